@@ -1,457 +1,7929 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"embed"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
-	"html"
+	"io"
+	"log/slog"
+	"math"
+	mathrand "math/rand"
+	"mime"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	pathpkg "path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf16"
+
+	"github.com/naratornb/tools-canvas-quiz-extractor/quizextract"
+	"golang.org/x/text/unicode/norm"
 )
 
-type QuizChoice struct {
-	ItemBody string `json:"item_body"`
-	ID       string `json:"id"`
-	Position int    `json:"position"`
+// Submission is the Canvas submission object (Submissions API with include[]=submission_comments),
+// supplied with -submission for submission-level instructor comments and the attempt details.
+type Submission struct {
+	Comments    json.RawMessage `json:"submission_comments"`
+	Attempt     int             `json:"attempt"`
+	SubmittedAt string          `json:"submitted_at"`
+	Score       *float64        `json:"score"` // for -verify-score
 }
 
-type QuizBlank struct {
-	AnswerType string `json:"answer_type"`
-	ID         string `json:"id"`
+func mustReadJSON[T any](path string, v *T) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
 }
 
-type InteractionData struct {
-	Blanks        []QuizBlank     `json:"blanks"`
-	Choices       []QuizChoice    // normalized slice after unmarshal
-	TrueChoice    string          `json:"true_choice"`
-	FalseChoice   string          `json:"false_choice"`
-	ShuffledOrder []string        `json:"shuffled_order"`
-	RawChoices    json.RawMessage `json:"choices"` // holds raw map/array for secondary parse
-}
+// redactedText replaces the matches of -redact-pattern.
+const redactedText = "[redacted]"
 
-type QuizItemInner struct {
-	InteractionData  InteractionData `json:"interaction_data"`
-	ItemBody         string          `json:"item_body"`
-	UserResponseType string          `json:"user_response_type"`
-	Title            string          `json:"title"`
-	ID               string          `json:"id"`
-	InteractionType  struct {
-		Name string `json:"name"`
-		Slug string `json:"slug"`
-		ID   string `json:"id"`
-	} `json:"interaction_type"`
+// textRule replaces the matches of Pattern with Replacement, which may refer to capture
+// groups as $1 or ${name}.
+type textRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+	Matches     int // pieces of text the rule changed
 }
 
-type QuizItem struct {
-	CalculatorType string        `json:"calculator_type"`
-	Item           QuizItemInner `json:"item"`
-	PointsPossible float64       `json:"points_possible"`
-	Position       int           `json:"position"`
-	QuestionNumber int           `json:"question_number"`
-}
+// textRules are the -redact-pattern and -replace rules, in command-line order.
+type textRules []textRule
 
-type ResultValueEntry struct {
-	ResultScore   *int   `json:"result_score,omitempty"`
-	UserResponded *bool  `json:"user_responded,omitempty"`
-	Correct       *bool  `json:"correct,omitempty"`
-	UserResponse  string `json:"user_response,omitempty"`
-	CorrectAnswer string `json:"correct_answer,omitempty"`
+// apply runs every rule over s in order, counting matches.
+func (rules textRules) apply(s string) string {
+	for i := range rules {
+		if rules[i].Pattern.MatchString(s) {
+			rules[i].Matches++
+			s = rules[i].Pattern.ReplaceAllString(s, rules[i].Replacement)
+		}
+	}
+	return s
 }
 
-type ScoredData struct {
-	Correct  bool            `json:"correct"`
-	ValueRaw json.RawMessage `json:"value"`
+// ruleFlag is a repeatable flag adding to rules: -redact-pattern takes a regex and
+// -replace takes "regex=>text".
+type ruleFlag struct {
+	rules  *textRules
+	redact bool
 }
 
-type ResultItem struct {
-	ItemID   string     `json:"item_id"`
-	Position int        `json:"position"`
-	Score    float64    `json:"score"`
-	Scored   ScoredData `json:"scored_data"`
-}
+func (f ruleFlag) String() string { return "" }
 
-func mustReadJSON[T any](path string, v *T) error {
-	b, err := os.ReadFile(path)
+func (f ruleFlag) Set(v string) error {
+	pattern, repl := v, redactedText
+	if !f.redact {
+		var ok bool
+		if pattern, repl, ok = strings.Cut(v, "=>"); !ok {
+			return errors.New(`want "regex=>text"`)
+		}
+	}
+	re, err := regexp.Compile(pattern)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(b, v)
+	*f.rules = append(*f.rules, textRule{Pattern: re, Replacement: repl})
+	return nil
 }
 
-// annotateBlanks replaces runs of underscores (___) with labeled placeholders [Blank i].
-// If the number of detected placeholders is fewer than provided blanks, it will still
-// label what it finds and rely on the list summary for remaining blanks.
-func annotateBlanks(question string, blanksCount int) string {
-	if blanksCount <= 0 || question == "" {
-		return question
-	}
-	re := regexp.MustCompile(`_{3,}`)
-	i := 0
-	annotated := re.ReplaceAllStringFunc(question, func(_ string) string {
-		i++
-		return fmt.Sprintf("[Blank %d]", i)
-	})
-	// If we couldn't find any obvious placeholders and there's exactly one blank,
-	// place it before the final punctuation (., ?, !) as a reasonable default.
-	if i == 0 && blanksCount == 1 {
-		tail := regexp.MustCompile(`([\.!\?])$`)
-		if tail.MatchString(annotated) {
-			annotated = tail.ReplaceAllString(annotated, " [Blank 1]$1")
-		} else {
-			annotated = annotated + " [Blank 1]"
-		}
+// choiceOrders are the values accepted by -choice-order.
+var choiceOrders = []string{"shuffled", "canonical"}
+
+// useCanonicalOrder drops the recorded shuffles so every item lists its choices in authored order.
+func useCanonicalOrder(quiz []quizextract.QuizItem) {
+	for i := range quiz {
+		quiz[i].Item.InteractionData.ShuffledOrder = nil
 	}
-	return annotated
 }
 
-// annotateBlanksFromHTML replaces explicit blank spans in HTML (e.g., <span id="blank_..."></span>)
-// with [Blank i] markers, then strips HTML to plain text.
-func annotateBlanksFromHTML(htmlQuestion string, blanks []QuizBlank) string {
-	if strings.TrimSpace(htmlQuestion) == "" {
-		return ""
+// readGlossaryTerms reads a JSON object mapping terms to definitions (which may be empty).
+func readGlossaryTerms(path string) (map[string]string, error) {
+	terms := map[string]string{}
+	if err := mustReadJSON(path, &terms); err != nil {
+		return nil, err
 	}
-	// Replace <span id="blank_..."></span> with [Blank i]
-	reSpan := regexp.MustCompile(`(?i)<span[^>]*id=\"blank_[^\"]*\"[^>]*></span>`) // greedy span with id starting blank_
-	i := 0
-	replaced := reSpan.ReplaceAllStringFunc(htmlQuestion, func(_ string) string {
-		i++
-		return fmt.Sprintf("[Blank %d]", i)
-	})
-	if i > 0 {
-		// Now strip remaining HTML
-		return stripHTML(replaced)
+	return terms, nil
+}
+
+// readLabels reads a -lang-file: a JSON object mapping English labels to translations.
+func readLabels(path string) (quizextract.Labels, error) {
+	labels := quizextract.Labels{}
+	if err := mustReadJSON(path, &labels); err != nil {
+		return nil, err
 	}
-	// Fallback to generic underscore-based annotation on stripped text
-	return annotateBlanks(stripHTML(htmlQuestion), len(blanks))
+	return labels, nil
 }
 
-// stripHTML does a simple tag stripper and entity unescape for short HTML fragments.
-func stripHTML(s string) string {
-	var b strings.Builder
-	inTag := false
-	for _, r := range s {
-		if r == '<' {
-			inTag = true
+// labelPattern is a named filename convention. Its "label" capture group names the document
+// (title and output file); an optional "title" group replaces the whole heading.
+type labelPattern struct {
+	Name string
+	Re   *regexp.Regexp
+}
+
+// builtinLabelPatterns are tried in order by default; -label-patterns selects and reorders them.
+var builtinLabelPatterns = []labelPattern{
+	{"wk", regexp.MustCompile(`(?i)^(?P<label>wk\d{2})`)},
+	{"week", regexp.MustCompile(`(?i)^(?P<label>week[-_ ]?\d+)`)},
+	{"quiz", regexp.MustCompile(`(?i)^(?P<label>quiz[-_ ]?\d+)`)},
+	{"module", regexp.MustCompile(`(?i)^(?P<label>module[-_ ]?\d+)`)},
+	{"exam", regexp.MustCompile(`(?i)^(?P<label>midterm|final)`)},
+}
+
+// selectLabelPatterns resolves a comma-separated list of built-in pattern names.
+func selectLabelPatterns(names string) ([]labelPattern, error) {
+	if strings.TrimSpace(names) == "" {
+		return nil, nil
+	}
+	var out []labelPattern
+	for _, n := range strings.Split(names, ",") {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n == "" {
 			continue
 		}
-		if r == '>' {
-			inTag = false
-			continue
+		found := false
+		for _, p := range builtinLabelPatterns {
+			if p.Name == n {
+				out = append(out, p)
+				found = true
+			}
 		}
-		if !inTag {
-			b.WriteRune(r)
+		if !found {
+			var known []string
+			for _, p := range builtinLabelPatterns {
+				known = append(known, p.Name)
+			}
+			return nil, fmt.Errorf("unknown label pattern %q (want %s)", n, strings.Join(known, ", "))
 		}
 	}
-	out := b.String()
-	out = html.UnescapeString(out)
-	out = strings.ReplaceAll(out, "\r", "")
-	out = strings.ReplaceAll(out, "\n", " ")
-	out = strings.TrimSpace(out)
-	out = strings.Join(strings.Fields(out), " ")
-	return out
+	return out, nil
 }
 
-// deriveCorrectChoiceIDs returns ids deemed correct from heterogeneous scored value structures.
-func deriveCorrectChoiceIDs(res ResultItem) map[string]bool {
-	ids := map[string]bool{}
-	if len(res.Scored.ValueRaw) == 0 || string(res.Scored.ValueRaw) == "null" {
-		return ids
+// compileLabelRegex turns a user-supplied -label-regex into a pattern. The regex should
+// capture a "label" and/or "title" group; otherwise the first group (or whole match) is used.
+func compileLabelRegex(expr string) (labelPattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return labelPattern{}, fmt.Errorf("invalid -label-regex: %v", err)
 	}
-	// Try map form first
-	var mapForm map[string]ResultValueEntry
-	if err := json.Unmarshal(res.Scored.ValueRaw, &mapForm); err == nil && len(mapForm) > 0 {
-		for id, entry := range mapForm {
-			if entry.ResultScore != nil && *entry.ResultScore == 1 {
-				ids[id] = true
+	return labelPattern{Name: "custom", Re: re}, nil
+}
+
+// detectLabel matches a file's base name (extension dropped) against patterns in order.
+func detectLabel(path string, patterns []labelPattern) quizextract.FileLabel {
+	// macOS stores file names decomposed (NFD); compose them so "é" matches a pattern's "é".
+	base := norm.NFC.String(filepath.Base(path))
+	return matchLabel(strings.TrimSuffix(base, filepath.Ext(base)), patterns)
+}
+
+// matchLabel returns the label from the first pattern that matches name.
+func matchLabel(name string, patterns []labelPattern) quizextract.FileLabel {
+	for _, p := range patterns {
+		m := p.Re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		var fl quizextract.FileLabel
+		for i, group := range p.Re.SubexpNames() {
+			switch group {
+			case "label":
+				fl.Label = strings.ToUpper(strings.TrimSpace(m[i]))
+			case "title":
+				fl.Title = strings.TrimSpace(m[i])
 			}
-			if entry.Correct != nil && *entry.Correct {
-				ids[id] = true
+		}
+		if fl.Label == "" && fl.Title == "" {
+			fl.Label = strings.ToUpper(strings.TrimSpace(m[0]))
+			if len(m) > 1 {
+				fl.Label = strings.ToUpper(strings.TrimSpace(m[1]))
 			}
 		}
-		return ids
+		if fl.Label == "" && fl.Title == "" {
+			// An empty match (e.g. a custom `^x?`) names nothing; let later patterns try.
+			continue
+		}
+		fl.Slug = slugify(fl.Label)
+		if fl.Slug == "" {
+			fl.Slug = slugify(fl.Title)
+		}
+		return fl
 	}
-	// Try ordering / array form
-	var arrayForm []struct {
-		ID            any    `json:"id"`
-		UserResponded string `json:"user_responded"`
-		ResultScore   int    `json:"result_score"`
-		Value         string `json:"value"`
+	return quizextract.FileLabel{}
+}
+
+// resolveLabel picks the quiz label according to -label-from: the quiz filename, the quiz
+// title from -quiz-meta, or the literal -label value.
+func resolveLabel(from, quizPath, quizTitle, flagLabel string, patterns []labelPattern) (quizextract.FileLabel, error) {
+	switch from {
+	case "", "filename":
+		return detectLabel(quizPath, patterns), nil
+	case "title":
+		if strings.TrimSpace(quizTitle) == "" {
+			return quizextract.FileLabel{}, fmt.Errorf("-label-from title needs a -quiz-meta file with a title")
+		}
+		if fl := matchLabel(quizTitle, patterns); fl != (quizextract.FileLabel{}) {
+			return fl, nil
+		}
+		// No convention matched: the whole title names the quiz.
+		title := strings.TrimSpace(quizTitle)
+		return quizextract.FileLabel{Title: title, Slug: slugify(title)}, nil
+	case "flag":
+		if strings.TrimSpace(flagLabel) == "" {
+			return quizextract.FileLabel{}, fmt.Errorf("-label-from flag needs -label")
+		}
+		l := strings.TrimSpace(flagLabel)
+		return quizextract.FileLabel{Label: l, Slug: slugify(l)}, nil
 	}
-	if err := json.Unmarshal(res.Scored.ValueRaw, &arrayForm); err == nil {
-		for _, row := range arrayForm {
-			if row.ResultScore == 1 {
-				// For ordering questions, value is the correct choice id.
-				if row.Value != "" {
-					ids[row.Value] = true
-				}
-			}
+	return quizextract.FileLabel{}, fmt.Errorf("unknown -label-from %q (want canvas, title, filename or flag)", from)
+}
+
+// canvasLabel labels a quiz fetched with -canvas-url after its Canvas module and title: the
+// heading is "<module> — <title>" (e.g. "Module 5: Routing — Quiz 5"), and the label, used
+// for file names, is the module's entry in moduleLabels, or else the first convention
+// matched by the module name, then by the title.
+func canvasLabel(module, title string, moduleLabels map[string]string, patterns []labelPattern) quizextract.FileLabel {
+	module, title = strings.TrimSpace(module), strings.TrimSpace(title)
+	fl := quizextract.FileLabel{Title: title}
+	switch {
+	case module != "" && title != "":
+		fl.Title = module + " — " + title
+	case module != "":
+		fl.Title = module
+	}
+	if l := strings.TrimSpace(moduleLabels[module]); l != "" && module != "" {
+		fl.Label = l
+	} else if m := matchLabel(module, patterns); m.Label != "" {
+		fl.Label = m.Label
+	} else if m := matchLabel(title, patterns); m.Label != "" {
+		fl.Label = m.Label
+	}
+	fl.Slug = slugify(fl.Label)
+	if fl.Slug == "" {
+		fl.Slug = slugify(fl.Title)
+	}
+	return fl
+}
+
+// moduleSequence is the part of Canvas's module item sequence response that names the
+// module an assignment is in.
+type moduleSequence struct {
+	Items []struct {
+		Current struct {
+			ModuleID any `json:"module_id"`
+		} `json:"current"`
+	} `json:"items"`
+	Modules []struct {
+		ID   any    `json:"id"`
+		Name string `json:"name"`
+	} `json:"modules"`
+}
+
+// module returns the name of the module holding the assignment, or "" if it is in none.
+func (s moduleSequence) module() string {
+	if len(s.Items) == 0 {
+		return ""
+	}
+	id := quizextract.ClassicID(s.Items[0].Current.ModuleID)
+	for _, m := range s.Modules {
+		if quizextract.ClassicID(m.ID) == id {
+			return m.Name
 		}
 	}
-	return ids
+	return ""
 }
 
-// normalizeChoices ensures InteractionData.Choices is populated from various Canvas encodings.
-func (idat *InteractionData) normalizeChoices(userRespType, interactionSlug string) {
-	if len(idat.Choices) > 0 { // already standard array
-		return
+// docTitle derives the document heading ("<label> Quiz — <subtitle>") from the quiz label,
+// falling back to the output filename. A heading template, from -heading, replaces the
+// default layout; its {label} is the label, or the title a pattern captured. "Quiz" and the
+// subtitle are translated with labels, as the document's other labels are.
+func docTitle(label quizextract.FileLabel, outPath string, patterns []labelPattern, subtitle, heading string, labels quizextract.Labels) string {
+	subtitle = labels.Translate(subtitle)
+	if label.Label == "" && label.Title == "" {
+		// attempt fallback: read from output filename
+		label = detectLabel(outPath, patterns)
 	}
-	// Boolean true/false
-	if strings.EqualFold(userRespType, "Boolean") || interactionSlug == "true-false" {
-		trueLabel := idat.TrueChoice
-		falseLabel := idat.FalseChoice
-		if trueLabel == "" {
-			trueLabel = "True"
+	if heading != "" {
+		name := label.Title
+		if name == "" {
+			name = label.Label
 		}
-		if falseLabel == "" {
-			falseLabel = "False"
+		if name == "" {
+			name = "WK"
 		}
-		idat.Choices = []QuizChoice{{ItemBody: trueLabel, ID: "true", Position: 1}, {ItemBody: falseLabel, ID: "false", Position: 2}}
-		return
+		return strings.NewReplacer("{label}", name, "{subtitle}", subtitle).Replace(heading)
 	}
-	if len(idat.RawChoices) == 0 {
-		return
+	if label.Title != "" {
+		return fmt.Sprintf("%s — %s", label.Title, subtitle)
 	}
-	// Attempt map form
-	var mapChoices map[string]struct {
-		ItemBody string `json:"item_body"`
-		ID       string `json:"id"`
-	}
-	if err := json.Unmarshal(idat.RawChoices, &mapChoices); err == nil && len(mapChoices) > 0 {
-		order := idat.ShuffledOrder
-		pos := 1
-		if len(order) > 0 {
-			for _, cid := range order {
-				if mc, ok := mapChoices[cid]; ok {
-					label := mc.ItemBody
-					if label == "" {
-						label = mapChoices[cid].ItemBody
-					}
-					idat.Choices = append(idat.Choices, QuizChoice{ItemBody: label, ID: cid, Position: pos})
-					pos++
-				}
-			}
+	if label.Label != "" {
+		return fmt.Sprintf("%s %s — %s", label.Label, labels.Translate("Quiz"), subtitle)
+	}
+	return fmt.Sprintf("WK %s — %s", labels.Translate("Quiz"), subtitle)
+}
+
+// explanationSources lists the valid -explain entries.
+var explanationSources = []string{"general", "correct", "incorrect", "notes", "llm"}
+
+// defaultExplain is the default -explain: the item's own feedback, the incorrect-answer
+// feedback first for a question the student missed, as Canvas shows it.
+const defaultExplain = "incorrect,general,correct"
+
+// parseExplainSources splits and validates the -explain flag value.
+func parseExplainSources(v string) ([]string, error) {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
 		}
-		// Fallback add remaining not in order
-		if len(idat.Choices) == 0 {
-			for _, mc := range mapChoices {
-				idVal := mc.ID
-				if idVal == "" {
-					// use key? we don't have key variable here; skip
-					continue
-				}
-				idat.Choices = append(idat.Choices, QuizChoice{ItemBody: mc.ItemBody, ID: idVal, Position: pos})
-				pos++
+		valid := false
+		for _, s := range explanationSources {
+			if part == s {
+				valid = true
 			}
 		}
-		return
+		if !valid {
+			return nil, fmt.Errorf("unknown explanation source %q (want %s)", part, strings.Join(explanationSources, ", "))
+		}
+		out = append(out, part)
+	}
+	return out, nil
+}
+
+// shellLLM returns an LLM that pipes each prompt into command (run by sh -c) and returns
+// its trimmed output, or nil when command is empty. The command's stderr goes to ours.
+func shellLLM(command string) quizextract.LLM {
+	if command == "" {
+		return nil
+	}
+	return func(prompt string) (string, error) {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin = strings.NewReader(prompt)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+}
+
+// readNotes loads a notes file: a JSON object mapping item ids or question numbers to text.
+func readNotes(path string) (map[string]string, error) {
+	notes := map[string]string{}
+	if err := mustReadJSON(path, &notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// readQuizStatistics reads the response of GET .../quizzes/:id/statistics.
+func readQuizStatistics(path string) ([]quizextract.QuestionStatistics, error) {
+	var payload struct {
+		QuizStatistics []struct {
+			QuestionStatistics []quizextract.QuestionStatistics `json:"question_statistics"`
+		} `json:"quiz_statistics"`
 	}
-	// Attempt array form (already attempted earlier but ensure we decode if RawChoices contains array shape differing from struct tag)
-	var arr []QuizChoice
-	if err := json.Unmarshal(idat.RawChoices, &arr); err == nil && len(arr) > 0 {
-		idat.Choices = arr
+	if err := mustReadJSON(path, &payload); err != nil {
+		return nil, err
 	}
+	if len(payload.QuizStatistics) == 0 {
+		return nil, errors.New("no quiz_statistics in payload")
+	}
+	return payload.QuizStatistics[0].QuestionStatistics, nil
+}
+
+func readSubmissionEvents(path string) ([]quizextract.SubmissionEvent, error) {
+	var payload struct {
+		Events []quizextract.SubmissionEvent `json:"quiz_submission_events"`
+	}
+	if err := mustReadJSON(path, &payload); err != nil {
+		return nil, err
+	}
+	return payload.Events, nil
+}
+
+// readTags reads a JSON object mapping item ids or question numbers to topic tags.
+func readTags(path string) (map[string][]string, error) {
+	tags := map[string][]string{}
+	if err := mustReadJSON(path, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
 }
 
-func findResultByID(results []ResultItem, id string) (ResultItem, error) {
-	for _, r := range results {
-		if r.ItemID == id {
-			return r, nil
+// splitModes are the -split-by values.
+var splitModes = []string{"tag", "type"}
+
+// splitPath suffixes the file name of outPath with the part key, e.g.
+// wk12_quiz_solutions.md -> wk12_quiz_solutions_dynamic-programming.md.
+func splitPath(outPath, key string) string {
+	ext := pathpkg.Ext(outPath)
+	return strings.TrimSuffix(outPath, ext) + "_" + slugify(key) + ext
+}
+
+// dumpStages writes the parsing pipeline's intermediate models for one quiz as JSON files in
+// dir, named after prefix: the decoded payloads (1-decoded), the quiz items after
+// NormalizeChoices (2-normalized; New Quizzes only) and the derived document before any
+// filtering or enrichment (3-derived).
+func dumpStages(dir, prefix string, decoded any, quiz []quizextract.QuizItem, doc quizextract.QuizDoc) ([]string, error) {
+	stages := []struct {
+		name string
+		v    any
+	}{{"1-decoded", decoded}}
+	if quiz != nil {
+		normalized := make([]quizextract.QuizItem, len(quiz))
+		for i, q := range quiz {
+			q.Item.InteractionData.Choices = append([]quizextract.QuizChoice(nil), q.Item.InteractionData.Choices...)
+			q.Item.InteractionData.NormalizeChoices(q.Item.UserResponseType, q.Item.InteractionType.Slug)
+			normalized[i] = q
+		}
+		stages = append(stages, struct {
+			name string
+			v    any
+		}{"2-normalized", normalized})
+	}
+	stages = append(stages, struct {
+		name string
+		v    any
+	}{"3-derived", doc})
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, st := range stages {
+		b, err := json.MarshalIndent(st.v, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", st.name, err)
+		}
+		path := filepath.Join(dir, prefix+"."+st.name+".json")
+		if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+			return nil, err
 		}
+		paths = append(paths, path)
 	}
-	return ResultItem{}, errors.New("result not found for item_id=" + id)
+	return paths, nil
 }
 
-func writeMarkdown(outPath string, quiz []QuizItem, results []ResultItem, weekLabel string) error {
-	var sb strings.Builder
-	// Derive a nicer week-specific header if possible (e.g. wk03 -> WK03)
-	cleanWeek := strings.TrimSpace(weekLabel)
-	if cleanWeek == "" {
-		// attempt fallback: read from output filename
-		base := filepath.Base(outPath)
-		name := strings.TrimSuffix(base, filepath.Ext(base))
-		// match wk followed by digits
-		re := regexp.MustCompile(`(?i)^(wk\d{2})`)
-		if m := re.FindStringSubmatch(name); len(m) > 1 {
-			cleanWeek = strings.ToUpper(m[1])
+// unansweredDetail is the "Unanswered: 2 of 10 questions" summary line; ok is false when
+// every question was answered.
+func unansweredDetail(questions []quizextract.Question) (d quizextract.DocDetail, ok bool) {
+	n := 0
+	for _, q := range questions {
+		if q.Unanswered {
+			n++
 		}
 	}
-	if cleanWeek != "" {
-		sb.WriteString(fmt.Sprintf("# %s Quiz — Questions and Solutions\n\n", strings.ToUpper(cleanWeek)))
-	} else {
-		sb.WriteString("# WK Quiz — Questions and Solutions\n\n")
+	if n == 0 {
+		return quizextract.DocDetail{}, false
 	}
+	return quizextract.DocDetail{Label: "Unanswered", Value: fmt.Sprintf("%d of %d questions", n, len(questions))}, true
+}
 
-	sorted := make([]QuizItem, len(quiz))
-	copy(sorted, quiz)
-	sort.SliceStable(sorted, func(i, j int) bool {
-		if sorted[i].Position != sorted[j].Position {
-			return sorted[i].Position < sorted[j].Position
+// checkScore runs -verify-score on doc, before any question is filtered out: against the
+// results items unless merged is set (Classic Quizzes or merged attempts, whose questions
+// don't come from one results file), the score of the submission or of the last Classic
+// Quizzes attempt, and the quiz's points possible.
+func checkScore(doc quizextract.QuizDoc, results []quizextract.ResultItem, sub Submission, meta quizextract.QuizMeta, classicSubs []quizextract.ClassicSubmission, merged bool) {
+	var reported quizextract.ReportedScore
+	reported.Score, reported.Possible = sub.Score, meta.Points
+	if last := len(classicSubs) - 1; last >= 0 {
+		if reported.Score == nil {
+			reported.Score = classicSubs[last].Score
 		}
-		if sorted[i].QuestionNumber != sorted[j].QuestionNumber {
-			return sorted[i].QuestionNumber < sorted[j].QuestionNumber
+		if p := classicSubs[last].PointsPossible; reported.Possible == nil && p > 0 {
+			reported.Possible = &p
 		}
-		return i < j
-	})
+	}
+	if merged {
+		results = nil
+	}
+	c := quizextract.VerifyScore(doc, results, reported)
+	if c.Scored == 0 {
+		warnf("score check: no question has a score to add up")
+		return
+	}
+	for _, m := range c.Mismatches {
+		warnf("score check: %s", m)
+	}
+	if len(c.Mismatches) == 0 {
+		fmt.Printf("Score check: %s / %s from %d question(s) agrees with every total\n", quizextract.FormatPoints(quizextract.RoundTo(c.Earned, 2)), quizextract.FormatPoints(quizextract.RoundTo(c.Possible, 2)), c.Scored)
+	}
+}
 
-	for idx, q := range sorted {
-		// Prefer HTML-aware blank annotation for open entry questions
-		rawQuestion := stripHTML(q.Item.ItemBody)
-		isBlank := len(q.Item.InteractionData.Blanks) > 0
-		questionText := rawQuestion
-		if isBlank {
-			questionText = annotateBlanksFromHTML(q.Item.ItemBody, q.Item.InteractionData.Blanks)
-		}
-		num := idx + 1
-		sb.WriteString(fmt.Sprintf("## %d) %s\n", num, questionText))
-
-		res, err := findResultByID(results, q.Item.ID)
-		if err != nil {
-			sb.WriteString("- Options: (no result data)\n\n")
-			continue
-		}
-
-		// Normalize choices given heterogeneous encodings
-		q.Item.InteractionData.normalizeChoices(q.Item.UserResponseType, q.Item.InteractionType.Slug)
-		choices := q.Item.InteractionData.Choices
-
-		if isBlank {
-			sb.WriteString("- Options: N/A (open entry)\n\n")
-			// Extract answers for each blank and report with positions
-			var mapForm map[string]ResultValueEntry
-			if len(res.Scored.ValueRaw) > 0 {
-				_ = json.Unmarshal(res.Scored.ValueRaw, &mapForm)
-			}
-			sb.WriteString("- Blanks and answers:\n")
-			for i, b := range q.Item.InteractionData.Blanks {
-				label := fmt.Sprintf("Blank %d", i+1)
-				ans := ""
-				if mapForm != nil {
-					if v, ok := mapForm[b.ID]; ok {
-						if v.CorrectAnswer != "" {
-							ans = v.CorrectAnswer
-						} else if v.UserResponse != "" {
-							ans = v.UserResponse
-						}
-					}
-				}
-				if ans == "" {
-					ans = "(answer unavailable)"
-				}
-				sb.WriteString(fmt.Sprintf("  - %s: %s\n", label, stripHTML(ans)))
-			}
-			sb.WriteString("\n")
+// scoreDetail is -show-responses' "Score: 7.67 / 10 (7 of 10 questions correct)" summary
+// line; ok is false when no question has a score.
+func scoreDetail(questions []quizextract.Question) (d quizextract.DocDetail, ok bool) {
+	var earned, possible float64
+	scored, correct := 0, 0
+	for _, q := range questions {
+		c, ok := q.CheckResponse()
+		if !ok {
 			continue
 		}
+		scored++
+		earned += *q.Earned
+		possible += q.Possible
+		if c.Correct {
+			correct++
+		}
+	}
+	if scored == 0 {
+		return quizextract.DocDetail{}, false
+	}
+	value := fmt.Sprintf("%s / %s (%d of %d questions correct)", quizextract.FormatPoints(quizextract.RoundTo(earned, 2)), quizextract.FormatPoints(quizextract.RoundTo(possible, 2)), correct, scored)
+	return quizextract.DocDetail{Label: "Score", Value: value}, true
+}
 
-		correctIDs := deriveCorrectChoiceIDs(res)
-		if len(choices) > 0 {
-			sb.WriteString("- Options:\n")
-			sort.SliceStable(choices, func(i, j int) bool { return choices[i].Position < choices[j].Position })
-			for _, c := range choices {
-				label := stripHTML(c.ItemBody)
-				if correctIDs[c.ID] {
-					sb.WriteString(fmt.Sprintf("  - %s (correct)\n", label))
-				} else {
-					sb.WriteString(fmt.Sprintf("  - %s\n", label))
-				}
-			}
-			sb.WriteString("\n")
+// responseFilters are the values accepted by -only.
+var responseFilters = []string{"unanswered", "incorrect"}
+
+// parseQuestionNumbers reads a -questions list such as "3,7,12-15" into question numbers.
+func parseQuestionNumbers(spec string) ([]int, error) {
+	var numbers []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(strings.TrimSpace(first))
+		hi := lo
+		if err == nil && isRange {
+			hi, err = strconv.Atoi(strings.TrimSpace(last))
 		}
+		switch {
+		case err != nil || lo < 1:
+			return nil, fmt.Errorf("bad -questions entry %q (want a question number like 3 or a range like 12-15)", part)
+		case hi < lo:
+			return nil, fmt.Errorf("bad -questions range %q: %d comes after %d", part, lo, hi)
+		case hi-lo >= 10000:
+			return nil, fmt.Errorf("-questions range %q is too long", part)
+		}
+		for n := lo; n <= hi; n++ {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers, nil
+}
 
-		var correctLabels []string
-		for _, c := range choices {
-			if correctIDs[c.ID] {
-				correctLabels = append(correctLabels, stripHTML(c.ItemBody))
-			}
+// difficultyLevels are the values accepted by bank -difficulty, easiest first.
+var difficultyLevels = []string{"easy", "medium", "hard"}
+
+// outputVersions lists the Markdown layouts -output-version accepts; the last is the default.
+// Keep at least one prior layout so scripts that parse the output can pin it while the
+// default evolves, and record each change in the README's "Output versions" section.
+var outputVersions = []int{1, 2}
+
+// readInput reads a JSON input file or https:// URL (see fetchInput) and returns it with its
+// name. A .zip is searched for the one .json entry whose payload has the wanted shape ("quiz"
+// or "results"; see PayloadShape), so captures shared as a zip can be used without unpacking
+// them first; the name is then the entry's, which labels follow. A quiz may also be a QTI
+// package, zipped or a single .xml file, which comes back as a Classic Quizzes export, and
+// either payload may come from a browser's HAR capture (see readHAR).
+func readInput(path, want, token string) ([]byte, string, error) {
+	name := path
+	var b []byte
+	var err error
+	if path == stdio {
+		name = "stdin"
+		b, err = readStdin()
+	} else if isURL(path) {
+		u, perr := url.Parse(path)
+		if perr != nil {
+			return nil, "", perr
+		}
+		name = pathpkg.Base(u.Path)
+		b, err = fetchInput(path, token)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.EqualFold(filepath.Ext(name), ".har") {
+		return readHAR(b, name, want)
+	}
+	if want == "quiz" && qtiExts[strings.ToLower(filepath.Ext(name))] {
+		return qtiInput(map[string][]byte{name: b}, name)
+	}
+	if !strings.EqualFold(filepath.Ext(name), ".zip") {
+		return recoverInput(b, name)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, "", err
+	}
+	var matches []string
+	var data []byte
+	qti := map[string][]byte{}
+	for _, f := range zr.File {
+		base := filepath.Base(f.Name)
+		ext := strings.ToLower(filepath.Ext(base))
+		isQTI := want == "quiz" && qtiExts[ext]
+		if f.FileInfo().IsDir() || !jsonExts[ext] && !isQTI || strings.HasPrefix(base, "._") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %v", f.Name, err)
 		}
+		b, err := io.ReadAll(io.LimitReader(rc, zipEntryMax+1))
+		rc.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if len(b) > zipEntryMax {
+			return nil, "", fmt.Errorf("%s: entry is larger than %d MB", f.Name, zipEntryMax>>20)
+		}
+		if isQTI {
+			qti[f.Name] = b
+		} else if fixed, _, err := recoverJSON(b); err == nil && quizextract.PayloadShape(fixed) == want {
+			matches = append(matches, f.Name)
+			data = b
+		}
+	}
+	switch {
+	case len(matches) == 0 && len(qti) > 0:
+		return qtiInput(qti, name)
+	case len(matches) == 0:
+		return nil, "", fmt.Errorf("no %s payload among the JSON entries of %s", want, path)
+	case len(matches) == 1:
+		return recoverInput(data, matches[0])
+	}
+	return nil, "", fmt.Errorf("%s holds %d %s payloads (%s); extract the one you want", path, len(matches), want, strings.Join(matches, ", "))
+}
+
+// zipEntryMax caps how much of one zip entry readInput unpacks, so a small archive can't
+// expand into more than memory holds. It matches the serve upload limit.
+const zipEntryMax = serveMaxUpload
+
+// jsonExts are the zip entry extensions readInput considers.
+var jsonExts = map[string]bool{".json": true, ".ndjson": true, ".jsonl": true}
+
+// qtiExts are the extensions of QTI files; Canvas course exports name them .xml.qti.
+var qtiExts = map[string]bool{".xml": true, ".qti": true}
+
+// qtiInput converts the XML files of a QTI package into a Classic Quizzes export, which the
+// rest of the tool already reads; name labels it.
+func qtiInput(files map[string][]byte, name string) ([]byte, string, error) {
+	qs, err := quizextract.ParseQTI(files)
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := json.Marshal(map[string][]quizextract.ClassicQuestion{"quiz_submission_questions": qs})
+	return b, name, err
+}
+
+// harFile is the part of a HAR capture (the network log browsers save with "Save all as
+// HAR") that readHAR needs.
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				URL string `json:"url"`
+			} `json:"request"`
+			Response struct {
+				Content struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"` // "base64" for binary-safe bodies
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
 
-		if strings.Contains(strings.ToLower(q.Item.UserResponseType), "multipleuuid") || len(correctLabels) > 1 {
-			sb.WriteString("- Correct answers:\n")
-			for _, l := range correctLabels {
-				sb.WriteString(fmt.Sprintf("  - %s\n", l))
+// readHAR finds the JSON response of a HAR capture whose payload has the wanted shape, the
+// way readInput searches a zip. Identical responses, such as a page loaded twice, count
+// once, and the pages of one paginated list (the same URL up to the query) are joined. The
+// name stays the capture's, so labels follow it.
+func readHAR(b []byte, name, want string) ([]byte, string, error) {
+	var har harFile
+	if err := json.Unmarshal(b, &har); err != nil {
+		return nil, "", fmt.Errorf("not a HAR file: %v", err)
+	}
+	var urls []string
+	var bodies [][]byte
+	seen := map[string]bool{}
+	for _, e := range har.Log.Entries {
+		c := e.Response.Content
+		if !strings.Contains(c.MimeType, "json") || c.Text == "" {
+			continue
+		}
+		body := []byte(c.Text)
+		if c.Encoding == "base64" {
+			var err error
+			if body, err = base64.StdEncoding.DecodeString(c.Text); err != nil {
+				continue
 			}
-			sb.WriteString("\n")
-		} else if len(correctLabels) == 1 {
-			sb.WriteString(fmt.Sprintf("- Answer: %s\n\n", correctLabels[0]))
-		} else {
-			sb.WriteString("- Answer: (answer unavailable)\n\n")
 		}
+		fixed, _, err := recoverJSON(body)
+		if err != nil || seen[string(fixed)] || quizextract.PayloadShape(fixed) != want {
+			continue
+		}
+		seen[string(fixed)] = true
+		urls = append(urls, e.Request.URL)
+		bodies = append(bodies, fixed)
+	}
+	if len(bodies) == 0 {
+		return nil, "", fmt.Errorf("no %s payload among the JSON responses of %s", want, name)
+	}
+	if len(bodies) > 1 {
+		joined, ok := joinPages(urls, bodies)
+		if !ok {
+			return nil, "", fmt.Errorf("%s holds %d %s responses (%s); save a capture of one quiz", name, len(bodies), want, strings.Join(urls, ", "))
+		}
+		fmt.Fprintf(os.Stderr, "note: %s: %s from %s (%d pages)\n", name, want, urls[0], len(bodies))
+		return joined, name, nil
 	}
+	fmt.Fprintf(os.Stderr, "note: %s: %s from %s\n", name, want, urls[0])
+	return bodies[0], name, nil
+}
 
-	if err := os.WriteFile(outPath, []byte(sb.String()), 0o644); err != nil {
-		return err
+// joinPages joins the JSON arrays of a paginated list into one; ok is false when the bodies
+// are not arrays or came from different URLs.
+func joinPages(urls []string, bodies [][]byte) (joined []byte, ok bool) {
+	var all [][]byte
+	for i, b := range bodies {
+		if strings.SplitN(urls[i], "?", 2)[0] != strings.SplitN(urls[0], "?", 2)[0] {
+			return nil, false
+		}
+		var page []json.RawMessage
+		if json.Unmarshal(b, &page) != nil {
+			return nil, false
+		}
+		for _, item := range page {
+			all = append(all, item)
+		}
 	}
-	return nil
+	return append(append([]byte("["), bytes.Join(all, []byte(","))...), ']'), true
 }
 
-func main() {
-	var (
-		quizPath   string
-		resultPath string
-		outPath    string
-	)
-	flag.StringVar(&quizPath, "in", "", "Path to quiz JSON (e.g., wk12.json). If empty, you'll be prompted.")
-	flag.StringVar(&resultPath, "results", "", "Path to results JSON (e.g., wk12_result.json). If empty, you'll be prompted.")
-	flag.StringVar(&outPath, "out", "", "Output Markdown file path. If empty, derived from the first 4 chars of quiz filename.")
-	flag.Parse()
+// bundledInput reports whether an -in path can hold the results as well as the quiz: a zip
+// of captures or a HAR capture.
+func bundledInput(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	return ext == ".zip" || ext == ".har"
+}
 
-	reader := bufio.NewReader(os.Stdin)
-	if strings.TrimSpace(quizPath) == "" {
-		fmt.Print("Enter quiz JSON path (e.g., wk12.json): ")
-		line, _ := reader.ReadString('\n')
-		quizPath = strings.TrimSpace(line)
+// recoverInput applies recoverJSON to an input and notes each repair on stderr.
+func recoverInput(b []byte, name string) ([]byte, string, error) {
+	b, fixes, err := recoverJSON(b)
+	if err != nil {
+		return nil, "", err
 	}
-	if strings.TrimSpace(resultPath) == "" {
-		fmt.Print("Enter results JSON path (e.g., wk12_result.json): ")
-		line, _ := reader.ReadString('\n')
-		resultPath = strings.TrimSpace(line)
+	for _, fix := range fixes {
+		fmt.Fprintf(os.Stderr, "note: %s: %s\n", name, fix)
 	}
+	return b, name, nil
+}
 
-	if strings.TrimSpace(outPath) == "" {
-		base := filepath.Base(quizPath)
-		name := strings.TrimSuffix(base, filepath.Ext(base))
-		r := []rune(name)
-		prefix := name
-		if len(r) >= 4 {
-			prefix = string(r[:4])
+// inputClient bounds input downloads.
+var inputClient = &http.Client{Timeout: 60 * time.Second}
+
+// isURL reports whether an input path is a URL rather than a file.
+func isURL(p string) bool {
+	return strings.HasPrefix(p, "https://") || strings.HasPrefix(p, "http://")
+}
+
+// fetchInput downloads an https:// input, sending token as a bearer token when set. Plain
+// http is refused so the token and quiz contents never travel unencrypted.
+func fetchInput(rawURL, token string) ([]byte, error) {
+	b, _, err := fetchPage(rawURL, token)
+	return b, err
+}
+
+// fetchPage is fetchInput that also returns the response headers, for pagination.
+func fetchPage(rawURL, token string) ([]byte, http.Header, error) {
+	if !strings.HasPrefix(rawURL, "https://") {
+		return nil, nil, errors.New("only https:// URLs are supported")
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := inputClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	slog.Info("fetched", "url", rawURL, "status", resp.StatusCode, "bytes", len(b))
+	return b, resp.Header, err
+}
+
+// canvasQuizURL is the New Quizzes API URL of a quiz, or of one of its collections when
+// parts are given (e.g. "items").
+func canvasQuizURL(canvasURL, courseID, quizID string, parts ...string) string {
+	u := strings.TrimSuffix(canvasURL, "/") + "/api/quiz/v1/courses/" + url.PathEscape(courseID) + "/quizzes/" + url.PathEscape(quizID)
+	for _, p := range parts {
+		u += "/" + url.PathEscape(p)
+	}
+	return u
+}
+
+// fetchCanvasList GETs a paginated Canvas API list, following the rel="next" URL of each
+// page's Link header, and joins the pages into one JSON array. A next URL on another host
+// than rawURL's is an error, so the token only ever goes to the -canvas-url host.
+func fetchCanvasList(rawURL, token string) ([]byte, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var all []json.RawMessage
+	seen := map[string]bool{}
+	for next := rawURL; next != "" && !seen[next]; {
+		seen[next] = true
+		b, header, err := fetchPage(next, token)
+		if err != nil {
+			return nil, err
 		}
-		outPath = filepath.Join(filepath.Dir(quizPath), fmt.Sprintf("%s_quiz_solutions.md", prefix))
+		if b, _, err = recoverJSON(b); err != nil {
+			return nil, fmt.Errorf("GET %s: %v", next, err)
+		}
+		var page []json.RawMessage
+		if err := json.Unmarshal(b, &page); err != nil {
+			return nil, fmt.Errorf("GET %s: expected a JSON array: %v", next, err)
+		}
+		all = append(all, page...)
+		link := nextLink(header.Get("Link"))
+		if link == "" {
+			break
+		}
+		u, err := base.Parse(link)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: bad next link %q: %v", next, link, err)
+		}
+		if !strings.EqualFold(u.Host, base.Host) {
+			return nil, fmt.Errorf("GET %s: the next page is on %s, not the Canvas host %s; not following it", next, u.Host, base.Host)
+		}
+		next = u.String()
 	}
+	if all == nil {
+		all = []json.RawMessage{}
+	}
+	return json.Marshal(all)
+}
 
-	qp, _ := filepath.Abs(quizPath)
-	rp, _ := filepath.Abs(resultPath)
-	op, _ := filepath.Abs(outPath)
+// fixture is a recorded HTTP response, as -record saves it and -replay serves it.
+type fixture struct {
+	Method string              `json:"method"`
+	URL    string              `json:"url"`
+	Status int                 `json:"status"`
+	Header map[string][]string `json:"header,omitempty"`
+	Body   string              `json:"body"`
+}
 
-	var quiz []QuizItem
-	if err := mustReadJSON(qp, &quiz); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to read quiz JSON %s: %v\n", qp, err)
-		os.Exit(1)
+// fixtureHeaders are the response headers a fixture keeps: enough to paginate and to tell
+// JSON from a zip, and nothing that identifies the session.
+var fixtureHeaders = []string{"Content-Type", "Link"}
+
+// fixtureTransport saves every response of the input client to dir (-record), or serves
+// them from dir without touching the network (-replay). Fixtures are named after a hash of
+// the method and URL; the Authorization header is never saved.
+type fixtureTransport struct {
+	dir    string
+	replay bool
+	next   http.RoundTripper // nil uses http.DefaultTransport
+}
+
+func (t fixtureTransport) path(req *http.Request) string {
+	key := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	return filepath.Join(t.dir, fmt.Sprintf("%x.json", key[:8]))
+}
+
+func (t fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.replay {
+		var f fixture
+		if err := mustReadJSON(t.path(req), &f); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("no recorded response in %s", t.dir)
+			}
+			return nil, err
+		}
+		resp := &http.Response{
+			Status: fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)), StatusCode: f.Status,
+			Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header: http.Header(f.Header), Body: io.NopCloser(strings.NewReader(f.Body)), Request: req,
+		}
+		if resp.Header == nil {
+			resp.Header = http.Header{}
+		}
+		return resp, nil
 	}
-	var results []ResultItem
-	if err := mustReadJSON(rp, &results); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to read result JSON %s: %v\n", rp, err)
-		os.Exit(1)
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
 	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	f := fixture{Method: req.Method, URL: req.URL.String(), Status: resp.StatusCode, Header: map[string][]string{}, Body: string(body)}
+	for _, h := range fixtureHeaders {
+		if v := resp.Header.Values(h); len(v) > 0 {
+			f.Header[h] = v
+		}
+	}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(t.dir, 0o755); err == nil {
+			err = os.WriteFile(t.path(req), append(b, '\n'), 0o644)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recording %s: %v", req.URL, err)
+	}
+	return resp, nil
+}
 
-	// Derive week label from quiz filename (e.g., wk12.json -> WK12)
-	weekLabel := ""
-	{
-		base := filepath.Base(qp)
-		name := strings.TrimSuffix(base, filepath.Ext(base))
-		re := regexp.MustCompile(`(?i)^(wk\d{2})`)
-		if m := re.FindStringSubmatch(name); len(m) > 1 {
-			weekLabel = strings.ToUpper(m[1])
+// useFixtures routes the input client through a fixtureTransport for -record or -replay.
+func useFixtures(record, replay string) error {
+	switch {
+	case record != "" && replay != "":
+		return errors.New("-record and -replay cannot be combined")
+	case record != "":
+		inputClient = &http.Client{Timeout: inputClient.Timeout, Transport: fixtureTransport{dir: record, next: inputClient.Transport}}
+	case replay != "":
+		if _, err := os.Stat(replay); err != nil {
+			return fmt.Errorf("-replay: %v", err)
 		}
+		inputClient = &http.Client{Timeout: inputClient.Timeout, Transport: fixtureTransport{dir: replay, replay: true}}
 	}
+	return nil
+}
+
+// lockWait is how long lockPath waits for another run to release a lock, and staleLock the
+// age past which a lock is taken to be left behind by a run that was killed.
+var (
+	lockWait  = 2 * time.Minute
+	staleLock = 10 * time.Minute
+)
+
+// lockPath takes an exclusive lock on path by creating path+".lock", so two runs sharing a
+// cache or output directory take turns instead of interleaving their writes. It waits while
+// another run holds the lock, and breaks a lock older than staleLock. The returned func
+// releases it. Locking is advisory: only runs of this tool honour it.
+//
+// A stale lock is broken by renaming it to a name of this run's own before creating a new
+// one. Rename is atomic, so of two runs breaking the same lock only one moves it; the other
+// either finds it gone or moves the fresh lock the winner just took, sees that it isn't
+// stale, and puts it back.
+func lockPath(path string) (unlock func(), err error) {
+	lock := path + ".lock"
+	owner := fmt.Sprintf("%d %d", os.Getpid(), time.Now().UnixNano())
+	deadline := time.Now().Add(lockWait)
+	waiting := false
+	for {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			fmt.Fprintln(f, owner)
+			f.Close()
+			return func() {
+				// A run that held the lock past staleLock may have lost it; leave the new holder's.
+				if b, err := os.ReadFile(lock); err == nil && strings.TrimSpace(string(b)) == owner {
+					os.Remove(lock)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > staleLock {
+			breakStaleLock(lock, owner)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another run; remove it if no other run is active", lock)
+		}
+		if !waiting {
+			b, _ := os.ReadFile(lock)
+			pid, _, _ := strings.Cut(strings.TrimSpace(string(b)), " ")
+			fmt.Fprintf(os.Stderr, "note: waiting for another run (pid %s) to release %s\n", pid, lock)
+			waiting = true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// breakStaleLock moves lock out of the way for lockPath. A lock that turns out to be fresh
+// once moved was taken by another run in the meantime, and is linked back unless yet another
+// run has already taken its place.
+func breakStaleLock(lock, owner string) {
+	moved := lock + ".stale-" + strings.ReplaceAll(owner, " ", "-")
+	if err := os.Rename(lock, moved); err != nil {
+		return // another run broke it first
+	}
+	defer os.Remove(moved)
+	if fi, err := os.Stat(moved); err == nil && time.Since(fi.ModTime()) <= staleLock {
+		_ = os.Link(moved, lock)
+		return
+	}
+	warnf("breaking stale lock %s", lock)
+}
+
+// stdio is the -in, -results or -out path that stands for standard input or output.
+const stdio = "-"
+
+// docStdout is where -out - writes the document: the standard output the process started
+// with, since the run's own messages then go to standard error (see main).
+var docStdout = os.Stdout
+
+var (
+	stdinOnce sync.Once
+	stdinData []byte
+	stdinErr  error
+)
+
+// readStdin reads standard input whole, the first time it is called, and returns the same
+// bytes every time after, so an input given as "-" can be read again, e.g. to hash it.
+func readStdin() ([]byte, error) {
+	stdinOnce.Do(func() { stdinData, stdinErr = io.ReadAll(os.Stdin) })
+	return stdinData, stdinErr
+}
+
+// writeFileAtomic writes b to path through a temporary file in the same directory renamed
+// into place, so a reader sees the old contents or the new ones, never a partial write.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// cachedFetch returns the payload of rawURL from the cache in dir, calling fetch and storing
+// its result when the entry is missing or fails its checksum. Each entry is a file named
+// after the URL's hash with a sha256sum-style ".sha256" file beside it, written last, so an
+// interrupted write is refetched too. An entry is locked while it is checked and fetched, so
+// a second run wanting the same URL waits and reads it from the cache. An empty dir
+// disables the cache.
+func cachedFetch(dir, rawURL string, fetch func() ([]byte, error)) ([]byte, error) {
+	if dir == "" {
+		return fetch()
+	}
+	key := sha256.Sum256([]byte(rawURL))
+	name := fmt.Sprintf("%x.json", key[:8])
+	path := filepath.Join(dir, name)
+	err := os.MkdirAll(dir, 0o755)
+	var unlock func()
+	if err == nil {
+		unlock, err = lockPath(path)
+	}
+	if err != nil {
+		warnf("not caching %s: %v", rawURL, err)
+		return fetch()
+	}
+	defer unlock()
+	if b, err := os.ReadFile(path); err == nil {
+		sum, err := os.ReadFile(path + ".sha256")
+		if err == nil && string(sum) == fmt.Sprintf("%x  %s\n", sha256.Sum256(b), name) {
+			slog.Info("read from cache", "url", rawURL, "path", path)
+			return b, nil
+		}
+		warnf("cached %s (%s) failed its checksum; fetching it again", rawURL, path)
+	}
+	b, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	err = writeFileAtomic(path, b)
+	if err == nil {
+		err = writeFileAtomic(path+".sha256", []byte(fmt.Sprintf("%x  %s\n", sha256.Sum256(b), name)))
+	}
+	if err != nil {
+		warnf("failed to cache %s: %v", rawURL, err)
+	}
+	return b, nil
+}
+
+// nextLink returns the rel="next" URL of an RFC 8288 Link header, as Canvas paginates
+// with, or "" on the last page.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok {
+			continue
+		}
+		target = strings.TrimSpace(target)
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if !strings.EqualFold(k, "rel") {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.Trim(v, `"`)) {
+				if strings.EqualFold(rel, "next") {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// xssiPrefixes are the anti-JSON-hijacking prefixes APIs put before JSON bodies; Canvas
+// sends "while(1);" unless asked not to.
+var xssiPrefixes = []string{"while(1);", "for(;;);", ")]}',", ")]}'"}
+
+// recoverJSON repairs the artifacts captures pick up on the way to disk: a UTF-8 BOM, an
+// XSSI prefix, JSON5/JSONC annotations (see fromJSON5), trailing commas, and several JSON
+// documents saved one after another (arrays are joined into one). Valid JSON is returned
+// unchanged. fixes says what was repaired.
+func recoverJSON(b []byte) (out []byte, fixes []string, err error) {
+	if json.Valid(b) {
+		return b, nil, nil
+	}
+	if trimmed := bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")); len(trimmed) != len(b) {
+		b, fixes = trimmed, append(fixes, "stripped a UTF-8 byte order mark")
+	}
+	for _, p := range xssiPrefixes {
+		if trimmed := bytes.TrimLeft(b, " \t\r\n"); bytes.HasPrefix(trimmed, []byte(p)) {
+			b, fixes = trimmed[len(p):], append(fixes, fmt.Sprintf("stripped the %q prefix", p))
+			break
+		}
+	}
+	converted, kinds, err := fromJSON5(b)
+	if err != nil {
+		return nil, fixes, err
+	}
+	if len(kinds) > 0 {
+		b, fixes = converted, append(fixes, "read JSON5 syntax: "+strings.Join(kinds, ", "))
+	}
+	if trimmed, n := stripTrailingCommas(b); n > 0 {
+		b, fixes = trimmed, append(fixes, fmt.Sprintf("removed %d trailing comma(s)", n))
+	}
+	if json.Valid(b) {
+		return b, fixes, nil
+	}
+	if joined, n := joinNDJSON(b); n > 0 {
+		return joined, append(fixes, fmt.Sprintf("read %d lines of newline-delimited JSON", n)), nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	var docs []json.RawMessage
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fixes, jsonSyntaxError(b, err)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) < 2 {
+		return nil, fixes, jsonSyntaxError(b, json.Unmarshal(b, new(any)))
+	}
+	var joined []json.RawMessage
+	for _, doc := range docs {
+		var elems []json.RawMessage
+		if json.Unmarshal(doc, &elems) != nil {
+			return nil, fixes, fmt.Errorf("%d JSON documents saved one after another; only arrays can be joined", len(docs))
+		}
+		joined = append(joined, elems...)
+	}
+	if b, err = json.Marshal(joined); err != nil {
+		return nil, fixes, err
+	}
+	return b, append(fixes, fmt.Sprintf("joined %d concatenated JSON arrays", len(docs))), nil
+}
+
+// joinNDJSON reads newline-delimited JSON, one quiz item or result entry per line, into a
+// JSON array. Blank lines are skipped. It returns the array and the number of objects read,
+// or 0 unless b has at least two lines and every one is a JSON object.
+func joinNDJSON(b []byte) ([]byte, int) {
+	var out bytes.Buffer
+	out.WriteByte('[')
+	n := 0
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(nil, len(b)+1)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '{' || !json.Valid(line) {
+			return nil, 0
+		}
+		if n > 0 {
+			out.WriteByte(',')
+		}
+		out.Write(line)
+		n++
+	}
+	if sc.Err() != nil || n < 2 {
+		return nil, 0
+	}
+	out.WriteByte(']')
+	return out.Bytes(), n
+}
+
+// json5Kinds names the JSON5 extensions fromJSON5 rewrites, in the order fixes list them.
+var json5Kinds = []string{"comments", "unquoted keys", "single-quoted strings", "hexadecimal numbers", "leading + signs", "bare decimal points", "line continuations"}
+
+// fromJSON5 rewrites the JSON5 (and JSONC) extensions people use to annotate saved payloads
+// into plain JSON: comments, unquoted keys, single-quoted strings, hexadecimal numbers,
+// leading + signs, leading or trailing decimal points and escaped line breaks in strings.
+// Comments become whitespace, so error positions stay put. It returns the kinds it found;
+// trailing commas are left to stripTrailingCommas.
+func fromJSON5(b []byte) ([]byte, []string, error) {
+	out := make([]byte, 0, len(b))
+	found := map[string]bool{}
+	isIdent := func(c byte, first bool) bool {
+		return c == '_' || c == '$' || 'a' <= c|0x20 && c|0x20 <= 'z' || !first && '0' <= c && c <= '9'
+	}
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '"' || c == '\'':
+			// Strings: copy, switching single quotes to double and dropping line continuations.
+			if c == '\'' {
+				found["single-quoted strings"] = true
+			}
+			out = append(out, '"')
+			for i++; i < len(b) && b[i] != c; i++ {
+				switch {
+				case b[i] == '\\' && i+1 < len(b) && (b[i+1] == '\n' || b[i+1] == '\r'):
+					found["line continuations"] = true
+					i++
+					if b[i] == '\r' && i+1 < len(b) && b[i+1] == '\n' {
+						i++
+					}
+				case b[i] == '\\' && i+1 < len(b) && b[i+1] == '\'':
+					out = append(out, '\'')
+					i++
+				case b[i] == '\\' && i+1 < len(b):
+					out = append(out, b[i], b[i+1])
+					i++
+				case b[i] == '"':
+					out = append(out, '\\', '"')
+				default:
+					out = append(out, b[i])
+				}
+			}
+			out = append(out, '"')
+		case c == '/' && i+1 < len(b) && (b[i+1] == '/' || b[i+1] == '*'):
+			found["comments"] = true
+			end := len(b)
+			if b[i+1] == '/' {
+				if j := bytes.IndexByte(b[i:], '\n'); j >= 0 {
+					end = i + j
+				}
+			} else if j := bytes.Index(b[i+2:], []byte("*/")); j >= 0 {
+				end = i + 2 + j + 2
+			}
+			for _, cc := range b[i:end] {
+				if cc == '\n' {
+					out = append(out, '\n')
+				} else {
+					out = append(out, ' ')
+				}
+			}
+			i = end - 1
+		case isIdent(c, true):
+			j := i + 1
+			for j < len(b) && isIdent(b[j], false) {
+				j++
+			}
+			word := string(b[i:j])
+			k := j
+			for k < len(b) && strings.IndexByte(" \t\r\n", b[k]) >= 0 {
+				k++
+			}
+			switch {
+			case word == "Infinity" || word == "NaN":
+				return nil, nil, fmt.Errorf("%s has no JSON equivalent", word)
+			case k < len(b) && b[k] == ':':
+				found["unquoted keys"] = true
+				out = append(out, '"')
+				out = append(out, word...)
+				out = append(out, '"')
+			default:
+				out = append(out, word...) // true, false, null, or an error for json to report
+			}
+			i = j - 1
+		case c == '+' && i+1 < len(b) && ('0' <= b[i+1] && b[i+1] <= '9' || b[i+1] == '.'):
+			found["leading + signs"] = true
+		case '0' <= c && c <= '9' || c == '.' && i+1 < len(b) && '0' <= b[i+1] && b[i+1] <= '9':
+			j := i
+			if c == '0' && i+1 < len(b) && b[i+1]|0x20 == 'x' {
+				for j = i + 2; j < len(b) && strings.IndexByte("0123456789abcdefABCDEF", b[j]) >= 0; j++ {
+				}
+				n, err := strconv.ParseUint(string(b[i+2:j]), 16, 64)
+				if err != nil {
+					return nil, nil, fmt.Errorf("bad hexadecimal number %s", b[i:j])
+				}
+				found["hexadecimal numbers"] = true
+				out = strconv.AppendUint(out, n, 10)
+				i = j - 1
+				continue
+			}
+			for j < len(b) && (strings.IndexByte("0123456789.eE", b[j]) >= 0 || (b[j] == '+' || b[j] == '-') && b[j-1]|0x20 == 'e') {
+				j++
+			}
+			num := string(b[i:j])
+			if strings.HasPrefix(num, ".") {
+				num, found["bare decimal points"] = "0"+num, true
+			}
+			if strings.HasSuffix(num, ".") {
+				num, found["bare decimal points"] = num+"0", true
+			}
+			out = append(out, num...)
+			i = j - 1
+		default:
+			out = append(out, c)
+		}
+	}
+	var kinds []string
+	for _, k := range json5Kinds {
+		if found[k] {
+			kinds = append(kinds, k)
+		}
+	}
+	return out, kinds, nil
+}
+
+// stripTrailingCommas drops commas that directly precede a closing bracket or brace, outside
+// strings, and reports how many it dropped.
+func stripTrailingCommas(b []byte) ([]byte, int) {
+	out := make([]byte, 0, len(b))
+	inString, escaped, n := false, false, 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == ',':
+			j := i + 1
+			for j < len(b) && strings.IndexByte(" \t\r\n", b[j]) >= 0 {
+				j++
+			}
+			if j < len(b) && (b[j] == '}' || b[j] == ']') {
+				n++
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out, n
+}
+
+// jsonSyntaxError adds the line and column to a syntax error's byte offset.
+func jsonSyntaxError(b []byte, err error) error {
+	var se *json.SyntaxError
+	offset := len(b)
+	switch {
+	case errors.As(err, &se):
+		offset = int(se.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		err = errors.New("input ends in the middle of a value; was the capture truncated?")
+	default:
+		return err
+	}
+	before := b[:min(offset, len(b))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %v", line, col, err)
+}
+
+// streamable reports whether path is a local .json file, which can be decoded as it is read
+// (see quizextract.ReadQuizItems) before falling back to readInput.
+func streamable(path string) bool {
+	return !isURL(path) && strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// streamQuizItems reads a local .json quiz file one item at a time, so the file is never held
+// in memory next to the items decoded from it; ok is false when the file needs readInput instead: a Classic Quizzes
+// export, items nested deeper than one wrapper, or JSON that needs repairs.
+func streamQuizItems(path string) (quiz []quizextract.QuizItem, ok bool) {
+	if !streamable(path) {
+		return nil, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	quiz, err = quizextract.ReadQuizItems(f)
+	return quiz, err == nil
+}
+
+// readQuizPages reads the pages of a quiz export paginated into several files, as repeated
+// -in flags give them, and joins their items (see quizextract.DecodeQuizPages).
+func readQuizPages(paths []string, token string) ([]quizextract.QuizItem, error) {
+	var pages [][]byte
+	for _, p := range paths {
+		b, _, err := readInput(p, "quiz", token)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		if _, isClassic, _ := quizextract.ParseClassicQuestions(b); isClassic {
+			return nil, fmt.Errorf("%s: a Classic Quizzes export is read whole; give it as the only -in", p)
+		}
+		pages = append(pages, b)
+	}
+	quiz, err := quizextract.DecodeQuizPages(pages)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", strings.Join(paths, ", "), err)
+	}
+	return quiz, nil
+}
+
+// rePageSuffix matches the page number of a paginated export's file name, as in wk12_p1 or
+// wk12-page2.
+var rePageSuffix = regexp.MustCompile(`(?i)[_-](?:p|page)\d+$`)
+
+// pagesName is the name labels and output paths take from the first page of a paginated
+// export: its path without the page number (wk12_p1.json -> wk12.json).
+func pagesName(path string) string {
+	ext := filepath.Ext(path)
+	if base := rePageSuffix.ReplaceAllString(strings.TrimSuffix(path, ext), ""); base != "" && !os.IsPathSeparator(base[len(base)-1]) {
+		return base + ext
+	}
+	return path
+}
+
+// swappedInputs is called when the quiz input at quizPath holds item results. If the results
+// input holds the quiz, the two were given the wrong way round: it returns the quiz read from
+// resultPath, with a note, and the caller swaps the paths. Otherwise the error says how to
+// pass the file.
+func swappedInputs(quizPath, resultPath, token string) ([]byte, string, error) {
+	if resultPath != "" {
+		b, name, err := readInput(resultPath, "quiz", token)
+		if err == nil && quizextract.PayloadShape(b) == "quiz" {
+			fmt.Fprintf(os.Stderr, "note: %s holds item results and %s the quiz; reading them the other way round\n", quizPath, resultPath)
+			return b, name, nil
+		}
+	}
+	return nil, "", fmt.Errorf("%s holds item results, not quiz items: pass it as -results, with the quiz as -in (without -in, the questions are rebuilt from the results, without their text)", quizPath)
+}
+
+// readResults reads one student's item results from a file or URL (see
+// quizextract.DecodeResults for the payloads it accepts). A local .json file is decoded as
+// it is read when its shape allows (see quizextract.StreamResults).
+func readResults(path, token string) ([]quizextract.ResultItem, error) {
+	if streamable(path) {
+		if f, err := os.Open(path); err == nil {
+			var results []quizextract.ResultItem
+			err := quizextract.StreamResults(f, func(res quizextract.ResultItem) error {
+				results = append(results, res)
+				return nil
+			})
+			f.Close()
+			if err == nil {
+				slog.Info("read results", "path", path, "items", len(results), "streamed", true)
+				return results, nil
+			}
+		}
+	}
+	b, _, err := readInput(path, "results", token)
+	if err != nil {
+		return nil, err
+	}
+	results, err := quizextract.DecodeResults(b)
+	if err == nil {
+		slog.Info("read results", "path", path, "items", len(results), "streamed", false)
+	}
+	return results, err
+}
+
+// readClassicSubmissions reads the attempts of a Classic Quizzes submission (see
+// quizextract.ParseClassicSubmissions) from path.
+func readClassicSubmissions(path, token string) ([]quizextract.ClassicSubmission, error) {
+	b, _, err := readInput(path, "results", token)
+	if err != nil {
+		return nil, err
+	}
+	subs, ok, err := quizextract.ParseClassicSubmissions(b)
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok || len(subs) == 0:
+		return nil, fmt.Errorf("no attempts (submission_history, submission_data or quiz_submissions) found in payload: it holds %s", quizextract.DescribePayload(b))
+	}
+	slog.Info("read Classic Quizzes submission", "path", path, "attempts", len(subs))
+	return subs, nil
+}
+
+// readResultsDir reads every *.json file in dir as one student's results, in filename order.
+func readResultsDir(dir string) ([][]quizextract.ResultItem, []string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no result files (*.json) in %s", dir)
+	}
+	var class [][]quizextract.ResultItem
+	var names []string
+	for _, p := range paths {
+		results, err := readResults(p, "")
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", p, err)
+		}
+		class = append(class, results)
+		names = append(names, strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)))
+	}
+	return class, names, nil
+}
+
+// gradebook is a Canvas gradebook CSV export: one row per student, keyed by column header.
+type gradebook struct {
+	Columns []string
+	Rows    []map[string]string
+}
+
+// gradebookKeys are the gradebook columns a result file's name is matched against.
+var gradebookKeys = []string{"ID", "SIS User ID", "SIS Login ID"}
+
+// readGradebook reads a gradebook CSV export, skipping the "Points Possible" row Canvas
+// puts under the header and any other row without a student ID.
+func readGradebook(path string) (gradebook, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return gradebook{}, err
+	}
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return gradebook{}, err
+	}
+	if len(records) == 0 {
+		return gradebook{}, errors.New("empty gradebook")
+	}
+	gb := gradebook{Columns: records[0]}
+	for _, rec := range records[1:] {
+		row := map[string]string{}
+		for i, col := range gb.Columns {
+			if i < len(rec) {
+				row[col] = strings.TrimSpace(rec[i])
+			}
+		}
+		if row["ID"] == "" || strings.EqualFold(strings.TrimSpace(rec[0]), "Points Possible") {
+			continue
+		}
+		gb.Rows = append(gb.Rows, row)
+	}
+	return gb, nil
+}
+
+// groupStudents returns the group of each result file: the value of column in the gradebook
+// row whose ID, SIS User ID or SIS Login ID equals the file's name. Numeric columns, such as
+// assignment group scores, are grouped in ten-point bands. Files without a row are grouped
+// under NotInGradebook.
+func groupStudents(gb gradebook, column string, names []string) ([]string, error) {
+	known := false
+	for _, c := range gb.Columns {
+		known = known || c == column
+	}
+	if !known {
+		return nil, fmt.Errorf("no column %q in the gradebook", column)
+	}
+	byKey := map[string]map[string]string{}
+	for _, row := range gb.Rows {
+		for _, k := range gradebookKeys {
+			if v := strings.ToLower(row[k]); v != "" {
+				byKey[v] = row
+			}
+		}
+	}
+	values := make([]string, len(names))
+	numeric := true
+	for i, n := range names {
+		row, ok := byKey[strings.ToLower(n)]
+		if !ok {
+			values[i] = quizextract.NotInGradebook
+			continue
+		}
+		values[i] = row[column]
+		if _, err := strconv.ParseFloat(values[i], 64); err != nil {
+			numeric = false
+		}
+	}
+	for i, v := range values {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && numeric {
+			values[i] = scoreBand(f)
+		} else if v == "" {
+			values[i] = "(none)"
+		}
+	}
+	return values, nil
+}
+
+// scoreBand names the ten-point band holding score, e.g. "80–90"; a band includes its lower
+// bound, and 100 falls in "90–100".
+func scoreBand(score float64) string {
+	lo := math.Floor(score/10) * 10
+	if score == 100 {
+		lo = 90
+	}
+	return fmt.Sprintf("%s–%s", quizextract.FormatPoints(lo), quizextract.FormatPoints(lo+10))
+}
+
+// quizizzTimes are the per-question time limits, in seconds, that Quizizz accepts.
+var quizizzTimes = []int{5, 10, 20, 30, 45, 60, 90, 120, 180, 300, 600, 900}
+
+// publishConfig carries the credentials and destinations for every publish target.
+type publishConfig struct {
+	GoogleCredentials string // service-account key JSON
+	GoogleToken       string // OAuth access token, used instead of a service account
+	SheetID           string
+	SheetRange        string
+	DocID             string // existing Google Doc to overwrite; empty creates a new one
+	ConfluenceURL     string // base URL, e.g. https://example.atlassian.net/wiki
+	ConfluenceSpace   string
+	ConfluenceParent  string // optional parent page id
+	ConfluenceUser    string // Cloud account e-mail; empty sends the token as a bearer token
+	ConfluenceToken   string
+}
+
+// publishTargets lists the destinations accepted by "publish <target>".
+var publishTargets = []string{"sheets", "gdoc", "confluence"}
+
+// publish sends the extraction to target: statistics for trackers, the document for
+// document stores.
+func publish(target string, cfg publishConfig, doc quizextract.QuizDoc, st quizextract.QuizStats) error {
+	switch target {
+	case "sheets":
+		return publishSheets(cfg, st)
+	case "gdoc":
+		return publishGoogleDoc(cfg, doc)
+	case "confluence":
+		return publishConfluence(cfg, doc)
+	}
+	return fmt.Errorf("unknown publish target %q (want %s)", target, strings.Join(publishTargets, ", "))
+}
+
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+// publishSheets appends one row per quiz to the configured spreadsheet:
+// date, label, title, questions, students, score, points possible, percent.
+func publishSheets(cfg publishConfig, st quizextract.QuizStats) error {
+	if cfg.SheetID == "" {
+		return fmt.Errorf("-sheet-id is required")
+	}
+	token, err := googleAccessToken(cfg, sheetsScope)
+	if err != nil {
+		return err
+	}
+	row := []any{
+		time.Now().Format("2006-01-02"), st.Label, st.Title, st.Questions, st.Students,
+		quizextract.RoundTo(st.Score, 2), quizextract.RoundTo(st.Possible, 2), quizextract.RoundTo(st.Percent(), 1),
+	}
+	endpoint := fmt.Sprintf("https://sheets.googleapis.com/v4/spreadsheets/%s/values/%s:append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS",
+		url.PathEscape(cfg.SheetID), url.PathEscape(cfg.SheetRange))
+	return publishJSON(http.MethodPost, endpoint, "Bearer "+token, map[string]any{"values": [][]any{row}}, nil)
+}
+
+const docsScope = "https://www.googleapis.com/auth/documents"
+
+// publishGoogleDoc writes doc to a Google Doc: a new one titled after the quiz, or the
+// -gdoc-id document, whose previous content is replaced.
+func publishGoogleDoc(cfg publishConfig, doc quizextract.QuizDoc) error {
+	token, err := googleAccessToken(cfg, docsScope)
+	if err != nil {
+		return err
+	}
+	id := cfg.DocID
+	var requests []map[string]any
+	if id == "" {
+		var created struct {
+			DocumentID string `json:"documentId"`
+		}
+		if err := publishJSON(http.MethodPost, "https://docs.googleapis.com/v1/documents", "Bearer "+token, map[string]any{"title": doc.Title}, &created); err != nil {
+			return err
+		}
+		id = created.DocumentID
+	} else {
+		var existing struct {
+			Body struct {
+				Content []struct {
+					EndIndex int `json:"endIndex"`
+				} `json:"content"`
+			} `json:"body"`
+		}
+		if err := publishJSON(http.MethodGet, "https://docs.googleapis.com/v1/documents/"+url.PathEscape(id), "Bearer "+token, nil, &existing); err != nil {
+			return err
+		}
+		// The body always ends with a newline that cannot be deleted.
+		if c := existing.Body.Content; len(c) > 0 && c[len(c)-1].EndIndex > 2 {
+			requests = append(requests, map[string]any{"deleteContentRange": map[string]any{
+				"range": map[string]int{"startIndex": 1, "endIndex": c[len(c)-1].EndIndex - 1},
+			}})
+		}
+	}
+	requests = append(requests, gdocRequests(gdocParagraphs(doc))...)
+	if err := publishJSON(http.MethodPost, "https://docs.googleapis.com/v1/documents/"+url.PathEscape(id)+":batchUpdate", "Bearer "+token, map[string]any{"requests": requests}, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Google Doc: https://docs.google.com/document/d/%s/edit\n", id)
+	return nil
+}
+
+// gdocParagraph is one paragraph of the Google Docs rendering. Text from BoldFrom (in runes)
+// to the end is bold; -1 means no bold.
+type gdocParagraph struct {
+	Text     string
+	Style    string // namedStyleType: TITLE, HEADING_2 or NORMAL_TEXT
+	BoldFrom int
+}
+
+// gdocParagraphs lays the document out as Docs paragraphs, bolding the answers.
+func gdocParagraphs(doc quizextract.QuizDoc) []gdocParagraph {
+	plain := func(text string) gdocParagraph { return gdocParagraph{Text: text, Style: "NORMAL_TEXT", BoldFrom: -1} }
+	labelled := func(label, value string) gdocParagraph {
+		return gdocParagraph{Text: label + value, Style: "NORMAL_TEXT", BoldFrom: len([]rune(label))}
+	}
+	out := []gdocParagraph{{Text: doc.Title, Style: "TITLE", BoldFrom: -1}}
+	for _, d := range doc.Details {
+		out = append(out, plain(d.Label+": "+d.Value))
+	}
+	for _, p := range doc.Description {
+		out = append(out, plain(p))
+	}
+	for _, q := range doc.Questions {
+		out = append(out, gdocParagraph{Text: fmt.Sprintf("%d) %s", q.Number, q.Text), Style: "HEADING_2", BoldFrom: -1})
+		for _, m := range q.Media {
+			out = append(out, labelled("Media: ", m.Label()+" "+m.URL))
+		}
+		switch {
+		case !q.HasResult:
+			out = append(out, plain("(no result data)"))
+		case q.Ungraded:
+			for _, o := range q.Options {
+				out = append(out, plain("• "+o.Label))
+			}
+			if !doc.Practice {
+				resp := "(no response)"
+				if len(q.Responses) > 0 {
+					resp = strings.Join(q.Responses, ", ")
+				}
+				out = append(out, labelled("Your response: ", resp))
+			}
+		case q.OpenEntry:
+			for _, b := range q.Blanks {
+				ans := b.Answer
+				if ans == "" {
+					ans = "(answer unavailable)"
+				}
+				out = append(out, labelled(b.Label+": ", ans))
+			}
+		case q.Essay:
+			out = append(out, plain("Essay question"))
+			for _, c := range q.Rubric {
+				out = append(out, plain(fmt.Sprintf("• %s (%s pts)", c.Description, quizextract.FormatPoints(c.Points))))
+			}
+		default:
+			for _, o := range q.Options {
+				if o.Correct {
+					out = append(out, gdocParagraph{Text: "• " + o.Label + " (correct)", Style: "NORMAL_TEXT", BoldFrom: 0})
+				} else {
+					out = append(out, plain("• "+o.Label))
+				}
+			}
+			switch {
+			case len(q.Answers) > 1:
+				out = append(out, labelled("Correct answers: ", strings.Join(q.Answers, "; ")))
+			case len(q.Answers) == 1:
+				out = append(out, labelled("Answer: ", q.Answers[0]))
+			default:
+				out = append(out, plain("Answer: (answer unavailable)"))
+			}
+		}
+		for _, a := range q.Attempts {
+			out = append(out, plain(a.Summary()))
+		}
+		if q.Class != nil {
+			out = append(out, plain("Class: "+q.Class.Summary()))
+		}
+		if q.Explanation != "" {
+			out = append(out, plain("Explanation: "+q.Explanation))
+		}
+		for _, c := range q.Comments {
+			out = append(out, plain("Instructor: "+c.Text))
+		}
+	}
+	return out
+}
+
+// gdocRequests turns paragraphs into Docs batchUpdate requests: one text insertion at the
+// start of the body, then paragraph and bold styles over the inserted ranges. Docs indexes
+// count UTF-16 code units.
+func gdocRequests(paras []gdocParagraph) []map[string]any {
+	var text strings.Builder
+	var styles []map[string]any
+	index := 1
+	for _, p := range paras {
+		line := strings.ReplaceAll(p.Text, "\n", " ") + "\n"
+		start := index
+		end := start + len(utf16.Encode([]rune(line)))
+		styles = append(styles, map[string]any{"updateParagraphStyle": map[string]any{
+			"range":          map[string]int{"startIndex": start, "endIndex": end},
+			"paragraphStyle": map[string]string{"namedStyleType": p.Style},
+			"fields":         "namedStyleType",
+		}})
+		if p.BoldFrom >= 0 {
+			boldStart := start + len(utf16.Encode([]rune(p.Text)[:p.BoldFrom]))
+			if boldStart < end-1 {
+				styles = append(styles, map[string]any{"updateTextStyle": map[string]any{
+					"range":     map[string]int{"startIndex": boldStart, "endIndex": end - 1},
+					"textStyle": map[string]bool{"bold": true},
+					"fields":    "bold",
+				}})
+			}
+		}
+		text.WriteString(line)
+		index = end
+	}
+	insert := map[string]any{"insertText": map[string]any{"location": map[string]int{"index": 1}, "text": text.String()}}
+	return append([]map[string]any{insert}, styles...)
+}
+
+// publishConfluence creates or updates the page titled after the quiz in -confluence-space,
+// with the document in Confluence storage format.
+func publishConfluence(cfg publishConfig, doc quizextract.QuizDoc) error {
+	if cfg.ConfluenceURL == "" || cfg.ConfluenceSpace == "" {
+		return fmt.Errorf("-confluence-url and -confluence-space are required")
+	}
+	token := cfg.ConfluenceToken
+	if token == "" {
+		token = os.Getenv("CONFLUENCE_TOKEN")
+	}
+	if token == "" {
+		return fmt.Errorf("Confluence token missing: pass -confluence-token or set CONFLUENCE_TOKEN")
+	}
+	// Cloud uses e-mail + API token (basic auth); Data Center/Server personal access tokens are bearer tokens.
+	auth := "Bearer " + token
+	if cfg.ConfluenceUser != "" {
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(cfg.ConfluenceUser+":"+token))
+	}
+	api := strings.TrimRight(cfg.ConfluenceURL, "/") + "/rest/api/content"
+
+	var found struct {
+		Results []struct {
+			ID      string `json:"id"`
+			Version struct {
+				Number int `json:"number"`
+			} `json:"version"`
+		} `json:"results"`
+	}
+	query := url.Values{"spaceKey": {cfg.ConfluenceSpace}, "title": {doc.Title}, "expand": {"version"}}
+	if err := publishJSON(http.MethodGet, api+"?"+query.Encode(), auth, nil, &found); err != nil {
+		return err
+	}
+	page := map[string]any{
+		"type":  "page",
+		"title": doc.Title,
+		"space": map[string]string{"key": cfg.ConfluenceSpace},
+		"body": map[string]any{"storage": map[string]string{
+			"value":          quizextract.RenderConfluenceStorage(doc),
+			"representation": "storage",
+		}},
+	}
+	if cfg.ConfluenceParent != "" {
+		page["ancestors"] = []map[string]string{{"id": cfg.ConfluenceParent}}
+	}
+	var saved struct {
+		ID    string `json:"id"`
+		Links struct {
+			Base  string `json:"base"`
+			WebUI string `json:"webui"`
+		} `json:"_links"`
+	}
+	if len(found.Results) > 0 {
+		existing := found.Results[0]
+		page["version"] = map[string]int{"number": existing.Version.Number + 1}
+		if err := publishJSON(http.MethodPut, api+"/"+url.PathEscape(existing.ID), auth, page, &saved); err != nil {
+			return err
+		}
+	} else if err := publishJSON(http.MethodPost, api, auth, page, &saved); err != nil {
+		return err
+	}
+	fmt.Printf("Confluence page: %s%s\n", saved.Links.Base, saved.Links.WebUI)
+	return nil
+}
+
+// publishClient bounds every publisher request, including token exchanges.
+var publishClient = &http.Client{Timeout: 60 * time.Second}
+
+// publishJSON sends body (if any) as JSON with the given Authorization header and decodes
+// the JSON response into out (if non-nil). Non-2xx responses become errors with the start
+// of the body.
+func publishJSON(method, endpoint, authorization string, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, endpoint, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", authorization)
+	resp, err := publishClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+		return fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// googleServiceAccount is the subset of a service-account key file needed for the JWT grant.
+type googleServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// googleAccessToken returns an OAuth access token: -google-token (or GOOGLE_OAUTH_ACCESS_TOKEN)
+// when set, otherwise one obtained with the -google-credentials service account.
+func googleAccessToken(cfg publishConfig, scope string) (string, error) {
+	if cfg.GoogleToken != "" {
+		return cfg.GoogleToken, nil
+	}
+	if t := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); t != "" {
+		return t, nil
+	}
+	if cfg.GoogleCredentials == "" {
+		return "", fmt.Errorf("Google credentials missing: pass -google-credentials or -google-token")
+	}
+	var sa googleServiceAccount
+	if err := mustReadJSON(cfg.GoogleCredentials, &sa); err != nil {
+		return "", fmt.Errorf("read service account: %v", err)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	assertion, err := signServiceAccountJWT(sa, scope, time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	resp, err := publishClient.PostForm(sa.TokenURI, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("token exchange: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.AccessToken == "" {
+		return "", fmt.Errorf("token exchange failed (%s): %s", resp.Status, tok.Error)
+	}
+	return tok.AccessToken, nil
+}
+
+// signServiceAccountJWT builds the RS256-signed assertion for the OAuth JWT-bearer grant.
+func signServiceAccountJWT(sa googleServiceAccount, scope string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("service account private_key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", fmt.Errorf("parse private key: %v", err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("service account key is not RSA")
+	}
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	unsigned := enc(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + enc(map[string]any{
+		"iss":   sa.ClientEmail,
+		"scope": scope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// outputLayout describes where derived output paths go when -out is not given.
+type outputLayout struct {
+	Dir    string // -out-dir; empty keeps outputs next to the quiz file
+	Mode   string // structured, mirror or flat
+	Course string
+}
+
+// quizFilePrefix names outputs of quizzes whose file name matches no label pattern: the
+// slug of the whole base name (quiz_final.json -> quiz-final), or "quiz" if that is empty.
+func quizFilePrefix(quizPath string) string {
+	base := filepath.Base(quizPath)
+	if prefix := slugify(strings.TrimSuffix(base, filepath.Ext(base))); prefix != "" {
+		return prefix
+	}
+	return "quiz"
+}
+
+// outputOwner names the quiz that claims derived output paths (see claimOutPath): its quiz
+// id, or for a quiz without one, the slug of its file name and a hash of the absolute input
+// path or URL, so same-named files from different directories don't share an owner.
+func outputOwner(quizID, quizPath, quizName string) string {
+	if quizID != "" {
+		return quizID
+	}
+	prefix := quizFilePrefix(quizName)
+	if quizPath == stdio {
+		return prefix
+	}
+	source := quizPath
+	if !isURL(quizPath) {
+		if abs, err := filepath.Abs(quizPath); err == nil {
+			source = abs
+		}
+	}
+	sum := sha256.Sum256([]byte(source))
+	return prefix + "-" + hex.EncodeToString(sum[:4])
+}
+
+// outputOwnersFile records, per directory, which quiz each derived output file belongs to.
+const outputOwnersFile = ".quiz-owners.json"
+
+// claimOutPath resolves collisions between derived output paths. The first quiz to derive
+// a file name owns it; a different quiz deriving the same name gets its quiz id appended
+// (solutions.md -> solutions_quiz-final.md), so re-running either quiz always lands on the
+// same file. Owners are kept in .quiz-owners.json next to the outputs, which is locked from
+// the read to the write so the parallel runs of -dir each see the others' claims.
+func claimOutPath(path, quizID string) (string, error) {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	ownersPath := filepath.Join(dir, outputOwnersFile)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	unlock, err := lockPath(ownersPath)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	owners := map[string]string{}
+	if b, err := os.ReadFile(ownersPath); err == nil {
+		if err := json.Unmarshal(b, &owners); err != nil {
+			return "", fmt.Errorf("%s: %v", ownersPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if owner, ok := owners[name]; ok && owner != quizID {
+		ext := filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext) + "_" + slugify(quizID) + ext
+	}
+	if owners[name] == quizID {
+		return filepath.Join(dir, name), nil
+	}
+	owners[name] = quizID
+	b, err := json.MarshalIndent(owners, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), writeFileAtomic(ownersPath, append(b, '\n'))
+}
+
+// slugSeparators are the runs slugify turns into dashes. Combining marks (Thai vowels,
+// Devanagari matras, decomposed accents) belong to their letter; splitting on them would
+// scatter a word across dashes.
+var slugSeparators = regexp.MustCompile(`[^\p{L}\p{M}\p{N}]+`)
+
+// slugify lowercases s and collapses everything but letters, marks and digits into single dashes.
+func slugify(s string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(norm.NFC.String(s)), "-"), "-")
+}
+
+// deriveOutPath picks the output path for quizPath when -out is not given. kind names the
+// document ("quiz_solutions", "item_analysis"); the structured layout drops its "quiz_" prefix.
+func deriveOutPath(quizPath string, label quizextract.FileLabel, kind, ext string, layout outputLayout) (string, error) {
+	prefix := label.Slug
+	if prefix == "" {
+		prefix = quizFilePrefix(quizPath)
+	}
+	fileName := fmt.Sprintf("%s_%s%s", prefix, kind, ext)
+	if layout.Dir == "" {
+		return filepath.Join(filepath.Dir(quizPath), fileName), nil
+	}
+	switch layout.Mode {
+	case "", "structured":
+		week := slugify(prefix)
+		parts := []string{layout.Dir}
+		if c := slugify(layout.Course); c != "" {
+			parts = append(parts, c)
+		}
+		parts = append(parts, week, strings.TrimPrefix(kind, "quiz_")+ext)
+		return filepath.Join(parts...), nil
+	case "mirror":
+		// Recreate the quiz file's directory relative to the working directory;
+		// inputs outside it land at the root of -out-dir.
+		rel := filepath.Dir(quizPath)
+		if filepath.IsAbs(rel) {
+			if wd, err := os.Getwd(); err == nil {
+				if r, err := filepath.Rel(wd, rel); err == nil {
+					rel = r
+				}
+			}
+		}
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			rel = "."
+		}
+		return filepath.Join(layout.Dir, rel, fileName), nil
+	case "flat":
+		return filepath.Join(layout.Dir, fileName), nil
+	}
+	return "", fmt.Errorf("unknown layout %q (want structured, mirror or flat)", layout.Mode)
+}
+
+// schemaFiles holds the published JSON Schemas for the payloads the extractor reads.
+//
+//go:embed schemas/*.schema.json
+var schemaFiles embed.FS
+
+// schemaNames maps -schema values to the files under schemas/.
+var schemaNames = map[string]string{
+	"quiz":    "schemas/quiz.schema.json",
+	"results": "schemas/results.schema.json",
+}
+
+// jsonSchema is the subset of JSON Schema the published schemas use: type, properties,
+// required, items, enum, anyOf and local $ref. Other keywords are ignored.
+type jsonSchema struct {
+	Ref        string                 `json:"$ref"`
+	Type       json.RawMessage        `json:"type"` // a type name or a list of them
+	Properties map[string]*jsonSchema `json:"properties"`
+	Required   []string               `json:"required"`
+	Items      *jsonSchema            `json:"items"`
+	Enum       []any                  `json:"enum"`
+	AnyOf      []*jsonSchema          `json:"anyOf"`
+	Defs       map[string]*jsonSchema `json:"$defs"`
+}
+
+// schemaViolation is one place where a document deviates from its schema.
+type schemaViolation struct {
+	Path    string // JSONPath-style location, e.g. $[3].item.id
+	Message string
+}
+
+func (v schemaViolation) String() string { return v.Path + ": " + v.Message }
+
+func loadSchema(name string) (*jsonSchema, error) {
+	file, ok := schemaNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q (want quiz or results)", name)
+	}
+	b, err := schemaFiles.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var s jsonSchema
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &s, nil
+}
+
+// validateJSON checks data against root and returns every violation, in document order.
+func validateJSON(root *jsonSchema, data []byte) ([]schemaViolation, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	var out []schemaViolation
+	validateValue(root, root, v, "$", &out)
+	return out, nil
+}
+
+func validateValue(root, s *jsonSchema, v any, path string, out *[]schemaViolation) {
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		if def := root.Defs[name]; ok && def != nil {
+			validateValue(root, def, v, path, out)
+		} else {
+			*out = append(*out, schemaViolation{path, "schema has unresolvable $ref " + s.Ref})
+		}
+		return
+	}
+	if types := schemaTypes(s.Type); len(types) > 0 && !matchesType(types, v) {
+		*out = append(*out, schemaViolation{path, fmt.Sprintf("expected %s, got %s", strings.Join(types, " or "), jsonTypeName(v))})
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			var allowed []string
+			for _, e := range s.Enum {
+				b, _ := json.Marshal(e)
+				allowed = append(allowed, string(b))
+			}
+			got, _ := json.Marshal(v)
+			*out = append(*out, schemaViolation{path, fmt.Sprintf("value %s is not one of %s", got, strings.Join(allowed, ", "))})
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, alt := range s.AnyOf {
+			var errs []schemaViolation
+			validateValue(root, alt, v, path, &errs)
+			if len(errs) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			*out = append(*out, schemaViolation{path, fmt.Sprintf("%s does not match any allowed shape", jsonTypeName(v))})
+		}
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := t[name]; !ok {
+				*out = append(*out, schemaViolation{path, fmt.Sprintf("missing required field %q", name)})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if fv, ok := t[name]; ok {
+				validateValue(root, s.Properties[name], fv, path+"."+name, out)
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, iv := range t {
+				validateValue(root, s.Items, iv, fmt.Sprintf("%s[%d]", path, i), out)
+			}
+		}
+	}
+}
+
+func schemaTypes(raw json.RawMessage) []string {
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}
+	}
+	var many []string
+	_ = json.Unmarshal(raw, &many)
+	return many
+}
+
+func matchesType(types []string, v any) bool {
+	got := jsonTypeName(v)
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeName names the JSON type of a value decoded into any; whole numbers are "integer".
+func jsonTypeName(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if t == math.Trunc(t) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// runValidate implements "validate -schema quiz|results file...". It reports every
+// violation and exits non-zero if any file deviates from the schema.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	schemaName := fs.String("schema", "", "Schema to validate against: quiz (the -in file) or results (the -results file).")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s validate -schema quiz|results file.json...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *schemaName == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	schema, err := loadSchema(*schemaName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	failed := false
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		var violations []schemaViolation
+		data, fixes, err := recoverJSON(data)
+		if err == nil {
+			for _, fix := range fixes {
+				fmt.Printf("%s: note: %s\n", path, fix)
+			}
+			violations, err = validateJSON(schema, data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: not valid JSON: %v\n", path, err)
+			failed = true
+			continue
+		}
+		if len(violations) == 0 {
+			fmt.Printf("%s: valid %s payload\n", path, *schemaName)
+			continue
+		}
+		failed = true
+		fmt.Printf("%s: %d problem(s) against the %s schema\n", path, len(violations), *schemaName)
+		for _, v := range violations {
+			fmt.Printf("  %s\n", v)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// pairFiles pairs quiz file names with their results files: pattern names the results file
+// of a quiz, with "{name}" standing for the quiz file name without its extension (e.g.
+// "{name}_result.json" pairs wk12.json with wk12_result.json). rest are the names that are
+// neither.
+func pairFiles(names []string, pattern string) (pairs [][2]string, rest []string) {
+	have := map[string]bool{}
+	for _, n := range names {
+		have[n] = true
+	}
+	results := map[string]string{} // quiz -> results
+	isResults := map[string]bool{}
+	for _, n := range names {
+		r := strings.ReplaceAll(pattern, "{name}", strings.TrimSuffix(n, filepath.Ext(n)))
+		if r != n && have[r] {
+			results[n] = r
+			isResults[r] = true
+		}
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	for _, n := range sorted {
+		switch r, ok := results[n]; {
+		case ok && !isResults[n]:
+			pairs = append(pairs, [2]string{n, r})
+		case !isResults[n]:
+			rest = append(rest, n)
+		}
+	}
+	return pairs, rest
+}
+
+// withoutFlags drops the named string flags, with their values, from command-line args.
+func withoutFlags(args []string, names ...string) []string {
+	drop := map[string]bool{}
+	for _, n := range names {
+		drop[n] = true
+	}
+	var out []string
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || !drop[name] {
+			out = append(out, args[i])
+			continue
+		}
+		if !hasValue {
+			i++ // the value is the next argument
+		}
+	}
+	return out
+}
+
+// Exit statuses of the main command, for scripts and CI jobs.
+const (
+	exitOK       = 0
+	exitFailure  = 1 // a hard failure: the outputs were not all written
+	exitWarnings = 2 // the outputs were written, but with warnings or extraction problems
+)
+
+var (
+	warningsMu  sync.Mutex
+	runWarnings []string // every warning of this run, for the exit status and -summary-json
+)
+
+// warnf prints a warning on stderr and records it for the run's exit status and summary.
+func warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	warningsMu.Lock()
+	runWarnings = append(runWarnings, msg)
+	warningsMu.Unlock()
+	fmt.Fprintln(os.Stderr, "warning: "+msg)
+}
+
+// runCompleted reports whether a run of this binary that returned err wrote its outputs: it
+// exited with exitOK, or exitWarnings.
+func runCompleted(err error) bool {
+	var ee *exec.ExitError
+	return err == nil || errors.As(err, &ee) && ee.ExitCode() == exitWarnings
+}
+
+// runSummary is what -summary-json reports about a run that wrote its outputs.
+type runSummary struct {
+	Status            string   `json:"status"` // ok, warnings or failed (-strict)
+	ExitCode          int      `json:"exit_code"`
+	Outputs           []string `json:"outputs"`
+	Questions         int      `json:"questions"`
+	AnswersResolved   int      `json:"answers_resolved"`   // questions whose answer key was found
+	AnswersInferred   int      `json:"answers_inferred"`   // of those, keys inferred rather than confirmed
+	AnswersUnresolved int      `json:"answers_unresolved"` // graded questions left without a key
+	Warnings          []string `json:"warnings"`
+	Problems          []string `json:"problems"`         // extraction problems, as listed at the end of the run
+	Failed            []string `json:"failed,omitempty"` // -dir: the quizzes whose run failed
+}
+
+// count adds the questions of doc and how many of them got an answer key.
+func (s *runSummary) count(doc quizextract.QuizDoc) {
+	s.Questions += len(doc.Questions)
+	for _, q := range doc.Questions {
+		switch q.KeyConfidence {
+		case quizextract.KeyConfirmed:
+			s.AnswersResolved++
+		case quizextract.KeyInferred:
+			s.AnswersResolved++
+			s.AnswersInferred++
+		case quizextract.KeyUnknown:
+			s.AnswersUnresolved++
+		}
+	}
+}
+
+// finishRun ends a run whose outputs were written: it exits with exitWarnings when there
+// were warnings or problems, or exitFailure when failed (-strict with problems), after
+// writing s to summaryPath if set.
+func finishRun(summaryPath string, s runSummary, failed bool) {
+	warningsMu.Lock()
+	s.Warnings = append(s.Warnings, runWarnings...)
+	warningsMu.Unlock()
+	code := exitOK
+	switch {
+	case failed:
+		code = exitFailure
+	case len(s.Warnings) > 0 || len(s.Problems) > 0:
+		code = exitWarnings
+	}
+	if err := writeSummary(summaryPath, s, code); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write summary %s: %v\n", summaryPath, err)
+		os.Exit(exitFailure)
+	}
+	os.Exit(code)
+}
+
+// writeSummary writes s, for a run ending with exit status code, to path; nothing without a
+// path. Empty lists are written as [] rather than null.
+func writeSummary(path string, s runSummary, code int) error {
+	if path == "" {
+		return nil
+	}
+	s.Status = map[int]string{exitOK: "ok", exitWarnings: "warnings", exitFailure: "failed"}[code]
+	s.ExitCode = code
+	for _, l := range []*[]string{&s.Outputs, &s.Warnings, &s.Problems} {
+		if *l == nil {
+			*l = []string{}
+		}
+	}
+	b, _ := json.MarshalIndent(s, "", "  ")
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// runBatch renders every quiz/results pair in dir (see pairFiles) by running this binary
+// once per pair with the other flags of this run, up to jobs at once, then prints a summary.
+// Each run's output is held back and printed in pair order, so the log reads the same
+// whatever finishes first. It returns exitFailure if any pair failed or none was found, and
+// exitWarnings if any run had warnings. With summaryPath, the runs' summaries are added up
+// into one there.
+func runBatch(dir, pattern string, jobs int, args []string, summaryPath string) int {
+	if !strings.Contains(pattern, "{name}") {
+		fmt.Fprintf(os.Stderr, "-pair-pattern %q needs {name}, the quiz file name without its extension\n", pattern)
+		return 1
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && jsonExts[strings.ToLower(filepath.Ext(e.Name()))] {
+			names = append(names, e.Name())
+		}
+	}
+	pairs, rest := pairFiles(names, pattern)
+	if len(pairs) == 0 {
+		fmt.Fprintf(os.Stderr, "no quiz/results pairs in %s (results named %s)\n", dir, pattern)
+		return 1
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var summaries string // directory of the runs' -summary-json files
+	if summaryPath != "" {
+		if summaries, err = os.MkdirTemp("", "quiz-summary-"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(summaries)
+	}
+	type run struct {
+		stdout, stderr bytes.Buffer
+		err            error
+		done           chan struct{}
+	}
+	runs := make([]*run, len(pairs))
+	for i := range runs {
+		runs[i] = &run{done: make(chan struct{})}
+	}
+	go forEachJob(len(pairs), jobs, func(i int) {
+		p, r := pairs[i], runs[i]
+		defer close(r.done)
+		// "-dir=" keeps a -dir from a config profile from applying to the pair's run.
+		runArgs := append([]string{"-dir=", "-in", filepath.Join(dir, p[0]), "-results", filepath.Join(dir, p[1])}, args...)
+		if summaries != "" {
+			runArgs = append(runArgs, "-summary-json="+filepath.Join(summaries, fmt.Sprintf("%d.json", i)))
+		}
+		cmd := exec.Command(exe, runArgs...)
+		cmd.Stdout, cmd.Stderr = &r.stdout, &r.stderr
+		r.err = cmd.Run()
+	})
+	var failed []string
+	var total runSummary
+	warned := false
+	for i, p := range pairs {
+		r := runs[i]
+		<-r.done
+		os.Stdout.Write(r.stdout.Bytes())
+		os.Stderr.Write(r.stderr.Bytes())
+		if !runCompleted(r.err) {
+			fmt.Printf("FAIL %s + %s: %v\n", p[0], p[1], r.err)
+			failed = append(failed, p[0])
+			continue
+		}
+		if r.err != nil {
+			warned = true
+			fmt.Printf("ok   %s + %s (with warnings)\n", p[0], p[1])
+		} else {
+			fmt.Printf("ok   %s + %s\n", p[0], p[1])
+		}
+		var s runSummary
+		if summaries != "" && mustReadJSON(filepath.Join(summaries, fmt.Sprintf("%d.json", i)), &s) == nil {
+			total.Outputs = append(total.Outputs, s.Outputs...)
+			total.Questions += s.Questions
+			total.AnswersResolved += s.AnswersResolved
+			total.AnswersInferred += s.AnswersInferred
+			total.AnswersUnresolved += s.AnswersUnresolved
+			for _, w := range s.Warnings {
+				total.Warnings = append(total.Warnings, p[0]+": "+w)
+			}
+			for _, pr := range s.Problems {
+				total.Problems = append(total.Problems, p[0]+": "+pr)
+			}
+		}
+	}
+	// Other JSON files (notes, tags, ...) are expected; only quizzes left without results
+	// are worth a mention.
+	var unpaired []string
+	for _, n := range rest {
+		if b, err := os.ReadFile(filepath.Join(dir, n)); err == nil {
+			if fixed, _, err := recoverJSON(b); err == nil && quizextract.PayloadShape(fixed) == "quiz" {
+				unpaired = append(unpaired, n)
+			}
+		}
+	}
+	fmt.Printf("\n%d pair(s): %d written, %d failed\n", len(pairs), len(pairs)-len(failed), len(failed))
+	if len(failed) > 0 {
+		fmt.Printf("failed: %s\n", strings.Join(failed, ", "))
+	}
+	if len(unpaired) > 0 {
+		fmt.Printf("quizzes without results (%s): %s\n", pattern, strings.Join(unpaired, ", "))
+	}
+	code := exitOK
+	switch {
+	case len(failed) > 0:
+		code = exitFailure
+	case warned:
+		code = exitWarnings
+	}
+	total.Failed = failed
+	if err := writeSummary(summaryPath, total, code); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write summary %s: %v\n", summaryPath, err)
+		return exitFailure
+	}
+	return code
+}
+
+// watchInterval is how often -watch polls its inputs.
+var watchInterval = 500 * time.Millisecond
+
+// runWatch runs this binary with args, then again each time one of paths changes, until it is
+// interrupted. A directory changes when one of its quiz/results pairs (see pairFiles, with
+// pattern) is added, removed or rewritten; the documents and state files written next to them
+// do not count, or every run would start the next. A change is acted on once the files have
+// stayed the same for a poll, so a download the browser is still writing is not read
+// half-way. A failing run is reported and watching goes on.
+func runWatch(paths []string, pattern string, args []string) int {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	run := func() {
+		cmd := exec.Command(exe, args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); !runCompleted(err) {
+			fmt.Fprintf(os.Stderr, "watch: run failed: %v\n", err)
+		}
+	}
+	prev := watchSnapshot(paths, pattern)
+	run()
+	fmt.Fprintf(os.Stderr, "watch: waiting for changes to %s (Ctrl-C to stop)\n", strings.Join(paths, ", "))
+	for {
+		time.Sleep(watchInterval)
+		cur := watchSnapshot(paths, pattern)
+		if len(changedPaths(prev, cur)) == 0 {
+			continue
+		}
+		for {
+			time.Sleep(watchInterval)
+			next := watchSnapshot(paths, pattern)
+			if len(changedPaths(cur, next)) == 0 {
+				break
+			}
+			cur = next
+		}
+		fmt.Fprintf(os.Stderr, "watch: %s changed, regenerating\n", strings.Join(changedPaths(prev, cur), ", "))
+		prev = cur
+		run()
+	}
+}
+
+// watchSnapshot records the size and modification time of each path, and of the files of the
+// quiz/results pairs in each directory among them. Other files in a directory, such as a
+// JSON document or .quiz-manifest.json, are left out. A missing file is recorded as "", so
+// its reappearance counts.
+func watchSnapshot(paths []string, pattern string) map[string]string {
+	snap := map[string]string{}
+	stamp := func(fi os.FileInfo) string {
+		return fmt.Sprintf("%d %d", fi.Size(), fi.ModTime().UnixNano())
+	}
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		switch {
+		case err != nil:
+			snap[p] = ""
+		case !fi.IsDir():
+			snap[p] = stamp(fi)
+		default:
+			entries, _ := os.ReadDir(p)
+			var names []string
+			for _, e := range entries {
+				if e.Type().IsRegular() && jsonExts[strings.ToLower(filepath.Ext(e.Name()))] {
+					names = append(names, e.Name())
+				}
+			}
+			pairs, _ := pairFiles(names, pattern)
+			for _, pair := range pairs {
+				for _, n := range pair {
+					if info, err := os.Stat(filepath.Join(p, n)); err == nil {
+						snap[filepath.Join(p, n)] = stamp(info)
+					}
+				}
+			}
+		}
+	}
+	return snap
+}
+
+// changedPaths lists, sorted, the paths whose entries differ between two watchSnapshots.
+func changedPaths(prev, cur map[string]string) []string {
+	var out []string
+	for p, v := range cur {
+		if old, ok := prev[p]; !ok || old != v {
+			out = append(out, p)
+		}
+	}
+	for p := range prev {
+		if _, ok := cur[p]; !ok {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// fetchManifest lists the courses a fetch-all run refreshes.
+type fetchManifest struct {
+	Courses []fetchCourse `json:"courses"`
+}
+
+// fetchCourse is one course of a fetch manifest. Without Quizzes, every quiz of the course
+// is fetched.
+type fetchCourse struct {
+	CanvasURL   string      `json:"canvas_url"`
+	CourseID    any         `json:"course_id"`
+	TokenEnv    string      `json:"token_env"` // default CANVAS_TOKEN
+	LabelPrefix string      `json:"label_prefix"`
+	OutDir      string      `json:"out_dir"`
+	Quizzes     []fetchQuiz `json:"quizzes"`
+}
+
+// fetchQuiz is a quiz of a fetchCourse, with the results capture to render it with.
+type fetchQuiz struct {
+	ID      any    `json:"id"`
+	Results string `json:"results"`
+}
+
+// courseQuiz is an entry of a course's quiz list.
+type courseQuiz struct {
+	ID    any    `json:"id"`
+	Title string `json:"title"`
+}
+
+// fetchProgress is what an interrupted fetch-all run had done: the rendered quizzes and the
+// course quiz lists it downloaded, both keyed by fetchKey.
+type fetchProgress struct {
+	Done    []string                `json:"done"`
+	Quizzes map[string][]courseQuiz `json:"quizzes"`
+}
+
+// fetchKey identifies a course, or a quiz of it when quizID is given, in fetchProgress.
+func fetchKey(c fetchCourse, quizID string) string {
+	key := strings.TrimSuffix(c.CanvasURL, "/") + " " + quizextract.ClassicID(c.CourseID)
+	if quizID != "" {
+		key += " " + quizID
+	}
+	return key
+}
+
+// readFetchProgress reads a progress file; a missing file is no progress.
+func readFetchProgress(path string) (fetchProgress, error) {
+	var p fetchProgress
+	if err := mustReadJSON(path, &p); err != nil && !os.IsNotExist(err) {
+		return p, err
+	}
+	if p.Quizzes == nil {
+		p.Quizzes = map[string][]courseQuiz{}
+	}
+	return p, nil
+}
+
+// save writes the progress file.
+func (p fetchProgress) save(path string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// fetchLabel labels a fetched quiz "<prefix> <title>", dropping a trailing "Quiz" from the
+// title since the document heading adds one.
+func fetchLabel(prefix, title string) string {
+	title = strings.TrimSpace(title)
+	if n := len(title) - len(" quiz"); n > 0 && strings.EqualFold(title[n:], " quiz") {
+		title = title[:n]
+	}
+	return strings.TrimSpace(prefix + " " + title)
+}
+
+// fetchArgs are the command-line arguments that render one quiz of a course, followed by
+// the extra flags given to fetch-all.
+func fetchArgs(c fetchCourse, q fetchQuiz, title string, extra []string) []string {
+	args := []string{"-canvas-url", c.CanvasURL, "-course-id", quizextract.ClassicID(c.CourseID), "-quiz-id", quizextract.ClassicID(q.ID)}
+	if q.Results != "" {
+		args = append(args, "-results", q.Results)
+	}
+	if c.OutDir != "" {
+		args = append(args, "-out-dir", c.OutDir)
+	}
+	if c.LabelPrefix != "" && title != "" {
+		args = append(args, "-label-from", "flag", "-label", fetchLabel(c.LabelPrefix, title))
+	}
+	return append(args, extra...)
+}
+
+// runFetchAll renders every quiz of the courses in a manifest, running this binary once per
+// quiz. Flags after the manifest's are passed on to every run. A failing quiz is reported
+// and the rest still run; the exit status is 1 if any failed. Progress is kept in
+// <manifest>.progress so an interrupted run resumes with the quizzes it had not rendered,
+// without listing the courses again; the file is removed once a run has no failures.
+func runFetchAll(args []string) int {
+	fs := flag.NewFlagSet("fetch-all", flag.ExitOnError)
+	manifestPath := fs.String("manifest", "", "JSON file listing the courses to fetch.")
+	restart := fs.Bool("restart", false, "Ignore the progress of an interrupted run and fetch everything again.")
+	recordDir := fs.String("record", "", "Save every API response to this directory, for -replay; passed on to every quiz.")
+	replayDir := fs.String("replay", "", "Serve every fetch from the responses -record saved here; passed on to every quiz.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s fetch-all -manifest FILE [flags for every quiz...]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *manifestPath == "" {
+		fs.Usage()
+		return 2
+	}
+	var m fetchManifest
+	if err := mustReadJSON(*manifestPath, &m); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read manifest %s: %v\n", *manifestPath, err)
+		return 1
+	}
+	if err := useFixtures(*recordDir, *replayDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	extra := fs.Args()
+	if *recordDir != "" {
+		extra = append([]string{"-record", *recordDir}, extra...)
+	} else if *replayDir != "" {
+		extra = append([]string{"-replay", *replayDir}, extra...)
+	}
+	progressPath := *manifestPath + ".progress"
+	if *restart {
+		if err := os.Remove(progressPath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	progress, err := readFetchProgress(progressPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", progressPath, err)
+		return 1
+	}
+	if len(progress.Done) > 0 {
+		fmt.Printf("resuming: %d quiz(zes) already rendered (-restart to redo them)\n", len(progress.Done))
+	}
+	done := map[string]bool{}
+	for _, key := range progress.Done {
+		done[key] = true
+	}
+	// record saves the progress after each step, so an interruption loses at most one quiz.
+	record := func() {
+		if err := progress.save(progressPath); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to save progress to %s: %v\n", progressPath, err)
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	failed := 0
+	for i, c := range m.Courses {
+		name := fmt.Sprintf("course %s", quizextract.ClassicID(c.CourseID))
+		if c.CanvasURL == "" || quizextract.ClassicID(c.CourseID) == "" {
+			fmt.Printf("FAIL courses[%d]: canvas_url and course_id are required\n", i)
+			failed++
+			continue
+		}
+		env := c.TokenEnv
+		if env == "" {
+			env = "CANVAS_TOKEN"
+		}
+		token := os.Getenv(env)
+		if token == "" && *replayDir == "" {
+			fmt.Printf("FAIL %s: %s is not set\n", name, env)
+			failed++
+			continue
+		}
+		// The course's quiz list gives the titles, and the quizzes when none are listed.
+		quizzes, ok := progress.Quizzes[fetchKey(c, "")]
+		if !ok {
+			b, err := fetchCanvasList(strings.TrimSuffix(c.CanvasURL, "/")+"/api/quiz/v1/courses/"+url.PathEscape(quizextract.ClassicID(c.CourseID))+"/quizzes?per_page=100", token)
+			if err == nil {
+				err = json.Unmarshal(b, &quizzes)
+			}
+			if err != nil {
+				fmt.Printf("FAIL %s: %v\n", name, err)
+				failed++
+				continue
+			}
+			progress.Quizzes[fetchKey(c, "")] = quizzes
+			record()
+		}
+		titles := map[string]string{}
+		for _, q := range quizzes {
+			titles[quizextract.ClassicID(q.ID)] = q.Title
+		}
+		list := c.Quizzes
+		if len(list) == 0 {
+			for _, q := range quizzes {
+				list = append(list, fetchQuiz{ID: q.ID})
+			}
+		}
+		for _, q := range list {
+			key := fetchKey(c, quizextract.ClassicID(q.ID))
+			if done[key] {
+				continue
+			}
+			cmd := exec.Command(exe, fetchArgs(c, q, titles[quizextract.ClassicID(q.ID)], extra)...)
+			cmd.Env = append(os.Environ(), "CANVAS_TOKEN="+token)
+			cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+			if err := cmd.Run(); !runCompleted(err) {
+				fmt.Printf("FAIL %s quiz %s: %v\n", name, quizextract.ClassicID(q.ID), err)
+				failed++
+				continue
+			}
+			fmt.Printf("ok   %s quiz %s\n", name, quizextract.ClassicID(q.ID))
+			done[key] = true
+			progress.Done = append(progress.Done, key)
+			record()
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	if err := os.Remove(progressPath); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	return 0
+}
+
+//go:embed selftest/st01.json selftest/st01_result.json
+var selftestFiles embed.FS
+
+// selftestQuestions are the question texts of the embedded quiz, which every format must show.
+var selftestQuestions = []string{
+	"Which planet is known as the Red Planet?",
+	"Which of these are prime numbers?",
+	"degrees Celsius at sea level.",
+	"Which gas do plants absorb from the air?",
+}
+
+// selftestMarkdown are lines the default Markdown output of the embedded quiz must contain.
+var selftestMarkdown = []string{
+	"- Answer: Mars",
+	"  - Two (correct)",
+	"  - Seven (correct)",
+	"  - Blank 1: 100",
+	"- Answer: Carbon dioxide",
+}
+
+// runSelftest runs this binary over the embedded sample quiz in every -format and checks the
+// results: the inputs match the schemas, every question and correct answer is rendered, the
+// Markdown is the same on a second run, the HTML needs no repair, and the scores add up.
+// With -dir the inputs and outputs are kept there, for attaching to a bug report.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	dir := fs.String("dir", "", "Keep the sample inputs and the outputs in this directory instead of a temporary one.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s selftest [-dir DIR]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	work := *dir
+	if work == "" {
+		if work, err = os.MkdirTemp("", "quiz-selftest-"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(work)
+	} else if err := os.MkdirAll(work, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("selftest: %s/%s, %s\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+	inputs := map[string]string{"quiz": "st01.json", "results": "st01_result.json"}
+	for _, kind := range []string{"quiz", "results"} {
+		check("schema "+kind, func() error {
+			data, err := selftestFiles.ReadFile("selftest/" + inputs[kind])
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(work, inputs[kind]), data, 0o644); err != nil {
+				return err
+			}
+			schema, err := loadSchema(kind)
+			if err != nil {
+				return err
+			}
+			violations, err := validateJSON(schema, data)
+			if err == nil && len(violations) > 0 {
+				err = fmt.Errorf("%d problem(s), first: %s", len(violations), violations[0])
+			}
+			return err
+		}())
+	}
+	if failed > 0 {
+		return 1
+	}
+
+	// run renders the sample with extra flags into out and returns the document.
+	run := func(out string, extra ...string) (string, error) {
+		args := append([]string{"-in", filepath.Join(work, inputs["quiz"]), "-results", filepath.Join(work, inputs["results"]), "-out", filepath.Join(work, out)}, extra...)
+		if msg, err := exec.Command(exe, args...).CombinedOutput(); !runCompleted(err) {
+			return "", fmt.Errorf("%v: %s", err, bytes.TrimSpace(msg))
+		}
+		b, err := os.ReadFile(filepath.Join(work, out))
+		return string(b), err
+	}
+	formats := make([]string, 0, len(formatExtensions))
+	for f := range formatExtensions {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	for _, f := range formats {
+		check("format "+f, func() error {
+			doc, err := run("st01.out"+formatExtensions[f], "-format", f)
+			if err != nil {
+				return err
+			}
+			if f == "pdf" {
+				// The page content is compressed; check the file's framing instead.
+				if !strings.HasPrefix(doc, "%PDF-") || !strings.HasSuffix(strings.TrimSpace(doc), "%%EOF") {
+					return errors.New("output is not a PDF file")
+				}
+				return nil
+			}
+			if f == "docx" {
+				// The text is in the compressed word/document.xml part.
+				zr, err := zip.NewReader(strings.NewReader(doc), int64(len(doc)))
+				if err != nil {
+					return fmt.Errorf("output is not a docx file: %v", err)
+				}
+				part, err := zr.Open("word/document.xml")
+				if err != nil {
+					return fmt.Errorf("output is not a docx file: %v", err)
+				}
+				b, err := io.ReadAll(part)
+				if err != nil {
+					return err
+				}
+				doc = string(b)
+			}
+			for _, q := range selftestQuestions {
+				if !strings.Contains(doc, q) {
+					return fmt.Errorf("question %q missing", q)
+				}
+			}
+			if f == "html" {
+				if _, repaired := quizextract.RepairHTML(doc); repaired {
+					return errors.New("output is not well-formed HTML")
+				}
+			}
+			return nil
+		}())
+	}
+	templates, _ := builtinTemplates.ReadDir("templates")
+	for _, e := range templates {
+		name := templateName(e.Name())
+		check("template "+name, func() error {
+			doc, err := run("st01."+name+".out", "-template", name)
+			if err == nil && !strings.Contains(doc, "Questions and Solutions") {
+				err = errors.New("the document title is missing")
+			}
+			return err
+		}())
+	}
+	check("markdown answers", func() error {
+		doc, err := run("st01.md")
+		if err != nil {
+			return err
+		}
+		for _, line := range selftestMarkdown {
+			if !strings.Contains(doc, line+"\n") {
+				return fmt.Errorf("line %q missing", line)
+			}
+		}
+		again, err := run("st01.again.md")
+		if err == nil && again != doc {
+			err = errors.New("a second run produced different output")
+		}
+		return err
+	}())
+	check("scores", func() error {
+		if _, err := run("st01.scores.md", "-scores-csv", filepath.Join(work, "st01_scores.csv")); err != nil {
+			return err
+		}
+		b, err := os.ReadFile(filepath.Join(work, "st01_scores.csv"))
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(string(b), "total,,5,3,\n") {
+			return fmt.Errorf("want 3 of 5 points, got %q", b)
+		}
+		return nil
+	}())
+	if failed > 0 {
+		fmt.Printf("selftest: %d check(s) failed", failed)
+		if *dir != "" {
+			fmt.Printf("; inputs and outputs are in %s", work)
+		}
+		fmt.Println()
+		return 1
+	}
+	fmt.Println("selftest: all checks passed")
+	return 0
+}
+
+// ttsEngines are the built-in -tts backends: shell commands that read the script on stdin
+// and write a WAV file to $TTS_OUT. "cmd" runs -tts-cmd the same way, e.g. a cloud API call.
+var ttsEngines = map[string]string{
+	"espeak": `espeak-ng --stdin -w "$TTS_OUT"`,
+	"say":    `say -f - -o "$TTS_OUT" --file-format=WAVE --data-format=LEI16@22050`,
+}
+
+// audioFormats are the values -audio accepts; mp3 and ogg are encoded from WAV with ffmpeg.
+var audioFormats = []string{"mp3", "ogg", "wav"}
+
+// synthesize reads script aloud with engine (or command, for engine "cmd") into dest,
+// whose extension picks the format. Engines write WAV; other formats go through ffmpeg.
+func synthesize(script, engine, command, dest string) error {
+	if engine != "cmd" {
+		command = ttsEngines[engine]
+	}
+	wav := dest
+	if !strings.EqualFold(filepath.Ext(dest), ".wav") {
+		tmp, err := os.CreateTemp("", "quiz-tts-*.wav")
+		if err != nil {
+			return err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		wav = tmp.Name()
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Env = append(os.Environ(), "TTS_OUT="+wav)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", engine, err)
+	}
+	if wav == dest {
+		return nil
+	}
+	if msg, err := exec.Command("ffmpeg", "-loglevel", "error", "-y", "-i", wav, dest).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// reportQuiz is one quiz of a report: its label, e.g. "WK12", and solutions document.
+type reportQuiz struct {
+	Label string
+	Doc   quizextract.QuizDoc
+}
+
+// reportLabel labels a quiz of report or bank by its file name, e.g. "WK12".
+func reportLabel(quizPath string) string {
+	if label := detectLabel(quizPath, builtinLabelPatterns).Label; label != "" {
+		return label
+	}
+	return quizFilePrefix(quizPath)
+}
+
+// reportPairs lists the quiz/results pairs report's arguments name: every pair in a
+// directory (see pairFiles), or a quiz file with the results file pattern names beside it.
+func reportPairs(args []string, pattern string) ([][2]string, error) {
+	var pairs [][2]string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			name := strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg))
+			pairs = append(pairs, [2]string{arg, filepath.Join(filepath.Dir(arg), strings.ReplaceAll(pattern, "{name}", name))})
+			continue
+		}
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, e := range entries {
+			if e.Type().IsRegular() && jsonExts[strings.ToLower(filepath.Ext(e.Name()))] {
+				names = append(names, e.Name())
+			}
+		}
+		found, _ := pairFiles(names, pattern)
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no quiz/results pairs in %s (results named %s)", arg, pattern)
+		}
+		for _, p := range found {
+			pairs = append(pairs, [2]string{filepath.Join(arg, p[0]), filepath.Join(arg, p[1])})
+		}
+	}
+	return pairs, nil
+}
+
+// percent formats part of whole as a whole percentage, e.g. "77%".
+func percent(part, whole float64) string {
+	if whole == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", 100*part/whole)
+}
+
+// reportMarkdown is the report of several quizzes: the score of each, the accuracy per
+// question type (weakest first) and every question not answered for full points, with the
+// student's answer and the key. Questions without a score, such as survey items, are left out.
+func reportMarkdown(quizzes []reportQuiz) string {
+	type tally struct{ correct, scored int }
+	types := map[string]*tally{}
+	var sb, missed strings.Builder
+	var earned, possible float64
+	sb.WriteString("# Quiz report\n\n## Scores\n\n| Quiz | Score | Percent | Correct |\n| --- | --- | --- | --- |\n")
+	for _, rq := range quizzes {
+		var qe, qp float64
+		var t tally
+		var misses []string
+		for _, q := range rq.Doc.Questions {
+			c, ok := q.CheckResponse()
+			if !ok {
+				continue
+			}
+			qe += *q.Earned
+			qp += q.Possible
+			typ := q.Type
+			if typ == "" {
+				typ = "other"
+			}
+			if types[typ] == nil {
+				types[typ] = &tally{}
+			}
+			types[typ].scored++
+			t.scored++
+			if c.Correct {
+				types[typ].correct++
+				t.correct++
+				continue
+			}
+			miss := fmt.Sprintf("- %d) %s (%s)\n  - Your answer: %s\n", q.Number, q.Text, c.Score, c.Response)
+			if c.Key != "" {
+				miss += fmt.Sprintf("  - Correct answer: %s\n", c.Key)
+			}
+			misses = append(misses, miss)
+		}
+		earned += qe
+		possible += qp
+		sb.WriteString(fmt.Sprintf("| %s | %s / %s | %s | %d of %d |\n", quizextract.MarkdownCell(rq.Label), quizextract.FormatPoints(quizextract.RoundTo(qe, 2)), quizextract.FormatPoints(quizextract.RoundTo(qp, 2)), percent(qe, qp), t.correct, t.scored))
+		if len(misses) > 0 {
+			missed.WriteString(fmt.Sprintf("### %s\n\n%s\n", rq.Label, strings.Join(misses, "")))
+		}
+	}
+	sb.WriteString(fmt.Sprintf("| **Total** | %s / %s | %s | |\n\n", quizextract.FormatPoints(quizextract.RoundTo(earned, 2)), quizextract.FormatPoints(quizextract.RoundTo(possible, 2)), percent(earned, possible)))
+
+	names := make([]string, 0, len(types))
+	for n := range types {
+		names = append(names, n)
+	}
+	accuracy := func(n string) float64 { return float64(types[n].correct) / float64(types[n].scored) }
+	sort.Slice(names, func(i, j int) bool {
+		if a, b := accuracy(names[i]), accuracy(names[j]); a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+	sb.WriteString("## Accuracy by question type\n\n| Type | Correct | Accuracy |\n| --- | --- | --- |\n")
+	for _, n := range names {
+		t := types[n]
+		sb.WriteString(fmt.Sprintf("| %s | %d of %d | %s |\n", quizextract.MarkdownCell(n), t.correct, t.scored, percent(float64(t.correct), float64(t.scored))))
+	}
+	sb.WriteString("\n## Missed questions\n\n")
+	if missed.Len() == 0 {
+		sb.WriteString("None: every question was answered for full points.\n")
+	}
+	sb.WriteString(missed.String())
+	return sb.String()
+}
+
+// reportCSV is the report as one row per scored question, for spreadsheets: the quiz, the
+// question, its type, the score, whether it earned full points, and the answer and key.
+func reportCSV(quizzes []reportQuiz) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"quiz", "question", "type", "points_possible", "points_earned", "correct", "response", "answer", "text"})
+	for _, rq := range quizzes {
+		for _, q := range rq.Doc.Questions {
+			c, ok := q.CheckResponse()
+			if !ok {
+				continue
+			}
+			_ = w.Write([]string{rq.Label, strconv.Itoa(q.Number), q.Type, quizextract.FormatPoints(q.Possible), quizextract.FormatPoints(quizextract.RoundTo(*q.Earned, 2)), strconv.FormatBool(c.Correct), c.Response, c.Key, q.Text})
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// runReport summarizes the results of several quizzes (see reportMarkdown and reportCSV),
+// so revision can start with the weakest question types and the questions missed.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	pattern := fs.String("pair-pattern", "{name}_result.json", "Results file name of a quiz; {name} is the quiz file name without its extension.")
+	format := fs.String("format", "md", "Report format: md, or csv for one row per question.")
+	outPath := fs.String("out", "", "File to write the report to; standard output by default.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s report [flags] <dir|quiz.json>...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	inputs := parseInterspersed(fs, args)
+	if len(inputs) == 0 {
+		fs.Usage()
+		return 2
+	}
+	if !strings.Contains(*pattern, "{name}") {
+		fmt.Fprintf(os.Stderr, "-pair-pattern %q needs {name}, the quiz file name without its extension\n", *pattern)
+		return 1
+	}
+	if *format != "md" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "unknown -format %q (want md or csv)\n", *format)
+		return 1
+	}
+	pairs, err := reportPairs(inputs, *pattern)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var quizzes []reportQuiz
+	for _, p := range pairs {
+		doc, err := loadQuizDoc(p[0], p[1], os.Getenv("QUIZ_URL_TOKEN"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load %s: %v\n", p[0], err)
+			return 1
+		}
+		quizzes = append(quizzes, reportQuiz{Label: reportLabel(p[0]), Doc: doc})
+	}
+	var out []byte
+	if *format == "csv" {
+		if out, err = reportCSV(quizzes); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		out = []byte(reportMarkdown(quizzes))
+	}
+	if *outPath == "" {
+		os.Stdout.Write(out)
+		return 0
+	}
+	if err := os.WriteFile(*outPath, out, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Wrote a report of %d quiz(zes) to %s\n", len(quizzes), *outPath)
+	return 0
+}
+
+// serveMaxUpload caps the size of a serve upload form, both files together.
+const serveMaxUpload = 64 << 20
+
+// serveForm is the page the serve subcommand shows: the quiz and results files, which can
+// be dropped on their fields, and the format of the document to download. %s is the
+// format options.
+const serveForm = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Canvas Quiz Extractor</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+label { display: block; margin: 1em 0 .3em; font-weight: 600; }
+input[type=file] { display: block; width: 100%%; padding: 2em 1em; border: 2px dashed #999; border-radius: 6px; box-sizing: border-box; }
+button { margin-top: 1.5em; padding: .5em 1.5em; }
+</style>
+</head>
+<body>
+<h1>Canvas Quiz Extractor</h1>
+<form method="post" action="/convert" enctype="multipart/form-data">
+<label for="quiz">Quiz JSON (New Quizzes items or a Classic Quizzes export)</label>
+<input type="file" id="quiz" name="quiz" accept=".json,application/json" required>
+<label for="results">Results JSON (optional)</label>
+<input type="file" id="results" name="results" accept=".json,application/json">
+<label for="format">Format</label>
+<select id="format" name="format">
+%s</select>
+<button type="submit">Download</button>
+</form>
+</body>
+</html>
+`
+
+// runServe is the serve subcommand: a local web page where a quiz and its results can be
+// uploaded and the generated document downloaded, for people who don't use a terminal.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "Address to listen on; the default only accepts connections from this computer.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s serve [-addr host:port]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	fmt.Printf("Serving on http://%s/ (Ctrl-C to stop)\n", *addr)
+	if err := http.ListenAndServe(*addr, serveHandler()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// serveHandler serves the upload form at / and the generated documents at /convert.
+func serveHandler() http.Handler {
+	formats := make([]string, 0, len(formatExtensions))
+	for f := range formatExtensions {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	var options strings.Builder
+	for _, f := range formats {
+		selected := ""
+		if f == "md" {
+			selected = " selected"
+		}
+		fmt.Fprintf(&options, "<option value=\"%s\"%s>%s</option>\n", f, selected, f)
+	}
+	page := fmt.Sprintf(serveForm, options.String())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	})
+	mux.HandleFunc("/convert", serveConvert)
+	return mux
+}
+
+// serveConvert renders the uploaded quiz, and results if any, in the format asked for and
+// sends it back as a download named after the quiz file.
+func serveConvert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "upload the files from the form at /", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, serveMaxUpload)
+	if err := r.ParseMultipartForm(serveMaxUpload); err != nil {
+		http.Error(w, "couldn't read the upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.FormValue("format")
+	ext, ok := formatExtensions[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	quiz, name, err := formFile(r, "quiz")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if quiz == nil {
+		http.Error(w, "a quiz file is needed", http.StatusBadRequest)
+		return
+	}
+	results, _, err := formFile(r, "results")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	doc, err := uploadedQuizDoc(quiz, name, results)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read %s: %v", name, err), http.StatusBadRequest)
+		return
+	}
+	out, warnings, err := quizextract.Render(doc, quizextract.RenderOptions{
+		Format:         format,
+		Width:          80,
+		QuizizzSeconds: 30,
+		HTML:           quizextract.HTMLOptions{Theme: "light", CSSMode: "inline", Math: "cdn"},
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to render %s: %v", format, err), http.StatusInternalServerError)
+		return
+	}
+	for _, warn := range warnings {
+		slog.Warn(warn, "format", format, "file", name)
+	}
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	if base == "" {
+		base = "quiz"
+	}
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": base + ext}))
+	io.WriteString(w, out)
+}
+
+// formFile returns the contents and name of the file uploaded as field, or nil when none was.
+func formFile(r *http.Request, field string) ([]byte, string, error) {
+	f, h, err := r.FormFile(field)
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	return b, h.Filename, err
+}
+
+// uploadedQuizDoc builds the document of an uploaded quiz, named name, as the main command
+// would: a Classic Quizzes export with its submission if results holds one, a New Quizzes
+// quiz with its results, or a practice sheet of the quiz alone. Explanations come from the
+// default -explain sources.
+func uploadedQuizDoc(quiz []byte, name string, results []byte) (quizextract.QuizDoc, error) {
+	if name == "" {
+		name = "quiz.json"
+	}
+	title := func(subtitle string) string {
+		return docTitle(detectLabel(name, builtinLabelPatterns), name, builtinLabelPatterns, subtitle, "", nil)
+	}
+	classic, isClassic, err := quizextract.ParseClassicQuestions(quiz)
+	if err != nil {
+		return quizextract.QuizDoc{}, err
+	}
+	var doc quizextract.QuizDoc
+	switch {
+	case isClassic && results == nil:
+		doc = quizextract.BuildClassicDoc(classic, title("Questions and Solutions"))
+	case isClassic:
+		subs, ok, err := quizextract.ParseClassicSubmissions(results)
+		if err != nil {
+			return quizextract.QuizDoc{}, err
+		}
+		if !ok {
+			return quizextract.QuizDoc{}, errors.New("the results file isn't a Classic Quizzes submission")
+		}
+		doc = quizextract.BuildClassicSubmissionDoc(classic, subs, title("Questions and Solutions"))
+	default:
+		items, err := quizextract.DecodeQuizItems(quiz)
+		if err != nil {
+			return quizextract.QuizDoc{}, err
+		}
+		if results == nil {
+			return quizextract.BuildPracticeDoc(items, title("Practice Questions")), nil
+		}
+		res, err := quizextract.DecodeResults(results)
+		if err != nil {
+			return quizextract.QuizDoc{}, err
+		}
+		doc = quizextract.BuildQuizDoc(items, res, title("Questions and Solutions"))
+	}
+	quizextract.ApplyExplanations(&doc, quizextract.ExplainConfig{Sources: strings.Split(defaultExplain, ",")})
+	return doc, nil
+}
+
+// anonymizeDropped are the fields anonymize removes: contact details, account logins and
+// what a browser session reveals, none of which the extractor reads.
+var anonymizeDropped = map[string]bool{
+	"email": true, "primary_email": true, "login_id": true, "sis_user_id": true, "sis_login_id": true,
+	"integration_id": true, "lti_user_id": true, "avatar_url": true, "pronouns": true,
+	"ip_address": true, "remote_address": true, "user_agent": true,
+}
+
+// anonymizeNames are the fields holding a person's name, which anonymize replaces.
+var anonymizeNames = map[string]bool{
+	"author_name": true, "user_name": true, "student_name": true, "grader_name": true,
+	"sortable_name": true, "short_name": true,
+}
+
+// anonymizePeople are the fields holding a user object (or a list of them), whose id and
+// name anonymize replaces like a user_id and an author_name.
+var anonymizePeople = map[string]bool{
+	"user": true, "users": true, "author": true, "student": true, "grader": true,
+}
+
+// anonymizeIDClasses maps the ID fields anonymize hashes to the kind of object they name.
+// An ID is hashed with its kind, so a course ID and a user ID that happen to be equal don't
+// get the same hash, while the same course stays the same course in every file and URL.
+var anonymizeIDClasses = map[string]string{
+	"user_id": "user", "student_id": "user", "author_id": "user", "grader_id": "user",
+	"course_id": "course", "account_id": "account", "root_account_id": "account",
+	"course_section_id": "section", "section_id": "section", "enrollment_id": "enrollment",
+	"assignment_id": "assignment", "submission_id": "submission", "participant_id": "participant",
+	"quiz_session_id": "session", "quiz_submission_id": "submission",
+}
+
+// anonymizeURLSegments maps the Canvas URL path segments followed by an ID to its kind.
+var anonymizeURLSegments = map[string]string{
+	"courses": "course", "users": "user", "accounts": "account", "sections": "section",
+	"assignments": "assignment", "submissions": "submission", "enrollments": "enrollment",
+}
+
+// anonymizeHost replaces the host of every URL anonymize rewrites.
+const anonymizeHost = "canvas.example.com"
+
+var anonymizeURLRe = regexp.MustCompile(`https?://[^\s"'<>()]+`)
+
+// anonymizer scrubs Canvas JSON of the people and courses it names (see anonymize), counting
+// what it changed.
+type anonymizer struct {
+	Salt    string
+	IDs     int // IDs hashed
+	Removed int // fields removed
+	Names   int // names replaced
+	URLs    int // URLs rewritten
+}
+
+// hash is the stable stand-in for the ID v of the given kind: the same digits or characters
+// for the same ID, kind and salt, in every run.
+func (a *anonymizer) hash(kind, v string) string {
+	mac := hmac.New(sha256.New, []byte(a.Salt))
+	mac.Write([]byte(kind + ":" + v))
+	sum := mac.Sum(nil)
+	if _, err := strconv.ParseUint(v, 10, 64); err == nil {
+		// Numeric IDs stay numeric, in the range Canvas uses, so they decode as before.
+		n := uint64(0)
+		for _, c := range sum[:8] {
+			n = n<<8 | uint64(c)
+		}
+		return strconv.FormatUint(100000+n%900000000, 10)
+	}
+	return hex.EncodeToString(sum[:6])
+}
+
+// id hashes the ID value v, keeping its JSON type; null and empty IDs are left alone.
+func (a *anonymizer) id(kind string, v any) any {
+	switch x := v.(type) {
+	case json.Number:
+		a.IDs++
+		return json.Number(a.hash(kind, x.String()))
+	case string:
+		if x == "" {
+			return x
+		}
+		a.IDs++
+		return a.hash(kind, x)
+	}
+	return v
+}
+
+// name is the stand-in for a person's name s.
+func (a *anonymizer) name(s string) string {
+	if s == "" {
+		return s
+	}
+	a.Names++
+	return "User " + a.hash("name", s)
+}
+
+// text rewrites the URLs in s, including those of HTML attributes: the host becomes
+// anonymizeHost, IDs of courses, users and the like in the path are hashed, and the query,
+// which can hold file verifiers and tokens, is dropped.
+func (a *anonymizer) text(s string) string {
+	return anonymizeURLRe.ReplaceAllStringFunc(s, func(raw string) string {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return raw
+		}
+		segs := strings.Split(u.Path, "/")
+		for i := 1; i < len(segs); i++ {
+			if kind, ok := anonymizeURLSegments[segs[i-1]]; ok && segs[i] != "" && segs[i] != "self" {
+				segs[i] = a.hash(kind, segs[i])
+			}
+		}
+		a.URLs++
+		out := url.URL{Scheme: "https", Host: anonymizeHost, Path: strings.Join(segs, "/"), Fragment: u.Fragment}
+		return out.String()
+	})
+}
+
+// value anonymizes the decoded JSON v. person is set for the objects of a user field, whose
+// id is a user ID and whose name is a person's name.
+func (a *anonymizer) value(v any, person bool) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, fv := range x {
+			switch {
+			case anonymizeDropped[k]:
+				delete(x, k)
+				a.Removed++
+			case anonymizeNames[k] || person && (k == "name" || k == "display_name"):
+				if s, ok := fv.(string); ok {
+					x[k] = a.name(s)
+				}
+			case person && k == "id":
+				x[k] = a.id("user", fv)
+			case anonymizeIDClasses[k] != "":
+				x[k] = a.id(anonymizeIDClasses[k], fv)
+			default:
+				x[k] = a.value(fv, anonymizePeople[k])
+			}
+		}
+		return x
+	case []any:
+		for i, e := range x {
+			x[i] = a.value(e, person)
+		}
+		return x
+	case string:
+		return a.text(x)
+	}
+	return v
+}
+
+// anonymize returns the JSON document b with people and courses scrubbed (see value),
+// indented, with keys sorted so the same input always gives the same bytes. Everything the
+// extractor reads — items, choices, results and their IDs — is kept.
+func (a *anonymizer) anonymize(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(a.value(v, false)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runAnonymize is the anonymize subcommand: it writes copies of Canvas JSON files without
+// the students, instructors and courses they name, to share as samples or test fixtures.
+func runAnonymize(args []string) int {
+	fs := flag.NewFlagSet("anonymize", flag.ExitOnError)
+	outDir := fs.String("out-dir", "anonymized", "Directory to write the anonymized copies to, under the same file names.")
+	salt := fs.String("salt", os.Getenv("QUIZ_ANON_SALT"), "Secret mixed into the hashed IDs, so they can't be matched back by hashing every ID (default $QUIZ_ANON_SALT). Use the same salt to get the same hashes again.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s anonymize [flags] <file.json>...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	inputs := parseInterspersed(fs, args)
+	if len(inputs) == 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	a := &anonymizer{Salt: *salt}
+	for _, in := range inputs {
+		out := filepath.Join(*outDir, filepath.Base(in))
+		if same, _ := sameFile(in, out); same {
+			fmt.Fprintf(os.Stderr, "%s would overwrite itself; pick another -out-dir\n", in)
+			return 1
+		}
+		b, err := os.ReadFile(in)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		before := *a
+		clean, err := a.anonymize(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", in, err)
+			return 1
+		}
+		if err := writeFileAtomic(out, clean); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Wrote %s (%d ID(s) hashed, %d name(s) replaced, %d field(s) removed, %d URL(s) rewritten)\n",
+			out, a.IDs-before.IDs, a.Names-before.Names, a.Removed-before.Removed, a.URLs-before.URLs)
+	}
+	if *salt == "" {
+		fmt.Fprintln(os.Stderr, "warning: no -salt: numeric IDs can be matched back by hashing every candidate ID")
+	}
+	return 0
+}
+
+// sameFile reports whether paths a and b are the same existing file.
+func sameFile(a, b string) (bool, error) {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fa, fb), nil
+}
+
+// runBank merges the questions of several quizzes into one question bank (see
+// quizextract.BuildBank), so a question Canvas reuses from week to week is studied once,
+// with every answer known for it.
+func runBank(args []string) int {
+	fs := flag.NewFlagSet("bank", flag.ExitOnError)
+	pattern := fs.String("pair-pattern", "{name}_result.json", "Results file name of a quiz; {name} is the quiz file name without its extension.")
+	format := fs.String("format", "md", "Output format, as for the main command's -format.")
+	outPath := fs.String("out", "", "File to write the bank to; standard output by default.")
+	title := fs.String("title", "Question Bank", "Title of the bank document.")
+	levels := fs.String("difficulty", "", "Only include questions of these difficulties (comma-separated): easy (full points the first time), medium (only later) or hard (never).")
+	order := fs.String("sort", "first-seen", "Question order: first-seen (as they first appeared) or difficulty (hardest first).")
+	dbPath := fs.String("db", defaultBankDB, "Bank database file whose tags are added to the questions; skipped when missing.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s bank [flags] <dir|quiz.json>...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	inputs := parseInterspersed(fs, args)
+	if len(inputs) == 0 {
+		fs.Usage()
+		return 2
+	}
+	if _, ok := formatExtensions[*format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown -format %q\n", *format)
+		return 1
+	}
+	var keep []string
+	for _, l := range strings.Split(*levels, ",") {
+		if l = strings.TrimSpace(l); l == "" {
+			continue
+		}
+		if !slices.Contains(difficultyLevels, l) {
+			fmt.Fprintf(os.Stderr, "unknown -difficulty %q (want %s)\n", l, strings.Join(difficultyLevels, ", "))
+			return 1
+		}
+		keep = append(keep, l)
+	}
+	if *order != "first-seen" && *order != "difficulty" {
+		fmt.Fprintf(os.Stderr, "unknown -sort %q (want first-seen or difficulty)\n", *order)
+		return 1
+	}
+	bank, quizzes, err := loadBank(inputs, *pattern, *title)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	db, err := readBankDB(*dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	bank.ApplyTags(db.tags())
+	bank.FilterDifficulty(keep)
+	if *order == "difficulty" {
+		bank.SortByDifficulty()
+	}
+	out, warnings, err := quizextract.Render(bank, quizextract.RenderOptions{
+		Format:         *format,
+		Width:          80,
+		QuizizzSeconds: 30,
+		HTML:           quizextract.HTMLOptions{Theme: "light", CSSMode: "inline", Math: "cdn", OutPath: *outPath},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to render %s: %v\n", *format, err)
+		return 1
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", *format, w)
+	}
+	if *outPath == "" {
+		os.Stdout.WriteString(out)
+		return 0
+	}
+	if err := os.WriteFile(*outPath, []byte(out), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Wrote a bank of %d question(s) from %d quiz(zes) to %s\n", len(bank.Questions), quizzes, *outPath)
+	return 0
+}
+
+// runMockExam is the mock-exam subcommand: a practice exam drawn at random from the question
+// bank of the quizzes given, with its answer key in a separate file.
+func runMockExam(args []string) int {
+	fs := flag.NewFlagSet("mock-exam", flag.ExitOnError)
+	pattern := fs.String("pair-pattern", "{name}_result.json", "Results file name of a quiz; {name} is the quiz file name without its extension.")
+	format := fs.String("format", "md", "Output format, as for the main command's -format.")
+	n := fs.Int("n", 20, "Number of questions in the exam.")
+	seed := fs.Int64("seed", 0, "Seed of the random draw and shuffle; a new one each run when 0. The seed used is printed.")
+	outPath := fs.String("out", "", "File to write the exam to; mock-exam with the format's extension by default.")
+	keyPath := fs.String("key", "", "File to write the answer key to; the -out name with _key before its extension by default.")
+	title := fs.String("title", "Mock Exam", "Title of the exam.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s mock-exam [flags] <dir|quiz.json>...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	inputs := parseInterspersed(fs, args)
+	if len(inputs) == 0 {
+		fs.Usage()
+		return 2
+	}
+	ext, ok := formatExtensions[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown -format %q\n", *format)
+		return 1
+	}
+	if *n < 1 {
+		fmt.Fprintf(os.Stderr, "-n must be at least 1, got %d\n", *n)
+		return 1
+	}
+	if *outPath == "" {
+		*outPath = "mock-exam" + ext
+	}
+	if *keyPath == "" {
+		*keyPath = strings.TrimSuffix(*outPath, filepath.Ext(*outPath)) + "_key" + filepath.Ext(*outPath)
+	}
+	if *keyPath == *outPath {
+		fmt.Fprintf(os.Stderr, "-key and -out both name %s\n", *outPath)
+		return 1
+	}
+	bank, _, err := loadBank(inputs, *pattern, *title)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	exam, key := quizextract.MockExam(bank, *n, mathrand.New(mathrand.NewSource(*seed)), *title)
+	if len(exam.Questions) == 0 {
+		fmt.Fprintln(os.Stderr, "no question in the bank has a known answer to draw")
+		return 1
+	}
+	if len(exam.Questions) < *n {
+		fmt.Fprintf(os.Stderr, "note: only %d question(s) of the bank have a known answer; the exam has them all\n", len(exam.Questions))
+	}
+	for _, f := range []struct {
+		doc  quizextract.QuizDoc
+		path string
+	}{{exam, *outPath}, {key, *keyPath}} {
+		out, warnings, err := quizextract.Render(f.doc, quizextract.RenderOptions{
+			Format:         *format,
+			Width:          80,
+			QuizizzSeconds: 30,
+			HTML:           quizextract.HTMLOptions{Theme: "light", CSSMode: "inline", Math: "cdn", OutPath: f.path},
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to render %s: %v\n", *format, err)
+			return 1
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s: %s\n", *format, w)
+		}
+		if err := os.WriteFile(f.path, []byte(out), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	fmt.Printf("Wrote a mock exam of %d question(s) to %s and its key to %s (-seed %d)\n", len(exam.Questions), *outPath, *keyPath, *seed)
+	return 0
+}
+
+// runStudy is the study subcommand: a spaced-repetition session over the question bank of
+// the quizzes given, scheduled in the bank database.
+func runStudy(args []string) int {
+	fs := flag.NewFlagSet("study", flag.ExitOnError)
+	pattern := fs.String("pair-pattern", "{name}_result.json", "Results file name of a quiz; {name} is the quiz file name without its extension.")
+	dbPath := fs.String("db", defaultBankDB, "Bank database file holding the review schedule; created when missing.")
+	maxNew := fs.Int("new", 20, "Most questions never studied before to add in one session.")
+	dueOnly := fs.Bool("due", false, "Only print how many questions are due, without studying.")
+	plain := fs.Bool("plain", false, "Print the questions one after another, without clearing the screen.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s study [flags] <dir|quiz.json>...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	inputs := parseInterspersed(fs, args)
+	if len(inputs) == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *maxNew < 0 {
+		fmt.Fprintf(os.Stderr, "-new must not be negative, got %d\n", *maxNew)
+		return 1
+	}
+	bank, _, err := loadBank(inputs, *pattern, "Question Bank")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *dueOnly {
+		db, err := readBankDB(*dbPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		printDueCounts(os.Stdout, bank, db, time.Now())
+		return 0
+	}
+	if _, err := study(bank, *dbPath, os.Stdin, os.Stdout, !*plain && isTerminal(os.Stdin) && isTerminal(os.Stdout), time.Now(), *maxNew); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to update %s: %v\n", *dbPath, err)
+		return 1
+	}
+	return 0
+}
+
+// runTag is the tag subcommand: "tag add|remove <tags> ..." tags questions of the bank in
+// the bank database, and "tag list" shows the tagged ones.
+func runTag(args []string) int {
+	usage := func() {
+		name := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s tag add|remove <tag>[,<tag>...] [-id q-...] [-match text] [<dir|quiz.json>...]\n       %s tag list [-tag name] [-id q-...] [-match text] [<dir|quiz.json>...]\n", name, name)
+	}
+	if len(args) == 0 || (args[0] != "add" && args[0] != "remove" && args[0] != "list") {
+		usage()
+		return 2
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("tag "+action, flag.ExitOnError)
+	pattern := fs.String("pair-pattern", "{name}_result.json", "Results file name of a quiz; {name} is the quiz file name without its extension.")
+	dbPath := fs.String("db", defaultBankDB, "Bank database file holding the tags.")
+	ids := fs.String("id", "", "Question IDs to select (comma-separated), as in the anchors of the documents.")
+	match := fs.String("match", "", "Select the questions whose text or choices contain this text, ignoring case.")
+	var only *string
+	if action == "list" {
+		only = fs.String("tag", "", "Only list questions with one of these tags (comma-separated).")
+	}
+	fs.Usage = func() {
+		usage()
+		fs.PrintDefaults()
+	}
+	inputs := parseInterspersed(fs, args[1:])
+	var tags []string
+	if action != "list" {
+		if len(inputs) > 0 {
+			tags, inputs = splitList(inputs[0]), inputs[1:]
+		}
+		if len(tags) == 0 {
+			fs.Usage()
+			return 2
+		}
+		if *ids == "" && *match == "" {
+			fmt.Fprintf(os.Stderr, "tag %s needs -id or -match to select questions\n", action)
+			return 1
+		}
+	}
+	var bank quizextract.QuizDoc
+	if len(inputs) > 0 {
+		var err error
+		if bank, _, err = loadBank(inputs, *pattern, "Question Bank"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	db, err := readBankDB(*dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	picked, err := selectBankQuestions(bankQuestions(bank, db), splitList(*ids), *match)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if action == "list" {
+		listed := 0
+		for _, q := range picked {
+			c := db.Cards[q.ID]
+			if c == nil || len(c.Tags) == 0 || *only != "" && !slices.ContainsFunc(splitList(*only), func(t string) bool { return slices.Contains(c.Tags, t) }) {
+				continue
+			}
+			fmt.Println(tagLine(q.ID, c.Tags, q.Text))
+			listed++
+		}
+		if listed == 0 {
+			fmt.Println("no tagged questions")
+		}
+		return 0
+	}
+	if db, err = updateBankDB(*dbPath, func(db *bankDB) {
+		for _, q := range picked {
+			c := db.Cards[q.ID]
+			if c == nil {
+				c = &bankCard{}
+				db.Cards[q.ID] = c
+			}
+			c.Question = q.Text
+			for _, t := range tags {
+				if action == "add" && !slices.Contains(c.Tags, t) {
+					c.Tags = append(c.Tags, t)
+				}
+				if action == "remove" {
+					c.Tags = slices.DeleteFunc(c.Tags, func(have string) bool { return have == t })
+				}
+			}
+			if len(c.Tags) == 0 && c.Reviews == 0 {
+				delete(db.Cards, q.ID) // nothing left to remember
+			}
+		}
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to update %s: %v\n", *dbPath, err)
+		return 1
+	}
+	for _, q := range picked {
+		var now []string
+		if c := db.Cards[q.ID]; c != nil {
+			now = c.Tags
+		}
+		fmt.Println(tagLine(q.ID, now, q.Text))
+	}
+	return 0
+}
+
+// splitList splits a comma-separated flag value, trimming the items and dropping empty ones.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// bankQuestion is a question the tag subcommand can select: one of the bank, or one only
+// known from the bank database. Search is its text and choices, lowercased.
+type bankQuestion struct {
+	ID, Text, Search string
+}
+
+// bankQuestions lists the questions of bank in order, then those of db not in it, by ID.
+func bankQuestions(bank quizextract.QuizDoc, db bankDB) []bankQuestion {
+	var out []bankQuestion
+	seen := map[string]bool{}
+	for _, q := range bank.Questions {
+		text := strings.Join(strings.Fields(q.Text), " ")
+		search := []string{text}
+		for _, o := range q.Options {
+			search = append(search, o.Label)
+		}
+		out = append(out, bankQuestion{ID: q.ContentID, Text: text, Search: strings.ToLower(strings.Join(search, "\n"))})
+		seen[q.ContentID] = true
+	}
+	var rest []string
+	for id := range db.Cards {
+		if !seen[id] {
+			rest = append(rest, id)
+		}
+	}
+	sort.Strings(rest)
+	for _, id := range rest {
+		text := db.Cards[id].Question
+		out = append(out, bankQuestion{ID: id, Text: text, Search: strings.ToLower(text)})
+	}
+	return out
+}
+
+// selectBankQuestions picks the questions with one of ids or whose search text contains
+// match; with neither, it picks them all. An ID that isn't among qs is an error.
+func selectBankQuestions(qs []bankQuestion, ids []string, match string) ([]bankQuestion, error) {
+	if len(ids) == 0 && match == "" {
+		return qs, nil
+	}
+	for _, id := range ids {
+		if !slices.ContainsFunc(qs, func(q bankQuestion) bool { return q.ID == id }) {
+			return nil, fmt.Errorf("no question %s in the quizzes given or the bank database", id)
+		}
+	}
+	match = strings.ToLower(strings.TrimSpace(match))
+	var out []bankQuestion
+	for _, q := range qs {
+		if slices.Contains(ids, q.ID) || match != "" && strings.Contains(q.Search, match) {
+			out = append(out, q)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no question matches %q", match)
+	}
+	return out, nil
+}
+
+// tagLine shows a question's tags as "q-...  tag, tag  text", with the text cut short.
+func tagLine(id string, tags []string, text string) string {
+	if r := []rune(text); len(r) > 60 {
+		text = string(r[:59]) + "…"
+	}
+	label := "(no tags)"
+	if len(tags) > 0 {
+		label = strings.Join(tags, ", ")
+	}
+	return fmt.Sprintf("%s  %s  %s", id, label, text)
+}
+
+// parseInterspersed parses args with fs and returns the positional arguments. Flags may
+// come after them too, as in "report captures/ -out report.md".
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var inputs []string
+	for rest := args; ; rest = fs.Args()[1:] {
+		_ = fs.Parse(rest)
+		if fs.NArg() == 0 {
+			return inputs
+		}
+		inputs = append(inputs, fs.Arg(0))
+	}
+}
+
+// loadBank merges the quizzes of inputs, paired with their results by pattern as for
+// report, into a question bank titled title. It also returns the number of quizzes.
+func loadBank(inputs []string, pattern, title string) (quizextract.QuizDoc, int, error) {
+	if !strings.Contains(pattern, "{name}") {
+		return quizextract.QuizDoc{}, 0, fmt.Errorf("-pair-pattern %q needs {name}, the quiz file name without its extension", pattern)
+	}
+	pairs, err := reportPairs(inputs, pattern)
+	if err != nil {
+		return quizextract.QuizDoc{}, 0, err
+	}
+	var docs []quizextract.QuizDoc
+	var labels []string
+	for _, p := range pairs {
+		doc, err := loadQuizDoc(p[0], p[1], os.Getenv("QUIZ_URL_TOKEN"))
+		if err != nil {
+			return quizextract.QuizDoc{}, 0, fmt.Errorf("failed to load %s: %v", p[0], err)
+		}
+		docs = append(docs, doc)
+		labels = append(labels, reportLabel(p[0]))
+	}
+	return quizextract.BuildBank(docs, labels, title), len(docs), nil
+}
+
+// loadQuizDoc builds the solutions document for a quiz and its results the way the main
+// command does, with the default explanation sources applied. resultPath is not needed for
+// Classic Quizzes exports.
+func loadQuizDoc(quizPath, resultPath, token string) (quizextract.QuizDoc, error) {
+	data, name, err := readInput(quizPath, "quiz", token)
+	if err != nil {
+		return quizextract.QuizDoc{}, err
+	}
+	title := docTitle(detectLabel(name, builtinLabelPatterns), name, builtinLabelPatterns, "Practice", "", nil)
+	classic, isClassic, err := quizextract.ParseClassicQuestions(data)
+	if err != nil {
+		return quizextract.QuizDoc{}, err
+	}
+	var doc quizextract.QuizDoc
+	if isClassic {
+		doc = quizextract.BuildClassicDoc(classic, title)
+	} else {
+		quiz, err := quizextract.DecodeQuizItems(data)
+		if err != nil {
+			return quizextract.QuizDoc{}, err
+		}
+		if resultPath == "" {
+			return quizextract.QuizDoc{}, errors.New("-results is needed for the answer key of a New Quizzes quiz")
+		}
+		results, err := readResults(resultPath, token)
+		if err != nil {
+			return quizextract.QuizDoc{}, err
+		}
+		doc = quizextract.BuildQuizDoc(quiz, results, title)
+	}
+	quizextract.ApplyExplanations(&doc, quizextract.ExplainConfig{Sources: []string{"general", "correct"}})
+	return doc, nil
+}
+
+// runQuizMe runs a quiz as practice in the terminal: it asks every question, scores the
+// answers it can check and reviews all of them, with explanations, at the end.
+func runQuizMe(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	quizPath := fs.String("in", "", "Quiz JSON, Classic Quizzes export, .zip or https:// URL, as for the main command.")
+	resultPath := fs.String("results", "", "Results JSON holding the answer key (not needed for Classic Quizzes exports; taken from -in when it is a .zip).")
+	plain := fs.Bool("plain", false, "Print the questions one after another, without clearing the screen or giving feedback before the review.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s -in quiz.json [-results result.json]\n", filepath.Base(os.Args[0]), name)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *quizPath == "" {
+		fs.Usage()
+		return 2
+	}
+	if *resultPath == "" && bundledInput(*quizPath) {
+		*resultPath = *quizPath
+	}
+	doc, err := loadQuizDoc(*quizPath, *resultPath, os.Getenv("QUIZ_URL_TOKEN"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load %s: %v\n", *quizPath, err)
+		return 1
+	}
+	quizMe(doc, os.Stdin, os.Stdout, !*plain && isTerminal(os.Stdin) && isTerminal(os.Stdout))
+	return 0
+}
+
+// isTerminal reports whether f is a terminal rather than a file, pipe or the null device.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	if err != nil || st.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// The null device is a character device too, but no one types into it.
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(st, null)
+}
+
+// clearScreen moves the cursor home and clears a terminal (ANSI).
+const clearScreen = "\x1b[H\x1b[2J"
+
+// practiceAnswer is one question's outcome in a quizme session.
+type practiceAnswer struct {
+	Given   string
+	Scored  bool // the answer could be checked against the key
+	Correct bool
+}
+
+// quizMe asks the questions of doc on out, reading answers from in, then prints the score
+// and a review. Choice questions take letters ("B", or "A, C" when several are correct) and
+// blanks take text. Questions without a checkable key are shown and reviewed but not
+// scored. Typing q ends the session early; questions not reached count as skipped. With
+// screen, for a terminal, each question gets a cleared screen with the progress so far, and
+// its answer is checked right away, before moving on.
+func quizMe(doc quizextract.QuizDoc, in io.Reader, out io.Writer, screen bool) (correct, scored int) {
+	p := &practicePrompt{in: bufio.NewReader(in), out: out}
+	fmt.Fprintf(out, "%s\n%d questions. Type q to stop early.\n", doc.Title, len(doc.Questions))
+	answers := make([]practiceAnswer, len(doc.Questions))
+	asked := 0
+	for i, q := range doc.Questions {
+		asked++
+		if screen {
+			fmt.Fprintf(out, "%s%s\nQuestion %d of %d · %d of %d correct so far · q to stop\n", clearScreen, doc.Title, i+1, len(doc.Questions), correct, scored)
+		}
+		a := p.question(q)
+		if p.quit { // stopped partway through this question
+			asked--
+			break
+		}
+		if a.Scored {
+			scored++
+			if a.Correct {
+				correct++
+			}
+		}
+		answers[i] = a
+		if screen {
+			practiceFeedback(out, q, a)
+			if p.ask("\n   Press Enter to continue "); p.quit {
+				break
+			}
+		}
+	}
+
+	if screen {
+		fmt.Fprint(out, clearScreen+doc.Title+"\n")
+	}
+	fmt.Fprintf(out, "\nScore: %d of %d checked questions", correct, scored)
+	if scored > 0 {
+		fmt.Fprintf(out, " (%s%%)", quizextract.FormatPoints(quizextract.RoundTo(float64(correct)*100/float64(scored), 0)))
+	}
+	fmt.Fprintln(out)
+	if asked < len(doc.Questions) {
+		fmt.Fprintf(out, "Stopped after %d of %d questions.\n", asked, len(doc.Questions))
+	}
+	fmt.Fprintln(out, "\nReview")
+	for i, q := range doc.Questions[:asked] {
+		a := answers[i]
+		mark := "-"
+		switch {
+		case a.Scored && a.Correct:
+			mark = "✓"
+		case a.Scored:
+			mark = "✗"
+		}
+		fmt.Fprintf(out, "\n%s %d) %s\n", mark, q.Number, q.Text)
+		if a.Given != "" {
+			fmt.Fprintf(out, "   You answered: %s\n", a.Given)
+		}
+		if key := practiceKey(q); key != "" {
+			fmt.Fprintf(out, "   Correct answer: %s\n", key)
+		}
+		if q.Explanation != "" {
+			fmt.Fprintf(out, "   Why: %s\n", q.Explanation)
+		}
+	}
+	return correct, scored
+}
+
+// practicePrompt reads the answers of a practice session. Typing q, or the end of the
+// input, sets quit; every prompt after that returns "" without reading.
+type practicePrompt struct {
+	in   *bufio.Reader
+	out  io.Writer
+	quit bool
+}
+
+func (p *practicePrompt) ask(prompt string) string {
+	if p.quit {
+		return ""
+	}
+	fmt.Fprint(p.out, prompt)
+	line, err := p.in.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if err != nil && line == "" || strings.EqualFold(line, "q") {
+		p.quit = true
+		return ""
+	}
+	return line
+}
+
+// question shows q and reads the answer to it. Choice questions take letters and blanks
+// take text; questions without a checkable key only wait for Enter and are not scored.
+func (p *practicePrompt) question(q quizextract.Question) practiceAnswer {
+	out := p.out
+	fmt.Fprintf(out, "\n%d) %s\n", q.Number, q.Text)
+	var a practiceAnswer
+	switch {
+	case q.OpenEntry && !q.Ungraded && len(q.Blanks) > 0:
+		if len(q.WordBank) > 0 {
+			fmt.Fprintf(out, "   Word bank: %s\n", strings.Join(q.WordBank, ", "))
+		}
+		a.Scored, a.Correct = true, true
+		var given []string
+		for _, b := range q.Blanks {
+			resp := p.ask("   " + b.Label + ": ")
+			given = append(given, resp)
+			a.Scored = a.Scored && (b.Answer != "" || b.Pattern != "")
+			a.Correct = a.Correct && blankMatches(b, resp)
+		}
+		a.Given = strings.Join(given, "; ")
+	case len(q.Options) > 0 && !q.Ungraded && len(q.Answers) > 0:
+		for j, o := range q.Options {
+			fmt.Fprintf(out, "   %c. %s\n", 'A'+j, o.Label)
+		}
+		prompt := "   Your answer: "
+		if q.Multi {
+			prompt = "   Your answers (all that apply, e.g. A, C): "
+		}
+		a.Given = p.ask(prompt)
+		a.Scored = true
+		a.Correct = chosenLetters(a.Given, len(q.Options)) == correctLetters(q.Options)
+	default:
+		for j, o := range q.Options {
+			fmt.Fprintf(out, "   %c. %s\n", 'A'+j, o.Label)
+		}
+		a.Given = p.ask("   Think of your answer, then press Enter (not scored): ")
+	}
+	return a
+}
+
+// practiceFeedback tells whether answer a to q was correct, with the key when it wasn't
+// (or couldn't be checked) and the explanation.
+func practiceFeedback(out io.Writer, q quizextract.Question, a practiceAnswer) {
+	key := practiceKey(q)
+	switch {
+	case a.Scored && a.Correct:
+		fmt.Fprintln(out, "\n   ✓ Correct")
+	case a.Scored:
+		fmt.Fprintf(out, "\n   ✗ Correct answer: %s\n", key)
+	case key != "":
+		fmt.Fprintf(out, "\n   Answer: %s\n", key)
+	}
+	if q.Explanation != "" {
+		fmt.Fprintf(out, "   Why: %s\n", q.Explanation)
+	}
+}
+
+// defaultBankDB is where study keeps its schedule unless -db says otherwise.
+const defaultBankDB = "quiz-bank.json"
+
+// bankDB is the bank database: what is known about each bank question across runs, by the
+// question's content ID, so it follows the question from quiz to quiz.
+type bankDB struct {
+	Cards map[string]*bankCard `json:"cards"`
+}
+
+// bankCard is what the bank database knows of one question: the tags given to it and its
+// SM-2 review schedule, empty until it is first studied. Due and LastReview are dates
+// (YYYY-MM-DD) in local time.
+type bankCard struct {
+	Question    string   `json:"question"`
+	Tags        []string `json:"tags,omitempty"`
+	Ease        float64  `json:"ease,omitempty"`
+	Interval    int      `json:"interval_days,omitempty"`
+	Repetitions int      `json:"repetitions,omitempty"`
+	Due         string   `json:"due,omitempty"`
+	Reviews     int      `json:"reviews,omitempty"`
+	LastGrade   int      `json:"last_grade,omitempty"`
+	LastReview  string   `json:"last_reviewed,omitempty"`
+}
+
+// tags returns the tags of the database by question ID, as QuizDoc.ApplyTags takes them.
+func (db bankDB) tags() map[string][]string {
+	out := map[string][]string{}
+	for id, c := range db.Cards {
+		if len(c.Tags) > 0 {
+			out[id] = c.Tags
+		}
+	}
+	return out
+}
+
+// readBankDB reads the bank database at path; a missing file is an empty database.
+func readBankDB(path string) (bankDB, error) {
+	db := bankDB{Cards: map[string]*bankCard{}}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &db)
+	}
+	if err != nil {
+		return db, fmt.Errorf("%s: %v", path, err)
+	}
+	if db.Cards == nil {
+		db.Cards = map[string]*bankCard{}
+	}
+	return db, nil
+}
+
+// updateBankDB applies change to the bank database at path under its lock, rereading it
+// first so a concurrent run's changes are kept, and returns the database as written.
+func updateBankDB(path string, change func(*bankDB)) (bankDB, error) {
+	unlock, err := lockPath(path)
+	if err != nil {
+		return bankDB{}, err
+	}
+	defer unlock()
+	db, err := readBankDB(path)
+	if err != nil {
+		return db, err
+	}
+	change(&db)
+	b, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return db, err
+	}
+	return db, writeFileAtomic(path, append(b, '\n'))
+}
+
+// review schedules the card after a review graded 0 (no recall) to 5 (perfect), by SM-2:
+// a grade under 3 starts the card over at one day, and otherwise the interval goes 1, 6,
+// then grows by the ease factor, which itself moves with the grade and stays at least 1.3.
+func (c *bankCard) review(grade int, today time.Time) {
+	if c.Ease == 0 {
+		c.Ease = 2.5
+	}
+	if grade < 3 {
+		c.Repetitions, c.Interval = 0, 1
+	} else {
+		switch c.Repetitions {
+		case 0:
+			c.Interval = 1
+		case 1:
+			c.Interval = 6
+		default:
+			c.Interval = int(math.Round(float64(c.Interval) * c.Ease))
+		}
+		c.Repetitions++
+	}
+	c.Ease = math.Max(1.3, c.Ease+0.1-float64(5-grade)*(0.08+float64(5-grade)*0.02))
+	c.Reviews++
+	c.LastGrade = grade
+	c.LastReview = today.Format("2006-01-02")
+	c.Due = today.AddDate(0, 0, c.Interval).Format("2006-01-02")
+}
+
+// dueCards returns the indexes of the bank questions due on today: the reviews due by
+// then, longest overdue first, followed by up to maxNew questions never studied, in bank
+// order. Questions are matched to cards by content ID.
+func dueCards(bank quizextract.QuizDoc, db bankDB, today time.Time, maxNew int) (reviews, fresh []int) {
+	day := today.Format("2006-01-02")
+	for i, q := range bank.Questions {
+		switch c := db.Cards[q.ContentID]; {
+		case c == nil || c.Due == "":
+			if len(fresh) < maxNew {
+				fresh = append(fresh, i)
+			}
+		case c.Due <= day:
+			reviews = append(reviews, i)
+		}
+	}
+	sort.SliceStable(reviews, func(i, j int) bool {
+		return db.Cards[bank.Questions[reviews[i]].ContentID].Due < db.Cards[bank.Questions[reviews[j]].ContentID].Due
+	})
+	return reviews, fresh
+}
+
+// printDueCounts summarizes the schedule of the bank: what is due today, what comes due in
+// the next days, and how many questions have never been studied.
+func printDueCounts(out io.Writer, bank quizextract.QuizDoc, db bankDB, today time.Time) {
+	day := today.Format("2006-01-02")
+	tomorrow := today.AddDate(0, 0, 1).Format("2006-01-02")
+	week := today.AddDate(0, 0, 7).Format("2006-01-02")
+	var due, dueTomorrow, dueWeek, fresh int
+	for _, q := range bank.Questions {
+		switch c := db.Cards[q.ContentID]; {
+		case c == nil || c.Due == "":
+			fresh++
+		case c.Due <= day:
+			due++
+		case c.Due <= tomorrow:
+			dueTomorrow++
+			dueWeek++
+		case c.Due <= week:
+			dueWeek++
+		}
+	}
+	fmt.Fprintf(out, "Due today: %d\nDue tomorrow: %d\nDue in the next 7 days: %d\nNot studied yet: %d of %d\n", due, dueTomorrow, dueWeek, fresh, len(bank.Questions))
+}
+
+// study runs a spaced-repetition session over the due questions of bank: each is asked and
+// checked as in quizme, then graded 0-5 and rescheduled in the database at dbPath, which is
+// saved after every question so stopping early with q loses nothing. A wrong answer to a
+// checked question is graded 1; a right one asks how easy it was (3-5, 4 by default); one
+// that can't be checked is graded by the student. It returns the number of questions reviewed.
+func study(bank quizextract.QuizDoc, dbPath string, in io.Reader, out io.Writer, screen bool, today time.Time, maxNew int) (int, error) {
+	db, err := readBankDB(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	reviews, fresh := dueCards(bank, db, today, maxNew)
+	due := append(reviews, fresh...)
+	fmt.Fprintf(out, "%s\n%d due (%d to review, %d new). Type q to stop.\n", bank.Title, len(due), len(reviews), len(fresh))
+	p := &practicePrompt{in: bufio.NewReader(in), out: out}
+	reviewed := 0
+	for n, i := range due {
+		q := bank.Questions[i]
+		if screen {
+			fmt.Fprintf(out, "%s%s\nCard %d of %d · q to stop\n", clearScreen, bank.Title, n+1, len(due))
+		}
+		a := p.question(q)
+		if p.quit {
+			break
+		}
+		practiceFeedback(out, q, a)
+		grade := 1
+		if !a.Scored || a.Correct {
+			prompt, def := "\n   How well did you recall it? 3 hard, 4 good, 5 easy [4]: ", 4
+			if !a.Scored {
+				prompt, def = "\n   Grade your recall, 0 (blank) to 5 (perfect): ", -1
+			}
+			grade = askGrade(p, prompt, def)
+			if p.quit {
+				break
+			}
+		}
+		if db, err = updateBankDB(dbPath, func(db *bankDB) {
+			c := db.Cards[q.ContentID]
+			if c == nil {
+				c = &bankCard{}
+				db.Cards[q.ContentID] = c
+			}
+			c.Question = q.Text
+			c.review(grade, today)
+		}); err != nil {
+			return reviewed, err
+		}
+		reviewed++
+		fmt.Fprintf(out, "   Next review: %s\n", db.Cards[q.ContentID].Due)
+	}
+	if screen {
+		fmt.Fprint(out, clearScreen+bank.Title+"\n")
+	}
+	fmt.Fprintf(out, "\nReviewed %d of %d due.\n", reviewed, len(due))
+	printDueCounts(out, bank, db, today)
+	return reviewed, nil
+}
+
+// askGrade reads a grade from 0 to 5, asking again until it gets one; an empty answer is
+// def when def is a grade.
+func askGrade(p *practicePrompt, prompt string, def int) int {
+	for !p.quit {
+		answer := p.ask(prompt)
+		if answer == "" && def >= 0 && !p.quit {
+			return def
+		}
+		if g, err := strconv.Atoi(answer); err == nil && g >= 0 && g <= 5 {
+			return g
+		}
+	}
+	return 0
+}
+
+// chosenLetters normalizes a choice answer such as "c, a" to the sorted letters "AC",
+// ignoring separators and letters beyond the n options.
+func chosenLetters(answer string, n int) string {
+	seen := map[rune]bool{}
+	for _, r := range strings.ToUpper(answer) {
+		if r >= 'A' && r < 'A'+rune(n) {
+			seen[r] = true
+		}
+	}
+	var out []rune
+	for r := 'A'; r < 'A'+rune(n); r++ {
+		if seen[r] {
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
+
+// correctLetters returns the letters of the correct options, in order.
+func correctLetters(opts []quizextract.Option) string {
+	var out []rune
+	for i, o := range opts {
+		if o.Correct {
+			out = append(out, 'A'+rune(i))
+		}
+	}
+	return string(out)
+}
+
+// blankMatches reports whether resp fills blank b: it matches the grading pattern when there
+// is one, else any accepted answer, ignoring case and surrounding space.
+func blankMatches(b quizextract.BlankAnswer, resp string) bool {
+	resp = strings.TrimSpace(resp)
+	if b.Pattern != "" {
+		re, err := regexp.Compile(b.Pattern)
+		return err == nil && re.MatchString(resp)
+	}
+	for _, acc := range append([]string{b.Answer}, b.Accepted...) {
+		if acc != "" && strings.EqualFold(strings.TrimSpace(acc), resp) {
+			return true
+		}
+	}
+	return false
+}
+
+// practiceKey describes the correct answer of q for the quizme review; "" when unknown.
+func practiceKey(q quizextract.Question) string {
+	if q.OpenEntry && len(q.Blanks) > 0 {
+		var parts []string
+		for _, b := range q.Blanks {
+			ans := b.Answer
+			if ans == "" {
+				ans = "(unavailable)"
+			}
+			parts = append(parts, b.Label+": "+ans)
+		}
+		return strings.Join(parts, "; ")
+	}
+	var parts []string
+	for i, o := range q.Options {
+		if o.Correct {
+			parts = append(parts, fmt.Sprintf("%c. %s", 'A'+i, o.Label))
+		}
+	}
+	if len(parts) == 0 {
+		parts = q.Answers
+	}
+	return strings.Join(parts, "; ")
+}
+
+// indexEntry is one generated document listed in INDEX.md. Entries persist in
+// .quiz-index.json next to it, so each run adds to the listing instead of replacing it.
+type indexEntry struct {
+	Path      string   `json:"path"` // slash-separated, relative to the index directory
+	Title     string   `json:"title"`
+	Score     *float64 `json:"score,omitempty"` // nil when there are no scored results
+	Possible  float64  `json:"possible"`
+	Questions int      `json:"questions"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+const (
+	indexFile      = "INDEX.md"
+	indexStateFile = ".quiz-index.json"
+)
+
+// newIndexEntry describes the document written to path.
+func newIndexEntry(path string, doc quizextract.QuizDoc, st quizextract.QuizStats) indexEntry {
+	e := indexEntry{Path: path, Title: doc.Title, Possible: st.Possible, Questions: len(doc.Questions), Warnings: docWarnings(doc)}
+	if st.Students > 0 {
+		score := st.Score
+		e.Score = &score
+	}
+	return e
+}
+
+// docWarnings lists problems a reader of the document should know about.
+func docWarnings(doc quizextract.QuizDoc) []string {
+	var noResult, unavailable, repaired int
+	for _, q := range doc.Questions {
+		if q.Repaired {
+			repaired++
+		}
+		switch {
+		case !q.HasResult:
+			noResult++
+		case q.Ungraded || q.Essay:
+		case q.OpenEntry:
+			for _, b := range q.Blanks {
+				if b.Answer == "" {
+					unavailable++
+					break
+				}
+			}
+		case len(q.Answers) == 0:
+			unavailable++
+		}
+	}
+	var out []string
+	if noResult > 0 {
+		out = append(out, fmt.Sprintf("%d without result data", noResult))
+	}
+	if unavailable > 0 {
+		out = append(out, fmt.Sprintf("%d with answers unavailable", unavailable))
+	}
+	if repaired > 0 {
+		out = append(out, fmt.Sprintf("%d with malformed HTML repaired", repaired))
+	}
+	return out
+}
+
+// updateIndex merges entries (absolute paths) into the index state of dir, drops entries
+// whose documents no longer exist, and rewrites dir/INDEX.md. The state is locked while it
+// is updated, so runs writing to the same directory at once each keep the other's entries.
+func updateIndex(dir string, entries []indexEntry) error {
+	statePath := filepath.Join(dir, indexStateFile)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	unlock, err := lockPath(statePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var state []indexEntry
+	if b, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(b, &state); err != nil {
+			return fmt.Errorf("%s: %v", statePath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	byPath := map[string]indexEntry{}
+	for _, e := range state {
+		byPath[e.Path] = e
+	}
+	for _, e := range entries {
+		rel, err := filepath.Rel(dir, e.Path)
+		if err != nil {
+			return err
+		}
+		e.Path = filepath.ToSlash(rel)
+		byPath[e.Path] = e
+	}
+	state = state[:0]
+	for p, e := range byPath {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p))); err == nil {
+			state = append(state, e)
+		}
+	}
+	sort.Slice(state, func(i, j int) bool { return state[i].Path < state[j].Path })
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(statePath, append(b, '\n')); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, indexFile), []byte(renderIndex(state)))
+}
+
+// renderIndex renders the landing page listing every generated document.
+func renderIndex(entries []indexEntry) string {
+	var sb strings.Builder
+	sb.WriteString("# Quiz index\n\n")
+	if len(entries) == 0 {
+		sb.WriteString("No documents yet.\n")
+		return sb.String()
+	}
+	sb.WriteString("| Document | Title | Score | Questions | Warnings |\n| --- | --- | --- | --- | --- |\n")
+	for _, e := range entries {
+		score := "—"
+		if e.Score != nil {
+			score = fmt.Sprintf("%s / %s", quizextract.FormatPoints(math.Round(*e.Score*100)/100), quizextract.FormatPoints(e.Possible))
+			if e.Possible > 0 {
+				score += fmt.Sprintf(" (%.0f%%)", 100**e.Score/e.Possible)
+			}
+		}
+		warnings := "—"
+		if len(e.Warnings) > 0 {
+			warnings = strings.Join(e.Warnings, "; ")
+		}
+		link := (&url.URL{Path: e.Path}).String()
+		sb.WriteString(fmt.Sprintf("| [%s](%s) | %s | %s | %d | %s |\n", quizextract.MarkdownCell(e.Path), link, quizextract.MarkdownCell(e.Title), score, e.Questions, quizextract.MarkdownCell(warnings)))
+	}
+	return sb.String()
+}
+
+// manifestFile lists the outputs generated under an -out-dir with the inputs and options
+// that produced them, for the status and clean subcommands.
+const manifestFile = ".quiz-manifest.json"
+
+type manifestEntry struct {
+	Output      string            `json:"output"`   // slash-separated, relative to the manifest directory
+	Inputs      []provenanceInput `json:"inputs"`   // local paths are absolute
+	Options     []string          `json:"options"`  // command-line arguments, secrets removed
+	WorkDir     string            `json:"work_dir"` // where the command ran, for re-running Options
+	GeneratedAt string            `json:"generated_at"`
+}
+
+// secretFlags are left out of the options recorded in the manifest.
+var secretFlags = map[string]bool{"url-token": true, "token": true, "google-token": true, "confluence-token": true}
+
+// redactArgs drops secret flags and their values from command-line arguments.
+func redactArgs(args []string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if args[i] == name || args[i] == "--" {
+			out = append(out, args[i])
+			continue
+		}
+		name, _, hasValue := strings.Cut(name, "=")
+		if secretFlags[name] {
+			if !hasValue {
+				i++ // skip the separate value
+			}
+			continue
+		}
+		out = append(out, args[i])
+	}
+	return out
+}
+
+func readManifest(dir string) ([]manifestEntry, error) {
+	var entries []manifestEntry
+	b, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &entries)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, manifestFile), err)
+	}
+	return entries, nil
+}
+
+func writeManifest(dir string, entries []manifestEntry) error {
+	if entries == nil {
+		entries = []manifestEntry{}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Output < entries[j].Output })
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, manifestFile), append(b, '\n'))
+}
+
+// recordManifest adds or replaces the manifest entries of outputs (absolute paths). The
+// manifest is locked while it is updated, so runs writing to the same directory at once
+// each keep the other's entries.
+func recordManifest(dir string, outputs []string, inputs []provenanceInput, options []string, workDir string, now time.Time) error {
+	unlock, err := lockPath(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	for _, out := range outputs {
+		rel, err := filepath.Rel(dir, out)
+		if err != nil {
+			return err
+		}
+		e := manifestEntry{Output: filepath.ToSlash(rel), Inputs: inputs, Options: options, WorkDir: workDir, GeneratedAt: now.UTC().Format(time.RFC3339)}
+		replaced := false
+		for i := range entries {
+			if entries[i].Output == e.Output {
+				entries[i], replaced = e, true
+			}
+		}
+		if !replaced {
+			entries = append(entries, e)
+		}
+	}
+	return writeManifest(dir, entries)
+}
+
+// outputStatus is the state of one manifest entry: ok, stale (an input changed since the
+// output was generated), orphaned (an input is gone) or missing (the output is gone).
+type outputStatus struct {
+	Entry  manifestEntry
+	State  string
+	Detail string
+}
+
+// checkManifest compares every entry with the files on disk. URL inputs are not checked.
+func checkManifest(dir string, entries []manifestEntry) []outputStatus {
+	var out []outputStatus
+	for _, e := range entries {
+		st := outputStatus{Entry: e, State: "ok"}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(e.Output))); err != nil {
+			st.State, st.Detail = "missing", "output deleted"
+		}
+		for _, in := range e.Inputs {
+			if st.State == "orphaned" || in.SHA256 == "" {
+				continue
+			}
+			sum, err := hashPath(in.Path)
+			switch {
+			case err != nil:
+				st.State, st.Detail = "orphaned", fmt.Sprintf("%s %s is gone", in.Role, in.Path)
+			case sum != in.SHA256 && st.State == "ok":
+				st.State, st.Detail = "stale", fmt.Sprintf("%s %s changed", in.Role, in.Path)
+			}
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// manifestDir parses the -out-dir flag of the status and clean subcommands.
+func manifestDir(fs *flag.FlagSet, args []string) (string, []manifestEntry) {
+	dir := fs.String("out-dir", ".", "Output directory holding "+manifestFile+".")
+	_ = fs.Parse(args)
+	entries, err := readManifest(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return *dir, entries
+}
+
+// runStatus lists which outputs under -out-dir are up to date, stale, orphaned or missing.
+// It exits 1 when any output needs attention.
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	dir, entries := manifestDir(fs, args)
+	if len(entries) == 0 {
+		fmt.Printf("no generated outputs recorded in %s\n", dir)
+		return 0
+	}
+	code := 0
+	for _, st := range checkManifest(dir, entries) {
+		line := fmt.Sprintf("%-8s %s", st.State, st.Entry.Output)
+		if st.Detail != "" {
+			line += " (" + st.Detail + ")"
+		}
+		fmt.Println(line)
+		if st.State == "stale" || st.State == "missing" {
+			fmt.Printf("         re-run in %s: %s %s\n", st.Entry.WorkDir, filepath.Base(os.Args[0]), strings.Join(st.Entry.Options, " "))
+		}
+		if st.State != "ok" {
+			code = 1
+		}
+	}
+	return code
+}
+
+// runClean removes outputs whose inputs disappeared and drops manifest entries of
+// outputs that were deleted by hand.
+func runClean(args []string) int {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "List what would be removed without removing it.")
+	dir, entries := manifestDir(fs, args)
+	if len(entries) == 0 {
+		return 0
+	}
+	// Reread the manifest under the lock so entries a concurrent run adds are kept.
+	unlock, err := lockPath(filepath.Join(dir, manifestFile))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer unlock()
+	if entries, err = readManifest(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var kept []manifestEntry
+	for _, st := range checkManifest(dir, entries) {
+		switch st.State {
+		case "orphaned":
+			fmt.Printf("remove %s (%s)\n", st.Entry.Output, st.Detail)
+			if !*dryRun {
+				if err := os.Remove(filepath.Join(dir, filepath.FromSlash(st.Entry.Output))); err != nil && !os.IsNotExist(err) {
+					fmt.Fprintf(os.Stderr, "failed to remove %s: %v\n", st.Entry.Output, err)
+					return 1
+				}
+			}
+		case "missing":
+			fmt.Printf("forget %s (output deleted)\n", st.Entry.Output)
+		default:
+			kept = append(kept, st.Entry)
+		}
+	}
+	if *dryRun || len(kept) == len(entries) {
+		return 0
+	}
+	if err := writeManifest(dir, kept); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to update %s: %v\n", manifestFile, err)
+		return 1
+	}
+	return 0
+}
+
+// gitCommitMessage describes a regenerated document: the subject names it, and the body lists
+// each input with its hash so the history shows which capture every version came from.
+func gitCommitMessage(title string, prov provenance) string {
+	var b strings.Builder
+	b.WriteString("Update " + title + "\n\n")
+	for _, in := range prov.Inputs {
+		if in.SHA256 == "" {
+			fmt.Fprintf(&b, "%s: %s\n", in.Role, in.Path)
+		} else {
+			fmt.Fprintf(&b, "%s: %s (sha256 %s)\n", in.Role, filepath.Base(in.Path), in.SHA256)
+		}
+	}
+	return b.String()
+}
+
+// gitCommitOutputs commits files in the git repository containing the first one, leaving any
+// other staged changes alone. Files outside that repository are skipped. It reports false
+// when the files are unchanged since the last commit.
+func gitCommitOutputs(files []string, message string) (bool, error) {
+	top, err := runGit(filepath.Dir(files[0]), "rev-parse", "--show-toplevel")
+	if err != nil {
+		return false, fmt.Errorf("%s is not in a git repository: %v", files[0], err)
+	}
+	top, _ = filepath.EvalSymlinks(top)
+	var paths []string
+	for _, f := range files {
+		dir, _ := filepath.EvalSymlinks(filepath.Dir(f))
+		if rel, err := filepath.Rel(top, filepath.Join(dir, filepath.Base(f))); err == nil && !strings.HasPrefix(rel, "..") {
+			paths = append(paths, rel)
+		}
+	}
+	status, err := runGit(top, append([]string{"status", "--porcelain", "--"}, paths...)...)
+	if err != nil || status == "" {
+		return false, err
+	}
+	if _, err := runGit(top, append([]string{"add", "--"}, paths...)...); err != nil {
+		return false, err
+	}
+	_, err = runGit(top, append([]string{"commit", "-q", "-m", message, "--"}, paths...)...)
+	return err == nil, err
+}
+
+// runGit runs git in dir and returns its trimmed standard output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// remoteOutputSchemes are the -out URL schemes written with uploadOutput instead of to disk.
+var remoteOutputSchemes = []string{"s3", "gs", "webdav"}
+
+// remoteScheme returns the scheme of a remote -out target, or "" for a local path.
+func remoteScheme(dest string) string {
+	for _, s := range remoteOutputSchemes {
+		if strings.HasPrefix(dest, s+"://") {
+			return s
+		}
+	}
+	return ""
+}
+
+// writeOutput writes a generated document to a local path, creating its directory, or
+// uploads it when dest is a remote target (see uploadOutput).
+func writeOutput(dest string, data []byte, pub publishConfig) error {
+	if dest == stdio {
+		_, err := docStdout.Write(data)
+		return err
+	}
+	if remoteScheme(dest) != "" {
+		return uploadOutput(dest, data, pub)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(dest, data, 0o644)
+}
+
+// diffContext is the number of unchanged lines a unified diff shows around each change.
+const diffContext = 3
+
+// unifiedDiff returns a unified diff from a to b with the given file names in its header, or
+// "" when they are equal. Lines are matched by longest common subsequence after trimming the
+// common prefix and suffix, which keeps regrades of a long document cheap.
+func unifiedDiff(aName, bName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	al, bl := diffLines(a), diffLines(b)
+	pre := 0
+	for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+		suf++
+	}
+	am, bm := al[pre:len(al)-suf], bl[pre:len(bl)-suf]
+	// lcs[i][j] is the length of the longest common subsequence of am[i:] and bm[j:].
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	// ops holds one entry per output line: ' ', '-' or '+', with the line's text.
+	type op struct {
+		kind byte
+		text string
+	}
+	var ops []op
+	for _, l := range al[:pre] {
+		ops = append(ops, op{' ', l})
+	}
+	i, j := 0, 0
+	for i < len(am) || j < len(bm) {
+		switch {
+		case i < len(am) && j < len(bm) && am[i] == bm[j]:
+			ops = append(ops, op{' ', am[i]})
+			i, j = i+1, j+1
+		case j == len(bm) || i < len(am) && lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', am[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', bm[j]})
+			j++
+		}
+	}
+	for _, l := range al[len(al)-suf:] {
+		ops = append(ops, op{' ', l})
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+	aLine, bLine := 0, 0 // lines of a and b before ops[k]
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			aLine, bLine, k = aLine+1, bLine+1, k+1
+			continue
+		}
+		// A hunk runs from diffContext lines before this change to diffContext lines after
+		// the last change that is at most 2*diffContext unchanged lines from the previous one.
+		lo := max(k-diffContext, 0)
+		hi, gap := k, 0
+		for e := k; e < len(ops) && gap <= 2*diffContext; e++ {
+			if ops[e].kind == ' ' {
+				gap++
+			} else {
+				hi, gap = e+1, 0
+			}
+		}
+		hi = min(hi+diffContext, len(ops))
+		aStart, bStart := aLine-(k-lo), bLine-(k-lo)
+		var body strings.Builder
+		aCount, bCount := 0, 0
+		for _, o := range ops[lo:hi] {
+			if o.kind != '+' {
+				aCount++
+			}
+			if o.kind != '-' {
+				bCount++
+			}
+			body.WriteByte(o.kind)
+			body.WriteString(o.text)
+			if !strings.HasSuffix(o.text, "\n") {
+				body.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n%s", diffRange(aStart, aCount), diffRange(bStart, bCount), body.String())
+		aLine, bLine, k = aStart+aCount, bStart+bCount, hi
+	}
+	return sb.String()
+}
+
+// diffLines splits s after each newline; a last line without one is kept as is.
+func diffLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffRange formats a hunk's line range: the 1-based first line and the count, where an
+// empty range names the line before it.
+func diffRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// outputContentTypes maps output extensions to the Content-Type sent with uploads.
+var outputContentTypes = map[string]string{
+	".md":   "text/markdown; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+}
+
+// uploadOutput stores data at an s3://bucket/key, gs://bucket/object or webdav://host/path
+// target. Credentials come from the usual environment variables of each service.
+func uploadOutput(dest string, data []byte, pub publishConfig) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return fmt.Errorf("%s: want %s://<bucket or host>/<path>", dest, u.Scheme)
+	}
+	contentType := outputContentTypes[pathpkg.Ext(key)]
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	switch u.Scheme {
+	case "s3":
+		return putS3(u.Host, key, data, contentType)
+	case "gs":
+		token, err := googleAccessToken(pub, storageScope)
+		if err != nil {
+			return err
+		}
+		endpoint := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s", url.PathEscape(u.Host), url.QueryEscape(key))
+		return uploadBytes(http.MethodPost, endpoint, data, contentType, func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		})
+	default:
+		return putWebDAV("https://"+u.Host+"/"+key, data, contentType)
+	}
+}
+
+const storageScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// uploadBytes sends one upload request; auth adds credentials to it.
+func uploadBytes(method, endpoint string, data []byte, contentType string, auth func(*http.Request)) error {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	auth(req)
+	resp, err := publishClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+		return fmt.Errorf("%s %s: %s: %s", method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// putWebDAV uploads to a WebDAV server, creating missing parent collections first. It
+// authenticates with WEBDAV_USER/WEBDAV_PASSWORD, or WEBDAV_TOKEN as a bearer token.
+func putWebDAV(endpoint string, data []byte, contentType string) error {
+	auth := func(req *http.Request) {
+		if user := os.Getenv("WEBDAV_USER"); user != "" {
+			req.SetBasicAuth(user, os.Getenv("WEBDAV_PASSWORD"))
+		} else if token := os.Getenv("WEBDAV_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	dirs := strings.Split(strings.Trim(pathpkg.Dir(u.Path), "/"), "/")
+	for i := range dirs {
+		if dirs[i] == "" || dirs[i] == "." {
+			continue
+		}
+		coll := *u
+		coll.Path = "/" + strings.Join(dirs[:i+1], "/") + "/"
+		req, err := http.NewRequest("MKCOL", coll.String(), nil)
+		if err != nil {
+			return err
+		}
+		auth(req)
+		resp, err := publishClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// 405 Method Not Allowed means the collection already exists.
+		if resp.StatusCode != http.StatusMethodNotAllowed && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			return fmt.Errorf("MKCOL %s: %s", coll.Redacted(), resp.Status)
+		}
+	}
+	return uploadBytes(http.MethodPut, endpoint, data, contentType, auth)
+}
+
+// awsCredentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+type awsCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// putS3 uploads an object with a SigV4-signed PUT. AWS_REGION (or AWS_DEFAULT_REGION) picks
+// the region; AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL points at an S3-compatible server,
+// addressed path-style.
+func putS3(bucket, key string, data []byte, contentType string) error {
+	creds := awsCredentials{os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return errors.New("AWS credentials missing: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := "https://" + bucket + ".s3." + region + ".amazonaws.com/" + key
+	for _, env := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+		if e := os.Getenv(env); e != "" {
+			endpoint = strings.TrimRight(e, "/") + "/" + bucket + "/" + key
+			break
+		}
+	}
+	return uploadBytes(http.MethodPut, endpoint, data, contentType, func(req *http.Request) {
+		sum := sha256.Sum256(data)
+		signAWSv4(req, hex.EncodeToString(sum[:]), creds, region, "s3", time.Now())
+	})
+}
+
+// signAWSv4 adds a Signature Version 4 Authorization header covering Host and every header
+// already set on req. payloadHash is the hex SHA-256 of the body.
+func signAWSv4(req *http.Request, payloadHash string, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	values := map[string]string{"host": req.URL.Host}
+	for name, vs := range req.Header {
+		values[strings.ToLower(name)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	var segments []string
+	for _, seg := range strings.Split(req.URL.Path, "/") {
+		segments = append(segments, awsEscape(seg))
+	}
+	canonicalURI := strings.Join(segments, "/")
+	req.URL.RawPath = canonicalURI
+
+	canonicalRequest := strings.Join([]string{req.Method, canonicalURI, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := []byte("AWS4" + creds.SecretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKey, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, as SigV4 requires.
+func awsEscape(s string) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-' || b == '_' || b == '.' || b == '~' {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// archiveFile is one entry of an -archive bundle.
+type archiveFile struct {
+	Name string // slash-separated path inside the archive
+	Data []byte
+}
+
+// provenance records how an archived bundle was produced, so a study group can tell which
+// capture a document came from. It is stored as provenance.json.
+type provenance struct {
+	Tool          string            `json:"tool"`
+	GeneratedAt   string            `json:"generated_at"`
+	Format        string            `json:"format"`
+	OutputVersion int               `json:"output_version"`
+	Inputs        []provenanceInput `json:"inputs"`
+	Outputs       []string          `json:"outputs"`
+}
+
+type provenanceInput struct {
+	Role   string `json:"role"` // quiz, results, quiz-meta, ...
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"` // empty for URL inputs, which may change between fetches
+}
+
+// reproducibleTime is the timestamp -deterministic writes when SOURCE_DATE_EPOCH is unset:
+// the earliest time a zip entry can record.
+var reproducibleTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// outputTime is the generation time recorded in provenance, archive entries and the
+// manifest. SOURCE_DATE_EPOCH (seconds since 1970, the reproducible-builds convention) wins
+// when set; otherwise it is reproducibleTime under -deterministic and the current time if not.
+func outputTime(deterministic bool) (time.Time, error) {
+	if v := os.Getenv("SOURCE_DATE_EPOCH"); v != "" {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || sec < 0 {
+			return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH %q is not a count of seconds", v)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	if deterministic {
+		return reproducibleTime, nil
+	}
+	return time.Now(), nil
+}
+
+// newProvenance hashes every non-empty input path, keyed by role in the given order.
+func newProvenance(format string, version int, inputs [][2]string, now time.Time) (provenance, error) {
+	p := provenance{Tool: "canvas_quiz_extractor", GeneratedAt: now.UTC().Format(time.RFC3339), Format: format, OutputVersion: version}
+	for _, in := range inputs {
+		role, path := in[0], in[1]
+		if path == "" {
+			continue
+		}
+		if isURL(path) {
+			p.Inputs = append(p.Inputs, provenanceInput{Role: role, Path: path})
+			continue
+		}
+		sum, err := hashPath(path)
+		if err != nil {
+			return p, err
+		}
+		p.Inputs = append(p.Inputs, provenanceInput{Role: role, Path: path, SHA256: sum})
+	}
+	return p, nil
+}
+
+// hashPath hashes a file, or every *.json file of a directory in name order.
+func hashPath(path string) (string, error) {
+	if path == stdio {
+		b, err := readStdin()
+		return fmt.Sprintf("%x", sha256.Sum256(b)), err
+	}
+	files := []string{path}
+	if info, err := os.Stat(path); err != nil {
+		return "", err
+	} else if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return "", err
+		}
+		sort.Strings(files)
+	}
+	h := sha256.New()
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// writeArchive writes files as a zip, or as a gzipped tar when path ends in .tar.gz or .tgz,
+// with modified as the time of every entry.
+func writeArchive(path string, files []archiveFile, modified time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		for _, af := range files {
+			hdr := &tar.Header{Name: af.Name, Mode: 0o644, Size: int64(len(af.Data)), ModTime: modified}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(af.Data); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	zw := zip.NewWriter(f)
+	for _, af := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: af.Name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(af.Data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// archiveOutputs bundles the generated document, the stylesheet it links to (if any) and
+// provenance.json. Linked assets keep their path relative to the document when they sit
+// under its directory so links still resolve after extraction.
+func archiveOutputs(archivePath, docPath string, assets []string, prov provenance, modified time.Time) error {
+	var files []archiveFile
+	add := func(path string) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(path)
+		if rel, err := filepath.Rel(filepath.Dir(docPath), path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+		} else {
+			warnf("%s is outside the output directory; archived as %s, so links to it will not resolve", path, name)
+		}
+		files = append(files, archiveFile{Name: name, Data: b})
+		prov.Outputs = append(prov.Outputs, name)
+		return nil
+	}
+	for _, p := range append([]string{docPath}, assets...) {
+		if err := add(p); err != nil {
+			return err
+		}
+	}
+	meta, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return err
+	}
+	files = append(files, archiveFile{Name: "provenance.json", Data: append(meta, '\n')})
+	return writeArchive(archivePath, files, modified)
+}
+
+// pathsFlag is a repeatable path flag: the first path goes to path, repeats to more. For
+// -results they are one results file per attempt or regrade of the quiz; for -in, the pages
+// of one quiz export.
+type pathsFlag struct {
+	path *string
+	more *[]string
+}
+
+func (f pathsFlag) String() string { return "" }
+
+func (f pathsFlag) Set(v string) error {
+	if *f.path == "" {
+		*f.path = v
+	} else {
+		*f.more = append(*f.more, v)
+	}
+	return nil
+}
+
+// configFile is the optional JSON or YAML config file: named profiles of flag defaults, e.g. one per
+// course or Canvas instance. Profile keys are flag names without the dash. A secret flag
+// such as url-token is given as a reference, "url-token-env", naming the environment
+// variable that holds it, so tokens stay out of the file.
+type configFile struct {
+	DefaultProfile string                    `json:"default_profile"`
+	Profiles       map[string]map[string]any `json:"profiles"`
+}
+
+// configName is the config file looked for in the working directory, as JSON or, with a
+// .yaml or .yml extension instead, YAML.
+const configName = ".quizextractor.json"
+
+// findConfig returns the config file to read: explicit when set, else configName in the
+// working directory, else quizextractor/config.json in the user config directory, each also
+// tried as YAML. It returns "" when there is none.
+func findConfig(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	var candidates []string
+	add := func(jsonPath string) {
+		stem := strings.TrimSuffix(jsonPath, ".json")
+		candidates = append(candidates, jsonPath, stem+".yaml", stem+".yml")
+	}
+	add(configName)
+	if dir, err := os.UserConfigDir(); err == nil {
+		add(filepath.Join(dir, "quizextractor", "config.json"))
+	}
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// loadProfile reads the profile called name from the config file at path, or its
+// default_profile when name is empty. It returns nil when neither names a profile.
+func loadProfile(path, name string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		m, err := parseYAMLConfig(b)
+		if err != nil {
+			return nil, err
+		}
+		b, _ = json.Marshal(m)
+	}
+	var cfg configFile
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = cfg.DefaultProfile
+		if name == "" {
+			return nil, nil
+		}
+	}
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(cfg.Profiles))
+		for n := range cfg.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("no profile %q (have %s)", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// parseYAMLConfig reads the part of YAML a config file needs: nested block mappings of
+// plain, single- or double-quoted scalars, with comments. Scalars become strings, numbers
+// or booleans as in JSON. Sequences, flow collections, anchors and block scalars are
+// rejected rather than misread.
+func parseYAMLConfig(b []byte) (map[string]any, error) {
+	type frame struct {
+		indent int // of the key that opened the mapping; -1 for the document
+		m      map[string]any
+	}
+	root := map[string]any{}
+	stack := []frame{{-1, root}}
+	for n, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, " \r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+		fail := func(format string, a ...any) error {
+			return fmt.Errorf("line %d: %s", n+1, fmt.Sprintf(format, a...))
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fail("indent with spaces, not tabs")
+		}
+		indent := len(line) - len(content)
+		for indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		key, rest, found := strings.Cut(content, ":")
+		if strings.HasPrefix(content, "- ") || content == "-" {
+			return nil, fail("lists are not supported in config files")
+		}
+		if !found || rest != "" && rest[0] != ' ' {
+			return nil, fail("want key: value")
+		}
+		key = strings.TrimSpace(key)
+		if k, err := yamlScalar(key); err == nil {
+			if s, ok := k.(string); ok {
+				key = s
+			}
+		}
+		m := stack[len(stack)-1].m
+		if _, dup := m[key]; dup {
+			return nil, fail("%s is given twice", key)
+		}
+		value := strings.TrimSpace(yamlUncomment(rest))
+		if value == "" {
+			child := map[string]any{}
+			m[key] = child
+			stack = append(stack, frame{indent, child})
+			continue
+		}
+		v, err := yamlScalar(value)
+		if err != nil {
+			return nil, fail("%s: %v", key, err)
+		}
+		m[key] = v
+	}
+	return root, nil
+}
+
+// yamlUncomment drops a trailing " # comment" from a value, outside quotes.
+func yamlUncomment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// yamlScalar converts a YAML scalar to the JSON value it stands for.
+func yamlScalar(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, errors.New("unterminated string")
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.ContainsAny(s[:1], "[{&*|>!%@`"):
+		return nil, fmt.Errorf("%q is not supported in config files; quote the value", s[:1])
+	}
+	switch strings.ToLower(s) {
+	case "true", "yes":
+		return true, nil
+	case "false", "no":
+		return false, nil
+	case "null", "~":
+		return nil, errors.New("null is not a flag value")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
+
+// applyProfile sets every flag of fs named in profile that was not given on the command
+// line, so explicit flags always win. Secret flags are read from the environment variable
+// named by their "-env" key and are left unset when it is empty.
+func applyProfile(fs *flag.FlagSet, profile map[string]any) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	keys := make([]string, 0, len(profile))
+	for k := range profile {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var value string
+		switch v := profile[key].(type) {
+		case string:
+			value = v
+		case bool:
+			value = strconv.FormatBool(v)
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("%s: want a string, number or boolean", key)
+		}
+		name := key
+		if secret := strings.TrimSuffix(key, "-env"); secret != key && secretFlags[secret] {
+			name, value = secret, os.Getenv(value)
+		} else if secretFlags[key] {
+			return fmt.Errorf("%s: store the name of an environment variable as %s-env instead of the token", key, key)
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: no such flag", key)
+		}
+		if explicit[name] || value == "" && name != key {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// resolveInput finds a relative input path that is not a local file in inputDir, or else
+// turns it into a URL under baseURL, so a profile can read every week's captures from one
+// place.
+func resolveInput(p, inputDir, baseURL string) string {
+	if p == "" || p == stdio || isURL(p) || filepath.IsAbs(p) {
+		return p
+	}
+	if _, err := os.Stat(p); err == nil {
+		return p
+	}
+	if inputDir != "" {
+		q := filepath.Join(inputDir, p)
+		if _, err := os.Stat(q); err == nil || baseURL == "" {
+			return q
+		}
+	}
+	if baseURL == "" {
+		return p
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + filepath.ToSlash(p)
+}
+
+// builtinTemplates holds the templates -template can name without a file.
+//
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// templateName is the name -template knows a template file by: its file name up to the
+// first dot, e.g. flashcards for flashcards.md.tmpl.
+func templateName(file string) string {
+	name, _, _ := strings.Cut(filepath.Base(file), ".")
+	return name
+}
+
+// findTemplate resolves -template: a template file, else the name of a template in dir,
+// which overrides a built-in of the same name, else the name of a built-in. It returns the
+// template's source and file name.
+func findTemplate(ref, dir string) ([]byte, string, error) {
+	b, err := os.ReadFile(ref)
+	if err == nil || !os.IsNotExist(err) || strings.ContainsAny(ref, `/\`) || filepath.Ext(ref) != "" {
+		return b, ref, err
+	}
+	var names []string
+	seen := map[string]bool{}
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			if templateName(e.Name()) == ref {
+				b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+				return b, e.Name(), err
+			}
+			if name := templateName(e.Name()); !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	entries, _ := builtinTemplates.ReadDir("templates")
+	for _, e := range entries {
+		if templateName(e.Name()) == ref {
+			b, err := builtinTemplates.ReadFile("templates/" + e.Name())
+			return b, e.Name(), err
+		}
+		if name := templateName(e.Name()); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return nil, "", fmt.Errorf("no template file or template named %q (have %s)", ref, strings.Join(names, ", "))
+}
+
+// loadTemplate parses the -template named by ref (see findTemplate) and returns it with the
+// extension of the documents it writes: its own, or the one before .tmpl (notes.html.tmpl
+// writes .html), else .md.
+func loadTemplate(ref, dir string) (*template.Template, string, error) {
+	b, file, err := findTemplate(ref, dir)
+	if err != nil {
+		return nil, "", err
+	}
+	t, err := quizextract.ParseTemplate(filepath.Base(file), string(b))
+	if err != nil {
+		return nil, "", err
+	}
+	ext := filepath.Ext(strings.TrimSuffix(filepath.Base(file), ".tmpl"))
+	if ext == "" {
+		ext = ".md"
+	}
+	return t, ext, nil
+}
+
+// formatExtensions maps each -format value to the extension of derived output names.
+var formatExtensions = map[string]string{
+	"md":        ".md",
+	"html":      ".html",
+	"mediawiki": ".wiki",
+	"rst":       ".rst",
+	"adoc":      ".adoc",
+	"txt":       ".txt",
+	"quizizz":   ".csv",
+	"anki":      ".tsv",
+	"json":      ".json",
+	"pdf":       ".pdf",
+	"docx":      ".docx",
+	"csv":       ".csv",
+}
+
+// imageFetcher downloads the images of question bodies for -format pdf. The token is only
+// sent to the host of base, the Canvas instance, never to third-party image hosts.
+func imageFetcher(base, token string) func(string) ([]byte, error) {
+	host := ""
+	if u, err := url.Parse(base); err == nil {
+		host = u.Host
+	}
+	return func(src string) ([]byte, error) {
+		t := ""
+		if u, err := url.Parse(src); err == nil && host != "" && u.Host == host {
+			t = token
+		}
+		return fetchInput(src, t)
+	}
+}
+
+// assetExt returns the file extension for a downloaded image, from its content or, for SVG,
+// which has no signature, from the URL. ok is false for anything else, such as the login
+// page Canvas serves in place of a file when the token is missing.
+func assetExt(src string, data []byte) (ext string, ok bool) {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return ".png", true
+	case "image/jpeg":
+		return ".jpg", true
+	case "image/gif":
+		return ".gif", true
+	case "image/webp":
+		return ".webp", true
+	case "image/bmp":
+		return ".bmp", true
+	}
+	if u, err := url.Parse(src); err == nil && strings.EqualFold(pathpkg.Ext(u.Path), ".svg") && bytes.Contains(data, []byte("<svg")) {
+		return ".svg", true
+	}
+	return "", false
+}
+
+// assetSources is the file in an -assets directory that maps each downloaded URL to its
+// copy, so later runs, and other quizzes sharing the directory, reuse it without downloading.
+const assetSources = "sources.json"
+
+// canvasFile is the part of a Canvas file object (GET /api/v1/files/:id) -assets needs.
+type canvasFile struct {
+	URL      string `json:"url"` // download URL, signed for a limited time
+	Filename string `json:"filename"`
+}
+
+// newLogger returns the logger of -v and -debug, writing to w in logFormat (text or json).
+// Without either flag only warnings and errors pass, and nothing logs at those levels yet:
+// warnings stay plain "warning:" lines.
+func newLogger(w io.Writer, verbose, debug bool, logFormat string) (*slog.Logger, error) {
+	level := slog.LevelWarn
+	switch {
+	case debug:
+		level = slog.LevelDebug
+	case verbose:
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("-log-format must be text or json, not %q", logFormat)
+}
+
+// forEachJob calls work(i) for every i below n on up to jobs goroutines at once and returns
+// when all calls have. Callers keep output deterministic by storing results at index i.
+func forEachJob(n, jobs int, work func(i int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				work(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// downloadAssets saves a copy of every image in doc into assetsDir for -assets and returns
+// QuizDoc.Assets, with paths relative to docDir, and the files written. Files are named by a
+// hash of their content, so quizzes sharing the directory share copies; a URL already listed
+// in its assetSources is not downloaded again. Relative sources are resolved against base;
+// data: URLs are already self-contained and are skipped. With api, the Canvas URL in API
+// mode, links to files on Canvas are saved too, and every Canvas file is downloaded through
+// the Files API rather than from its expiring link. Up to jobs downloads run at once. An
+// image or file that cannot be downloaded keeps its link, with a warning.
+func downloadAssets(doc quizextract.QuizDoc, assetsDir, docDir, base, api string, jobs int, fetch func(string) ([]byte, error)) (map[string]string, []string, error) {
+	rel, err := filepath.Rel(docDir, assetsDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	sourcesPath := filepath.Join(assetsDir, assetSources)
+	sources, err := readAssetSources(sourcesPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	baseURL, _ := url.Parse(base)
+	apiURL, _ := url.Parse(api)
+	srcs := quizextract.ImageSources(doc)
+	images := len(srcs)
+	if api != "" {
+		srcs = append(srcs, quizextract.FileLinks(doc)...)
+	}
+
+	// Work out what each source is first, so the downloads can run side by side.
+	type asset struct {
+		src, kind string
+		key, meta string // key names the asset in sources; meta is its Files API URL, if any
+		filename  string
+		name      string // file name in assetsDir, once known
+		warning   string
+	}
+	var todo []*asset
+	for i, src := range srcs {
+		kind := "image"
+		if i >= images {
+			kind = "file"
+		}
+		u, err := url.Parse(src)
+		if err != nil || u.Scheme == "data" {
+			continue
+		}
+		if baseURL != nil && baseURL.IsAbs() {
+			u = baseURL.ResolveReference(u)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			warnf("%s %s not downloaded: relative URL (set -link-base or -canvas-url)", kind, src)
+			continue
+		}
+		// A Canvas file is known by its id, as its links carry a verifier that changes.
+		a := &asset{src: src, kind: kind, key: u.String(), filename: u.Path}
+		if id := quizextract.CanvasFileID(a.key); id != "" && apiURL != nil && apiURL.Host != "" && u.Host == apiURL.Host {
+			a.key = strings.TrimSuffix(api, "/") + "/api/v1/files/" + url.PathEscape(id)
+			a.meta = a.key
+		} else if kind == "file" {
+			continue // on another site, so not a Canvas file
+		}
+		if name := sources[a.key]; name != "" {
+			if _, err := os.Stat(filepath.Join(assetsDir, name)); err == nil {
+				a.name = name
+			}
+		}
+		todo = append(todo, a)
+	}
+
+	// The same URL can appear more than once; it is downloaded once.
+	var fetches []*asset
+	first := map[string]*asset{}
+	for _, a := range todo {
+		if a.name == "" && first[a.key] == nil {
+			first[a.key] = a
+			fetches = append(fetches, a)
+		}
+	}
+	forEachJob(len(fetches), jobs, func(i int) {
+		a := fetches[i]
+		from := a.key
+		if a.meta != "" {
+			var f canvasFile
+			b, err := fetch(a.meta)
+			if err == nil {
+				err = json.Unmarshal(b, &f)
+			}
+			if err == nil && f.URL == "" {
+				err = errors.New("the Files API returned no download URL")
+			}
+			if err != nil {
+				a.warning = fmt.Sprintf("%s %s not downloaded: %v", a.kind, a.src, err)
+				return
+			}
+			from, a.filename = f.URL, f.Filename
+		}
+		data, err := fetch(from)
+		if err != nil {
+			a.warning = fmt.Sprintf("%s %s not downloaded: %v", a.kind, a.src, err)
+			return
+		}
+		ext := strings.ToLower(pathpkg.Ext(a.filename))
+		if a.kind == "image" {
+			var ok bool
+			if ext, ok = assetExt(a.filename, data); !ok {
+				a.warning = fmt.Sprintf("image %s not downloaded: the response is %s, not an image (is the token missing?)", a.src, http.DetectContentType(data))
+				return
+			}
+		}
+		name := fmt.Sprintf("%x", sha256.Sum256(data))[:16] + ext
+		err = os.MkdirAll(assetsDir, 0o755)
+		if err == nil {
+			err = writeFileAtomic(filepath.Join(assetsDir, name), data)
+		}
+		if err != nil {
+			a.warning = fmt.Sprintf("%s %s not saved: %v", a.kind, a.src, err)
+			return
+		}
+		a.name = name
+	})
+
+	assets := map[string]string{}
+	added := map[string]string{}
+	saved := map[string]bool{}
+	var files []string
+	for _, a := range todo {
+		if f := first[a.key]; f != nil && f != a {
+			a.name = f.name
+		}
+		if a.warning != "" {
+			warnf("%s", a.warning)
+		}
+		if a.name == "" {
+			continue
+		}
+		if first[a.key] != nil {
+			added[a.key] = a.name
+		}
+		if path := filepath.Join(assetsDir, a.name); !saved[path] {
+			saved[path] = true
+			files = append(files, path)
+		}
+		assets[a.src] = filepath.ToSlash(filepath.Join(rel, a.name))
+	}
+	if len(files) > 0 {
+		if err := recordAssetSources(sourcesPath, added); err != nil {
+			return nil, nil, err
+		}
+		files = append(files, sourcesPath)
+	}
+	return assets, files, nil
+}
+
+// readAssetSources reads an assetSources file; a missing one lists nothing.
+func readAssetSources(path string) (map[string]string, error) {
+	sources := map[string]string{}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &sources); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// recordAssetSources adds the downloads of this run to the assetSources file at path. Runs
+// sharing an -assets directory can finish at the same time, so the file is locked and read
+// again before it is written.
+func recordAssetSources(path string, added map[string]string) error {
+	unlock, err := lockPath(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	sources, err := readAssetSources(path)
+	if err != nil {
+		return err
+	}
+	for key, name := range added {
+		sources[key] = name
+	}
+	b, _ := json.MarshalIndent(sources, "", "  ")
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// sectionStartRe matches the line that opens a week's section of an -append-to master
+// document; sectionEnd is the line that closes it.
+var sectionStartRe = regexp.MustCompile(`(?m)^<!-- quiz-section: (.+) -->\r?$`)
+
+const sectionEnd = "<!-- /quiz-section: %s -->"
+
+// spliceSection puts body into master as the section of week, between its marker lines. The
+// week's section is replaced where it stands when master has one; a new one goes before the
+// first section of a later week (see weekKey), or at the end. Everything else in master is
+// kept byte for byte, so splicing the same body again changes nothing.
+func spliceSection(master, week, body string) (string, error) {
+	end := fmt.Sprintf(sectionEnd, week)
+	section := fmt.Sprintf("<!-- quiz-section: %s -->\n\n%s\n\n%s\n", week, strings.TrimRight(body, "\n"), end)
+	for _, m := range sectionStartRe.FindAllStringSubmatchIndex(master, -1) {
+		switch name := master[m[2]:m[3]]; {
+		case name == week:
+			off := m[1]
+			for {
+				line, _, found := strings.Cut(master[off:], "\n")
+				if strings.TrimRight(line, "\r") == end {
+					off += len(line)
+					if found {
+						off++
+					}
+					return master[:m[0]] + section + master[off:], nil
+				}
+				if !found {
+					return "", fmt.Errorf("the %s section has no closing %s line", week, end)
+				}
+				off += len(line) + 1
+			}
+		case weekKey(name) > weekKey(week):
+			return master[:m[0]] + section + "\n" + master[m[0]:], nil
+		}
+	}
+	switch {
+	case master == "":
+	case strings.HasSuffix(master, "\n"):
+		master += "\n"
+	default:
+		master += "\n\n"
+	}
+	return master + section, nil
+}
+
+var digitRun = regexp.MustCompile(`\d+`)
+
+// weekKey is a week label in the form that sorts in week order: lower case, with every run
+// of digits padded to the same width, so "Week 2" comes before "Week 10".
+func weekKey(s string) string {
+	return digitRun.ReplaceAllStringFunc(strings.ToLower(s), func(d string) string {
+		if len(d) >= 20 {
+			return d
+		}
+		return strings.Repeat("0", 20-len(d)) + d
+	})
+}
+
+// appendSection splices body into the master document at path as the section of week (see
+// spliceSection), creating the document when it doesn't exist. The document is locked while
+// it is read and written, so the runs of -dir can share one.
+func appendSection(path, week, body string) error {
+	unlock, err := lockPath(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	master, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := spliceSection(string(master), week, body)
+	if err != nil {
+		return err
+	}
+	if out == string(master) {
+		return nil
+	}
+	return writeFileAtomic(path, []byte(out))
+}
+
+// checkNoResults rejects -no-results together with any source of results.
+func checkNoResults(noResults bool, resultPath string, moreResults []string, resultsDir string) error {
+	if noResults && (resultPath != "" || len(moreResults) > 0 || resultsDir != "") {
+		return fmt.Errorf("-no-results writes a practice sheet from the quiz alone; it cannot be combined with -results or -results-dir")
+	}
+	return nil
+}
+
+func main() {
+	// Subcommands take no logging flags; they only log warnings, like the default run.
+	quiet, _ := newLogger(os.Stderr, false, false, "text")
+	slog.SetDefault(quiet)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "status":
+			os.Exit(runStatus(os.Args[2:]))
+		case "clean":
+			os.Exit(runClean(os.Args[2:]))
+		case "selftest":
+			os.Exit(runSelftest(os.Args[2:]))
+		case "quizme", "practice":
+			os.Exit(runQuizMe(os.Args[1], os.Args[2:]))
+		case "fetch-all":
+			os.Exit(runFetchAll(os.Args[2:]))
+		case "bank":
+			os.Exit(runBank(os.Args[2:]))
+		case "mock-exam":
+			os.Exit(runMockExam(os.Args[2:]))
+		case "study":
+			os.Exit(runStudy(os.Args[2:]))
+		case "tag":
+			os.Exit(runTag(os.Args[2:]))
+		case "report":
+			os.Exit(runReport(os.Args[2:]))
+		case "serve":
+			os.Exit(runServe(os.Args[2:]))
+		case "anonymize":
+			os.Exit(runAnonymize(os.Args[2:]))
+		}
+	}
+	// "publish <target> [flags]" runs the usual extraction, then pushes the result to target.
+	var publishTarget string
+	if len(os.Args) > 1 && os.Args[1] == "publish" {
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "usage: %s publish <%s> [flags]\n", filepath.Base(os.Args[0]), strings.Join(publishTargets, "|"))
+			os.Exit(exitFailure)
+		}
+		publishTarget = os.Args[2]
+		os.Args = append(os.Args[:1], os.Args[3:]...)
+	}
+
+	cliArgs := append([]string{}, os.Args[1:]...) // recorded in the manifest
+	if publishTarget != "" {
+		cliArgs = append([]string{"publish", publishTarget}, cliArgs...)
+	}
+	var pub publishConfig
+	var (
+		quizPath      string
+		resultPath    string
+		noResults     bool
+		harPath       string
+		moreResults   []string
+		quizPages     []string
+		outPath       string
+		appendTo      string
+		format        string
+		flavor        string
+		lang          string
+		langFile      string
+		cssPath       string
+		cssMode       string
+		theme         string
+		mathMode      string
+		katexDir      string
+		explain       string
+		notesPath     string
+		llmCmd        string
+		metaPath      string
+		bankFilter    string
+		onlyFilter    string
+		typesFilter   string
+		numbersFilter string
+		showResponses bool
+		annotateKeys  bool
+		choiceOrder   string
+		debugIDs      bool
+		dumpDir       string
+		subPath       string
+		outDir        string
+		layoutMode    string
+		course        string
+		labelPats     string
+		labelRegex    string
+		labelFrom     string
+		labelFlag     string
+		resultsDir    string
+		distractorPct float64
+		gradebookPath string
+		rules         textRules
+		groupBy       string
+		wrapWidth     int
+		quizizzTime   int
+		outputVersion int
+		statsPath     string
+		eventsPath    string
+		archivePath   string
+		urlToken      string
+		canvasURL     string
+		courseID      string
+		canvasQuizID  string
+		canvasToken   string
+		cacheDir      string
+		moduleLabels  string
+		batchDir      string
+		linkBase      string
+		pageBreaks    bool
+		saveAssets    bool
+		assetsDir     string
+		pairPattern   string
+		recordDir     string
+		replayDir     string
+		baseURL       string
+		inputDir      string
+		templatePath  string
+		templateDir   string
+		heading       string
+		configPath    string
+		profileName   string
+		gitCommit     bool
+		glossary      bool
+		glossaryPath  string
+		bloomMode     string
+		tagsPath      string
+		splitBy       string
+		splitKey      bool
+		scoresPath    string
+		diffPrev      bool
+		audioFormat   string
+		ttsEngine     string
+		ttsCmd        string
+		diffFile      string
+		writeIndex    bool
+		watch         bool
+		deterministic bool
+		jobs          int
+		strict        bool
+		verifyScore   bool
+		summaryPath   string
+		verbose       bool
+		debug         bool
+		logFormat     string
+	)
+	flag.Var(pathsFlag{path: &quizPath, more: &quizPages}, "in", "Path to quiz JSON (e.g., wk12.json). If empty, you'll be prompted, unless -results is given: then the questions are rebuilt from the results. Repeat it for an export paginated into several files (wk12_p1.json, wk12_p2.json); their items are joined.")
+	flag.Var(pathsFlag{path: &resultPath, more: &moreResults}, "results", "Path to results JSON (e.g., wk12_result.json). If empty, you'll be prompted; no answer writes a practice sheet without the key. Repeat it for several attempts or regrades, oldest first, to merge them.")
+	flag.StringVar(&harPath, "har", "", "Browser HAR capture (e.g., session.har) to take both the quiz and the results from, instead of -in and -results.")
+	flag.BoolVar(&noResults, "no-results", false, "Write a practice sheet of the questions and options without asking for results, e.g. before they are released.")
+	flag.StringVar(&batchDir, "dir", "", "Render every quiz/results pair in this directory (see -pair-pattern), one document each, and print a summary.")
+	flag.StringVar(&pairPattern, "pair-pattern", "{name}_result.json", "With -dir, the results file name of a quiz; {name} is the quiz file name without its extension.")
+	flag.BoolVar(&watch, "watch", false, "Keep running: regenerate the output whenever an input file (or a JSON file in -dir) changes, e.g. after a results file is downloaded again.")
+	flag.BoolVar(&strict, "strict", false, "Exit with status 1, after writing the outputs, when any question could not be fully extracted (see the summary on stderr).")
+	flag.BoolVar(&verifyScore, "verify-score", false, "Add up the questions' scores and points possible and compare them with the results items, -submission's score and -quiz-meta's points possible; a mismatch is a warning.")
+	flag.StringVar(&summaryPath, "summary-json", "", "Write a JSON summary of the run to this file: the outputs, how many questions were extracted and answered, and the warnings. It is removed at the start and only written when the outputs are.")
+	flag.BoolVar(&verbose, "v", false, "Log to stderr what is read and fetched: each input's item count, whether it was streamed, and every URL and cache hit.")
+	flag.BoolVar(&debug, "debug", false, "Log as -v does, plus how each item was decoded: the form of its choices, the shape of its scored_data.value and where its key was read from.")
+	flag.StringVar(&logFormat, "log-format", "text", "Format of the -v and -debug log: text (key=value lines) or json (one object per line).")
+	flag.IntVar(&jobs, "jobs", runtime.NumCPU(), "How many quizzes -dir renders at once, and how many images -assets downloads at once.")
+	flag.StringVar(&baseURL, "base-url", "", "https:// URL that relative -in and -results paths are fetched from when they are not local files.")
+	flag.StringVar(&inputDir, "input-dir", "", "Directory that relative -in and -results paths are read from when they are not in the working directory.")
+	flag.StringVar(&heading, "heading", "", "Document heading, with {label} for the quiz label (or title) and {subtitle}, e.g. \"{label} — {subtitle}\" (default \"{label} Quiz — {subtitle}\").")
+	flag.StringVar(&configPath, "config", "", "Config file with named profiles (default "+configName+", then quizextractor/config.json in the user config directory).")
+	flag.StringVar(&profileName, "profile", "", "Config profile whose settings become the flag defaults. Empty uses the config file's default_profile.")
+	flag.StringVar(&urlToken, "url-token", "", "Bearer token sent when -in or -results is an https:// URL (also read from QUIZ_URL_TOKEN).")
+	flag.StringVar(&canvasURL, "canvas-url", "", "Canvas base URL (e.g., https://school.instructure.com) to fetch the quiz from the New Quizzes API instead of -in; needs -course-id, -quiz-id and -token.")
+	flag.StringVar(&courseID, "course-id", "", "Canvas course id of the -canvas-url quiz.")
+	flag.StringVar(&canvasQuizID, "quiz-id", "", "New Quizzes assignment id of the -canvas-url quiz.")
+	flag.StringVar(&canvasToken, "token", "", "Canvas API access token for -canvas-url (also read from CANVAS_TOKEN).")
+	flag.StringVar(&cacheDir, "cache-dir", "", "Keep the -canvas-url API responses in this directory, with checksums, and render from them instead of fetching again.")
+	flag.StringVar(&recordDir, "record", "", "Save every response fetched from Canvas or another https:// input to this directory, for -replay.")
+	flag.StringVar(&replayDir, "replay", "", "Serve every fetch from the responses -record saved in this directory, without network access.")
+	flag.StringVar(&outPath, "out", "", "Output file path, or an s3://, gs:// or webdav:// URL to upload to. If empty, derived from the quiz filename (see -out-dir).")
+	flag.StringVar(&appendTo, "append-to", "", "Put the document into this master Markdown file as the section of its week label, replacing the week's section when it is already there, instead of writing a file per quiz.")
+	flag.StringVar(&format, "format", "md", "Output format: md, html, mediawiki, rst, adoc, txt, quizizz (CSV for import into Quizizz) anki (notes for import into Anki), json (for other programs), pdf, docx (Word) or csv (one row per question, for spreadsheets).")
+	flag.StringVar(&flavor, "flavor", "", "Markdown flavor of -format md: empty for plain Markdown, obsidian (YAML frontmatter, answers in collapsed callouts) for Obsidian and Notion vaults, or notes for study notes with the answers worked into statements.")
+	flag.StringVar(&lang, "lang", "", "Language of the labels (Options, Answer, Correct answers, ...) in -format md, txt and html, by code: "+strings.Join(quizextract.Languages(), ", ")+". Empty means English.")
+	flag.StringVar(&langFile, "lang-file", "", "JSON object mapping English labels to translations, over the -lang catalog; for a language without one, or to change its wording.")
+	flag.StringVar(&templatePath, "template", "", "Go text/template file, or the name of a built-in or -template-dir template (e.g. flashcards), that lays out the document instead of -format; the output extension comes from its file name, e.g. notes.md.tmpl writes .md.")
+	flag.StringVar(&templateDir, "template-dir", "", "Directory of templates -template can name; each overrides the built-in of the same name.")
+	flag.IntVar(&outputVersion, "output-version", outputVersions[len(outputVersions)-1], "Markdown layout version to write; pin it in scripts that parse the output (see README \"Output versions\").")
+	flag.BoolVar(&pageBreaks, "page-breaks", false, "Start every question on a new page in -format pdf or docx.")
+	flag.BoolVar(&saveAssets, "assets", false, "Download the images in question bodies, and with -canvas-url the Canvas files they link to, to an assets directory next to the output and link them from there (-format md and html).")
+	flag.StringVar(&assetsDir, "assets-dir", "", "Directory for the images -assets saves, e.g. one shared by every quiz (default assets next to the output); implies -assets.")
+	flag.IntVar(&wrapWidth, "wrap", 80, "Line width for -format txt; 0 disables wrapping.")
+	flag.IntVar(&quizizzTime, "quizizz-time", 30, "Time limit per question in seconds for -format quizizz: 5, 10, 20, 30, 45, 60, 90, 120, 180, 300, 600 or 900.")
+	flag.StringVar(&archivePath, "archive", "", "Also bundle the generated document, linked assets and provenance.json into this .zip (or .tar.gz/.tgz) file.")
+	flag.BoolVar(&writeIndex, "index", false, "Add the generated document(s) to INDEX.md in -out-dir (or the output directory), a landing page listing every document with its title, score, question count and warnings.")
+	flag.BoolVar(&gitCommit, "git-commit", false, "Commit the regenerated output (and -archive bundle) in the git repository it is written to, with the input hashes in the message.")
+	flag.StringVar(&cssPath, "css", "", "Stylesheet to add to HTML output after the built-in styles.")
+	flag.StringVar(&linkBase, "link-base", "", "URL that relative links and images in HTML question bodies resolve against, e.g. https://school.instructure.com (default -canvas-url).")
+	flag.StringVar(&cssMode, "css-mode", "inline", "How -css is included in HTML output: inline (embedded) or link (<link> to the file).")
+	flag.StringVar(&theme, "theme", "light", "HTML theme: light, dark, sepia or compact.")
+	flag.StringVar(&mathMode, "math", "cdn", "How HTML output typesets TeX equations: cdn (load MathJax from jsDelivr when the document has any), offline (embed KaTeX from -katex-dir) or none.")
+	flag.StringVar(&katexDir, "katex-dir", "", "KaTeX distribution folder (with katex.min.css, katex.min.js, contrib/ and fonts/) embedded by -math offline.")
+	flag.StringVar(&explain, "explain", defaultExplain, "Comma-separated explanation sources in priority order: general, correct, incorrect (for missed questions), notes, llm. Empty disables explanations.")
+	flag.StringVar(&notesPath, "notes", "", "JSON file mapping item ids or question numbers to explanation notes (source \"notes\").")
+	flag.StringVar(&llmCmd, "llm-cmd", "", "Shell command that reads a question prompt on stdin and prints an explanation (source \"llm\").")
+	flag.StringVar(&metaPath, "quiz-meta", "", "Optional quiz object JSON (title, instructions, settings) from the Canvas quiz API.")
+	flag.StringVar(&statsPath, "quiz-stats", "", "Optional quiz statistics JSON from the Canvas quiz statistics API; adds class difficulty and answer distribution per question.")
+	flag.StringVar(&eventsPath, "events", "", "Optional quiz submission events JSON; adds an \"Attempt replay\" appendix (md and html) with time spent and answer switches per question.")
+	flag.BoolVar(&glossary, "glossary", false, "Add a \"Glossary\" appendix (md and html) of emphasized terms and short correct answers, with the questions that use them.")
+	flag.StringVar(&glossaryPath, "glossary-terms", "", "JSON object mapping extra glossary terms to definitions (empty strings allowed); implies -glossary.")
+	flag.StringVar(&bloomMode, "bloom", "", "Tag questions with a Bloom's taxonomy level and summarize the distribution: keywords, or llm (keywords, then -llm-cmd for the rest). Empty disables it.")
+	flag.StringVar(&tagsPath, "tags", "", "JSON file mapping item ids or question numbers to arrays of topic tags.")
+	flag.StringVar(&scoresPath, "scores-csv", "", "Also write a CSV of item id, question number, points possible and points earned per question, with a total row, for checking the gradebook.")
+	flag.StringVar(&audioFormat, "audio", "", "Also read each document aloud into an audio file next to it: mp3, ogg or wav. Empty disables it.")
+	flag.StringVar(&ttsEngine, "tts", "espeak", "Speech engine for -audio: espeak (espeak-ng), say (macOS) or cmd (-tts-cmd).")
+	flag.StringVar(&ttsCmd, "tts-cmd", "", "Shell command for -tts cmd: reads the script on stdin and writes a WAV file to $TTS_OUT.")
+	flag.BoolVar(&diffPrev, "diff-prev", false, "Before overwriting an existing output document, print a unified diff from it to the new one.")
+	flag.StringVar(&diffFile, "diff-file", "", "Write the -diff-prev diff to this file instead of stdout; implies -diff-prev.")
+	flag.BoolVar(&deterministic, "deterministic", false, "Write the same timestamp on every run (SOURCE_DATE_EPOCH, or 1980-01-01) in provenance, archives and the manifest, so regenerating unchanged inputs changes no bytes.")
+	flag.Var(ruleFlag{rules: &rules, redact: true}, "redact-pattern", "Regex whose matches are replaced with \""+redactedText+"\" in the document, e.g. instructor names or internal URLs. Repeatable.")
+	flag.Var(ruleFlag{rules: &rules}, "replace", "Rewrite the document text with \"regex=>text\" (text may use $1). Repeatable; rules run in order with -redact-pattern.")
+	flag.StringVar(&splitBy, "split-by", "", "Write one document per group instead of one: tag (one per question tag; questions may repeat) or type (one per question type). Empty writes a single document.")
+	flag.BoolVar(&splitKey, "split", false, "Write two documents instead of one: the questions without answers, for self-testing (<label>_questions), and the answer key (<label>_answers).")
+	flag.StringVar(&bankFilter, "bank", "", "Only include questions drawn from these item banks (comma-separated titles).")
+	flag.StringVar(&dumpDir, "dump-stages", "", "Directory to write the intermediate parsing models to, as JSON: decoded payloads, normalized choices and the derived document.")
+	flag.BoolVar(&debugIDs, "debug-ids", false, "Append item ids, interaction slugs and choice ids to the output, for tracing answers back to the raw JSON.")
+	flag.StringVar(&choiceOrder, "choice-order", "shuffled", "Order of answer choices: shuffled (as the student saw them, from shuffled_order) or canonical (as authored, for comparing attempts).")
+	flag.BoolVar(&showResponses, "show-responses", false, "Show the student's answer next to the correct one for every question, marked ✅ or ❌, with the total score under the title.")
+	flag.BoolVar(&annotateKeys, "annotate-confidence", false, "Mark every answer key as confirmed, inferred from score or unknown, and list the questions to double-check under the title.")
+	flag.StringVar(&typesFilter, "types", "", "Only include questions of these types (comma-separated), e.g. multiple-choice,true-false. Question numbers keep their original values.")
+	flag.StringVar(&numbersFilter, "questions", "", "Only include these questions (comma-separated numbers and ranges), e.g. 3,7,12-15.")
+	flag.StringVar(&onlyFilter, "only", "", "Only include questions answered a certain way (comma-separated): unanswered (left blank) or incorrect (answered, below full points).")
+	flag.StringVar(&subPath, "submission", "", "Optional Canvas submission JSON (with submission_comments) for submission-level instructor comments.")
+	flag.StringVar(&outDir, "out-dir", "", "Root directory for derived output paths (ignored when -out is set). Empty writes next to the quiz file.")
+	flag.StringVar(&layoutMode, "layout", "structured", "Layout under -out-dir: structured (<course>/<week>/solutions.md), mirror (input directory tree) or flat.")
+	flag.StringVar(&course, "course", "", "Course name used for the <course> directory of the structured layout.")
+	flag.StringVar(&labelPats, "label-patterns", "", "Comma-separated filename conventions to try, in order: wk, week, quiz, module, exam. Empty tries all.")
+	flag.StringVar(&labelRegex, "label-regex", "", "Custom filename regex tried before the built-in conventions; capture (?P<label>...) and/or (?P<title>...).")
+	flag.StringVar(&labelFrom, "label-from", "", "Where the quiz label comes from: canvas (the -canvas-url module and title), filename, title (the -quiz-meta title) or flag (-label). Empty uses canvas with -canvas-url, else filename.")
+	flag.StringVar(&moduleLabels, "module-labels", "", "JSON file mapping Canvas module names to quiz labels for -label-from canvas (e.g. {\"Routing\": \"WK05\"}).")
+	flag.StringVar(&labelFlag, "label", "", "Quiz label used with -label-from flag (e.g., \"Lab 4\").")
+	flag.StringVar(&resultsDir, "results-dir", "", "Directory of per-student result JSON files; writes a class-wide item analysis instead of a solutions document.")
+	flag.StringVar(&gradebookPath, "gradebook", "", "With -results-dir, a Canvas gradebook CSV export; result files are matched to students by name (ID, SIS User ID or SIS Login ID) and the item analysis is broken down by -group-by.")
+	flag.StringVar(&groupBy, "group-by", "Section", "Gradebook column that -gradebook groups students by, e.g. Section or an assignment group score such as \"Quizzes Current Score\".")
+	flag.Float64Var(&distractorPct, "distractor-threshold", 30, "With -results-dir, flag incorrect options chosen by more than this percent of students.")
+	flag.StringVar(&pub.GoogleCredentials, "google-credentials", "", "publish sheets: Google service-account key JSON.")
+	flag.StringVar(&pub.GoogleToken, "google-token", "", "publish sheets/gdoc: OAuth access token (alternative to -google-credentials; also read from GOOGLE_OAUTH_ACCESS_TOKEN).")
+	flag.StringVar(&pub.SheetID, "sheet-id", "", "publish sheets: spreadsheet id to append rows to.")
+	flag.StringVar(&pub.SheetRange, "sheet-range", "Sheet1", "publish sheets: A1 range (usually a sheet name) rows are appended after.")
+	flag.StringVar(&pub.ConfluenceURL, "confluence-url", "", "publish confluence: base URL of the Confluence site (e.g., https://example.atlassian.net/wiki).")
+	flag.StringVar(&pub.ConfluenceSpace, "confluence-space", "", "publish confluence: key of the space the page is created in.")
+	flag.StringVar(&pub.ConfluenceParent, "confluence-parent", "", "publish confluence: optional id of the parent page.")
+	flag.StringVar(&pub.ConfluenceUser, "confluence-user", "", "publish confluence: account e-mail for Confluence Cloud API tokens. Empty sends -confluence-token as a bearer token.")
+	flag.StringVar(&pub.ConfluenceToken, "confluence-token", "", "publish confluence: API token or personal access token (also read from CONFLUENCE_TOKEN).")
+	flag.StringVar(&pub.DocID, "gdoc-id", "", "publish gdoc: id of an existing Google Doc to overwrite. Empty creates a new document.")
+	// A bad flag is a hard failure, not the exitWarnings the flag package would use.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(exitOK)
+	} else if err != nil {
+		os.Exit(exitFailure)
+	}
+	if cfg := findConfig(configPath); cfg != "" {
+		profile, err := loadProfile(cfg, profileName)
+		if err == nil {
+			err = applyProfile(flag.CommandLine, profile)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config %s: %v\n", cfg, err)
+			os.Exit(1)
+		}
+	} else if profileName != "" {
+		fmt.Fprintf(os.Stderr, "-profile %s needs a config file (%s or -config)\n", profileName, configName)
+		os.Exit(1)
+	}
+
+	if jobs < 1 {
+		fmt.Fprintf(os.Stderr, "-jobs must be at least 1, not %d\n", jobs)
+		os.Exit(1)
+	}
+	logger, err := newLogger(os.Stderr, verbose, debug, logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	if debug {
+		quizextract.Logger = logger
+	}
+	// A summary left by an earlier run must not pass for this one's if it fails.
+	if summaryPath != "" {
+		if err := os.Remove(summaryPath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if quizPath == stdio || resultPath == stdio || outPath == stdio {
+		switch {
+		case quizPath == stdio && resultPath == stdio:
+			fmt.Fprintln(os.Stderr, "-in - and -results - cannot both read standard input; give one of them as a file")
+			os.Exit(1)
+		case watch:
+			fmt.Fprintln(os.Stderr, "-watch regenerates a document when its files change; it cannot read standard input or write to standard output")
+			os.Exit(1)
+		case outPath == stdio && (splitBy != "" || splitKey || archivePath != "" || writeIndex || gitCommit || diffPrev || audioFormat != "" || saveAssets || assetsDir != ""):
+			fmt.Fprintln(os.Stderr, "-out - writes one document to standard output; it cannot be combined with -split-by, -split, -archive, -index, -git-commit, -diff-prev, -audio or -assets")
+			os.Exit(1)
+		}
+		// The document has standard output to itself; "Generated ..." and the other notes
+		// go to standard error.
+		if outPath == stdio {
+			os.Stdout = os.Stderr
+		}
+	}
+
+	// -watch runs this binary again, without -watch, whenever an input changes (see runWatch).
+	if watch {
+		var paths []string
+		for _, p := range append([]string{quizPath, resultPath, harPath, batchDir, metaPath, subPath, notesPath, tagsPath}, append(quizPages, moreResults...)...) {
+			if p != "" && !isURL(p) {
+				paths = append(paths, p)
+			}
+		}
+		switch {
+		case canvasURL != "" || resultsDir != "" || publishTarget != "":
+			fmt.Fprintln(os.Stderr, "-watch regenerates a document from local files; it cannot be combined with -canvas-url, -results-dir or publish")
+			os.Exit(1)
+		case quizPath == "" && resultPath == "" && harPath == "" && batchDir == "":
+			fmt.Fprintln(os.Stderr, "-watch needs the files to watch: -in, -results, -har or -dir")
+			os.Exit(1)
+		}
+		// The trailing -watch=false also overrides a watch setting from a config profile.
+		os.Exit(runWatch(paths, pairPattern, append(append([]string{}, os.Args[1:]...), "-watch=false")))
+	}
+
+	// -dir runs this binary once per quiz/results pair (see runBatch).
+	if batchDir != "" {
+		if quizPath != "" || harPath != "" || resultPath != "" || outPath != "" || resultsDir != "" || canvasURL != "" {
+			fmt.Fprintln(os.Stderr, "-dir pairs the quiz and results files itself; it cannot be combined with -in, -har, -results, -out, -results-dir or -canvas-url")
+			os.Exit(1)
+		}
+		os.Exit(runBatch(batchDir, pairPattern, jobs, withoutFlags(os.Args[1:], "dir", "pair-pattern", "summary-json"), summaryPath))
+	}
+
+	ext, ok := formatExtensions[format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q (want md, html, mediawiki, rst, adoc, txt, quizizz, anki, json, pdf, docx or csv)\n", format)
+		os.Exit(1)
+	}
+	var tmpl *template.Template
+	if templatePath != "" {
+		if format != "md" {
+			fmt.Fprintln(os.Stderr, "-template replaces -format; name the output type in the template's file name instead, e.g. notes.html.tmpl")
+			os.Exit(1)
+		}
+		var err error
+		if tmpl, ext, err = loadTemplate(templatePath, templateDir); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read template %s: %v\n", templatePath, err)
+			os.Exit(1)
+		}
+	}
+	if flavor != "" {
+		switch {
+		case flavor != "obsidian" && flavor != "notes":
+			fmt.Fprintf(os.Stderr, "unknown -flavor %q (want obsidian or notes)\n", flavor)
+			os.Exit(1)
+		case format != "md" || templatePath != "" || resultsDir != "":
+			fmt.Fprintln(os.Stderr, "-flavor applies to -format md solutions documents; it cannot be combined with another -format, -template or -results-dir")
+			os.Exit(1)
+		}
+	}
+	var labels quizextract.Labels
+	if lang != "" || langFile != "" {
+		var ok bool
+		switch labels, ok = quizextract.LabelsFor(lang); {
+		case !ok && langFile == "":
+			fmt.Fprintf(os.Stderr, "unknown -lang %q (want %s, or -lang-file for another language)\n", lang, strings.Join(quizextract.Languages(), ", "))
+			os.Exit(1)
+		case format != "md" && format != "txt" && format != "html" || templatePath != "" || resultsDir != "" || flavor == "notes" || outputVersion != outputVersions[len(outputVersions)-1]:
+			fmt.Fprintln(os.Stderr, "-lang and -lang-file apply to -format md, txt and html solutions documents; they cannot be combined with -template, -results-dir, -flavor notes or -output-version")
+			os.Exit(1)
+		}
+		if langFile != "" {
+			custom, err := readLabels(langFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read translations %s: %v\n", langFile, err)
+				os.Exit(1)
+			}
+			if labels == nil {
+				labels = quizextract.Labels{}
+			}
+			for k, v := range custom {
+				labels[k] = v
+			}
+		}
+	}
+	if outputVersion != outputVersions[len(outputVersions)-1] {
+		known := false
+		for _, v := range outputVersions {
+			known = known || v == outputVersion
+		}
+		switch {
+		case !known:
+			fmt.Fprintf(os.Stderr, "unknown -output-version %d (want 1 to %d)\n", outputVersion, outputVersions[len(outputVersions)-1])
+			os.Exit(1)
+		case format != "md" || resultsDir != "" || flavor != "":
+			fmt.Fprintf(os.Stderr, "-output-version %d only applies to plain -format md solutions documents\n", outputVersion)
+			os.Exit(1)
+		}
+	}
+	diffPrev = diffPrev || diffFile != ""
+	saveAssets = saveAssets || assetsDir != ""
+	if diffPrev && (format == "pdf" || format == "docx") {
+		fmt.Fprintf(os.Stderr, "-diff-prev and -diff compare text documents; they cannot be used with -format %s\n", format)
+		os.Exit(1)
+	}
+	now, err := outputTime(deterministic)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if remoteScheme(outPath) != "" && (archivePath != "" || gitCommit || writeIndex || diffPrev || (format == "html" && cssMode == "link" && cssPath != "")) {
+		fmt.Fprintln(os.Stderr, "a remote -out cannot be combined with -archive, -git-commit, -index, -diff-prev or -css-mode link")
+		os.Exit(1)
+	}
+	if splitBy != "" {
+		known := false
+		for _, m := range splitModes {
+			known = known || m == splitBy
+		}
+		switch {
+		case !known:
+			fmt.Fprintf(os.Stderr, "unknown -split-by %q (want %s)\n", splitBy, strings.Join(splitModes, " or "))
+			os.Exit(1)
+		case resultsDir != "" || archivePath != "" || publishTarget != "":
+			fmt.Fprintln(os.Stderr, "-split-by writes several solutions documents; it cannot be combined with -results-dir, -archive or publish")
+			os.Exit(1)
+		}
+	}
+	if appendTo != "" && (format != "md" || templatePath != "" || flavor == "obsidian" || outPath != "" || resultsDir != "" || splitBy != "" || splitKey ||
+		archivePath != "" || writeIndex || diffPrev || audioFormat != "" || publishTarget != "") {
+		fmt.Fprintln(os.Stderr, "-append-to puts a -format md solutions document into a master file; it cannot be combined with -out, -template, -flavor obsidian, -results-dir, -split-by, -split, -archive, -index, -diff-prev, -audio or publish")
+		os.Exit(1)
+	}
+	if splitKey && (splitBy != "" || resultsDir != "" || archivePath != "" || publishTarget != "" || noResults) {
+		fmt.Fprintln(os.Stderr, "-split writes a questions document and an answer key; it cannot be combined with -split-by, -results-dir, -archive, -no-results or publish")
+		os.Exit(1)
+	}
+	for _, k := range strings.Split(onlyFilter, ",") {
+		known := strings.TrimSpace(onlyFilter) == ""
+		for _, f := range responseFilters {
+			known = known || strings.TrimSpace(k) == f
+		}
+		if !known {
+			fmt.Fprintf(os.Stderr, "unknown -only %q (want %s)\n", k, strings.Join(responseFilters, " or "))
+			os.Exit(1)
+		}
+	}
+	if choiceOrder != "shuffled" && choiceOrder != "canonical" {
+		fmt.Fprintf(os.Stderr, "unknown -choice-order %q (want %s)\n", choiceOrder, strings.Join(choiceOrders, " or "))
+		os.Exit(1)
+	}
+	questionNumbers, err := parseQuestionNumbers(numbersFilter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if (typesFilter != "" || numbersFilter != "") && resultsDir != "" {
+		fmt.Fprintln(os.Stderr, "-types and -questions select questions of a solutions document; they cannot be combined with -results-dir")
+		os.Exit(1)
+	}
+	if onlyFilter != "" && resultsDir != "" {
+		fmt.Fprintln(os.Stderr, "-only filters one student's responses; it cannot be combined with -results-dir")
+		os.Exit(1)
+	}
+	if showResponses && resultsDir != "" {
+		fmt.Fprintln(os.Stderr, "-show-responses shows one student's responses; it cannot be combined with -results-dir")
+		os.Exit(1)
+	}
+	if format == "quizizz" {
+		known := false
+		for _, t := range quizizzTimes {
+			known = known || t == quizizzTime
+		}
+		if !known {
+			fmt.Fprintf(os.Stderr, "-quizizz-time %d is not a Quizizz time limit (want one of %v seconds)\n", quizizzTime, quizizzTimes)
+			os.Exit(1)
+		}
+	}
+	if audioFormat != "" {
+		known := false
+		for _, f := range audioFormats {
+			known = known || f == audioFormat
+		}
+		switch {
+		case !known:
+			fmt.Fprintf(os.Stderr, "unknown -audio %q (want %s)\n", audioFormat, strings.Join(audioFormats, ", "))
+			os.Exit(1)
+		case ttsEngine != "cmd" && ttsEngines[ttsEngine] == "":
+			fmt.Fprintf(os.Stderr, "unknown -tts %q (want espeak, say or cmd)\n", ttsEngine)
+			os.Exit(1)
+		case ttsEngine == "cmd" && ttsCmd == "":
+			fmt.Fprintln(os.Stderr, "-tts cmd needs -tts-cmd")
+			os.Exit(1)
+		case resultsDir != "" || remoteScheme(outPath) != "":
+			fmt.Fprintln(os.Stderr, "-audio reads a local solutions document aloud; it cannot be combined with -results-dir or a remote -out")
+			os.Exit(1)
+		}
+	}
+	if gradebookPath != "" && resultsDir == "" {
+		fmt.Fprintln(os.Stderr, "-gradebook groups a class item analysis; it needs -results-dir")
+		os.Exit(1)
+	}
+	if scoresPath != "" && resultsDir != "" {
+		fmt.Fprintln(os.Stderr, "-scores-csv lists one student's scores; it cannot be combined with -results-dir")
+		os.Exit(1)
+	}
+	if verifyScore && (resultsDir != "" || noResults) {
+		fmt.Fprintln(os.Stderr, "-verify-score checks one student's score; it needs results and cannot be combined with -results-dir or -no-results")
+		os.Exit(1)
+	}
+	if bloomMode != "" && bloomMode != "keywords" && bloomMode != "llm" {
+		fmt.Fprintf(os.Stderr, "unknown -bloom %q (want keywords or llm)\n", bloomMode)
+		os.Exit(1)
+	}
+	if bloomMode == "llm" && llmCmd == "" {
+		fmt.Fprintln(os.Stderr, "-bloom llm needs -llm-cmd")
+		os.Exit(1)
+	}
+	sources, err := parseExplainSources(explain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	explainCfg := quizextract.ExplainConfig{Sources: sources, LLM: shellLLM(llmCmd)}
+	if notesPath != "" {
+		notes, err := readNotes(notesPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read notes %s: %v\n", notesPath, err)
+			os.Exit(1)
+		}
+		explainCfg.Notes = notes
+	}
+
+	if err := useFixtures(recordDir, replayDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// -canvas-url fetches the quiz from the API in place of -in (see fetchCanvasList).
+	if canvasURL != "" {
+		if canvasToken == "" {
+			canvasToken = os.Getenv("CANVAS_TOKEN")
+		}
+		if courseID == "" || canvasQuizID == "" || (canvasToken == "" && replayDir == "") {
+			fmt.Fprintln(os.Stderr, "-canvas-url needs -course-id, -quiz-id and -token (or CANVAS_TOKEN)")
+			os.Exit(1)
+		}
+		if quizPath != "" || resultsDir != "" {
+			fmt.Fprintln(os.Stderr, "-canvas-url fetches the quiz itself; it cannot be combined with -in or -results-dir")
+			os.Exit(1)
+		}
+		quizPath = canvasQuizURL(canvasURL, courseID, canvasQuizID, "items")
+	}
+
+	if harPath != "" {
+		if quizPath != "" || resultPath != "" || resultsDir != "" {
+			fmt.Fprintln(os.Stderr, "-har takes the quiz and results from the capture; it cannot be combined with -in, -results, -results-dir or -canvas-url")
+			os.Exit(1)
+		}
+		quizPath = harPath
+	}
+	if err := checkNoResults(noResults, resultPath, moreResults, resultsDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	// Missing paths are only asked for at a terminal, so scripts and pipes never wait on a prompt.
+	interactive := isTerminal(os.Stdin) && quizPath != stdio && resultPath != stdio
+	// Given only -results, the quiz is rebuilt from them (see BuildResultsDoc) instead of
+	// asking for the quiz JSON.
+	if strings.TrimSpace(quizPath) == "" && strings.TrimSpace(resultPath) == "" && interactive {
+		fmt.Print("Enter quiz JSON path (e.g., wk12.json): ")
+		line, _ := reader.ReadString('\n')
+		quizPath = strings.TrimSpace(line)
+	}
+	if urlToken == "" {
+		urlToken = os.Getenv("QUIZ_URL_TOKEN")
+	}
+	quizPath, resultPath = strings.TrimSpace(quizPath), strings.TrimSpace(resultPath)
+	resultsOnly := quizPath == ""
+	if resultsOnly && resultPath == "" {
+		fmt.Fprintln(os.Stderr, "no quiz JSON given (-in), and no -results to rebuild the quiz from")
+		os.Exit(1)
+	}
+	if resultsOnly && resultsDir != "" {
+		fmt.Fprintln(os.Stderr, "-results-dir needs the quiz JSON (-in)")
+		os.Exit(1)
+	}
+	var quizData []byte
+	var quizName string
+	var quiz []quizextract.QuizItem
+	var streamed bool // quiz was decoded as the file was read (see streamQuizItems)
+	var classic []quizextract.ClassicQuestion
+	var isClassic bool
+	if resultsOnly {
+		resultPath = resolveInput(resultPath, inputDir, baseURL)
+		quizName = resultPath
+		if u, err := url.Parse(resultPath); err == nil && isURL(resultPath) {
+			quizName = pathpkg.Base(u.Path)
+		}
+		warnf("no quiz JSON given; rebuilding the questions from the results, without question or choice text")
+	} else if canvasURL != "" {
+		quizName = "quiz-" + canvasQuizID + ".json"
+		quizData, err = cachedFetch(cacheDir, quizPath, func() ([]byte, error) {
+			return fetchCanvasList(quizPath+"?per_page=100", canvasToken)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch quiz items: %v\n", err)
+			os.Exit(1)
+		}
+	} else if len(quizPages) > 0 {
+		quizPath = resolveInput(quizPath, inputDir, baseURL)
+		paths := []string{quizPath}
+		for _, p := range quizPages {
+			paths = append(paths, resolveInput(p, inputDir, baseURL))
+		}
+		if quiz, err = readQuizPages(paths, urlToken); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read quiz JSON %v\n", err)
+			os.Exit(1)
+		}
+		quizName = pagesName(quizPath)
+	} else {
+		quizPath = resolveInput(quizPath, inputDir, baseURL)
+		if quiz, streamed = streamQuizItems(quizPath); streamed {
+			quizName = quizPath
+		} else {
+			quizData, quizName, err = readInput(quizPath, "quiz", urlToken)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read quiz JSON %s: %v\n", quizPath, err)
+				os.Exit(1)
+			}
+			if quizextract.PayloadShape(quizData) == "results" {
+				if quizData, quizName, err = swappedInputs(quizPath, resolveInput(resultPath, inputDir, baseURL), urlToken); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				quizPath, resultPath = resultPath, quizPath
+			}
+			// Legacy Classic Quizzes exports carry their own answer key, so they need no results file.
+			classic, isClassic, err = quizextract.ParseClassicQuestions(quizData)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read Classic Quizzes export %s: %v\n", quizPath, err)
+				os.Exit(1)
+			}
+		}
+	}
+	if isClassic && (resultsDir != "" || publishTarget == "sheets") {
+		fmt.Fprintln(os.Stderr, "Classic Quizzes exports carry no student scores; -results-dir and publish sheets need New Quizzes results")
+		os.Exit(1)
+	}
+	if strings.TrimSpace(resultPath) == "" && bundledInput(quizPath) && !noResults {
+		resultPath = quizPath // look for the results in the same archive or capture
+	}
+	if strings.TrimSpace(resultPath) == "" && resultsDir == "" && !isClassic && !noResults && interactive {
+		fmt.Print("Enter results JSON path (e.g., wk12_result.json), or nothing for a practice sheet: ")
+		line, _ := reader.ReadString('\n')
+		resultPath = strings.TrimSpace(line)
+	}
+	// Without results there is no key: the quiz becomes a practice sheet (see BuildPracticeDoc).
+	practice := resultPath == "" && resultsDir == "" && !isClassic
+	if len(moreResults) > 0 && (resultsOnly || isClassic || resultsDir != "") {
+		fmt.Fprintln(os.Stderr, "several -results files can only be merged with the New Quizzes quiz JSON (-in), without -results-dir")
+		os.Exit(1)
+	}
+	if practice {
+		if onlyFilter != "" || showResponses || annotateKeys || scoresPath != "" || publishTarget == "sheets" {
+			fmt.Fprintln(os.Stderr, "-only, -show-responses, -annotate-confidence, -scores-csv and publish sheets need results (-results)")
+			os.Exit(1)
+		}
+		if !noResults {
+			warnf("no results given; writing a practice sheet without an answer key")
+		}
+	}
+	resultPath = resolveInput(resultPath, inputDir, baseURL)
+	for i, p := range moreResults {
+		moreResults[i] = resolveInput(p, inputDir, baseURL)
+	}
+
+	var meta quizextract.QuizMeta
+	if metaPath != "" {
+		if err := mustReadJSON(metaPath, &meta); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read quiz metadata %s: %v\n", metaPath, err)
+			os.Exit(1)
+		}
+	} else if canvasURL != "" {
+		metaPath = canvasQuizURL(canvasURL, courseID, canvasQuizID)
+		b, err := cachedFetch(cacheDir, metaPath, func() ([]byte, error) {
+			return fetchInput(metaPath, canvasToken)
+		})
+		if err == nil {
+			if b, _, err = recoverJSON(b); err == nil {
+				err = json.Unmarshal(b, &meta)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch quiz metadata: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if linkBase == "" {
+		linkBase = canvasURL
+	}
+	imageToken := canvasToken
+	if imageToken == "" {
+		imageToken = urlToken
+	}
+	if labelFrom == "" {
+		labelFrom = "filename"
+		if canvasURL != "" {
+			labelFrom = "canvas"
+		}
+	}
+	if labelFrom == "canvas" && canvasURL == "" {
+		fmt.Fprintln(os.Stderr, "-label-from canvas needs -canvas-url")
+		os.Exit(1)
+	}
+	// The module an assignment is in comes from its module item sequence (New Quizzes are
+	// assignments with the quiz's id).
+	var module string
+	if labelFrom == "canvas" {
+		seqURL := strings.TrimSuffix(canvasURL, "/") + "/api/v1/courses/" + url.PathEscape(courseID) + "/module_item_sequence?asset_type=Assignment&asset_id=" + url.QueryEscape(canvasQuizID)
+		b, err := cachedFetch(cacheDir, seqURL, func() ([]byte, error) {
+			return fetchInput(seqURL, canvasToken)
+		})
+		var seq moduleSequence
+		if err == nil {
+			if b, _, err = recoverJSON(b); err == nil {
+				err = json.Unmarshal(b, &seq)
+			}
+		}
+		if err != nil {
+			warnf("failed to look up the quiz's module, labeling it by its title: %v", err)
+		}
+		module = seq.module()
+	}
+	var moduleMap map[string]string
+	if moduleLabels != "" {
+		if err := mustReadJSON(moduleLabels, &moduleMap); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read module labels %s: %v\n", moduleLabels, err)
+			os.Exit(1)
+		}
+	}
+
+	// Derive the quiz label (e.g., wk12.json -> WK12)
+	patterns := builtinLabelPatterns
+	if labelPats != "" {
+		if patterns, err = selectLabelPatterns(labelPats); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if labelRegex != "" {
+		custom, err := compileLabelRegex(labelRegex)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		patterns = append([]labelPattern{custom}, patterns...)
+	}
+	var label quizextract.FileLabel
+	if labelFrom == "canvas" {
+		label = canvasLabel(module, meta.Title, moduleMap, patterns)
+	} else if label, err = resolveLabel(labelFrom, quizName, meta.Title, labelFlag, patterns); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	inputs := [][2]string{
+		{"quiz", quizPath}, {"results", resultPath}, {"results-dir", resultsDir}, {"quiz-meta", metaPath},
+		{"submission", subPath}, {"quiz-stats", statsPath}, {"events", eventsPath}, {"notes", notesPath},
+		{"tags", tagsPath}, {"glossary-terms", glossaryPath}, {"gradebook", gradebookPath},
+		{"lang-file", langFile},
+	}
+	for _, p := range moreResults {
+		inputs = append(inputs, [2]string{"results", p})
+	}
+	var assetFiles []string // images saved by -assets
+	// archive bundles the document written to op, for -archive.
+	archive := func(format string) {
+		if archivePath == "" {
+			return
+		}
+		prov, err := newProvenance(format, outputVersion, inputs, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to record provenance: %v\n", err)
+			os.Exit(1)
+		}
+		var assets []string
+		if format == "html" && cssMode == "link" && cssPath != "" && !strings.Contains(cssPath, "://") {
+			css, _ := filepath.Abs(cssPath)
+			assets = append(assets, css)
+		}
+		assets = append(assets, assetFiles...)
+		docPath, _ := filepath.Abs(outPath)
+		if err := archiveOutputs(archivePath, docPath, assets, prov, now); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write archive %s: %v\n", archivePath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Archived %s\n", archivePath)
+	}
+	// index lists the written documents in INDEX.md, for -index. It runs before commit so the
+	// refreshed index is part of the same commit.
+	index := func(entries []indexEntry) []string {
+		if !writeIndex {
+			return nil
+		}
+		dir := outDir
+		if dir == "" {
+			dir = filepath.Dir(outPath)
+		}
+		dir, _ = filepath.Abs(dir)
+		if err := updateIndex(dir, entries); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to update %s: %v\n", filepath.Join(dir, indexFile), err)
+			os.Exit(1)
+		}
+		fmt.Printf("Updated %s\n", filepath.Join(dir, indexFile))
+		return []string{filepath.Join(dir, indexFile), filepath.Join(dir, indexStateFile)}
+	}
+	// showDiff compares the document about to be written to path with the one already there.
+	// Diffs are collected in diffs when -diff-file is set and printed otherwise.
+	var diffs strings.Builder
+	showDiff := func(path, out string) {
+		if !diffPrev {
+			return
+		}
+		prev, err := os.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			fmt.Printf("%s is new; no previous generation to compare\n", path)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "failed to read previous %s: %v\n", path, err)
+			os.Exit(1)
+		case string(prev) == out:
+			fmt.Printf("%s is unchanged since the previous generation\n", path)
+		case diffFile != "":
+			diffs.WriteString(unifiedDiff(path, path, string(prev), out))
+		default:
+			fmt.Print(unifiedDiff(path, path, string(prev), out))
+		}
+	}
+	writeDiffs := func() {
+		if diffFile == "" {
+			return
+		}
+		if err := writeOutput(diffFile, []byte(diffs.String()), pub); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write diff %s: %v\n", diffFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote changes since the previous generation to %s\n", diffFile)
+	}
+	// record adds the written outputs to the -out-dir manifest read by status and clean.
+	record := func(format string, outputs []string) []string {
+		if outDir == "" || remoteScheme(outPath) != "" || outPath == stdio {
+			return nil
+		}
+		prov, err := newProvenance(format, outputVersion, inputs, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to hash inputs: %v\n", err)
+			os.Exit(1)
+		}
+		for i, in := range prov.Inputs {
+			if !isURL(in.Path) {
+				prov.Inputs[i].Path, _ = filepath.Abs(in.Path)
+			}
+		}
+		dir, _ := filepath.Abs(outDir)
+		abs := make([]string, len(outputs))
+		for i, o := range outputs {
+			abs[i], _ = filepath.Abs(o)
+		}
+		wd, _ := os.Getwd()
+		if err := recordManifest(dir, abs, prov.Inputs, redactArgs(cliArgs), wd, now); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to update %s: %v\n", manifestFile, err)
+			os.Exit(1)
+		}
+		return []string{filepath.Join(dir, manifestFile)}
+	}
+	// commit records the regenerated outputs in git, for -git-commit.
+	commit := func(format, docTitle string, outputs []string) {
+		if !gitCommit {
+			return
+		}
+		prov, err := newProvenance(format, outputVersion, inputs, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to hash inputs: %v\n", err)
+			os.Exit(1)
+		}
+		files := append([]string{}, outputs...)
+		if archivePath != "" {
+			files = append(files, archivePath)
+		}
+		for i := range files {
+			files[i], _ = filepath.Abs(files[i])
+		}
+		committed, err := gitCommitOutputs(files, gitCommitMessage(docTitle, prov))
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "failed to commit outputs: %v\n", err)
+			os.Exit(1)
+		case committed:
+			fmt.Printf("Committed %s\n", strings.Join(outputs, ", "))
+		default:
+			fmt.Println("outputs unchanged; nothing to commit")
+		}
+	}
+
+	kind := "quiz_solutions"
+	if practice {
+		kind = "quiz_practice"
+	}
+	if splitKey && practice {
+		fmt.Fprintln(os.Stderr, "-split writes an answer key, but no results were given")
+		os.Exit(1)
+	}
+	// partPaths names the documents of -split; -split-by parts are named after -out instead.
+	partPaths := map[string]string{}
+	if splitKey {
+		kind = "questions"
+	}
+	if resultsDir != "" {
+		if format != "md" {
+			fmt.Fprintln(os.Stderr, "-results-dir only supports -format md")
+			os.Exit(1)
+		}
+		if publishTarget == "gdoc" || publishTarget == "confluence" {
+			fmt.Fprintf(os.Stderr, "publish %s publishes solutions documents; it cannot be combined with -results-dir\n", publishTarget)
+			os.Exit(1)
+		}
+		kind = "item_analysis"
+	}
+	weekLabel := label.Label // the week column of -format csv and the section of -append-to
+	if weekLabel == "" {
+		weekLabel = label.Title
+	}
+	if appendTo != "" {
+		if weekLabel == "" {
+			fmt.Fprintln(os.Stderr, "-append-to needs the quiz's week label; name the quiz file after its week (e.g. wk12.json) or pass -label-from flag -label \"Week 12\"")
+			os.Exit(1)
+		}
+		outPath = appendTo
+	}
+	if strings.TrimSpace(outPath) == "" {
+		localQuiz := quizPath
+		if isURL(quizPath) || len(quizPages) > 0 || quizPath == stdio {
+			localQuiz = quizName // a URL or stdin writes next to the working directory; pages, without their number
+		}
+		layout := outputLayout{Dir: outDir, Mode: layoutMode, Course: course}
+		outPath, err = deriveOutPath(localQuiz, label, kind, ext, layout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// Two quizzes can derive the same name (same label, or the same layout directory);
+		// the quiz id keeps them apart.
+		quizID := outputOwner(quizextract.ClassicID(meta.ID), quizPath, quizName)
+		if outPath, err = claimOutPath(outPath, quizID); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to claim output path: %v\n", err)
+			os.Exit(1)
+		}
+		if splitKey {
+			answers, err := deriveOutPath(localQuiz, label, "answers", ext, layout)
+			if err == nil {
+				answers, err = claimOutPath(answers, quizID)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to claim output path: %v\n", err)
+				os.Exit(1)
+			}
+			partPaths["questions"], partPaths["answers"] = outPath, answers
+		}
+	}
+
+	qp, rp := quizPath, resultPath
+	switch {
+	case qp == stdio:
+		qp = "standard input"
+	case qp != "" && !isURL(qp):
+		qp, _ = filepath.Abs(qp)
+	}
+	switch {
+	case rp == stdio:
+		rp = "standard input"
+	case rp != "" && !isURL(rp):
+		rp, _ = filepath.Abs(rp)
+	}
+	op := outPath
+	if remoteScheme(op) == "" && op != stdio {
+		op, _ = filepath.Abs(op)
+	}
+
+	if !isClassic && !resultsOnly && !streamed && len(quizPages) == 0 {
+		if quiz, err = quizextract.DecodeQuizItems(quizData); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read quiz JSON %s: %v (run \"validate -schema quiz\" for details)\n", qp, err)
+			os.Exit(1)
+		}
+	}
+	switch {
+	case isClassic:
+		slog.Info("read Classic Quizzes export", "path", qp, "questions", len(classic))
+	case !resultsOnly:
+		slog.Info("read quiz", "path", qp, "items", len(quiz), "streamed", streamed, "pages", 1+len(quizPages))
+	}
+	if choiceOrder == "canonical" {
+		useCanonicalOrder(quiz)
+	}
+	if resultsDir != "" {
+		class, names, err := readResultsDir(resultsDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read results directory: %v\n", err)
+			os.Exit(1)
+		}
+		analysis := quizextract.AnalyzeResults(quiz, class, docTitle(label, op, patterns, "Item Analysis", heading, labels), distractorPct)
+		if gradebookPath != "" {
+			gb, err := readGradebook(gradebookPath)
+			var groups []string
+			if err == nil {
+				groups, err = groupStudents(gb, groupBy, names)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read gradebook %s: %v\n", gradebookPath, err)
+				os.Exit(1)
+			}
+			for i, g := range groups {
+				if g == quizextract.NotInGradebook {
+					warnf("%s.json matches no ID, SIS User ID or SIS Login ID in the gradebook", names[i])
+				}
+			}
+			analysis.GroupBy, analysis.Groups = groupBy, quizextract.AnalyzeGroups(quiz, class, groups, distractorPct)
+		}
+		out := quizextract.RenderItemAnalysisMarkdown(analysis)
+		showDiff(op, out)
+		writeDiffs()
+		if err := writeOutput(op, []byte(out), pub); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write item analysis %s: %v\n", op, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Generated %s from %s and %d result files in %s\n", op, qp, len(class), resultsDir)
+		archive("md")
+		analysisDoc := quizextract.BuildQuizDoc(quiz, nil, analysis.Title)
+		entry := newIndexEntry(op, analysisDoc, quizextract.ComputeStats(analysisDoc, label, quiz, class))
+		entry.Warnings = nil // the item analysis needs no per-question results
+		tracked := append([]string{op}, index([]indexEntry{entry})...)
+		commit("md", analysis.Title, append(tracked, record("md", []string{op})...))
+		if publishTarget != "" {
+			doc := quizextract.BuildQuizDoc(quiz, nil, analysis.Title)
+			runPublish(publishTarget, pub, doc, quizextract.ComputeStats(doc, label, quiz, class))
+		}
+		finishRun(summaryPath, runSummary{Outputs: []string{op}, Questions: len(quiz)}, false)
+	}
+	// A Classic Quizzes export carries its key; -results can add the student's attempts.
+	var classicSubs []quizextract.ClassicSubmission
+	if isClassic && resultPath != "" && resultPath != quizPath {
+		if classicSubs, err = readClassicSubmissions(resultPath, urlToken); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read Classic Quizzes submission %s: %v\n", rp, err)
+			os.Exit(1)
+		}
+	}
+	var results []quizextract.ResultItem
+	if !isClassic && !practice {
+		if results, err = readResults(resultPath, urlToken); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read result JSON %s: %v (run \"validate -schema results\" for details)\n", rp, err)
+			os.Exit(1)
+		}
+	}
+	attempts, attemptNames := [][]quizextract.ResultItem{results}, []string{strings.TrimSuffix(filepath.Base(resultPath), filepath.Ext(resultPath))}
+	for _, p := range moreResults {
+		more, err := readResults(p, urlToken)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read result JSON %s: %v (run \"validate -schema results\" for details)\n", p, err)
+			os.Exit(1)
+		}
+		attempts = append(attempts, more)
+		attemptNames = append(attemptNames, strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)))
+	}
+
+	var submission Submission
+	if subPath != "" {
+		if err := mustReadJSON(subPath, &submission); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read submission %s: %v\n", subPath, err)
+			os.Exit(1)
+		}
+	}
+
+	var doc quizextract.QuizDoc
+	switch {
+	case isClassic && classicSubs != nil:
+		doc = quizextract.BuildClassicSubmissionDoc(classic, classicSubs, docTitle(label, op, patterns, "Questions and Solutions", heading, labels))
+	case isClassic:
+		doc = quizextract.BuildClassicDoc(classic, docTitle(label, op, patterns, "Questions and Solutions", heading, labels))
+	case practice:
+		doc = quizextract.BuildPracticeDoc(quiz, docTitle(label, op, patterns, "Practice Questions", heading, labels))
+	case resultsOnly:
+		doc = quizextract.BuildResultsDoc(results, docTitle(label, op, patterns, "Questions and Solutions", heading, labels))
+	case len(attempts) > 1:
+		doc = quizextract.MergeAttempts(quiz, attempts, attemptNames, docTitle(label, op, patterns, "Questions and Solutions", heading, labels))
+		results = attempts[len(attempts)-1]
+	default:
+		doc = quizextract.BuildQuizDoc(quiz, results, docTitle(label, op, patterns, "Questions and Solutions", heading, labels))
+	}
+	if dumpDir != "" {
+		decoded, dumpQuiz := any(map[string]any{"quiz": quiz, "results": results}), quiz
+		if isClassic {
+			decoded, dumpQuiz = classic, nil
+		}
+		src := qp
+		if resultsOnly {
+			src = rp
+		}
+		paths, err := dumpStages(dumpDir, quizFilePrefix(src), decoded, dumpQuiz, doc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to dump stages: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Dumped parsing stages to %s\n", strings.Join(paths, ", "))
+	}
+	for _, q := range doc.Questions {
+		if q.Repaired {
+			warnf("question %d (item %s) has malformed HTML; stray tags were escaped or closed", q.Number, q.ItemID)
+		}
+	}
+	diagnostics := quizextract.Diagnose(doc) // reported at the end, after the outputs
+	// Filter first so explanation sources (notably -llm-cmd) only run for kept questions.
+	unanswered, anyUnanswered := unansweredDetail(doc.Questions) // counted before filtering
+	score, anyScore := scoreDetail(doc.Questions)
+	info := quizextract.QuizInfo{Title: meta.Title, Attempt: submission.Attempt, SubmittedAt: submission.SubmittedAt}
+	for _, q := range doc.Questions {
+		info.Points += q.Possible // the whole quiz, before filtering
+	}
+	if meta.Points != nil {
+		info.Points = *meta.Points
+	}
+	if info.Attempt == 0 && len(attempts) == 1 {
+		info.Attempt = quizextract.ResultsAttempt(results)
+	}
+	if last := len(classicSubs) - 1; last >= 0 && info.Attempt == 0 {
+		info.Attempt, info.SubmittedAt = classicSubs[last].Attempt, classicSubs[last].SubmittedAt
+	}
+	if verifyScore {
+		checkScore(doc, results, submission, meta, classicSubs, isClassic || len(attempts) > 1)
+	}
+	if strings.TrimSpace(bankFilter) != "" {
+		doc.FilterBanks(strings.Split(bankFilter, ","))
+	}
+	if onlyFilter != "" {
+		doc.FilterResponses(strings.Split(onlyFilter, ","))
+	}
+	if strings.TrimSpace(typesFilter) != "" {
+		doc.FilterTypes(strings.Split(typesFilter, ","))
+	}
+	doc.FilterNumbers(questionNumbers)
+	if len(doc.Questions) == 0 && (typesFilter != "" || numbersFilter != "") {
+		warnf("no question is left after -types and -questions")
+	}
+	if !practice { // an explanation would give the answer away
+		for _, w := range quizextract.ApplyExplanations(&doc, explainCfg) {
+			warnf("%s", w)
+		}
+	}
+	doc.ApplyMeta(meta)
+	doc.ApplyQuizInfo(info)
+	if showResponses {
+		doc.ShowResponses = true
+		if anyScore {
+			doc.Details = append(doc.Details, score)
+		}
+	}
+	if anyUnanswered {
+		doc.Details = append(doc.Details, unanswered)
+	}
+	if annotateKeys {
+		doc.AnnotateKeyConfidence()
+	}
+	if len(rules) > 0 {
+		doc.MapText(rules.apply)
+		for _, r := range rules {
+			if r.Matches == 0 {
+				warnf("%q matched nothing", r.Pattern)
+			}
+		}
+	}
+	if tagsPath != "" {
+		tags, err := readTags(tagsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read tags %s: %v\n", tagsPath, err)
+			os.Exit(1)
+		}
+		doc.ApplyTags(tags)
+	}
+	if bloomMode != "" {
+		for _, w := range doc.ApplyBloom(bloomMode, explainCfg.LLM) {
+			warnf("%s", w)
+		}
+	}
+	if format != "html" || publishTarget != "" {
+		doc.MapText(quizextract.ChemFallback) // only the HTML output typesets \ce{...}
+	}
+	if debugIDs {
+		doc.AppendDebugIDs()
+	}
+	if statsPath != "" {
+		stats, err := readQuizStatistics(statsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read quiz statistics %s: %v\n", statsPath, err)
+			os.Exit(1)
+		}
+		doc.ApplyClassStats(stats)
+	}
+	if eventsPath != "" {
+		if format != "md" && format != "html" {
+			fmt.Fprintln(os.Stderr, "-events only supports -format md and html")
+			os.Exit(1)
+		}
+		events, err := readSubmissionEvents(eventsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read submission events %s: %v\n", eventsPath, err)
+			os.Exit(1)
+		}
+		doc.Replay = quizextract.BuildReplay(events, doc)
+	}
+	if glossary || glossaryPath != "" {
+		if format != "md" && format != "html" {
+			fmt.Fprintln(os.Stderr, "-glossary only supports -format md and html")
+			os.Exit(1)
+		}
+		var terms map[string]string
+		if glossaryPath != "" {
+			if terms, err = readGlossaryTerms(glossaryPath); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read glossary terms %s: %v\n", glossaryPath, err)
+				os.Exit(1)
+			}
+		}
+		doc.Glossary = quizextract.BuildGlossary(doc, terms)
+	}
+	if saveAssets {
+		if format != "md" && format != "html" {
+			fmt.Fprintln(os.Stderr, "-assets only supports -format md and html")
+			os.Exit(1)
+		}
+		if remoteScheme(op) != "" {
+			fmt.Fprintln(os.Stderr, "-assets saves images next to the output; it cannot be used when -out is a URL")
+			os.Exit(1)
+		}
+		dir := assetsDir
+		if dir == "" {
+			dir = filepath.Join(filepath.Dir(op), "assets")
+		}
+		dir, _ = filepath.Abs(dir)
+		if doc.Assets, assetFiles, err = downloadAssets(doc, dir, filepath.Dir(op), linkBase, canvasURL, jobs, imageFetcher(linkBase, imageToken)); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save images to %s: %v\n", dir, err)
+			os.Exit(1)
+		}
+	}
+	if doc.Comments, err = quizextract.ParseComments(submission.Comments); err != nil {
+		warnf("submission %v", err)
+	}
+	doc.Labels = labels
+	parts := []quizextract.DocPart{{Doc: doc}}
+	switch splitBy {
+	case "tag":
+		parts = quizextract.SplitDoc(doc, func(q quizextract.Question) []string { return q.Tags }, "untagged")
+	case "type":
+		parts = quizextract.SplitDoc(doc, func(q quizextract.Question) []string { return []string{q.Type} }, "other")
+	}
+	if splitKey {
+		questions, key := quizextract.SplitKey(doc)
+		questions.Title = docTitle(label, op, patterns, "Questions", heading, labels)
+		key.Title = docTitle(label, op, patterns, "Answer Key", heading, labels)
+		parts = []quizextract.DocPart{{Key: "questions", Doc: questions}, {Key: "answers", Doc: key}}
+	}
+	var written []string
+	var entries []indexEntry
+	for _, part := range parts {
+		path := op
+		switch {
+		case partPaths[part.Key] != "":
+			path = partPaths[part.Key]
+		case part.Key != "":
+			path = splitPath(op, part.Key)
+		}
+		out, warnings, err := quizextract.Render(part.Doc, quizextract.RenderOptions{
+			Format:         format,
+			Version:        outputVersion,
+			Width:          wrapWidth,
+			QuizizzSeconds: quizizzTime,
+			Label:          weekLabel,
+			Flavor:         flavor,
+			Obsidian:       quizextract.ObsidianOptions{Course: course, Week: weekLabel, Date: now.Format("2006-01-02")},
+			HTML:           quizextract.HTMLOptions{Theme: theme, CSSPath: cssPath, CSSMode: cssMode, OutPath: path, Math: mathMode, KaTeXDir: katexDir, LinkBase: linkBase, Lang: lang},
+			PDF:            quizextract.PDFOptions{PageBreaks: pageBreaks, LinkBase: linkBase, FetchImage: imageFetcher(linkBase, imageToken)},
+			Docx:           quizextract.DocxOptions{PageBreaks: pageBreaks},
+			Template:       tmpl,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to render %s: %v\n", format, err)
+			os.Exit(1)
+		}
+		for _, w := range warnings {
+			warnf("%s: %s", format, w)
+		}
+		shown := path
+		if path == stdio {
+			shown = "standard output"
+		}
+		if appendTo != "" {
+			if err := appendSection(path, weekLabel, out); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to update %s: %v\n", path, err)
+				os.Exit(1)
+			}
+			shown = fmt.Sprintf("the %s section of %s", weekLabel, path)
+		} else {
+			showDiff(path, out)
+			if err := writeOutput(path, []byte(out), pub); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write %s %s: %v\n", format, path, err)
+				os.Exit(1)
+			}
+		}
+		switch {
+		case isClassic && classicSubs != nil:
+			fmt.Printf("Generated %s from Classic Quizzes export %s and %s\n", shown, qp, rp)
+		case isClassic:
+			fmt.Printf("Generated %s from Classic Quizzes export %s\n", shown, qp)
+		case practice:
+			fmt.Printf("Generated practice sheet %s from %s\n", shown, qp)
+		case resultsOnly:
+			fmt.Printf("Generated %s from %s alone\n", shown, rp)
+		case len(attempts) > 1:
+			fmt.Printf("Generated %s from %s and %d results files\n", shown, qp, len(attempts))
+		default:
+			fmt.Printf("Generated %s from %s and %s\n", shown, qp, rp)
+		}
+		written = append(written, path)
+		if audioFormat != "" {
+			audioPath := strings.TrimSuffix(path, filepath.Ext(path)) + "." + audioFormat
+			if err := synthesize(quizextract.SpeechScript(part.Doc), ttsEngine, ttsCmd, audioPath); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write audio %s: %v\n", audioPath, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Read %s aloud into %s\n", path, audioPath)
+			written = append(written, audioPath)
+		}
+		var class [][]quizextract.ResultItem
+		if !isClassic {
+			class = [][]quizextract.ResultItem{results}
+		}
+		entries = append(entries, newIndexEntry(path, part.Doc, quizextract.ComputeStats(part.Doc, label, quiz, class)))
+	}
+	writeDiffs()
+	if scoresPath != "" {
+		data, err := quizextract.ScoresCSV(doc)
+		if err == nil {
+			err = writeOutput(scoresPath, data, pub)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write scores %s: %v\n", scoresPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote per-question scores to %s\n", scoresPath)
+		written = append(written, scoresPath)
+	}
+	archive(format)
+	tracked := append(append(append([]string{}, written...), assetFiles...), index(entries)...)
+	if appendTo == "" { // the master document holds other weeks too; clean must not remove it
+		tracked = append(tracked, record(format, written)...)
+	}
+	commit(format, doc.Title, tracked)
+	if publishTarget != "" {
+		runPublish(publishTarget, pub, doc, quizextract.ComputeStats(doc, label, quiz, [][]quizextract.ResultItem{results}))
+	}
+	summary := runSummary{Outputs: written}
+	summary.count(doc)
+	if len(diagnostics) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d problem(s) kept questions from being fully extracted:\n", len(diagnostics))
+		for _, d := range diagnostics {
+			fmt.Fprintf(os.Stderr, "  %s\n", d)
+			summary.Problems = append(summary.Problems, d.String())
+		}
+	}
+	finishRun(summaryPath, summary, strict && len(diagnostics) > 0)
+}
 
-	if err := writeMarkdown(op, quiz, results, weekLabel); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to write markdown %s: %v\n", op, err)
+// runPublish publishes doc and st to target, exiting on failure.
+func runPublish(target string, cfg publishConfig, doc quizextract.QuizDoc, st quizextract.QuizStats) {
+	if err := publish(target, cfg, doc, st); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to publish to %s: %v\n", target, err)
 		os.Exit(1)
 	}
-	fmt.Printf("Generated %s from %s and %s\n", op, qp, rp)
+	fmt.Printf("Published %s to %s\n", st.Title, target)
 }
@@ -0,0 +1,11995 @@
+// Package quizextract turns Canvas quiz payloads into study documents. It parses New
+// Quizzes items and Classic Quizzes exports with a student's item results, normalizes them
+// into a QuizDoc, and renders that as Markdown, HTML and the other formats of the
+// canvas_quiz_extractor command, which is a thin wrapper around it.
+//
+// Most callers need three functions:
+//
+//	doc, err := quizextract.Parse(quizJSON, resultsJSON, "Week 12")
+//	doc, err := quizextract.Merge(quizJSON, [][]byte{first, regrade}, nil, "Week 12")
+//	out, warnings, err := quizextract.Render(doc, quizextract.RenderOptions{Format: "html"})
+//
+// The steps they combine (DecodeQuizItems, DecodeResults, BuildQuizDoc, MergeAttempts and
+// the Render* functions) are exported for callers that need to work between them, e.g. to
+// redact text with QuizDoc.MapText before rendering.
+package quizextract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif" // decoders for the images RenderPDF embeds
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Parse builds the document for one quiz and one student's results. quizJSON holds New
+// Quizzes items or a Classic Quizzes export, resultsJSON the item results, bare or wrapped in
+// a submission payload. Classic exports carry their own key and need no results; New Quizzes
+// items without results (nil) give a practice sheet.
+func Parse(quizJSON, resultsJSON []byte, title string) (QuizDoc, error) {
+	classic, isClassic, err := ParseClassicQuestions(quizJSON)
+	if err != nil {
+		return QuizDoc{}, err
+	}
+	if isClassic {
+		return BuildClassicDoc(classic, title), nil
+	}
+	quiz, err := DecodeQuizItems(quizJSON)
+	if err != nil {
+		return QuizDoc{}, err
+	}
+	if resultsJSON == nil {
+		return BuildPracticeDoc(quiz, title), nil
+	}
+	results, err := DecodeResults(resultsJSON)
+	if err != nil {
+		return QuizDoc{}, err
+	}
+	return BuildQuizDoc(quiz, results, title), nil
+}
+
+// Merge builds one document from several results of the same New Quizzes quiz, such as
+// attempts or regrades, oldest first (see MergeAttempts). names label the attempts when the
+// results don't number them; nil uses "Attempt N".
+func Merge(quizJSON []byte, attempts [][]byte, names []string, title string) (QuizDoc, error) {
+	quiz, err := DecodeQuizItems(quizJSON)
+	if err != nil {
+		return QuizDoc{}, err
+	}
+	if len(attempts) == 0 {
+		return QuizDoc{}, errors.New("no results to merge")
+	}
+	all := make([][]ResultItem, len(attempts))
+	for i, b := range attempts {
+		if all[i], err = DecodeResults(b); err != nil {
+			return QuizDoc{}, fmt.Errorf("results %d: %v", i+1, err)
+		}
+	}
+	return MergeAttempts(quiz, all, names, title), nil
+}
+
+// Logger receives debug records tracing how each item was decoded: the form its choices came
+// in, the shape of its scored_data.value and where its key was read from. It is nil, and
+// nothing is logged, unless the caller sets it (canvas_quiz_extractor -debug does).
+var Logger *slog.Logger
+
+// logDebug logs msg at debug level to Logger, if one is set.
+func logDebug(msg string, args ...any) {
+	if Logger != nil {
+		Logger.Debug(msg, args...)
+	}
+}
+
+// RenderOptions selects the format Render writes and its settings.
+type RenderOptions struct {
+	Format         string             // md (the default), html, mediawiki, rst, adoc, txt, quizizz, anki, json, pdf, docx or csv
+	Version        int                // md: 1 for the original layout, anything else for the current one
+	Width          int                // txt: line width; 0 disables wrapping
+	QuizizzSeconds int                // quizizz: time limit of every question
+	Label          string             // csv: the quiz label of the week column, e.g. "WK12"
+	Flavor         string             // md: "" for plain Markdown, or "obsidian" (see RenderObsidian)
+	Obsidian       ObsidianOptions    // md with Flavor "obsidian": frontmatter fields
+	HTML           HTMLOptions        // html: theme, stylesheet and math
+	PDF            PDFOptions         // pdf: page breaks and images
+	Docx           DocxOptions        // docx: page breaks
+	Template       *template.Template // replaces the layout of Format when set (see ParseTemplate)
+}
+
+// Render renders doc in opts.Format. The warnings name what the format could not hold, such
+// as the questions a Quizizz sheet leaves out.
+func Render(doc QuizDoc, opts RenderOptions) (string, []string, error) {
+	if opts.Template != nil {
+		out, err := RenderTemplate(doc, opts.Template)
+		return out, nil, err
+	}
+	switch opts.Format {
+	case "", "md":
+		switch opts.Flavor {
+		case "":
+		case "obsidian":
+			return RenderObsidian(doc, opts.Obsidian), nil, nil
+		case "notes":
+			return RenderStudyNotes(doc), nil, nil
+		default:
+			return "", nil, fmt.Errorf("unknown Markdown flavor %q", opts.Flavor)
+		}
+		if opts.Version == 1 {
+			return RenderMarkdownV1(doc), nil, nil
+		}
+		return RenderMarkdown(doc), nil, nil
+	case "html":
+		out, err := RenderHTML(doc, opts.HTML)
+		return out, nil, err
+	case "mediawiki":
+		return RenderMediaWiki(doc), nil, nil
+	case "rst":
+		return RenderRST(doc), nil, nil
+	case "adoc":
+		return RenderAsciiDoc(doc), nil, nil
+	case "txt":
+		return RenderText(doc, opts.Width), nil, nil
+	case "quizizz":
+		data, warnings := RenderQuizizz(doc, opts.QuizizzSeconds)
+		return string(data), warnings, nil
+	case "anki":
+		out, warnings := RenderAnki(doc)
+		return out, warnings, nil
+	case "json":
+		out, err := RenderJSON(doc)
+		return out, nil, err
+	case "pdf":
+		out, warnings := RenderPDF(doc, opts.PDF)
+		return string(out), warnings, nil
+	case "docx":
+		out, warnings := RenderDocx(doc, opts.Docx)
+		return string(out), warnings, nil
+	case "csv":
+		out, err := RenderCSV(doc, opts.Label)
+		return string(out), nil, err
+	}
+	return "", nil, fmt.Errorf("unknown format %q", opts.Format)
+}
+
+type QuizChoice struct {
+	ItemBody string `json:"item_body"`
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+}
+
+// UnmarshalJSON reads the id as text whether it is a JSON string or a number, since some
+// exports number their choices; an id of another kind is an error.
+func (c *QuizChoice) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		ItemBody string          `json:"item_body"`
+		ID       json.RawMessage `json:"id"`
+		Position int             `json:"position"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	id, err := decodeID(raw.ID)
+	if err != nil {
+		return fmt.Errorf("choice %w", err)
+	}
+	*c = QuizChoice{ItemBody: raw.ItemBody, ID: id, Position: raw.Position}
+	return nil
+}
+
+// decodeID reads an id given as a JSON string or number, keeping a number's digits as
+// written so long ids don't lose precision. A missing or null id is "".
+func decodeID(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n.String(), nil
+	}
+	return "", fmt.Errorf("id is %s, not a string or a number", valueShape(raw))
+}
+
+type QuizBlank struct {
+	AnswerType string       `json:"answer_type"` // openEntry, dropdown or wordbank
+	ID         string       `json:"id"`
+	Choices    []QuizChoice `json:"choices"` // dropdown options for this blank
+}
+
+// UnmarshalJSON reads the id as a string or a number (see decodeID).
+func (b *QuizBlank) UnmarshalJSON(data []byte) error {
+	type plain QuizBlank
+	var raw struct {
+		plain
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*b = QuizBlank(raw.plain)
+	var err error
+	if b.ID, err = decodeID(raw.ID); err != nil {
+		return fmt.Errorf("blank %w", err)
+	}
+	return nil
+}
+
+type InteractionData struct {
+	Blanks        []QuizBlank     `json:"blanks"`
+	WordBank      []QuizChoice    `json:"word_bank_choices"` // shared entries dragged into wordbank blanks
+	Passage       string          `json:"passage"`           // hot-text passage HTML with selectable spans
+	Scale         []QuizChoice    `json:"scale"`             // Likert/scale labels, lowest first
+	Choices       []QuizChoice    // normalized slice after unmarshal
+	TrueChoice    string          `json:"true_choice"`
+	FalseChoice   string          `json:"false_choice"`
+	ShuffledOrder []string        `json:"shuffled_order"`
+	RawChoices    json.RawMessage `json:"choices"`        // holds raw map/array for secondary parse
+	Questions     []QuizChoice    `json:"questions"`      // matching: the prompts
+	Answers       []string        `json:"answers"`        // matching: the answers prompts are matched with, distractors included
+	Categories    json.RawMessage `json:"categories"`     // categorization: the categories, keyed by id or as an array
+	Distractors   json.RawMessage `json:"distractors"`    // categorization: every item to sort, those in no category included
+	CategoryOrder []string        `json:"category_order"` // categorization: category ids in display order
+	ImageURL      string          `json:"image_url"`      // hot spot: the image the student clicks on
+}
+
+// ItemFeedback is the item-level feedback authored in New Quizzes. neutral is shown to
+// everyone; correct/incorrect depend on how the question was answered.
+type ItemFeedback struct {
+	Neutral   string `json:"neutral"`
+	Correct   string `json:"correct"`
+	Incorrect string `json:"incorrect"`
+}
+
+type QuizItemInner struct {
+	InteractionData  InteractionData `json:"interaction_data"`
+	ItemBody         string          `json:"item_body"`
+	Body             string          `json:"body"` // stimulus: the passage its items refer to
+	UserResponseType string          `json:"user_response_type"`
+	Title            string          `json:"title"`
+	ID               string          `json:"id"`              // a string or a number in the JSON (see UnmarshalJSON)
+	AnswerFeedback   json.RawMessage `json:"answer_feedback"` // per-choice feedback keyed by choice id
+	Feedback         ItemFeedback    `json:"feedback"`
+	ScoringData      json.RawMessage `json:"scoring_data"`      // authored answer key, present in instructor/export payloads
+	ScoringAlgorithm string          `json:"scoring_algorithm"` // e.g. AllOrNothing or PartialScore
+	Bank             *QuizBank       `json:"bank"`
+	Rubric           json.RawMessage `json:"rubric"` // essay rubric: criteria array or {"criteria": [...]}
+	InteractionType  struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+		ID   string `json:"id"`
+	} `json:"interaction_type"`
+}
+
+// UnmarshalJSON reads the id as a string or a number (see decodeID).
+func (it *QuizItemInner) UnmarshalJSON(data []byte) error {
+	type plain QuizItemInner
+	var raw struct {
+		plain
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*it = QuizItemInner(raw.plain)
+	var err error
+	if it.ID, err = decodeID(raw.ID); err != nil {
+		return fmt.Errorf("item %w", err)
+	}
+	return nil
+}
+
+// QuizGroup describes the question group (or bank draw) an item was picked from.
+// Canvas exports name the counts differently, so both spellings are accepted.
+type QuizGroup struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	PickCount  int    `json:"pick_count"`
+	SampleNum  int    `json:"sample_num"`
+	ItemCount  int    `json:"item_count"`
+	EntryCount int    `json:"entry_count"`
+}
+
+type QuizItem struct {
+	ID             string        `json:"id"`                     // quiz entry id, which stimulus items point at
+	EntryType      string        `json:"entry_type"`             // "Item", or "Stimulus" for a shared passage
+	StimulusID     string        `json:"stimulus_quiz_entry_id"` // the stimulus this item belongs to
+	Stimulus       *QuizStimulus `json:"-"`                      // set by attachStimuli
+	CalculatorType string        `json:"calculator_type"`
+	Item           QuizItemInner `json:"item"`
+	PointsPossible float64       `json:"points_possible"`
+	Position       int           `json:"position"`
+	QuestionNumber int           `json:"question_number"`
+	Group          *QuizGroup    `json:"group"`
+	Bank           *QuizBank     `json:"bank"`
+}
+
+// UnmarshalJSON reads the entry and stimulus ids as strings or numbers (see decodeID).
+func (q *QuizItem) UnmarshalJSON(data []byte) error {
+	type plain QuizItem
+	var raw struct {
+		plain
+		ID         json.RawMessage `json:"id"`
+		StimulusID json.RawMessage `json:"stimulus_quiz_entry_id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*q = QuizItem(raw.plain)
+	var err error
+	if q.ID, err = decodeID(raw.ID); err != nil {
+		return fmt.Errorf("quiz entry %w", err)
+	}
+	if q.StimulusID, err = decodeID(raw.StimulusID); err != nil {
+		return fmt.Errorf("stimulus_quiz_entry_id: %w", err)
+	}
+	return nil
+}
+
+// QuizStimulus is a passage, such as a reading, that several quiz items are asked about.
+type QuizStimulus struct {
+	ID    string
+	Title string
+	Body  string // HTML
+}
+
+// attachStimuli removes the stimulus entries from quiz and attaches each to the items that
+// point at it. A stimulus is not a question, so it takes no question number.
+func attachStimuli(quiz []QuizItem) []QuizItem {
+	stimuli := map[string]*QuizStimulus{}
+	var items []QuizItem
+	for _, q := range quiz {
+		if !strings.EqualFold(q.EntryType, "Stimulus") {
+			items = append(items, q)
+			continue
+		}
+		body := q.Item.Body
+		if body == "" {
+			body = q.Item.ItemBody
+		}
+		stimuli[q.ID] = &QuizStimulus{ID: q.ID, Title: strings.TrimSpace(q.Item.Title), Body: body}
+	}
+	if len(stimuli) == 0 {
+		return quiz
+	}
+	for i := range items {
+		if items[i].StimulusID != "" {
+			items[i].Stimulus = stimuli[items[i].StimulusID]
+		}
+	}
+	return items
+}
+
+// QuizBank identifies the item bank a question was drawn from.
+type QuizBank struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type ResultValueEntry struct {
+	ResultScore   *int   `json:"result_score,omitempty"`
+	UserResponded *bool  `json:"user_responded,omitempty"`
+	Correct       *bool  `json:"correct,omitempty"`
+	UserResponse  string `json:"user_response,omitempty"`
+	CorrectAnswer string `json:"correct_answer,omitempty"`
+}
+
+type ScoredData struct {
+	Correct  bool            `json:"correct"`
+	ValueRaw json.RawMessage `json:"value"`
+}
+
+type ResultItem struct {
+	ItemID         string          `json:"item_id"` // a string or a number in the JSON (see UnmarshalJSON)
+	Position       int             `json:"position"`
+	Attempt        int             `json:"attempt"`
+	Score          float64         `json:"score"`
+	PointsPossible float64         `json:"points_possible"`
+	Scored         ScoredData      `json:"scored_data"`
+	AnswerFeedback json.RawMessage `json:"answer_feedback"`
+	Rubric         json.RawMessage `json:"rubric"`            // some exports attach the rubric to the result
+	RubricAssess   json.RawMessage `json:"rubric_assessment"` // criterion id -> {points, rating_id, comments}
+	Comments       json.RawMessage `json:"comments"`          // per-question instructor comments
+	Comment        string          `json:"comment"`
+	Feedback       struct {
+		ItemFeedback ItemFeedback `json:"item_feedback"`
+	} `json:"feedback"`
+}
+
+// UnmarshalJSON reads item_id as a string or a number (see decodeID).
+func (r *ResultItem) UnmarshalJSON(data []byte) error {
+	type plain ResultItem
+	var raw struct {
+		plain
+		ItemID json.RawMessage `json:"item_id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = ResultItem(raw.plain)
+	var err error
+	if r.ItemID, err = decodeID(raw.ItemID); err != nil {
+		return fmt.Errorf("result item_id: %w", err)
+	}
+	return nil
+}
+
+// QuizMeta is the quiz-level object (New Quizzes GET /api/quiz/v1/courses/:course_id/quizzes/:id,
+// or a Classic Quizzes quiz), supplied with -quiz-meta.
+type QuizMeta struct {
+	ID           any      `json:"id"`
+	Title        string   `json:"title"`
+	Instructions string   `json:"instructions"` // New Quizzes
+	Description  string   `json:"description"`  // Classic Quizzes
+	DueAt        string   `json:"due_at"`
+	Points       *float64 `json:"points_possible"`
+	UnlockAt     string   `json:"unlock_at"`
+	LockAt       string   `json:"lock_at"`
+
+	// New Quizzes keeps the exam conditions under quiz_settings.
+	Settings struct {
+		HasTimeLimit              bool   `json:"has_time_limit"`
+		SessionTimeLimitInSeconds int    `json:"session_time_limit_in_seconds"`
+		ShuffleAnswers            *bool  `json:"shuffle_answers"`
+		ShuffleQuestions          *bool  `json:"shuffle_questions"`
+		OneAtATimeType            string `json:"one_at_a_time_type"`
+		MultipleAttempts          struct {
+			Enabled      bool   `json:"multiple_attempts_enabled"`
+			AttemptLimit bool   `json:"attempt_limit"`
+			MaxAttempts  int    `json:"max_attempts"`
+			ScoreToKeep  string `json:"score_to_keep"`
+		} `json:"multiple_attempts"`
+	} `json:"quiz_settings"`
+
+	// Classic Quizzes equivalents.
+	TimeLimit          *int   `json:"time_limit"` // minutes
+	AllowedAttempts    *int   `json:"allowed_attempts"`
+	ShuffleAnswers     *bool  `json:"shuffle_answers"`
+	ScoringPolicy      string `json:"scoring_policy"`
+	OneQuestionAtATime *bool  `json:"one_question_at_a_time"`
+}
+
+var (
+	reUnderscores  = regexp.MustCompile(`_{3,}`)
+	reEndPunct     = regexp.MustCompile(`([\.!\?])$`)
+	reBlankSpan    = regexp.MustCompile(`(?i)<span[^>]*id=\"blank_[^\"]*\"[^>]*></span>`) // greedy span with id starting blank_
+	reParagraphEnd = regexp.MustCompile(`(?i)</p>|<br\s*/?>|</li>|</div>|</h[1-6]>`)
+	reHotTextSpan  = regexp.MustCompile(`(?is)<span[^>]*?\b(?:data-hot-text-id|data-id|id)="([^"]+)"[^>]*>(.*?)</span>`)
+	reTag          = regexp.MustCompile(`<[^>]*>`)
+)
+
+// annotateBlanks replaces runs of underscores (___) with labeled placeholders [Blank i].
+// If the number of detected placeholders is fewer than provided blanks, it will still
+// label what it finds and rely on the list summary for remaining blanks.
+func annotateBlanks(question string, blanksCount int) string {
+	if blanksCount <= 0 || question == "" {
+		return question
+	}
+	i := 0
+	annotated := reUnderscores.ReplaceAllStringFunc(question, func(_ string) string {
+		i++
+		return fmt.Sprintf("[Blank %d]", i)
+	})
+	// If we couldn't find any obvious placeholders and there's exactly one blank,
+	// place it before the final punctuation (., ?, !) as a reasonable default.
+	if i == 0 && blanksCount == 1 {
+		if reEndPunct.MatchString(annotated) {
+			annotated = reEndPunct.ReplaceAllString(annotated, " [Blank 1]$1")
+		} else {
+			annotated = annotated + " [Blank 1]"
+		}
+	}
+	return annotated
+}
+
+// annotateBlanksFromHTML replaces explicit blank spans in HTML (e.g., <span id="blank_..."></span>)
+// with [Blank i] markers, then strips HTML to plain text.
+func annotateBlanksFromHTML(htmlQuestion string, blanks []QuizBlank) string {
+	if strings.TrimSpace(htmlQuestion) == "" {
+		return ""
+	}
+	// Replace <span id="blank_..."></span> with [Blank i]
+	i := 0
+	replaced := reBlankSpan.ReplaceAllStringFunc(htmlQuestion, func(_ string) string {
+		i++
+		return fmt.Sprintf("[Blank %d]", i)
+	})
+	if i > 0 {
+		// Now strip remaining HTML
+		return stripHTML(replaced)
+	}
+	// Fallback to generic underscore-based annotation on stripped text
+	return annotateBlanks(stripHTML(htmlQuestion), len(blanks))
+}
+
+// htmlParagraphs splits an HTML block into plain-text paragraphs at block-level boundaries.
+func htmlParagraphs(s string) []string {
+	var out []string
+	for _, part := range reParagraphEnd.Split(s, -1) {
+		if text := stripHTML(part); text != "" {
+			out = append(out, text)
+		}
+	}
+	return out
+}
+
+// stripHTML drops the tags of a short HTML fragment (see tokenizeHTML) and unescapes entities.
+func stripHTML(s string) string {
+	s = replaceMathML(s)
+	var b strings.Builder
+	var script map[rune]rune // inside <sub> or <sup>
+	var scripted strings.Builder
+	var isolated []string // open elements that carried a dir attribute
+	for _, tok := range tokenizeHTML(s) {
+		switch {
+		case tok.IsTag && !tok.Closing && !tok.SelfClosing && tagDir(tok.Raw) != "":
+			// <p dir="rtl"> has no Markdown form; an isolate keeps the run's direction in plain text.
+			b.WriteRune(bidiIsolates[tagDir(tok.Raw)])
+			isolated = append(isolated, tok.Name)
+		case tok.IsTag && tok.Closing && len(isolated) > 0 && isolated[len(isolated)-1] == tok.Name:
+			b.WriteRune(popDirectionalIsolate)
+			isolated = isolated[:len(isolated)-1]
+		case !tok.IsTag && script != nil:
+			scripted.WriteString(tok.Raw)
+		case !tok.IsTag:
+			b.WriteString(tok.Raw)
+		case (tok.Name == "sub" || tok.Name == "sup") && !tok.Closing:
+			script = superscripts
+			if tok.Name == "sub" {
+				script = subscripts
+			}
+			scripted.Reset()
+		case (tok.Name == "sub" || tok.Name == "sup") && script != nil:
+			// H<sub>2</sub>O reads as H₂O; text without a Unicode form stays on the line.
+			if out, ok := toScript(html.UnescapeString(scripted.String()), script); ok {
+				b.WriteString(html.EscapeString(out))
+			} else {
+				b.WriteString(scripted.String())
+			}
+			script = nil
+		case tok.Name == "img":
+			if tex := equationLaTeX(tok.Raw); tex != "" {
+				b.WriteString(html.EscapeString(`\(` + tex + `\)`)) // unescaped again below
+			}
+		}
+	}
+	if script != nil {
+		b.WriteString(scripted.String()) // unclosed <sub> or <sup>
+	}
+	b.WriteString(strings.Repeat(string(popDirectionalIsolate), len(isolated)))
+	return normalizeText(html.UnescapeString(b.String()))
+}
+
+// Bidi isolates wrap text from elements with a dir attribute; see stripHTML.
+const popDirectionalIsolate = '\u2069'
+
+var bidiIsolates = map[string]rune{"ltr": '\u2066', "rtl": '\u2067', "auto": '\u2068'}
+
+// tagDir returns the dir attribute of a raw tag when it is one of ltr, rtl or auto.
+func tagDir(raw string) string {
+	if !strings.Contains(strings.ToLower(raw), "dir") {
+		return ""
+	}
+	if dir := strings.ToLower(htmlAttr(raw, "dir")); bidiIsolates[dir] != 0 {
+		return dir
+	}
+	return ""
+}
+
+// normalizeText composes s to NFC, so text pasted from macOS or typed with combining marks
+// compares and hashes the same, and collapses whitespace runs to single spaces. Directional
+// marks (U+200E, U+200F) and isolates are formatting characters, not spaces, and survive.
+func normalizeText(s string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(s)), " ")
+}
+
+// textWidth counts the columns s occupies: combining marks and formatting characters
+// (directional marks, zero-width joiners) take none.
+func textWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+			n++
+		}
+	}
+	return n
+}
+
+// htmlToken is a run of text or a single tag, comment or doctype from tokenizeHTML.
+type htmlToken struct {
+	Raw         string
+	IsTag       bool
+	Name        string // lower-case element name; "" for text, comments and doctypes
+	Closing     bool
+	SelfClosing bool
+}
+
+// equationLaTeX recovers the LaTeX source of a Canvas equation image (<img class="equation_image">),
+// which the rich content editor keeps in data-equation-content and, prefixed, in alt.
+func equationLaTeX(tag string) string {
+	if !strings.Contains(htmlAttr(tag, "class"), "equation_image") && !strings.Contains(htmlAttr(tag, "src"), "/equation_images/") {
+		return ""
+	}
+	if tex := htmlAttr(tag, "data-equation-content"); tex != "" {
+		return tex
+	}
+	return strings.TrimSpace(strings.TrimPrefix(htmlAttr(tag, "alt"), "LaTeX:"))
+}
+
+// mathNode is an element or text of a MathML tree, for mathMLTeX.
+type mathNode struct {
+	name string // local element name; "" for text
+	tag  string // the raw start tag
+	text string
+	kids []*mathNode
+}
+
+// mathOperators are the MathML operator characters with a TeX command of their own.
+var mathOperators = map[string]string{
+	"−": "-", "×": `\times`, "÷": `\div`, "±": `\pm`, "∓": `\mp`, "·": `\cdot`, "⋅": `\cdot`,
+	"≤": `\le`, "≥": `\ge`, "≠": `\ne`, "≈": `\approx`, "∞": `\infty`, "→": `\to`,
+	"∑": `\sum`, "∏": `\prod`, "∫": `\int`, "∂": `\partial`, "∈": `\in`, "√": `\surd`,
+}
+
+// mathFunctions are the multi-letter identifiers TeX sets as named functions.
+var mathFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "log": true, "ln": true, "exp": true,
+	"lim": true, "max": true, "min": true, "det": true, "gcd": true,
+}
+
+// replaceMathML rewrites each MathML <math> element of s as TeX text, \(...\) or, for
+// display="block", \[...\], so it reads like an equation image does (see equationLaTeX).
+// A TeX annotation is used as it is; otherwise the markup is translated. When the markup
+// uses elements the translation does not know, the alttext is used instead, as plain text.
+func replaceMathML(s string) string {
+	if !strings.Contains(strings.ToLower(s), "math") {
+		return s
+	}
+	var b strings.Builder
+	var root *mathNode // the <math> element being read
+	var stack []*mathNode
+	for _, tok := range tokenizeHTML(s) {
+		name := tok.Name
+		if i := strings.LastIndexByte(name, ':'); i >= 0 {
+			name = name[i+1:] // m:math
+		}
+		if root == nil {
+			if name == "math" && !tok.Closing && !tok.SelfClosing {
+				root = &mathNode{name: name, tag: tok.Raw}
+				stack = []*mathNode{root}
+			} else {
+				b.WriteString(tok.Raw)
+			}
+			continue
+		}
+		top := stack[len(stack)-1]
+		switch {
+		case !tok.IsTag:
+			top.kids = append(top.kids, &mathNode{text: html.UnescapeString(tok.Raw)})
+		case name == "":
+			// a comment
+		case tok.Closing:
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			if len(stack) == 0 {
+				b.WriteString(html.EscapeString(mathMLTeX(root)))
+				root = nil
+			}
+		default:
+			n := &mathNode{name: name, tag: tok.Raw}
+			top.kids = append(top.kids, n)
+			if !tok.SelfClosing {
+				stack = append(stack, n)
+			}
+		}
+	}
+	if root != nil {
+		b.WriteString(html.EscapeString(mathMLTeX(root))) // unclosed <math>
+	}
+	return b.String()
+}
+
+// mathMLTeX returns the TeX text for a <math> element, with its delimiters.
+func mathMLTeX(m *mathNode) string {
+	tex := ""
+	if ann := m.find(func(n *mathNode) bool {
+		return n.name == "annotation" && strings.Contains(strings.ToLower(htmlAttr(n.tag, "encoding")), "tex")
+	}); ann != nil {
+		tex = strings.TrimSpace(ann.plain())
+	} else {
+		known := true
+		tex = strings.TrimSpace(m.tex(&known))
+		if alt := strings.TrimSpace(htmlAttr(m.tag, "alttext")); (!known || tex == "") && alt != "" {
+			return alt
+		}
+	}
+	if tex == "" {
+		return ""
+	}
+	if htmlAttr(m.tag, "display") == "block" {
+		return `\[` + tex + `\]`
+	}
+	return `\(` + tex + `\)`
+}
+
+// find returns the first node of n's tree, depth first, that match accepts.
+func (n *mathNode) find(match func(*mathNode) bool) *mathNode {
+	if match(n) {
+		return n
+	}
+	for _, k := range n.kids {
+		if f := k.find(match); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// plain returns the text of n's tree.
+func (n *mathNode) plain() string {
+	s := n.text
+	for _, k := range n.kids {
+		s += k.plain()
+	}
+	return s
+}
+
+// elements returns n's child elements, without the whitespace between them.
+func (n *mathNode) elements() []*mathNode {
+	var out []*mathNode
+	for _, k := range n.kids {
+		if k.name != "" || strings.TrimSpace(k.text) != "" {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// tex translates n's tree to TeX, clearing *known at elements it has no translation for.
+func (n *mathNode) tex(known *bool) string {
+	kids := n.elements()
+	arg := func(i int) string {
+		if i >= len(kids) {
+			*known = false
+			return "{}"
+		}
+		return texGroup(kids[i].tex(known))
+	}
+	switch n.name {
+	case "":
+		return strings.TrimSpace(n.text)
+	case "mi":
+		t := strings.TrimSpace(n.plain())
+		switch {
+		case mathFunctions[t]:
+			return `\` + t
+		case utf8.RuneCountInString(t) > 1:
+			return `\mathrm{` + t + `}`
+		}
+		return t
+	case "mn":
+		return strings.TrimSpace(n.plain())
+	case "mo":
+		t := strings.TrimSpace(n.plain())
+		if op, ok := mathOperators[t]; ok {
+			return op
+		}
+		return t
+	case "mtext", "ms":
+		return `\text{` + n.plain() + `}`
+	case "mspace", "annotation", "annotation-xml", "none", "mprescripts":
+		return ""
+	case "semantics":
+		if len(kids) == 0 {
+			return ""
+		}
+		return kids[0].tex(known)
+	case "msup":
+		return arg(0) + "^" + arg(1)
+	case "msub":
+		return arg(0) + "_" + arg(1)
+	case "msubsup", "munderover":
+		return arg(0) + "_" + arg(1) + "^" + arg(2)
+	case "mfrac":
+		return `\frac` + braced(arg(0)) + braced(arg(1))
+	case "msqrt":
+		return `\sqrt{` + joinTeX(kids, known) + `}`
+	case "mroot":
+		index := ""
+		if len(kids) > 1 {
+			index = kids[1].tex(known)
+		}
+		return `\sqrt[` + index + `]` + braced(arg(0))
+	case "mover", "munder":
+		accent := ""
+		if len(kids) > 1 {
+			accent = strings.TrimSpace(kids[1].plain())
+		}
+		switch {
+		case n.name == "munder":
+			return arg(0) + "_" + arg(1)
+		case accent == "¯" || accent == "‾" || accent == "―":
+			return `\overline` + braced(arg(0))
+		case accent == "^" || accent == "ˆ":
+			return `\hat` + braced(arg(0))
+		case accent == "→" || accent == "⃗":
+			return `\vec` + braced(arg(0))
+		case accent == "~" || accent == "˜":
+			return `\tilde` + braced(arg(0))
+		case accent == "." || accent == "˙":
+			return `\dot` + braced(arg(0))
+		}
+		return `\overset` + braced(arg(1)) + braced(arg(0))
+	case "mfenced":
+		open, close := htmlAttr(n.tag, "open"), htmlAttr(n.tag, "close")
+		if !strings.Contains(n.tag, "open=") {
+			open = "("
+		}
+		if !strings.Contains(n.tag, "close=") {
+			close = ")"
+		}
+		parts := make([]string, len(kids))
+		for i, k := range kids {
+			parts[i] = k.tex(known)
+		}
+		return `\left` + texDelimiter(open) + " " + strings.Join(parts, ", ") + ` \right` + texDelimiter(close)
+	case "mtable":
+		var rows []string
+		for _, tr := range kids {
+			var cells []string
+			for _, td := range tr.elements() {
+				cells = append(cells, joinTeX(td.elements(), known))
+			}
+			rows = append(rows, strings.Join(cells, " & "))
+		}
+		return `\begin{matrix} ` + strings.Join(rows, ` \\ `) + ` \end{matrix}`
+	case "math", "mrow", "mstyle", "mpadded", "mphantom", "merror", "menclose", "maction":
+		return joinTeX(kids, known)
+	}
+	*known = false
+	return joinTeX(kids, known)
+}
+
+// joinTeX concatenates the TeX of nodes, with a space where a command would otherwise run
+// into the letters after it (\sin x, not \sinx).
+func joinTeX(nodes []*mathNode, known *bool) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		t := n.tex(known)
+		if t == "" {
+			continue
+		}
+		if s := b.String(); reTeXCommandEnd.MatchString(s) && unicode.IsLetter([]rune(t)[0]) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+var reTeXCommandEnd = regexp.MustCompile(`\\[A-Za-z]+$`)
+
+// texGroup braces t unless it is a single character or command, or already a group.
+func texGroup(t string) string {
+	if utf8.RuneCountInString(t) == 1 || reTeXCommandEnd.FindString(t) == t {
+		return t
+	}
+	return braced(t)
+}
+
+// braced is texGroup that braces single characters too, for \frac and the like.
+func braced(t string) string {
+	if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") && strings.Count(t, "{") == 1 {
+		return t
+	}
+	return "{" + t + "}"
+}
+
+// texDelimiter returns a fence character as a \left or \right delimiter.
+func texDelimiter(d string) string {
+	switch d {
+	case "":
+		return "."
+	case "{", "}":
+		return `\` + d
+	}
+	return d
+}
+
+// reMath matches the TeX delimiters MathJax typesets: \(...\) inline, and $$...$$ or \[...\]
+// as Canvas authors type them.
+var reMath = regexp.MustCompile(`\\\((?s:.+?)\\\)|\$\$(?s:.+?)\$\$|\\\[(?s:.+?)\\\]`)
+
+// docHasMath reports whether any question carries TeX, recovered from an equation image or
+// typed inline.
+func docHasMath(doc QuizDoc) bool {
+	for _, q := range doc.Questions {
+		if reMath.MatchString(questionText(q) + "\n" + q.Explanation) {
+			return true
+		}
+	}
+	return false
+}
+
+// mathJaxCDN loads MathJax 3 from jsDelivr, configured for the delimiters reMath matches and
+// with the mhchem extension for \ce{...} chemical equations.
+const mathJaxCDN = `<script>
+MathJax = {loader: {load: ["[tex]/mhchem"]}, tex: {packages: {"[+]": ["mhchem"]}, inlineMath: [["\\(", "\\)"]], displayMath: [["$$", "$$"], ["\\[", "\\]"]]}};
+</script>
+<script defer src="https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-chtml.js"></script>
+`
+
+var (
+	subscripts   = map[rune]rune{'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆', '7': '₇', '8': '₈', '9': '₉', '+': '₊', '-': '₋', '=': '₌', '(': '₍', ')': '₎', 'a': 'ₐ', 'e': 'ₑ', 'h': 'ₕ', 'i': 'ᵢ', 'j': 'ⱼ', 'k': 'ₖ', 'l': 'ₗ', 'm': 'ₘ', 'n': 'ₙ', 'o': 'ₒ', 'p': 'ₚ', 'r': 'ᵣ', 's': 'ₛ', 't': 'ₜ', 'u': 'ᵤ', 'v': 'ᵥ', 'x': 'ₓ'}
+	superscripts = map[rune]rune{'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶', '7': '⁷', '8': '⁸', '9': '⁹', '+': '⁺', '-': '⁻', '=': '⁼', '(': '⁽', ')': '⁾', 'i': 'ⁱ', 'n': 'ⁿ'}
+)
+
+// toScript maps s through a subscripts or superscripts table; ok is false when some
+// character has no Unicode form.
+func toScript(s string, table map[rune]rune) (string, bool) {
+	var sb strings.Builder
+	for _, r := range s {
+		m, ok := table[r]
+		if !ok {
+			return s, false
+		}
+		sb.WriteRune(m)
+	}
+	return sb.String(), true
+}
+
+// chemArrows are the mhchem reaction arrows, longest first.
+var chemArrows = strings.NewReplacer("<=>", "⇌", "<->", "↔", "->", "→", "<-", "←")
+
+// chemUnicode writes the body of an mhchem \ce{...} with Unicode sub- and superscripts:
+// "SO4^2-" becomes "SO₄²⁻" and "2H2 + O2 -> 2H2O" becomes "2H₂ + O₂ → 2H₂O". Counts after an
+// element or closing bracket are subscripts; leading coefficients stay on the line.
+func chemUnicode(s string) string {
+	rs := []rune(chemArrows.Replace(s))
+	var sb strings.Builder
+	script := func(text string, table map[rune]rune, mark rune) {
+		if out, ok := toScript(text, table); ok {
+			sb.WriteString(out)
+		} else {
+			sb.WriteString(string(mark) + "(" + text + ")")
+		}
+	}
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case (r == '^' || r == '_') && i+1 < len(rs):
+			table := superscripts
+			if r == '_' {
+				table = subscripts
+			}
+			var text string
+			if rs[i+1] == '{' {
+				end := i + 2
+				for end < len(rs) && rs[end] != '}' {
+					end++
+				}
+				text, i = string(rs[i+2:min(end, len(rs))]), end
+			} else {
+				end := i + 1
+				for end < len(rs) && strings.ContainsRune("0123456789+-", rs[end]) {
+					end++
+				}
+				text, i = string(rs[i+1:end]), end-1
+			}
+			script(text, table, r)
+		case '0' <= r && r <= '9' && i > 0 && (unicode.IsLetter(rs[i-1]) || rs[i-1] == ')' || rs[i-1] == ']'):
+			sb.WriteRune(subscripts[r])
+		case (r == '+' || r == '-') && i > 0 && (unicode.IsLetter(rs[i-1]) || unicode.IsDigit(rs[i-1]) || rs[i-1] == ')') &&
+			(i+1 == len(rs) || rs[i+1] == ' ' || rs[i+1] == ')'):
+			sb.WriteRune(superscripts[r]) // ion charge shorthand, as in "OH-" or "e-"
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// ceEnd returns the index just past the brace closing the \ce{ at s[start:], or -1.
+func ceEnd(s string, start int) int {
+	depth := 0
+	for i := start + len(`\ce`); i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// ChemFallback replaces mhchem formulas with their chemUnicode form for outputs that
+// cannot typeset TeX: a math span holding a single \ce{...}, or a bare \ce{...} outside math.
+// Other TeX is left alone.
+func ChemFallback(s string) string {
+	if !strings.Contains(s, `\ce{`) {
+		return s
+	}
+	s = reMath.ReplaceAllStringFunc(s, func(m string) string {
+		inner := strings.TrimSpace(m[2 : len(m)-2])
+		if strings.HasPrefix(inner, `\ce{`) && ceEnd(inner, 0) == len(inner) {
+			return chemUnicode(inner[len(`\ce{`) : len(inner)-1])
+		}
+		return m
+	})
+	math := reMath.FindAllStringIndex(s, -1)
+	var sb strings.Builder
+	last := 0
+	for i := strings.Index(s, `\ce{`); i >= 0; {
+		end := ceEnd(s, i)
+		inMath := false
+		for _, m := range math {
+			inMath = inMath || m[0] <= i && i < m[1]
+		}
+		if end > 0 && !inMath {
+			sb.WriteString(s[last:i] + chemUnicode(s[i+len(`\ce{`):end-1]))
+			last = end
+		}
+		next := strings.Index(s[i+1:], `\ce{`)
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+	return sb.String() + s[last:]
+}
+
+// MapText applies f to every piece of quiz text the renderers print: instructions, comments,
+// links and the questions with their answers and feedback.
+func (doc *QuizDoc) MapText(f func(string) string) {
+	for i := range doc.Description {
+		doc.Description[i] = f(doc.Description[i])
+	}
+	for i := range doc.Details {
+		doc.Details[i].Value = f(doc.Details[i].Value)
+	}
+	mapComments := func(cs []Comment) {
+		for i := range cs {
+			cs[i].Author, cs[i].Text = f(cs[i].Author), f(cs[i].Text)
+		}
+	}
+	mapComments(doc.Comments)
+	for i := range doc.Questions {
+		q := &doc.Questions[i]
+		q.Text, q.GeneralFeedback, q.CorrectFeedback, q.Explanation = f(q.Text), f(q.GeneralFeedback), f(q.CorrectFeedback), f(q.Explanation)
+		q.IncorrectFeedback = f(q.IncorrectFeedback)
+		q.BodyHTML = f(q.BodyHTML)
+		if st := q.Stimulus; st != nil {
+			st.Title, st.HTML = f(st.Title), f(st.HTML)
+			for j := range st.Text {
+				st.Text[j] = f(st.Text[j])
+			}
+		}
+		mapComments(q.Comments)
+		for j := range q.Links {
+			q.Links[j].URL, q.Links[j].Text = f(q.Links[j].URL), f(q.Links[j].Text)
+		}
+		for j := range q.Media {
+			q.Media[j].URL, q.Media[j].Title = f(q.Media[j].URL), f(q.Media[j].Title)
+		}
+		for j := range q.Rubric {
+			q.Rubric[j].Description = f(q.Rubric[j].Description)
+		}
+		for j := range q.Options {
+			q.Options[j].Label, q.Options[j].Feedback = f(q.Options[j].Label), f(q.Options[j].Feedback)
+		}
+		for j := range q.Blanks {
+			q.Blanks[j].Answer = f(q.Blanks[j].Answer)
+			for k := range q.Blanks[j].Accepted {
+				q.Blanks[j].Accepted[k] = f(q.Blanks[j].Accepted[k])
+			}
+		}
+		for _, list := range [][]string{q.Answers, q.Responses, q.WordBank, q.Terms, q.Order, q.ResponseOrder} {
+			for j := range list {
+				list[j] = f(list[j])
+			}
+		}
+		for j := range q.Passage {
+			q.Passage[j].Text = f(q.Passage[j].Text)
+		}
+		for j := range q.Matches {
+			m := &q.Matches[j]
+			m.Prompt, m.Answer, m.Response = f(m.Prompt), f(m.Answer), f(m.Response)
+		}
+		for j := range q.MatchAnswers {
+			q.MatchAnswers[j] = f(q.MatchAnswers[j])
+		}
+		for j := range q.Categories {
+			c := &q.Categories[j]
+			c.Name = f(c.Name)
+			for _, list := range [][]string{c.Members, c.Responses} {
+				for k := range list {
+					list[k] = f(list[k])
+				}
+			}
+		}
+		for j := range q.CategoryItems {
+			q.CategoryItems[j] = f(q.CategoryItems[j])
+		}
+	}
+}
+
+// reKaTeXFallbackFont matches the woff and ttf alternatives in katex.min.css; only the woff2
+// fonts, which every current browser loads, are embedded.
+var reKaTeXFallbackFont = regexp.MustCompile(`,\s*url\(fonts/[^)]+\.(?:woff|ttf)\)\s*format\("[^"]*"\)`)
+
+var reKaTeXFont = regexp.MustCompile(`url\((fonts/[^)]+\.woff2)\)`)
+
+// katexOffline inlines a KaTeX distribution (the dist folder of the katex npm package or
+// release archive) into <style> and <script> elements, fonts as data: URLs, so the page
+// typesets equations without network access.
+func katexOffline(dir string) (string, error) {
+	read := func(name string) (string, error) {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return "", fmt.Errorf("KaTeX distribution: %w", err)
+		}
+		return string(b), nil
+	}
+	css, err := read("katex.min.css")
+	if err != nil {
+		return "", err
+	}
+	css = reKaTeXFallbackFont.ReplaceAllString(css, "")
+	var fontErr error
+	css = reKaTeXFont.ReplaceAllStringFunc(css, func(m string) string {
+		font, err := read(reKaTeXFont.FindStringSubmatch(m)[1])
+		if err != nil {
+			fontErr = err
+			return m
+		}
+		return "url(data:font/woff2;base64," + base64.StdEncoding.EncodeToString([]byte(font)) + ")"
+	})
+	if fontErr != nil {
+		return "", fontErr
+	}
+	var sb strings.Builder
+	sb.WriteString("<style>\n" + css + "\n</style>\n")
+	for _, name := range []string{"katex.min.js", "contrib/mhchem.min.js", "contrib/auto-render.min.js"} {
+		js, err := read(name)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString("<script>\n" + strings.ReplaceAll(js, "</script", `<\/script`) + "\n</script>\n")
+	}
+	sb.WriteString(`<script>
+document.addEventListener("DOMContentLoaded", function () {
+  renderMathInElement(document.body, {delimiters: [
+    {left: "$$", right: "$$", display: true},
+    {left: "\\[", right: "\\]", display: true},
+    {left: "\\(", right: "\\)", display: false}
+  ]});
+});
+</script>
+`)
+	return sb.String(), nil
+}
+
+// tokenizeHTML splits a fragment into text and tags with the HTML5 tokenizer, so a "<" only
+// opens a tag when a letter follows and a ">" inside a quoted attribute stays in it: "x <3" or
+// "if a < b" in code stay text instead of swallowing what follows. A tag that is cut off by the
+// end of the fragment, or runs into another "<" outside quotes ("a<b then</code>"), is text.
+func tokenizeHTML(s string) []htmlToken {
+	var out []htmlToken
+	text := func(t string) {
+		if t == "" {
+			return
+		}
+		if n := len(out); n > 0 && !out[n-1].IsTag {
+			out[n-1].Raw += t
+			return
+		}
+		out = append(out, htmlToken{Raw: t})
+	}
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+	for pos := 0; ; {
+		tt := z.Next()
+		raw := string(z.Raw())
+		switch tt {
+		case xhtml.ErrorToken:
+			text(raw)
+			return out
+		case xhtml.TextToken:
+			text(raw)
+		case xhtml.StartTagToken, xhtml.EndTagToken, xhtml.SelfClosingTagToken:
+			if i := strayLessThan(raw); i > 0 {
+				text(raw[:i])
+				pos += i
+				z = xhtml.NewTokenizer(strings.NewReader(s[pos:]))
+				continue
+			}
+			name, _ := z.TagName()
+			out = append(out, htmlToken{Raw: raw, IsTag: true, Name: string(name),
+				Closing: tt == xhtml.EndTagToken, SelfClosing: tt == xhtml.SelfClosingTagToken})
+		default:
+			out = append(out, htmlToken{Raw: raw, IsTag: true}) // comment or doctype
+		}
+		pos += len(raw)
+	}
+}
+
+// strayLessThan returns the index of the first "<" after the start of a raw tag that is not
+// inside a quoted attribute value, or -1.
+func strayLessThan(raw string) int {
+	quote := byte(0)
+	for i := 1; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && raw[i-1] == '=':
+			quote = c
+		case c == '<':
+			return i
+		}
+	}
+	return -1
+}
+
+// voidElements never have a closing tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// optionalEndElements may legally omit their closing tag, so closing them is not a repair.
+var optionalEndElements = map[string]bool{
+	"p": true, "li": true, "dt": true, "dd": true, "tr": true, "td": true, "th": true,
+	"thead": true, "tbody": true, "tfoot": true, "option": true,
+}
+
+// RepairHTML rewrites a fragment into well-formed HTML: stray "<" and ">" in text are
+// escaped, closing tags without a matching open tag are dropped and elements left open are
+// closed. It reports whether the fragment was malformed.
+func RepairHTML(s string) (string, bool) {
+	var sb strings.Builder
+	var open []string
+	repaired := false
+	closeTo := func(depth int) {
+		for len(open) > depth {
+			name := open[len(open)-1]
+			open = open[:len(open)-1]
+			if !optionalEndElements[name] {
+				repaired = true
+			}
+			sb.WriteString("</" + name + ">")
+		}
+	}
+	for _, tok := range tokenizeHTML(s) {
+		switch {
+		case !tok.IsTag:
+			if strings.Contains(tok.Raw, "<") {
+				repaired = true
+			}
+			sb.WriteString(strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(tok.Raw))
+			continue
+		case tok.Name == "" || voidElements[tok.Name] || tok.SelfClosing && !tok.Closing:
+		case !tok.Closing:
+			// A new <li>, <p>, <td>... implicitly ends an open sibling of the same kind.
+			if n := len(open); n > 0 && open[n-1] == tok.Name && optionalEndElements[tok.Name] {
+				closeTo(n - 1)
+			}
+			open = append(open, tok.Name)
+		default:
+			depth := -1
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == tok.Name {
+					depth = i
+					break
+				}
+			}
+			if depth < 0 {
+				repaired = true
+				continue
+			}
+			closeTo(depth + 1)
+			open = open[:depth]
+		}
+		sb.WriteString(tok.Raw)
+	}
+	closeTo(0)
+	return sb.String(), repaired
+}
+
+// sanitizedTags are the elements sanitizeHTML keeps, with the attributes each may carry.
+var sanitizedTags = map[string][]string{
+	"p": nil, "br": nil, "hr": nil, "div": nil, "span": nil, "blockquote": nil,
+	"h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"pre": {"class"}, "code": {"class"}, "kbd": nil, "samp": nil, "var": nil,
+	"strong": nil, "b": nil, "em": nil, "i": nil, "u": nil, "s": nil, "sub": nil, "sup": nil,
+	"ul": nil, "ol": {"start", "type"}, "li": nil, "dl": nil, "dt": nil, "dd": nil,
+	"table": nil, "caption": nil, "thead": nil, "tbody": nil, "tfoot": nil, "tr": nil,
+	"th": {"colspan", "rowspan", "scope"}, "td": {"colspan", "rowspan"},
+	"figure": nil, "figcaption": nil, "bdi": nil, "bdo": nil,
+	"a": {"href", "title"}, "img": {"src", "alt", "title", "width", "height"},
+}
+
+// droppedContent are the elements sanitizeHTML removes together with their content.
+var droppedContent = map[string]bool{
+	"script": true, "style": true, "template": true, "noscript": true, "iframe": true, "object": true,
+	"video": true, "audio": true, // rendered from Question.Media instead
+}
+
+// ImageSources returns the src of every image in the question bodies and stimulus
+// passages of doc, once each and as written (possibly relative), for downloading a local
+// copy to list in QuizDoc.Assets. Equation images are left out; they are TeX in the text.
+func ImageSources(doc QuizDoc) []string {
+	var srcs []string
+	seen := map[string]bool{}
+	for _, q := range doc.Questions {
+		bodies := []string{q.BodyHTML}
+		if q.Stimulus != nil {
+			bodies = append(bodies, q.Stimulus.HTML)
+		}
+		for _, body := range bodies {
+			for _, img := range bodyImageTags(body) {
+				if src := htmlAttr(img, "src"); src != "" && !seen[src] {
+					seen[src] = true
+					srcs = append(srcs, src)
+				}
+			}
+		}
+	}
+	return srcs
+}
+
+var reCanvasFile = regexp.MustCompile(`/files/(\d+)(?:/(?:download|preview))?/?$`)
+
+// CanvasFileID returns the id of the Canvas file a link points to, e.g. "12345" for
+// /courses/1/files/12345/download?verifier=..., or "" if it is not a file link.
+func CanvasFileID(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if m := reCanvasFile.FindStringSubmatch(u.Path); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// FileLinks returns the links to Canvas files (see CanvasFileID) in the question bodies,
+// stimulus passages and feedback of doc, once each and as written, for downloading a local
+// copy to list in QuizDoc.Assets. The download links Canvas puts in bodies expire.
+func FileLinks(doc QuizDoc) []string {
+	var hrefs []string
+	seen := map[string]bool{}
+	add := func(href string) {
+		if href != "" && !seen[href] && CanvasFileID(href) != "" {
+			seen[href] = true
+			hrefs = append(hrefs, href)
+		}
+	}
+	for _, q := range doc.Questions {
+		bodies := []string{q.BodyHTML}
+		if q.Stimulus != nil {
+			bodies = append(bodies, q.Stimulus.HTML)
+		}
+		for _, body := range bodies {
+			for _, tok := range tokenizeHTML(body) {
+				if tok.Name == "a" && !tok.Closing {
+					add(htmlAttr(tok.Raw, "href"))
+				}
+			}
+		}
+		for _, l := range q.Links {
+			add(l.URL)
+		}
+	}
+	return hrefs
+}
+
+// bodyImageTags returns the raw <img> tags of body other than equation images.
+func bodyImageTags(body string) []string {
+	var tags []string
+	for _, tok := range tokenizeHTML(body) {
+		if tok.Name == "img" && !tok.Closing && equationLaTeX(tok.Raw) == "" {
+			tags = append(tags, tok.Raw)
+		}
+	}
+	return tags
+}
+
+// writeMarkdownImages writes an image line for each image of body that has a local copy in
+// assets. Images without one are left out, as the Markdown leaves out all body images.
+func writeMarkdownImages(sb *strings.Builder, body string, assets map[string]string, prefix string) {
+	for _, img := range bodyImageTags(body) {
+		local := assets[htmlAttr(img, "src")]
+		if local == "" {
+			continue
+		}
+		alt := htmlAttr(img, "alt")
+		if alt == "" {
+			alt = pathpkg.Base(local)
+		}
+		sb.WriteString(fmt.Sprintf("%s![%s](%s)\n", prefix, strings.NewReplacer("[", "\\[", "]", "\\]").Replace(alt), local))
+	}
+}
+
+// richBody reports whether a question body has structure that plain text loses: code,
+// lists, tables or images other than equations. Only these bodies are kept as HTML.
+func richBody(s string) bool {
+	for _, tok := range tokenizeHTML(s) {
+		switch tok.Name {
+		case "pre", "code", "ul", "ol", "table":
+			return true
+		case "img":
+			if equationLaTeX(tok.Raw) == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// markdownStem returns the heading text of q and the Markdown blocks that follow it. A body
+// kept as HTML (see richBody) leads with its first paragraph, when it starts with one, and
+// keeps its lists, tables and code below the heading.
+func markdownStem(q Question) (string, []string) {
+	if q.BodyHTML == "" {
+		return q.Text, nil
+	}
+	var heading string
+	var body []string
+	for i, b := range markdownBlocks(q.BodyHTML) {
+		if i == 0 && b.Para {
+			heading = b.Text
+		} else {
+			body = append(body, b.Text)
+		}
+	}
+	return heading, body
+}
+
+// mdBlock is a paragraph, list, table or fenced code block of a Markdown question body.
+type mdBlock struct {
+	Text string
+	Para bool // plain paragraph text
+}
+
+// mdList is an open <ul> or <ol> while markdownBlocks reads a body.
+type mdList struct {
+	ordered bool
+	next    int    // number of the next ordered item
+	indent  string // of this list's markers
+	marker  string // of the open item, until its first line is written
+	width   int    // of the open item's marker; its continuation lines are indented by it
+}
+
+// markdownBlocks converts a question body to Markdown blocks in order: <table> becomes a pipe
+// table, <ul> and <ol> become (nested) list items, <pre> and multi-line <code> become fenced
+// code and inline <code> is set in backticks. Other inline markup is flattened as in stripHTML;
+// images other than equations are left out, since the renderers list them on their own.
+func markdownBlocks(s string) []mdBlock {
+	var blocks []mdBlock
+	var inline strings.Builder
+	var lists []*mdList
+	var listLines []string
+	var table [][]string // rows of the open <table>; nil outside one
+	var caption string
+	var code *strings.Builder // text of the open <pre> or <code>
+	codeTag, lang := "", ""
+	// nested counts the tables open inside the table.
+	inCell, skip, nested := false, "", 0
+
+	addLine := func(line string) {
+		l := lists[len(lists)-1]
+		prefix := l.indent + strings.Repeat(" ", l.width)
+		if l.marker != "" {
+			prefix, l.marker = l.indent+l.marker, ""
+		}
+		listLines = append(listLines, prefix+line)
+	}
+	flush := func() {
+		text := stripHTML(inline.String())
+		inline.Reset()
+		switch {
+		case table != nil && inCell:
+			row := table[len(table)-1]
+			table[len(table)-1] = append(row, strings.ReplaceAll(text, "|", `\|`))
+			inCell = false
+		case table != nil:
+		case text == "":
+		case len(lists) > 0:
+			addLine(text)
+		default:
+			blocks = append(blocks, mdBlock{Text: text, Para: true})
+		}
+	}
+	fenced := func(body string) {
+		flush()
+		fence := codeFence(body)
+		lines := append([]string{fence + lang}, strings.Split(body, "\n")...)
+		lines = append(lines, fence)
+		if len(lists) == 0 {
+			blocks = append(blocks, mdBlock{Text: strings.Join(lines, "\n")})
+			return
+		}
+		for _, line := range lines {
+			addLine(line)
+		}
+	}
+	for _, tok := range tokenizeHTML(replaceMathML(s)) {
+		switch {
+		case skip != "":
+			if tok.IsTag && tok.Closing && tok.Name == skip {
+				skip = ""
+			}
+		case code != nil && tok.IsTag && tok.Closing && tok.Name == codeTag:
+			body := strings.Trim(html.UnescapeString(code.String()), "\n")
+			code = nil
+			if table == nil && (codeTag == "pre" || strings.Contains(body, "\n")) {
+				fenced(body)
+			} else if body != "" {
+				inline.WriteString(html.EscapeString(inlineCode(body)))
+			}
+		case code != nil && !tok.IsTag:
+			code.WriteString(tok.Raw)
+		case code != nil:
+			if tok.Name == "br" {
+				code.WriteString("\n")
+			} else if l := codeLanguage(tok.Raw); l != "" && lang == "" {
+				lang = l
+			}
+		case !tok.IsTag:
+			inline.WriteString(tok.Raw)
+		case droppedContent[tok.Name]:
+			if !tok.Closing && !tok.SelfClosing {
+				skip = tok.Name
+			}
+		case (tok.Name == "pre" || tok.Name == "code") && !tok.Closing:
+			code, codeTag, lang = &strings.Builder{}, tok.Name, codeLanguage(tok.Raw)
+		case tok.Name == "img" && equationLaTeX(tok.Raw) == "":
+		case tok.Name == "table" && table == nil:
+			if !tok.Closing {
+				flush()
+				table, caption = [][]string{}, ""
+			}
+		case table != nil && (nested > 0 || tok.Name == "table" && !tok.Closing):
+			// A table inside a cell is flattened into the cell's text.
+			switch {
+			case tok.Name == "table" && tok.Closing:
+				nested--
+			case tok.Name == "table":
+				nested++
+			}
+			if markdownBreaks[tok.Name] || tableParts[tok.Name] {
+				inline.WriteString(" ")
+			} else {
+				inline.WriteString(tok.Raw)
+			}
+		case table != nil:
+			switch tok.Name {
+			case "table":
+				flush()
+				blocks = append(blocks, markdownTable(table, caption))
+				table = nil
+			case "tr":
+				flush()
+				if !tok.Closing {
+					table = append(table, nil)
+				}
+			case "td", "th":
+				flush()
+				if !tok.Closing {
+					if len(table) == 0 {
+						table = append(table, nil)
+					}
+					inCell = true
+				}
+			case "caption":
+				if tok.Closing {
+					caption = stripHTML(inline.String())
+				}
+				inline.Reset()
+			default:
+				if markdownBreaks[tok.Name] {
+					inline.WriteString(" ")
+				} else {
+					inline.WriteString(tok.Raw)
+				}
+			}
+		case tok.Name == "ul" || tok.Name == "ol":
+			flush()
+			if tok.Closing {
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				if len(lists) == 0 && len(listLines) > 0 {
+					blocks = append(blocks, mdBlock{Text: strings.Join(listLines, "\n")})
+					listLines = nil
+				}
+				break
+			}
+			l := &mdList{ordered: tok.Name == "ol", next: 1}
+			if n, err := strconv.Atoi(htmlAttr(tok.Raw, "start")); err == nil {
+				l.next = n
+			}
+			if len(lists) > 0 {
+				parent := lists[len(lists)-1]
+				if parent.marker != "" {
+					addLine("") // a list opening its item: the marker gets a line of its own
+					listLines[len(listLines)-1] = strings.TrimRight(listLines[len(listLines)-1], " ")
+				}
+				l.indent = parent.indent + strings.Repeat(" ", parent.width)
+			}
+			lists = append(lists, l)
+		case tok.Name == "li":
+			flush()
+			if tok.Closing || len(lists) == 0 {
+				break
+			}
+			l := lists[len(lists)-1]
+			l.marker = "- "
+			if l.ordered {
+				l.marker = fmt.Sprintf("%d. ", l.next)
+				l.next++
+			}
+			l.width = len(l.marker)
+		case markdownBreaks[tok.Name]:
+			flush()
+		default:
+			inline.WriteString(tok.Raw)
+		}
+	}
+	if code != nil {
+		inline.WriteString(html.EscapeString(inlineCode(html.UnescapeString(code.String())))) // unclosed
+	}
+	flush()
+	if table != nil {
+		blocks = append(blocks, markdownTable(table, caption))
+	}
+	if len(listLines) > 0 {
+		blocks = append(blocks, mdBlock{Text: strings.Join(listLines, "\n")})
+	}
+	return blocks
+}
+
+// markdownBreaks are the elements that end a paragraph (or, in a table cell, a line).
+var markdownBreaks = map[string]bool{
+	"p": true, "br": true, "hr": true, "div": true, "blockquote": true, "figure": true, "figcaption": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "dl": true, "dt": true, "dd": true,
+}
+
+// tableParts are the elements that lay out a table's cells.
+var tableParts = map[string]bool{"table": true, "tr": true, "td": true, "th": true}
+
+// markdownTable renders table rows as a pipe table whose first row is the header; short rows
+// are padded. A caption goes in italics above it.
+func markdownTable(rows [][]string, caption string) mdBlock {
+	cols := 0
+	for _, r := range rows {
+		cols = max(cols, len(r))
+	}
+	if cols == 0 {
+		return mdBlock{Text: caption, Para: true}
+	}
+	var sb strings.Builder
+	if caption != "" {
+		sb.WriteString("_" + caption + "_\n\n")
+	}
+	row := func(cells []string) {
+		cells = append(cells, make([]string, cols-len(cells))...)
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	for i, r := range rows {
+		row(r)
+		if i == 0 {
+			rule := make([]string, cols)
+			for j := range rule {
+				rule[j] = "---"
+			}
+			row(rule)
+		}
+	}
+	return mdBlock{Text: strings.TrimSuffix(sb.String(), "\n")}
+}
+
+// codeLanguage returns the highlighting hint of a <pre> or <code> tag (class="language-go" or
+// "lang-go"), for the info string of a fenced block.
+func codeLanguage(tag string) string {
+	for _, c := range strings.Fields(htmlAttr(tag, "class")) {
+		for _, p := range []string{"language-", "lang-"} {
+			if strings.HasPrefix(c, p) {
+				return strings.TrimPrefix(c, p)
+			}
+		}
+	}
+	return ""
+}
+
+// codeFence returns a backtick fence longer than any backtick run in code.
+func codeFence(code string) string {
+	longest, run := 0, 0
+	for _, r := range code {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// inlineCode sets code in backticks, doubling them when the code holds one.
+func inlineCode(code string) string {
+	code = strings.Join(strings.Fields(code), " ")
+	if strings.Contains(code, "`") {
+		return "`` " + code + " ``"
+	}
+	return "`" + code + "`"
+}
+
+// sanitizeHTML keeps the structure of a question body for HTML output: the elements and
+// attributes of sanitizedTags survive, other tags are dropped with their text kept, and
+// droppedContent goes entirely. Relative links and image sources are resolved against base
+// when it is set; only http(s), mailto and (for images) data: URLs are kept. Images with a
+// local copy in assets, and links to files with one, point to it instead. Equation images become \(...\) for MathJax, as
+// in stripHTML. The result is well-formed (see RepairHTML).
+func sanitizeHTML(s, base string, assets map[string]string) string {
+	s = replaceMathML(s)
+	baseURL, _ := url.Parse(base)
+	var sb strings.Builder
+	skip := ""
+	for _, tok := range tokenizeHTML(s) {
+		switch {
+		case skip != "":
+			if tok.IsTag && tok.Closing && tok.Name == skip {
+				skip = ""
+			}
+			continue
+		case !tok.IsTag:
+			sb.WriteString(tok.Raw)
+			continue
+		case droppedContent[tok.Name]:
+			if !tok.Closing && !tok.SelfClosing {
+				skip = tok.Name
+			}
+			continue
+		case tok.Name == "img" && equationLaTeX(tok.Raw) != "":
+			sb.WriteString(html.EscapeString(`\(` + equationLaTeX(tok.Raw) + `\)`))
+			continue
+		}
+		attrs, ok := sanitizedTags[tok.Name]
+		if !ok {
+			continue
+		}
+		if tok.Closing {
+			sb.WriteString("</" + tok.Name + ">")
+			continue
+		}
+		sb.WriteString("<" + tok.Name)
+		for _, a := range attrs {
+			v := htmlAttr(tok.Raw, a)
+			switch {
+			case v == "":
+				continue
+			case (a == "src" || a == "href") && assets[v] != "":
+				v = assets[v]
+			case a == "href" || a == "src":
+				if v = safeURL(v, baseURL, a == "src"); v == "" {
+					continue
+				}
+			case a == "class":
+				// Only highlighting hints (language-go, lang-py) survive.
+				var keep []string
+				for _, c := range strings.Fields(v) {
+					if strings.HasPrefix(c, "language-") || strings.HasPrefix(c, "lang-") {
+						keep = append(keep, c)
+					}
+				}
+				if v = strings.Join(keep, " "); v == "" {
+					continue
+				}
+			}
+			sb.WriteString(fmt.Sprintf(" %s=\"%s\"", a, html.EscapeString(v)))
+		}
+		// Any kept element may set its direction; right-to-left runs read wrongly without it.
+		if dir := tagDir(tok.Raw); dir != "" {
+			sb.WriteString(fmt.Sprintf(" dir=\"%s\"", dir))
+		}
+		sb.WriteString(">")
+	}
+	out, _ := RepairHTML(sb.String())
+	return strings.TrimSpace(out)
+}
+
+// safeURL resolves a link or image URL against base (when not nil) and returns it if its
+// scheme is safe to render, or "" otherwise.
+func safeURL(raw string, base *url.URL, image bool) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if base != nil && base.Scheme != "" && !u.IsAbs() {
+		u = base.ResolveReference(u)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https":
+		return u.String()
+	case "mailto":
+		if !image {
+			return u.String()
+		}
+	case "data":
+		if image && strings.HasPrefix(strings.ToLower(u.Opaque), "image/") {
+			return raw
+		}
+	}
+	return ""
+}
+
+// leftBlank reports whether a result shows the student submitted nothing for the item. Only
+// values that say so count: user_responded flags all false, every user_response empty, or an
+// empty string or list. A missing or null value is not enough.
+func leftBlank(res ResultItem) bool {
+	var mapForm map[string]ResultValueEntry
+	if err := json.Unmarshal(res.Scored.ValueRaw, &mapForm); err == nil && len(mapForm) > 0 {
+		known := false
+		for _, e := range mapForm {
+			if (e.UserResponded != nil && *e.UserResponded) || e.UserResponse != "" {
+				return false
+			}
+			known = known || e.UserResponded != nil || e.CorrectAnswer != ""
+		}
+		return known
+	}
+	switch strings.TrimSpace(string(res.Scored.ValueRaw)) {
+	case `""`, `[]`, `{}`:
+		return true
+	}
+	return false
+}
+
+// deriveSelectedChoiceIDs returns the ids the student picked. Map-form values flag them with
+// user_responded; a bare string or string array value is the selection itself. Any other
+// value is an error, with the ids read so far (none).
+func deriveSelectedChoiceIDs(res ResultItem) (map[string]bool, error) {
+	ids := map[string]bool{}
+	var mapForm map[string]ResultValueEntry
+	if err := json.Unmarshal(res.Scored.ValueRaw, &mapForm); err == nil {
+		for id, entry := range mapForm {
+			if id != "" && entry.UserResponded != nil && *entry.UserResponded {
+				ids[id] = true
+			}
+		}
+		return ids, nil
+	}
+	list, err := decodeStringList(res.Scored.ValueRaw)
+	if err != nil {
+		return ids, fmt.Errorf("selection %w", err)
+	}
+	for _, id := range list {
+		if id != "" {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// deriveCorrectChoiceIDs returns ids deemed correct from heterogeneous scored value structures.
+func deriveCorrectChoiceIDs(res ResultItem) map[string]bool {
+	ids, _ := correctChoiceIDs(res)
+	return ids
+}
+
+// correctChoiceIDs is deriveCorrectChoiceIDs, also naming the branch of scored_data.value
+// the ids were read from ("" when none applied), for -debug.
+func correctChoiceIDs(res ResultItem) (ids map[string]bool, branch string) {
+	ids = map[string]bool{}
+	if len(res.Scored.ValueRaw) == 0 || string(res.Scored.ValueRaw) == "null" {
+		return ids, ""
+	}
+	// Try map form first
+	var mapForm map[string]ResultValueEntry
+	if err := json.Unmarshal(res.Scored.ValueRaw, &mapForm); err == nil && len(mapForm) > 0 {
+		for id, entry := range mapForm {
+			if id == "" {
+				continue
+			}
+			if entry.ResultScore != nil && *entry.ResultScore == 1 {
+				ids[id] = true
+			}
+			if entry.Correct != nil && *entry.Correct {
+				ids[id] = true
+			}
+		}
+		return ids, "map of result_score/correct"
+	}
+	// Try ordering / array form. Ids and values are strings or numbers, depending on the export.
+	var arrayForm []struct {
+		ID          json.RawMessage `json:"id"`
+		ResultScore float64         `json:"result_score"`
+		Value       json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(res.Scored.ValueRaw, &arrayForm); err == nil {
+		for _, row := range arrayForm {
+			if row.ResultScore != 1 {
+				continue
+			}
+			// For ordering questions, value is the correct choice id; a row without one is
+			// the choice itself.
+			id, err := decodeID(row.Value)
+			if err == nil && id == "" {
+				id, err = decodeID(row.ID)
+			}
+			if err == nil && id != "" {
+				ids[id] = true
+			}
+		}
+		return ids, "array of result_score/value"
+	}
+	return ids, ""
+}
+
+// applyShuffledOrder renumbers Choices in ShuffledOrder; choices it does not list keep their
+// relative order after the listed ones.
+func (idat *InteractionData) applyShuffledOrder() {
+	if len(idat.ShuffledOrder) == 0 || len(idat.Choices) == 0 {
+		return
+	}
+	rank := map[string]int{}
+	for i, id := range idat.ShuffledOrder {
+		rank[id] = i + 1
+	}
+	sort.SliceStable(idat.Choices, func(i, j int) bool {
+		ri, rj := rank[idat.Choices[i].ID], rank[idat.Choices[j].ID]
+		switch {
+		case ri == 0 && rj == 0:
+			return idat.Choices[i].Position < idat.Choices[j].Position
+		case ri == 0 || rj == 0:
+			return rj == 0
+		}
+		return ri < rj
+	})
+	for i := range idat.Choices {
+		idat.Choices[i].Position = i + 1
+	}
+}
+
+// NormalizeChoices ensures InteractionData.Choices is populated from various Canvas encodings.
+// Choices end up in shuffled_order when the payload has one (the order the student saw), and
+// in authored position order otherwise.
+//
+// The error reports choices in a shape none of those encodings reads; Choices is then empty.
+func (idat *InteractionData) NormalizeChoices(userRespType, interactionSlug string) error {
+	defer idat.applyShuffledOrder()
+	if len(idat.Choices) > 0 { // already standard array
+		return nil
+	}
+	// Boolean true/false
+	if strings.EqualFold(userRespType, "Boolean") || interactionSlug == "true-false" {
+		trueLabel := idat.TrueChoice
+		falseLabel := idat.FalseChoice
+		if trueLabel == "" {
+			trueLabel = "True"
+		}
+		if falseLabel == "" {
+			falseLabel = "False"
+		}
+		idat.Choices = []QuizChoice{{ItemBody: trueLabel, ID: "true", Position: 1}, {ItemBody: falseLabel, ID: "false", Position: 2}}
+		return nil
+	}
+	var err error
+	idat.Choices, err = decodeChoiceSet(idat.RawChoices)
+	return err
+}
+
+// choiceForm names the encoding NormalizeChoices reads the choices from, for -debug: "set"
+// when Choices is already filled in, "true/false", "map" (keyed by id), "array" or "none".
+func (idat InteractionData) choiceForm(userRespType, interactionSlug string) string {
+	switch {
+	case len(idat.Choices) > 0:
+		return "set"
+	case strings.EqualFold(userRespType, "Boolean") || interactionSlug == "true-false":
+		return "true/false"
+	}
+	raw := bytes.TrimSpace(idat.RawChoices)
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return "none"
+	case raw[0] == '{':
+		return "map"
+	case raw[0] == '[':
+		return "array"
+	}
+	return "unreadable"
+}
+
+// decodeChoiceSet decodes choices given either as a map keyed by id or as an array. Map
+// entries are put in authored position order and renumbered from 1. Anything else, or a map
+// or array whose entries aren't choices, is an error.
+func decodeChoiceSet(raw json.RawMessage) ([]QuizChoice, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '[' {
+		var arr []QuizChoice
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			return nil, fmt.Errorf("unreadable list of choices: %w", err)
+		}
+		return arr, nil
+	}
+	if raw[0] != '{' {
+		return nil, fmt.Errorf("choices are %s, not an object keyed by id or a list", valueShape(raw))
+	}
+	var mapChoices map[string]QuizChoice
+	if err := json.Unmarshal(raw, &mapChoices); err != nil {
+		return nil, fmt.Errorf("unreadable object of choices: %w", err)
+	}
+	var choices []QuizChoice
+	for key, mc := range mapChoices {
+		id := mc.ID
+		if id == "" {
+			id = key
+		}
+		choices = append(choices, QuizChoice{ItemBody: mc.ItemBody, ID: id, Position: mc.Position})
+	}
+	// Authored position first, then id, so the order is the same on every run.
+	sort.Slice(choices, func(i, j int) bool {
+		a, b := choices[i], choices[j]
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.ID < b.ID
+	})
+	for i := range choices {
+		choices[i].Position = i + 1
+	}
+	return choices, nil
+}
+
+// decodeStringList accepts either a single JSON string or an array of strings. Numbers in
+// the array are read as their text, since some exports send ids and accepted answers as numbers.
+// Anything else is an error saying what the value was.
+func decodeStringList(raw json.RawMessage) ([]string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		if one == "" {
+			return nil, nil
+		}
+		return []string{one}, nil
+	}
+	var many []json.RawMessage
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, fmt.Errorf("is %s, not a string or a list of strings", valueShape(raw))
+	}
+	out := make([]string, 0, len(many))
+	for _, m := range many {
+		s, err := decodeID(m)
+		if err != nil {
+			return nil, fmt.Errorf("list entry %w", err)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// blankScoring is the authored grading rule for one blank, taken from the item's scoring_data.
+type blankScoring struct {
+	Algorithm        string
+	Accepted         []string
+	CaseSensitive    *bool
+	IgnoreWhitespace *bool
+	MaxDistance      *int   // TextCloseEnough: allowed Levenshtein distance
+	Pattern          string // TextRegex: the grading regular expression
+}
+
+// parseBlankScoring maps blank ids to their grading rule. scoring_data is either
+// {"value": [...]} or the bare array; each entry looks like
+// {"id", "scoring_algorithm", "scoring_data": {"value": "x" | ["x", "y"], "blank_text": "x"}}.
+// A blank whose value can't be read keeps no accepted answers, and the first such error is
+// returned with the rest of the rules.
+func parseBlankScoring(raw json.RawMessage) (map[string]blankScoring, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	type entry struct {
+		ID               string `json:"id"`
+		ScoringAlgorithm string `json:"scoring_algorithm"`
+		ScoringData      struct {
+			Value               json.RawMessage `json:"value"`
+			BlankText           string          `json:"blank_text"`
+			CaseSensitive       *bool           `json:"case_sensitive"`
+			IgnoreWhitespace    *bool           `json:"ignore_whitespace"`
+			LevenshteinDistance *int            `json:"levenshtein_distance"`
+		} `json:"scoring_data"`
+	}
+	var entries []entry
+	var wrapped struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err == nil && len(wrapped.Value) > 0 {
+		raw = wrapped.Value
+	}
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("is %s, not a list of blank rules", valueShape(raw))
+	}
+	out := map[string]blankScoring{}
+	var firstErr error
+	for _, e := range entries {
+		if e.ID == "" {
+			continue
+		}
+		accepted, err := decodeStringList(e.ScoringData.Value)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("blank %s value %w", e.ID, err)
+		}
+		if len(accepted) == 0 && e.ScoringData.BlankText != "" {
+			accepted = []string{e.ScoringData.BlankText}
+		}
+		sc := blankScoring{
+			Algorithm:        e.ScoringAlgorithm,
+			Accepted:         accepted,
+			CaseSensitive:    e.ScoringData.CaseSensitive,
+			IgnoreWhitespace: e.ScoringData.IgnoreWhitespace,
+			MaxDistance:      e.ScoringData.LevenshteinDistance,
+		}
+		// For regex blanks the "value" is the pattern, not an answer a student would type.
+		if e.ScoringAlgorithm == "TextRegex" && len(accepted) > 0 {
+			sc.Pattern = accepted[0]
+			sc.Accepted = nil
+		}
+		out[e.ID] = sc
+	}
+	return out, firstErr
+}
+
+// describeBlankRule explains how Canvas judged a blank, e.g. "exact match, case-insensitive".
+// It returns "" when the item has no authored scoring for the blank.
+func describeBlankRule(answerType string, sc blankScoring) string {
+	if sc.Algorithm == "" {
+		return ""
+	}
+	var parts []string
+	switch sc.Algorithm {
+	case "TextEquivalence", "Equivalence":
+		parts = append(parts, "exact match")
+	case "TextContainsAnswer":
+		parts = append(parts, "response must contain the answer")
+	case "TextCloseEnough":
+		if sc.MaxDistance != nil {
+			parts = append(parts, fmt.Sprintf("close match (up to %d character edits)", *sc.MaxDistance))
+		} else {
+			parts = append(parts, "close match")
+		}
+	case "TextInChoices":
+		parts = append(parts, "must match one of the accepted answers")
+	case "TextRegex":
+		parts = append(parts, "matched by regular expression")
+	default:
+		parts = append(parts, sc.Algorithm)
+	}
+	if sc.CaseSensitive != nil {
+		if *sc.CaseSensitive {
+			parts = append(parts, "case-sensitive")
+		} else {
+			parts = append(parts, "case-insensitive")
+		}
+	}
+	if sc.IgnoreWhitespace != nil && *sc.IgnoreWhitespace {
+		parts = append(parts, "extra whitespace ignored")
+	}
+	switch strings.ToLower(answerType) {
+	case "dropdown":
+		parts = append(parts, "chosen from a dropdown")
+	case "wordbank":
+		parts = append(parts, "chosen from the word bank")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// regexExample builds a short string matched by pattern by taking the cheapest path through
+// its syntax tree (first alternative, minimum repetitions, a readable member of each class).
+// It returns "" when the pattern doesn't parse or the guess fails to match.
+func regexExample(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	var walk func(r *syntax.Regexp)
+	walk = func(r *syntax.Regexp) {
+		switch r.Op {
+		case syntax.OpLiteral:
+			lit := string(r.Rune)
+			if r.Flags&syntax.FoldCase != 0 {
+				lit = strings.ToLower(lit)
+			}
+			sb.WriteString(lit)
+		case syntax.OpCharClass:
+			sb.WriteRune(classExample(r.Rune))
+		case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+			sb.WriteByte('a')
+		case syntax.OpCapture:
+			walk(r.Sub[0])
+		case syntax.OpPlus, syntax.OpQuest:
+			// Optional parts are kept: "colou?r" reads better as "colour" than "color".
+			walk(r.Sub[0])
+		case syntax.OpRepeat:
+			for i := 0; i < r.Min; i++ {
+				walk(r.Sub[0])
+			}
+		case syntax.OpConcat:
+			for _, sub := range r.Sub {
+				walk(sub)
+			}
+		case syntax.OpAlternate:
+			walk(r.Sub[0])
+		}
+		// Star, anchors and boundaries contribute nothing.
+	}
+	walk(re.Simplify())
+	example := sb.String()
+	full, err := regexp.Compile(pattern)
+	if err != nil || !full.MatchString(example) {
+		return ""
+	}
+	return example
+}
+
+// classExample picks a readable member of a character class given as [lo, hi] rune pairs,
+// preferring lowercase letters, then digits, then anything printable.
+func classExample(ranges []rune) rune {
+	for _, preferred := range []string{"abcdefghijklmnopqrstuvwxyz", "0123456789", "ABCDEFGHIJKLMNOPQRSTUVWXYZ"} {
+		for _, c := range preferred {
+			for i := 0; i+1 < len(ranges); i += 2 {
+				if c >= ranges[i] && c <= ranges[i+1] {
+					return c
+				}
+			}
+		}
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for c := ranges[i]; c <= ranges[i+1] && c-ranges[i] < 128; c++ {
+			if c > ' ' && c != 0x7f {
+				return c
+			}
+		}
+	}
+	// Whitespace-only classes such as \s: a plain space reads better than a tab.
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ' ' >= ranges[i] && ' ' <= ranges[i+1] {
+			return ' '
+		}
+	}
+	if len(ranges) > 0 {
+		return ranges[0]
+	}
+	return 'a'
+}
+
+// describeRegex turns a pattern into a plain-English reading such as
+// `"colo", then optionally "u", then "r"`. It returns "" for patterns that don't parse.
+func describeRegex(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	// Describe the parsed tree as written: Simplify would expand counted repeats like \d{3}.
+	desc := describeRegexNode(re)
+	if regexFoldsCase(re) {
+		desc += " (ignoring case)"
+	}
+	return desc
+}
+
+func regexFoldsCase(r *syntax.Regexp) bool {
+	if r.Op == syntax.OpLiteral && r.Flags&syntax.FoldCase != 0 {
+		return true
+	}
+	for _, sub := range r.Sub {
+		if regexFoldsCase(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRanges reports whether a character class has exactly the given [lo, hi] pairs.
+func sameRanges(got []rune, want ...rune) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func describeRegexNode(r *syntax.Regexp) string {
+	switch r.Op {
+	case syntax.OpLiteral:
+		lit := string(r.Rune)
+		if r.Flags&syntax.FoldCase != 0 {
+			lit = strings.ToLower(lit)
+		}
+		return strconv.Quote(lit)
+	case syntax.OpCharClass:
+		switch {
+		case sameRanges(r.Rune, '0', '9'):
+			return "a digit"
+		case sameRanges(r.Rune, '\t', '\n', '\f', '\r', ' ', ' '):
+			return "a whitespace character"
+		case sameRanges(r.Rune, '0', '9', 'A', 'Z', '_', '_', 'a', 'z'):
+			return "a letter, digit or underscore"
+		}
+		return "one of " + r.String()
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return "any character"
+	case syntax.OpBeginLine, syntax.OpBeginText:
+		return "the start"
+	case syntax.OpEndLine, syntax.OpEndText:
+		return "the end"
+	case syntax.OpWordBoundary:
+		return "a word boundary"
+	case syntax.OpCapture:
+		return describeRegexNode(r.Sub[0])
+	case syntax.OpStar:
+		return "zero or more of " + describeRegexNode(r.Sub[0])
+	case syntax.OpPlus:
+		return "one or more of " + describeRegexNode(r.Sub[0])
+	case syntax.OpQuest:
+		return "optionally " + describeRegexNode(r.Sub[0])
+	case syntax.OpRepeat:
+		sub := describeRegexNode(r.Sub[0])
+		switch {
+		case r.Max == r.Min:
+			return fmt.Sprintf("%s (%d times)", sub, r.Min)
+		case r.Max < 0:
+			return fmt.Sprintf("%s (%d or more times)", sub, r.Min)
+		case r.Min == 0:
+			return fmt.Sprintf("%s (up to %d times)", sub, r.Max)
+		}
+		return fmt.Sprintf("%s (%d to %d times)", sub, r.Min, r.Max)
+	case syntax.OpConcat:
+		var parts []string
+		for _, sub := range r.Sub {
+			if d := describeRegexNode(sub); d != "" {
+				parts = append(parts, d)
+			}
+		}
+		return strings.Join(parts, ", then ")
+	case syntax.OpAlternate:
+		var parts []string
+		for _, sub := range r.Sub {
+			parts = append(parts, describeRegexNode(sub))
+		}
+		return "either " + strings.Join(parts, " or ")
+	}
+	return ""
+}
+
+// isScaleSlug reports whether an interaction slug denotes a Likert/scale survey item.
+func isScaleSlug(slug string) bool {
+	slug = strings.ToLower(slug)
+	return strings.Contains(slug, "likert") || strings.Contains(slug, "scale") || strings.Contains(slug, "survey")
+}
+
+// isMatchingSlug reports whether an interaction slug denotes a matching item.
+func isMatchingSlug(slug string) bool {
+	return strings.EqualFold(slug, "matching")
+}
+
+// matchKey reads the authored key of a matching item (scoring_data {"value": {prompt id:
+// answer}}), when the payload carries it.
+func matchKey(raw json.RawMessage) map[string]string {
+	var wrapped struct {
+		Value map[string]string `json:"value"`
+	}
+	if json.Unmarshal(raw, &wrapped) != nil {
+		return nil
+	}
+	return wrapped.Value
+}
+
+// matchResponses reads a matching result's value: prompt id -> the answer the student chose,
+// either bare or as an entry with user_response, and the correct answer when the entry has
+// correct_answer, or is marked correct.
+func matchResponses(raw json.RawMessage) (chosen, correct map[string]string) {
+	var entries map[string]json.RawMessage
+	if json.Unmarshal(raw, &entries) != nil {
+		return nil, nil
+	}
+	chosen, correct = map[string]string{}, map[string]string{}
+	for id, e := range entries {
+		var bare string
+		if json.Unmarshal(e, &bare) == nil {
+			chosen[id] = bare
+			continue
+		}
+		var entry struct {
+			UserResponse  string `json:"user_response"`
+			CorrectAnswer string `json:"correct_answer"`
+			Correct       *bool  `json:"correct"`
+			ResultScore   *int   `json:"result_score"`
+		}
+		if json.Unmarshal(e, &entry) != nil {
+			continue
+		}
+		chosen[id] = entry.UserResponse
+		switch {
+		case entry.CorrectAnswer != "":
+			correct[id] = entry.CorrectAnswer
+		case entry.UserResponse != "" && (entry.Correct != nil && *entry.Correct || entry.ResultScore != nil && *entry.ResultScore == 1):
+			correct[id] = entry.UserResponse
+		}
+	}
+	return chosen, correct
+}
+
+// isOrderingSlug reports whether an interaction slug denotes an ordering item.
+func isOrderingSlug(slug string) bool {
+	return strings.EqualFold(slug, "ordering")
+}
+
+// orderingKey reads the authored order of an ordering item (scoring_data {"value": [choice
+// ids]}), when the payload carries it.
+func orderingKey(raw json.RawMessage) []string {
+	var wrapped struct {
+		Value []string `json:"value"`
+	}
+	if json.Unmarshal(raw, &wrapped) != nil {
+		return nil
+	}
+	return wrapped.Value
+}
+
+// orderingResponse reads an ordering result's value, one row per position: each row's value
+// is the choice that belongs there and user_responded the one the student put there. A bare
+// list of choice ids is the student's order alone.
+func orderingResponse(raw json.RawMessage) (correct, chosen []string) {
+	var ids []string
+	if json.Unmarshal(raw, &ids) == nil {
+		return nil, ids
+	}
+	var rows []struct {
+		UserResponded string `json:"user_responded"`
+		Value         string `json:"value"`
+	}
+	if json.Unmarshal(raw, &rows) != nil {
+		return nil, nil
+	}
+	for _, r := range rows {
+		correct = append(correct, r.Value)
+		chosen = append(chosen, r.UserResponded)
+	}
+	return correct, chosen
+}
+
+// isCategorizationSlug reports whether an interaction slug denotes a categorization item.
+func isCategorizationSlug(slug string) bool {
+	return strings.EqualFold(slug, "categorization")
+}
+
+// categoryKey reads the authored key of a categorization item (scoring_data {"value": [{"id":
+// category id, "value": [item ids]}]}), when the payload carries it.
+func categoryKey(raw json.RawMessage) map[string][]string {
+	var wrapped struct {
+		Value []struct {
+			ID    string   `json:"id"`
+			Value []string `json:"value"`
+		} `json:"value"`
+	}
+	if json.Unmarshal(raw, &wrapped) != nil || len(wrapped.Value) == 0 {
+		return nil
+	}
+	key := map[string][]string{}
+	for _, c := range wrapped.Value {
+		key[c.ID] = c.Value
+	}
+	return key
+}
+
+// categoryResponses reads a categorization result's value: category id -> the items the
+// student put there, either bare or as an entry with user_response, and the category's
+// correct items when the entry has correct_answer, or is marked correct. A list it can't
+// read is an error, and that category is left out.
+func categoryResponses(raw json.RawMessage) (chosen, correct map[string][]string, err error) {
+	var entries map[string]json.RawMessage
+	if json.Unmarshal(raw, &entries) != nil {
+		return nil, nil, nil
+	}
+	chosen, correct = map[string][]string{}, map[string][]string{}
+	for id, e := range entries {
+		var bare []string
+		if json.Unmarshal(e, &bare) == nil {
+			chosen[id] = bare
+			continue
+		}
+		var entry struct {
+			UserResponse  json.RawMessage `json:"user_response"`
+			CorrectAnswer json.RawMessage `json:"correct_answer"`
+			Correct       *bool           `json:"correct"`
+		}
+		if json.Unmarshal(e, &entry) != nil {
+			continue
+		}
+		response, rerr := decodeStringList(entry.UserResponse)
+		if rerr != nil {
+			err = fmt.Errorf("category %s user_response %w", id, rerr)
+			continue
+		}
+		chosen[id] = response
+		switch {
+		case len(entry.CorrectAnswer) > 0:
+			key, kerr := decodeStringList(entry.CorrectAnswer)
+			if kerr != nil {
+				err = fmt.Errorf("category %s correct_answer %w", id, kerr)
+				continue
+			}
+			correct[id] = key
+		case entry.Correct != nil && *entry.Correct:
+			correct[id] = chosen[id]
+		}
+	}
+	return chosen, correct, err
+}
+
+// isHotSpotSlug reports whether an interaction slug denotes a hot spot item.
+func isHotSpotSlug(slug string) bool {
+	return strings.EqualFold(slug, "hot-spot") || strings.EqualFold(slug, "hotspot")
+}
+
+// hotSpotPoint is a position on a hot spot image, as fractions of its width and height.
+type hotSpotPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+func (p hotSpotPoint) String() string {
+	return fmt.Sprintf("(%g, %g)", p.X, p.Y)
+}
+
+// hotSpotRegion is a correct area of a hot spot image: a shape (square, oval or polygon)
+// outlined by its coordinates.
+type hotSpotRegion struct {
+	Type        string         `json:"type"`
+	Coordinates []hotSpotPoint `json:"coordinates"`
+}
+
+// String describes the region, e.g. "square (0.1, 0.2), (0.4, 0.5)".
+func (r hotSpotRegion) String() string {
+	points := make([]string, len(r.Coordinates))
+	for i, p := range r.Coordinates {
+		points[i] = p.String()
+	}
+	return strings.TrimSpace(r.Type + " " + strings.Join(points, ", "))
+}
+
+// hotSpotRegions decodes one region or an array of them; regions without coordinates are
+// dropped.
+func hotSpotRegions(raw json.RawMessage) []hotSpotRegion {
+	var regions []hotSpotRegion
+	if json.Unmarshal(raw, &regions) != nil {
+		var one hotSpotRegion
+		if json.Unmarshal(raw, &one) != nil {
+			return nil
+		}
+		regions = []hotSpotRegion{one}
+	}
+	var out []hotSpotRegion
+	for _, r := range regions {
+		if len(r.Coordinates) > 0 {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// hotSpotKey reads the correct regions of a hot spot item (scoring_data {"value": region}),
+// when the payload carries them.
+func hotSpotKey(raw json.RawMessage) []hotSpotRegion {
+	var wrapped struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if json.Unmarshal(raw, &wrapped) != nil {
+		return nil
+	}
+	return hotSpotRegions(wrapped.Value)
+}
+
+// hotSpotResponse reads a hot spot result's value: the point the student clicked, either
+// bare or as an entry with user_response, and the correct regions when the entry has
+// correct_answer.
+func hotSpotResponse(raw json.RawMessage) (click *hotSpotPoint, correct []hotSpotRegion) {
+	var entry struct {
+		X             *float64        `json:"x"`
+		Y             *float64        `json:"y"`
+		UserResponse  *hotSpotPoint   `json:"user_response"`
+		CorrectAnswer json.RawMessage `json:"correct_answer"`
+	}
+	if json.Unmarshal(raw, &entry) != nil {
+		return nil, nil
+	}
+	click = entry.UserResponse
+	if entry.X != nil && entry.Y != nil {
+		click = &hotSpotPoint{X: *entry.X, Y: *entry.Y}
+	}
+	return click, hotSpotRegions(entry.CorrectAnswer)
+}
+
+// isNumericSlug reports whether an interaction slug denotes a numeric item.
+func isNumericSlug(slug string) bool {
+	return strings.EqualFold(slug, "numeric")
+}
+
+// numericNumber reads a number of numeric scoring data, which Canvas writes as a JSON
+// number or a string; ok is false when the field is missing or not a number.
+func numericNumber(raw json.RawMessage) (v float64, ok bool) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		raw = json.RawMessage(strings.TrimSpace(s))
+	}
+	return v, len(raw) > 0 && json.Unmarshal(raw, &v) == nil
+}
+
+// numericKey reads the accepted answers of a numeric item (scoring_data {"value": [answer]})
+// as labels: "9.81" for an exact response, "9.81 ± 0.05" or "9.81 ± 5%" within a margin of
+// error, "between 1 and 2.5" for a range, and "3.14 (2 decimal places)" or "3.14 (3
+// significant digits)" for a precise response.
+func numericKey(raw json.RawMessage) []string {
+	var wrapped struct {
+		Value []map[string]json.RawMessage `json:"value"`
+	}
+	if json.Unmarshal(raw, &wrapped) != nil {
+		return nil
+	}
+	var out []string
+	for _, a := range wrapped.Value {
+		if label := numericLabel(a); label != "" {
+			out = append(out, label)
+		}
+	}
+	return out
+}
+
+// numericLabel labels one accepted answer of numeric scoring data (see numericKey); "" when
+// it has no value.
+func numericLabel(a map[string]json.RawMessage) string {
+	var kind, marginType, precisionType string
+	_ = json.Unmarshal(a["type"], &kind)
+	_ = json.Unmarshal(a["margin_type"], &marginType)
+	_ = json.Unmarshal(a["precision_type"], &precisionType)
+	value, hasValue := numericNumber(a["value"])
+	switch kind {
+	case "withinARange":
+		start, okStart := numericNumber(a["start"])
+		end, okEnd := numericNumber(a["end"])
+		if okStart && okEnd {
+			return fmt.Sprintf("between %s and %s", FormatPoints(start), FormatPoints(end))
+		}
+	case "marginOfError":
+		if margin, ok := numericNumber(a["margin"]); ok && hasValue && margin != 0 {
+			label := FormatPoints(value) + " ± " + FormatPoints(margin)
+			if strings.EqualFold(marginType, "percent") {
+				label += "%"
+			}
+			return label
+		}
+	case "preciseResponse":
+		if precision, ok := numericNumber(a["precision"]); ok && hasValue {
+			unit := "decimal places"
+			if strings.EqualFold(precisionType, "significantDigits") {
+				unit = "significant digits"
+			}
+			return fmt.Sprintf("%s (%s %s)", FormatPoints(value), FormatPoints(precision), unit)
+		}
+	}
+	if hasValue {
+		return FormatPoints(value) // exactResponse, or a type this doesn't know
+	}
+	return ""
+}
+
+// numericResponse reads the number a student gave to a numeric item: the result value bare,
+// or an entry with user_response or value.
+func numericResponse(raw json.RawMessage) string {
+	if v, ok := numericNumber(raw); ok {
+		return FormatPoints(v)
+	}
+	var entry struct {
+		UserResponse json.RawMessage `json:"user_response"`
+		Value        json.RawMessage `json:"value"`
+	}
+	if json.Unmarshal(raw, &entry) != nil {
+		return ""
+	}
+	for _, r := range []json.RawMessage{entry.UserResponse, entry.Value} {
+		if v, ok := numericNumber(r); ok {
+			return FormatPoints(v)
+		}
+	}
+	return ""
+}
+
+// isFormulaSlug reports whether an interaction slug denotes a formula (calculated) item.
+func isFormulaSlug(slug string) bool {
+	return strings.EqualFold(slug, "formula")
+}
+
+// formulaSolution is one set of variable values of a formula item with the answer they yield.
+type formulaSolution struct {
+	Inputs []struct {
+		Name  string          `json:"name"`
+		Value json.RawMessage `json:"value"`
+	} `json:"inputs"`
+	Output json.RawMessage `json:"output"`
+}
+
+// given lists the solution's variable values, e.g. "x=3, y=7".
+func (s formulaSolution) given() string {
+	var parts []string
+	for _, in := range s.Inputs {
+		v, ok := numericNumber(in.Value)
+		value := FormatPoints(v)
+		if !ok {
+			value = strings.Trim(strings.TrimSpace(string(in.Value)), `"`)
+		}
+		parts = append(parts, in.Name+"="+value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formulaAnswer reads a formula item: its formula, the variable values the answer was
+// computed for, the answer with the item's tolerance, and the student's number. Each
+// student draws their own values, so the result's are used when it records them
+// ({"inputs": [...], "output": ..., "user_response": ...}); otherwise the first solution
+// Canvas generated for the item (scoring_data {"value": {"formula", "numeric",
+// "generated_solutions"}}) stands in as an example.
+func formulaAnswer(scoringData, result json.RawMessage) (formula, given, answer, response string) {
+	var key struct {
+		Value struct {
+			Formula   string                     `json:"formula"`
+			Numeric   map[string]json.RawMessage `json:"numeric"`
+			Solutions []formulaSolution          `json:"generated_solutions"`
+		} `json:"value"`
+	}
+	_ = json.Unmarshal(scoringData, &key)
+	formula = strings.TrimSpace(key.Value.Formula)
+
+	var entry struct {
+		formulaSolution
+		UserResponse  json.RawMessage `json:"user_response"`
+		CorrectAnswer json.RawMessage `json:"correct_answer"`
+	}
+	_ = json.Unmarshal(result, &entry)
+	solution := entry.formulaSolution
+	if len(solution.Output) == 0 {
+		solution.Output = entry.CorrectAnswer
+	}
+	if len(solution.Inputs) == 0 && len(key.Value.Solutions) > 0 {
+		solution = key.Value.Solutions[0]
+	}
+	if v, ok := numericNumber(entry.UserResponse); ok {
+		response = FormatPoints(v)
+	}
+
+	given = solution.given()
+	if _, ok := numericNumber(solution.Output); ok {
+		tolerance := map[string]json.RawMessage{"value": solution.Output}
+		for k, v := range key.Value.Numeric {
+			if k != "value" {
+				tolerance[k] = v
+			}
+		}
+		answer = numericLabel(tolerance)
+	}
+	return formula, given, answer, response
+}
+
+// sameOrder reports whether two ordering answers list the same choices in the same order.
+func sameOrder(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isHotTextSlug reports whether an interaction slug denotes a hot-text (select-in-passage) item.
+func isHotTextSlug(slug string) bool {
+	slug = strings.ToLower(slug)
+	return strings.Contains(slug, "hot-text") || strings.Contains(slug, "hottext") || slug == "highlight"
+}
+
+// questionTypeNames maps New Quizzes interaction slugs and Classic question_type values to
+// the names -split-by type groups questions under.
+var questionTypeNames = map[string]string{
+	"choice":                           "multiple choice",
+	"multiple_choice_question":         "multiple choice",
+	"true-false":                       "true/false",
+	"true_false_question":              "true/false",
+	"multi-answer":                     "multiple answer",
+	"multiple_answers_question":        "multiple answer",
+	"rich-fill-blank":                  "fill in the blank",
+	"fill-blank":                       "fill in the blank",
+	"short_answer_question":            "fill in the blank",
+	"fill_in_multiple_blanks_question": "fill in the blank",
+	"multiple_dropdowns_question":      "fill in the blank",
+	"matching":                         "matching",
+	"matching_question":                "matching",
+	"categorization":                   "categorization",
+	"ordering":                         "ordering",
+	"numeric":                          "numeric",
+	"numerical_question":               "numeric",
+	"formula":                          "formula",
+	"calculated_question":              "formula",
+	"essay":                            "essay",
+	"essay_question":                   "essay",
+	"file-upload":                      "file upload",
+	"file_upload_question":             "file upload",
+	"hot-spot":                         "hot spot",
+}
+
+// questionType names a question's type from its interaction slug (or Classic question_type),
+// falling back to the interaction name Canvas sent, then "other".
+func questionType(slug, name, userRespType string) string {
+	switch {
+	case questionTypeNames[slug] != "":
+		return questionTypeNames[slug]
+	case strings.EqualFold(userRespType, "Boolean"):
+		return "true/false"
+	case isHotTextSlug(slug):
+		return "hot text"
+	case isScaleSlug(slug):
+		return "survey"
+	case strings.TrimSpace(name) != "":
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+	return "other"
+}
+
+// Media is an audio or video clip embedded in a question body, or the image of a hot spot
+// question.
+type Media struct {
+	Kind     string // "audio", "video" or "image"
+	URL      string
+	Title    string
+	Duration time.Duration // 0 when the payload does not say
+	Player   bool          // URL is the media file itself rather than a Canvas media page
+}
+
+// Label names the clip for links and captions, e.g. "Video: lecture.mp4 (2:15)".
+func (m Media) Label() string {
+	s := strings.ToUpper(m.Kind[:1]) + m.Kind[1:]
+	if m.Title != "" {
+		s += ": " + m.Title
+	}
+	if m.Duration > 0 {
+		s += " (" + formatClock(m.Duration) + ")"
+	}
+	return s
+}
+
+var (
+	reMediaElement = regexp.MustCompile(`(?is)<(audio|video)\b([^>]*)>(.*?)</(?:audio|video)>`)
+	reMediaSource  = regexp.MustCompile(`(?is)<source\b([^>]*)>`)
+	reMediaComment = regexp.MustCompile(`(?is)<a\b([^>]*\binstructure_inline_media_comment\b[^>]*)>(.*?)</a>`)
+	reMediaIframe  = regexp.MustCompile(`(?is)<iframe\b([^>]*/media_(?:objects|attachments)_iframe/[^>]*)>`)
+)
+
+// extractMedia finds the clips a question body embeds: <audio>/<video> elements, Canvas
+// media comment links and the media player iframes the rich content editor inserts.
+func extractMedia(body string) []Media {
+	var out []Media
+	seen := map[string]bool{}
+	add := func(m Media, attrs string) {
+		if m.URL == "" || seen[m.URL] {
+			return
+		}
+		seen[m.URL] = true
+		if m.Title == "" {
+			m.Title = htmlAttr(attrs, "title")
+		}
+		if m.Title == "" {
+			m.Title = htmlAttr(attrs, "aria-label")
+		}
+		for _, name := range []string{"data-duration", "data-media-duration", "duration"} {
+			if sec, err := strconv.ParseFloat(htmlAttr(attrs, name), 64); err == nil && sec > 0 {
+				m.Duration = time.Duration(sec * float64(time.Second))
+				break
+			}
+		}
+		out = append(out, m)
+	}
+	for _, el := range reMediaElement.FindAllStringSubmatch(body, -1) {
+		kind, attrs := strings.ToLower(el[1]), el[2]
+		src := htmlAttr(attrs, "src")
+		if src == "" {
+			if s := reMediaSource.FindStringSubmatch(el[3]); s != nil {
+				src = htmlAttr(s[1], "src")
+			}
+		}
+		title := ""
+		if src != "" && htmlAttr(attrs, "title") == "" && htmlAttr(attrs, "aria-label") == "" {
+			title = pathpkg.Base(strings.SplitN(src, "?", 2)[0])
+		}
+		add(Media{Kind: kind, URL: src, Title: title, Player: true}, attrs)
+	}
+	for _, a := range reMediaComment.FindAllStringSubmatch(body, -1) {
+		attrs := a[1]
+		kind := "video"
+		if strings.Contains(htmlAttr(attrs, "class"), "audio_comment") || htmlAttr(attrs, "data-media_comment_type") == "audio" {
+			kind = "audio"
+		}
+		title := stripHTML(a[2])
+		if strings.EqualFold(title, "this is a media comment") {
+			title = "" // Canvas placeholder text
+		}
+		add(Media{Kind: kind, URL: htmlAttr(attrs, "href"), Title: title}, attrs)
+	}
+	for _, f := range reMediaIframe.FindAllStringSubmatch(body, -1) {
+		attrs := f[1]
+		kind := "video"
+		if htmlAttr(attrs, "data-media-type") == "audio" || strings.Contains(htmlAttr(attrs, "src"), "type=audio") {
+			kind = "audio"
+		}
+		add(Media{Kind: kind, URL: htmlAttr(attrs, "src")}, attrs)
+	}
+	return out
+}
+
+// htmlAttr returns the unescaped value of a quoted attribute in a tag's attribute text.
+func htmlAttr(attrs, name string) string {
+	re := regexp.MustCompile(`(?is)(?:^|\s)` + regexp.QuoteMeta(name) + `\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	m := re.FindStringSubmatch(attrs)
+	if m == nil {
+		return ""
+	}
+	return strings.TrimSpace(html.UnescapeString(m[1] + m[2]))
+}
+
+// Link is an external hyperlink found in a question body or its feedback.
+type Link struct {
+	URL  string
+	Text string // link text; the URL itself when the anchor has none
+}
+
+var reAnchor = regexp.MustCompile(`(?is)<a\b([^>]*)>(.*?)</a>`)
+
+// extractLinks collects the absolute http(s) links of HTML fragments in order, once each.
+// Canvas-relative links and media comment links (see extractMedia) are skipped.
+func extractLinks(fragments ...string) []Link {
+	var out []Link
+	seen := map[string]bool{}
+	for _, frag := range fragments {
+		for _, a := range reAnchor.FindAllStringSubmatch(frag, -1) {
+			href := htmlAttr(a[1], "href")
+			lower := strings.ToLower(href)
+			if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") ||
+				strings.Contains(htmlAttr(a[1], "class"), "instructure_inline_media_comment") || seen[href] {
+				continue
+			}
+			seen[href] = true
+			text := stripHTML(a[2])
+			if text == "" {
+				text = href
+			}
+			out = append(out, Link{URL: href, Text: text})
+		}
+	}
+	return out
+}
+
+// Reference is one external link of the document with the questions that cite it.
+type Reference struct {
+	Link
+	Questions []int // question numbers, in document order
+}
+
+// References gathers the links of every question, in order of first citation. Links to
+// files with a local copy in doc.Assets point to it.
+func (doc QuizDoc) References() []Reference {
+	var refs []Reference
+	index := map[string]int{}
+	for _, q := range doc.Questions {
+		for _, l := range q.Links {
+			if local := doc.Assets[l.URL]; local != "" {
+				l.URL = local
+			}
+			i, ok := index[l.URL]
+			if !ok {
+				i = len(refs)
+				index[l.URL] = i
+				refs = append(refs, Reference{Link: l})
+			}
+			if n := len(refs[i].Questions); n == 0 || refs[i].Questions[n-1] != q.Number {
+				refs[i].Questions = append(refs[i].Questions, q.Number)
+			}
+		}
+	}
+	return refs
+}
+
+// citedBy renders a reference's back-references as "Q1, Q4"; link wraps each label.
+func (r Reference) citedBy(link func(n int, label string) string) string {
+	var parts []string
+	for _, n := range r.Questions {
+		parts = append(parts, link(n, fmt.Sprintf("Q%d", n)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func plainCite(_ int, label string) string { return label }
+
+// GlossaryEntry is one term of the glossary appendix.
+type GlossaryEntry struct {
+	Term       string
+	Definition string // from -glossary-terms; empty for terms found by the heuristics
+	Questions  []int  // question numbers that use the term
+}
+
+var reEmphasis = regexp.MustCompile(`(?is)<(strong|b|em|i|dfn)\b[^>]*>(.*?)</(?:strong|b|em|i|dfn)>`)
+
+// extractTerms returns the emphasized and <dfn> phrases of question HTML that look like
+// terms: at most five words, not just a number.
+func extractTerms(body string) []string {
+	var out []string
+	for _, m := range reEmphasis.FindAllStringSubmatch(body, -1) {
+		if t, ok := glossaryTerm(stripHTML(m[2]), 5); ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// glossaryTerm trims a candidate term and reports whether it is short enough to be one.
+func glossaryTerm(s string, maxWords int) (string, bool) {
+	t := strings.TrimRight(strings.TrimSpace(s), ".,;:!?")
+	if n := len(strings.Fields(t)); n == 0 || n > maxWords || utf8.RuneCountInString(t) < 2 {
+		return "", false
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(t, ",", ""), 64); err == nil {
+		return "", false
+	}
+	return t, true
+}
+
+// BuildGlossary collects terms from the emphasized phrases of each stem, short correct
+// answers (up to four words, excluding True/False) and the user's term list, which also
+// supplies definitions. User terms are kept only when some question mentions them. Entries
+// are sorted alphabetically, ignoring case.
+func BuildGlossary(doc QuizDoc, userTerms map[string]string) []GlossaryEntry {
+	var entries []*GlossaryEntry
+	byKey := map[string]*GlossaryEntry{}
+	add := func(term string, n int) {
+		key := strings.ToLower(term)
+		e, ok := byKey[key]
+		if !ok {
+			e = &GlossaryEntry{Term: term}
+			byKey[key] = e
+			entries = append(entries, e)
+		}
+		if k := len(e.Questions); k == 0 || e.Questions[k-1] != n {
+			e.Questions = append(e.Questions, n)
+		}
+	}
+	user := make([]string, 0, len(userTerms))
+	for term := range userTerms {
+		user = append(user, term)
+	}
+	sort.Strings(user)
+	for _, q := range doc.Questions {
+		for _, term := range user {
+			re := regexp.MustCompile(`(?i)(^|\W)` + regexp.QuoteMeta(term) + `(\W|$)`)
+			if re.MatchString(questionText(q)) {
+				add(term, q.Number)
+			}
+		}
+		for _, t := range q.Terms {
+			add(t, q.Number)
+		}
+		answers := append([]string{}, q.Answers...)
+		for _, b := range q.Blanks {
+			answers = append(answers, b.Answer)
+		}
+		for _, a := range answers {
+			if t, ok := glossaryTerm(a, 4); ok && !strings.EqualFold(t, "true") && !strings.EqualFold(t, "false") {
+				add(t, q.Number)
+			}
+		}
+	}
+	out := make([]GlossaryEntry, 0, len(entries))
+	for _, e := range entries {
+		for _, term := range user {
+			if strings.EqualFold(term, e.Term) {
+				e.Definition = userTerms[term]
+			}
+		}
+		out = append(out, *e)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return strings.ToLower(out[i].Term) < strings.ToLower(out[j].Term) })
+	return out
+}
+
+// questionText joins the visible text of a question for term matching.
+func questionText(q Question) string {
+	parts := []string{q.Text, q.GeneralFeedback, q.CorrectFeedback, q.IncorrectFeedback}
+	parts = append(parts, q.Answers...)
+	for _, o := range q.Options {
+		parts = append(parts, o.Label, o.Feedback)
+	}
+	for _, b := range q.Blanks {
+		parts = append(parts, b.Answer)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// writeMarkdownGlossary renders the glossary appendix.
+func writeMarkdownGlossary(sb *strings.Builder, glossary []GlossaryEntry) {
+	if len(glossary) == 0 {
+		return
+	}
+	sb.WriteString("## Glossary\n\n")
+	for _, e := range glossary {
+		line := "- **" + e.Term + "**"
+		if e.Definition != "" {
+			line += ": " + e.Definition
+		}
+		sb.WriteString(line + " (" + glossaryCites(e.Questions, plainCite) + ")\n")
+	}
+	sb.WriteString("\n")
+}
+
+func writeHTMLGlossary(sb *strings.Builder, glossary []GlossaryEntry) {
+	if len(glossary) == 0 {
+		return
+	}
+	sb.WriteString("<section class=\"glossary\">\n<h2>Glossary</h2>\n<dl>\n")
+	for _, e := range glossary {
+		cites := glossaryCites(e.Questions, func(n int, label string) string { return fmt.Sprintf("<a href=\"#q%d\">%s</a>", n, label) })
+		sb.WriteString("<dt>" + html.EscapeString(e.Term) + "</dt>\n<dd>")
+		if e.Definition != "" {
+			sb.WriteString(html.EscapeString(e.Definition) + " ")
+		}
+		sb.WriteString("(" + cites + ")</dd>\n")
+	}
+	sb.WriteString("</dl>\n</section>\n")
+}
+
+func glossaryCites(questions []int, link func(n int, label string) string) string {
+	return Reference{Questions: questions}.citedBy(link)
+}
+
+// splitHotText breaks passage HTML into runs, treating every <span> that carries an id
+// (data-hot-text-id, data-id or id) as a selectable region.
+func splitHotText(passage string) []PassageSpan {
+	var spans []PassageSpan
+	last := 0
+	for _, m := range reHotTextSpan.FindAllStringSubmatchIndex(passage, -1) {
+		if text := passage[last:m[0]]; strings.TrimSpace(stripHTML(text)) != "" {
+			spans = append(spans, PassageSpan{Text: stripHTMLKeepEdges(text)})
+		}
+		spans = append(spans, PassageSpan{Text: stripHTML(passage[m[4]:m[5]]), ID: passage[m[2]:m[3]], Selectable: true})
+		last = m[1]
+	}
+	if text := passage[last:]; strings.TrimSpace(stripHTML(text)) != "" {
+		spans = append(spans, PassageSpan{Text: stripHTMLKeepEdges(text)})
+	}
+	if len(spans) > 0 {
+		spans[0].Text = strings.TrimLeft(spans[0].Text, " ")
+		spans[len(spans)-1].Text = strings.TrimRight(spans[len(spans)-1].Text, " ")
+	}
+	return spans
+}
+
+// stripHTMLKeepEdges strips like stripHTML but keeps a single leading/trailing space so
+// passage runs still join into readable text.
+func stripHTMLKeepEdges(s string) string {
+	out := stripHTML(s)
+	plain := html.UnescapeString(reTag.ReplaceAllString(s, " "))
+	if strings.TrimLeft(plain, " \t\r\n") != plain {
+		out = " " + out
+	}
+	if strings.TrimRight(plain, " \t\r\n") != plain {
+		out += " "
+	}
+	return out
+}
+
+// decodeAnswerFeedback reads per-choice feedback, which Canvas sends either as a map keyed by
+// choice id or as an array of {id|choice_id, feedback|item_body} objects, with ids as strings
+// or numbers. Other shapes are an error.
+func decodeAnswerFeedback(raw json.RawMessage) (map[string]string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var mapForm map[string]string
+	if err := json.Unmarshal(raw, &mapForm); err == nil {
+		return mapForm, nil
+	}
+	var arrayForm []struct {
+		ID       json.RawMessage `json:"id"`
+		ChoiceID json.RawMessage `json:"choice_id"`
+		Feedback string          `json:"feedback"`
+		ItemBody string          `json:"item_body"`
+	}
+	if err := json.Unmarshal(raw, &arrayForm); err != nil {
+		return nil, fmt.Errorf("answer feedback is %s, not feedback keyed by choice id or a list of it", valueShape(raw))
+	}
+	out := map[string]string{}
+	for _, row := range arrayForm {
+		id, err := decodeID(row.ChoiceID)
+		if err == nil && id == "" {
+			id, err = decodeID(row.ID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("answer feedback %w", err)
+		}
+		text := row.Feedback
+		if text == "" {
+			text = row.ItemBody
+		}
+		if id != "" && text != "" {
+			out[id] = text
+		}
+	}
+	return out, nil
+}
+
+func findResultByID(results []ResultItem, id string) (ResultItem, error) {
+	for _, r := range results {
+		if r.ItemID == id {
+			return r, nil
+		}
+	}
+	return ResultItem{}, errors.New("result not found for item_id=" + id)
+}
+
+// Question is the renderer-agnostic view of one quiz item joined with its result.
+type Question struct {
+	Number     int
+	ItemID     string
+	ContentID  string // content hash of the stem and choices, stable across exports (see contentID)
+	Text       string // plain-text stem, blanks annotated as [Blank i]
+	Title      string // the item's title as authored, e.g. "Dynamic programming 3"; often just "Question 3"
+	Group      *QuestionGroup
+	Stimulus   *Stimulus // the passage the question is asked about
+	Bank       string    // title of the item bank the question came from
+	Quizzes    []string  // labels of the quizzes the question appeared in (see BuildBank)
+	HasResult  bool
+	OpenEntry  bool
+	Essay      bool
+	Options    []Option
+	Blanks     []BlankAnswer
+	WordBank   []string      // labels of the shared word bank, in authored order
+	Passage    []PassageSpan // hot-text passage split into plain and selectable runs
+	Rubric     []RubricCriterion
+	Answers    []string // labels of the correct choices
+	Multi      bool
+	Ungraded   bool     // survey/scale item: Responses are shown instead of an answer key
+	Scale      bool     // Likert/scale item; Options are the scale points
+	Responses  []string // labels the student chose, for ungraded items
+	Comments   []Comment
+	Media      []Media  // audio and video embedded in the stem
+	Links      []Link   // external links in the stem and feedback, for the References appendix
+	Terms      []string // emphasized phrases of the stem, glossary candidates
+	Tags       []string // classification and topic tags, e.g. "bloom:apply"
+	Type       string   // question type name, e.g. "multiple choice" (see questionType)
+	Slug       string   // raw interaction slug (New Quizzes) or question_type (Classic)
+	Repaired   bool     // the body was malformed HTML and went through RepairHTML
+	BodyHTML   string   // the body's HTML when it has code, lists, tables or images (see richBody); HTML output shows it sanitized
+	Unanswered bool     // the result records no response at all, as opposed to a wrong one
+	Possible   float64  // points possible
+	Earned     *float64 // points the student scored; nil without a result
+
+	KeyConfidence     string          // where the key came from: KeyConfirmed, KeyInferred or KeyUnknown; "" when there is no key to rate
+	ResultShape       string          // what scored_data.value held when no key could be read from it (see Diagnose)
+	Unreadable        []string        // parts of the item or result in a shape no decoder reads, with why (see Diagnose)
+	Formula           string          // formula: the expression the answer is computed from
+	Submission        []string        // essay: the student's text, in paragraphs
+	Files             []string        // essay/file upload: names of the files the student uploaded
+	Given             string          // formula: the variable values the answer is for, e.g. "x=3, y=7"
+	GeneralFeedback   string          // item feedback shown regardless of the response
+	CorrectFeedback   string          // item feedback shown for a correct response
+	IncorrectFeedback string          // item feedback shown for an incorrect response
+	Explanation       string          // rationale chosen by ApplyExplanations
+	Class             *ClassStats     // class-wide results from -quiz-stats
+	Attempts          []AttemptAnswer // every attempt's answer, when several results files are merged
+	Matches           []MatchPair     // matching: each prompt with its correct and chosen answer
+	MatchAnswers      []string        // matching: every answer on offer, distractors included
+	Order             []string        // ordering: the choices in their correct order
+	Categories        []Category      // categorization: each category with its items
+	CategoryItems     []string        // categorization: every item to sort, distractors included
+	ResponseOrder     []string        // ordering: the choices in the student's order
+}
+
+// awarded is the points scored out of the points possible, e.g. "0.5 / 1"; "" without a result.
+func (q Question) awarded() string {
+	if q.Earned == nil {
+		return ""
+	}
+	return FormatPoints(RoundTo(*q.Earned, 2)) + " / " + FormatPoints(q.Possible)
+}
+
+// submissionSummary sums up an essay submission in a line, e.g. "212 words; report.pdf".
+func (q Question) submissionSummary() string {
+	var parts []string
+	if len(q.Submission) > 0 {
+		words := 0
+		for _, p := range q.Submission {
+			words += len(strings.Fields(p))
+		}
+		if words == 1 {
+			parts = append(parts, "1 word")
+		} else {
+			parts = append(parts, fmt.Sprintf("%d words", words))
+		}
+	}
+	if len(q.Files) > 0 {
+		parts = append(parts, strings.Join(q.Files, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// givenPrefix leads a formula question's answer with the values it was computed for, e.g.
+// "Given x=3, y=7 → "; "" for other questions.
+func (q Question) givenPrefix() string {
+	if q.Given == "" {
+		return ""
+	}
+	return "Given " + q.Given + " → "
+}
+
+// MatchPair is one prompt of a matching question.
+type MatchPair struct {
+	Prompt   string
+	Answer   string // correct match; "" when neither the key nor the result has it
+	Response string // the student's match; "" when unanswered or unknown
+}
+
+// ResponseCheck is how a question's response compares with its key (see CheckResponse).
+type ResponseCheck struct {
+	Correct  bool   // full points
+	Response string // the student's answer; "(left blank)" when unanswered
+	Key      string // the correct answer; "" for essays, which are graded by hand
+	Score    string // e.g. "0.5 / 1"
+}
+
+// Mark is ✅ for full points and ❌ otherwise, partial credit included.
+func (c ResponseCheck) Mark() string {
+	if c.Correct {
+		return "✅"
+	}
+	return "❌"
+}
+
+// CheckResponse compares q's response with its key; ok is false for questions without a
+// score, such as survey items or questions the results don't cover.
+func (q Question) CheckResponse() (c ResponseCheck, ok bool) {
+	if q.Earned == nil || q.Ungraded {
+		return ResponseCheck{}, false
+	}
+	c.Correct = !q.Unanswered && *q.Earned >= q.Possible
+	c.Score = q.awarded()
+	// Choice labels can contain commas, so lists are joined with semicolons.
+	switch {
+	case q.Unanswered:
+		c.Response = "(left blank)"
+	case q.Essay && (len(q.Submission) > 0 || len(q.Files) > 0):
+		c.Response = q.submissionSummary()
+	case creditResponse(q) != "":
+		c.Response = creditResponse(q)
+		c.Score += " (partial credit)"
+	case len(q.Responses) > 0:
+		c.Response = strings.Join(q.Responses, "; ")
+	default:
+		c.Response = "(not recorded)"
+	}
+	var key []string
+	for _, b := range q.Blanks {
+		if b.Answer != "" {
+			key = append(key, b.Label+": "+b.Answer)
+		}
+	}
+	if len(q.Blanks) == 0 {
+		key = q.Answers
+	}
+	switch {
+	case len(key) > 0:
+		c.Key = strings.Join(key, "; ")
+	case !q.Essay:
+		c.Key = "(answer unavailable)"
+	}
+	return c, true
+}
+
+// partialCredit sets Credit on the choices selected in a multiple-answer question scored
+// with partial credit, the way New Quizzes scores one: each correct choice selected earns
+// the points possible divided by the number of correct choices, each incorrect one loses as
+// much, and the total doesn't go below zero. algorithm is the item's scoring_algorithm;
+// without one, partial credit is assumed for a score that is neither zero nor full. Nothing
+// is set when the rule doesn't give the recorded score, as after a manual regrade.
+func partialCredit(q *Question, algorithm string) {
+	if !q.Multi || q.Earned == nil || q.Possible <= 0 || q.Unanswered {
+		return
+	}
+	switch {
+	case strings.HasPrefix(strings.ToLower(algorithm), "partial"):
+	case algorithm == "" && *q.Earned > 0 && *q.Earned < q.Possible:
+	default:
+		return
+	}
+	correct := 0
+	for _, o := range q.Options {
+		if o.Correct {
+			correct++
+		}
+	}
+	if correct == 0 {
+		return
+	}
+	share := q.Possible / float64(correct)
+	credit := make([]float64, len(q.Options))
+	total := 0.0
+	for i, o := range q.Options {
+		switch {
+		case !o.Selected:
+			continue
+		case o.Correct:
+			credit[i] = share
+		default:
+			credit[i] = -share
+		}
+		total += credit[i]
+	}
+	if math.Abs(math.Max(total, 0)-*q.Earned) > 0.005 {
+		return
+	}
+	for i := range q.Options {
+		if q.Options[i].Selected {
+			q.Options[i].Credit = &credit[i]
+		}
+	}
+}
+
+// creditResponse lists the choices selected in a question scored with partial credit, each
+// with the points it gained or lost, e.g. "Blocking I/O (+0.33); Efficient indexing (-0.33)".
+// It is "" when the choices carry no credit (see partialCredit).
+func creditResponse(q Question) string {
+	var parts []string
+	for _, o := range q.Options {
+		if o.Credit == nil {
+			continue
+		}
+		sign := "+"
+		if *o.Credit < 0 {
+			sign = "-"
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s%s)", o.Label, sign, FormatPoints(RoundTo(math.Abs(*o.Credit), 2))))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Category is one category of a categorization question.
+type Category struct {
+	Name      string
+	Members   []string // the items that belong here; nil when neither the key nor the result has them
+	Responses []string // the items the student put here
+}
+
+// Distractors are the answers of a matching question that match no prompt, or the items of
+// a categorization question that belong in no category; nil without a key.
+func (q Question) Distractors() []string {
+	used := map[string]bool{}
+	for _, m := range q.Matches {
+		if m.Answer == "" {
+			return nil
+		}
+		used[m.Answer] = true
+	}
+	offered := q.MatchAnswers
+	if len(q.Categories) > 0 {
+		offered = q.CategoryItems
+		for _, c := range q.Categories {
+			if c.Members == nil {
+				return nil
+			}
+			for _, m := range c.Members {
+				used[m] = true
+			}
+		}
+	}
+	var out []string
+	for _, a := range offered {
+		if !used[a] {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// AttemptAnswer is one results file's answer to a question in a document merged from several
+// attempts or regrades (see MergeAttempts).
+type AttemptAnswer struct {
+	Attempt string // "Attempt 2", or the file name when attempt numbers don't tell files apart
+	Answer  string // chosen labels or blank responses, "(no response)" without any
+	Score   string // e.g. "0.5 / 1"
+}
+
+type Option struct {
+	ID       string
+	Label    string
+	Correct  bool
+	Selected bool     // the student's response included this choice
+	Feedback string   // why this choice is right or wrong, when the instructor provided it
+	Credit   *float64 // points this selected choice gained (or lost, when negative) under partial credit; see partialCredit
+}
+
+// QuestionGroup is the normalized form of QuizGroup: "pick Pick of Of questions".
+type QuestionGroup struct {
+	ID    string
+	Title string
+	Pick  int
+	Of    int
+}
+
+// Rule describes the pick rule, e.g. "pick 2 of 5"; "" when the counts are unknown.
+func (g QuestionGroup) Rule() string {
+	switch {
+	case g.Pick > 0 && g.Of > 0:
+		return fmt.Sprintf("pick %d of %d", g.Pick, g.Of)
+	case g.Pick > 0:
+		return fmt.Sprintf("pick %d", g.Pick)
+	}
+	return ""
+}
+
+// newQuestionGroup normalizes a raw group; nil in, nil out.
+func newQuestionGroup(g *QuizGroup) *QuestionGroup {
+	if g == nil || (g.ID == "" && g.Title == "") {
+		return nil
+	}
+	out := &QuestionGroup{ID: g.ID, Title: g.Title, Pick: g.PickCount, Of: g.ItemCount}
+	if out.ID == "" {
+		out.ID = g.Title
+	}
+	if out.Title == "" {
+		out.Title = "Question group"
+	}
+	if out.Pick == 0 {
+		out.Pick = g.SampleNum
+	}
+	if out.Of == 0 {
+		out.Of = g.EntryCount
+	}
+	return out
+}
+
+// Stimulus is the normalized form of QuizStimulus.
+type Stimulus struct {
+	ID    string
+	Title string
+	Text  []string // plain-text paragraphs
+	HTML  string
+}
+
+// Heading is "Stimulus: Title", or "Stimulus" when it has no title.
+func (s Stimulus) Heading() string {
+	if s.Title == "" {
+		return "Stimulus"
+	}
+	return "Stimulus: " + s.Title
+}
+
+// newStimulus normalizes a stimulus for one question; nil in, nil out. Each question gets
+// its own copy so that MapText rewrites it once.
+func newStimulus(s *QuizStimulus) *Stimulus {
+	if s == nil {
+		return nil
+	}
+	body, _ := RepairHTML(s.Body)
+	return &Stimulus{ID: s.ID, Title: s.Title, Text: htmlParagraphs(body), HTML: body}
+}
+
+// stimulusChange reports whether q refers to a different stimulus than prev.
+func stimulusChange(prev, q *Question) bool {
+	var prevID, curID string
+	if prev != nil && prev.Stimulus != nil {
+		prevID = prev.Stimulus.ID
+	}
+	if q.Stimulus != nil {
+		curID = q.Stimulus.ID
+	}
+	return prevID != curID
+}
+
+// groupChange reports whether q starts a different group than prev (nil prev = document start).
+func groupChange(prev, q *Question) bool {
+	var prevID, curID string
+	if prev != nil && prev.Group != nil {
+		prevID = prev.Group.ID
+	}
+	if q.Group != nil {
+		curID = q.Group.ID
+	}
+	return prevID != curID
+}
+
+// Comment is an instructor comment on the whole submission or on one question.
+type Comment struct {
+	Author string
+	Text   string
+	Date   string
+}
+
+// ParseComments decodes Canvas comment arrays ([{author_name, comment, created_at}]),
+// also accepting a bare string or an array of strings. Any other shape is an error.
+func ParseComments(raw json.RawMessage) ([]Comment, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var rows []struct {
+		AuthorName string `json:"author_name"`
+		Comment    string `json:"comment"`
+		Text       string `json:"text"`
+		CreatedAt  string `json:"created_at"`
+	}
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		texts, err := decodeStringList(raw)
+		if err != nil {
+			return nil, fmt.Errorf("comments %w", err)
+		}
+		var out []Comment
+		for _, t := range texts {
+			if t = stripHTML(t); t != "" {
+				out = append(out, Comment{Text: t})
+			}
+		}
+		return out, nil
+	}
+	var out []Comment
+	for _, r := range rows {
+		text := r.Comment
+		if text == "" {
+			text = r.Text
+		}
+		if text = stripHTML(text); text == "" {
+			continue
+		}
+		out = append(out, Comment{Author: strings.TrimSpace(r.AuthorName), Text: text, Date: formatCanvasTime(r.CreatedAt)})
+	}
+	return out, nil
+}
+
+// RubricCriterion is one row of an essay rubric, with the grader's assessment when known.
+type RubricCriterion struct {
+	ID          string
+	Description string
+	Points      float64
+	Ratings     []RubricRating
+	Assessed    *RubricAssessment
+}
+
+type RubricRating struct {
+	ID          string
+	Description string
+	Points      float64
+}
+
+type RubricAssessment struct {
+	Points   *float64
+	Rating   string // description of the chosen rating
+	Comments string
+}
+
+// parseRubric decodes Canvas rubric criteria (bare array or wrapped in "criteria"/"data")
+// and joins the optional rubric_assessment map onto them.
+func parseRubric(raw, assessment json.RawMessage) []RubricCriterion {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	type rating struct {
+		ID          string  `json:"id"`
+		Description string  `json:"description"`
+		Points      float64 `json:"points"`
+	}
+	type criterion struct {
+		ID              string   `json:"id"`
+		Description     string   `json:"description"`
+		LongDescription string   `json:"long_description"`
+		Points          float64  `json:"points"`
+		Ratings         []rating `json:"ratings"`
+	}
+	var crits []criterion
+	if err := json.Unmarshal(raw, &crits); err != nil {
+		var wrapped struct {
+			Criteria []criterion `json:"criteria"`
+			Data     []criterion `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &wrapped); err != nil {
+			return nil
+		}
+		crits = wrapped.Criteria
+		if len(crits) == 0 {
+			crits = wrapped.Data
+		}
+	}
+	var assessed map[string]struct {
+		Points   *float64 `json:"points"`
+		RatingID string   `json:"rating_id"`
+		Comments string   `json:"comments"`
+	}
+	if len(assessment) > 0 {
+		_ = json.Unmarshal(assessment, &assessed)
+	}
+	var out []RubricCriterion
+	for _, c := range crits {
+		rc := RubricCriterion{ID: c.ID, Description: stripHTML(c.Description), Points: c.Points}
+		if rc.Description == "" {
+			rc.Description = stripHTML(c.LongDescription)
+		}
+		for _, r := range c.Ratings {
+			rc.Ratings = append(rc.Ratings, RubricRating{ID: r.ID, Description: stripHTML(r.Description), Points: r.Points})
+		}
+		if a, ok := assessed[c.ID]; ok {
+			ra := &RubricAssessment{Points: a.Points, Comments: strings.TrimSpace(a.Comments)}
+			for _, r := range rc.Ratings {
+				if r.ID != "" && r.ID == a.RatingID {
+					ra.Rating = r.Description
+				}
+			}
+			rc.Assessed = ra
+		}
+		out = append(out, rc)
+	}
+	return out
+}
+
+// FormatPoints prints a point value without trailing zeros (1, 0.5, 2.25).
+func FormatPoints(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// isEssay reports whether an item is an essay/free-response or file-upload question.
+func isEssay(item QuizItemInner) bool {
+	slug := strings.ToLower(item.InteractionType.Slug)
+	return strings.Contains(slug, "essay") || slug == "file-upload" || strings.EqualFold(item.UserResponseType, "Text") || strings.EqualFold(item.UserResponseType, "RichText")
+}
+
+// essaySubmission reads what a student submitted to an essay or file-upload item: the text,
+// split into paragraphs, and the names of any uploaded files. Canvas records the text as the
+// result value itself or under user_response (or value), as HTML, and uploads as a list of
+// attachments, bare or under attachments or user_response, named by display_name or filename.
+func essaySubmission(raw json.RawMessage) (text, files []string) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return htmlParagraphs(s), nil
+	}
+	var attachments []struct {
+		DisplayName string `json:"display_name"`
+		Filename    string `json:"filename"`
+		Name        string `json:"name"`
+	}
+	if json.Unmarshal(raw, &attachments) == nil {
+		for _, a := range attachments {
+			for _, name := range []string{a.DisplayName, a.Filename, a.Name} {
+				if name = strings.TrimSpace(name); name != "" {
+					files = append(files, name)
+					break
+				}
+			}
+		}
+		return nil, files
+	}
+	var entry map[string]json.RawMessage
+	if json.Unmarshal(raw, &entry) != nil {
+		return nil, nil
+	}
+	for _, key := range []string{"user_response", "value", "attachments"} {
+		if t, f := essaySubmission(entry[key]); len(t) > 0 || len(f) > 0 {
+			text, files = append(text, t...), append(files, f...)
+		}
+	}
+	return text, files
+}
+
+// PassageSpan is one run of a hot-text passage. Selectable runs carry the id used in scored data.
+type PassageSpan struct {
+	Text       string
+	ID         string
+	Selectable bool
+	Correct    bool
+}
+
+type BlankAnswer struct {
+	ID       string
+	Label    string
+	Answer   string
+	Accepted []string // every accepted variation, Answer first; nil when only one is known
+	Rule     string   // how the response was matched, see describeBlankRule
+	Pattern  string   // grading regex for TextRegex blanks
+	Meaning  string   // plain-English reading of Pattern
+	Example  bool     // Answer was generated from Pattern rather than reported by Canvas
+}
+
+// QuizDoc is the normalized document every output format is rendered from.
+type QuizDoc struct {
+	Title         string
+	Questions     []Question
+	ResponsesOnly bool     // the quiz carries no grading (survey); every question shows responses only
+	Practice      bool     // built from the quiz alone (see BuildPracticeDoc); no key, no responses
+	Exam          bool     // a mock exam (see MockExam); its key is a separate document
+	QuestionsOnly bool     // the questions of a SplitKey pair; the key is a separate document
+	ShowResponses bool     // each scored question shows the student's answer next to the key (see CheckResponse)
+	AnnotateKeys  bool     // each question shows its KeyConfidence
+	Reconstructed bool     // built from the results alone (see BuildResultsDoc); no question text
+	Description   []string // quiz instructions, one entry per paragraph
+	Details       []DocDetail
+	Comments      []Comment         // submission-level instructor comments
+	Replay        *AttemptReplay    // from -events; rendered as an appendix
+	Glossary      []GlossaryEntry   // from -glossary; rendered as an appendix
+	Assets        map[string]string // image src or file link, as written, → local copy (see ImageSources, FileLinks)
+	Labels        Labels            // translations of the md, txt and html labels; nil for English
+}
+
+// DocDetail is one labeled line of the document's metadata block, e.g. "Time limit: 60 minutes".
+type DocDetail struct {
+	Label string
+	Value string
+}
+
+// FileLabel is what a filename convention says about a quiz.
+type FileLabel struct {
+	Label string // display form, e.g. "WK12"
+	Slug  string // filename form, e.g. "wk12"
+	Title string // explicit heading from a "title" group, if any
+}
+
+// hasScoringSignal reports whether a result says anything about scoring or correctness, as
+// opposed to only recording what was answered. A score, a points value or the correct flag
+// counts even when Canvas hides the answer key from the value entries.
+func hasScoringSignal(res ResultItem) bool {
+	if res.Score != 0 || res.PointsPossible != 0 || res.Scored.Correct {
+		return true
+	}
+	var mapForm map[string]ResultValueEntry
+	if err := json.Unmarshal(res.Scored.ValueRaw, &mapForm); err == nil {
+		for _, e := range mapForm {
+			if e.ResultScore != nil || e.Correct != nil || e.CorrectAnswer != "" {
+				return true
+			}
+		}
+		return false
+	}
+	return len(deriveCorrectChoiceIDs(res)) > 0
+}
+
+// isUngradedQuiz detects surveys and other ungraded quizzes: every item is worth zero points,
+// or results exist but none of them carries scoring data.
+func isUngradedQuiz(quiz []QuizItem, results []ResultItem) bool {
+	if len(quiz) == 0 {
+		return false
+	}
+	zeroPoints := true
+	for _, q := range quiz {
+		if q.PointsPossible != 0 {
+			zeroPoints = false
+			break
+		}
+	}
+	if zeroPoints {
+		return true
+	}
+	if len(results) == 0 {
+		return false
+	}
+	for _, r := range results {
+		if hasScoringSignal(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildQuizDoc joins quiz items with their results into the normalized document model.
+func BuildQuizDoc(quiz []QuizItem, results []ResultItem, title string) QuizDoc {
+	doc := QuizDoc{Title: title, ResponsesOnly: isUngradedQuiz(quiz, results)}
+
+	sorted := make([]QuizItem, len(quiz))
+	copy(sorted, quiz)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Position != sorted[j].Position {
+			return sorted[i].Position < sorted[j].Position
+		}
+		if sorted[i].QuestionNumber != sorted[j].QuestionNumber {
+			return sorted[i].QuestionNumber < sorted[j].QuestionNumber
+		}
+		return i < j
+	})
+
+	for idx, q := range sorted {
+		var repaired bool
+		q.Item.ItemBody, repaired = RepairHTML(q.Item.ItemBody)
+		// Prefer HTML-aware blank annotation for open entry questions
+		rawQuestion := stripHTML(q.Item.ItemBody)
+		isBlank := len(q.Item.InteractionData.Blanks) > 0
+		questionText := rawQuestion
+		if isBlank {
+			questionText = annotateBlanksFromHTML(q.Item.ItemBody, q.Item.InteractionData.Blanks)
+		}
+		question := Question{Number: idx + 1, ItemID: q.Item.ID, Text: questionText, OpenEntry: isBlank, Group: newQuestionGroup(q.Group), Stimulus: newStimulus(q.Stimulus)}
+		question.Type = questionType(q.Item.InteractionType.Slug, q.Item.InteractionType.Name, q.Item.UserResponseType)
+		question.Slug = q.Item.InteractionType.Slug
+		question.Title = strings.TrimSpace(q.Item.Title)
+		question.Repaired = repaired
+		if !isBlank && richBody(q.Item.ItemBody) {
+			question.BodyHTML = q.Item.ItemBody
+		}
+		for _, b := range []*QuizBank{q.Bank, q.Item.Bank} {
+			if b != nil && question.Bank == "" {
+				question.Bank = strings.TrimSpace(b.Title)
+			}
+		}
+		question.Media = extractMedia(q.Item.ItemBody)
+		if u := q.Item.InteractionData.ImageURL; u != "" && isHotSpotSlug(q.Item.InteractionType.Slug) {
+			question.Media = append(question.Media, Media{Kind: "image", URL: u, Title: "hot spot"})
+		}
+		question.Terms = extractTerms(q.Item.ItemBody)
+		question.Links = extractLinks(q.Item.ItemBody, q.Item.Feedback.Neutral, q.Item.Feedback.Correct, q.Item.Feedback.Incorrect)
+		question.GeneralFeedback = stripHTML(q.Item.Feedback.Neutral)
+		question.CorrectFeedback = stripHTML(q.Item.Feedback.Correct)
+		question.IncorrectFeedback = stripHTML(q.Item.Feedback.Incorrect)
+
+		question.Possible = q.PointsPossible
+		res, err := findResultByID(results, q.Item.ID)
+		if err != nil {
+			logDebug("no result for item", "question", question.Number, "item", q.Item.ID, "slug", question.Slug)
+			question.KeyConfidence = KeyUnknown
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+		question.HasResult = true
+		question.Unanswered = leftBlank(res)
+		if question.Possible == 0 {
+			question.Possible = res.PointsPossible
+		}
+		earned := res.Score
+		question.Earned = &earned
+		question.Comments, err = ParseComments(res.Comments)
+		question.noteUnreadable("the result's comments", err)
+		if c := stripHTML(res.Comment); c != "" {
+			question.Comments = append(question.Comments, Comment{Text: c})
+		}
+		question.Links = append(question.Links, extractLinks(res.Feedback.ItemFeedback.Neutral, res.Feedback.ItemFeedback.Correct, res.Feedback.ItemFeedback.Incorrect)...)
+		if question.GeneralFeedback == "" {
+			question.GeneralFeedback = stripHTML(res.Feedback.ItemFeedback.Neutral)
+		}
+		if question.CorrectFeedback == "" {
+			question.CorrectFeedback = stripHTML(res.Feedback.ItemFeedback.Correct)
+		}
+		if question.IncorrectFeedback == "" {
+			question.IncorrectFeedback = stripHTML(res.Feedback.ItemFeedback.Incorrect)
+		}
+
+		if Logger != nil {
+			Logger.Debug("decoding item", "question", question.Number, "item", q.Item.ID, "slug", question.Slug, "type", question.Type,
+				"choices", q.Item.InteractionData.choiceForm(q.Item.UserResponseType, question.Slug), "value", valueShape(res.Scored.ValueRaw))
+		}
+		// Normalize choices given heterogeneous encodings
+		question.noteUnreadable("interaction_data.choices", q.Item.InteractionData.NormalizeChoices(q.Item.UserResponseType, q.Item.InteractionType.Slug))
+		choices := q.Item.InteractionData.Choices
+
+		if isBlank {
+			// Extract answers for each blank and report with positions
+			var mapForm map[string]ResultValueEntry
+			var rawForm map[string]struct {
+				CorrectAnswer json.RawMessage `json:"correct_answer"`
+			}
+			if len(res.Scored.ValueRaw) > 0 {
+				_ = json.Unmarshal(res.Scored.ValueRaw, &mapForm)
+				_ = json.Unmarshal(res.Scored.ValueRaw, &rawForm)
+			}
+			scoring, err := parseBlankScoring(q.Item.ScoringData)
+			question.noteUnreadable("scoring_data", err)
+			// Word bank and dropdown blanks are answered with choice ids; map them back to text.
+			choiceLabels := map[string]string{}
+			bank := q.Item.InteractionData.WordBank
+			sort.SliceStable(bank, func(i, j int) bool { return bank[i].Position < bank[j].Position })
+			for _, c := range bank {
+				choiceLabels[c.ID] = c.ItemBody
+				question.WordBank = append(question.WordBank, stripHTML(c.ItemBody))
+			}
+			for _, b := range q.Item.InteractionData.Blanks {
+				for _, c := range b.Choices {
+					choiceLabels[c.ID] = c.ItemBody
+				}
+			}
+			for i, b := range q.Item.InteractionData.Blanks {
+				label := fmt.Sprintf("Blank %d", i+1)
+				// Accepted variations: the authored key first, then whatever the result reports.
+				var accepted []string
+				accepted = append(accepted, scoring[b.ID].Accepted...)
+				stated, err := decodeStringList(rawForm[b.ID].CorrectAnswer)
+				question.noteUnreadable("scored_data.value correct_answer of "+label, err)
+				accepted = append(accepted, stated...)
+				pattern := scoring[b.ID].Pattern
+				ans, example, from := "", false, "none"
+				if v, ok := mapForm[b.ID]; ok && v.CorrectAnswer != "" {
+					ans, from = v.CorrectAnswer, "scored_data.value correct_answer"
+				} else if len(accepted) > 0 {
+					ans, from = accepted[0], "scoring_data"
+					if len(scoring[b.ID].Accepted) == 0 {
+						from = "scored_data.value correct_answer list"
+					}
+				} else if pattern != "" && regexExample(pattern) != "" {
+					ans, example, from = regexExample(pattern), true, "scoring_data regex example"
+				} else if ok && v.UserResponse != "" {
+					ans, from = v.UserResponse, "user_response"
+				}
+				logDebug("key source", "question", question.Number, "blank", b.ID, "from", from)
+				if l, ok := choiceLabels[ans]; ok {
+					ans = l
+				}
+				for j, a := range accepted {
+					if l, ok := choiceLabels[a]; ok {
+						accepted[j] = l
+					}
+				}
+				if ans != "" && !example {
+					ans = stripHTML(ans)
+				}
+				blank := BlankAnswer{ID: b.ID, Label: label, Answer: ans, Rule: describeBlankRule(b.AnswerType, scoring[b.ID]), Example: example}
+				if pattern != "" {
+					blank.Pattern = pattern
+					blank.Meaning = describeRegex(pattern)
+				}
+				if ans != "" {
+					seen := map[string]bool{ans: true}
+					for _, a := range accepted {
+						a = stripHTML(a)
+						if a != "" && !seen[a] {
+							seen[a] = true
+							blank.Accepted = append(blank.Accepted, a)
+						}
+					}
+					if len(blank.Accepted) > 0 {
+						blank.Accepted = append([]string{ans}, blank.Accepted...)
+					}
+				}
+				question.Blanks = append(question.Blanks, blank)
+				if v, ok := mapForm[b.ID]; ok && v.UserResponse != "" {
+					question.Responses = append(question.Responses, fmt.Sprintf("%s: %s", label, stripHTML(v.UserResponse)))
+				}
+			}
+			question.Ungraded = doc.ResponsesOnly
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		if isOrderingSlug(q.Item.InteractionType.Slug) {
+			labels := map[string]string{}
+			for _, c := range choices {
+				labels[c.ID] = stripHTML(c.ItemBody)
+				question.Options = append(question.Options, Option{ID: c.ID, Label: labels[c.ID]})
+			}
+			correct, chosen := orderingResponse(res.Scored.ValueRaw)
+			from := "scored_data.value"
+			if key := orderingKey(q.Item.ScoringData); len(key) > 0 {
+				correct, from = key, "scoring_data"
+			} else if len(correct) == 0 {
+				from = "none"
+			}
+			logDebug("key source", "question", question.Number, "from", from)
+			// A list with unknown ids says nothing about the order.
+			toLabels := func(ids []string) []string {
+				var out []string
+				for _, id := range ids {
+					l, ok := labels[id]
+					if !ok {
+						return nil
+					}
+					out = append(out, l)
+				}
+				return out
+			}
+			question.Order, question.ResponseOrder = toLabels(correct), toLabels(chosen)
+			// Formats without an ordering list show the order as the correct answers.
+			question.Answers = append([]string(nil), question.Order...)
+			question.Responses = append([]string(nil), question.ResponseOrder...)
+			question.Multi = true
+			question.Ungraded = doc.ResponsesOnly
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		correctIDs, branch := correctChoiceIDs(res)
+
+		if isHotTextSlug(q.Item.InteractionType.Slug) {
+			passage := q.Item.InteractionData.Passage
+			if passage == "" {
+				passage = q.Item.ItemBody
+			}
+			question.Passage = splitHotText(passage)
+			logDebug("key source", "question", question.Number, "from", keyBranch(branch), "correct", len(correctIDs))
+			for i := range question.Passage {
+				sp := &question.Passage[i]
+				if sp.Selectable && correctIDs[sp.ID] {
+					sp.Correct = true
+					question.Answers = append(question.Answers, sp.Text)
+				}
+			}
+			question.Multi = len(question.Answers) > 1
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		if isNumericSlug(q.Item.InteractionType.Slug) {
+			question.Answers = numericKey(q.Item.ScoringData)
+			if r := numericResponse(res.Scored.ValueRaw); r != "" {
+				question.Responses = []string{r}
+			}
+			question.Multi = len(question.Answers) > 1
+			question.Ungraded = doc.ResponsesOnly
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		if isFormulaSlug(q.Item.InteractionType.Slug) {
+			var answer, response string
+			question.Formula, question.Given, answer, response = formulaAnswer(q.Item.ScoringData, res.Scored.ValueRaw)
+			if answer != "" {
+				question.Answers = []string{answer}
+			}
+			if response != "" {
+				question.Responses = []string{response}
+			}
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		if isEssay(q.Item) {
+			question.Essay = true
+			rubric := q.Item.Rubric
+			if len(rubric) == 0 || string(rubric) == "null" {
+				rubric = res.Rubric
+			}
+			question.Rubric = parseRubric(rubric, res.RubricAssess)
+			question.Submission, question.Files = essaySubmission(res.Scored.ValueRaw)
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		if isMatchingSlug(q.Item.InteractionType.Slug) {
+			key := matchKey(q.Item.ScoringData)
+			chosen, correct := matchResponses(res.Scored.ValueRaw)
+			logDebug("key source", "question", question.Number, "from", keyFrom(len(key), len(correct)))
+			prompts := q.Item.InteractionData.Questions
+			sort.SliceStable(prompts, func(i, j int) bool { return prompts[i].Position < prompts[j].Position })
+			for _, a := range q.Item.InteractionData.Answers {
+				question.MatchAnswers = append(question.MatchAnswers, stripHTML(a))
+			}
+			for _, p := range prompts {
+				answer := key[p.ID]
+				if answer == "" {
+					answer = correct[p.ID]
+				}
+				m := MatchPair{Prompt: stripHTML(p.ItemBody), Answer: stripHTML(answer), Response: stripHTML(chosen[p.ID])}
+				question.Matches = append(question.Matches, m)
+				// Formats without a match table list the pairs as answers.
+				if m.Answer != "" {
+					question.Answers = append(question.Answers, m.Prompt+" → "+m.Answer)
+				}
+				if m.Response != "" {
+					question.Responses = append(question.Responses, m.Prompt+" → "+m.Response)
+				}
+			}
+			question.Multi = true
+			question.Ungraded = doc.ResponsesOnly
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		if isCategorizationSlug(q.Item.InteractionType.Slug) {
+			idat := q.Item.InteractionData
+			// Results may name items by id or by text; ids are mapped to their text.
+			labels := map[string]string{}
+			distractors, err := decodeChoiceSet(idat.Distractors)
+			question.noteUnreadable("interaction_data.distractors", err)
+			for _, c := range distractors {
+				labels[c.ID] = stripHTML(c.ItemBody)
+				question.CategoryItems = append(question.CategoryItems, labels[c.ID])
+			}
+			toLabels := func(ids []string) []string {
+				out := []string{}
+				for _, id := range ids {
+					if l, ok := labels[id]; ok {
+						out = append(out, l)
+					} else {
+						out = append(out, stripHTML(id))
+					}
+				}
+				return out
+			}
+			categories, err := decodeChoiceSet(idat.Categories)
+			question.noteUnreadable("interaction_data.categories", err)
+			if len(idat.CategoryOrder) > 0 {
+				rank := map[string]int{}
+				for i, id := range idat.CategoryOrder {
+					rank[id] = i + 1
+				}
+				sort.SliceStable(categories, func(i, j int) bool { return rank[categories[i].ID] < rank[categories[j].ID] })
+			}
+			key := categoryKey(q.Item.ScoringData)
+			chosen, correct, err := categoryResponses(res.Scored.ValueRaw)
+			question.noteUnreadable("scored_data.value", err)
+			logDebug("key source", "question", question.Number, "from", keyFrom(len(key), len(correct)))
+			for _, c := range categories {
+				members, ok := key[c.ID]
+				if !ok {
+					members, ok = correct[c.ID]
+				}
+				cat := Category{Name: stripHTML(c.ItemBody), Responses: toLabels(chosen[c.ID])}
+				if ok {
+					cat.Members = toLabels(members)
+				}
+				question.Categories = append(question.Categories, cat)
+				// Formats without a category table list each category's items as answers.
+				if cat.Members != nil {
+					question.Answers = append(question.Answers, cat.Name+": "+strings.Join(cat.Members, ", "))
+				}
+				if len(cat.Responses) > 0 {
+					question.Responses = append(question.Responses, cat.Name+": "+strings.Join(cat.Responses, ", "))
+				}
+			}
+			question.Multi = true
+			question.Ungraded = doc.ResponsesOnly
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		if isHotSpotSlug(q.Item.InteractionType.Slug) {
+			click, correct := hotSpotResponse(res.Scored.ValueRaw)
+			key := hotSpotKey(q.Item.ScoringData)
+			logDebug("key source", "question", question.Number, "from", keyFrom(len(key), len(correct)))
+			if len(key) > 0 {
+				correct = key
+			}
+			for _, r := range correct {
+				question.Answers = append(question.Answers, r.String())
+			}
+			if click != nil {
+				question.Responses = []string{click.String()}
+			}
+			question.Multi = len(question.Answers) > 1
+			question.Ungraded = doc.ResponsesOnly
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		if isScaleSlug(q.Item.InteractionType.Slug) {
+			scale := q.Item.InteractionData.Scale
+			if len(scale) == 0 {
+				scale = choices
+			}
+			sort.SliceStable(scale, func(i, j int) bool { return scale[i].Position < scale[j].Position })
+			selected, err := deriveSelectedChoiceIDs(res)
+			question.noteUnreadable("scored_data.value", err)
+			question.Ungraded = true
+			question.Scale = true
+			for _, c := range scale {
+				label := stripHTML(c.ItemBody)
+				question.Options = append(question.Options, Option{ID: c.ID, Label: label, Selected: selected[c.ID]})
+				if selected[c.ID] {
+					question.Responses = append(question.Responses, label)
+				}
+			}
+			doc.Questions = append(doc.Questions, question)
+			continue
+		}
+
+		// Item-level feedback is the authored source; the result copy fills in what the item lacks.
+		feedback, err := decodeAnswerFeedback(q.Item.AnswerFeedback)
+		question.noteUnreadable("answer_feedback", err)
+		resFeedback, err := decodeAnswerFeedback(res.AnswerFeedback)
+		question.noteUnreadable("the result's answer_feedback", err)
+		for id, text := range resFeedback {
+			if feedback == nil {
+				feedback = map[string]string{}
+			}
+			if _, ok := feedback[id]; !ok {
+				feedback[id] = text
+			}
+		}
+		selected, err := deriveSelectedChoiceIDs(res)
+		question.noteUnreadable("scored_data.value", err)
+		logDebug("key source", "question", question.Number, "from", keyBranch(branch), "correct", len(correctIDs), "selected", len(selected))
+		sort.SliceStable(choices, func(i, j int) bool { return choices[i].Position < choices[j].Position })
+		for _, c := range choices {
+			question.Links = append(question.Links, extractLinks(feedback[c.ID])...)
+			label := stripHTML(c.ItemBody)
+			question.Options = append(question.Options, Option{ID: c.ID, Label: label, Correct: correctIDs[c.ID], Selected: selected[c.ID], Feedback: stripHTML(feedback[c.ID])})
+			if correctIDs[c.ID] {
+				question.Answers = append(question.Answers, label)
+			}
+			if selected[c.ID] {
+				question.Responses = append(question.Responses, label)
+			}
+		}
+		question.Ungraded = doc.ResponsesOnly
+		question.Multi = strings.Contains(strings.ToLower(q.Item.UserResponseType), "multipleuuid") || len(question.Answers) > 1
+		partialCredit(&question, q.Item.ScoringAlgorithm)
+		doc.Questions = append(doc.Questions, question)
+	}
+	for i := range doc.Questions {
+		q := &doc.Questions[i]
+		if !q.HasResult {
+			continue
+		}
+		res, _ := findResultByID(results, q.ItemID)
+		for _, item := range sorted {
+			if item.Item.ID == q.ItemID {
+				q.KeyConfidence = keyConfidence(*q, item.Item.ScoringData, res)
+				if q.KeyConfidence == KeyUnknown {
+					q.ResultShape = valueShape(res.Scored.ValueRaw)
+				}
+				break
+			}
+		}
+	}
+	doc.assignContentIDs()
+	return doc
+}
+
+// keyFrom names where a key was read from for -debug, given the size of the authored key
+// in scoring_data and of the one reported in scored_data.value; the authored key wins.
+func keyFrom(authored, reported int) string {
+	switch {
+	case authored > 0:
+		return "scoring_data"
+	case reported > 0:
+		return "scored_data.value"
+	}
+	return "none"
+}
+
+// keyBranch names the scored_data.value branch correctChoiceIDs matched for -debug.
+func keyBranch(branch string) string {
+	if branch == "" {
+		return "none"
+	}
+	return "scored_data.value " + branch
+}
+
+// notice is the line shown under the title of a document that has no answer key or no
+// question text, saying why; it is empty for a regular solutions document.
+func (doc QuizDoc) notice() string {
+	switch {
+	case doc.Exam:
+		return "Mock exam — the answer key is a separate document."
+	case doc.QuestionsOnly:
+		return "Questions only — the answer key is a separate document."
+	case doc.Practice:
+		return "Practice sheet — no results were given, so there is no answer key."
+	case doc.Reconstructed:
+		return "Rebuilt from the results alone — question and choice text are not in them."
+	case doc.ResponsesOnly:
+		return "Ungraded quiz — showing responses only."
+	}
+	return ""
+}
+
+// BuildPracticeDoc builds an un-keyed practice sheet from quiz items alone, for when no
+// results are given: every question lists its choices, word bank or blanks, with no answer,
+// score, response or feedback that could give the key away.
+func BuildPracticeDoc(quiz []QuizItem, title string) QuizDoc {
+	// An empty result per item takes every question through the choice-building paths
+	// without marking anything correct or selected.
+	empty := make([]ResultItem, len(quiz))
+	for i, q := range quiz {
+		empty[i] = ResultItem{ItemID: q.Item.ID}
+	}
+	doc := BuildQuizDoc(quiz, empty, title)
+	doc.ResponsesOnly, doc.Practice = true, true
+	for i := range doc.Questions {
+		doc.Questions[i].hideKey()
+	}
+	return doc
+}
+
+// hideKey removes everything from q that gives its answer away, for a practice sheet or a
+// mock exam: the key, the score and the feedback. It leaves the student's responses, which
+// a practice sheet has none of; see hideResponses.
+func (q *Question) hideKey() {
+	q.Ungraded, q.Earned, q.Answers, q.KeyConfidence, q.ResultShape = true, nil, nil, "", ""
+	q.GeneralFeedback, q.CorrectFeedback, q.IncorrectFeedback, q.Explanation = "", "", "", ""
+	if q.Given != "" {
+		// The values are part of the problem; the formula is the working.
+		q.Text += " (Given " + q.Given + ")"
+	}
+	q.Formula, q.Given = "", ""
+	q.Options = slices.Clone(q.Options)
+	for j := range q.Options {
+		q.Options[j].Correct, q.Options[j].Feedback = false, ""
+	}
+	q.Blanks = slices.Clone(q.Blanks)
+	for j, b := range q.Blanks {
+		q.Blanks[j] = BlankAnswer{ID: b.ID, Label: b.Label}
+	}
+	q.Passage = slices.Clone(q.Passage)
+	for j := range q.Passage {
+		q.Passage[j].Correct = false
+	}
+	q.Matches = slices.Clone(q.Matches)
+	for j := range q.Matches {
+		q.Matches[j].Answer = ""
+	}
+	q.Order = nil
+	q.Categories = slices.Clone(q.Categories)
+	for j := range q.Categories {
+		q.Categories[j].Members = nil
+	}
+}
+
+// hideResponses removes the student's answers, score and comments from q, keeping its key.
+func (q *Question) hideResponses() {
+	q.Responses, q.ResponseOrder, q.Submission, q.Files, q.Comments = nil, nil, nil, nil, nil
+	q.Earned, q.Unanswered, q.Attempts, q.Class = nil, false, nil, nil
+	q.Options = slices.Clone(q.Options)
+	for j := range q.Options {
+		q.Options[j].Selected, q.Options[j].Credit = false, nil
+	}
+	q.Matches = slices.Clone(q.Matches)
+	for j := range q.Matches {
+		q.Matches[j].Response = ""
+	}
+	q.Categories = slices.Clone(q.Categories)
+	for j := range q.Categories {
+		q.Categories[j].Responses = nil
+	}
+}
+
+// hasKey reports whether q has an answer to put in a mock exam's key; essays, graded by
+// hand, count as having one.
+func (q Question) hasKey() bool {
+	if q.Essay || len(q.Answers) > 0 || len(q.Order) > 0 {
+		return true
+	}
+	for _, b := range q.Blanks {
+		if b.Answer != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// MockExam draws n questions at random from bank, the questions of past quizzes (see
+// BuildBank), for a practice exam. Only questions with a key are drawn; surveys and questions
+// whose answer was never known are left out, and n is capped at how many remain. The options
+// of each question, and the answers, items or word bank it offers, are shuffled. The exam is
+// returned without its key, responses or feedback; key holds the same questions, in the same
+// order and with the same option order, with their answers and without the student's.
+func MockExam(bank QuizDoc, n int, rng *rand.Rand, title string) (exam, key QuizDoc) {
+	var pool []Question
+	for _, q := range bank.Questions {
+		if q.hasKey() {
+			pool = append(pool, q)
+		}
+	}
+	n = max(0, min(n, len(pool)))
+	key = QuizDoc{Title: title + " — Answer Key"}
+	exam = QuizDoc{Title: title, Practice: true, ResponsesOnly: true, Exam: true}
+	for i, p := range rng.Perm(len(pool))[:n] {
+		q := pool[p]
+		q.Number, q.Group = i+1, nil
+		q.hideResponses()
+		if !q.Scale && q.Type != "true/false" {
+			// For an ordering question the key is Order, so this scrambles the steps.
+			q.Options = slices.Clone(q.Options)
+			rng.Shuffle(len(q.Options), func(a, b int) { q.Options[a], q.Options[b] = q.Options[b], q.Options[a] })
+		}
+		for _, list := range []*[]string{&q.MatchAnswers, &q.CategoryItems, &q.WordBank} {
+			*list = slices.Clone(*list)
+			rng.Shuffle(len(*list), func(a, b int) { (*list)[a], (*list)[b] = (*list)[b], (*list)[a] })
+		}
+		key.Questions = append(key.Questions, q)
+		q.hideKey()
+		q.Quizzes, q.Tags = nil, nil
+		exam.Questions = append(exam.Questions, q)
+	}
+	return exam, key
+}
+
+// SplitKey splits doc in two for self-testing: questions, with neither the key, the student's
+// responses nor feedback, and key, the same questions with their answers but without the
+// student's responses. Both keep doc's numbering, grouping and details; the submission's
+// comments and attempt replay are dropped, and the glossary, which quotes answers, goes
+// with the key.
+func SplitKey(doc QuizDoc) (questions, key QuizDoc) {
+	key = doc
+	key.Comments, key.Replay, key.ShowResponses = nil, nil, false
+	key.Questions = make([]Question, len(doc.Questions))
+	questions = key
+	questions.Practice, questions.ResponsesOnly, questions.QuestionsOnly = true, true, true
+	questions.AnnotateKeys, questions.Glossary = false, nil
+	questions.Questions = make([]Question, len(doc.Questions))
+	for i, q := range doc.Questions {
+		q.hideResponses()
+		key.Questions[i] = q
+		q.hideKey()
+		questions.Questions[i] = q
+	}
+	return questions, key
+}
+
+// BuildResultsDoc reconstructs what it can of a quiz from results alone, for when no quiz
+// JSON is given. Results carry no question or choice text, so questions are named by item
+// id and choices by choice id, in the order the result lists them. Scores, the key, the
+// student's picks, recorded blank answers, comments and feedback are kept.
+func BuildResultsDoc(results []ResultItem, title string) QuizDoc {
+	sorted := make([]ResultItem, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Position < sorted[j].Position })
+	doc := QuizDoc{Title: title, Reconstructed: true}
+	for idx, res := range sorted {
+		q := Question{Number: idx + 1, ItemID: res.ItemID, Text: "Item " + res.ItemID, HasResult: true, Possible: res.PointsPossible, Unanswered: leftBlank(res)}
+		earned := res.Score
+		q.Earned = &earned
+		comments, err := ParseComments(res.Comments)
+		q.Comments = comments
+		q.noteUnreadable("the result's comments", err)
+		if c := stripHTML(res.Comment); c != "" {
+			q.Comments = append(q.Comments, Comment{Text: c})
+		}
+		q.GeneralFeedback = stripHTML(res.Feedback.ItemFeedback.Neutral)
+		q.CorrectFeedback = stripHTML(res.Feedback.ItemFeedback.Correct)
+		q.IncorrectFeedback = stripHTML(res.Feedback.ItemFeedback.Incorrect)
+
+		var mapForm map[string]ResultValueEntry
+		_ = json.Unmarshal(res.Scored.ValueRaw, &mapForm)
+		keys := objectKeys(res.Scored.ValueRaw)
+		for _, id := range keys {
+			if e := mapForm[id]; e.CorrectAnswer != "" || e.UserResponse != "" {
+				q.OpenEntry = true
+			}
+		}
+		if q.OpenEntry {
+			for i, id := range keys {
+				e := mapForm[id]
+				label := fmt.Sprintf("Blank %d", i+1)
+				q.Blanks = append(q.Blanks, BlankAnswer{ID: id, Label: label, Answer: stripHTML(e.CorrectAnswer)})
+				if e.UserResponse != "" {
+					q.Responses = append(q.Responses, fmt.Sprintf("%s: %s", label, stripHTML(e.UserResponse)))
+				}
+			}
+			doc.Questions = append(doc.Questions, q)
+			continue
+		}
+		correct, branch := correctChoiceIDs(res)
+		selected, err := deriveSelectedChoiceIDs(res)
+		q.noteUnreadable("scored_data.value", err)
+		logDebug("key source", "question", q.Number, "item", res.ItemID, "from", keyBranch(branch), "correct", len(correct), "selected", len(selected))
+		ids := keys
+		if len(ids) == 0 {
+			ids, _ = decodeStringList(res.Scored.ValueRaw) // an unreadable value was noted just above
+		}
+		listed := map[string]bool{}
+		for _, id := range ids {
+			listed[id] = true
+		}
+		var unlisted []string
+		for id := range correct {
+			if !listed[id] {
+				unlisted = append(unlisted, id)
+			}
+		}
+		sort.Strings(unlisted)
+		ids = append(ids, unlisted...)
+		feedback, err := decodeAnswerFeedback(res.AnswerFeedback)
+		q.noteUnreadable("the result's answer_feedback", err)
+		for _, id := range ids {
+			o := Option{ID: id, Label: "Choice " + id, Correct: correct[id], Selected: selected[id], Feedback: stripHTML(feedback[id])}
+			q.Options = append(q.Options, o)
+			if o.Correct {
+				q.Answers = append(q.Answers, o.Label)
+			}
+		}
+		q.Multi = len(q.Answers) > 1
+		q.KeyConfidence = keyConfidence(q, nil, res)
+		if q.KeyConfidence == KeyUnknown {
+			q.ResultShape = valueShape(res.Scored.ValueRaw)
+		}
+		doc.Questions = append(doc.Questions, q)
+	}
+	doc.assignContentIDs()
+	return doc
+}
+
+// Key confidence levels (see Question.KeyConfidence).
+const (
+	KeyConfirmed = "confirmed"           // authored scoring data, or results that state the answer
+	KeyInferred  = "inferred from score" // only a response that scored full points marks it
+	KeyUnknown   = "unknown"             // no key, or only the student's own answer
+)
+
+// Diagnostic is a problem that kept a question from being fully extracted (see Diagnose).
+type Diagnostic struct {
+	Number  int
+	ItemID  string
+	Problem string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("question %d (item %s): %s", d.Number, d.ItemID, d.Problem)
+}
+
+// Diagnose lists what the document could not extract, question by question: an interaction
+// type none of the question paths knows (it is read as a choice question), an item with no
+// result, a part of the item or result that could not be decoded, and a graded question
+// whose result yielded no answer key, which renders as "(answer unavailable)". A practice
+// sheet has no results, so only unknown types and undecodable items count.
+func Diagnose(doc QuizDoc) []Diagnostic {
+	var out []Diagnostic
+	for _, q := range doc.Questions {
+		add := func(format string, args ...any) {
+			out = append(out, Diagnostic{Number: q.Number, ItemID: q.ItemID, Problem: fmt.Sprintf(format, args...)})
+		}
+		if q.Slug != "" && questionTypeNames[q.Slug] == "" && !isHotTextSlug(q.Slug) && !isScaleSlug(q.Slug) && q.Type != "true/false" {
+			add("unrecognized interaction type %q, read as a choice question", q.Slug)
+		}
+		for _, u := range q.Unreadable {
+			add("unreadable %s", u)
+		}
+		switch {
+		case doc.Practice:
+		case !q.HasResult:
+			add("no result for this item; is its item_id in the results?")
+		case q.KeyConfidence != KeyUnknown:
+		case q.ResultShape == "":
+			add("no answer key: no answer is marked correct")
+		default:
+			add("no answer key: scored_data.value is %s, which matches none of its choices or blanks", q.ResultShape)
+		}
+	}
+	return out
+}
+
+// noteUnreadable records err, from decoding part of the item or result, for Diagnose.
+func (q *Question) noteUnreadable(part string, err error) {
+	if err != nil {
+		q.Unreadable = append(q.Unreadable, part+": "+err.Error())
+	}
+}
+
+// valueShape describes a result's scored_data.value for Diagnose, e.g. "an object of 3
+// entries" or "a list of 2 strings".
+func valueShape(raw json.RawMessage) string {
+	var v any
+	if len(bytes.TrimSpace(raw)) == 0 || json.Unmarshal(raw, &v) != nil {
+		return "missing"
+	}
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if t == "" {
+			return "an empty string"
+		}
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "true/false"
+	case map[string]any:
+		if len(t) == 0 {
+			return "an empty object"
+		}
+		if len(t) == 1 {
+			return "an object of 1 entry"
+		}
+		return fmt.Sprintf("an object of %d entries", len(t))
+	case []any:
+		if len(t) == 0 {
+			return "an empty list"
+		}
+		kind := ""
+		for _, e := range t {
+			k := "values"
+			switch e.(type) {
+			case string:
+				k = "strings"
+			case float64:
+				k = "numbers"
+			case map[string]any:
+				k = "objects"
+			}
+			if kind != "" && kind != k {
+				kind = "values"
+				break
+			}
+			kind = k
+		}
+		if len(t) == 1 {
+			kind = strings.TrimSuffix(kind, "s")
+		}
+		return fmt.Sprintf("a list of %d %s", len(t), kind)
+	}
+	return "an unexpected value"
+}
+
+// AnnotateKeyConfidence shows each question's KeyConfidence and adds a detail listing the
+// questions whose key is inferred or unknown, the ones worth double-checking.
+func (doc *QuizDoc) AnnotateKeyConfidence() {
+	doc.AnnotateKeys = true
+	var check []string
+	for _, q := range doc.Questions {
+		if q.KeyConfidence == KeyInferred || q.KeyConfidence == KeyUnknown {
+			check = append(check, strconv.Itoa(q.Number))
+		}
+	}
+	if len(check) > 0 {
+		value := "question " + check[0]
+		if len(check) > 1 {
+			value = "questions " + strings.Join(check, ", ")
+		}
+		doc.Details = append(doc.Details, DocDetail{Label: "Keys to double-check", Value: value})
+	}
+}
+
+// keyConfidence rates where the key of q, built from an item with scoringData and its
+// result res, came from. An authored key, a correct_answer or correct: true is confirmed;
+// a result_score of 1 on the student's response only infers it, since the response may
+// have been scored for something else. A blank answered with nothing but the student's
+// response is unknown. Essays and ungraded questions have no key to rate.
+func keyConfidence(q Question, scoringData json.RawMessage, res ResultItem) string {
+	if q.Essay || q.Ungraded {
+		return ""
+	}
+	var entries map[string]ResultValueEntry
+	if json.Unmarshal(res.Scored.ValueRaw, &entries) != nil {
+		entries = nil
+	}
+	stated := func(e ResultValueEntry) bool { return e.CorrectAnswer != "" || e.Correct != nil && *e.Correct }
+	scored := func(e ResultValueEntry) bool { return e.ResultScore != nil && *e.ResultScore == 1 }
+	listed := func(raw json.RawMessage) bool {
+		answers, _ := decodeStringList(raw) // an unreadable list was noted while decoding the item
+		return len(answers) > 0
+	}
+	if len(q.Blanks) > 0 {
+		scoring, _ := parseBlankScoring(scoringData) // unreadable parts were noted while decoding the item
+		var rawForm map[string]struct {
+			CorrectAnswer json.RawMessage `json:"correct_answer"`
+		}
+		_ = json.Unmarshal(res.Scored.ValueRaw, &rawForm)
+		level := KeyConfirmed
+		for _, b := range q.Blanks {
+			e := entries[b.ID]
+			switch {
+			case b.Answer == "":
+				return KeyUnknown
+			case len(scoring[b.ID].Accepted) > 0 || scoring[b.ID].Pattern != "" || listed(rawForm[b.ID].CorrectAnswer) || stated(e):
+			case scored(e):
+				level = KeyInferred
+			default:
+				return KeyUnknown
+			}
+		}
+		return level
+	}
+	switch {
+	case len(q.Answers) == 0:
+		return KeyUnknown
+	case len(q.Order) > 0 && len(orderingKey(scoringData)) > 0,
+		len(q.Matches) > 0 && len(matchKey(scoringData)) > 0,
+		len(q.Categories) > 0 && len(categoryKey(scoringData)) > 0,
+		isNumericSlug(q.Slug) && len(numericKey(scoringData)) > 0,
+		isFormulaSlug(q.Slug),
+		isHotSpotSlug(q.Slug) && len(hotSpotKey(scoringData)) > 0:
+		return KeyConfirmed
+	case entries == nil:
+		// Ordering rows and hot spot entries carry the key; rows of choices only have scores.
+		if len(q.Order) > 0 || isHotSpotSlug(q.Slug) {
+			return KeyConfirmed
+		}
+		return KeyInferred
+	}
+	level := KeyUnknown
+	for _, e := range entries {
+		switch {
+		case stated(e):
+			if level == KeyUnknown {
+				level = KeyConfirmed
+			}
+		case scored(e):
+			level = KeyInferred
+		}
+	}
+	return level
+}
+
+// Summary is the attempt on one line, e.g. "Attempt 2: Response Time (1 / 1)".
+func (a AttemptAnswer) Summary() string {
+	return fmt.Sprintf("%s: %s (%s)", a.Attempt, a.Answer, a.Score)
+}
+
+// MergeAttempts builds one document from several results files for the same quiz, given in
+// attempt order and named by names. Each question shows the last attempt that has a result
+// for it, so a regrade replaces what it regraded, and lists every attempt's answer and score
+// in Attempts. Where that attempt does not reveal the key, the latest attempt that does
+// supplies it, which makes the document's key the consolidated final one.
+func MergeAttempts(quiz []QuizItem, attempts [][]ResultItem, names []string, title string) QuizDoc {
+	labels := attemptLabels(attempts, names)
+	docs := make([]QuizDoc, len(attempts))
+	for i, results := range attempts {
+		docs[i] = BuildQuizDoc(quiz, results, title)
+	}
+	doc := docs[len(docs)-1]
+	var totals []string
+	for i, d := range docs {
+		var earned, possible float64
+		for _, q := range d.Questions {
+			if q.Earned != nil {
+				earned += *q.Earned
+				possible += q.Possible
+			}
+		}
+		totals = append(totals, fmt.Sprintf("%s: %s / %s", labels[i], FormatPoints(RoundTo(earned, 2)), FormatPoints(possible)))
+	}
+	doc.Details = append(doc.Details, DocDetail{Label: "Attempts", Value: strings.Join(totals, ", ")})
+	var fromResponses []string
+	for j := range doc.Questions {
+		latest := -1
+		for i, d := range docs {
+			q := d.Questions[j]
+			if !q.HasResult {
+				continue
+			}
+			latest = i
+			answer := strings.Join(q.Responses, ", ")
+			if answer == "" {
+				answer = "(no response)"
+			}
+			score := "—"
+			if q.Earned != nil {
+				score = FormatPoints(RoundTo(*q.Earned, 2)) + " / " + FormatPoints(q.Possible)
+			}
+			doc.Questions[j].Attempts = append(doc.Questions[j].Attempts, AttemptAnswer{Attempt: labels[i], Answer: answer, Score: score})
+		}
+		if latest < 0 {
+			continue
+		}
+		attempts := doc.Questions[j].Attempts
+		final := docs[latest].Questions[j]
+		for i := latest - 1; i >= 0 && len(final.Answers) == 0; i-- {
+			fillKey(&final, docs[i].Questions[j])
+		}
+		// When no attempt reveals the key, a response that scored full points is known to be right.
+		for i := latest; i >= 0; i-- {
+			if c, ok := docs[i].Questions[j].CheckResponse(); ok && c.Correct && docs[i].Questions[j].HasResult {
+				if keyFromResponse(&final, docs[i].Questions[j]) {
+					final.KeyConfidence = KeyInferred
+					fromResponses = append(fromResponses, strconv.Itoa(final.Number))
+				}
+				break
+			}
+		}
+		final.Attempts = attempts
+		doc.Questions[j] = final
+	}
+	if len(fromResponses) > 0 {
+		value := "question " + fromResponses[0]
+		if len(fromResponses) > 1 {
+			value = "questions " + strings.Join(fromResponses, ", ")
+		}
+		doc.Details = append(doc.Details, DocDetail{Label: "Key from full-score answers", Value: value})
+	}
+	return doc
+}
+
+// keyFromResponse fills in the key of q from from, an attempt at it that scored full points:
+// the chosen options when q has no key, and the text of each blank whose answer is missing
+// or only q's own response, which BuildQuizDoc falls back to when the key is hidden. ok
+// reports whether anything changed.
+func keyFromResponse(q *Question, from Question) (ok bool) {
+	switch {
+	case len(q.Blanks) > 0:
+		own, full := blankResponses(*q), blankResponses(from)
+		for i, b := range q.Blanks {
+			revealed := len(b.Accepted) > 0 || b.Pattern != ""
+			if r := full[b.Label]; r != "" && r != b.Answer && !revealed && (b.Answer == "" || b.Answer == own[b.Label]) {
+				q.Blanks[i].Answer, ok = r, true
+			}
+		}
+		return ok
+	case len(q.Answers) == 0 && len(q.Options) > 0 && len(q.Order) == 0 && len(from.Responses) > 0:
+		chosen := map[string]bool{}
+		for _, o := range from.Options {
+			chosen[o.ID] = o.Selected
+		}
+		for i := range q.Options {
+			q.Options[i].Correct = chosen[q.Options[i].ID]
+		}
+		q.Answers = append([]string(nil), from.Responses...)
+		return true
+	}
+	return false
+}
+
+// blankResponses maps a blank question's labels to the student's responses.
+func blankResponses(q Question) map[string]string {
+	responses := map[string]string{}
+	for _, r := range q.Responses {
+		if label, text, found := strings.Cut(r, ": "); found {
+			responses[label] = text
+		}
+	}
+	return responses
+}
+
+// fillKey copies the answer key of from, an earlier attempt at the same question, into q,
+// which lacks one: correct choices by id, the correct answers and missing blank answers.
+func fillKey(q *Question, from Question) {
+	correct := map[string]bool{}
+	for _, o := range from.Options {
+		correct[o.ID] = o.Correct
+	}
+	for i := range q.Options {
+		q.Options[i].Correct = correct[q.Options[i].ID]
+	}
+	q.Answers = append([]string(nil), from.Answers...)
+	for i := range q.Blanks {
+		if q.Blanks[i].Answer == "" && i < len(from.Blanks) {
+			q.Blanks[i].Answer = from.Blanks[i].Answer
+		}
+	}
+	if from.Given != "" {
+		q.Formula, q.Given = from.Formula, from.Given // the key is for the values it was computed from
+	}
+	q.KeyConfidence, q.ResultShape = from.KeyConfidence, from.ResultShape
+}
+
+// BuildBank merges the questions of several quizzes into one question bank, in the order
+// they first appear. Canvas draws quizzes from shared item banks, so a question often comes
+// back in later weeks; copies are recognized by their ContentID and kept once, with the
+// labels of the quizzes they appeared in (Quizzes). The known answers of the copies are
+// pooled: a copy with an answer key stands in for one without, and each blank gains the
+// accepted answers of every copy. Questions with results are tagged with their difficulty
+// for the student (see difficulty), e.g. "difficulty:hard".
+func BuildBank(docs []QuizDoc, labels []string, title string) QuizDoc {
+	bank := QuizDoc{Title: title}
+	index := map[string]int{}
+	record := map[string][]bool{} // full points or not, in quiz order
+	total := 0
+	for i, doc := range docs {
+		for _, q := range doc.Questions {
+			total++
+			if c, ok := q.CheckResponse(); ok {
+				record[q.ContentID] = append(record[q.ContentID], c.Correct)
+			}
+			j, seen := index[q.ContentID]
+			if seen {
+				bank.Questions[j] = poolAnswers(bank.Questions[j], q)
+			} else {
+				j = len(bank.Questions)
+				index[q.ContentID] = j
+				q.Quizzes = nil
+				bank.Questions = append(bank.Questions, q)
+			}
+			if b := &bank.Questions[j]; len(b.Quizzes) == 0 || b.Quizzes[len(b.Quizzes)-1] != labels[i] {
+				b.Quizzes = append(b.Quizzes, labels[i])
+			}
+		}
+	}
+	counts := map[string]int{}
+	for i := range bank.Questions {
+		q := &bank.Questions[i]
+		q.Number = i + 1
+		level := difficulty(record[q.ContentID])
+		if level == "" {
+			counts["unscored"]++
+			continue
+		}
+		counts[level]++
+		q.Tags = append(q.Tags, "difficulty:"+level)
+	}
+	bank.Details = []DocDetail{
+		{Label: "Quizzes", Value: strings.Join(labels, ", ")},
+		{Label: "Questions", Value: fmt.Sprintf("%d unique of %d", len(bank.Questions), total)},
+	}
+	var parts []string
+	for _, level := range append(difficultyLevels, "unscored") {
+		if counts[level] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", level, counts[level]))
+		}
+	}
+	if counts["unscored"] < len(bank.Questions) {
+		bank.Details = append(bank.Details, DocDetail{Label: "Difficulty", Value: strings.Join(parts, ", ")})
+	}
+	return bank
+}
+
+// difficultyLevels are the difficulty tags of BuildBank, easiest first.
+var difficultyLevels = []string{"easy", "medium", "hard"}
+
+// difficulty rates a question from the student's record on it, oldest first: easy when
+// answered for full points the first time, medium when only later, hard when never. It is
+// "" without a record.
+func difficulty(record []bool) string {
+	switch {
+	case len(record) == 0:
+		return ""
+	case record[0]:
+		return "easy"
+	case slices.Contains(record, true):
+		return "medium"
+	}
+	return "hard"
+}
+
+// questionDifficulty is the level of q's difficulty tag, or "" when it has none.
+func questionDifficulty(q Question) string {
+	for _, t := range q.Tags {
+		if level, ok := strings.CutPrefix(t, "difficulty:"); ok {
+			return level
+		}
+	}
+	return ""
+}
+
+// FilterDifficulty keeps only the questions of a bank (see BuildBank) rated at one of levels.
+// Question numbers are left as-is so they still match the full bank.
+func (doc *QuizDoc) FilterDifficulty(levels []string) {
+	if len(levels) == 0 {
+		return
+	}
+	var kept []Question
+	for _, q := range doc.Questions {
+		if slices.Contains(levels, questionDifficulty(q)) {
+			kept = append(kept, q)
+		}
+	}
+	doc.Questions = kept
+}
+
+// SortByDifficulty orders the questions of a bank hardest first, keeping their order within
+// a level; questions without a rating come last.
+func (doc *QuizDoc) SortByDifficulty() {
+	rank := func(q Question) int {
+		if i := slices.Index(difficultyLevels, questionDifficulty(q)); i >= 0 {
+			return len(difficultyLevels) - 1 - i
+		}
+		return len(difficultyLevels)
+	}
+	sort.SliceStable(doc.Questions, func(i, j int) bool { return rank(doc.Questions[i]) < rank(doc.Questions[j]) })
+}
+
+// poolAnswers combines q, a question of the bank, with other, a later copy of it.
+func poolAnswers(q, other Question) Question {
+	if len(q.Answers) == 0 && len(other.Answers) > 0 {
+		quizzes := q.Quizzes
+		q, other = other, q
+		q.Quizzes = quizzes
+	}
+	for i := range q.Blanks {
+		if i >= len(other.Blanks) {
+			break
+		}
+		b, o := &q.Blanks[i], other.Blanks[i]
+		if b.Answer == "" {
+			b.Answer = o.Answer
+		}
+		var accepted []string
+		for _, list := range [][]string{{b.Answer}, b.Accepted, {o.Answer}, o.Accepted} {
+			for _, a := range list {
+				if a != "" && !slices.Contains(accepted, a) {
+					accepted = append(accepted, a)
+				}
+			}
+		}
+		if len(accepted) > 1 {
+			b.Accepted = accepted
+		}
+	}
+	if q.Explanation == "" {
+		q.Explanation = other.Explanation
+	}
+	if q.GeneralFeedback == "" {
+		q.GeneralFeedback = other.GeneralFeedback
+	}
+	if q.CorrectFeedback == "" {
+		q.CorrectFeedback = other.CorrectFeedback
+	}
+	if q.IncorrectFeedback == "" {
+		q.IncorrectFeedback = other.IncorrectFeedback
+	}
+	return q
+}
+
+// attemptLabels names the results files of MergeAttempts: "Attempt N" from the attempt number
+// the results record, or the file names when the numbers are missing or repeat (regrades of
+// one attempt).
+func attemptLabels(attempts [][]ResultItem, names []string) []string {
+	labels := make([]string, len(attempts))
+	seen := map[int]bool{}
+	for i, results := range attempts {
+		n := 0
+		if len(results) > 0 {
+			n = results[0].Attempt
+		}
+		if n == 0 || seen[n] {
+			return names
+		}
+		seen[n] = true
+		labels[i] = fmt.Sprintf("Attempt %d", n)
+	}
+	return labels
+}
+
+// objectKeys returns the keys of a JSON object in document order, or nil if raw is not an
+// object.
+func objectKeys(raw json.RawMessage) []string {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		keys = append(keys, t.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil
+		}
+	}
+	return keys
+}
+
+// ExplainConfig describes where question explanations come from, in priority order.
+type ExplainConfig struct {
+	Sources []string          // subset of explanationSources; the first one yielding text wins
+	Notes   map[string]string // user notes keyed by item id or question number
+	LLM     LLM               // asked for the "llm" source; nil skips it
+}
+
+// LLM answers one prompt, typically by running an external model. Callers decide how it runs;
+// the library only builds the prompts and reads the replies.
+type LLM func(prompt string) (string, error)
+
+// llmPrompt builds the prompt sent to the LLM for one question.
+func llmPrompt(q Question) string {
+	var sb strings.Builder
+	sb.WriteString("Explain briefly, in two or three sentences, why the answer to this quiz question is correct.\n\n")
+	sb.WriteString("Question: " + q.Text + "\n")
+	for _, o := range q.Options {
+		sb.WriteString("Option: " + o.Label + "\n")
+	}
+	for _, a := range q.Answers {
+		sb.WriteString("Correct answer: " + a + "\n")
+	}
+	for _, b := range q.Blanks {
+		if b.Answer != "" {
+			sb.WriteString(fmt.Sprintf("Correct answer (%s): %s\n", b.Label, b.Answer))
+		}
+	}
+	return sb.String()
+}
+
+// missed reports whether q was answered without full points, when Canvas shows the item's
+// incorrect feedback.
+func (q Question) missed() bool {
+	return q.Earned != nil && !q.Ungraded && *q.Earned < q.Possible
+}
+
+// ApplyExplanations fills Question.Explanation from the first configured source with content.
+// The "incorrect" source only applies to missed questions (see missed).
+// LLM failures are returned as warnings and skipped so one bad call doesn't abort the document.
+func ApplyExplanations(doc *QuizDoc, cfg ExplainConfig) []string {
+	var warnings []string
+	for i := range doc.Questions {
+		q := &doc.Questions[i]
+		for _, src := range cfg.Sources {
+			text := ""
+			switch src {
+			case "general":
+				text = q.GeneralFeedback
+			case "correct":
+				text = q.CorrectFeedback
+			case "incorrect":
+				if q.missed() {
+					text = q.IncorrectFeedback
+				}
+			case "notes":
+				if n, ok := cfg.Notes[q.ItemID]; ok {
+					text = n
+				} else {
+					text = cfg.Notes[fmt.Sprint(q.Number)]
+				}
+			case "llm":
+				if cfg.LLM == nil {
+					continue
+				}
+				out, err := cfg.LLM(llmPrompt(*q))
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("llm explanation failed for question %d: %v", q.Number, err))
+					continue
+				}
+				text = out
+			}
+			if text = strings.TrimSpace(text); text != "" {
+				q.Explanation = text
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// bloomLevels are the Bloom's taxonomy levels questions are classified into, lowest first.
+var bloomLevels = []string{"remember", "understand", "apply", "analyze"}
+
+// bloomKeywords are the cue words and phrases of each level, matched as whole words in the
+// question stem. Higher levels are checked first, so "explain why ... compare" is analyze.
+var bloomKeywords = map[string][]string{
+	"analyze":    {"analyze", "analyse", "compare", "contrast", "differentiate", "distinguish", "examine", "diagnose", "infer", "most likely cause", "root cause", "trade-off", "tradeoff", "best explains", "why would"},
+	"apply":      {"calculate", "compute", "solve", "apply", "implement", "demonstrate", "predict", "estimate", "what is the output", "what will", "how many", "given", "best suited", "most appropriate", "should you use", "would you use", "scenario"},
+	"understand": {"explain", "describe", "summarize", "summarise", "interpret", "classify", "illustrate", "example of", "purpose of", "best describes", "best represents", "represents", "means", "why"},
+	"remember":   {"define", "definition", "list", "name", "identify", "recall", "state", "which of the following is", "which of the following are", "what is", "what are", "who", "when", "true or false", "used for", "used to", "refers to", "is called", "known as"},
+}
+
+var bloomPatterns = func() map[string]*regexp.Regexp {
+	out := map[string]*regexp.Regexp{}
+	for level, words := range bloomKeywords {
+		quoted := make([]string, len(words))
+		for i, w := range words {
+			quoted[i] = regexp.QuoteMeta(w)
+		}
+		out[level] = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return out
+}()
+
+// classifyBloom returns the Bloom level cued by the question stem, or "" when no keyword
+// matches.
+func classifyBloom(text string) string {
+	for i := len(bloomLevels) - 1; i >= 0; i-- {
+		if bloomPatterns[bloomLevels[i]].MatchString(text) {
+			return bloomLevels[i]
+		}
+	}
+	return ""
+}
+
+// bloomFromLLM asks llm for a level and takes the first level named in its reply.
+func bloomFromLLM(llm LLM, q Question) (string, error) {
+	prompt := "Classify this quiz question into one Bloom's taxonomy level: remember, understand, apply or analyze. Reply with the level only.\n\n" + llmPrompt(q)
+	out, err := llm(prompt)
+	if err != nil {
+		return "", err
+	}
+	reply := strings.ToLower(out)
+	best, at := "", -1
+	for _, level := range bloomLevels {
+		if i := strings.Index(reply, level); i >= 0 && (at < 0 || i < at) {
+			best, at = level, i
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no Bloom level in reply %q", out)
+	}
+	return best, nil
+}
+
+// ApplyBloom tags every question with its Bloom level ("bloom:apply") and adds the level
+// distribution to the document details. mode is "keywords", or "llm" to ask llm about
+// questions the keywords leave unclassified. Failed LLM calls are returned as warnings.
+func (doc *QuizDoc) ApplyBloom(mode string, llm LLM) []string {
+	var warnings []string
+	counts := map[string]int{}
+	for i := range doc.Questions {
+		q := &doc.Questions[i]
+		level := classifyBloom(q.Text)
+		if level == "" && mode == "llm" && llm != nil {
+			var err error
+			if level, err = bloomFromLLM(llm, *q); err != nil {
+				warnings = append(warnings, fmt.Sprintf("llm Bloom classification failed for question %d: %v", q.Number, err))
+			}
+		}
+		if level == "" {
+			counts["unclassified"]++
+			continue
+		}
+		counts[level]++
+		q.Tags = append(q.Tags, "bloom:"+level)
+	}
+	var parts []string
+	for _, level := range append(bloomLevels, "unclassified") {
+		if counts[level] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", level, counts[level]))
+		}
+	}
+	if len(parts) > 0 {
+		doc.Details = append(doc.Details, DocDetail{Label: "Bloom's levels", Value: strings.Join(parts, ", ")})
+	}
+	return warnings
+}
+
+// ApplyMeta copies quiz-level metadata into the document.
+func (doc *QuizDoc) ApplyMeta(meta QuizMeta) {
+	desc := meta.Instructions
+	if strings.TrimSpace(desc) == "" {
+		desc = meta.Description
+	}
+	doc.Description = htmlParagraphs(desc)
+	doc.Details = append(doc.Details, scheduleDetails(meta)...)
+	doc.Details = append(doc.Details, examConditions(meta)...)
+}
+
+// QuizInfo is what the inputs tell about the quiz as a whole and the attempt the document
+// is for. Zero fields are left out of the metadata block.
+type QuizInfo struct {
+	Title       string  // quiz title in Canvas; shown when the heading doesn't already hold it
+	Points      float64 // total points possible
+	Attempt     int     // attempt number
+	SubmittedAt string  // Canvas ISO-8601 timestamp
+}
+
+// ApplyQuizInfo adds info to the metadata block, ahead of the lines already there: the quiz
+// title, points possible, attempt number and submission time.
+func (doc *QuizDoc) ApplyQuizInfo(info QuizInfo) {
+	var out []DocDetail
+	if t := strings.TrimSpace(info.Title); t != "" && !strings.Contains(doc.Title, t) {
+		out = append(out, DocDetail{"Quiz", t})
+	}
+	if info.Points > 0 {
+		out = append(out, DocDetail{"Points possible", FormatPoints(RoundTo(info.Points, 2))})
+	}
+	if info.Attempt > 0 {
+		out = append(out, DocDetail{"Attempt", strconv.Itoa(info.Attempt)})
+	}
+	if at := formatCanvasTime(info.SubmittedAt); at != "" {
+		out = append(out, DocDetail{"Submitted", at})
+	}
+	doc.Details = append(out, doc.Details...)
+}
+
+// ResultsAttempt is the attempt number the item results record, or 0 when they record none
+// or disagree.
+func ResultsAttempt(results []ResultItem) int {
+	attempt := 0
+	for _, r := range results {
+		switch {
+		case r.Attempt == 0:
+		case attempt == 0:
+			attempt = r.Attempt
+		case attempt != r.Attempt:
+			return 0
+		}
+	}
+	return attempt
+}
+
+// scheduleDetails renders the due date and availability window, when present.
+func scheduleDetails(meta QuizMeta) []DocDetail {
+	var out []DocDetail
+	if d := formatCanvasTime(meta.DueAt); d != "" {
+		out = append(out, DocDetail{"Due", d})
+	}
+	from, until := formatCanvasTime(meta.UnlockAt), formatCanvasTime(meta.LockAt)
+	switch {
+	case from != "" && until != "":
+		out = append(out, DocDetail{"Available", fmt.Sprintf("%s until %s", from, until)})
+	case from != "":
+		out = append(out, DocDetail{"Available", "from " + from})
+	case until != "":
+		out = append(out, DocDetail{"Available", "until " + until})
+	}
+	return out
+}
+
+// formatCanvasTime reformats a Canvas ISO-8601 timestamp for display, keeping its zone.
+// Unparseable values are passed through unchanged.
+func formatCanvasTime(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return ""
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05.000-07:00", "2006-01-02T15:04:05.000Z07:00"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.Format("Mon 2 Jan 2006 15:04 MST")
+		}
+	}
+	return v
+}
+
+// examConditions summarizes time limit, attempts, shuffling and navigation from either the
+// New Quizzes quiz_settings or the Classic Quizzes fields.
+func examConditions(meta QuizMeta) []DocDetail {
+	var out []DocDetail
+	st := meta.Settings
+
+	switch {
+	case st.HasTimeLimit && st.SessionTimeLimitInSeconds > 0:
+		out = append(out, DocDetail{"Time limit", formatSeconds(st.SessionTimeLimitInSeconds)})
+	case meta.TimeLimit != nil && *meta.TimeLimit > 0:
+		out = append(out, DocDetail{"Time limit", formatMinutes(*meta.TimeLimit)})
+	}
+
+	scorePolicy := st.MultipleAttempts.ScoreToKeep
+	if scorePolicy == "" {
+		scorePolicy = strings.TrimPrefix(meta.ScoringPolicy, "keep_")
+	}
+	attempts := ""
+	switch {
+	case st.MultipleAttempts.Enabled && st.MultipleAttempts.AttemptLimit && st.MultipleAttempts.MaxAttempts > 0:
+		attempts = fmt.Sprint(st.MultipleAttempts.MaxAttempts)
+	case st.MultipleAttempts.Enabled:
+		attempts = "unlimited"
+	case meta.AllowedAttempts != nil && *meta.AllowedAttempts < 0:
+		attempts = "unlimited"
+	case meta.AllowedAttempts != nil && *meta.AllowedAttempts > 0:
+		attempts = fmt.Sprint(*meta.AllowedAttempts)
+	}
+	if attempts != "" {
+		if scorePolicy != "" && attempts != "1" {
+			attempts += fmt.Sprintf(" (score kept: %s)", strings.ReplaceAll(scorePolicy, "_", " "))
+		}
+		out = append(out, DocDetail{"Allowed attempts", attempts})
+	}
+
+	shuffleAnswers := st.ShuffleAnswers
+	if shuffleAnswers == nil {
+		shuffleAnswers = meta.ShuffleAnswers
+	}
+	var shuffle []string
+	if shuffleAnswers != nil {
+		if *shuffleAnswers {
+			shuffle = append(shuffle, "answers shuffled")
+		} else {
+			shuffle = append(shuffle, "answers in fixed order")
+		}
+	}
+	if st.ShuffleQuestions != nil {
+		if *st.ShuffleQuestions {
+			shuffle = append(shuffle, "questions shuffled")
+		} else {
+			shuffle = append(shuffle, "questions in fixed order")
+		}
+	}
+	if len(shuffle) > 0 {
+		out = append(out, DocDetail{"Shuffle", strings.Join(shuffle, ", ")})
+	}
+
+	switch {
+	case st.OneAtATimeType != "" && st.OneAtATimeType != "none":
+		out = append(out, DocDetail{"Navigation", "one question at a time"})
+	case meta.OneQuestionAtATime != nil && *meta.OneQuestionAtATime:
+		out = append(out, DocDetail{"Navigation", "one question at a time"})
+	}
+	return out
+}
+
+func formatMinutes(m int) string {
+	if m == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", m)
+}
+
+// formatSeconds renders a time limit given in seconds without losing the odd seconds,
+// e.g. "30 seconds", "1 minute 30 seconds", "45 minutes".
+func formatSeconds(sec int) string {
+	unit := func(n int, one string) string {
+		if n == 1 {
+			return "1 " + one
+		}
+		return fmt.Sprintf("%d %ss", n, one)
+	}
+	switch {
+	case sec < 60:
+		return unit(sec, "second")
+	case sec%60 == 0:
+		return formatMinutes(sec / 60)
+	}
+	return formatMinutes(sec/60) + " " + unit(sec%60, "second")
+}
+
+// ClassicQuestion is one question of a legacy Classic Quizzes export: the quiz questions
+// endpoint or submission_questions. The answer key travels with the question as answer
+// weights (100 = correct) instead of in a separate results payload.
+type ClassicQuestion struct {
+	ID                any             `json:"id"`
+	Position          int             `json:"position"`
+	QuestionName      string          `json:"question_name"`
+	QuestionType      string          `json:"question_type"` // e.g. multiple_choice_question
+	QuestionText      string          `json:"question_text"` // HTML; blanks appear as [blank_id]
+	PointsPossible    float64         `json:"points_possible"`
+	Answers           []ClassicAnswer `json:"answers"`
+	CorrectComments   string          `json:"correct_comments"`
+	IncorrectComments string          `json:"incorrect_comments"`
+	NeutralComments   string          `json:"neutral_comments"`
+	// calculated_question: each answer is one set of variable values with its result
+	Formulas []struct {
+		Formula string `json:"formula"`
+	} `json:"formulas"`
+	AnswerTolerance any `json:"answer_tolerance"` // a number, or a percentage such as "5%"
+	// submission_questions: the student's answer, ungraded (see response)
+	Answer any `json:"answer"`
+}
+
+type ClassicAnswer struct {
+	ID       any    `json:"id"`
+	Text     string `json:"text"`
+	HTML     string `json:"html"`
+	Weight   any    `json:"weight"`   // 100 or "100" for correct answers, 0 otherwise
+	BlankID  string `json:"blank_id"` // fill_in_multiple_blanks and multiple_dropdowns
+	Comments string `json:"comments"`
+	Left     string `json:"left"` // matching: prompt
+	Right    string `json:"right"`
+	// numerical_question answers
+	NumericalType string   `json:"numerical_answer_type"` // exact_answer, range_answer or precision_answer
+	Exact         *float64 `json:"exact"`
+	Margin        *float64 `json:"margin"`
+	Start         *float64 `json:"start"`
+	End           *float64 `json:"end"`
+	Approximate   *float64 `json:"approximate"`
+	Precision     *float64 `json:"precision"` // significant digits of an approximate answer
+	// calculated_question answers
+	Variables []struct {
+		Name  string `json:"name"`
+		Value any    `json:"value"`
+	} `json:"variables"`
+	Answer *float64 `json:"answer"`
+}
+
+// ParseClassicQuestions parses b as a legacy Classic Quizzes export. ok is false when the
+// payload has another shape (New Quizzes items), so the caller falls back to the usual parser.
+func ParseClassicQuestions(b []byte) (qs []ClassicQuestion, ok bool, err error) {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(b, &obj) == nil {
+		raw, found := obj["quiz_submission_questions"]
+		if !found {
+			return nil, false, nil
+		}
+		return qs, true, json.Unmarshal(raw, &qs)
+	}
+	var probe []map[string]json.RawMessage
+	if json.Unmarshal(b, &probe) != nil || len(probe) == 0 {
+		return nil, false, nil
+	}
+	if _, found := probe[0]["question_type"]; !found {
+		return nil, false, nil
+	}
+	return qs, true, json.Unmarshal(b, &qs)
+}
+
+func (a ClassicAnswer) correct() bool {
+	switch w := a.Weight.(type) {
+	case float64:
+		return w > 0
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(w), 64)
+		return err == nil && f > 0
+	}
+	return false
+}
+
+func (a ClassicAnswer) label() string {
+	switch {
+	case a.Left != "" || a.Right != "":
+		return stripHTML(a.Left) + " → " + stripHTML(a.Right)
+	case a.NumericalType == "range_answer" && a.Start != nil && a.End != nil:
+		return fmt.Sprintf("between %s and %s", FormatPoints(*a.Start), FormatPoints(*a.End))
+	case a.NumericalType == "precision_answer" && a.Approximate != nil:
+		if a.Precision != nil {
+			return fmt.Sprintf("%s (%s significant digits)", FormatPoints(*a.Approximate), FormatPoints(*a.Precision))
+		}
+		return FormatPoints(*a.Approximate)
+	case a.Exact != nil:
+		if a.Margin != nil && *a.Margin != 0 {
+			return fmt.Sprintf("%s ± %s", FormatPoints(*a.Exact), FormatPoints(*a.Margin))
+		}
+		return FormatPoints(*a.Exact)
+	case a.HTML != "":
+		return stripHTML(a.HTML)
+	}
+	return stripHTML(a.Text)
+}
+
+// ClassicID renders a Classic Quizzes id, which exports store as a number or a string.
+func ClassicID(v any) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// BuildClassicDoc normalizes a legacy Classic Quizzes export into the same document the
+// New Quizzes path produces. text_only_question entries are instructions, not questions.
+func BuildClassicDoc(questions []ClassicQuestion, title string) QuizDoc {
+	sorted := make([]ClassicQuestion, len(questions))
+	copy(sorted, questions)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Position < sorted[j].Position })
+
+	doc := QuizDoc{Title: title}
+	for _, cq := range sorted {
+		if cq.QuestionType == "text_only_question" {
+			continue
+		}
+		var repaired bool
+		cq.QuestionText, repaired = RepairHTML(cq.QuestionText)
+		q := Question{
+			Number:            len(doc.Questions) + 1,
+			ItemID:            ClassicID(cq.ID),
+			Title:             strings.TrimSpace(cq.QuestionName),
+			HasResult:         true,
+			Media:             extractMedia(cq.QuestionText),
+			Links:             extractLinks(cq.QuestionText, cq.NeutralComments, cq.CorrectComments, cq.IncorrectComments),
+			Terms:             extractTerms(cq.QuestionText),
+			Type:              questionType(cq.QuestionType, "", ""),
+			Slug:              cq.QuestionType,
+			Repaired:          repaired,
+			Possible:          cq.PointsPossible,
+			GeneralFeedback:   stripHTML(cq.NeutralComments),
+			CorrectFeedback:   stripHTML(cq.CorrectComments),
+			IncorrectFeedback: stripHTML(cq.IncorrectComments),
+		}
+		text := cq.QuestionText
+		switch cq.QuestionType {
+		case "essay_question", "file_upload_question":
+			q.Essay = true
+		case "short_answer_question", "numerical_question":
+			q.OpenEntry = true
+			q.Blanks = []BlankAnswer{classicBlank("Blank 1", cq.Answers)}
+		case "fill_in_multiple_blanks_question", "multiple_dropdowns_question":
+			q.OpenEntry = true
+			var order []string
+			byBlank := map[string][]ClassicAnswer{}
+			for _, a := range cq.Answers {
+				if _, seen := byBlank[a.BlankID]; !seen {
+					order = append(order, a.BlankID)
+				}
+				byBlank[a.BlankID] = append(byBlank[a.BlankID], a)
+			}
+			for i, id := range order {
+				label := fmt.Sprintf("Blank %d", i+1)
+				text = strings.ReplaceAll(text, "["+id+"]", "["+label+"]")
+				b := classicBlank(label, byBlank[id])
+				b.ID = id
+				q.Blanks = append(q.Blanks, b)
+			}
+		case "calculated_question":
+			q.Formula, q.Given, q.Answers = cq.calculated()
+		case "matching_question":
+			q.Multi = true
+			for _, a := range cq.Answers {
+				q.Answers = append(q.Answers, a.label())
+			}
+		default: // multiple_choice, true_false, multiple_answers and anything unrecognized
+			q.Multi = cq.QuestionType == "multiple_answers_question"
+			for _, a := range cq.Answers {
+				o := Option{ID: ClassicID(a.ID), Label: a.label(), Correct: a.correct(), Feedback: stripHTML(a.Comments)}
+				q.Options = append(q.Options, o)
+				if o.Correct {
+					q.Answers = append(q.Answers, o.Label)
+				}
+			}
+		}
+		q.Text = stripHTML(text)
+		if !q.OpenEntry && richBody(text) {
+			q.BodyHTML = text
+		}
+		if r, ok := cq.response(); ok {
+			q.applyClassicResponse(r, cq)
+		}
+		// Classic answers carry their authored weights, so a key is always confirmed.
+		switch {
+		case q.Essay || q.Ungraded:
+		case len(q.Answers) > 0 || len(q.Blanks) > 0 && q.Blanks[0].Answer != "":
+			q.KeyConfidence = KeyConfirmed
+		default:
+			q.KeyConfidence = KeyUnknown
+		}
+		doc.Questions = append(doc.Questions, q)
+	}
+	doc.assignContentIDs()
+	return doc
+}
+
+// calculated reads a calculated_question: its formula, and the first answer's variable
+// values and result with the question's tolerance. A submission question holds the one set
+// of values the student was given; a quiz export lists several generated sets.
+func (cq ClassicQuestion) calculated() (formula, given string, answers []string) {
+	var formulas []string
+	for _, f := range cq.Formulas {
+		if f := strings.TrimSpace(f.Formula); f != "" {
+			formulas = append(formulas, f)
+		}
+	}
+	formula = strings.Join(formulas, "; ")
+	if len(cq.Answers) == 0 {
+		return formula, "", nil
+	}
+	a := cq.Answers[0]
+	var parts []string
+	for _, v := range a.Variables {
+		value := fmt.Sprint(v.Value)
+		if f, ok := v.Value.(float64); ok {
+			value = FormatPoints(f)
+		}
+		parts = append(parts, v.Name+"="+value)
+	}
+	if a.Answer == nil {
+		return formula, strings.Join(parts, ", "), nil
+	}
+	label := FormatPoints(*a.Answer)
+	switch t := cq.AnswerTolerance.(type) {
+	case float64:
+		if t != 0 {
+			label += " ± " + FormatPoints(t)
+		}
+	case string:
+		if t = strings.TrimSpace(t); t != "" && t != "0" {
+			label += " ± " + t
+		}
+	}
+	return formula, strings.Join(parts, ", "), []string{label}
+}
+
+// classicBlank collects a blank's correct answers; every weighted answer is accepted.
+func classicBlank(label string, answers []ClassicAnswer) BlankAnswer {
+	b := BlankAnswer{Label: label}
+	for _, a := range answers {
+		if a.correct() {
+			b.Accepted = append(b.Accepted, a.label())
+		}
+	}
+	if len(b.Accepted) > 0 {
+		b.Answer = b.Accepted[0]
+	}
+	if len(b.Accepted) < 2 {
+		b.Accepted = nil
+	}
+	return b
+}
+
+// ClassicSubmission is one attempt at a Classic Quizzes quiz: an entry of a submission's
+// submission_history, with the student's answers in Answers, or of the quiz_submissions
+// endpoint, which only has the attempt's score.
+type ClassicSubmission struct {
+	Attempt        int               `json:"attempt"`
+	Score          *float64          `json:"score"`
+	PointsPossible float64           `json:"quiz_points_possible"` // quiz_submissions only
+	SubmittedAt    string            `json:"submitted_at"`
+	FinishedAt     string            `json:"finished_at"` // quiz_submissions' name for it
+	Answers        []ClassicResponse `json:"submission_data"`
+}
+
+// ClassicResponse is the student's answer to one question in submission_data. Which fields
+// are set depends on the question type: answer_id for a single choice, "answer_<id>": "1"
+// for each answer chosen in a multiple-answer question, "answer_for_<blank>" for each blank
+// (the text typed, or the id of the dropdown answer picked) and text for short answers,
+// numbers and essays.
+type ClassicResponse struct {
+	QuestionID any            `json:"question_id"`
+	Correct    any            `json:"correct"` // true, false, "partial", or "undefined" before grading
+	Points     float64        `json:"points"`
+	AnswerID   any            `json:"answer_id"`
+	Text       string         `json:"text"`
+	Fields     map[string]any `json:"-"` // every other answer_* field, by name
+}
+
+func (r *ClassicResponse) UnmarshalJSON(b []byte) error {
+	type plain ClassicResponse
+	if err := json.Unmarshal(b, (*plain)(r)); err != nil {
+		return err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	for k, v := range all {
+		if strings.HasPrefix(k, "answer_") && k != "answer_id" {
+			if r.Fields == nil {
+				r.Fields = map[string]any{}
+			}
+			r.Fields[k] = v
+		}
+	}
+	return nil
+}
+
+// field returns the answer_* field name as text; "" when it is missing or null.
+func (r ClassicResponse) field(name string) string {
+	if v, ok := r.Fields[name]; ok && v != nil {
+		return strings.TrimSpace(ClassicID(v))
+	}
+	return ""
+}
+
+// ParseClassicSubmissions reads the attempts of a Classic Quizzes submission from any of: a
+// submission with its submission_history (the Submissions API with
+// include[]=submission_history), a list of such submissions, a single attempt with its
+// submission_data, a list of attempts, or a quiz_submissions response. ok is false when b
+// has none of these shapes. The attempts come back oldest first.
+func ParseClassicSubmissions(b []byte) (subs []ClassicSubmission, ok bool, err error) {
+	var objs []map[string]json.RawMessage
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(b, &obj) == nil {
+		objs = []map[string]json.RawMessage{obj}
+	} else if json.Unmarshal(b, &objs) != nil || len(objs) == 0 {
+		return nil, false, nil
+	}
+	for _, o := range objs {
+		var list json.RawMessage
+		switch {
+		case o["submission_history"] != nil:
+			list = o["submission_history"]
+		case o["quiz_submissions"] != nil:
+			list = o["quiz_submissions"]
+		case o["submission_data"] != nil:
+			raw, _ := json.Marshal(o)
+			list = append(append(json.RawMessage("["), raw...), ']')
+		default:
+			return nil, false, nil
+		}
+		var attempts []ClassicSubmission
+		if err := json.Unmarshal(list, &attempts); err != nil {
+			return nil, true, err
+		}
+		subs = append(subs, attempts...)
+	}
+	for i := range subs {
+		if subs[i].Attempt == 0 {
+			subs[i].Attempt = i + 1
+		}
+		if subs[i].SubmittedAt == "" {
+			subs[i].SubmittedAt = subs[i].FinishedAt
+		}
+	}
+	sort.SliceStable(subs, func(i, j int) bool { return subs[i].Attempt < subs[j].Attempt })
+	return subs, true, nil
+}
+
+// BuildClassicSubmissionDoc builds the document of a Classic Quizzes export, as
+// BuildClassicDoc does, with the student's answers from subs (see ParseClassicSubmissions).
+// Each question shows the latest attempt that answered it, with its points; when several
+// attempts have answers, every attempt's answer and score is listed in Attempts, as
+// MergeAttempts does for New Quizzes. Attempts that only have a score (quiz_submissions)
+// are summed up in an "Attempts" detail.
+func BuildClassicSubmissionDoc(questions []ClassicQuestion, subs []ClassicSubmission, title string) QuizDoc {
+	doc := BuildClassicDoc(questions, title)
+	byID := map[string]ClassicQuestion{}
+	for _, cq := range questions {
+		byID[ClassicID(cq.ID)] = cq
+	}
+	var possible float64
+	for _, q := range doc.Questions {
+		possible += q.Possible
+	}
+	var totals []string
+	var answered []ClassicSubmission
+	for _, sub := range subs {
+		if sub.Score != nil {
+			total := possible
+			if sub.PointsPossible > 0 {
+				total = sub.PointsPossible
+			}
+			totals = append(totals, fmt.Sprintf("Attempt %d: %s / %s", sub.Attempt, FormatPoints(RoundTo(*sub.Score, 2)), FormatPoints(total)))
+		}
+		if sub.Answers != nil {
+			answered = append(answered, sub)
+		}
+	}
+	if len(totals) > 1 {
+		doc.Details = append(doc.Details, DocDetail{Label: "Attempts", Value: strings.Join(totals, ", ")})
+	}
+	for j := range doc.Questions {
+		base := doc.Questions[j]
+		for _, sub := range answered {
+			for _, r := range sub.Answers {
+				if ClassicID(r.QuestionID) != base.ItemID {
+					continue
+				}
+				q := base
+				q.applyClassicResponse(r, byID[base.ItemID])
+				if len(answered) > 1 {
+					answer := strings.Join(q.Responses, ", ")
+					if answer == "" {
+						answer = "(no response)"
+					}
+					score := "—"
+					if q.Earned != nil {
+						score = FormatPoints(RoundTo(*q.Earned, 2)) + " / " + FormatPoints(q.Possible)
+					}
+					q.Attempts = append(doc.Questions[j].Attempts, AttemptAnswer{Attempt: fmt.Sprintf("Attempt %d", sub.Attempt), Answer: answer, Score: score})
+				}
+				doc.Questions[j] = q
+			}
+		}
+	}
+	return doc
+}
+
+// applyClassicResponse sets q's response from r, the student's answer to cq: the options
+// chosen, Responses, Submission for an essay, and Earned once the answer is graded.
+func (q *Question) applyClassicResponse(r ClassicResponse, cq ClassicQuestion) {
+	q.Options = slices.Clone(q.Options)
+	q.Responses, q.Submission, q.Earned = nil, nil, nil
+	if c, _ := r.Correct.(string); c != "undefined" {
+		points := r.Points
+		q.Earned = &points
+	}
+	chosen := ClassicID(r.AnswerID)
+	for i, o := range q.Options {
+		v := r.field("answer_" + o.ID)
+		q.Options[i].Selected = o.ID == chosen || v != "" && v != "0"
+		if q.Options[i].Selected {
+			q.Responses = append(q.Responses, o.Label)
+		}
+	}
+	text := strings.TrimSpace(r.Text)
+	switch {
+	case q.Essay:
+		q.Submission = htmlParagraphs(text)
+	case q.OpenEntry && len(q.Blanks) == 1 && q.Blanks[0].ID == "":
+		if text != "" {
+			q.Responses = []string{q.Blanks[0].Label + ": " + stripHTML(text)}
+		}
+	case q.OpenEntry:
+		for _, b := range q.Blanks {
+			v := r.field("answer_for_" + b.ID)
+			if cq.QuestionType == "multiple_dropdowns_question" {
+				// A dropdown records the id of the answer picked.
+				for _, a := range cq.Answers {
+					if ClassicID(a.ID) == v && v != "" {
+						v = a.label()
+					}
+				}
+			}
+			if v != "" {
+				q.Responses = append(q.Responses, b.Label+": "+stripHTML(v))
+			}
+		}
+	}
+	q.Unanswered = len(q.Responses) == 0 && len(q.Submission) == 0 && chosen == "" && text == ""
+	for _, v := range r.Fields {
+		q.Unanswered = q.Unanswered && (v == nil || ClassicID(v) == "")
+	}
+}
+
+// response reads the answer a submission_questions entry records, in the submission_data
+// form: an answer id, a list of ids, text, or blank ids mapped to text or answer ids. It is
+// not graded, so it carries no points.
+func (cq ClassicQuestion) response() (ClassicResponse, bool) {
+	r := ClassicResponse{QuestionID: cq.ID, Correct: "undefined"}
+	switch a := cq.Answer.(type) {
+	case nil:
+		return r, false
+	case []any:
+		r.Fields = map[string]any{}
+		for _, id := range a {
+			r.Fields["answer_"+ClassicID(id)] = "1"
+		}
+	case map[string]any:
+		r.Fields = map[string]any{}
+		for blank, v := range a {
+			r.Fields["answer_for_"+blank] = v
+		}
+	case string:
+		r.Text = a
+	case float64:
+		if strings.HasPrefix(cq.QuestionType, "multiple_choice") || strings.HasPrefix(cq.QuestionType, "true_false") {
+			r.AnswerID = a
+		} else {
+			r.Text = FormatPoints(a)
+		}
+	default:
+		r.Text = fmt.Sprint(a)
+	}
+	return r, true
+}
+
+// ParseQTI reads a QTI package, given as its XML files keyed by path, into Classic Quizzes
+// questions for BuildClassicDoc. Canvas writes Classic question types and answer weights
+// into its QTI 1.2 exports, so those map across directly. It reads QTI 1.2 assessments,
+// with the items they draw from item banks in the same package, bare item banks, and
+// QTI 2.1 assessment items, in assessmentTest order when there is one. Other files, such
+// as imsmanifest.xml or assessment_meta.xml, are ignored.
+func ParseQTI(files map[string][]byte) ([]ClassicQuestion, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var assessments, items []*qtiNode
+	var itemFiles []string
+	var bankIDs []string
+	banks := map[string]*qtiNode{}
+	seen := map[string]bool{} // course exports write each assessment twice
+	var testRefs []string
+	for _, name := range names {
+		b := files[name]
+		if !bytes.Contains(b, []byte("questestinterop")) && !bytes.Contains(b, []byte("assessmentItem")) && !bytes.Contains(b, []byte("assessmentTest")) {
+			continue
+		}
+		root := new(qtiNode)
+		if err := xml.Unmarshal(b, root); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		switch root.XMLName.Local {
+		case "questestinterop":
+			as, bs := root.find("assessment"), root.find("objectbank")
+			for _, a := range as {
+				if id := a.attr("ident"); id == "" || !seen[id] {
+					seen[id] = true
+					assessments = append(assessments, a)
+				}
+			}
+			for _, bank := range bs {
+				id := bank.attr("ident")
+				if _, dup := banks[id]; !dup {
+					banks[id] = bank
+					bankIDs = append(bankIDs, id)
+				}
+			}
+			if len(as) == 0 && len(bs) == 0 {
+				banks[name] = root
+				bankIDs = append(bankIDs, name)
+			}
+		case "assessmentItem":
+			items = append(items, root)
+			itemFiles = append(itemFiles, name)
+		case "assessmentTest":
+			for _, ref := range root.find("assessmentItemRef") {
+				testRefs = append(testRefs, pathpkg.Join(pathpkg.Dir(name), ref.attr("href")))
+			}
+		}
+	}
+
+	var qs []ClassicQuestion
+	switch {
+	case len(assessments) > 1:
+		var titles []string
+		for _, a := range assessments {
+			titles = append(titles, strconv.Quote(a.attr("title")))
+		}
+		return nil, fmt.Errorf("the package holds %d assessments (%s); export the quiz you want on its own", len(assessments), strings.Join(titles, ", "))
+	case len(assessments) == 1:
+		used := map[string]bool{}
+		var walk func(n *qtiNode)
+		walk = func(n *qtiNode) {
+			for i := range n.Nodes {
+				c := &n.Nodes[i]
+				switch c.XMLName.Local {
+				case "item":
+					qs = append(qs, qtiItem(c))
+				case "sourcebank_ref":
+					// A question group drawing from a bank; banks exported elsewhere are missing.
+					if id := strings.TrimSpace(c.Text); banks[id] != nil && !used[id] {
+						used[id] = true
+						walk(banks[id])
+					}
+				default:
+					walk(c)
+				}
+			}
+		}
+		walk(assessments[0])
+	case len(banks) > 0:
+		for _, id := range bankIDs {
+			for _, item := range banks[id].find("item") {
+				qs = append(qs, qtiItem(item))
+			}
+		}
+	case len(items) > 0:
+		order := map[string]int{}
+		for i, ref := range testRefs {
+			order[ref] = i + 1
+		}
+		idx := make([]int, len(items))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(a, b int) bool {
+			oa, ob := order[itemFiles[idx[a]]], order[itemFiles[idx[b]]]
+			return oa != 0 && (ob == 0 || oa < ob)
+		})
+		for _, i := range idx {
+			qs = append(qs, qti21Item(items[i]))
+		}
+	default:
+		return nil, errors.New("no QTI assessment, item bank or assessment item found")
+	}
+	for i := range qs {
+		qs[i].Position = i + 1
+	}
+	return qs, nil
+}
+
+// qtiNode is an element of a QTI document, kept generic because the item layouts vary
+// with the question type and the tool that wrote them.
+type qtiNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Nodes   []qtiNode  `xml:",any"`
+	Text    string     `xml:",chardata"`
+	Inner   string     `xml:",innerxml"`
+}
+
+func (n *qtiNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// find returns n's descendants with the local name, in document order, without looking
+// inside the ones it returns.
+func (n *qtiNode) find(local string) []*qtiNode {
+	return n.findFunc(func(c *qtiNode) bool { return c.XMLName.Local == local })
+}
+
+func (n *qtiNode) findFunc(match func(*qtiNode) bool) []*qtiNode {
+	var out []*qtiNode
+	for i := range n.Nodes {
+		if c := &n.Nodes[i]; match(c) {
+			out = append(out, c)
+		} else {
+			out = append(out, c.findFunc(match)...)
+		}
+	}
+	return out
+}
+
+// material returns the first mattext under n as HTML, and whether Canvas marked it as
+// HTML rather than plain text.
+func (n *qtiNode) material() (s string, isHTML bool) {
+	m := n.find("mattext")
+	if len(m) == 0 {
+		return "", false
+	}
+	return strings.TrimSpace(m[0].Text), m[0].attr("texttype") == "text/html"
+}
+
+// qtiAnswer is a choice of a QTI 1.2 response_label, filed as Classic HTML or text.
+func qtiAnswer(label *qtiNode) ClassicAnswer {
+	a := ClassicAnswer{ID: label.attr("ident")}
+	if s, isHTML := label.material(); isHTML {
+		a.HTML = s
+	} else {
+		a.Text = s
+	}
+	return a
+}
+
+// qtiItem converts a QTI 1.2 item. The answer key is read from the respconditions that
+// award points: the values they test for, outside a <not>, are correct.
+func qtiItem(item *qtiNode) ClassicQuestion {
+	meta := map[string]string{}
+	for _, f := range item.find("qtimetadatafield") {
+		if l, e := f.find("fieldlabel"), f.find("fieldentry"); len(l) > 0 && len(e) > 0 {
+			meta[strings.TrimSpace(l[0].Text)] = strings.TrimSpace(e[0].Text)
+		}
+	}
+	cq := ClassicQuestion{ID: item.attr("ident"), QuestionName: item.attr("title"), QuestionType: meta["question_type"]}
+	cq.PointsPossible, _ = strconv.ParseFloat(meta["points_possible"], 64)
+	feedback := map[string]string{}
+	for _, fb := range item.find("itemfeedback") {
+		feedback[fb.attr("ident")], _ = fb.material()
+	}
+	cq.NeutralComments, cq.CorrectComments, cq.IncorrectComments = feedback["general_fb"], feedback["correct_fb"], feedback["general_incorrect_fb"]
+
+	var responses []*qtiNode
+	for _, pres := range item.find("presentation") {
+		for _, m := range pres.findFunc(func(c *qtiNode) bool {
+			return c.XMLName.Local == "material" || strings.HasPrefix(c.XMLName.Local, "response_")
+		}) {
+			if m.XMLName.Local != "material" {
+				responses = append(responses, m)
+			} else if cq.QuestionText == "" {
+				s, isHTML := m.material()
+				if !isHTML {
+					s = html.EscapeString(s)
+				}
+				cq.QuestionText = s
+			}
+		}
+	}
+	if cq.QuestionType == "" {
+		cq.QuestionType = "essay_question"
+		for _, r := range responses {
+			switch {
+			case r.XMLName.Local == "response_lid" && r.attr("rcardinality") == "Multiple":
+				cq.QuestionType = "multiple_answers_question"
+			case r.XMLName.Local == "response_lid":
+				cq.QuestionType = "multiple_choice_question"
+			case r.XMLName.Local == "response_num":
+				cq.QuestionType = "numerical_question"
+			}
+		}
+	}
+
+	key := map[string][]string{} // respident -> values that score
+	var numeric []ClassicAnswer
+	for _, rc := range item.find("respcondition") {
+		scores := false
+		for _, sv := range rc.find("setvar") {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(sv.Text), 64); err == nil && f > 0 {
+				scores = true
+			}
+		}
+		if !scores {
+			continue
+		}
+		var collect func(n *qtiNode)
+		collect = func(n *qtiNode) {
+			for i := range n.Nodes {
+				c := &n.Nodes[i]
+				switch c.XMLName.Local {
+				case "not":
+				case "varequal":
+					id := c.attr("respident")
+					key[id] = append(key[id], strings.TrimSpace(c.Text))
+				default:
+					collect(c)
+				}
+			}
+		}
+		for _, cv := range rc.find("conditionvar") {
+			collect(cv)
+			if cq.QuestionType == "numerical_question" {
+				numeric = append(numeric, qtiNumeric(cv))
+			}
+		}
+	}
+	scores := func(respident, value string) bool {
+		for _, v := range key[respident] {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+	weight := func(ok bool) any {
+		if ok {
+			return 100.0
+		}
+		return 0.0
+	}
+
+	switch cq.QuestionType {
+	case "essay_question", "file_upload_question", "text_only_question":
+	case "numerical_question":
+		cq.Answers = numeric
+	case "short_answer_question":
+		for _, r := range responses {
+			for _, v := range key[r.attr("ident")] {
+				cq.Answers = append(cq.Answers, ClassicAnswer{Text: v, Weight: 100.0})
+			}
+		}
+	case "matching_question":
+		for _, r := range responses {
+			left, _ := r.material()
+			for _, l := range r.find("response_label") {
+				if scores(r.attr("ident"), l.attr("ident")) {
+					right, _ := l.material()
+					cq.Answers = append(cq.Answers, ClassicAnswer{ID: r.attr("ident"), Left: left, Right: right, Weight: 100.0})
+				}
+			}
+		}
+	case "fill_in_multiple_blanks_question", "multiple_dropdowns_question":
+		for _, r := range responses {
+			blank, _ := r.material()
+			for _, l := range r.find("response_label") {
+				a := qtiAnswer(l)
+				a.BlankID, a.Weight = blank, weight(scores(r.attr("ident"), l.attr("ident")))
+				cq.Answers = append(cq.Answers, a)
+			}
+		}
+	default:
+		for _, r := range responses {
+			for _, l := range r.find("response_label") {
+				a := qtiAnswer(l)
+				a.Weight, a.Comments = weight(scores(r.attr("ident"), l.attr("ident"))), feedback[l.attr("ident")+"_fb"]
+				cq.Answers = append(cq.Answers, a)
+			}
+		}
+	}
+	return cq
+}
+
+// qtiNumeric reads a numerical answer from a scoring conditionvar: Canvas writes an exact
+// answer with a margin as varequal or vargte/varlte, and a range as vargte/varlte alone.
+func qtiNumeric(cv *qtiNode) ClassicAnswer {
+	num := func(local string) *float64 {
+		for _, n := range cv.find(local) {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(n.Text), 64); err == nil {
+				return &f
+			}
+		}
+		return nil
+	}
+	a := ClassicAnswer{Weight: 100.0}
+	exact, lo, hi := num("varequal"), num("vargte"), num("varlte")
+	switch {
+	case exact != nil:
+		a.NumericalType, a.Exact = "exact_answer", exact
+		if lo != nil {
+			m := *exact - *lo
+			a.Margin = &m
+		}
+	case lo != nil && hi != nil:
+		a.NumericalType, a.Start, a.End = "range_answer", lo, hi
+	}
+	return a
+}
+
+// reQTIInteraction matches a QTI 2.1 interaction element in an itemBody, which the
+// question text replaces with its prompt or, for inline ones, a [blank] placeholder.
+var reQTIInteraction = regexp.MustCompile(`(?s)<(?:\w+:)?(\w+Interaction)\b([^>]*?)(?:/>|>(.*?)</(?:\w+:)?\w+Interaction>)`)
+
+var reQTIPrompt = regexp.MustCompile(`(?s)<(?:\w+:)?prompt\b[^>]*>(.*?)</(?:\w+:)?prompt>`)
+
+var reQTIResponseID = regexp.MustCompile(`\bresponseIdentifier\s*=\s*"([^"]*)"`)
+
+// qti21Item converts a QTI 2.1 assessmentItem. Its key is the correctResponse of each
+// responseDeclaration, or the mapping entries worth points; without a MAXSCORE outcome the
+// item is worth 1 point, the QTI default for a correct response.
+func qti21Item(item *qtiNode) ClassicQuestion {
+	cq := ClassicQuestion{ID: item.attr("identifier"), QuestionName: item.attr("title"), PointsPossible: 1}
+	key := map[string][]string{}
+	for _, rd := range item.find("responseDeclaration") {
+		id := rd.attr("identifier")
+		for _, cr := range rd.find("correctResponse") {
+			for _, v := range cr.find("value") {
+				key[id] = append(key[id], strings.TrimSpace(v.Text))
+			}
+		}
+		for _, e := range rd.find("mapEntry") {
+			if f, err := strconv.ParseFloat(e.attr("mappedValue"), 64); err == nil && f > 0 && !slices.Contains(key[id], e.attr("mapKey")) {
+				key[id] = append(key[id], e.attr("mapKey"))
+			}
+		}
+	}
+	for _, od := range item.find("outcomeDeclaration") {
+		if od.attr("identifier") == "MAXSCORE" {
+			for _, v := range od.find("value") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v.Text), 64); err == nil {
+					cq.PointsPossible = f
+				}
+			}
+		}
+	}
+	var feedback []string
+	for _, fb := range item.find("modalFeedback") {
+		feedback = append(feedback, strings.TrimSpace(fb.Inner))
+	}
+	cq.NeutralComments = strings.Join(feedback, "\n")
+	weight := func(id, value string) any {
+		if slices.Contains(key[id], value) {
+			return 100.0
+		}
+		return 0.0
+	}
+
+	kinds := map[string]bool{}
+	for _, body := range item.find("itemBody") {
+		for _, in := range body.findFunc(func(c *qtiNode) bool { return strings.HasSuffix(c.XMLName.Local, "Interaction") }) {
+			id := in.attr("responseIdentifier")
+			kinds[in.XMLName.Local] = true
+			switch in.XMLName.Local {
+			case "choiceInteraction", "inlineChoiceInteraction":
+				for _, c := range in.findFunc(func(c *qtiNode) bool { return strings.HasSuffix(c.XMLName.Local, "Choice") }) {
+					a := ClassicAnswer{ID: c.attr("identifier"), HTML: strings.TrimSpace(c.Inner), Weight: weight(id, c.attr("identifier"))}
+					if in.XMLName.Local == "inlineChoiceInteraction" {
+						a.BlankID = id
+					}
+					cq.Answers = append(cq.Answers, a)
+				}
+				if in.XMLName.Local == "choiceInteraction" && in.attr("maxChoices") != "1" {
+					kinds["multiple"] = true
+				}
+			case "textEntryInteraction":
+				for _, v := range key[id] {
+					cq.Answers = append(cq.Answers, ClassicAnswer{Text: v, BlankID: id, Weight: 100.0})
+				}
+			}
+		}
+		cq.QuestionText += reQTIInteraction.ReplaceAllStringFunc(strings.TrimSpace(body.Inner), func(m string) string {
+			sub := reQTIInteraction.FindStringSubmatch(m)
+			if sub[1] == "textEntryInteraction" || sub[1] == "inlineChoiceInteraction" {
+				if id := reQTIResponseID.FindStringSubmatch(sub[2]); id != nil {
+					return "[" + id[1] + "]"
+				}
+			}
+			if p := reQTIPrompt.FindStringSubmatch(sub[3]); p != nil {
+				return p[1]
+			}
+			return ""
+		})
+	}
+	switch {
+	case kinds["choiceInteraction"] && kinds["multiple"]:
+		cq.QuestionType = "multiple_answers_question"
+	case kinds["choiceInteraction"]:
+		cq.QuestionType = "multiple_choice_question"
+	case kinds["textEntryInteraction"]:
+		cq.QuestionType = "fill_in_multiple_blanks_question"
+	case kinds["inlineChoiceInteraction"]:
+		cq.QuestionType = "multiple_dropdowns_question"
+	case kinds["uploadInteraction"]:
+		cq.QuestionType = "file_upload_question"
+	default:
+		cq.QuestionType = "essay_question"
+	}
+	return cq
+}
+
+// ClassStats is how the whole class did on one question, from the quiz statistics API.
+type ClassStats struct {
+	Answered   int
+	Correct    int
+	Difficulty float64 // share of students who answered correctly, 0-1 (Canvas difficulty index)
+	Choices    []ChoiceShare
+}
+
+// ChoiceShare is how many students picked one answer.
+type ChoiceShare struct {
+	Label     string
+	Responses int
+}
+
+// Summary describes the class result in one line, e.g. "Hard: 32% answered correctly
+// (8 of 25 students). Responses: Latency 60%, Response Time 32%, Throughput 8%."
+func (c ClassStats) Summary() string {
+	if c.Answered == 0 {
+		return "No class responses recorded."
+	}
+	level := "Moderate"
+	switch {
+	case c.Difficulty >= 0.85:
+		level = "Easy"
+	case c.Difficulty < 0.4:
+		level = "Hard"
+	}
+	s := fmt.Sprintf("%s: %.0f%% answered correctly (%d of %d students).", level, 100*c.Difficulty, c.Correct, c.Answered)
+	var parts []string
+	for _, ch := range c.Choices {
+		parts = append(parts, fmt.Sprintf("%s %.0f%%", ch.Label, 100*float64(ch.Responses)/float64(c.Answered)))
+	}
+	if len(parts) > 0 {
+		s += " Responses: " + strings.Join(parts, ", ") + "."
+	}
+	return s
+}
+
+// QuestionStatistics is one entry of question_statistics in the quiz statistics API payload.
+type QuestionStatistics struct {
+	ID                   any      `json:"id"`
+	Position             int      `json:"position"`
+	Responses            int      `json:"responses"`
+	AnsweredStudentCount int      `json:"answered_student_count"`
+	CorrectStudentCount  int      `json:"correct_student_count"`
+	CorrectStudentRatio  *float64 `json:"correct_student_ratio"`
+	DifficultyIndex      *float64 `json:"difficulty_index"`
+	Answers              []struct {
+		ID        any    `json:"id"`
+		Text      string `json:"text"`
+		Responses int    `json:"responses"`
+	} `json:"answers"`
+}
+
+// ApplyClassStats attaches class statistics to questions, matched by question id. Entries
+// whose id matches no question (New Quizzes ids differ from Classic ones) fall back to
+// position. Answers are labeled with the matching option when possible.
+func (doc *QuizDoc) ApplyClassStats(stats []QuestionStatistics) {
+	itemIDs := map[string]bool{}
+	for _, q := range doc.Questions {
+		itemIDs[q.ItemID] = true
+	}
+	for i := range doc.Questions {
+		q := &doc.Questions[i]
+		var st *QuestionStatistics
+		for j := range stats {
+			if ClassicID(stats[j].ID) == q.ItemID {
+				st = &stats[j]
+				break
+			}
+		}
+		for j := range stats {
+			if st == nil && stats[j].Position == q.Number && !itemIDs[ClassicID(stats[j].ID)] {
+				st = &stats[j]
+			}
+		}
+		if st == nil {
+			continue
+		}
+		cs := ClassStats{Answered: st.AnsweredStudentCount, Correct: st.CorrectStudentCount}
+		if cs.Answered == 0 {
+			cs.Answered = st.Responses
+		}
+		switch {
+		case st.CorrectStudentRatio != nil:
+			cs.Difficulty = *st.CorrectStudentRatio
+		case st.DifficultyIndex != nil:
+			cs.Difficulty = *st.DifficultyIndex
+		case cs.Answered > 0:
+			cs.Difficulty = float64(cs.Correct) / float64(cs.Answered)
+		}
+		for _, a := range st.Answers {
+			label := stripHTML(a.Text)
+			for _, o := range q.Options {
+				if o.ID == ClassicID(a.ID) {
+					label = o.Label
+				}
+			}
+			cs.Choices = append(cs.Choices, ChoiceShare{Label: label, Responses: a.Responses})
+		}
+		q.Class = &cs
+	}
+}
+
+// SubmissionEvent is one entry of the quiz submission events log
+// (GET .../quizzes/:quiz_id/submissions/:id/events).
+type SubmissionEvent struct {
+	Type      string          `json:"event_type"` // question_viewed, question_answered, page_blurred, ...
+	Data      json.RawMessage `json:"event_data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// AttemptReplay is the per-question timeline reconstructed from a submission's event log.
+type AttemptReplay struct {
+	Duration  time.Duration // first to last event
+	Questions []ReplayQuestion
+	Events    []ReplayEvent
+}
+
+// ReplayQuestion summarizes one question's share of the attempt.
+type ReplayQuestion struct {
+	Label       string // "Q3", or the raw question id when it is not in the document
+	FirstViewed time.Duration
+	TimeSpent   time.Duration // time the question was on screen while the page had focus
+	Answers     int           // answers recorded, including unchanged re-saves
+	Switches    int           // times a recorded answer replaced a different earlier one
+}
+
+// ReplayEvent is one line of the chronological replay.
+type ReplayEvent struct {
+	At   time.Duration // offset from the first event
+	Text string
+}
+
+// BuildReplay walks the events in time order. Time is charged to the question most recently
+// viewed or answered, and pauses while the page is blurred (the student left the tab).
+func BuildReplay(events []SubmissionEvent, doc QuizDoc) *AttemptReplay {
+	if len(events) == 0 {
+		return nil
+	}
+	sorted := make([]SubmissionEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedAt.Before(sorted[j].CreatedAt) })
+	start := sorted[0].CreatedAt
+
+	labels := map[string]string{}
+	for _, q := range doc.Questions {
+		labels[q.ItemID] = fmt.Sprintf("Q%d", q.Number)
+	}
+	stats := map[string]*ReplayQuestion{}
+	var order []string
+	question := func(id string, at time.Duration) *ReplayQuestion {
+		if rq, ok := stats[id]; ok {
+			return rq
+		}
+		label := labels[id]
+		if label == "" {
+			label = "question " + id
+		}
+		stats[id] = &ReplayQuestion{Label: label, FirstViewed: at}
+		order = append(order, id)
+		return stats[id]
+	}
+
+	r := &AttemptReplay{Duration: sorted[len(sorted)-1].CreatedAt.Sub(start)}
+	lastAnswer := map[string]string{}
+	current, focused, since := "", true, time.Duration(0)
+	charge := func(at time.Duration) {
+		if current != "" && focused {
+			question(current, since).TimeSpent += at - since
+		}
+		since = at
+	}
+	for _, e := range sorted {
+		at := e.CreatedAt.Sub(start)
+		switch e.Type {
+		case "question_viewed":
+			var ids []any
+			if json.Unmarshal(e.Data, &ids) != nil || len(ids) == 0 {
+				continue
+			}
+			charge(at)
+			current = ClassicID(ids[len(ids)-1])
+			r.Events = append(r.Events, ReplayEvent{at, "viewed " + question(current, at).Label})
+		case "question_answered":
+			var answers []struct {
+				QuestionID any             `json:"quiz_question_id"`
+				Answer     json.RawMessage `json:"answer"`
+			}
+			if json.Unmarshal(e.Data, &answers) != nil {
+				continue
+			}
+			for _, a := range answers {
+				id := ClassicID(a.QuestionID)
+				charge(at)
+				current = id
+				rq := question(id, at)
+				rq.Answers++
+				text := "answered " + rq.Label
+				if prev, ok := lastAnswer[id]; ok && prev != string(a.Answer) {
+					rq.Switches++
+					text = "changed answer to " + rq.Label
+				}
+				lastAnswer[id] = string(a.Answer)
+				r.Events = append(r.Events, ReplayEvent{at, text})
+			}
+		case "page_blurred":
+			charge(at)
+			focused = false
+			r.Events = append(r.Events, ReplayEvent{at, "left the quiz page"})
+		case "page_focused":
+			charge(at)
+			focused = true
+			r.Events = append(r.Events, ReplayEvent{at, "returned to the quiz page"})
+		case "question_flagged":
+			r.Events = append(r.Events, ReplayEvent{at, "flagged a question"})
+		case "session_started":
+			r.Events = append(r.Events, ReplayEvent{at, "started the attempt"})
+		}
+	}
+	charge(r.Duration)
+	for _, id := range order {
+		r.Questions = append(r.Questions, *stats[id])
+	}
+	return r
+}
+
+// formatClock renders a duration as m:ss, or h:mm:ss from an hour up.
+func formatClock(d time.Duration) string {
+	sec := int(d.Round(time.Second) / time.Second)
+	if sec >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", sec/3600, sec%3600/60, sec%60)
+	}
+	return fmt.Sprintf("%d:%02d", sec/60, sec%60)
+}
+
+// ApplyTags adds user topic tags to questions, looked up by item id, then question ID
+// (ContentID), then question number.
+func (doc *QuizDoc) ApplyTags(tags map[string][]string) {
+	for i := range doc.Questions {
+		q := &doc.Questions[i]
+		extra, ok := tags[q.ItemID]
+		if !ok {
+			extra, ok = tags[q.ContentID]
+		}
+		if !ok {
+			extra = tags[fmt.Sprint(q.Number)]
+		}
+		for _, t := range extra {
+			if t = strings.TrimSpace(t); t != "" {
+				q.Tags = append(q.Tags, t)
+			}
+		}
+	}
+}
+
+// DocPart is one document of a split output; Key names it and suffixes its file name.
+type DocPart struct {
+	Key string
+	Doc QuizDoc
+}
+
+// SplitDoc partitions the questions by the keys each one has, in order of first use; a
+// question with several keys lands in several parts, and one with none in "untagged".
+// Question numbers are kept so parts still match the original quiz. Each part's title
+// gains its key, its glossary is narrowed to its questions, and the attempt replay, which
+// covers the whole attempt, is left out.
+func SplitDoc(doc QuizDoc, keys func(Question) []string, none string) []DocPart {
+	var parts []DocPart
+	index := map[string]int{}
+	for _, q := range doc.Questions {
+		ks := keys(q)
+		if len(ks) == 0 {
+			ks = []string{none}
+		}
+		for _, k := range ks {
+			i, ok := index[k]
+			if !ok {
+				i = len(parts)
+				index[k] = i
+				part := doc
+				part.Title = fmt.Sprintf("%s (%s)", doc.Title, k)
+				part.Questions = nil
+				part.Replay = nil
+				parts = append(parts, DocPart{Key: k, Doc: part})
+			}
+			if qs := parts[i].Doc.Questions; len(qs) == 0 || qs[len(qs)-1].Number != q.Number {
+				parts[i].Doc.Questions = append(qs, q)
+			}
+		}
+	}
+	for i := range parts {
+		parts[i].Doc.Glossary = narrowGlossary(doc.Glossary, parts[i].Doc.Questions)
+	}
+	return parts
+}
+
+// narrowGlossary keeps the glossary entries used by the given questions.
+func narrowGlossary(glossary []GlossaryEntry, questions []Question) []GlossaryEntry {
+	kept := map[int]bool{}
+	for _, q := range questions {
+		kept[q.Number] = true
+	}
+	var out []GlossaryEntry
+	for _, e := range glossary {
+		var nums []int
+		for _, n := range e.Questions {
+			if kept[n] {
+				nums = append(nums, n)
+			}
+		}
+		if len(nums) > 0 {
+			e.Questions = nums
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// contentID derives a question's stable id from its stem and the set of its choice labels,
+// compared case-insensitively with whitespace collapsed. Unlike the Canvas item id it survives
+// a copy of the quiz to another course, a new attempt and reshuffled choices, so it is the
+// anchor other documents link to.
+func contentID(q Question) string {
+	norm := func(s string) string { return strings.Join(strings.Fields(strings.ToLower(s)), " ") }
+	labels := make([]string, len(q.Options))
+	for i, o := range q.Options {
+		labels[i] = norm(o.Label)
+	}
+	sort.Strings(labels)
+	sum := sha256.Sum256([]byte(norm(q.Text) + "\x00" + strings.Join(labels, "\x00")))
+	return "q-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// assignContentIDs sets ContentID on every question. The builders call it before redaction
+// and -debug-ids touch the text, so neither changes the ids.
+func (doc *QuizDoc) assignContentIDs() {
+	for i := range doc.Questions {
+		doc.Questions[i].ContentID = contentID(doc.Questions[i])
+	}
+}
+
+// AppendDebugIDs tags question text with the item id and interaction slug, and each choice
+// and blank with its id, so a suspicious answer can be traced back to the raw JSON (-debug-ids).
+func (doc *QuizDoc) AppendDebugIDs() {
+	for i := range doc.Questions {
+		q := &doc.Questions[i]
+		ids := []string{"item " + q.ItemID}
+		if q.Slug != "" {
+			ids = append(ids, q.Slug)
+		}
+		q.Text += " [" + strings.Join(ids, ", ") + "]"
+		for j := range q.Options {
+			if id := q.Options[j].ID; id != "" {
+				q.Options[j].Label += " [choice " + id + "]"
+			}
+		}
+		for j := range q.Blanks {
+			if id := q.Blanks[j].ID; id != "" {
+				q.Blanks[j].Label += " [blank " + id + "]"
+			}
+		}
+	}
+}
+
+// FilterResponses keeps the questions matching any of kinds: "unanswered" (left blank) or
+// "incorrect" (answered, but scored below the points possible).
+func (doc *QuizDoc) FilterResponses(kinds []string) {
+	want := map[string]bool{}
+	for _, k := range kinds {
+		want[strings.TrimSpace(k)] = true
+	}
+	var kept []Question
+	for _, q := range doc.Questions {
+		incorrect := !q.Unanswered && q.Earned != nil && *q.Earned < q.Possible
+		if (want["unanswered"] && q.Unanswered) || (want["incorrect"] && incorrect) {
+			kept = append(kept, q)
+		}
+	}
+	doc.Questions = kept
+}
+
+// FilterBanks keeps only questions drawn from one of the named banks (case-insensitive).
+// Question numbers are left as-is so they still match the original quiz.
+func (doc *QuizDoc) FilterBanks(banks []string) {
+	if len(banks) == 0 {
+		return
+	}
+	want := map[string]bool{}
+	for _, b := range banks {
+		want[strings.ToLower(strings.TrimSpace(b))] = true
+	}
+	var kept []Question
+	for _, q := range doc.Questions {
+		if want[strings.ToLower(q.Bank)] {
+			kept = append(kept, q)
+		}
+	}
+	doc.Questions = kept
+}
+
+// FilterTypes keeps only questions of the named types (see questionType). Names match ignoring
+// case, with dashes, underscores and slashes read as spaces, so "true-false" selects
+// "true/false" questions and "multiple-choice" "multiple choice" ones. Question numbers are
+// left as-is.
+func (doc *QuizDoc) FilterTypes(types []string) {
+	if len(types) == 0 {
+		return
+	}
+	key := func(s string) string {
+		return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return r == ' ' || r == '-' || r == '_' || r == '/'
+		}), " ")
+	}
+	want := map[string]bool{}
+	for _, t := range types {
+		want[key(t)] = true
+	}
+	var kept []Question
+	for _, q := range doc.Questions {
+		if want[key(q.Type)] {
+			kept = append(kept, q)
+		}
+	}
+	doc.Questions = kept
+}
+
+// FilterNumbers keeps only the questions with these numbers, in their document order.
+func (doc *QuizDoc) FilterNumbers(numbers []int) {
+	if len(numbers) == 0 {
+		return
+	}
+	var kept []Question
+	for _, q := range doc.Questions {
+		if slices.Contains(numbers, q.Number) {
+			kept = append(kept, q)
+		}
+	}
+	doc.Questions = kept
+}
+
+// Labels translates the fixed labels of the md, txt and html documents, keyed by their
+// English text (see LabelKeys), so a document can match the course language. Any other key,
+// such as the "Navigation" of the quiz details, is translated the same way. A label without
+// an entry stays in English; a nil Labels renders English throughout.
+type Labels map[string]string
+
+// t is the translation of the English label s, or s itself.
+func (l Labels) t(s string) string {
+	if v := l[s]; v != "" {
+		return v
+	}
+	return s
+}
+
+// Translate is the translation of the English label s, or s itself, for the labels the
+// command adds, such as the "Questions and Solutions" of the document title.
+func (l Labels) Translate(s string) string {
+	return l.t(s)
+}
+
+// blankPlaceholder matches the placeholder the builders put in question text for a blank,
+// "[Blank 1]"; its submatch is the blank's number.
+var blankPlaceholder = regexp.MustCompile(`\[Blank (\d+)\]`)
+
+// blankLabel matches a blank's label, "Blank 1", at the start of the label or of a response
+// or answer written as "Blank 1: ...".
+var blankLabel = regexp.MustCompile(`^Blank (\d+)(:|$)`)
+
+// translateBlanks returns doc with the word "Blank" of its blank labels in the language of
+// doc.Labels: in the question text, on the blanks and in the "Blank 1: ..." responses and
+// answers. The questions are copied, so doc itself is unchanged.
+func (doc QuizDoc) translateBlanks() QuizDoc {
+	word := doc.Labels.t("Blank")
+	if word == "Blank" {
+		return doc
+	}
+	relabel := func(re *regexp.Regexp, s, format string) string {
+		return re.ReplaceAllStringFunc(s, func(m string) string {
+			sub := re.FindStringSubmatch(m)
+			return fmt.Sprintf(format, word, sub[1]) + strings.Join(sub[2:], "")
+		})
+	}
+	list := func(in []string) []string {
+		out := make([]string, len(in))
+		for i, s := range in {
+			out[i] = relabel(blankLabel, s, "%s %s")
+		}
+		return out
+	}
+	questions := make([]Question, len(doc.Questions))
+	for i, q := range doc.Questions {
+		q.Text = relabel(blankPlaceholder, q.Text, "[%s %s]")
+		q.Blanks = append([]BlankAnswer(nil), q.Blanks...)
+		for j := range q.Blanks {
+			q.Blanks[j].Label = relabel(blankLabel, q.Blanks[j].Label, "%s %s")
+		}
+		if len(q.Blanks) > 0 {
+			q.Answers, q.Responses = list(q.Answers), list(q.Responses)
+		}
+		questions[i] = q
+	}
+	doc.Questions = questions
+	return doc
+}
+
+// LabelKeys are the English labels the renderers look up in Labels, in the order a document
+// shows them.
+var LabelKeys = []string{
+	"Points possible", "Time limit", "Allowed attempts", "Due", "Attempt", "Submitted",
+	"Bank", "Appeared in", "Media", "Tags", "Response", "left blank", "Formula", "Key",
+	"Your answer", "Correct answer", "Score", "Options", "correct", "Word bank",
+	"Blanks and answers", "Submission", "Files", "Rubric", "Matches", "Categories",
+	"Correct order", "Your order", "Distractors", "Your response", "no response",
+	"Correct answers", "Answer", "answer unavailable", "Class", "Explanation", "Instructor",
+	"Group", "Question", "References", "Blank",
+	// The command's document titles: "WK12 Quiz — Questions and Solutions".
+	"Quiz", "Questions and Solutions", "Practice Questions", "Questions", "Answer Key",
+	"Item Analysis",
+}
+
+// builtinLabels are the catalogs -lang chooses from, by language code.
+var builtinLabels = map[string]Labels{
+	"de": {
+		"Points possible": "Mögliche Punkte", "Time limit": "Zeitlimit", "Allowed attempts": "Erlaubte Versuche",
+		"Due": "Fällig", "Attempt": "Versuch", "Submitted": "Abgegeben",
+		"Bank": "Fragenpool", "Appeared in": "Vorgekommen in", "Media": "Medien", "Tags": "Schlagwörter",
+		"Response": "Antwort", "left blank": "leer gelassen", "Formula": "Formel", "Key": "Lösung",
+		"Your answer": "Deine Antwort", "Correct answer": "Richtige Antwort", "Score": "Punkte",
+		"Options": "Optionen", "correct": "richtig", "Word bank": "Wortliste",
+		"Blanks and answers": "Lücken und Antworten", "Submission": "Abgabe", "Files": "Dateien",
+		"Rubric": "Bewertungsraster", "Matches": "Zuordnungen", "Categories": "Kategorien",
+		"Correct order": "Richtige Reihenfolge", "Your order": "Deine Reihenfolge",
+		"Distractors": "Distraktoren", "Your response": "Deine Antwort", "no response": "keine Antwort",
+		"Correct answers": "Richtige Antworten", "Answer": "Antwort",
+		"answer unavailable": "Antwort nicht verfügbar", "Class": "Kurs", "Explanation": "Erklärung",
+		"Instructor": "Lehrkraft", "Group": "Gruppe", "Question": "Frage", "References": "Quellen",
+		"Blank": "Lücke", "Quiz": "Quiz", "Questions and Solutions": "Fragen und Lösungen",
+		"Practice Questions": "Übungsfragen", "Questions": "Fragen", "Answer Key": "Lösungsschlüssel",
+		"Item Analysis": "Aufgabenanalyse",
+	},
+	"es": {
+		"Points possible": "Puntos posibles", "Time limit": "Tiempo límite", "Allowed attempts": "Intentos permitidos",
+		"Due": "Fecha de entrega", "Attempt": "Intento", "Submitted": "Entregado",
+		"Bank": "Banco", "Appeared in": "Apareció en", "Media": "Multimedia", "Tags": "Etiquetas",
+		"Response": "Respuesta", "left blank": "en blanco", "Formula": "Fórmula", "Key": "Clave",
+		"Your answer": "Tu respuesta", "Correct answer": "Respuesta correcta", "Score": "Puntuación",
+		"Options": "Opciones", "correct": "correcta", "Word bank": "Banco de palabras",
+		"Blanks and answers": "Espacios y respuestas", "Submission": "Entrega", "Files": "Archivos",
+		"Rubric": "Rúbrica", "Matches": "Emparejamientos", "Categories": "Categorías",
+		"Correct order": "Orden correcto", "Your order": "Tu orden",
+		"Distractors": "Distractores", "Your response": "Tu respuesta", "no response": "sin respuesta",
+		"Correct answers": "Respuestas correctas", "Answer": "Respuesta",
+		"answer unavailable": "respuesta no disponible", "Class": "Clase", "Explanation": "Explicación",
+		"Instructor": "Profesor", "Group": "Grupo", "Question": "Pregunta", "References": "Referencias",
+		"Blank": "Espacio", "Quiz": "Cuestionario", "Questions and Solutions": "Preguntas y soluciones",
+		"Practice Questions": "Preguntas de práctica", "Questions": "Preguntas", "Answer Key": "Clave de respuestas",
+		"Item Analysis": "Análisis de ítems",
+	},
+	"fr": {
+		"Points possible": "Points possibles", "Time limit": "Durée limite", "Allowed attempts": "Tentatives autorisées",
+		"Due": "Date limite", "Attempt": "Tentative", "Submitted": "Rendu",
+		"Bank": "Banque", "Appeared in": "Paru dans", "Media": "Médias", "Tags": "Étiquettes",
+		"Response": "Réponse", "left blank": "laissée vide", "Formula": "Formule", "Key": "Corrigé",
+		"Your answer": "Votre réponse", "Correct answer": "Bonne réponse", "Score": "Note",
+		"Options": "Choix", "correct": "correct", "Word bank": "Banque de mots",
+		"Blanks and answers": "Blancs et réponses", "Submission": "Copie rendue", "Files": "Fichiers",
+		"Rubric": "Grille d'évaluation", "Matches": "Associations", "Categories": "Catégories",
+		"Correct order": "Ordre correct", "Your order": "Votre ordre",
+		"Distractors": "Distracteurs", "Your response": "Votre réponse", "no response": "aucune réponse",
+		"Correct answers": "Bonnes réponses", "Answer": "Réponse",
+		"answer unavailable": "réponse indisponible", "Class": "Classe", "Explanation": "Explication",
+		"Instructor": "Enseignant", "Group": "Groupe", "Question": "Question", "References": "Références",
+		"Blank": "Blanc", "Quiz": "Quiz", "Questions and Solutions": "Questions et solutions",
+		"Practice Questions": "Questions d'entraînement", "Questions": "Questions", "Answer Key": "Corrigé",
+		"Item Analysis": "Analyse des items",
+	},
+	"pt": {
+		"Points possible": "Pontos possíveis", "Time limit": "Tempo limite", "Allowed attempts": "Tentativas permitidas",
+		"Due": "Prazo", "Attempt": "Tentativa", "Submitted": "Enviado",
+		"Bank": "Banco", "Appeared in": "Apareceu em", "Media": "Mídia", "Tags": "Etiquetas",
+		"Response": "Resposta", "left blank": "em branco", "Formula": "Fórmula", "Key": "Gabarito",
+		"Your answer": "Sua resposta", "Correct answer": "Resposta correta", "Score": "Pontuação",
+		"Options": "Opções", "correct": "correta", "Word bank": "Banco de palavras",
+		"Blanks and answers": "Lacunas e respostas", "Submission": "Envio", "Files": "Arquivos",
+		"Rubric": "Rubrica", "Matches": "Correspondências", "Categories": "Categorias",
+		"Correct order": "Ordem correta", "Your order": "Sua ordem",
+		"Distractors": "Distratores", "Your response": "Sua resposta", "no response": "sem resposta",
+		"Correct answers": "Respostas corretas", "Answer": "Resposta",
+		"answer unavailable": "resposta indisponível", "Class": "Turma", "Explanation": "Explicação",
+		"Instructor": "Professor", "Group": "Grupo", "Question": "Questão", "References": "Referências",
+		"Blank": "Lacuna", "Quiz": "Questionário", "Questions and Solutions": "Questões e soluções",
+		"Practice Questions": "Questões de prática", "Questions": "Questões", "Answer Key": "Gabarito",
+		"Item Analysis": "Análise de itens",
+	},
+}
+
+// LabelsFor returns the built-in catalog of a language, by code: "es", "es-MX" and "ES" all
+// give the Spanish one. English ("en" or "") needs none and gives nil. ok is false for a
+// language without a catalog (see Languages).
+func LabelsFor(lang string) (l Labels, ok bool) {
+	code, _, _ := strings.Cut(strings.ToLower(strings.ReplaceAll(lang, "_", "-")), "-")
+	if code == "" || code == "en" {
+		return nil, true
+	}
+	l, ok = builtinLabels[code]
+	return maps.Clone(l), ok
+}
+
+// Languages lists the codes LabelsFor accepts, sorted, English included.
+func Languages() []string {
+	codes := []string{"en"}
+	for code := range builtinLabels {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// RenderMarkdown renders the document in the original study-sheet Markdown layout.
+func RenderMarkdown(doc QuizDoc) string {
+	return renderMarkdown(doc, nil)
+}
+
+// ObsidianOptions are the frontmatter fields of RenderObsidian that the document doesn't hold.
+type ObsidianOptions struct {
+	Course string // course name; left out when empty
+	Week   string // quiz label, e.g. "WK12"; also added as a tag
+	Date   string // e.g. "2026-10-16"
+}
+
+// RenderObsidian renders the Markdown layout for a notes vault such as Obsidian or Notion:
+// YAML frontmatter (title, course, week, score, date and tags) leads the document, and each
+// question's answer is folded into a collapsed "> [!answer]-" callout, so it stays hidden
+// until clicked. The options of choice questions are also listed above it, without marks.
+func RenderObsidian(doc QuizDoc, opts ObsidianOptions) string {
+	return renderMarkdown(doc, &opts)
+}
+
+// RenderStudyNotes renders doc as study notes rather than a quiz: each question becomes a
+// statement with its answer worked in (see studyStatement), followed by an empty "Why:" line
+// to explain it in one's own words. The notes are grouped by topic (see studyTopic), in the
+// order the topics first come up, and leave out the options, scores and feedback.
+func RenderStudyNotes(doc QuizDoc) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# %s\n\n", doc.Title))
+	sb.WriteString("_Study notes: each question is written out with its answer. Fill in the Why: lines in your own words._\n")
+	var topics []string
+	byTopic := map[string][]Question{}
+	for _, q := range doc.Questions {
+		t := studyTopic(q)
+		if _, ok := byTopic[t]; !ok {
+			topics = append(topics, t)
+		}
+		byTopic[t] = append(byTopic[t], q)
+	}
+	for _, t := range topics {
+		sb.WriteString(fmt.Sprintf("\n## %s\n\n", t))
+		for _, q := range byTopic[t] {
+			statement, details := studyStatement(q)
+			sb.WriteString(fmt.Sprintf("- Q%d: %s\n", q.Number, statement))
+			for _, d := range details {
+				sb.WriteString("  - " + d + "\n")
+			}
+			sb.WriteString("  - Why:\n")
+		}
+	}
+	return sb.String()
+}
+
+// studyTitleNumber matches the numbering of an item title, e.g. " 3", " #3", " (3)" or " - Q3".
+var studyTitleNumber = regexp.MustCompile(`(?i)\s*(?:[-–—:]\s*)?(?:q(?:uestion)?\s*)?[#(]?\d+[)]?$`)
+
+// studyTopic is the topic RenderStudyNotes files q under: its item title without numbering,
+// up to a colon or dash ("Dynamic programming: knapsack 2" is "Dynamic programming"), or
+// failing that its first topic tag. Titles that are only numbering, such as "Question 3",
+// say nothing, and such questions go under "General".
+func studyTopic(q Question) string {
+	title := strings.TrimSpace(studyTitleNumber.ReplaceAllString(q.Title, ""))
+	for _, sep := range []string{": ", " - ", " – ", " — "} {
+		if before, _, ok := strings.Cut(title, sep); ok {
+			title = strings.TrimSpace(before)
+		}
+	}
+	if title != "" && !strings.EqualFold(title, "question") && !strings.EqualFold(title, "item") {
+		return title
+	}
+	for _, t := range q.Tags {
+		if !strings.Contains(t, ":") {
+			return t
+		}
+	}
+	return "General"
+}
+
+// studyStatement rewrites q as a statement with its answer in bold: blanks are filled in,
+// and otherwise the answer follows the question, e.g. "Soak testing is used to: **find
+// leaks**". A true/false question is the statement itself, or its negation when false.
+// Matching, ordering and categorization answers come back as details, one line each.
+// Questions without a key keep their text and say so.
+func studyStatement(q Question) (string, []string) {
+	text := strings.Join(strings.Fields(q.Text), " ")
+	bold := func(s string) string { return "**" + s + "**" }
+	var details []string
+	for _, m := range q.Matches {
+		if m.Answer != "" {
+			details = append(details, m.Prompt+": "+bold(m.Answer))
+		}
+	}
+	for _, c := range q.Categories {
+		if len(c.Members) > 0 {
+			details = append(details, c.Name+": "+bold(strings.Join(c.Members, ", ")))
+		}
+	}
+	if len(q.Order) > 0 {
+		details = append(details, "In order: "+bold(strings.Join(q.Order, " → ")))
+	}
+	switch {
+	case q.OpenEntry && len(q.Blanks) > 0:
+		filled := blankPlaceholder.ReplaceAllStringFunc(text, func(m string) string {
+			i, _ := strconv.Atoi(blankPlaceholder.FindStringSubmatch(m)[1])
+			if i < 1 || i > len(q.Blanks) {
+				return m
+			}
+			b := q.Blanks[i-1]
+			answer := b.Answer
+			if answer == "" && len(b.Accepted) > 0 {
+				answer = b.Accepted[0]
+			}
+			if answer == "" {
+				return "____"
+			}
+			return bold(answer)
+		})
+		return filled, details
+	case q.Type == "true/false" && len(q.Answers) == 1:
+		if strings.EqualFold(q.Answers[0], "false") {
+			return bold("Not true:") + " " + text, details
+		}
+		return text, details
+	case len(q.Answers) > 0:
+		stem := strings.TrimSuffix(text, "?")
+		if !strings.HasSuffix(stem, ":") {
+			stem += ":"
+		}
+		return stem + " " + bold(strings.Join(q.Answers, "; ")), details
+	case len(details) > 0:
+		return text, details
+	}
+	return text + " _(no answer key)_", details
+}
+
+// renderMarkdown renders RenderMarkdown's layout, or with obsidian set RenderObsidian's.
+func renderMarkdown(doc QuizDoc, obsidian *ObsidianOptions) string {
+	var sb strings.Builder
+	doc = doc.translateBlanks()
+	l := doc.Labels
+	if obsidian != nil {
+		writeObsidianFrontmatter(&sb, doc, *obsidian)
+	}
+	sb.WriteString(fmt.Sprintf("# %s\n\n", doc.Title))
+	if len(doc.Details) > 0 {
+		for _, d := range doc.Details {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t(d.Label), d.Value))
+		}
+		sb.WriteString("\n")
+	}
+	if len(doc.Description) > 0 {
+		sb.WriteString("> " + strings.Join(doc.Description, "\n>\n> ") + "\n\n")
+	}
+	if len(doc.Comments) > 0 {
+		sb.WriteString("Instructor comments on this submission:\n\n")
+		writeMarkdownComments(&sb, doc.Comments, l)
+	}
+	if note := doc.notice(); note != "" {
+		sb.WriteString("_" + note + "_\n\n")
+	}
+
+	for i, q := range doc.Questions {
+		var prev *Question
+		if i > 0 {
+			prev = &doc.Questions[i-1]
+		}
+		if groupChange(prev, &q) {
+			if q.Group != nil {
+				sb.WriteString(fmt.Sprintf("## %s: %s\n\n", l.t("Group"), q.Group.Title))
+				if rule := q.Group.Rule(); rule != "" {
+					sb.WriteString(fmt.Sprintf("_Questions drawn at random: %s._\n\n", rule))
+				}
+			} else {
+				sb.WriteString("---\n\n")
+			}
+		} else if stimulusChange(prev, &q) && q.Stimulus == nil {
+			sb.WriteString("---\n\n")
+		}
+		if q.Stimulus != nil && (groupChange(prev, &q) || stimulusChange(prev, &q)) {
+			sb.WriteString(fmt.Sprintf("## %s\n\n", q.Stimulus.Heading()))
+			if len(q.Stimulus.Text) > 0 {
+				sb.WriteString("> " + strings.Join(q.Stimulus.Text, "\n>\n> ") + "\n\n")
+			}
+			before := sb.Len()
+			writeMarkdownImages(&sb, q.Stimulus.HTML, doc.Assets, "")
+			if sb.Len() > before {
+				sb.WriteString("\n")
+			}
+		}
+		heading, body := markdownStem(q)
+		sb.WriteString(fmt.Sprintf("<a id=\"%s\"></a>\n\n## %d) %s\n", q.ContentID, q.Number, heading))
+		if len(body) > 0 {
+			sb.WriteString("\n" + strings.Join(body, "\n\n") + "\n\n")
+		}
+		if q.Bank != "" {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Bank"), q.Bank))
+		}
+		if len(q.Quizzes) > 0 {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Appeared in"), strings.Join(q.Quizzes, ", ")))
+		}
+		for _, m := range q.Media {
+			sb.WriteString(fmt.Sprintf("- %s: [%s](%s)\n", l.t("Media"), m.Label(), m.URL))
+		}
+		writeMarkdownImages(&sb, q.BodyHTML, doc.Assets, "- Image: ")
+		if len(q.Tags) > 0 && obsidian != nil {
+			var tags []string
+			for _, t := range q.Tags {
+				tags = append(tags, "#"+obsidianTag(t))
+			}
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Tags"), strings.Join(tags, " ")))
+		} else if len(q.Tags) > 0 {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Tags"), strings.Join(q.Tags, ", ")))
+		}
+		if q.Unanswered {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Response"), l.t("left blank")))
+		}
+		if q.Formula != "" {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Formula"), q.Formula))
+		}
+		if doc.AnnotateKeys && q.KeyConfidence != "" {
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Key"), q.KeyConfidence))
+		}
+		if c, ok := q.CheckResponse(); ok && doc.ShowResponses {
+			sb.WriteString(fmt.Sprintf("- %s: %s %s\n", l.t("Your answer"), c.Mark(), c.Response))
+			if c.Key != "" {
+				sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Correct answer"), c.Key))
+			}
+			sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Score"), c.Score))
+		}
+
+		// Surveys and practice sheets have no key to hide.
+		if obsidian == nil || doc.Practice || !q.HasResult || q.Ungraded {
+			writeMarkdownAnswer(&sb, doc, q, nil)
+			continue
+		}
+		var stem, answer strings.Builder
+		writeMarkdownAnswer(&answer, doc, q, &stem)
+		sb.WriteString("\n" + stem.String())
+		sb.WriteString("> [!answer]- " + l.t("Answer") + "\n")
+		for _, line := range strings.Split(strings.TrimRight(answer.String(), "\n"), "\n") {
+			if line == "" {
+				sb.WriteString(">\n")
+			} else {
+				sb.WriteString("> " + line + "\n")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	if refs := doc.References(); len(refs) > 0 {
+		sb.WriteString("## " + l.t("References") + "\n\n")
+		for _, r := range refs {
+			sb.WriteString(fmt.Sprintf("- [%s](%s) — %s\n", r.Text, r.URL, r.citedBy(plainCite)))
+		}
+		sb.WriteString("\n")
+	}
+	writeMarkdownGlossary(&sb, doc.Glossary)
+	writeMarkdownReplay(&sb, doc.Replay)
+	return sb.String()
+}
+
+// writeObsidianFrontmatter writes RenderObsidian's YAML frontmatter. Strings are written as
+// JSON strings, which YAML reads as double-quoted scalars. The score is left out when no
+// question has one.
+func writeObsidianFrontmatter(sb *strings.Builder, doc QuizDoc, opts ObsidianOptions) {
+	quote := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	sb.WriteString("---\n")
+	sb.WriteString("title: " + quote(doc.Title) + "\n")
+	if opts.Course != "" {
+		sb.WriteString("course: " + quote(opts.Course) + "\n")
+	}
+	if opts.Week != "" {
+		sb.WriteString("week: " + quote(opts.Week) + "\n")
+	}
+	var earned, possible float64
+	scored := false
+	for _, q := range doc.Questions {
+		if q.Earned != nil && !q.Ungraded {
+			earned += *q.Earned
+			possible += q.Possible
+			scored = true
+		}
+	}
+	if scored {
+		sb.WriteString(fmt.Sprintf("score: %s\npoints_possible: %s\n", FormatPoints(RoundTo(earned, 2)), FormatPoints(RoundTo(possible, 2))))
+	}
+	if opts.Date != "" {
+		sb.WriteString("date: " + opts.Date + "\n")
+	}
+	tags := []string{"quiz"}
+	if opts.Week != "" {
+		tags = append(tags, obsidianTag(strings.ToLower(opts.Week)))
+	}
+	for _, q := range doc.Questions {
+		for _, t := range q.Tags {
+			if t := obsidianTag(t); !slices.Contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+	}
+	sb.WriteString("tags:\n")
+	for _, t := range tags {
+		sb.WriteString("  - " + t + "\n")
+	}
+	sb.WriteString("---\n\n")
+}
+
+// obsidianTag turns a question tag into an Obsidian tag: the ":" of a classification tag
+// nests it ("bloom:apply" → "bloom/apply"), spaces become dashes, and other characters tags
+// can't hold are dropped.
+func obsidianTag(s string) string {
+	s = strings.ReplaceAll(strings.Join(strings.Fields(s), "-"), ":", "/")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '/' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// writeMarkdownAnswer writes what follows a question's heading and details in RenderMarkdown:
+// its options, the answer key or responses, and the explanation. With stem set, the parts of
+// the question itself (the options, without marks, and the word bank) go there instead, for
+// RenderObsidian to show above the folded answer.
+func writeMarkdownAnswer(sb *strings.Builder, doc QuizDoc, q Question, stem *strings.Builder) {
+	l := doc.Labels
+	head := sb
+	if stem != nil {
+		head = stem
+	}
+	if !q.HasResult {
+		sb.WriteString(fmt.Sprintf("- %s: (no result data)\n\n", l.t("Options")))
+		writeMarkdownExplanation(sb, q, l)
+		return
+	}
+
+	if q.OpenEntry && !q.Ungraded {
+		if len(q.WordBank) > 0 {
+			head.WriteString(fmt.Sprintf("- %s:\n", l.t("Word bank")))
+			for _, w := range q.WordBank {
+				head.WriteString(fmt.Sprintf("  - %s\n", w))
+			}
+			head.WriteString("\n")
+		} else {
+			head.WriteString(fmt.Sprintf("- %s: N/A (open entry)\n\n", l.t("Options")))
+		}
+		sb.WriteString(fmt.Sprintf("- %s:\n", l.t("Blanks and answers")))
+		for _, b := range q.Blanks {
+			ans := b.Answer
+			if ans == "" {
+				ans = "(" + l.t("answer unavailable") + ")"
+			}
+			if b.Example {
+				ans += " (example match)"
+			}
+			if len(b.Accepted) > 1 {
+				ans += fmt.Sprintf(" (also accepted: %s)", strings.Join(b.Accepted[1:], ", "))
+			}
+			sb.WriteString(fmt.Sprintf("  - %s: %s\n", b.Label, ans))
+			if b.Rule != "" {
+				sb.WriteString(fmt.Sprintf("    - Matching: %s\n", b.Rule))
+			}
+			if b.Pattern != "" {
+				sb.WriteString(fmt.Sprintf("    - Pattern: `%s`\n", b.Pattern))
+				if b.Meaning != "" {
+					sb.WriteString(fmt.Sprintf("    - Reads as: %s\n", b.Meaning))
+				}
+			}
+		}
+		sb.WriteString("\n")
+		writeMarkdownExplanation(sb, q, l)
+		return
+	}
+
+	if q.Essay {
+		head.WriteString(fmt.Sprintf("- %s: N/A (essay)\n\n", l.t("Options")))
+		writeMarkdownSubmission(sb, q, !doc.ShowResponses, l)
+		writeMarkdownRubric(sb, q.Rubric, l)
+		writeMarkdownExplanation(sb, q, l)
+		return
+	}
+
+	if len(q.Matches) > 0 {
+		writeMarkdownMatches(sb, q, doc.Practice, l)
+		writeMarkdownExplanation(sb, q, l)
+		return
+	}
+
+	if len(q.Categories) > 0 {
+		writeMarkdownCategories(sb, q, doc.Practice, l)
+		writeMarkdownExplanation(sb, q, l)
+		return
+	}
+
+	if len(q.Order) > 0 && !q.Ungraded {
+		writeMarkdownOrder(sb, q, l)
+		writeMarkdownExplanation(sb, q, l)
+		return
+	}
+
+	if q.Ungraded {
+		if len(q.Options) > 0 {
+			switch {
+			case doc.Practice:
+				sb.WriteString(fmt.Sprintf("- %s:\n", l.t("Options")))
+			case q.Scale:
+				sb.WriteString("- Scale (ungraded):\n")
+			default:
+				sb.WriteString("- Options (ungraded):\n")
+			}
+			for _, o := range q.Options {
+				if o.Selected {
+					sb.WriteString(fmt.Sprintf("  - %s (your response)\n", o.Label))
+				} else {
+					sb.WriteString(fmt.Sprintf("  - %s\n", o.Label))
+				}
+			}
+			sb.WriteString("\n")
+		} else if q.OpenEntry {
+			sb.WriteString(fmt.Sprintf("- %s: N/A (open entry)\n\n", l.t("Options")))
+		}
+		switch {
+		case doc.Practice:
+		case len(q.Responses) > 0:
+			sb.WriteString(fmt.Sprintf("- %s: %s\n\n", l.t("Your response"), strings.Join(q.Responses, ", ")))
+		default:
+			sb.WriteString(fmt.Sprintf("- %s: (%s)\n\n", l.t("Your response"), l.t("no response")))
+		}
+		writeMarkdownExplanation(sb, q, l)
+		return
+	}
+
+	if len(q.Passage) > 0 {
+		sb.WriteString("- Passage (correct selections in bold):\n\n  > ")
+		for _, sp := range q.Passage {
+			if sp.Correct {
+				sb.WriteString("**" + sp.Text + "**")
+			} else {
+				sb.WriteString(sp.Text)
+			}
+		}
+		sb.WriteString("\n\n")
+	}
+
+	var footnotes []string
+	if len(q.Options) > 0 && stem != nil {
+		// Footnotes would give the key away, so the feedback is folded with it.
+		stem.WriteString(fmt.Sprintf("- %s:\n", l.t("Options")))
+		for _, o := range q.Options {
+			stem.WriteString(fmt.Sprintf("  - %s\n", o.Label))
+			if o.Feedback != "" {
+				footnotes = append(footnotes, fmt.Sprintf("- %s: %s\n", o.Label, o.Feedback))
+			}
+		}
+		stem.WriteString("\n")
+	} else if len(q.Options) > 0 {
+		sb.WriteString(fmt.Sprintf("- %s:\n", l.t("Options")))
+		for i, o := range q.Options {
+			line := "  - " + o.Label
+			if o.Correct {
+				line += " (" + l.t("correct") + ")"
+			}
+			if o.Feedback != "" {
+				ref := fmt.Sprintf("[^q%d-%d]", q.Number, i+1)
+				line += " " + ref
+				footnotes = append(footnotes, fmt.Sprintf("%s: %s\n", ref, o.Feedback))
+			}
+			sb.WriteString(line + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	if q.Multi {
+		sb.WriteString(fmt.Sprintf("- %s:\n", l.t("Correct answers")))
+		for _, l := range q.Answers {
+			sb.WriteString(fmt.Sprintf("  - %s\n", l))
+		}
+		sb.WriteString("\n")
+	} else if len(q.Answers) == 1 {
+		sb.WriteString(fmt.Sprintf("- %s%s: %s\n\n", q.givenPrefix(), l.t("Answer"), q.Answers[0]))
+	} else {
+		sb.WriteString(fmt.Sprintf("- %s: (%s)\n\n", l.t("Answer"), l.t("answer unavailable")))
+	}
+	writeMarkdownExplanation(sb, q, l)
+
+	if len(footnotes) > 0 {
+		for _, f := range footnotes {
+			sb.WriteString(f)
+		}
+		sb.WriteString("\n")
+	}
+}
+
+// RenderMarkdownV1 renders the output version 1 layout: title, numbered questions, options
+// with "(correct)", and answer or blank lines only. Everything added since (quiz details,
+// comments, groups, banks, feedback footnotes, explanations, rubrics, word banks, matching
+// rules) is left out so version 1 parsers never see unfamiliar lines.
+func RenderMarkdownV1(doc QuizDoc) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# %s\n\n", doc.Title))
+	for _, q := range doc.Questions {
+		sb.WriteString(fmt.Sprintf("## %d) %s\n", q.Number, q.Text))
+		if !q.HasResult {
+			sb.WriteString("- Options: (no result data)\n\n")
+			continue
+		}
+		if q.OpenEntry && len(q.Blanks) > 0 {
+			sb.WriteString("- Options: N/A (open entry)\n\n")
+			sb.WriteString("- Blanks and answers:\n")
+			for _, b := range q.Blanks {
+				ans := b.Answer
+				if ans == "" {
+					ans = "(answer unavailable)"
+				}
+				sb.WriteString(fmt.Sprintf("  - %s: %s\n", b.Label, ans))
+			}
+			sb.WriteString("\n")
+			continue
+		}
+		if len(q.Options) > 0 {
+			sb.WriteString("- Options:\n")
+			for _, o := range q.Options {
+				if o.Correct {
+					sb.WriteString(fmt.Sprintf("  - %s (correct)\n", o.Label))
+				} else {
+					sb.WriteString(fmt.Sprintf("  - %s\n", o.Label))
+				}
+			}
+			sb.WriteString("\n")
+		}
+		if q.Multi {
+			sb.WriteString("- Correct answers:\n")
+			for _, l := range q.Answers {
+				sb.WriteString(fmt.Sprintf("  - %s\n", l))
+			}
+			sb.WriteString("\n")
+		} else if len(q.Answers) == 1 {
+			sb.WriteString(fmt.Sprintf("- Answer: %s\n\n", q.Answers[0]))
+		} else {
+			sb.WriteString("- Answer: (answer unavailable)\n\n")
+		}
+	}
+	return sb.String()
+}
+
+// MarkdownCell escapes text for use inside a Markdown table cell.
+func MarkdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+// writeMarkdownReplay renders the attempt replay appendix.
+func writeMarkdownReplay(sb *strings.Builder, r *AttemptReplay) {
+	if r == nil {
+		return
+	}
+	sb.WriteString("## Attempt replay\n\n")
+	sb.WriteString(fmt.Sprintf("Attempt length: %s.\n\n", formatClock(r.Duration)))
+	sb.WriteString("| Question | First viewed | Time spent | Answers | Switches |\n| --- | --- | --- | --- | --- |\n")
+	for _, q := range r.Questions {
+		sb.WriteString(fmt.Sprintf("| %s | %s | %s | %d | %d |\n", MarkdownCell(q.Label), formatClock(q.FirstViewed), formatClock(q.TimeSpent), q.Answers, q.Switches))
+	}
+	sb.WriteString("\nTimeline:\n\n")
+	for _, e := range r.Events {
+		sb.WriteString(fmt.Sprintf("- %s %s\n", formatClock(e.At), e.Text))
+	}
+	sb.WriteString("\n")
+}
+
+func writeHTMLReplay(sb *strings.Builder, r *AttemptReplay) {
+	if r == nil {
+		return
+	}
+	sb.WriteString("<section class=\"replay\">\n<h2>Attempt replay</h2>\n")
+	sb.WriteString(fmt.Sprintf("<p>Attempt length: %s.</p>\n", formatClock(r.Duration)))
+	sb.WriteString("<table class=\"rubric\">\n<thead><tr><th>Question</th><th>First viewed</th><th>Time spent</th><th>Answers</th><th>Switches</th></tr></thead>\n<tbody>\n")
+	for _, q := range r.Questions {
+		sb.WriteString(fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td><td>%d</td><td>%d</td></tr>\n", html.EscapeString(q.Label), formatClock(q.FirstViewed), formatClock(q.TimeSpent), q.Answers, q.Switches))
+	}
+	sb.WriteString("</tbody>\n</table>\n<ol class=\"timeline\">\n")
+	for _, e := range r.Events {
+		sb.WriteString(fmt.Sprintf("<li><time>%s</time> %s</li>\n", formatClock(e.At), html.EscapeString(e.Text)))
+	}
+	sb.WriteString("</ol>\n</section>\n")
+}
+
+// writeMarkdownSubmission writes what the student handed in for an essay, quoted, and the
+// files they uploaded, then the points awarded unless the response check already shows them.
+func writeMarkdownSubmission(sb *strings.Builder, q Question, score bool, l Labels) {
+	if len(q.Submission) > 0 {
+		sb.WriteString(fmt.Sprintf("- %s:\n", l.t("Submission")))
+		for i, p := range q.Submission {
+			if i > 0 {
+				sb.WriteString("  >\n")
+			}
+			sb.WriteString("  > " + p + "\n")
+		}
+	}
+	if len(q.Files) > 0 {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Files"), strings.Join(q.Files, ", ")))
+	}
+	if a := q.awarded(); a != "" && score {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", l.t("Score"), a))
+	}
+	if len(q.Submission) > 0 || len(q.Files) > 0 || q.awarded() != "" && score {
+		sb.WriteString("\n")
+	}
+}
+
+func writeMarkdownRubric(sb *strings.Builder, rubric []RubricCriterion, l Labels) {
+	if len(rubric) == 0 {
+		return
+	}
+	sb.WriteString(fmt.Sprintf("- %s:\n\n", l.t("Rubric")))
+	sb.WriteString("| Criterion | Points | Ratings | Assessed |\n| --- | --- | --- | --- |\n")
+	for _, c := range rubric {
+		var ratings []string
+		for _, r := range c.Ratings {
+			ratings = append(ratings, fmt.Sprintf("%s (%s)", r.Description, FormatPoints(r.Points)))
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %s | %s | %s |\n", MarkdownCell(c.Description), FormatPoints(c.Points), MarkdownCell(strings.Join(ratings, "; ")), MarkdownCell(c.Assessed.summary())))
+	}
+	sb.WriteString("\n")
+}
+
+// writeMarkdownMatches writes a matching question as a table of prompts, their correct
+// match and the student's, then the answers that match nothing. A practice sheet lists every
+// answer instead, to choose from.
+func writeMarkdownMatches(sb *strings.Builder, q Question, practice bool, l Labels) {
+	responses := !practice && len(q.Responses) > 0
+	sb.WriteString(fmt.Sprintf("- %s:\n\n", l.t("Matches")))
+	switch {
+	case practice:
+		sb.WriteString("| Prompt | Match |\n| --- | --- |\n")
+	case responses:
+		sb.WriteString("| Prompt | Correct match | Your match |\n| --- | --- | --- |\n")
+	default:
+		sb.WriteString("| Prompt | Correct match |\n| --- | --- |\n")
+	}
+	for _, m := range q.Matches {
+		answer := m.Answer
+		if answer == "" && !practice {
+			answer = "(" + l.t("answer unavailable") + ")"
+		}
+		row := fmt.Sprintf("| %s | %s |", MarkdownCell(m.Prompt), MarkdownCell(answer))
+		if responses {
+			row += fmt.Sprintf(" %s |", MarkdownCell(m.responseMark()))
+		}
+		sb.WriteString(row + "\n")
+	}
+	sb.WriteString("\n")
+	if practice && len(q.MatchAnswers) > 0 {
+		sb.WriteString(fmt.Sprintf("- Answers to choose from: %s\n\n", strings.Join(q.MatchAnswers, ", ")))
+	} else if d := q.Distractors(); len(d) > 0 {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n\n", l.t("Distractors"), strings.Join(d, ", ")))
+	}
+}
+
+// writeMarkdownCategories writes a categorization question as a table of its categories,
+// like writeMarkdownMatches.
+func writeMarkdownCategories(sb *strings.Builder, q Question, practice bool, l Labels) {
+	responses := !practice && len(q.Responses) > 0
+	sb.WriteString(fmt.Sprintf("- %s:\n\n", l.t("Categories")))
+	switch {
+	case practice:
+		sb.WriteString("| Category | Items |\n| --- | --- |\n")
+	case responses:
+		sb.WriteString("| Category | Correct items | Your items |\n| --- | --- | --- |\n")
+	default:
+		sb.WriteString("| Category | Correct items |\n| --- | --- |\n")
+	}
+	for _, c := range q.Categories {
+		members := strings.Join(c.Members, ", ")
+		if c.Members == nil && !practice {
+			members = "(" + l.t("answer unavailable") + ")"
+		}
+		row := fmt.Sprintf("| %s | %s |", MarkdownCell(c.Name), MarkdownCell(members))
+		if responses {
+			row += fmt.Sprintf(" %s |", MarkdownCell(c.responseMark()))
+		}
+		sb.WriteString(row + "\n")
+	}
+	sb.WriteString("\n")
+	if practice && len(q.CategoryItems) > 0 {
+		sb.WriteString(fmt.Sprintf("- Items to sort: %s\n\n", strings.Join(q.CategoryItems, ", ")))
+	} else if d := q.Distractors(); len(d) > 0 {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n\n", l.t("Distractors"), strings.Join(d, ", ")))
+	}
+}
+
+// writeMarkdownOrder writes an ordering question as its choices numbered in the correct
+// order, followed by the student's order when it differs.
+func writeMarkdownOrder(sb *strings.Builder, q Question, l Labels) {
+	sb.WriteString(fmt.Sprintf("- %s:\n", l.t("Correct order")))
+	for i, l := range q.Order {
+		sb.WriteString(fmt.Sprintf("  %d. %s\n", i+1, l))
+	}
+	sb.WriteString("\n")
+	if len(q.ResponseOrder) > 0 && !sameOrder(q.ResponseOrder, q.Order) {
+		sb.WriteString(fmt.Sprintf("- %s:\n", l.t("Your order")))
+		for i, l := range q.ResponseOrder {
+			sb.WriteString(fmt.Sprintf("  %d. %s\n", i+1, l))
+		}
+		sb.WriteString("\n")
+	}
+}
+
+// responseMark is the student's match, marked "(incorrect)" when it differs from a known key.
+func (m MatchPair) responseMark() string {
+	switch {
+	case m.Response == "":
+		return "(no response)"
+	case m.Answer != "" && m.Response != m.Answer:
+		return m.Response + " (incorrect)"
+	}
+	return m.Response
+}
+
+// responseMark lists the items the student put in a category, marking "(incorrect)" those
+// that belong elsewhere when the category's items are known.
+func (c Category) responseMark() string {
+	if len(c.Responses) == 0 {
+		return "(no response)"
+	}
+	member := map[string]bool{}
+	for _, m := range c.Members {
+		member[m] = true
+	}
+	marked := make([]string, len(c.Responses))
+	for i, r := range c.Responses {
+		marked[i] = r
+		if c.Members != nil && !member[r] {
+			marked[i] += " (incorrect)"
+		}
+	}
+	return strings.Join(marked, ", ")
+}
+
+// summary renders an assessment as "3 (Good) — comment"; "" when not assessed.
+func (a *RubricAssessment) summary() string {
+	if a == nil {
+		return ""
+	}
+	var parts []string
+	if a.Points != nil {
+		parts = append(parts, FormatPoints(*a.Points))
+	}
+	if a.Rating != "" {
+		parts = append(parts, "("+a.Rating+")")
+	}
+	out := strings.Join(parts, " ")
+	if a.Comments != "" {
+		if out != "" {
+			out += " — "
+		}
+		out += a.Comments
+	}
+	return out
+}
+
+func writeMarkdownExplanation(sb *strings.Builder, q Question, l Labels) {
+	if len(q.Attempts) > 0 {
+		sb.WriteString("| Attempt | Answer | Score |\n| --- | --- | --- |\n")
+		for _, a := range q.Attempts {
+			sb.WriteString(fmt.Sprintf("| %s | %s | %s |\n", MarkdownCell(a.Attempt), MarkdownCell(a.Answer), a.Score))
+		}
+		sb.WriteString("\n")
+	}
+	if q.Class != nil {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n\n", l.t("Class"), q.Class.Summary()))
+	}
+	if q.Explanation != "" {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n\n", l.t("Explanation"), q.Explanation))
+	}
+	writeMarkdownComments(sb, q.Comments, l)
+}
+
+// writeMarkdownComments renders instructor comments as quoted blocks.
+func writeMarkdownComments(sb *strings.Builder, comments []Comment, l Labels) {
+	for _, c := range comments {
+		who := l.t("Instructor")
+		if c.Author != "" {
+			who = c.Author
+		}
+		if c.Date != "" {
+			who += ", " + c.Date
+		}
+		sb.WriteString(fmt.Sprintf("> **%s:** %s\n\n", who, c.Text))
+	}
+}
+
+// RenderMediaWiki renders the document as MediaWiki markup. Option feedback becomes <ref>
+// footnotes (Cite extension), listed after each question.
+func RenderMediaWiki(doc QuizDoc) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("= %s =\n\n", wikiText(doc.Title)))
+	for _, d := range doc.Details {
+		sb.WriteString(fmt.Sprintf("* '''%s:''' %s\n", wikiText(d.Label), wikiText(d.Value)))
+	}
+	if len(doc.Details) > 0 {
+		sb.WriteString("\n")
+	}
+	if len(doc.Description) > 0 {
+		sb.WriteString("<blockquote>\n" + wikiText(strings.Join(doc.Description, "\n\n")) + "\n</blockquote>\n\n")
+	}
+	if len(doc.Comments) > 0 {
+		sb.WriteString("Instructor comments on this submission:\n\n")
+		writeWikiComments(&sb, doc.Comments)
+	}
+	if note := doc.notice(); note != "" {
+		sb.WriteString("''" + note + "''\n\n")
+	}
+
+	for i, q := range doc.Questions {
+		var prev *Question
+		if i > 0 {
+			prev = &doc.Questions[i-1]
+		}
+		if groupChange(prev, &q) {
+			if q.Group != nil {
+				sb.WriteString(fmt.Sprintf("== Group: %s ==\n\n", wikiText(q.Group.Title)))
+				if rule := q.Group.Rule(); rule != "" {
+					sb.WriteString(fmt.Sprintf("''Questions drawn at random: %s.''\n\n", rule))
+				}
+			} else {
+				sb.WriteString("----\n\n")
+			}
+		}
+		sb.WriteString(fmt.Sprintf("<span id=\"%s\"></span>\n=== %d) %s ===\n", q.ContentID, q.Number, wikiText(q.Text)))
+		if q.Bank != "" {
+			sb.WriteString(fmt.Sprintf("* Bank: %s\n", wikiText(q.Bank)))
+		}
+		if len(q.Quizzes) > 0 {
+			sb.WriteString(fmt.Sprintf("* Appeared in: %s\n", wikiText(strings.Join(q.Quizzes, ", "))))
+		}
+		for _, m := range q.Media {
+			sb.WriteString(fmt.Sprintf("* Media: [%s %s]\n", m.URL, wikiText(m.Label())))
+		}
+		if len(q.Tags) > 0 {
+			sb.WriteString(fmt.Sprintf("* Tags: %s\n", wikiText(strings.Join(q.Tags, ", "))))
+		}
+		if q.Unanswered {
+			sb.WriteString("* Response: left blank\n")
+		}
+		if q.Formula != "" {
+			sb.WriteString("* Formula: " + wikiText(q.Formula) + "\n")
+		}
+		if doc.AnnotateKeys && q.KeyConfidence != "" {
+			sb.WriteString(fmt.Sprintf("* Key: %s\n", q.KeyConfidence))
+		}
+		if c, ok := q.CheckResponse(); ok && doc.ShowResponses {
+			sb.WriteString(fmt.Sprintf("* Your answer: %s %s\n", c.Mark(), wikiText(c.Response)))
+			if c.Key != "" {
+				sb.WriteString(fmt.Sprintf("* Correct answer: %s\n", wikiText(c.Key)))
+			}
+			sb.WriteString(fmt.Sprintf("* Score: %s\n", c.Score))
+		}
+		hasRefs := false
+		switch {
+		case !q.HasResult:
+			sb.WriteString("* Options: (no result data)\n")
+		case q.OpenEntry && !q.Ungraded:
+			if len(q.WordBank) > 0 {
+				sb.WriteString("* Word bank:\n")
+				for _, w := range q.WordBank {
+					sb.WriteString("** " + wikiText(w) + "\n")
+				}
+			}
+			sb.WriteString("* Blanks and answers:\n")
+			for _, b := range q.Blanks {
+				ans := "(answer unavailable)"
+				if b.Answer != "" {
+					ans = "'''" + wikiText(b.Answer) + "'''"
+				}
+				if b.Example {
+					ans += " (example match)"
+				}
+				if len(b.Accepted) > 1 {
+					ans += " (also accepted: " + wikiText(strings.Join(b.Accepted[1:], ", ")) + ")"
+				}
+				sb.WriteString(fmt.Sprintf("** %s: %s\n", b.Label, ans))
+				if b.Rule != "" {
+					sb.WriteString("*** Matching: " + wikiText(b.Rule) + "\n")
+				}
+				if b.Pattern != "" {
+					sb.WriteString("*** Pattern: <code>" + wikiText(b.Pattern) + "</code>\n")
+					if b.Meaning != "" {
+						sb.WriteString("*** Reads as: " + wikiText(b.Meaning) + "\n")
+					}
+				}
+			}
+		case q.Essay:
+			sb.WriteString("* Options: N/A (essay)\n")
+			for _, p := range q.Submission {
+				sb.WriteString("<blockquote>" + wikiText(p) + "</blockquote>\n")
+			}
+			if len(q.Files) > 0 {
+				sb.WriteString("* Files: " + wikiText(strings.Join(q.Files, ", ")) + "\n")
+			}
+			if a := q.awarded(); a != "" && !doc.ShowResponses {
+				sb.WriteString("* Score: " + a + "\n")
+			}
+			if len(q.Rubric) > 0 {
+				sb.WriteString("\n{| class=\"wikitable\"\n! Criterion !! Points !! Ratings !! Assessed\n")
+				for _, c := range q.Rubric {
+					var ratings []string
+					for _, r := range c.Ratings {
+						ratings = append(ratings, fmt.Sprintf("%s (%s)", r.Description, FormatPoints(r.Points)))
+					}
+					sb.WriteString(fmt.Sprintf("|-\n| %s || %s || %s || %s\n", wikiText(c.Description), FormatPoints(c.Points), wikiText(strings.Join(ratings, "; ")), wikiText(c.Assessed.summary())))
+				}
+				sb.WriteString("|}\n")
+			}
+		case q.Ungraded:
+			for _, o := range q.Options {
+				if o.Selected {
+					sb.WriteString("* " + wikiText(o.Label) + " (your response)\n")
+				} else {
+					sb.WriteString("* " + wikiText(o.Label) + "\n")
+				}
+			}
+			if !doc.Practice {
+				resp := "(no response)"
+				if len(q.Responses) > 0 {
+					resp = wikiText(strings.Join(q.Responses, ", "))
+				}
+				sb.WriteString("* Your response: " + resp + "\n")
+			}
+		default:
+			if len(q.Passage) > 0 {
+				sb.WriteString("<blockquote>")
+				for _, sp := range q.Passage {
+					if sp.Correct {
+						sb.WriteString("'''" + wikiText(sp.Text) + "'''")
+					} else {
+						sb.WriteString(wikiText(sp.Text))
+					}
+				}
+				sb.WriteString("</blockquote>\n")
+			}
+			if len(q.Options) > 0 {
+				sb.WriteString("* Options:\n")
+				for _, o := range q.Options {
+					line := "** " + wikiText(o.Label)
+					if o.Correct {
+						line = "** '''" + wikiText(o.Label) + "''' (correct)"
+					}
+					if o.Feedback != "" {
+						line += "<ref>" + wikiText(o.Feedback) + "</ref>"
+						hasRefs = true
+					}
+					sb.WriteString(line + "\n")
+				}
+			}
+			switch {
+			case q.Multi:
+				sb.WriteString("* Correct answers:\n")
+				for _, a := range q.Answers {
+					sb.WriteString("** " + wikiText(a) + "\n")
+				}
+			case len(q.Answers) == 1:
+				sb.WriteString("* " + wikiText(q.givenPrefix()) + "Answer: '''" + wikiText(q.Answers[0]) + "'''\n")
+			default:
+				sb.WriteString("* Answer: (answer unavailable)\n")
+			}
+		}
+		if len(q.Attempts) > 0 {
+			sb.WriteString("\n{| class=\"wikitable\"\n! Attempt !! Answer !! Score\n")
+			for _, a := range q.Attempts {
+				sb.WriteString(fmt.Sprintf("|-\n| %s || %s || %s\n", wikiText(a.Attempt), wikiText(a.Answer), a.Score))
+			}
+			sb.WriteString("|}\n")
+		}
+		if q.Class != nil {
+			sb.WriteString("* Class: " + wikiText(q.Class.Summary()) + "\n")
+		}
+		if q.Explanation != "" {
+			sb.WriteString("* Explanation: " + wikiText(q.Explanation) + "\n")
+		}
+		if len(q.Comments) > 0 {
+			sb.WriteString("\n")
+			writeWikiComments(&sb, q.Comments)
+		}
+		if hasRefs {
+			sb.WriteString("\n<references />\n")
+		}
+		sb.WriteString("\n")
+	}
+	if refs := doc.References(); len(refs) > 0 {
+		sb.WriteString("== References ==\n")
+		for _, r := range refs {
+			sb.WriteString(fmt.Sprintf("* [%s %s] — %s\n", r.URL, wikiText(r.Text), r.citedBy(plainCite)))
+		}
+	}
+	return sb.String()
+}
+
+func writeWikiComments(sb *strings.Builder, comments []Comment) {
+	for _, c := range comments {
+		who := "Instructor"
+		if c.Author != "" {
+			who = c.Author
+		}
+		if c.Date != "" {
+			who += ", " + c.Date
+		}
+		sb.WriteString(fmt.Sprintf("<blockquote>'''%s:''' %s</blockquote>\n\n", wikiText(who), wikiText(c.Text)))
+	}
+}
+
+// wikiText protects plain text that contains wiki or HTML markup: links, templates, table
+// pipes, tags and entities, bold/italic quotes, signatures, and characters that are only
+// special at the edges of a line or heading.
+func wikiText(s string) string {
+	markup := strings.ContainsAny(s, "[]{}|<>&") || strings.Contains(s, "''") || strings.Contains(s, "~~~") ||
+		strings.HasPrefix(s, "=") || strings.HasSuffix(s, "=") || strings.IndexAny(s, "*#:;") == 0
+	if !markup {
+		return s
+	}
+	return "<nowiki>" + strings.ReplaceAll(s, "</nowiki>", "&lt;/nowiki>") + "</nowiki>"
+}
+
+// RenderRST renders the document as reStructuredText for Sphinx. Answers, explanations and
+// instructor comments go in admonition directives so themes can style (or collapse) them.
+func RenderRST(doc QuizDoc) string {
+	var sb strings.Builder
+	title := rstText(doc.Title)
+	bar := strings.Repeat("=", utf8.RuneCountInString(title))
+	sb.WriteString(bar + "\n" + title + "\n" + bar + "\n\n")
+	for _, d := range doc.Details {
+		sb.WriteString(fmt.Sprintf(":%s: %s\n", rstText(d.Label), rstText(d.Value)))
+	}
+	if len(doc.Details) > 0 {
+		sb.WriteString("\n")
+	}
+	for _, p := range doc.Description {
+		sb.WriteString("   " + rstText(p) + "\n\n")
+	}
+	writeRSTComments(&sb, doc.Comments)
+	if note := doc.notice(); note != "" {
+		sb.WriteString("*" + note + "*\n\n")
+	}
+
+	for i, q := range doc.Questions {
+		var prev *Question
+		if i > 0 {
+			prev = &doc.Questions[i-1]
+		}
+		if groupChange(prev, &q) {
+			if q.Group != nil {
+				heading := "Group: " + rstText(q.Group.Title)
+				sb.WriteString(heading + "\n" + strings.Repeat("-", utf8.RuneCountInString(heading)) + "\n\n")
+				if rule := q.Group.Rule(); rule != "" {
+					sb.WriteString(fmt.Sprintf("*Questions drawn at random: %s.*\n\n", rule))
+				}
+			} else {
+				sb.WriteString("----\n\n")
+			}
+		}
+		heading := fmt.Sprintf("%d) %s", q.Number, rstText(q.Text))
+		sb.WriteString(".. _" + q.ContentID + ":\n\n")
+		sb.WriteString(heading + "\n" + strings.Repeat("~", utf8.RuneCountInString(heading)) + "\n\n")
+		if q.Bank != "" {
+			sb.WriteString(fmt.Sprintf(":Bank: %s\n\n", rstText(q.Bank)))
+		}
+		if len(q.Quizzes) > 0 {
+			sb.WriteString(fmt.Sprintf(":Appeared in: %s\n\n", rstText(strings.Join(q.Quizzes, ", "))))
+		}
+		for _, m := range q.Media {
+			sb.WriteString(fmt.Sprintf(":Media: `%s <%s>`__\n\n", strings.NewReplacer("`", "\\`", "<", "\\<").Replace(m.Label()), m.URL))
+		}
+		if len(q.Tags) > 0 {
+			sb.WriteString(fmt.Sprintf(":Tags: %s\n\n", rstText(strings.Join(q.Tags, ", "))))
+		}
+		if q.Unanswered {
+			sb.WriteString(":Response: left blank\n\n")
+		}
+		if q.Formula != "" {
+			sb.WriteString(":Formula: " + rstText(q.Formula) + "\n\n")
+		}
+		if doc.AnnotateKeys && q.KeyConfidence != "" {
+			sb.WriteString(fmt.Sprintf(":Key: %s\n\n", q.KeyConfidence))
+		}
+		if c, ok := q.CheckResponse(); ok && doc.ShowResponses {
+			sb.WriteString(fmt.Sprintf(":Your answer: %s %s\n", c.Mark(), rstText(c.Response)))
+			if c.Key != "" {
+				sb.WriteString(fmt.Sprintf(":Correct answer: %s\n", rstText(c.Key)))
+			}
+			sb.WriteString(fmt.Sprintf(":Score: %s\n\n", c.Score))
+		}
+		var answer []string // lines of the Answer admonition body
+		switch {
+		case !q.HasResult:
+			sb.WriteString("*No result data.*\n\n")
+		case q.OpenEntry && !q.Ungraded:
+			if len(q.WordBank) > 0 {
+				sb.WriteString("Word bank:\n\n")
+				for _, w := range q.WordBank {
+					sb.WriteString("- " + rstText(w) + "\n")
+				}
+				sb.WriteString("\n")
+			}
+			for _, b := range q.Blanks {
+				ans := "(answer unavailable)"
+				if b.Answer != "" {
+					ans = "**" + rstText(b.Answer) + "**"
+				}
+				if b.Example {
+					ans += " (example match)"
+				}
+				if len(b.Accepted) > 1 {
+					ans += " (also accepted: " + rstText(strings.Join(b.Accepted[1:], ", ")) + ")"
+				}
+				answer = append(answer, fmt.Sprintf("- %s: %s", b.Label, ans))
+				if b.Rule != "" {
+					answer = append(answer, "", "  - Matching: "+rstText(b.Rule), "")
+				}
+				if b.Pattern != "" {
+					answer = append(answer, "  - Pattern: ``"+b.Pattern+"``")
+					if b.Meaning != "" {
+						answer = append(answer, "  - Reads as: "+rstText(b.Meaning))
+					}
+				}
+			}
+		case q.Essay:
+			sb.WriteString("*Essay.*\n\n")
+			if len(q.Submission) > 0 {
+				var body []string
+				for i, p := range q.Submission {
+					if i > 0 {
+						body = append(body, "")
+					}
+					body = append(body, rstText(p))
+				}
+				writeRSTAdmonition(&sb, "admonition:: Submission", body)
+			}
+			if len(q.Files) > 0 {
+				sb.WriteString(":Files: " + rstText(strings.Join(q.Files, ", ")) + "\n\n")
+			}
+			if a := q.awarded(); a != "" && !doc.ShowResponses {
+				sb.WriteString(":Score: " + a + "\n\n")
+			}
+			if len(q.Rubric) > 0 {
+				sb.WriteString(".. list-table:: Rubric\n   :header-rows: 1\n\n   * - Criterion\n     - Points\n     - Ratings\n     - Assessed\n")
+				for _, c := range q.Rubric {
+					var ratings []string
+					for _, r := range c.Ratings {
+						ratings = append(ratings, fmt.Sprintf("%s (%s)", r.Description, FormatPoints(r.Points)))
+					}
+					sb.WriteString(fmt.Sprintf("   * - %s\n     - %s\n     - %s\n     - %s\n", rstText(c.Description), FormatPoints(c.Points), rstText(strings.Join(ratings, "; ")), rstText(c.Assessed.summary())))
+				}
+				sb.WriteString("\n")
+			}
+		case q.Ungraded:
+			for _, o := range q.Options {
+				if o.Selected {
+					sb.WriteString("- " + rstText(o.Label) + " *(your response)*\n")
+				} else {
+					sb.WriteString("- " + rstText(o.Label) + "\n")
+				}
+			}
+			if len(q.Options) > 0 {
+				sb.WriteString("\n")
+			}
+			if !doc.Practice {
+				resp := "(no response)"
+				if len(q.Responses) > 0 {
+					resp = rstText(strings.Join(q.Responses, ", "))
+				}
+				sb.WriteString("Your response: " + resp + "\n\n")
+			}
+		default:
+			if len(q.Passage) > 0 {
+				sb.WriteString("   ")
+				for _, sp := range q.Passage {
+					if sp.Correct {
+						sb.WriteString("**" + rstText(strings.TrimSpace(sp.Text)) + "**")
+					} else {
+						sb.WriteString(rstText(sp.Text))
+					}
+				}
+				sb.WriteString("\n\n")
+			}
+			for _, o := range q.Options {
+				line := "- " + rstText(o.Label)
+				if o.Feedback != "" {
+					line += " — *" + rstText(o.Feedback) + "*"
+				}
+				sb.WriteString(line + "\n")
+			}
+			if len(q.Options) > 0 {
+				sb.WriteString("\n")
+			}
+			switch {
+			case q.Multi:
+				for _, a := range q.Answers {
+					answer = append(answer, "- "+rstText(a))
+				}
+			case len(q.Answers) == 1:
+				answer = append(answer, rstText(q.givenPrefix()+q.Answers[0]))
+			default:
+				answer = append(answer, "(answer unavailable)")
+			}
+		}
+		if len(answer) > 0 {
+			name := "Answer"
+			if q.Multi {
+				name = "Correct answers"
+			}
+			writeRSTAdmonition(&sb, "admonition:: "+name, answer)
+		}
+		if len(q.Attempts) > 0 {
+			sb.WriteString(".. list-table:: Attempts\n   :header-rows: 1\n\n   * - Attempt\n     - Answer\n     - Score\n")
+			for _, a := range q.Attempts {
+				sb.WriteString(fmt.Sprintf("   * - %s\n     - %s\n     - %s\n", rstText(a.Attempt), rstText(a.Answer), a.Score))
+			}
+			sb.WriteString("\n")
+		}
+		if q.Class != nil {
+			sb.WriteString(":Class: " + rstText(q.Class.Summary()) + "\n\n")
+		}
+		if q.Explanation != "" {
+			writeRSTAdmonition(&sb, "note::", []string{rstText(q.Explanation)})
+		}
+		writeRSTComments(&sb, q.Comments)
+	}
+	if refs := doc.References(); len(refs) > 0 {
+		sb.WriteString("References\n----------\n\n")
+		for _, r := range refs {
+			sb.WriteString(fmt.Sprintf("- `%s <%s>`__ — %s\n", strings.NewReplacer("`", "\\`", "<", "\\<").Replace(r.Text), r.URL, r.citedBy(plainCite)))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// writeRSTAdmonition writes a directive with its body indented under it.
+func writeRSTAdmonition(sb *strings.Builder, directive string, body []string) {
+	sb.WriteString(".. " + directive + "\n\n")
+	for _, l := range body {
+		if l == "" {
+			sb.WriteString("\n")
+			continue
+		}
+		sb.WriteString("   " + l + "\n")
+	}
+	sb.WriteString("\n")
+}
+
+func writeRSTComments(sb *strings.Builder, comments []Comment) {
+	for _, c := range comments {
+		who := "Instructor"
+		if c.Author != "" {
+			who = c.Author
+		}
+		if c.Date != "" {
+			who += ", " + c.Date
+		}
+		writeRSTAdmonition(sb, "admonition:: "+rstText(who), []string{rstText(c.Text)})
+	}
+}
+
+// rstText escapes reStructuredText inline markup: emphasis, literals, substitutions,
+// backslashes, and reference underscores at the end of a word.
+func rstText(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "*", `\*`, "`", "\\`", "|", `\|`).Replace(s)
+	return rstRefUnderscore.ReplaceAllString(s, `\_$1`)
+}
+
+var rstRefUnderscore = regexp.MustCompile(`_(\W|$)`)
+
+// RenderAsciiDoc renders the document as AsciiDoc for Asciidoctor/Antora. Answers and
+// explanations go in collapsible example blocks so the page reads as a practice quiz.
+func RenderAsciiDoc(doc QuizDoc) string {
+	var sb strings.Builder
+	sb.WriteString("= " + adocText(doc.Title) + "\n\n")
+	for _, d := range doc.Details {
+		sb.WriteString(fmt.Sprintf("%s:: %s\n", adocText(d.Label), adocText(d.Value)))
+	}
+	if len(doc.Details) > 0 {
+		sb.WriteString("\n")
+	}
+	if len(doc.Description) > 0 {
+		sb.WriteString("____\n" + adocText(strings.Join(doc.Description, "\n\n")) + "\n____\n\n")
+	}
+	writeAdocComments(&sb, doc.Comments)
+	if note := doc.notice(); note != "" {
+		sb.WriteString("_" + note + "_\n\n")
+	}
+
+	for i, q := range doc.Questions {
+		var prev *Question
+		if i > 0 {
+			prev = &doc.Questions[i-1]
+		}
+		if groupChange(prev, &q) {
+			if q.Group != nil {
+				sb.WriteString("[discrete]\n== Group: " + adocText(q.Group.Title) + "\n\n")
+				if rule := q.Group.Rule(); rule != "" {
+					sb.WriteString(fmt.Sprintf("_Questions drawn at random: %s._\n\n", rule))
+				}
+			} else {
+				sb.WriteString("'''\n\n")
+			}
+		}
+		sb.WriteString(fmt.Sprintf("[[%s]]\n== %d) %s\n\n", q.ContentID, q.Number, adocText(q.Text)))
+		if q.Bank != "" {
+			sb.WriteString("Bank:: " + adocText(q.Bank) + "\n\n")
+		}
+		if len(q.Quizzes) > 0 {
+			sb.WriteString("Appeared in:: " + adocText(strings.Join(q.Quizzes, ", ")) + "\n\n")
+		}
+		for _, m := range q.Media {
+			sb.WriteString("Media:: link:" + m.URL + "[" + strings.ReplaceAll(m.Label(), "]", "\\]") + "]\n\n")
+		}
+		if len(q.Tags) > 0 {
+			sb.WriteString("Tags:: " + adocText(strings.Join(q.Tags, ", ")) + "\n\n")
+		}
+		if q.Unanswered {
+			sb.WriteString("Response:: left blank\n\n")
+		}
+		if q.Formula != "" {
+			sb.WriteString("Formula:: " + adocText(q.Formula) + "\n\n")
+		}
+		if doc.AnnotateKeys && q.KeyConfidence != "" {
+			sb.WriteString("Key:: " + q.KeyConfidence + "\n\n")
+		}
+		if c, ok := q.CheckResponse(); ok && doc.ShowResponses {
+			sb.WriteString(fmt.Sprintf("Your answer:: %s %s\n", c.Mark(), adocText(c.Response)))
+			if c.Key != "" {
+				sb.WriteString(fmt.Sprintf("Correct answer:: %s\n", adocText(c.Key)))
+			}
+			sb.WriteString(fmt.Sprintf("Score:: %s\n\n", c.Score))
+		}
+		var answer []string // lines of the collapsible answer block
+		switch {
+		case !q.HasResult:
+			sb.WriteString("_No result data._\n\n")
+		case q.OpenEntry && !q.Ungraded:
+			if len(q.WordBank) > 0 {
+				sb.WriteString(".Word bank\n")
+				for _, w := range q.WordBank {
+					sb.WriteString("* " + adocText(w) + "\n")
+				}
+				sb.WriteString("\n")
+			}
+			for _, b := range q.Blanks {
+				ans := "(answer unavailable)"
+				if b.Answer != "" {
+					ans = "*" + adocText(b.Answer) + "*"
+				}
+				if b.Example {
+					ans += " (example match)"
+				}
+				if len(b.Accepted) > 1 {
+					ans += " (also accepted: " + adocText(strings.Join(b.Accepted[1:], ", ")) + ")"
+				}
+				answer = append(answer, fmt.Sprintf("* %s: %s", b.Label, ans))
+				if b.Rule != "" {
+					answer = append(answer, "** Matching: "+adocText(b.Rule))
+				}
+				if b.Pattern != "" {
+					answer = append(answer, "** Pattern: `"+adocText(b.Pattern)+"`")
+					if b.Meaning != "" {
+						answer = append(answer, "** Reads as: "+adocText(b.Meaning))
+					}
+				}
+			}
+		case q.Essay:
+			sb.WriteString("_Essay._\n\n")
+			if len(q.Submission) > 0 {
+				var body []string
+				for _, p := range q.Submission {
+					body = append(body, adocText(p))
+				}
+				sb.WriteString(".Submission\n____\n" + strings.Join(body, "\n\n") + "\n____\n\n")
+			}
+			if len(q.Files) > 0 {
+				sb.WriteString("Files:: " + adocText(strings.Join(q.Files, ", ")) + "\n\n")
+			}
+			if a := q.awarded(); a != "" && !doc.ShowResponses {
+				sb.WriteString("Score:: " + a + "\n\n")
+			}
+			if len(q.Rubric) > 0 {
+				sb.WriteString(".Rubric\n[cols=\"3,1,4,2\",options=\"header\"]\n|===\n|Criterion |Points |Ratings |Assessed\n")
+				for _, c := range q.Rubric {
+					var ratings []string
+					for _, r := range c.Ratings {
+						ratings = append(ratings, fmt.Sprintf("%s (%s)", r.Description, FormatPoints(r.Points)))
+					}
+					sb.WriteString(fmt.Sprintf("\n|%s\n|%s\n|%s\n|%s\n", adocText(c.Description), FormatPoints(c.Points), adocText(strings.Join(ratings, "; ")), adocText(c.Assessed.summary())))
+				}
+				sb.WriteString("|===\n\n")
+			}
+		case q.Ungraded:
+			for _, o := range q.Options {
+				if o.Selected {
+					sb.WriteString("* " + adocText(o.Label) + " _(your response)_\n")
+				} else {
+					sb.WriteString("* " + adocText(o.Label) + "\n")
+				}
+			}
+			if len(q.Options) > 0 {
+				sb.WriteString("\n")
+			}
+			if !doc.Practice {
+				resp := "(no response)"
+				if len(q.Responses) > 0 {
+					resp = adocText(strings.Join(q.Responses, ", "))
+				}
+				sb.WriteString("Your response: " + resp + "\n\n")
+			}
+		default:
+			if len(q.Passage) > 0 {
+				sb.WriteString("____\n")
+				for _, sp := range q.Passage {
+					if sp.Correct {
+						sb.WriteString("*" + adocText(strings.TrimSpace(sp.Text)) + "*")
+					} else {
+						sb.WriteString(adocText(sp.Text))
+					}
+				}
+				sb.WriteString("\n____\n\n")
+			}
+			for _, o := range q.Options {
+				line := "* " + adocText(o.Label)
+				if o.Feedback != "" {
+					line += " — _" + adocText(o.Feedback) + "_"
+				}
+				sb.WriteString(line + "\n")
+			}
+			if len(q.Options) > 0 {
+				sb.WriteString("\n")
+			}
+			switch {
+			case q.Multi:
+				for _, a := range q.Answers {
+					answer = append(answer, "* "+adocText(a))
+				}
+			case len(q.Answers) == 1:
+				answer = append(answer, adocText(q.givenPrefix()+q.Answers[0]))
+			default:
+				answer = append(answer, "(answer unavailable)")
+			}
+		}
+		if len(answer) > 0 {
+			name := "Answer"
+			if q.Multi {
+				name = "Correct answers"
+			}
+			writeAdocCollapsible(&sb, name, answer)
+		}
+		if len(q.Attempts) > 0 {
+			sb.WriteString(".Attempts\n[cols=\"2,4,1\",options=\"header\"]\n|===\n|Attempt |Answer |Score\n")
+			for _, a := range q.Attempts {
+				sb.WriteString(fmt.Sprintf("\n|%s\n|%s\n|%s\n", adocText(a.Attempt), adocText(a.Answer), a.Score))
+			}
+			sb.WriteString("|===\n\n")
+		}
+		if q.Class != nil {
+			sb.WriteString("Class:: " + adocText(q.Class.Summary()) + "\n\n")
+		}
+		if q.Explanation != "" {
+			writeAdocCollapsible(&sb, "Explanation", []string{adocText(q.Explanation)})
+		}
+		writeAdocComments(&sb, q.Comments)
+	}
+	if refs := doc.References(); len(refs) > 0 {
+		sb.WriteString("== References\n\n")
+		for _, r := range refs {
+			sb.WriteString("* link:" + r.URL + "[" + strings.ReplaceAll(r.Text, "]", "\\]") + "] — " + r.citedBy(plainCite) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// writeAdocCollapsible writes a collapsed example block; Asciidoctor renders it as <details>.
+func writeAdocCollapsible(sb *strings.Builder, title string, body []string) {
+	sb.WriteString("." + title + "\n[%collapsible]\n====\n")
+	sb.WriteString(strings.Join(body, "\n") + "\n====\n\n")
+}
+
+func writeAdocComments(sb *strings.Builder, comments []Comment) {
+	for _, c := range comments {
+		who := "Instructor"
+		if c.Author != "" {
+			who = c.Author
+		}
+		if c.Date != "" {
+			who += ", " + c.Date
+		}
+		sb.WriteString("[NOTE]\n." + adocText(who) + "\n====\n" + adocText(c.Text) + "\n====\n\n")
+	}
+}
+
+// adocText protects plain text that contains AsciiDoc formatting, macro or attribute
+// characters by wrapping it in an inline passthrough that only escapes HTML.
+func adocText(s string) string {
+	if !strings.ContainsAny(s, "*_`#+^~[]{}<>&|") {
+		return s
+	}
+	return "pass:c[" + strings.ReplaceAll(s, "]", `\]`) + "]"
+}
+
+// RenderText renders the document as wrapped plain text with ASCII markers: "[x]" for
+// correct options, "[ ]" for the rest, and "->" for answers.
+func RenderText(doc QuizDoc, width int) string {
+	var sb strings.Builder
+	doc = doc.translateBlanks()
+	l := doc.Labels
+	para := func(text, first, rest string) {
+		sb.WriteString(wrapText(text, width, first, rest) + "\n")
+	}
+	sb.WriteString(doc.Title + "\n" + strings.Repeat("=", utf8.RuneCountInString(doc.Title)) + "\n\n")
+	for _, d := range doc.Details {
+		para(l.t(d.Label)+": "+d.Value, "", "  ")
+	}
+	if len(doc.Details) > 0 {
+		sb.WriteString("\n")
+	}
+	for _, p := range doc.Description {
+		para(p, "  ", "  ")
+		sb.WriteString("\n")
+	}
+	writeTextComments(&sb, doc.Comments, width, "", l)
+	if note := doc.notice(); note != "" {
+		sb.WriteString("(" + strings.Replace(note, " — ", " - ", 1) + ")\n\n")
+	}
+
+	for i, q := range doc.Questions {
+		var prev *Question
+		if i > 0 {
+			prev = &doc.Questions[i-1]
+		}
+		if groupChange(prev, &q) {
+			if q.Group != nil {
+				heading := l.t("Group") + ": " + q.Group.Title
+				sb.WriteString(heading + "\n" + strings.Repeat("-", utf8.RuneCountInString(heading)) + "\n")
+				if rule := q.Group.Rule(); rule != "" {
+					para("Questions drawn at random: "+rule+".", "", "")
+				}
+				sb.WriteString("\n")
+			} else {
+				sb.WriteString(strings.Repeat("-", 20) + "\n\n")
+			}
+		}
+		num := fmt.Sprintf("%d) ", q.Number)
+		para(q.Text, num, strings.Repeat(" ", len(num)))
+		sb.WriteString("   ID: " + q.ContentID + "\n")
+		if q.Bank != "" {
+			para(l.t("Bank")+": "+q.Bank, "   ", "     ")
+		}
+		if len(q.Quizzes) > 0 {
+			para(l.t("Appeared in")+": "+strings.Join(q.Quizzes, ", "), "   ", "     ")
+		}
+		for _, m := range q.Media {
+			para(l.t("Media")+": "+m.Label()+" <"+m.URL+">", "   ", "     ")
+		}
+		if len(q.Tags) > 0 {
+			para(l.t("Tags")+": "+strings.Join(q.Tags, ", "), "   ", "     ")
+		}
+		if q.Unanswered {
+			para(l.t("Response")+": "+l.t("left blank"), "   ", "     ")
+		}
+		if q.Formula != "" {
+			para(l.t("Formula")+": "+q.Formula, "   ", "     ")
+		}
+		if doc.AnnotateKeys && q.KeyConfidence != "" {
+			para(l.t("Key")+": "+q.KeyConfidence, "   ", "     ")
+		}
+		if c, ok := q.CheckResponse(); ok && doc.ShowResponses {
+			para(l.t("Your answer")+": "+c.Mark()+" "+c.Response, "   ", "     ")
+			if c.Key != "" {
+				para(l.t("Correct answer")+": "+c.Key, "   ", "     ")
+			}
+			para(l.t("Score")+": "+c.Score, "   ", "     ")
+		}
+		switch {
+		case !q.HasResult:
+			sb.WriteString("   (no result data)\n")
+		case q.OpenEntry && !q.Ungraded:
+			if len(q.WordBank) > 0 {
+				para(l.t("Word bank")+": "+strings.Join(q.WordBank, ", "), "   ", "     ")
+			}
+			for _, b := range q.Blanks {
+				ans := "(" + l.t("answer unavailable") + ")"
+				if b.Answer != "" {
+					ans = b.Answer
+				}
+				if b.Example {
+					ans += " (example match)"
+				}
+				if len(b.Accepted) > 1 {
+					ans += " (also accepted: " + strings.Join(b.Accepted[1:], ", ") + ")"
+				}
+				para(b.Label+": "+ans, "   -> ", "      ")
+				if b.Rule != "" {
+					para("Matching: "+b.Rule, "      ", "        ")
+				}
+				if b.Pattern != "" {
+					para("Pattern: "+b.Pattern, "      ", "        ")
+					if b.Meaning != "" {
+						para("Reads as: "+b.Meaning, "      ", "        ")
+					}
+				}
+			}
+		case q.Essay:
+			sb.WriteString("   (essay)\n")
+			for _, p := range q.Submission {
+				para(p, "   > ", "   > ")
+			}
+			if len(q.Files) > 0 {
+				para(l.t("Files")+": "+strings.Join(q.Files, ", "), "   ", "     ")
+			}
+			if a := q.awarded(); a != "" && !doc.ShowResponses {
+				para(l.t("Score")+": "+a, "   ", "     ")
+			}
+			for _, c := range q.Rubric {
+				line := fmt.Sprintf("%s (%s pts)", c.Description, FormatPoints(c.Points))
+				if a := c.Assessed.summary(); a != "" {
+					line += ": " + a
+				}
+				para(line, "   * ", "     ")
+			}
+		case q.Ungraded:
+			for _, o := range q.Options {
+				marker := "   [ ] "
+				if o.Selected {
+					marker = "   [>] "
+				}
+				para(o.Label, marker, "       ")
+			}
+			if !doc.Practice {
+				resp := "(" + l.t("no response") + ")"
+				if len(q.Responses) > 0 {
+					resp = strings.Join(q.Responses, ", ")
+				}
+				para(l.t("Your response")+": "+resp, "   ", "     ")
+			}
+		default:
+			if len(q.Passage) > 0 {
+				var passage strings.Builder
+				for _, sp := range q.Passage {
+					if sp.Correct {
+						passage.WriteString("[" + strings.TrimSpace(sp.Text) + "]")
+					} else {
+						passage.WriteString(sp.Text)
+					}
+				}
+				para(passage.String(), "   | ", "   | ")
+			}
+			for _, o := range q.Options {
+				marker := "   [ ] "
+				if o.Correct {
+					marker = "   [x] "
+				}
+				para(o.Label, marker, "       ")
+				if o.Feedback != "" {
+					para("Feedback: "+o.Feedback, "         ", "         ")
+				}
+			}
+			switch {
+			case q.Multi:
+				para(l.t("Correct answers")+": "+strings.Join(q.Answers, "; "), "   -> ", "      ")
+			case len(q.Answers) == 1:
+				para(q.givenPrefix()+l.t("Answer")+": "+q.Answers[0], "   -> ", "      ")
+			default:
+				sb.WriteString("   -> " + l.t("Answer") + ": (" + l.t("answer unavailable") + ")\n")
+			}
+		}
+		for _, a := range q.Attempts {
+			para(a.Summary(), "   ", "     ")
+		}
+		if q.Class != nil {
+			para(l.t("Class")+": "+q.Class.Summary(), "   ", "   ")
+		}
+		if q.Explanation != "" {
+			para(l.t("Explanation")+": "+q.Explanation, "   ", "   ")
+		}
+		writeTextComments(&sb, q.Comments, width, "   ", l)
+		sb.WriteString("\n")
+	}
+	if refs := doc.References(); len(refs) > 0 {
+		heading := l.t("References")
+		sb.WriteString(heading + "\n" + strings.Repeat("-", utf8.RuneCountInString(heading)) + "\n")
+		for _, r := range refs {
+			para(r.Text+" <"+r.URL+"> - "+r.citedBy(plainCite), "* ", "  ")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func writeTextComments(sb *strings.Builder, comments []Comment, width int, indent string, l Labels) {
+	for _, c := range comments {
+		who := l.t("Instructor")
+		if c.Author != "" {
+			who = c.Author
+		}
+		if c.Date != "" {
+			who += ", " + c.Date
+		}
+		sb.WriteString(wrapText(who+": "+c.Text, width, indent+"> ", indent+"> ") + "\n")
+	}
+	if len(comments) > 0 && indent == "" {
+		sb.WriteString("\n")
+	}
+}
+
+// wrapText fills text to width columns, starting the first line with first and the rest
+// with rest. Words longer than the line are left unbroken; width <= 0 disables wrapping.
+func wrapText(text string, width int, first, rest string) string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return strings.TrimRight(first, " ")
+	}
+	var sb strings.Builder
+	line, n := first, textWidth(first)
+	empty := true
+	for _, w := range words {
+		wn := textWidth(w)
+		if !empty && width > 0 && n+1+wn > width {
+			sb.WriteString(line + "\n")
+			line, n, empty = rest, textWidth(rest), true
+		}
+		if !empty {
+			line += " "
+			n++
+		}
+		line += w
+		n += wn
+		empty = false
+	}
+	sb.WriteString(line)
+	return sb.String()
+}
+
+// defaultCSS is the stylesheet embedded in every HTML document. All colors, fonts and
+// spacing go through the --qe-* custom properties so user stylesheets can retheme the
+// output by overriding variables instead of selectors.
+const defaultCSS = `:root {
+  --qe-font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif;
+  --qe-font-size: 16px;
+  --qe-line-height: 1.5;
+  --qe-max-width: 48rem;
+  --qe-spacing: 1rem;
+  --qe-bg: #ffffff;
+  --qe-fg: #1f2328;
+  --qe-muted: #59636e;
+  --qe-border: #d0d7de;
+  --qe-accent: #0969da;
+  --qe-correct-bg: #dafbe1;
+  --qe-correct-fg: #1a7f37;
+  --qe-incorrect-fg: #cf222e;
+}
+body {
+  margin: 0;
+  background: var(--qe-bg);
+  color: var(--qe-fg);
+  font-family: var(--qe-font-family);
+  font-size: var(--qe-font-size);
+  line-height: var(--qe-line-height);
+}
+main.quiz {
+  max-width: var(--qe-max-width);
+  margin: 0 auto;
+  padding: calc(var(--qe-spacing) * 2) var(--qe-spacing);
+}
+h1 {
+  color: var(--qe-accent);
+  border-bottom: 1px solid var(--qe-border);
+  padding-bottom: calc(var(--qe-spacing) / 2);
+}
+.details {
+  display: grid;
+  grid-template-columns: max-content auto;
+  gap: 0.25em var(--qe-spacing);
+}
+.details dt {
+  font-weight: 600;
+}
+.details dd {
+  margin: 0;
+}
+.description {
+  color: var(--qe-muted);
+  border-left: 3px solid var(--qe-border);
+  padding-left: var(--qe-spacing);
+}
+.group {
+  border: 1px solid var(--qe-border);
+  border-radius: 6px;
+  padding: 0 var(--qe-spacing);
+  margin: var(--qe-spacing) 0;
+}
+.group-title {
+  color: var(--qe-accent);
+}
+.question {
+  border-bottom: 1px solid var(--qe-border);
+  padding: var(--qe-spacing) 0;
+}
+.question h2 {
+  font-size: 1.15em;
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+}
+.bank,
+.appeared-in {
+  color: var(--qe-muted);
+  font-size: 0.9em;
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+}
+.question-number {
+  color: var(--qe-muted);
+}
+.stem {
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+}
+.stem pre {
+  overflow-x: auto;
+  padding: calc(var(--qe-spacing) / 2);
+  border: 1px solid var(--qe-border);
+}
+.stem table {
+  border-collapse: collapse;
+}
+.stem th, .stem td {
+  border: 1px solid var(--qe-border);
+  padding: 0.25em 0.5em;
+}
+.stem img {
+  max-width: 100%;
+}
+.tags {
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+}
+.unanswered {
+  border-left: 3px solid var(--qe-muted);
+  color: var(--qe-muted);
+  font-style: italic;
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+  padding-left: 0.5em;
+}
+.key-confidence {
+  color: var(--qe-muted);
+  font-size: 0.9em;
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+}
+.key-confidence.inferred,
+.key-confidence.unknown {
+  color: var(--qe-incorrect-fg);
+}
+.response {
+  display: grid;
+  grid-template-columns: max-content auto;
+  gap: 0 var(--qe-spacing);
+  border-left: 3px solid var(--qe-incorrect-fg);
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+  padding-left: 0.5em;
+}
+.response.correct {
+  border-left-color: var(--qe-correct-fg);
+}
+.response dt {
+  font-weight: 600;
+}
+.response dd {
+  margin: 0;
+}
+.tag {
+  border: 1px solid var(--qe-muted);
+  border-radius: 3px;
+  color: var(--qe-muted);
+  font-size: 0.8em;
+  padding: 0 0.3em;
+}
+.media {
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+}
+.media audio,
+.media video {
+  display: block;
+  max-width: 100%;
+}
+.media figcaption {
+  color: var(--qe-muted);
+  font-size: 0.9em;
+}
+.options {
+  margin: 0 0 calc(var(--qe-spacing) / 2);
+}
+.option.correct,
+.matches td.correct {
+  background: var(--qe-correct-bg);
+  color: var(--qe-correct-fg);
+  font-weight: 600;
+}
+.correct-mark,
+.unavailable,
+.note {
+  color: var(--qe-muted);
+  font-style: italic;
+}
+.answer-label {
+  font-weight: 600;
+}
+.comment,
+.submission {
+  margin: calc(var(--qe-spacing) / 2) 0;
+  padding: 0.25em var(--qe-spacing);
+  border-left: 3px solid var(--qe-muted);
+}
+.comment cite {
+  font-weight: 600;
+  font-style: normal;
+}
+.comment p {
+  margin: 0.25em 0 0;
+}
+.rubric,
+.attempts,
+.matches {
+  border-collapse: collapse;
+  width: 100%;
+  margin: calc(var(--qe-spacing) / 2) 0;
+}
+.rubric th,
+.rubric td,
+.attempts th,
+.attempts td,
+.matches th,
+.matches td {
+  border: 1px solid var(--qe-border);
+  padding: 0.25em 0.5em;
+  text-align: left;
+  vertical-align: top;
+}
+.explanation {
+  border-left: 3px solid var(--qe-accent);
+  padding-left: calc(var(--qe-spacing) / 2);
+}
+.class-stats {
+  color: var(--qe-muted);
+}
+.option.selected {
+  font-weight: 600;
+}
+.passage .selectable {
+  border-bottom: 1px dotted var(--qe-muted);
+}
+.passage mark.correct {
+  background: var(--qe-correct-bg);
+  color: var(--qe-correct-fg);
+  font-weight: 600;
+}
+.word-bank li {
+  display: inline-block;
+  border: 1px solid var(--qe-border);
+  border-radius: 4px;
+  padding: 0 0.4em;
+  margin: 0 0.3em 0.3em 0;
+}
+.footnotes {
+  color: var(--qe-muted);
+  font-size: 0.9em;
+  border-top: 1px dashed var(--qe-border);
+  padding-top: calc(var(--qe-spacing) / 2);
+}
+`
+
+// themeCSS holds the built-in -theme overrides. Themes only reassign the --qe-* variables
+// (plus spacing for compact), so a user stylesheet still applies on top of any of them.
+var themeCSS = map[string]string{
+	"light": "",
+	"dark": `:root {
+  --qe-bg: #0d1117;
+  --qe-fg: #e6edf3;
+  --qe-muted: #8d96a0;
+  --qe-border: #30363d;
+  --qe-accent: #4493f8;
+  --qe-correct-bg: #12361f;
+  --qe-correct-fg: #56d364;
+}
+`,
+	"sepia": `:root {
+  --qe-font-family: Georgia, "Times New Roman", serif;
+  --qe-line-height: 1.6;
+  --qe-bg: #f4ecd8;
+  --qe-fg: #433422;
+  --qe-muted: #7a6a53;
+  --qe-border: #d8c8a8;
+  --qe-accent: #8b4513;
+  --qe-correct-bg: #e3d9b8;
+  --qe-correct-fg: #2f5d1e;
+}
+`,
+	"compact": `:root {
+  --qe-font-size: 13px;
+  --qe-line-height: 1.3;
+  --qe-max-width: 60rem;
+  --qe-spacing: 0.5rem;
+}
+.question h2 {
+  font-size: 1em;
+}
+.options,
+.answers,
+.blanks {
+  padding-left: 1.25rem;
+}
+`,
+}
+
+// HTMLOptions controls the optional parts of the HTML renderer.
+type HTMLOptions struct {
+	Theme    string // key of themeCSS; empty means light
+	CSSPath  string // user stylesheet appended after the defaults
+	CSSMode  string // "inline" embeds the stylesheet, "link" references it
+	OutPath  string // used to make linked stylesheet paths relative
+	Math     string // "cdn" loads MathJax when the document has TeX, "offline" embeds KaTeX; "none" leaves it as text
+	KaTeXDir string // KaTeX distribution embedded by Math "offline"
+	LinkBase string // URL that relative links and images in question bodies resolve against
+	Lang     string // the page's lang attribute, e.g. "es" to go with QuizDoc.Labels; empty means "en"
+}
+
+// RenderHTML renders the document as a standalone HTML page.
+func RenderHTML(doc QuizDoc, opts HTMLOptions) (string, error) {
+	esc := html.EscapeString
+	doc = doc.translateBlanks()
+	l := doc.Labels
+	lang := opts.Lang
+	if lang == "" {
+		lang = "en"
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<!DOCTYPE html>\n<html lang=\"%s\">\n<head>\n<meta charset=\"utf-8\">\n", esc(lang)))
+	sb.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	sb.WriteString(fmt.Sprintf("<title>%s</title>\n", esc(doc.Title)))
+	sb.WriteString("<style>\n" + defaultCSS + "</style>\n")
+	if opts.Theme != "" {
+		theme, ok := themeCSS[opts.Theme]
+		if !ok {
+			return "", fmt.Errorf("unknown theme %q (want light, dark, sepia or compact)", opts.Theme)
+		}
+		if theme != "" {
+			sb.WriteString("<style>\n" + theme + "</style>\n")
+		}
+	}
+	if opts.CSSPath != "" {
+		switch opts.CSSMode {
+		case "", "inline":
+			b, err := os.ReadFile(opts.CSSPath)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString("<style>\n" + string(b))
+			if !strings.HasSuffix(string(b), "\n") {
+				sb.WriteString("\n")
+			}
+			sb.WriteString("</style>\n")
+		case "link":
+			href := opts.CSSPath
+			if !strings.Contains(href, "://") && opts.OutPath != "" {
+				if abs, err := filepath.Abs(href); err == nil {
+					if rel, err := filepath.Rel(filepath.Dir(opts.OutPath), abs); err == nil {
+						href = filepath.ToSlash(rel)
+					}
+				}
+			}
+			sb.WriteString(fmt.Sprintf("<link rel=\"stylesheet\" href=\"%s\">\n", esc(href)))
+		default:
+			return "", fmt.Errorf("unknown css mode %q (want inline or link)", opts.CSSMode)
+		}
+	}
+	switch opts.Math {
+	case "", "none":
+	case "cdn":
+		if docHasMath(doc) {
+			sb.WriteString(mathJaxCDN)
+		}
+	case "offline":
+		if opts.KaTeXDir == "" {
+			return "", errors.New("-math offline needs -katex-dir")
+		}
+		if docHasMath(doc) {
+			katex, err := katexOffline(opts.KaTeXDir)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(katex)
+		}
+	default:
+		return "", fmt.Errorf("unknown math mode %q (want cdn, offline or none)", opts.Math)
+	}
+	sb.WriteString("</head>\n<body>\n<main class=\"quiz\">\n")
+	sb.WriteString(fmt.Sprintf("<h1>%s</h1>\n", esc(doc.Title)))
+	if len(doc.Details) > 0 {
+		sb.WriteString("<dl class=\"details\">\n")
+		for _, d := range doc.Details {
+			sb.WriteString(fmt.Sprintf("<dt>%s</dt><dd>%s</dd>\n", esc(l.t(d.Label)), esc(d.Value)))
+		}
+		sb.WriteString("</dl>\n")
+	}
+	if len(doc.Description) > 0 {
+		sb.WriteString("<section class=\"description\">\n")
+		for _, p := range doc.Description {
+			sb.WriteString(fmt.Sprintf("<p>%s</p>\n", esc(p)))
+		}
+		sb.WriteString("</section>\n")
+	}
+	if len(doc.Comments) > 0 {
+		sb.WriteString("<section class=\"comments\">\n<p class=\"answer-label\">Instructor comments on this submission:</p>\n")
+		writeHTMLComments(&sb, doc.Comments, l)
+		sb.WriteString("</section>\n")
+	}
+	if note := doc.notice(); note != "" {
+		sb.WriteString("<p class=\"note\">" + esc(note) + "</p>\n")
+	}
+
+	inGroup, inStimulus := false, false
+	for i, q := range doc.Questions {
+		var prev *Question
+		if i > 0 {
+			prev = &doc.Questions[i-1]
+		}
+		if inStimulus && (groupChange(prev, &q) || stimulusChange(prev, &q)) {
+			sb.WriteString("</section>\n")
+			inStimulus = false
+		}
+		if groupChange(prev, &q) {
+			if inGroup {
+				sb.WriteString("</section>\n")
+				inGroup = false
+			}
+			if q.Group != nil {
+				sb.WriteString("<section class=\"group\">\n")
+				sb.WriteString(fmt.Sprintf("<h2 class=\"group-title\">%s</h2>\n", esc(q.Group.Title)))
+				if rule := q.Group.Rule(); rule != "" {
+					sb.WriteString(fmt.Sprintf("<p class=\"note\">Questions drawn at random: %s.</p>\n", esc(rule)))
+				}
+				inGroup = true
+			}
+		}
+		if q.Stimulus != nil && !inStimulus {
+			sb.WriteString("<section class=\"group stimulus\">\n")
+			sb.WriteString(fmt.Sprintf("<h2 class=\"group-title\">%s</h2>\n", esc(q.Stimulus.Heading())))
+			sb.WriteString("<div class=\"stem\">\n" + sanitizeHTML(q.Stimulus.HTML, opts.LinkBase, doc.Assets) + "\n</div>\n")
+			inStimulus = true
+		}
+		sb.WriteString(fmt.Sprintf("<section class=\"question\" id=\"q%d\">\n", q.Number))
+		if q.BodyHTML != "" {
+			// Code, lists and tables don't fit a heading: the body follows it instead.
+			sb.WriteString(fmt.Sprintf("<h2 id=\"%s\"><span class=\"question-number\">%s %d</span></h2>\n", q.ContentID, esc(l.t("Question")), q.Number))
+			sb.WriteString("<div class=\"stem\">\n" + sanitizeHTML(q.BodyHTML, opts.LinkBase, doc.Assets) + "\n</div>\n")
+		} else {
+			sb.WriteString(fmt.Sprintf("<h2 id=\"%s\"><span class=\"question-number\">%d)</span> %s</h2>\n", q.ContentID, q.Number, esc(q.Text)))
+		}
+		if q.Bank != "" {
+			sb.WriteString(fmt.Sprintf("<p class=\"bank\">%s: %s</p>\n", esc(l.t("Bank")), esc(q.Bank)))
+		}
+		if len(q.Quizzes) > 0 {
+			sb.WriteString(fmt.Sprintf("<p class=\"appeared-in\">%s: %s</p>\n", esc(l.t("Appeared in")), esc(strings.Join(q.Quizzes, ", "))))
+		}
+		for _, m := range q.Media {
+			if m.Player {
+				sb.WriteString(fmt.Sprintf("<figure class=\"media\"><%s controls preload=\"none\" src=\"%s\"></%s><figcaption><a href=\"%s\">%s</a></figcaption></figure>\n",
+					m.Kind, html.EscapeString(m.URL), m.Kind, html.EscapeString(m.URL), esc(m.Label())))
+			} else {
+				sb.WriteString(fmt.Sprintf("<p class=\"media\">%s: <a href=\"%s\">%s</a></p>\n", esc(l.t("Media")), html.EscapeString(m.URL), esc(m.Label())))
+			}
+		}
+		if len(q.Tags) > 0 {
+			sb.WriteString("<p class=\"tags\">")
+			for _, t := range q.Tags {
+				sb.WriteString(fmt.Sprintf("<span class=\"tag\">%s</span> ", esc(t)))
+			}
+			sb.WriteString("</p>\n")
+		}
+		if q.Unanswered {
+			sb.WriteString("<p class=\"unanswered\">Left blank: no response was submitted.</p>\n")
+		}
+		if q.Formula != "" {
+			sb.WriteString(fmt.Sprintf("<p class=\"formula\">%s: <code>%s</code></p>\n", esc(l.t("Formula")), esc(q.Formula)))
+		}
+		if doc.AnnotateKeys && q.KeyConfidence != "" {
+			sb.WriteString(fmt.Sprintf("<p class=\"key-confidence %s\">%s: %s</p>\n", strings.Fields(q.KeyConfidence)[0], esc(l.t("Key")), q.KeyConfidence))
+		}
+		if c, ok := q.CheckResponse(); ok && doc.ShowResponses {
+			class := "response incorrect"
+			if c.Correct {
+				class = "response correct"
+			}
+			sb.WriteString(fmt.Sprintf("<dl class=\"%s\"><dt>%s</dt><dd>%s %s</dd>", class, esc(l.t("Your answer")), c.Mark(), esc(c.Response)))
+			if c.Key != "" {
+				sb.WriteString(fmt.Sprintf("<dt>%s</dt><dd>%s</dd>", esc(l.t("Correct answer")), esc(c.Key)))
+			}
+			sb.WriteString(fmt.Sprintf("<dt>%s</dt><dd>%s</dd></dl>\n", esc(l.t("Score")), c.Score))
+		}
+
+		if !q.HasResult {
+			sb.WriteString("<p class=\"note\">No result data.</p>\n")
+			writeHTMLExplanation(&sb, q, l)
+			sb.WriteString("</section>\n")
+			continue
+		}
+
+		if q.OpenEntry && !q.Ungraded {
+			if len(q.WordBank) > 0 {
+				sb.WriteString(fmt.Sprintf("<p class=\"answer-label\">%s:</p>\n<ul class=\"word-bank\">\n", esc(l.t("Word bank"))))
+				for _, w := range q.WordBank {
+					sb.WriteString(fmt.Sprintf("<li>%s</li>\n", esc(w)))
+				}
+				sb.WriteString("</ul>\n")
+			} else {
+				sb.WriteString("<p class=\"note\">Open entry.</p>\n")
+			}
+			sb.WriteString("<ul class=\"blanks\">\n")
+			for _, b := range q.Blanks {
+				ans := esc(b.Answer)
+				if b.Answer == "" {
+					ans = "<span class=\"unavailable\">(" + esc(l.t("answer unavailable")) + ")</span>"
+				}
+				if b.Example {
+					ans += " <span class=\"note\">(example match)</span>"
+				}
+				if len(b.Accepted) > 1 {
+					ans += fmt.Sprintf(" <span class=\"note\">(also accepted: %s)</span>", esc(strings.Join(b.Accepted[1:], ", ")))
+				}
+				rule := ""
+				if b.Rule != "" {
+					rule = fmt.Sprintf("<br><span class=\"note\">Matching: %s</span>", esc(b.Rule))
+				}
+				if b.Pattern != "" {
+					rule += fmt.Sprintf("<br><span class=\"note\">Pattern: <code>%s</code></span>", esc(b.Pattern))
+					if b.Meaning != "" {
+						rule += fmt.Sprintf("<br><span class=\"note\">Reads as: %s</span>", esc(b.Meaning))
+					}
+				}
+				sb.WriteString(fmt.Sprintf("<li><span class=\"answer-label\">%s:</span> %s%s</li>\n", esc(b.Label), ans, rule))
+			}
+			sb.WriteString("</ul>\n")
+			writeHTMLExplanation(&sb, q, l)
+			sb.WriteString("</section>\n")
+			continue
+		}
+
+		if q.Essay {
+			sb.WriteString("<p class=\"note\">Essay.</p>\n")
+			if len(q.Submission) > 0 {
+				sb.WriteString("<blockquote class=\"submission\">\n")
+				for _, p := range q.Submission {
+					sb.WriteString("<p>" + esc(p) + "</p>\n")
+				}
+				sb.WriteString("</blockquote>\n")
+			}
+			if len(q.Files) > 0 {
+				sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">%s:</span> %s</p>\n", esc(l.t("Files")), esc(strings.Join(q.Files, ", "))))
+			}
+			if a := q.awarded(); a != "" && !doc.ShowResponses {
+				sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">%s:</span> %s</p>\n", esc(l.t("Score")), a))
+			}
+			writeHTMLRubric(&sb, q.Rubric)
+			writeHTMLExplanation(&sb, q, l)
+			sb.WriteString("</section>\n")
+			continue
+		}
+
+		if len(q.Matches) > 0 {
+			writeHTMLMatches(&sb, q, doc.Practice, l)
+			writeHTMLExplanation(&sb, q, l)
+			sb.WriteString("</section>\n")
+			continue
+		}
+
+		if len(q.Categories) > 0 {
+			writeHTMLCategories(&sb, q, doc.Practice, l)
+			writeHTMLExplanation(&sb, q, l)
+			sb.WriteString("</section>\n")
+			continue
+		}
+
+		if len(q.Order) > 0 && !q.Ungraded {
+			writeHTMLOrder(&sb, q, l)
+			writeHTMLExplanation(&sb, q, l)
+			sb.WriteString("</section>\n")
+			continue
+		}
+
+		if q.Ungraded {
+			switch {
+			case doc.Practice:
+			case q.Scale:
+				sb.WriteString("<p class=\"note\">Ungraded scale item.</p>\n")
+			default:
+				sb.WriteString("<p class=\"note\">Ungraded.</p>\n")
+			}
+			if len(q.Options) > 0 {
+				sb.WriteString("<ol class=\"options scale\">\n")
+				for _, o := range q.Options {
+					if o.Selected {
+						sb.WriteString(fmt.Sprintf("<li class=\"option selected\">%s <span class=\"correct-mark\">(your response)</span></li>\n", esc(o.Label)))
+					} else {
+						sb.WriteString(fmt.Sprintf("<li class=\"option\">%s</li>\n", esc(o.Label)))
+					}
+				}
+				sb.WriteString("</ol>\n")
+			}
+			if !doc.Practice {
+				resp := "<span class=\"unavailable\">(" + esc(l.t("no response")) + ")</span>"
+				if len(q.Responses) > 0 {
+					resp = esc(strings.Join(q.Responses, ", "))
+				}
+				sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">%s:</span> %s</p>\n", esc(l.t("Your response")), resp))
+			}
+			writeHTMLExplanation(&sb, q, l)
+			sb.WriteString("</section>\n")
+			continue
+		}
+
+		if len(q.Passage) > 0 {
+			sb.WriteString("<blockquote class=\"passage\">")
+			for _, sp := range q.Passage {
+				switch {
+				case sp.Correct:
+					sb.WriteString("<mark class=\"correct\">" + esc(sp.Text) + "</mark>")
+				case sp.Selectable:
+					sb.WriteString("<span class=\"selectable\">" + esc(sp.Text) + "</span>")
+				default:
+					sb.WriteString(esc(sp.Text))
+				}
+			}
+			sb.WriteString("</blockquote>\n")
+		}
+
+		var footnotes []string
+		if len(q.Options) > 0 {
+			sb.WriteString("<ul class=\"options\">\n")
+			for i, o := range q.Options {
+				class, mark, ref := "option", "", ""
+				if o.Correct {
+					class += " correct"
+					mark = " <span class=\"correct-mark\">(" + esc(l.t("correct")) + ")</span>"
+				}
+				if o.Feedback != "" {
+					id := fmt.Sprintf("q%d-fn%d", q.Number, i+1)
+					footnotes = append(footnotes, fmt.Sprintf("<li id=\"%s\">%s</li>\n", id, esc(o.Feedback)))
+					ref = fmt.Sprintf("<sup class=\"footnote-ref\"><a href=\"#%s\">%d</a></sup>", id, len(footnotes))
+				}
+				sb.WriteString(fmt.Sprintf("<li class=\"%s\">%s%s%s</li>\n", class, esc(o.Label), mark, ref))
+			}
+			sb.WriteString("</ul>\n")
+		}
+
+		if q.Multi {
+			sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">%s:</span></p>\n<ul class=\"answers\">\n", esc(l.t("Correct answers"))))
+			for _, a := range q.Answers {
+				sb.WriteString(fmt.Sprintf("<li>%s</li>\n", esc(a)))
+			}
+			sb.WriteString("</ul>\n")
+		} else if len(q.Answers) == 1 {
+			sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">%s%s:</span> %s</p>\n", esc(q.givenPrefix()), esc(l.t("Answer")), esc(q.Answers[0])))
+		} else {
+			sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">%s:</span> <span class=\"unavailable\">(%s)</span></p>\n", esc(l.t("Answer")), esc(l.t("answer unavailable"))))
+		}
+		writeHTMLExplanation(&sb, q, l)
+		if len(footnotes) > 0 {
+			sb.WriteString("<ol class=\"footnotes\">\n" + strings.Join(footnotes, "") + "</ol>\n")
+		}
+		sb.WriteString("</section>\n")
+	}
+	if inStimulus {
+		sb.WriteString("</section>\n")
+	}
+	if inGroup {
+		sb.WriteString("</section>\n")
+	}
+	if refs := doc.References(); len(refs) > 0 {
+		sb.WriteString(fmt.Sprintf("<section class=\"references\">\n<h2>%s</h2>\n<ul>\n", esc(l.t("References"))))
+		for _, r := range refs {
+			cites := r.citedBy(func(n int, label string) string { return fmt.Sprintf("<a href=\"#q%d\">%s</a>", n, label) })
+			sb.WriteString(fmt.Sprintf("<li><a href=\"%s\">%s</a> — %s</li>\n", html.EscapeString(r.URL), esc(r.Text), cites))
+		}
+		sb.WriteString("</ul>\n</section>\n")
+	}
+	writeHTMLGlossary(&sb, doc.Glossary)
+	writeHTMLReplay(&sb, doc.Replay)
+	sb.WriteString("</main>\n</body>\n</html>\n")
+	return sb.String(), nil
+}
+
+func writeHTMLRubric(sb *strings.Builder, rubric []RubricCriterion) {
+	if len(rubric) == 0 {
+		return
+	}
+	esc := html.EscapeString
+	sb.WriteString("<table class=\"rubric\">\n<thead><tr><th>Criterion</th><th>Points</th><th>Ratings</th><th>Assessed</th></tr></thead>\n<tbody>\n")
+	for _, c := range rubric {
+		var ratings []string
+		for _, r := range c.Ratings {
+			ratings = append(ratings, fmt.Sprintf("%s (%s)", esc(r.Description), FormatPoints(r.Points)))
+		}
+		sb.WriteString(fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n", esc(c.Description), FormatPoints(c.Points), strings.Join(ratings, "<br>"), esc(c.Assessed.summary())))
+	}
+	sb.WriteString("</tbody>\n</table>\n")
+}
+
+// writeHTMLOrder is writeMarkdownOrder for HTML.
+func writeHTMLOrder(sb *strings.Builder, q Question, l Labels) {
+	list := func(label string, items []string) {
+		sb.WriteString(fmt.Sprintf("<p class=\"answer-label\">%s:</p>\n<ol class=\"order\">\n", html.EscapeString(l.t(label))))
+		for _, item := range items {
+			sb.WriteString("<li>" + html.EscapeString(item) + "</li>\n")
+		}
+		sb.WriteString("</ol>\n")
+	}
+	list("Correct order", q.Order)
+	if len(q.ResponseOrder) > 0 && !sameOrder(q.ResponseOrder, q.Order) {
+		list("Your order", q.ResponseOrder)
+	}
+}
+
+// writeHTMLCategories is writeMarkdownCategories for HTML.
+func writeHTMLCategories(sb *strings.Builder, q Question, practice bool, l Labels) {
+	esc := html.EscapeString
+	responses := !practice && len(q.Responses) > 0
+	sb.WriteString("<table class=\"matches\">\n<thead><tr><th>Category</th>")
+	if practice {
+		sb.WriteString("<th>Items</th>")
+	} else {
+		sb.WriteString("<th>Correct items</th>")
+	}
+	if responses {
+		sb.WriteString("<th>Your items</th>")
+	}
+	sb.WriteString("</tr></thead>\n<tbody>\n")
+	for _, c := range q.Categories {
+		members := "<td class=\"correct\">" + esc(strings.Join(c.Members, ", ")) + "</td>"
+		switch {
+		case practice:
+			members = "<td></td>"
+		case c.Members == nil:
+			members = "<td><span class=\"unavailable\">(" + esc(l.t("answer unavailable")) + ")</span></td>"
+		}
+		sb.WriteString("<tr><td>" + esc(c.Name) + "</td>" + members)
+		if responses {
+			sb.WriteString("<td>" + esc(c.responseMark()) + "</td>")
+		}
+		sb.WriteString("</tr>\n")
+	}
+	sb.WriteString("</tbody>\n</table>\n")
+	if practice && len(q.CategoryItems) > 0 {
+		sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">Items to sort:</span> %s</p>\n", esc(strings.Join(q.CategoryItems, ", "))))
+	} else if d := q.Distractors(); len(d) > 0 {
+		sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">%s:</span> %s</p>\n", esc(l.t("Distractors")), esc(strings.Join(d, ", "))))
+	}
+}
+
+// writeHTMLMatches is writeMarkdownMatches for HTML.
+func writeHTMLMatches(sb *strings.Builder, q Question, practice bool, l Labels) {
+	esc := html.EscapeString
+	responses := !practice && len(q.Responses) > 0
+	sb.WriteString("<table class=\"matches\">\n<thead><tr><th>Prompt</th>")
+	if practice {
+		sb.WriteString("<th>Match</th>")
+	} else {
+		sb.WriteString("<th>Correct match</th>")
+	}
+	if responses {
+		sb.WriteString("<th>Your match</th>")
+	}
+	sb.WriteString("</tr></thead>\n<tbody>\n")
+	for _, m := range q.Matches {
+		answer := "<td class=\"correct\">" + esc(m.Answer) + "</td>"
+		switch {
+		case practice:
+			answer = "<td></td>"
+		case m.Answer == "":
+			answer = "<td><span class=\"unavailable\">(" + esc(l.t("answer unavailable")) + ")</span></td>"
+		}
+		sb.WriteString("<tr><td>" + esc(m.Prompt) + "</td>" + answer)
+		if responses {
+			sb.WriteString("<td>" + esc(m.responseMark()) + "</td>")
+		}
+		sb.WriteString("</tr>\n")
+	}
+	sb.WriteString("</tbody>\n</table>\n")
+	if practice && len(q.MatchAnswers) > 0 {
+		sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">Answers to choose from:</span> %s</p>\n", esc(strings.Join(q.MatchAnswers, ", "))))
+	} else if d := q.Distractors(); len(d) > 0 {
+		sb.WriteString(fmt.Sprintf("<p class=\"answer\"><span class=\"answer-label\">%s:</span> %s</p>\n", esc(l.t("Distractors")), esc(strings.Join(d, ", "))))
+	}
+}
+
+func writeHTMLExplanation(sb *strings.Builder, q Question, l Labels) {
+	if len(q.Attempts) > 0 {
+		sb.WriteString("<table class=\"attempts\">\n<thead><tr><th>Attempt</th><th>Answer</th><th>Score</th></tr></thead>\n<tbody>\n")
+		for _, a := range q.Attempts {
+			sb.WriteString(fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n", html.EscapeString(a.Attempt), html.EscapeString(a.Answer), a.Score))
+		}
+		sb.WriteString("</tbody>\n</table>\n")
+	}
+	if q.Class != nil {
+		sb.WriteString(fmt.Sprintf("<p class=\"class-stats\"><span class=\"answer-label\">%s:</span> %s</p>\n", html.EscapeString(l.t("Class")), html.EscapeString(q.Class.Summary())))
+	}
+	if q.Explanation != "" {
+		sb.WriteString(fmt.Sprintf("<p class=\"explanation\"><span class=\"answer-label\">%s:</span> %s</p>\n", html.EscapeString(l.t("Explanation")), html.EscapeString(q.Explanation)))
+	}
+	writeHTMLComments(sb, q.Comments, l)
+}
+
+func writeHTMLComments(sb *strings.Builder, comments []Comment, l Labels) {
+	for _, c := range comments {
+		who := l.t("Instructor")
+		if c.Author != "" {
+			who = c.Author
+		}
+		cite := html.EscapeString(who)
+		if c.Date != "" {
+			cite += ", " + html.EscapeString(c.Date)
+		}
+		sb.WriteString(fmt.Sprintf("<blockquote class=\"comment\"><cite>%s</cite><p>%s</p></blockquote>\n", cite, html.EscapeString(c.Text)))
+	}
+}
+
+// ItemStats aggregates one question across a class's results.
+type ItemStats struct {
+	Question     Question       // stem, options and answer key (Selected/Responses are not meaningful)
+	Students     int            // students with a result for this item
+	Correct      int            // of those, how many Canvas scored correct
+	TotalScore   float64        // sum of per-student scores
+	ChoiceCounts map[string]int // option ID -> students who selected it
+	Responses    map[string]int // free-text responses (blanks) -> students who gave them
+}
+
+// PercentCorrect is the share of students who answered correctly, 0..100.
+func (s ItemStats) PercentCorrect() float64 {
+	if s.Students == 0 {
+		return 0
+	}
+	return 100 * float64(s.Correct) / float64(s.Students)
+}
+
+// AverageScore is the mean points awarded to students with a result.
+func (s ItemStats) AverageScore() float64 {
+	if s.Students == 0 {
+		return 0
+	}
+	return s.TotalScore / float64(s.Students)
+}
+
+// Distractors returns the incorrect options students chose, most chosen first. Items
+// without an answer key (ungraded, or no student got a key back) have none.
+func (s ItemStats) Distractors() []Option {
+	if s.Question.Ungraded {
+		return nil
+	}
+	keyed := false
+	var out []Option
+	for _, o := range s.Question.Options {
+		if o.Correct {
+			keyed = true
+		} else if s.ChoiceCounts[o.ID] > 0 {
+			out = append(out, o)
+		}
+	}
+	if !keyed {
+		return nil
+	}
+	sort.SliceStable(out, func(i, j int) bool { return s.ChoiceCounts[out[i].ID] > s.ChoiceCounts[out[j].ID] })
+	return out
+}
+
+// ChoicePercent is the share of students who selected option id, 0..100.
+func (s ItemStats) ChoicePercent(id string) float64 {
+	if s.Students == 0 {
+		return 0
+	}
+	return 100 * float64(s.ChoiceCounts[id]) / float64(s.Students)
+}
+
+// ItemAnalysis is the class-wide report produced from a directory of result files.
+type ItemAnalysis struct {
+	Title     string
+	Students  int // result files read
+	Items     []ItemStats
+	Threshold float64 // distractors chosen by more than this percent of students are flagged
+	GroupBy   string  // gradebook column Groups split the class by
+	Groups    []GroupAnalysis
+}
+
+// flagged reports whether a distractor drew enough students to deserve lecture review.
+func (a ItemAnalysis) flagged(st ItemStats, o Option) bool {
+	return st.ChoicePercent(o.ID) > a.Threshold
+}
+
+// PayloadShape classifies a JSON payload: "quiz" for New Quizzes items or a Classic Quizzes
+// export, "results" for item results (bare or wrapped, as in SpeedGrader), "" otherwise.
+func PayloadShape(b []byte) string {
+	if _, ok, err := ParseClassicQuestions(b); ok && err == nil {
+		return "quiz"
+	}
+	var v any
+	if json.Unmarshal(b, &v) != nil {
+		return ""
+	}
+	if _, ok := findQuizItems(v); ok {
+		return "quiz"
+	}
+	if _, ok := findResultItems(v); ok {
+		return "results"
+	}
+	return ""
+}
+
+// DescribePayload says in a few words what a JSON payload holds, for an error about a payload
+// that is not the one expected: "quiz items", "a Classic Quizzes export", "item results", "a
+// Canvas API error: ...", "a quiz_submissions list, ..." or an outline of its keys.
+func DescribePayload(b []byte) string {
+	if _, ok, err := ParseClassicQuestions(b); ok && err == nil {
+		return "a Classic Quizzes export"
+	}
+	if subs, ok, err := ParseClassicSubmissions(b); ok && err == nil && len(subs) > 0 && subs[len(subs)-1].Answers != nil {
+		return "a Classic Quizzes submission history, which goes with the Classic Quizzes questions"
+	}
+	switch PayloadShape(b) {
+	case "quiz":
+		return "quiz items"
+	case "results":
+		return "item results"
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return "no valid JSON"
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		if msg, ok := canvasError(t); ok {
+			return "a Canvas API error: " + msg
+		}
+		if _, ok := t["quiz_submissions"]; ok {
+			return "a quiz_submissions list, which has each attempt's score but not its item results"
+		}
+		return "an object with " + keyOutline(t)
+	case []any:
+		if len(t) == 0 {
+			break
+		}
+		if obj, ok := t[0].(map[string]any); ok {
+			if len(t) == 1 {
+				return "a list of 1 object with " + keyOutline(obj)
+			}
+			return fmt.Sprintf("a list of %d objects with %s", len(t), keyOutline(obj))
+		}
+	}
+	return valueShape(b)
+}
+
+// canvasError returns the message of a Canvas API error body, {"errors": [{"message": ...}]}
+// or {"errors": {...}}; ok is false for anything else.
+func canvasError(obj map[string]any) (msg string, ok bool) {
+	errs, ok := obj["errors"]
+	if !ok {
+		return "", false
+	}
+	var msgs []string
+	if list, isList := errs.([]any); isList {
+		for _, e := range list {
+			if m, _ := e.(map[string]any); m != nil {
+				if s, _ := m["message"].(string); s != "" {
+					msgs = append(msgs, s)
+				}
+			}
+		}
+	}
+	if len(msgs) == 0 {
+		return "no message given", true
+	}
+	return strings.Join(msgs, "; "), true
+}
+
+// keyOutline lists an object's keys for DescribePayload, sorted, the first five by name.
+func keyOutline(obj map[string]any) string {
+	if len(obj) == 0 {
+		return "no keys"
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > 5 {
+		return fmt.Sprintf("keys %s and %d more", strings.Join(keys[:5], ", "), len(keys)-5)
+	}
+	return "keys " + strings.Join(keys, ", ")
+}
+
+// firstHasKeys reports whether the array b holds is empty or starts with an object with all
+// of keys. Only the first element is decoded, so a large export is not parsed twice.
+func firstHasKeys(b []byte, keys ...string) bool {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return false
+	}
+	if !dec.More() {
+		return true
+	}
+	var first map[string]json.RawMessage
+	if dec.Decode(&first) != nil {
+		return false
+	}
+	for _, k := range keys {
+		if _, ok := first[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeQuizItems decodes New Quizzes items: the bare array, or the first array of items
+// nested in a wrapper object such as {"items": [...]}.
+func DecodeQuizItems(b []byte) ([]QuizItem, error) {
+	quiz, err := decodeQuizEntries(b)
+	if err != nil {
+		return nil, err
+	}
+	return attachStimuli(quiz), nil
+}
+
+// DecodeQuizPages decodes the pages of a quiz export paginated into several payloads, each
+// as DecodeQuizItems would, and joins them in order. A stimulus on one page is attached to
+// its items on the others, and an entry on more than one page, as when pages overlap, is
+// kept once.
+func DecodeQuizPages(pages [][]byte) ([]QuizItem, error) {
+	var quiz []QuizItem
+	seen := map[string]bool{}
+	for i, b := range pages {
+		page, err := decodeQuizEntries(b)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %v", i+1, err)
+		}
+		for _, q := range page {
+			key := q.Item.ID
+			if strings.EqualFold(q.EntryType, "Stimulus") {
+				key = "stimulus " + q.ID
+			}
+			if key != "" && seen[key] {
+				continue
+			}
+			seen[key] = true
+			quiz = append(quiz, q)
+		}
+	}
+	return attachStimuli(quiz), nil
+}
+
+// decodeQuizEntries is DecodeQuizItems without attaching the stimuli, which DecodeQuizPages
+// does once every page is read.
+func decodeQuizEntries(b []byte) ([]QuizItem, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) && !firstHasKeys(b, "item") {
+		return nil, fmt.Errorf("no quiz items (objects with an item) found in payload: it holds %s", DescribePayload(b))
+	}
+	var quiz []QuizItem
+	err := json.Unmarshal(b, &quiz)
+	if err == nil {
+		return quiz, nil
+	}
+	var v any
+	if json.Unmarshal(b, &v) != nil {
+		return nil, err
+	}
+	if _, isArray := v.([]any); isArray {
+		return nil, err
+	}
+	found, ok := findQuizItems(v)
+	if !ok {
+		return nil, fmt.Errorf("no quiz items (objects with an item) found in payload: it holds %s", DescribePayload(b))
+	}
+	if b, err = json.Marshal(found); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &quiz); err != nil {
+		return nil, err
+	}
+	return quiz, nil
+}
+
+// DecodeResults decodes one student's item results: the bare session item results array, or
+// the wrapped payloads SpeedGrader loads for a quiz submission, where the same array is
+// nested inside submission/session objects.
+func DecodeResults(b []byte) ([]ResultItem, error) {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if _, isArray := v.([]any); isArray && !firstHasKeys(b, "item_id") {
+		return nil, fmt.Errorf("no item results (objects with item_id and scored_data) found in payload: it holds %s", DescribePayload(b))
+	} else if !isArray {
+		found, ok := findResultItems(v)
+		if !ok {
+			return nil, fmt.Errorf("no item results (objects with item_id and scored_data) found in payload: it holds %s", DescribePayload(b))
+		}
+		var err error
+		if b, err = json.Marshal(found); err != nil {
+			return nil, err
+		}
+	}
+	var results []ResultItem
+	return results, json.Unmarshal(b, &results)
+}
+
+// errNotStreamable is returned by streamArray for payloads it cannot read element by element.
+var errNotStreamable = errors.New("not a bare or top-level wrapped array of items")
+
+// StreamQuizItems decodes New Quizzes items from r one at a time and calls each with every
+// one as it is decoded, so memory follows the largest item rather than the whole export, as
+// long as each doesn't keep the items. It reads the bare array, or a wrapper object holding
+// it under a top-level key such as {"items": [...]}. For anything else (a Classic Quizzes
+// export, items nested deeper, JSON that needs repairs) it returns an error, and the caller
+// falls back to DecodeQuizItems on the whole payload; each may have been called by then.
+// Stimulus entries are passed on like the questions: only ReadQuizItems, which has them all,
+// attaches them to the questions that refer to them. An error from each stops the stream and
+// is returned.
+func StreamQuizItems(r io.Reader, each func(QuizItem) error) error {
+	return streamArray(r, []string{"item"}, func(raw json.RawMessage) error {
+		var q QuizItem
+		if err := json.Unmarshal(raw, &q); err != nil {
+			return err
+		}
+		return each(q)
+	})
+}
+
+// StreamResults is StreamQuizItems for item results (see DecodeResults): the bare array, or
+// one held under a top-level key. SpeedGrader payloads nest it deeper and need DecodeResults.
+func StreamResults(r io.Reader, each func(ResultItem) error) error {
+	return streamArray(r, []string{"item_id", "scored_data"}, func(raw json.RawMessage) error {
+		var res ResultItem
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		return each(res)
+	})
+}
+
+// ReadQuizItems collects the items of StreamQuizItems, with their stimuli attached. Memory
+// follows the decoded items, which are all kept; what it saves over DecodeQuizItems is
+// holding the file, and for a wrapper object its generic JSON tree, at the same time.
+func ReadQuizItems(r io.Reader) ([]QuizItem, error) {
+	var quiz []QuizItem
+	err := StreamQuizItems(r, func(q QuizItem) error {
+		quiz = append(quiz, q)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attachStimuli(quiz), nil
+}
+
+// ReadResults collects the results of StreamResults, as ReadQuizItems does the items.
+func ReadResults(r io.Reader) ([]ResultItem, error) {
+	var results []ResultItem
+	err := StreamResults(r, func(res ResultItem) error {
+		results = append(results, res)
+		return nil
+	})
+	return results, err
+}
+
+// streamArray calls each with every element of the array r holds, bare or as the value of a
+// top-level key, whose first element is an object with all of keys. Other values in a wrapper
+// object are skipped without being kept. An empty bare array has no elements to check, so it
+// is accepted; in a wrapper, the first non-empty matching array is used, as findArray does.
+func streamArray(r io.Reader, keys []string, each func(json.RawMessage) error) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('['):
+		n, err := streamElements(dec, keys, each)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errNotStreamable
+		}
+	case json.Delim('{'):
+		found := false
+		for dec.More() {
+			if _, err := dec.Token(); err != nil { // the key
+				return err
+			}
+			if found {
+				if err := dec.Decode(new(json.RawMessage)); err != nil {
+					return err
+				}
+				continue
+			}
+			if open, err := dec.Token(); err != nil {
+				return err
+			} else if open == json.Delim('[') {
+				n, err := streamElements(dec, keys, each)
+				if err != nil {
+					return err
+				}
+				found = n > 0
+			} else if d, isDelim := open.(json.Delim); isDelim {
+				if err := skipValue(dec, d); err != nil {
+					return err
+				}
+			}
+		}
+		if !found {
+			return errNotStreamable
+		}
+		if _, err := dec.Token(); err != nil { // the closing brace
+			return err
+		}
+	default:
+		return errNotStreamable
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errNotStreamable // trailing data: several documents, which need recovery
+	}
+	return nil
+}
+
+// streamElements reads the rest of an array whose opening bracket dec has consumed and
+// returns how many elements it passed to each: all of them when the first is an object with
+// all of keys. Otherwise the array is skipped and n is -1.
+func streamElements(dec *json.Decoder, keys []string, each func(json.RawMessage) error) (n int, err error) {
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return n, err
+		}
+		if n == 0 {
+			var obj map[string]json.RawMessage
+			if json.Unmarshal(raw, &obj) != nil {
+				return -1, skipValue(dec, json.Delim('['))
+			}
+			for _, k := range keys {
+				if _, ok := obj[k]; !ok {
+					return -1, skipValue(dec, json.Delim('['))
+				}
+			}
+		}
+		if err := each(raw); err != nil {
+			return n, err
+		}
+		n++
+	}
+	_, err = dec.Token() // the closing bracket
+	return n, err
+}
+
+// skipValue reads past the rest of an array or object whose opening delimiter dec has
+// consumed.
+func skipValue(dec *json.Decoder, open json.Delim) error {
+	if open != '[' && open != '{' {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('['), json.Delim('{'):
+			depth++
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// findResultItems returns the first array, depth-first in key order, whose elements are
+// item results.
+func findResultItems(v any) ([]any, bool) {
+	return findArray(v, "item_id", "scored_data")
+}
+
+// findQuizItems returns the first array, depth-first in key order, whose elements are New
+// Quizzes items.
+func findQuizItems(v any) ([]any, bool) {
+	return findArray(v, "item")
+}
+
+// findArray returns the first array, depth-first in key order, whose first element is an
+// object with all of keys.
+func findArray(v any, keys ...string) ([]any, bool) {
+	switch t := v.(type) {
+	case []any:
+		if len(t) > 0 {
+			if obj, ok := t[0].(map[string]any); ok {
+				all := true
+				for _, k := range keys {
+					_, has := obj[k]
+					all = all && has
+				}
+				if all {
+					return t, true
+				}
+			}
+		}
+		for _, e := range t {
+			if found, ok := findArray(e, keys...); ok {
+				return found, true
+			}
+		}
+	case map[string]any:
+		names := make([]string, 0, len(t))
+		for k := range t {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			if found, ok := findArray(t[k], keys...); ok {
+				return found, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// NotInGradebook groups the result files whose name matches no gradebook row.
+const NotInGradebook = "(not in gradebook)"
+
+// GroupAnalysis is the item analysis of one group of students, e.g. a section.
+type GroupAnalysis struct {
+	Name     string
+	Analysis ItemAnalysis
+}
+
+// AnalyzeGroups analyzes the students of each group separately, groups[i] naming the group of
+// class[i]. Score bands come highest first, other groups alphabetically, NotInGradebook last.
+func AnalyzeGroups(quiz []QuizItem, class [][]ResultItem, groups []string, threshold float64) []GroupAnalysis {
+	members := map[string][][]ResultItem{}
+	var names []string
+	for i, g := range groups {
+		if _, ok := members[g]; !ok {
+			names = append(names, g)
+		}
+		members[g] = append(members[g], class[i])
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		if (a == NotInGradebook) != (b == NotInGradebook) {
+			return b == NotInGradebook
+		}
+		fa, errA := strconv.ParseFloat(strings.Split(a, "–")[0], 64)
+		fb, errB := strconv.ParseFloat(strings.Split(b, "–")[0], 64)
+		if errA == nil && errB == nil {
+			return fa > fb
+		}
+		return a < b
+	})
+	var out []GroupAnalysis
+	for _, n := range names {
+		out = append(out, GroupAnalysis{Name: n, Analysis: AnalyzeResults(quiz, members[n], n, threshold)})
+	}
+	return out
+}
+
+// AnalyzeResults builds each student's document and tallies it per question.
+func AnalyzeResults(quiz []QuizItem, class [][]ResultItem, title string, threshold float64) ItemAnalysis {
+	a := ItemAnalysis{Title: title, Students: len(class), Threshold: threshold}
+	base := BuildQuizDoc(quiz, nil, title)
+	for _, q := range base.Questions {
+		a.Items = append(a.Items, ItemStats{Question: q, ChoiceCounts: map[string]int{}, Responses: map[string]int{}})
+	}
+	for _, results := range class {
+		doc := BuildQuizDoc(quiz, results, title)
+		for i, q := range doc.Questions {
+			if !q.HasResult || i >= len(a.Items) {
+				continue
+			}
+			res, _ := findResultByID(results, q.ItemID)
+			a.Items[i].add(q, res)
+		}
+	}
+	return a
+}
+
+// add tallies one student's question q, scored in res. Results only reveal the key for what
+// a student saw, so it is merged across the class. Options are matched by ID, or by label
+// when they have none, since students may have seen them in different shuffles.
+func (st *ItemStats) add(q Question, res ResultItem) {
+	if !st.Question.HasResult {
+		st.Question = q
+	}
+	for _, o := range q.Options {
+		if !o.Correct {
+			continue
+		}
+		for j, known := range st.Question.Options {
+			if known.ID == o.ID && (o.ID != "" || known.Label == o.Label) {
+				st.Question.Options[j].Correct = true
+				break
+			}
+		}
+	}
+	st.Students++
+	st.TotalScore += res.Score
+	if res.Scored.Correct {
+		st.Correct++
+	}
+	for _, o := range q.Options {
+		if o.Selected {
+			st.ChoiceCounts[o.ID]++
+		}
+	}
+	if len(q.Options) == 0 {
+		for _, r := range q.Responses {
+			st.Responses[r]++
+		}
+	}
+}
+
+// RenderItemAnalysisMarkdown renders the summary table and per-question choice counts.
+func RenderItemAnalysisMarkdown(a ItemAnalysis) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# %s\n\n", a.Title))
+	sb.WriteString(fmt.Sprintf("- Students: %d\n\n", a.Students))
+	sb.WriteString("| # | Question | Students | % correct | Avg score |\n")
+	sb.WriteString("|---|----------|----------|-----------|-----------|\n")
+	for _, st := range a.Items {
+		pct, avg := "—", "—"
+		if st.Students > 0 && !st.Question.Ungraded {
+			pct = fmt.Sprintf("%.0f%%", st.PercentCorrect())
+			avg = fmt.Sprintf("%.2f", st.AverageScore())
+		}
+		sb.WriteString(fmt.Sprintf("| %d | %s | %d | %s | %s |\n", st.Question.Number, MarkdownCell(truncateText(st.Question.Text, 60)), st.Students, pct, avg))
+	}
+	sb.WriteString("\n")
+	if len(a.Groups) > 0 {
+		writeGroupTable(&sb, a)
+	}
+
+	var review []string
+	for _, st := range a.Items {
+		for _, o := range st.Distractors() {
+			if a.flagged(st, o) {
+				review = append(review, fmt.Sprintf("- Q%d: %s — %.0f%% (%d of %d)", st.Question.Number, o.Label, st.ChoicePercent(o.ID), st.ChoiceCounts[o.ID], st.Students))
+			}
+		}
+	}
+	if len(review) > 0 {
+		sb.WriteString(fmt.Sprintf("## Distractors to review\n\nIncorrect options chosen by more than %s%% of students:\n\n", FormatPoints(a.Threshold)))
+		sb.WriteString(strings.Join(review, "\n") + "\n\n")
+	}
+
+	for _, st := range a.Items {
+		q := st.Question
+		sb.WriteString(fmt.Sprintf("## %d) %s\n", q.Number, q.Text))
+		if st.Students == 0 {
+			sb.WriteString("- (no result data)\n\n")
+			continue
+		}
+		if len(q.Options) > 0 {
+			sb.WriteString("\n| Choice | Count | % |\n|--------|-------|---|\n")
+			for _, o := range q.Options {
+				label := MarkdownCell(o.Label)
+				if o.Correct {
+					label += " ✅"
+				}
+				sb.WriteString(fmt.Sprintf("| %s | %d | %.0f%% |\n", label, st.ChoiceCounts[o.ID], st.ChoicePercent(o.ID)))
+			}
+			sb.WriteString("\n")
+			if d := st.Distractors(); len(d) > 0 {
+				top := d[0]
+				note := ""
+				if a.flagged(st, top) {
+					note = " — flagged for review"
+				}
+				sb.WriteString(fmt.Sprintf("- Top distractor: %s (%.0f%%)%s\n\n", top.Label, st.ChoicePercent(top.ID), note))
+			}
+			continue
+		}
+		if len(st.Responses) > 0 {
+			sb.WriteString("\n| Response | Count |\n|----------|-------|\n")
+			for _, r := range sortedCounts(st.Responses) {
+				sb.WriteString(fmt.Sprintf("| %s | %d |\n", MarkdownCell(r), st.Responses[r]))
+			}
+			sb.WriteString("\n")
+			continue
+		}
+		sb.WriteString("- (no choice data)\n\n")
+	}
+	return sb.String()
+}
+
+// writeGroupTable writes the percent correct of every question per group of students, with
+// each group's mean total score in the last row.
+func writeGroupTable(sb *strings.Builder, a ItemAnalysis) {
+	sb.WriteString(fmt.Sprintf("## By %s\n\n| # | Question |", a.GroupBy))
+	for _, g := range a.Groups {
+		sb.WriteString(fmt.Sprintf(" %s (%d) |", MarkdownCell(g.Name), g.Analysis.Students))
+	}
+	sb.WriteString("\n|---|----------|" + strings.Repeat("---|", len(a.Groups)) + "\n")
+	totals := make([]float64, len(a.Groups))
+	for i, st := range a.Items {
+		sb.WriteString(fmt.Sprintf("| %d | %s |", st.Question.Number, MarkdownCell(truncateText(st.Question.Text, 40))))
+		for j, g := range a.Groups {
+			gst := g.Analysis.Items[i]
+			cell := "—"
+			if gst.Students > 0 && !st.Question.Ungraded {
+				cell = fmt.Sprintf("%.0f%%", gst.PercentCorrect())
+				totals[j] += gst.AverageScore()
+			}
+			sb.WriteString(" " + cell + " |")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("|  | Avg total score |")
+	for _, t := range totals {
+		sb.WriteString(fmt.Sprintf(" %.2f |", t))
+	}
+	sb.WriteString("\n\n")
+}
+
+// sortedCounts returns the keys of counts, most frequent first, then alphabetically.
+func sortedCounts(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// truncateText shortens s to at most n runes, marking the cut with an ellipsis.
+func truncateText(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
+
+// QuizStats is the per-quiz summary row sent to trackers such as Google Sheets. For a class
+// (-results-dir) Score is the mean total score across students.
+type QuizStats struct {
+	Label     string
+	Title     string
+	Questions int
+	Students  int
+	Score     float64
+	Possible  float64
+}
+
+// Percent is Score as a share of Possible, 0..100.
+func (s QuizStats) Percent() float64 {
+	if s.Possible == 0 {
+		return 0
+	}
+	return 100 * s.Score / s.Possible
+}
+
+// ComputeStats totals the points of the questions in doc across one or more students' results.
+func ComputeStats(doc QuizDoc, label FileLabel, quiz []QuizItem, class [][]ResultItem) QuizStats {
+	st := QuizStats{Label: label.Label, Title: doc.Title, Questions: len(doc.Questions), Students: len(class)}
+	if st.Label == "" {
+		st.Label = label.Title
+	}
+	possible := map[string]float64{}
+	for _, q := range quiz {
+		possible[q.Item.ID] = q.PointsPossible
+	}
+	for _, q := range doc.Questions {
+		st.Possible += possible[q.ItemID]
+	}
+	if len(class) == 0 {
+		return st
+	}
+	var total float64
+	for _, results := range class {
+		for _, q := range doc.Questions {
+			if res, err := findResultByID(results, q.ItemID); err == nil {
+				total += res.Score
+			}
+		}
+	}
+	st.Score = total / float64(len(class))
+	return st
+}
+
+// ReportedScore is the total Canvas reports for the attempt, to check the items against:
+// the submission's score and the quiz's points possible. Nil fields are not reported.
+type ReportedScore struct {
+	Score    *float64
+	Possible *float64
+}
+
+// ScoreCheck is a quiz's total recomputed from its questions (see VerifyScore).
+type ScoreCheck struct {
+	Earned     float64  // points scored, summed over the questions with a score
+	Possible   float64  // points possible of those questions
+	Scored     int      // questions with a score
+	Mismatches []string // every disagreement found, in a sentence each
+}
+
+// scoreTolerance absorbs the rounding of partial credit, e.g. three thirds of a point.
+const scoreTolerance = 0.01
+
+// VerifyScore adds up the score and points possible of every scored question of doc and
+// compares them with what else is known about the attempt: each question's own points
+// (a score above the points possible, or below zero, is suspect), the item results the
+// document was built from, when given (a difference means an item was not read), and the
+// totals Canvas reports (a difference usually means a regrade, fudge points or a late
+// penalty).
+func VerifyScore(doc QuizDoc, results []ResultItem, reported ReportedScore) ScoreCheck {
+	var c ScoreCheck
+	pts := func(v float64) string { return FormatPoints(RoundTo(v, 2)) }
+	for _, q := range doc.Questions {
+		if q.Earned == nil || q.Ungraded {
+			continue
+		}
+		c.Scored++
+		c.Earned += *q.Earned
+		c.Possible += q.Possible
+		switch {
+		case *q.Earned > q.Possible+scoreTolerance:
+			c.Mismatches = append(c.Mismatches, fmt.Sprintf("question %d scores %s of %s points possible", q.Number, pts(*q.Earned), pts(q.Possible)))
+		case *q.Earned < 0:
+			c.Mismatches = append(c.Mismatches, fmt.Sprintf("question %d scores %s, below zero", q.Number, pts(*q.Earned)))
+		}
+	}
+	if len(results) > 0 {
+		var sum float64
+		for _, r := range results {
+			sum += r.Score
+		}
+		if math.Abs(sum-c.Earned) > scoreTolerance {
+			c.Mismatches = append(c.Mismatches, fmt.Sprintf("the results items add up to %s, the questions to %s; an item may not have been read", pts(sum), pts(c.Earned)))
+		}
+	}
+	if r := reported.Score; r != nil && math.Abs(*r-c.Earned) > scoreTolerance {
+		c.Mismatches = append(c.Mismatches, fmt.Sprintf("Canvas reports a score of %s, the questions add up to %s (%+g); a regrade, fudge points or a late penalty?", pts(*r), pts(c.Earned), RoundTo(*r-c.Earned, 2)))
+	}
+	if p := reported.Possible; p != nil && math.Abs(*p-c.Possible) > scoreTolerance {
+		c.Mismatches = append(c.Mismatches, fmt.Sprintf("the quiz is worth %s points, the scored questions %s; a question may be missing or unscored", pts(*p), pts(c.Possible)))
+	}
+	return c
+}
+
+// ScoresCSV lists the points of every question, then their totals, so the sum can be checked
+// against the gradebook: item_id, question, points_possible, points_earned, question_id.
+// Earned is empty for questions without a result. The total adds the unrounded scores, like
+// Canvas does.
+func ScoresCSV(doc QuizDoc) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"item_id", "question", "points_possible", "points_earned", "question_id"})
+	var possible, earned float64
+	scored := false
+	for _, q := range doc.Questions {
+		row := []string{q.ItemID, strconv.Itoa(q.Number), FormatPoints(q.Possible), "", q.ContentID}
+		possible += q.Possible
+		if q.Earned != nil {
+			row[3] = FormatPoints(RoundTo(*q.Earned, 2)) // as the gradebook shows it
+			earned += *q.Earned
+			scored = true
+		}
+		_ = w.Write(row)
+	}
+	total := []string{"total", "", FormatPoints(RoundTo(possible, 2)), "", ""}
+	if scored {
+		total[3] = FormatPoints(RoundTo(earned, 2))
+	}
+	_ = w.Write(total)
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// quizizzMaxOptions is the number of option columns in the Quizizz import sheet.
+const quizizzMaxOptions = 5
+
+// RenderQuizizz renders doc as the spreadsheet Quizizz imports: question text, type, up to
+// five options, the 1-based correct option(s) and a time limit of seconds per question. A
+// last Content ID column, which Quizizz ignores, carries each question's ContentID.
+// Choice questions become Multiple Choice or Checkbox, single blanks Fill-in-the-Blank
+// (accepted answers as options), essays Open-Ended and survey items Poll. Questions Quizizz
+// cannot hold are left out, with a warning saying why.
+func RenderQuizizz(doc QuizDoc, seconds int) ([]byte, []string) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"Question Text", "Question Type", "Option 1", "Option 2", "Option 3", "Option 4", "Option 5", "Correct Answer", "Time in seconds", "Image Link", "Content ID"})
+	var warnings []string
+	skip := func(q Question, why string) {
+		warnings = append(warnings, fmt.Sprintf("question %d skipped: %s", q.Number, why))
+	}
+	for _, q := range doc.Questions {
+		var typ string
+		var options, correct []string
+		switch {
+		case q.Essay:
+			typ = "Open-Ended"
+		case q.OpenEntry && !q.Ungraded:
+			if len(q.Blanks) != 1 {
+				skip(q, fmt.Sprintf("%d blanks; Quizizz fill-in-the-blank questions have one", len(q.Blanks)))
+				continue
+			}
+			b := q.Blanks[0]
+			options = b.Accepted
+			if len(options) == 0 && b.Answer != "" {
+				options = []string{b.Answer}
+			}
+			if len(options) == 0 || b.Pattern != "" {
+				skip(q, "no accepted answer to list (Quizizz cannot grade by pattern)")
+				continue
+			}
+			if len(options) > quizizzMaxOptions {
+				warnings = append(warnings, fmt.Sprintf("question %d: only the first %d of %d accepted answers fit", q.Number, quizizzMaxOptions, len(options)))
+				options = options[:quizizzMaxOptions]
+			}
+			typ = "Fill-in-the-Blank"
+		case len(q.Options) == 0:
+			skip(q, fmt.Sprintf("Quizizz has no %s questions", q.Type))
+			continue
+		case len(q.Options) > quizizzMaxOptions:
+			skip(q, fmt.Sprintf("%d options; Quizizz takes at most %d", len(q.Options), quizizzMaxOptions))
+			continue
+		case q.Ungraded:
+			typ = "Poll"
+			for _, o := range q.Options {
+				options = append(options, o.Label)
+			}
+		default:
+			for i, o := range q.Options {
+				options = append(options, o.Label)
+				if o.Correct {
+					correct = append(correct, strconv.Itoa(i+1))
+				}
+			}
+			if len(correct) == 0 {
+				skip(q, "the answer key is unknown")
+				continue
+			}
+			typ = "Multiple Choice"
+			if q.Multi || len(correct) > 1 {
+				typ = "Checkbox"
+			}
+		}
+		row := []string{blankPlaceholder.ReplaceAllString(q.Text, "_____"), typ}
+		for i := 0; i < quizizzMaxOptions; i++ {
+			opt := ""
+			if i < len(options) {
+				opt = options[i]
+			}
+			row = append(row, opt)
+		}
+		row = append(row, strings.Join(correct, ","), strconv.Itoa(seconds), "", q.ContentID)
+		_ = w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes(), warnings
+}
+
+// RenderAnki renders doc as an Anki text import: one note per question, with the question
+// on the front, the answer and the options (correct ones in bold) on the back, and the quiz
+// title and question tags as Anki tags. The header lines tell Anki the fields are
+// tab-separated HTML and that the first column is the note's GUID, the question's
+// ContentID, so importing a later export updates the same notes instead of adding copies. Questions without an answer key to learn from, essays and survey items
+// among them, are left out, with a warning saying why.
+func RenderAnki(doc QuizDoc) (string, []string) {
+	esc := func(s string) string {
+		s = strings.ReplaceAll(html.EscapeString(s), "\t", " ")
+		return strings.ReplaceAll(s, "\n", "<br>")
+	}
+	// Anki tags are separated by spaces, so spaces inside a tag become underscores.
+	tag := func(s string) string { return strings.Join(strings.Fields(s), "_") }
+	// The title's " — Questions and Solutions" part is the same for every quiz.
+	quiz, _, _ := strings.Cut(doc.Title, " — ")
+	var sb strings.Builder
+	var warnings []string
+	sb.WriteString("#separator:tab\n#html:true\n#guid column:1\n#tags column:4\n")
+	for _, q := range doc.Questions {
+		var back []string
+		switch {
+		case q.Essay:
+			warnings = append(warnings, fmt.Sprintf("question %d skipped: essays have no answer key", q.Number))
+			continue
+		case q.Ungraded:
+			warnings = append(warnings, fmt.Sprintf("question %d skipped: ungraded questions have no answer key", q.Number))
+			continue
+		case q.OpenEntry:
+			for _, b := range q.Blanks {
+				accepted := b.Accepted
+				if len(accepted) == 0 && b.Answer != "" {
+					accepted = []string{b.Answer}
+				}
+				if len(accepted) > 0 {
+					back = append(back, "<b>"+esc(b.Label)+":</b> "+esc(strings.Join(accepted, " / ")))
+				}
+			}
+		case len(q.Answers) == 1:
+			back = append(back, "<b>Answer:</b> "+esc(q.Answers[0]))
+		case len(q.Answers) > 1:
+			back = append(back, "<b>Correct answers:</b> "+esc(strings.Join(q.Answers, "; ")))
+		}
+		if len(back) == 0 {
+			warnings = append(warnings, fmt.Sprintf("question %d skipped: the answer key is unknown", q.Number))
+			continue
+		}
+		if len(q.Options) > 0 {
+			var items []string
+			for _, o := range q.Options {
+				if o.Correct {
+					items = append(items, "<li><b>"+esc(o.Label)+"</b></li>")
+				} else {
+					items = append(items, "<li>"+esc(o.Label)+"</li>")
+				}
+			}
+			back = append(back, "<ul>"+strings.Join(items, "")+"</ul>")
+		}
+		if q.Explanation != "" {
+			back = append(back, "<i>"+esc(q.Explanation)+"</i>")
+		}
+		tags := []string{tag(quiz)}
+		for _, t := range q.Tags {
+			tags = append(tags, tag(t))
+		}
+		sb.WriteString(q.ContentID + "\t" + esc(q.Text) + "\t" + strings.Join(back, "<br>") + "\t" + strings.Join(tags, " ") + "\n")
+	}
+	return sb.String(), warnings
+}
+
+// RenderCSV renders doc as a question/answer matrix for spreadsheets, one row per question:
+// week, number, type, question, options, correct_answers, points_possible, points_earned,
+// content_id.
+// week is the quiz label given, or for a bank (see BuildBank) the labels of the quizzes the
+// question appeared in. Options and answers are separated by " | "; a blank's answer is
+// listed as "Blank 1: 100". points_earned is empty for questions without a result.
+func RenderCSV(doc QuizDoc, week string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"week", "number", "type", "question", "options", "correct_answers", "points_possible", "points_earned", "content_id"})
+	for _, q := range doc.Questions {
+		label := week
+		if len(q.Quizzes) > 0 {
+			label = strings.Join(q.Quizzes, " | ")
+		}
+		var options []string
+		for _, o := range q.Options {
+			options = append(options, o.Label)
+		}
+		answers := q.Answers
+		if len(q.Blanks) > 0 {
+			answers = nil
+			for _, b := range q.Blanks {
+				if b.Answer != "" {
+					answers = append(answers, b.Label+": "+b.Answer)
+				}
+			}
+		}
+		earned := ""
+		if q.Earned != nil {
+			earned = FormatPoints(RoundTo(*q.Earned, 2))
+		}
+		_ = w.Write([]string{label, strconv.Itoa(q.Number), q.Type, q.Text, strings.Join(options, " | "), strings.Join(answers, " | "), FormatPoints(q.Possible), earned, q.ContentID})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// jsonDoc is the shape of RenderJSON's output. Its field names are part of the format, so
+// they only ever gain fields.
+type jsonDoc struct {
+	Title     string         `json:"title"`
+	Practice  bool           `json:"practice,omitempty"`
+	Questions []jsonQuestion `json:"questions"`
+}
+
+type jsonQuestion struct {
+	Number     int          `json:"number"`
+	ID         string       `json:"id,omitempty"`
+	ContentID  string       `json:"content_id,omitempty"`
+	Type       string       `json:"type"`
+	Text       string       `json:"text"`
+	Points     float64      `json:"points_possible"`
+	Earned     *float64     `json:"points_earned,omitempty"`
+	Options    []jsonOption `json:"options,omitempty"`
+	Blanks     []jsonBlank  `json:"blanks,omitempty"`
+	Formula    string       `json:"formula,omitempty"`
+	Submission []string     `json:"submission,omitempty"`
+	Files      []string     `json:"files,omitempty"`
+	Given      string       `json:"given,omitempty"`
+	Answers    []string     `json:"answers"`
+	Response   []string     `json:"response"`
+	Unanswered bool         `json:"unanswered,omitempty"`
+	Ungraded   bool         `json:"ungraded,omitempty"`
+	AppearedIn []string     `json:"appeared_in,omitempty"`
+	Confidence string       `json:"key_confidence,omitempty"`
+}
+
+type jsonOption struct {
+	Label    string   `json:"label"`
+	Correct  bool     `json:"correct"`
+	Selected bool     `json:"selected,omitempty"`
+	Credit   *float64 `json:"credit,omitempty"`
+}
+
+type jsonBlank struct {
+	Label    string   `json:"label"`
+	Answer   string   `json:"answer"`
+	Accepted []string `json:"accepted,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+}
+
+// templateFuncs are the functions output templates can call besides the text/template
+// builtins.
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	// letter numbers options from 0: A, B, ... Z, then 27, 28 ...
+	"letter": func(i int) string {
+		if i >= 0 && i < 26 {
+			return string(rune('A' + i))
+		}
+		return strconv.Itoa(i + 1)
+	},
+	// points prints Possible or Earned without trailing zeros; a nil Earned prints "".
+	"points": func(v any) (string, error) {
+		switch v := v.(type) {
+		case float64:
+			return FormatPoints(v), nil
+		case *float64:
+			if v == nil {
+				return "", nil
+			}
+			return FormatPoints(*v), nil
+		}
+		return "", fmt.Errorf("points: want a number, got %T", v)
+	},
+	// check is CheckResponse, nil for questions without a score.
+	"check": func(q Question) *ResponseCheck {
+		if c, ok := q.CheckResponse(); ok {
+			return &c
+		}
+		return nil
+	},
+}
+
+// ParseTemplate parses a text/template for RenderTemplate, with templateFuncs available.
+// name appears in error messages.
+func ParseTemplate(name, src string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(src)
+}
+
+// RenderTemplate executes t with doc as its data, for layouts the built-in formats don't
+// offer: {{range .Questions}}, {{.Text}}, {{range .Options}} and so on over the QuizDoc.
+func RenderTemplate(doc QuizDoc, t *template.Template) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, doc); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// RenderJSON renders doc as indented JSON for other programs: per question its text, type,
+// options with their correct flags, the answer key, points and the student's response.
+// Answers and response are always arrays, empty when unknown, so consumers need no nil checks.
+func RenderJSON(doc QuizDoc) (string, error) {
+	out := jsonDoc{Title: doc.Title, Practice: doc.Practice, Questions: []jsonQuestion{}}
+	for _, q := range doc.Questions {
+		jq := jsonQuestion{
+			Number:     q.Number,
+			ID:         q.ItemID,
+			ContentID:  q.ContentID,
+			Type:       q.Type,
+			Text:       q.Text,
+			Points:     q.Possible,
+			Earned:     q.Earned,
+			Formula:    q.Formula,
+			Submission: q.Submission,
+			Files:      q.Files,
+			Given:      q.Given,
+			Answers:    append([]string{}, q.Answers...),
+			Response:   append([]string{}, q.Responses...),
+			Unanswered: q.Unanswered,
+			Ungraded:   q.Ungraded,
+			AppearedIn: q.Quizzes,
+		}
+		if doc.AnnotateKeys {
+			jq.Confidence = q.KeyConfidence
+		}
+		for _, o := range q.Options {
+			jq.Options = append(jq.Options, jsonOption{Label: o.Label, Correct: o.Correct, Selected: o.Selected, Credit: o.Credit})
+		}
+		for _, b := range q.Blanks {
+			jq.Blanks = append(jq.Blanks, jsonBlank{Label: b.Label, Answer: b.Answer, Accepted: b.Accepted, Pattern: b.Pattern})
+		}
+		out.Questions = append(out.Questions, jq)
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// PDFOptions are the settings of RenderPDF.
+type PDFOptions struct {
+	PageBreaks bool   // start every question on a new page
+	LinkBase   string // URL that relative image sources resolve against, as in HTMLOptions
+	// FetchImage downloads an image of a question body. Without it only images given as
+	// data: URLs are embedded.
+	FetchImage func(url string) ([]byte, error)
+}
+
+// A4 page geometry of RenderPDF, in points.
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.69 // 2 cm
+	pdfFooter     = 18    // room kept for the page number
+)
+
+// pdfFonts are the standard Type 1 fonts RenderPDF writes with. Every PDF reader has them,
+// so nothing is embedded, but they only cover the Windows-1252 characters (see pdfEncode).
+var pdfFonts = []string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Courier"}
+
+const (
+	pdfRegular = iota
+	pdfBold
+	pdfItalic
+	pdfMono
+)
+
+// helveticaWidths and helveticaBoldWidths are the advance widths, in thousandths of the
+// font size, of the printable ASCII characters (space to tilde) from the fonts' AFM files.
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+	// pdfWideWidths covers the punctuation above ASCII that is far from the default width.
+	pdfWideWidths = map[byte]int{0x85: 1000, 0x89: 1000, 0x91: 222, 0x92: 222, 0x93: 333, 0x94: 333, 0x95: 350, 0x97: 1000, 0x99: 1000, 0xA0: 278, 0xB0: 400, 0xB9: 333, 0xB2: 333, 0xB3: 333}
+)
+
+// pdfCharWidth is the width of the WinAnsi character c in font, in thousandths of the size.
+// Characters above ASCII other than pdfWideWidths are taken as wide as a lowercase letter.
+func pdfCharWidth(c byte, font int) int {
+	switch {
+	case font == pdfMono:
+		return 600
+	case c >= 32 && c <= 126 && font == pdfBold:
+		return helveticaBoldWidths[c-32]
+	case c >= 32 && c <= 126:
+		return helveticaWidths[c-32]
+	case pdfWideWidths[c] != 0:
+		return pdfWideWidths[c]
+	}
+	return 556
+}
+
+// pdfTextWidth is the width of the WinAnsi string s in font at size, in points.
+func pdfTextWidth(s string, font int, size float64) float64 {
+	w := 0
+	for i := 0; i < len(s); i++ {
+		w += pdfCharWidth(s[i], font)
+	}
+	return float64(w) * size / 1000
+}
+
+// winAnsi maps the Windows-1252 characters outside Latin-1 to their codes.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfFallbacks spell out common characters Windows-1252 lacks.
+var pdfFallbacks = map[rune]string{
+	'→': "->", '←': "<-", '↔': "<->", '⇒': "=>", '≤': "<=", '≥': ">=", '≠': "!=", '−': "-",
+	'≈': "~", '✓': "v", '✔': "v", '✗': "x", '✘': "x",
+}
+
+// pdfEncode converts s to Windows-1252 (WinAnsiEncoding), spelling out arrows and the like
+// (see pdfFallbacks) and writing Unicode sub- and superscript digits as plain ones; ok is
+// false when some other character had to become "?".
+func pdfEncode(s string) (out string, ok bool) {
+	var b strings.Builder
+	ok = true
+	for _, r := range s {
+		switch {
+		case r == '\t' || r == '\n':
+			b.WriteByte(' ')
+		case r >= 0x20 && r <= 0x7E || r >= 0xA0 && r <= 0xFF:
+			b.WriteByte(byte(r))
+		case winAnsi[r] != 0:
+			b.WriteByte(winAnsi[r])
+		case pdfFallbacks[r] != "":
+			b.WriteString(pdfFallbacks[r])
+		default:
+			plain := ""
+			for _, table := range []map[rune]rune{subscripts, superscripts} {
+				for k, v := range table {
+					if v == r {
+						plain = string(k)
+					}
+				}
+			}
+			if plain == "" {
+				plain, ok = "?", false
+			}
+			b.WriteString(plain)
+		}
+	}
+	return b.String(), ok
+}
+
+// pdfSpan is a run of text in one font.
+type pdfSpan struct {
+	Text string
+	Font int
+}
+
+// pdfText is a paragraph of runs in different fonts.
+type pdfText []pdfSpan
+
+// add appends s in font. TeX in s (see reMath) can't be typeset, so it is set in italics
+// without its delimiters.
+func (t pdfText) add(font int, s string) pdfText {
+	last := 0
+	for _, m := range reMath.FindAllStringIndex(s, -1) {
+		t = append(t, pdfSpan{s[last:m[0]], font}, pdfSpan{" " + strings.TrimSpace(s[m[0]+2:m[1]-2]) + " ", pdfItalic})
+		last = m[1]
+	}
+	return append(t, pdfSpan{s[last:], font})
+}
+
+// pdfImage is an image decoded for embedding: zlib-compressed 8-bit RGB.
+type pdfImage struct {
+	Width, Height int
+	Data          []byte
+}
+
+// pdfWriter lays out text and images on A4 pages, top to bottom.
+type pdfWriter struct {
+	pages   []*bytes.Buffer // content streams
+	y       float64         // top of the free space on the last page
+	images  []pdfImage
+	dests   []pdfDest
+	missing bool // some character had no glyph (see pdfEncode)
+}
+
+// pdfDest is a named destination: a link target at height y of page.
+type pdfDest struct {
+	name string
+	page int
+	y    float64
+}
+
+func (w *pdfWriter) newPage() {
+	w.pages = append(w.pages, &bytes.Buffer{})
+	w.y = pdfPageHeight - pdfMargin
+}
+
+// atTop reports whether nothing has been written on the current page.
+func (w *pdfWriter) atTop() bool {
+	return w.y == pdfPageHeight-pdfMargin
+}
+
+// need starts a new page unless h points are left on this one.
+func (w *pdfWriter) need(h float64) {
+	if w.y-h < pdfMargin+pdfFooter && !w.atTop() {
+		w.newPage()
+	}
+}
+
+// anchor names the current position, so links to name#anchor land there.
+func (w *pdfWriter) anchor(name string) {
+	if name != "" {
+		w.dests = append(w.dests, pdfDest{name, len(w.pages) - 1, w.y})
+	}
+}
+
+func (w *pdfWriter) space(h float64) {
+	if !w.atTop() {
+		w.y -= h
+	}
+}
+
+// pdfString quotes s as a PDF literal string.
+func pdfString(s string) string {
+	return "(" + strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, "\r", `\r`).Replace(s) + ")"
+}
+
+// para fills t into lines indent points from the left margin at size, starting new pages as
+// needed. marker, when set, is written in the margin of the first line, as a list bullet.
+func (w *pdfWriter) para(indent, size float64, marker string, t pdfText) {
+	type word struct {
+		text  string
+		font  int
+		space bool // a space separates it from the previous word
+	}
+	maxWidth := pdfPageWidth - 2*pdfMargin - indent
+	var words []word
+	gap := false // the text so far ends in a space
+	for _, sp := range t {
+		text, ok := pdfEncode(sp.Text)
+		w.missing = w.missing || !ok
+		gap = gap || strings.TrimLeft(text, " ") != text
+		for _, f := range strings.Fields(text) {
+			space := len(words) > 0 && gap
+			gap = true
+			// Words wider than the line, such as long URLs, are broken anywhere.
+			for pdfTextWidth(f, sp.Font, size) > maxWidth {
+				n := 1
+				for n < len(f) && pdfTextWidth(f[:n+1], sp.Font, size) <= maxWidth {
+					n++
+				}
+				words = append(words, word{f[:n], sp.Font, space})
+				f, space = f[n:], false
+			}
+			words = append(words, word{f, sp.Font, space})
+		}
+		gap = strings.TrimRight(text, " ") != text || text == "" && gap
+	}
+	lead := size * 1.3
+	for len(words) > 0 {
+		n, width := 0, 0.0
+		for n < len(words) {
+			ww := pdfTextWidth(words[n].text, words[n].font, size)
+			if n > 0 && words[n].space {
+				ww += pdfTextWidth(" ", words[n].font, size)
+			}
+			if n > 0 && width+ww > maxWidth {
+				break
+			}
+			width += ww
+			n++
+		}
+		w.need(lead)
+		page := w.pages[len(w.pages)-1]
+		baseline := w.y - size
+		if marker != "" {
+			enc, _ := pdfEncode(marker)
+			fmt.Fprintf(page, "BT /F%d %.2f Tf %.2f %.2f Td %s Tj ET\n", pdfRegular, size, pdfMargin+indent-pdfTextWidth(enc+" ", pdfRegular, size), baseline, pdfString(enc))
+			marker = ""
+		}
+		fmt.Fprintf(page, "BT %.2f %.2f Td", pdfMargin+indent, baseline)
+		font := -1
+		var run strings.Builder
+		flush := func() {
+			if run.Len() > 0 {
+				fmt.Fprintf(page, " %s Tj", pdfString(run.String()))
+				run.Reset()
+			}
+		}
+		for i, wd := range words[:n] {
+			if wd.font != font {
+				flush()
+				font = wd.font
+				fmt.Fprintf(page, " /F%d %.2f Tf", font, size)
+			}
+			if i > 0 && wd.space {
+				run.WriteByte(' ')
+			}
+			run.WriteString(wd.text)
+		}
+		flush()
+		page.WriteString(" ET\n")
+		w.y -= lead
+		words = words[n:]
+	}
+}
+
+// image places img at indent, at 96 pixels per inch, shrunk to fit the line width and half
+// the page height.
+func (w *pdfWriter) image(indent float64, img pdfImage) {
+	width, height := float64(img.Width)*0.75, float64(img.Height)*0.75
+	maxWidth, maxHeight := pdfPageWidth-2*pdfMargin-indent, (pdfPageHeight-2*pdfMargin)/2
+	scale := math.Min(1, math.Min(maxWidth/width, maxHeight/height))
+	width, height = width*scale, height*scale
+	w.need(height + 6)
+	w.images = append(w.images, img)
+	fmt.Fprintf(w.pages[len(w.pages)-1], "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", width, height, pdfMargin+indent, w.y-height-3, len(w.images)-1)
+	w.y -= height + 6
+}
+
+// bytes assembles the document: a catalog with the named destinations, the page tree, the
+// fonts, the images and one content stream per page, with page numbers added at the foot of
+// each page.
+func (w *pdfWriter) bytes(title string) []byte {
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string, stream []byte) int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s", len(offsets), body)
+		if stream != nil {
+			out.WriteString("\nstream\n")
+			out.Write(stream)
+			out.WriteString("\nendstream")
+		}
+		out.WriteString("\nendobj\n")
+		return len(offsets)
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// Objects 1 and 2 are the catalog and page tree; the pages follow the fonts and images.
+	fontBase, imageBase := 3, 3+len(pdfFonts)
+	pageBase := imageBase + len(w.images)
+	var dests strings.Builder
+	if len(w.dests) > 0 {
+		dests.WriteString(" /Dests <<")
+		for _, d := range w.dests {
+			fmt.Fprintf(&dests, " /%s [%d 0 R /XYZ 0 %.2f null]", d.name, pageBase+2*d.page, d.y)
+		}
+		dests.WriteString(" >>")
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R"+dests.String()+" >>", nil)
+	kids := make([]string, len(w.pages))
+	for i := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageBase+2*i)
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)), nil)
+	var resources strings.Builder
+	resources.WriteString("<< /Font <<")
+	for i, f := range pdfFonts {
+		obj(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f), nil)
+		fmt.Fprintf(&resources, " /F%d %d 0 R", i, fontBase+i)
+	}
+	resources.WriteString(" >>")
+	if len(w.images) > 0 {
+		resources.WriteString(" /XObject <<")
+		for i, img := range w.images {
+			obj(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>", img.Width, img.Height, len(img.Data)), img.Data)
+			fmt.Fprintf(&resources, " /Im%d %d 0 R", i, imageBase+i)
+		}
+		resources.WriteString(" >>")
+	}
+	resources.WriteString(" >>")
+	for i, content := range w.pages {
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(w.pages))
+		fmt.Fprintf(content, "BT /F%d 9 Tf %.2f %.2f Td %s Tj ET\n", pdfRegular, (pdfPageWidth-pdfTextWidth(footer, pdfRegular, 9))/2, pdfMargin/2, pdfString(footer))
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(content.Bytes())
+		zw.Close()
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, resources.String(), pageBase+2*i+1), nil)
+		obj(fmt.Sprintf("<< /Filter /FlateDecode /Length %d >>", z.Len()), z.Bytes())
+	}
+	// The title goes in as UTF-16, which any reader shows, whatever the fonts cover.
+	var hexTitle strings.Builder
+	hexTitle.WriteString("FEFF")
+	for _, u := range utf16.Encode([]rune(title)) {
+		fmt.Fprintf(&hexTitle, "%04X", u)
+	}
+	info := obj(fmt.Sprintf("<< /Title <%s> /Producer (canvas-quiz-extractor) >>", hexTitle.String()), nil)
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, info, xref)
+	return out.Bytes()
+}
+
+// bodyImages lists the image sources of a question body, resolved against base, and the
+// sources it cannot use: relative ones without a base, or other schemes. Equation images
+// are left out; they are TeX in the text.
+func bodyImages(body, base string) (srcs, unusable []string) {
+	baseURL, _ := url.Parse(base)
+	for _, tok := range tokenizeHTML(body) {
+		if tok.Name != "img" || tok.Closing || equationLaTeX(tok.Raw) != "" {
+			continue
+		}
+		raw := htmlAttr(tok.Raw, "src")
+		src := safeURL(raw, baseURL, true)
+		if u, err := url.Parse(src); src != "" && err == nil && u.IsAbs() {
+			srcs = append(srcs, src)
+		} else if raw != "" {
+			unusable = append(unusable, raw)
+		}
+	}
+	return srcs, unusable
+}
+
+// loadPDFImage decodes the PNG, JPEG or GIF image at src, a data: URL or one fetch
+// downloads, for embedding. Transparent parts are laid on white.
+func loadPDFImage(src string, fetch func(string) ([]byte, error)) (pdfImage, error) {
+	var b []byte
+	var err error
+	switch {
+	case strings.HasPrefix(src, "data:"):
+		_, data, found := strings.Cut(src, ";base64,")
+		if !found {
+			return pdfImage{}, errors.New("only base64 data: URLs are supported")
+		}
+		b, err = base64.StdEncoding.DecodeString(data)
+	case fetch == nil:
+		return pdfImage{}, errors.New("images are not downloaded")
+	default:
+		b, err = fetch(src)
+	}
+	if err != nil {
+		return pdfImage{}, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return pdfImage{}, err
+	}
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, 3*bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			// Colors are premultiplied by alpha, so adding the uncovered part of white
+			// composites the pixel onto a white page.
+			white := 0xffff - a
+			rgb = append(rgb, byte((r+white)>>8), byte((g+white)>>8), byte((bl+white)>>8))
+		}
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(rgb)
+	zw.Close()
+	return pdfImage{Width: bounds.Dx(), Height: bounds.Dy(), Data: z.Bytes()}, nil
+}
+
+// RenderPDF renders doc as an A4 PDF with the content of RenderText: numbered questions with
+// their options (correct ones in bold), answers, feedback, explanations and comments, and
+// the images of question bodies. It uses the standard PDF fonts, so characters outside
+// Windows-1252 are replaced (see pdfEncode), and TeX is shown as source, in italics, since
+// there is no TeX engine to typeset it. Images that can't be loaded are left out; both come
+// back as warnings.
+func RenderPDF(doc QuizDoc, opts PDFOptions) ([]byte, []string) {
+	const body, small = 10.5, 9
+	var warnings []string
+	who := func(c Comment) string { // as in writeTextComments
+		who := "Instructor"
+		if c.Author != "" {
+			who = c.Author
+		}
+		if c.Date != "" {
+			who += ", " + c.Date
+		}
+		return who
+	}
+	w := &pdfWriter{}
+	w.newPage()
+	w.para(0, 18, "", pdfText{}.add(pdfBold, doc.Title))
+	w.space(6)
+	for _, d := range doc.Details {
+		w.para(0, body, "", pdfText{}.add(pdfBold, d.Label+": ").add(pdfRegular, d.Value))
+	}
+	for _, p := range doc.Description {
+		w.space(4)
+		w.para(12, body, "", pdfText{}.add(pdfItalic, p))
+	}
+	for _, c := range doc.Comments {
+		w.space(4)
+		w.para(12, body, "", pdfText{}.add(pdfBold, who(c)+": ").add(pdfItalic, c.Text))
+	}
+	if note := doc.notice(); note != "" {
+		w.space(4)
+		w.para(0, body, "", pdfText{}.add(pdfItalic, note))
+	}
+	images := func(q Question, html string) {
+		srcs, unusable := bodyImages(html, opts.LinkBase)
+		for _, src := range unusable {
+			warnings = append(warnings, fmt.Sprintf("question %d: image %s left out: relative or unsupported URL", q.Number, src))
+		}
+		for _, src := range srcs {
+			img, err := loadPDFImage(src, opts.FetchImage)
+			if err != nil {
+				if len(src) > 80 {
+					src = src[:80] + "..."
+				}
+				warnings = append(warnings, fmt.Sprintf("question %d: image %s left out: %v", q.Number, src, err))
+				continue
+			}
+			w.image(18, img)
+		}
+	}
+
+	for i, q := range doc.Questions {
+		var prev *Question
+		if i > 0 {
+			prev = &doc.Questions[i-1]
+		}
+		if opts.PageBreaks && i > 0 {
+			w.newPage()
+		}
+		w.space(12)
+		if groupChange(prev, &q) && q.Group != nil {
+			w.need(60)
+			w.para(0, 13, "", pdfText{}.add(pdfBold, "Group: "+q.Group.Title))
+			if rule := q.Group.Rule(); rule != "" {
+				w.para(0, body, "", pdfText{}.add(pdfItalic, "Questions drawn at random: "+rule+"."))
+			}
+			w.space(8)
+		}
+		if q.Stimulus != nil && (groupChange(prev, &q) || stimulusChange(prev, &q)) {
+			w.need(60)
+			w.para(0, 13, "", pdfText{}.add(pdfBold, q.Stimulus.Heading()))
+			for _, p := range q.Stimulus.Text {
+				w.para(12, body, "", pdfText{}.add(pdfRegular, p))
+			}
+			images(q, q.Stimulus.HTML)
+			w.space(8)
+		}
+		w.need(60) // keep the heading with the start of the question
+		w.anchor(q.ContentID)
+		w.para(0, 12, "", pdfText{}.add(pdfBold, fmt.Sprintf("%d) ", q.Number)).add(pdfBold, q.Text))
+		images(q, q.BodyHTML)
+		line := func(label, text string) {
+			w.para(18, small, "", pdfText{}.add(pdfBold, label+": ").add(pdfRegular, text))
+		}
+		if q.Bank != "" {
+			line("Bank", q.Bank)
+		}
+		if len(q.Quizzes) > 0 {
+			line("Appeared in", strings.Join(q.Quizzes, ", "))
+		}
+		for _, m := range q.Media {
+			line("Media", m.Label()+" <"+m.URL+">")
+		}
+		if len(q.Tags) > 0 {
+			line("Tags", strings.Join(q.Tags, ", "))
+		}
+		if q.Unanswered {
+			w.para(18, body, "", pdfText{}.add(pdfItalic, "Response: left blank"))
+		}
+		if q.Formula != "" {
+			line("Formula", q.Formula)
+		}
+		if doc.AnnotateKeys && q.KeyConfidence != "" {
+			line("Key", q.KeyConfidence)
+		}
+		if c, ok := q.CheckResponse(); ok && doc.ShowResponses {
+			outcome := "incorrect"
+			if c.Correct {
+				outcome = "correct"
+			}
+			w.para(18, body, "", pdfText{}.add(pdfBold, "Your answer ("+outcome+"): ").add(pdfRegular, c.Response))
+			if c.Key != "" {
+				w.para(18, body, "", pdfText{}.add(pdfBold, "Correct answer: ").add(pdfRegular, c.Key))
+			}
+			w.para(18, body, "", pdfText{}.add(pdfBold, "Score: ").add(pdfRegular, c.Score))
+		}
+		w.space(3)
+		switch {
+		case !q.HasResult:
+			w.para(18, body, "", pdfText{}.add(pdfItalic, "(no result data)"))
+		case q.OpenEntry && !q.Ungraded:
+			if len(q.WordBank) > 0 {
+				w.para(18, body, "", pdfText{}.add(pdfBold, "Word bank: ").add(pdfRegular, strings.Join(q.WordBank, ", ")))
+			}
+			for _, b := range q.Blanks {
+				ans := "(answer unavailable)"
+				if b.Answer != "" {
+					ans = b.Answer
+				}
+				if b.Example {
+					ans += " (example match)"
+				}
+				if len(b.Accepted) > 1 {
+					ans += " (also accepted: " + strings.Join(b.Accepted[1:], ", ") + ")"
+				}
+				w.para(30, body, "•", pdfText{}.add(pdfBold, b.Label+": ").add(pdfRegular, ans))
+				if b.Rule != "" {
+					w.para(42, small, "", pdfText{}.add(pdfItalic, "Matching: "+b.Rule))
+				}
+				if b.Pattern != "" {
+					w.para(42, small, "", pdfText{}.add(pdfItalic, "Pattern: ").add(pdfMono, b.Pattern))
+					if b.Meaning != "" {
+						w.para(42, small, "", pdfText{}.add(pdfItalic, "Reads as: "+b.Meaning))
+					}
+				}
+			}
+		case q.Essay:
+			w.para(18, body, "", pdfText{}.add(pdfItalic, "(essay)"))
+			for _, p := range q.Submission {
+				w.para(30, body, "", pdfText{}.add(pdfRegular, p))
+			}
+			if len(q.Files) > 0 {
+				w.para(18, body, "", pdfText{}.add(pdfBold, "Files: ").add(pdfRegular, strings.Join(q.Files, ", ")))
+			}
+			if a := q.awarded(); a != "" && !doc.ShowResponses {
+				w.para(18, body, "", pdfText{}.add(pdfBold, "Score: ").add(pdfRegular, a))
+			}
+			for _, c := range q.Rubric {
+				text := fmt.Sprintf("%s (%s pts)", c.Description, FormatPoints(c.Points))
+				if a := c.Assessed.summary(); a != "" {
+					text += ": " + a
+				}
+				w.para(30, body, "•", pdfText{}.add(pdfRegular, text))
+			}
+		case q.Ungraded:
+			for _, o := range q.Options {
+				t := pdfText{}.add(pdfRegular, o.Label)
+				if o.Selected {
+					t = t.add(pdfItalic, " (your response)")
+				}
+				w.para(30, body, "•", t)
+			}
+			if !doc.Practice {
+				resp := "(no response)"
+				if len(q.Responses) > 0 {
+					resp = strings.Join(q.Responses, ", ")
+				}
+				w.para(18, body, "", pdfText{}.add(pdfBold, "Your response: ").add(pdfRegular, resp))
+			}
+		default:
+			if len(q.Passage) > 0 {
+				var t pdfText
+				for _, sp := range q.Passage {
+					if sp.Correct {
+						t = t.add(pdfBold, sp.Text)
+					} else {
+						t = t.add(pdfRegular, sp.Text)
+					}
+				}
+				w.para(30, body, "", t)
+				w.space(3)
+			}
+			for _, o := range q.Options {
+				t := pdfText{}.add(pdfRegular, o.Label)
+				if o.Correct {
+					t = pdfText{}.add(pdfBold, o.Label).add(pdfItalic, " (correct)")
+				}
+				w.para(30, body, "•", t)
+				if o.Feedback != "" {
+					w.para(42, small, "", pdfText{}.add(pdfItalic, o.Feedback))
+				}
+			}
+			w.space(3)
+			switch {
+			case q.Multi:
+				w.para(18, body, "", pdfText{}.add(pdfBold, "Correct answers: ").add(pdfRegular, strings.Join(q.Answers, "; ")))
+			case len(q.Answers) == 1:
+				w.para(18, body, "", pdfText{}.add(pdfRegular, q.givenPrefix()).add(pdfBold, "Answer: ").add(pdfRegular, q.Answers[0]))
+			default:
+				w.para(18, body, "", pdfText{}.add(pdfBold, "Answer: ").add(pdfItalic, "(answer unavailable)"))
+			}
+		}
+		for _, a := range q.Attempts {
+			w.para(18, small, "", pdfText{}.add(pdfRegular, a.Summary()))
+		}
+		if q.Class != nil {
+			line("Class", q.Class.Summary())
+		}
+		if q.Explanation != "" {
+			w.para(18, body, "", pdfText{}.add(pdfBold, "Explanation: ").add(pdfItalic, q.Explanation))
+		}
+		for _, c := range q.Comments {
+			w.para(30, body, "", pdfText{}.add(pdfBold, who(c)+": ").add(pdfItalic, c.Text))
+		}
+	}
+	if refs := doc.References(); len(refs) > 0 {
+		w.space(16)
+		w.need(60)
+		w.para(0, 13, "", pdfText{}.add(pdfBold, "References"))
+		for _, r := range refs {
+			w.para(12, small, "•", pdfText{}.add(pdfRegular, r.Text+" <"+r.URL+"> - "+r.citedBy(plainCite)))
+		}
+	}
+	if w.missing {
+		warnings = append(warnings, "some characters have no glyph in the standard PDF fonts and were replaced with ?")
+	}
+	return w.bytes(doc.Title), warnings
+}
+
+// DocxOptions are the settings of RenderDocx.
+type DocxOptions struct {
+	PageBreaks bool // start every question on a new page
+}
+
+// docxRun is a run of text in one style, the docx counterpart of a pdfText span.
+type docxRun struct {
+	text               string
+	bold, italic, mono bool
+}
+
+// docxWriter builds the body of word/document.xml one paragraph at a time.
+type docxWriter struct {
+	sb        strings.Builder
+	bookmarks int    // bookmarks written, for their ids
+	bookmark  string // name of the bookmark to put around the next paragraph
+}
+
+// para writes a paragraph of style (empty for Normal), indented by indent twips, as a bullet
+// when bullet is set, and in size half-points when size is not 0.
+func (w *docxWriter) para(style string, indent int, bullet bool, size int, runs ...docxRun) {
+	w.sb.WriteString("<w:p><w:pPr>")
+	if style != "" {
+		w.sb.WriteString(`<w:pStyle w:val="` + style + `"/>`)
+	}
+	if bullet {
+		w.sb.WriteString(`<w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr>`)
+		w.sb.WriteString(fmt.Sprintf(`<w:ind w:left="%d" w:hanging="240"/>`, indent))
+	} else if indent > 0 {
+		w.sb.WriteString(fmt.Sprintf(`<w:ind w:left="%d"/>`, indent))
+	}
+	w.sb.WriteString("</w:pPr>")
+	if w.bookmark != "" {
+		w.bookmarks++
+		fmt.Fprintf(&w.sb, `<w:bookmarkStart w:id="%d" w:name="%s"/>`, w.bookmarks, w.bookmark)
+	}
+	for _, r := range runs {
+		var props string
+		if r.mono {
+			props += `<w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/>`
+		}
+		if r.bold {
+			props += "<w:b/>"
+		}
+		if r.italic {
+			props += "<w:i/>"
+		}
+		if size > 0 {
+			props += fmt.Sprintf(`<w:sz w:val="%d"/>`, size)
+		}
+		w.sb.WriteString("<w:r>")
+		if props != "" {
+			w.sb.WriteString("<w:rPr>" + props + "</w:rPr>")
+		}
+		for i, line := range strings.Split(r.text, "\n") {
+			if i > 0 {
+				w.sb.WriteString("<w:br/>")
+			}
+			w.sb.WriteString(`<w:t xml:space="preserve">`)
+			xml.EscapeText(&w.sb, []byte(line))
+			w.sb.WriteString("</w:t>")
+		}
+		w.sb.WriteString("</w:r>")
+	}
+	if w.bookmark != "" {
+		fmt.Fprintf(&w.sb, `<w:bookmarkEnd w:id="%d"/>`, w.bookmarks)
+		w.bookmark = ""
+	}
+	w.sb.WriteString("</w:p>")
+}
+
+// pageBreak starts a new page.
+func (w *docxWriter) pageBreak() {
+	w.sb.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+}
+
+// The fixed parts of a .docx package. Styles use Word's built-in ids, so Word lists the
+// headings in its navigation pane and a table of contents picks them up.
+const (
+	docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/><Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/><Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/><Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/></Types>`
+	docxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/></Relationships>`
+	docxDocumentRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/></Relationships>`
+	docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:eastAsia="Calibri" w:cs="Calibri"/><w:sz w:val="21"/><w:szCs w:val="21"/><w:lang w:val="en-US"/></w:rPr></w:rPrDefault><w:pPrDefault><w:pPr><w:spacing w:after="60" w:line="264" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+		`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>` +
+		`<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:spacing w:after="200"/></w:pPr><w:rPr><w:b/><w:sz w:val="36"/><w:szCs w:val="36"/></w:rPr></w:style>` +
+		`<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:keepNext/><w:spacing w:before="360" w:after="120"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="26"/><w:szCs w:val="26"/></w:rPr></w:style>` +
+		`<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:keepNext/><w:spacing w:before="240" w:after="80"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="24"/><w:szCs w:val="24"/></w:rPr></w:style></w:styles>`
+	docxNumbering = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:abstractNum w:abstractNumId="0"><w:multiLevelType w:val="singleLevel"/><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="•"/><w:lvlJc w:val="left"/></w:lvl></w:abstractNum><w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num></w:numbering>`
+)
+
+// RenderDocx renders doc as a Word document (.docx) with the content of RenderPDF: the title
+// in the Title style, groups and stimuli as Heading 1, each question as a Heading 2 numbered
+// as in the other formats, options as a bulleted list with the correct ones in bold, then
+// answers, explanations and comments. TeX is shown as source, in italics. Images are not
+// embedded; the questions that have them come back as warnings. The package's timestamps
+// are fixed, so the same doc renders to the same bytes.
+func RenderDocx(doc QuizDoc, opts DocxOptions) ([]byte, []string) {
+	const small = 18 // half-points
+	var warnings []string
+	who := func(c Comment) string { // as in writeTextComments
+		who := "Instructor"
+		if c.Author != "" {
+			who = c.Author
+		}
+		if c.Date != "" {
+			who += ", " + c.Date
+		}
+		return who
+	}
+	images := func(q Question, html string) {
+		if srcs, unusable := bodyImages(html, ""); len(srcs)+len(unusable) > 0 {
+			warnings = append(warnings, fmt.Sprintf("question %d: %d image(s) left out; docx output does not embed images", q.Number, len(srcs)+len(unusable)))
+		}
+	}
+	plain := func(s string) docxRun { return docxRun{text: s} }
+	bold := func(s string) docxRun { return docxRun{text: s, bold: true} }
+	italic := func(s string) docxRun { return docxRun{text: s, italic: true} }
+
+	w := &docxWriter{}
+	w.para("Title", 0, false, 0, plain(doc.Title))
+	for _, d := range doc.Details {
+		w.para("", 0, false, 0, bold(d.Label+": "), plain(d.Value))
+	}
+	for _, p := range doc.Description {
+		w.para("", 240, false, 0, italic(p))
+	}
+	for _, c := range doc.Comments {
+		w.para("", 240, false, 0, bold(who(c)+": "), italic(c.Text))
+	}
+	if note := doc.notice(); note != "" {
+		w.para("", 0, false, 0, italic(note))
+	}
+
+	for i, q := range doc.Questions {
+		var prev *Question
+		if i > 0 {
+			prev = &doc.Questions[i-1]
+		}
+		if opts.PageBreaks && i > 0 {
+			w.pageBreak()
+		}
+		if groupChange(prev, &q) && q.Group != nil {
+			w.para("Heading1", 0, false, 0, plain("Group: "+q.Group.Title))
+			if rule := q.Group.Rule(); rule != "" {
+				w.para("", 0, false, 0, italic("Questions drawn at random: "+rule+"."))
+			}
+		}
+		if q.Stimulus != nil && (groupChange(prev, &q) || stimulusChange(prev, &q)) {
+			w.para("Heading1", 0, false, 0, plain(q.Stimulus.Heading()))
+			for _, p := range q.Stimulus.Text {
+				w.para("", 240, false, 0, plain(p))
+			}
+			images(q, q.Stimulus.HTML)
+		}
+		// Word bookmark names can't hold "-", so q-0a1b... becomes q_0a1b...
+		w.bookmark = strings.ReplaceAll(q.ContentID, "-", "_")
+		w.para("Heading2", 0, false, 0, plain(fmt.Sprintf("%d) %s", q.Number, q.Text)))
+		images(q, q.BodyHTML)
+		line := func(label, text string) {
+			w.para("", 360, false, small, bold(label+": "), plain(text))
+		}
+		if q.Bank != "" {
+			line("Bank", q.Bank)
+		}
+		if len(q.Quizzes) > 0 {
+			line("Appeared in", strings.Join(q.Quizzes, ", "))
+		}
+		for _, m := range q.Media {
+			line("Media", m.Label()+" <"+m.URL+">")
+		}
+		if len(q.Tags) > 0 {
+			line("Tags", strings.Join(q.Tags, ", "))
+		}
+		if q.Unanswered {
+			w.para("", 360, false, 0, italic("Response: left blank"))
+		}
+		if q.Formula != "" {
+			line("Formula", q.Formula)
+		}
+		if doc.AnnotateKeys && q.KeyConfidence != "" {
+			line("Key", q.KeyConfidence)
+		}
+		if c, ok := q.CheckResponse(); ok && doc.ShowResponses {
+			outcome := "incorrect"
+			if c.Correct {
+				outcome = "correct"
+			}
+			w.para("", 360, false, 0, bold("Your answer ("+outcome+"): "), plain(c.Response))
+			if c.Key != "" {
+				w.para("", 360, false, 0, bold("Correct answer: "), plain(c.Key))
+			}
+			w.para("", 360, false, 0, bold("Score: "), plain(c.Score))
+		}
+		switch {
+		case !q.HasResult:
+			w.para("", 360, false, 0, italic("(no result data)"))
+		case q.OpenEntry && !q.Ungraded:
+			if len(q.WordBank) > 0 {
+				w.para("", 360, false, 0, bold("Word bank: "), plain(strings.Join(q.WordBank, ", ")))
+			}
+			for _, b := range q.Blanks {
+				ans := "(answer unavailable)"
+				if b.Answer != "" {
+					ans = b.Answer
+				}
+				if b.Example {
+					ans += " (example match)"
+				}
+				if len(b.Accepted) > 1 {
+					ans += " (also accepted: " + strings.Join(b.Accepted[1:], ", ") + ")"
+				}
+				w.para("", 600, true, 0, bold(b.Label+": "), plain(ans))
+				if b.Rule != "" {
+					w.para("", 840, false, small, italic("Matching: "+b.Rule))
+				}
+				if b.Pattern != "" {
+					w.para("", 840, false, small, italic("Pattern: "), docxRun{text: b.Pattern, mono: true})
+					if b.Meaning != "" {
+						w.para("", 840, false, small, italic("Reads as: "+b.Meaning))
+					}
+				}
+			}
+		case q.Essay:
+			w.para("", 360, false, 0, italic("(essay)"))
+			for _, p := range q.Submission {
+				w.para("", 600, false, 0, plain(p))
+			}
+			if len(q.Files) > 0 {
+				w.para("", 360, false, 0, bold("Files: "), plain(strings.Join(q.Files, ", ")))
+			}
+			if a := q.awarded(); a != "" && !doc.ShowResponses {
+				w.para("", 360, false, 0, bold("Score: "), plain(a))
+			}
+			for _, c := range q.Rubric {
+				text := fmt.Sprintf("%s (%s pts)", c.Description, FormatPoints(c.Points))
+				if a := c.Assessed.summary(); a != "" {
+					text += ": " + a
+				}
+				w.para("", 600, true, 0, plain(text))
+			}
+		case q.Ungraded:
+			for _, o := range q.Options {
+				runs := []docxRun{plain(o.Label)}
+				if o.Selected {
+					runs = append(runs, italic(" (your response)"))
+				}
+				w.para("", 600, true, 0, runs...)
+			}
+			if !doc.Practice {
+				resp := "(no response)"
+				if len(q.Responses) > 0 {
+					resp = strings.Join(q.Responses, ", ")
+				}
+				w.para("", 360, false, 0, bold("Your response: "), plain(resp))
+			}
+		default:
+			if len(q.Passage) > 0 {
+				var runs []docxRun
+				for _, sp := range q.Passage {
+					runs = append(runs, docxRun{text: sp.Text, bold: sp.Correct})
+				}
+				w.para("", 600, false, 0, runs...)
+			}
+			for _, o := range q.Options {
+				if o.Correct {
+					w.para("", 600, true, 0, bold(o.Label), italic(" (correct)"))
+				} else {
+					w.para("", 600, true, 0, plain(o.Label))
+				}
+				if o.Feedback != "" {
+					w.para("", 840, false, small, italic(o.Feedback))
+				}
+			}
+			switch {
+			case q.Multi:
+				w.para("", 360, false, 0, bold("Correct answers: "), plain(strings.Join(q.Answers, "; ")))
+			case len(q.Answers) == 1:
+				w.para("", 360, false, 0, plain(q.givenPrefix()), bold("Answer: "), plain(q.Answers[0]))
+			default:
+				w.para("", 360, false, 0, bold("Answer: "), italic("(answer unavailable)"))
+			}
+		}
+		for _, a := range q.Attempts {
+			w.para("", 360, false, small, plain(a.Summary()))
+		}
+		if q.Class != nil {
+			line("Class", q.Class.Summary())
+		}
+		if q.Explanation != "" {
+			w.para("", 360, false, 0, bold("Explanation: "), italic(q.Explanation))
+		}
+		for _, c := range q.Comments {
+			w.para("", 600, false, 0, bold(who(c)+": "), italic(c.Text))
+		}
+	}
+	if refs := doc.References(); len(refs) > 0 {
+		w.para("Heading1", 0, false, 0, plain("References"))
+		for _, r := range refs {
+			w.para("", 360, true, small, plain(r.Text+" <"+r.URL+"> - "+r.citedBy(plainCite)))
+		}
+	}
+
+	var title strings.Builder
+	xml.EscapeText(&title, []byte(doc.Title))
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", docxContentTypes},
+		{"_rels/.rels", docxRels},
+		{"docProps/core.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>` + title.String() + `</dc:title></cp:coreProperties>`},
+		{"word/_rels/document.xml.rels", docxDocumentRels},
+		{"word/styles.xml", docxStyles},
+		{"word/numbering.xml", docxNumbering},
+		// A4 with 2 cm margins, as RenderPDF.
+		{"word/document.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + w.sb.String() +
+			`<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1134" w:right="1134" w:bottom="1134" w:left="1134" w:header="709" w:footer="709" w:gutter="0"/></w:sectPr></w:body></w:document>`},
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, p := range parts {
+		// Writing to a bytes.Buffer cannot fail.
+		f, _ := zw.CreateHeader(&zip.FileHeader{Name: p.name, Method: zip.Deflate, Modified: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)})
+		f.Write([]byte(p.body))
+	}
+	zw.Close()
+	return buf.Bytes(), warnings
+}
+
+// RenderConfluenceStorage renders doc as Confluence storage-format XHTML, sticking to the
+// elements the storage format documents (headings, paragraphs, lists, tables, strong).
+func RenderConfluenceStorage(doc QuizDoc) string {
+	esc := html.EscapeString
+	var sb strings.Builder
+	if len(doc.Details) > 0 {
+		sb.WriteString("<table><tbody>")
+		for _, d := range doc.Details {
+			sb.WriteString(fmt.Sprintf("<tr><th>%s</th><td>%s</td></tr>", esc(d.Label), esc(d.Value)))
+		}
+		sb.WriteString("</tbody></table>")
+	}
+	for _, p := range doc.Description {
+		sb.WriteString("<p>" + esc(p) + "</p>")
+	}
+	for _, c := range doc.Comments {
+		sb.WriteString(fmt.Sprintf("<blockquote><p><strong>Instructor:</strong> %s</p></blockquote>", esc(c.Text)))
+	}
+	for _, q := range doc.Questions {
+		sb.WriteString(fmt.Sprintf("<h2><ac:structured-macro ac:name=\"anchor\"><ac:parameter ac:name=\"\">%s</ac:parameter></ac:structured-macro>%d) %s</h2>", q.ContentID, q.Number, esc(q.Text)))
+		for _, m := range q.Media {
+			sb.WriteString(fmt.Sprintf("<p><strong>Media:</strong> <a href=\"%s\">%s</a></p>", esc(m.URL), esc(m.Label())))
+		}
+		switch {
+		case !q.HasResult:
+			sb.WriteString("<p><em>No result data.</em></p>")
+		case doc.Practice:
+			if len(q.Options) > 0 {
+				sb.WriteString("<ul>")
+				for _, o := range q.Options {
+					sb.WriteString("<li>" + esc(o.Label) + "</li>")
+				}
+				sb.WriteString("</ul>")
+			}
+		case q.Ungraded:
+			resp := "(no response)"
+			if len(q.Responses) > 0 {
+				resp = strings.Join(q.Responses, ", ")
+			}
+			sb.WriteString(fmt.Sprintf("<p><strong>Your response:</strong> %s</p>", esc(resp)))
+		case q.OpenEntry:
+			sb.WriteString("<ul>")
+			for _, b := range q.Blanks {
+				ans := b.Answer
+				if ans == "" {
+					ans = "(answer unavailable)"
+				}
+				sb.WriteString(fmt.Sprintf("<li>%s: <strong>%s</strong></li>", esc(b.Label), esc(ans)))
+			}
+			sb.WriteString("</ul>")
+		case q.Essay:
+			sb.WriteString("<p><em>Essay.</em></p>")
+			if len(q.Submission) > 0 {
+				sb.WriteString("<blockquote>")
+				for _, p := range q.Submission {
+					sb.WriteString("<p>" + esc(p) + "</p>")
+				}
+				sb.WriteString("</blockquote>")
+			}
+			if len(q.Files) > 0 {
+				sb.WriteString("<p>Files: " + esc(strings.Join(q.Files, ", ")) + "</p>")
+			}
+			if len(q.Rubric) > 0 {
+				sb.WriteString("<table><tbody><tr><th>Criterion</th><th>Points</th></tr>")
+				for _, c := range q.Rubric {
+					sb.WriteString(fmt.Sprintf("<tr><td>%s</td><td>%s</td></tr>", esc(c.Description), FormatPoints(c.Points)))
+				}
+				sb.WriteString("</tbody></table>")
+			}
+		default:
+			if len(q.Options) > 0 {
+				sb.WriteString("<ul>")
+				for _, o := range q.Options {
+					if o.Correct {
+						sb.WriteString(fmt.Sprintf("<li><strong>%s (correct)</strong></li>", esc(o.Label)))
+					} else {
+						sb.WriteString("<li>" + esc(o.Label) + "</li>")
+					}
+				}
+				sb.WriteString("</ul>")
+			}
+			switch {
+			case len(q.Answers) > 1:
+				sb.WriteString("<p><strong>Correct answers:</strong></p><ul>")
+				for _, a := range q.Answers {
+					sb.WriteString("<li>" + esc(a) + "</li>")
+				}
+				sb.WriteString("</ul>")
+			case len(q.Answers) == 1:
+				sb.WriteString(fmt.Sprintf("<p><strong>Answer:</strong> %s</p>", esc(q.Answers[0])))
+			default:
+				sb.WriteString("<p><strong>Answer:</strong> (answer unavailable)</p>")
+			}
+		}
+		if len(q.Attempts) > 0 {
+			sb.WriteString("<table><tbody><tr><th>Attempt</th><th>Answer</th><th>Score</th></tr>")
+			for _, a := range q.Attempts {
+				sb.WriteString(fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td></tr>", esc(a.Attempt), esc(a.Answer), a.Score))
+			}
+			sb.WriteString("</tbody></table>")
+		}
+		if q.Class != nil {
+			sb.WriteString(fmt.Sprintf("<p><strong>Class:</strong> %s</p>", esc(q.Class.Summary())))
+		}
+		if q.Explanation != "" {
+			sb.WriteString(fmt.Sprintf("<p><strong>Explanation:</strong> %s</p>", esc(q.Explanation)))
+		}
+		for _, c := range q.Comments {
+			sb.WriteString(fmt.Sprintf("<blockquote><p><strong>Instructor:</strong> %s</p></blockquote>", esc(c.Text)))
+		}
+	}
+	return sb.String()
+}
+
+// RoundTo rounds v to the given number of decimal places.
+func RoundTo(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+// SpeechScript is the text read aloud for doc: each question with its options, then the
+// answer and the explanation, as sentences a speech engine pauses between.
+func SpeechScript(doc QuizDoc) string {
+	var sb strings.Builder
+	sentence := func(s string) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return
+		}
+		if !strings.ContainsAny(s[len(s)-1:], ".?!:") {
+			s += "."
+		}
+		sb.WriteString(s + "\n")
+	}
+	sentence(doc.Title)
+	sb.WriteString("\n")
+	for _, q := range doc.Questions {
+		sentence(fmt.Sprintf("Question %d. %s", q.Number, blankPlaceholder.ReplaceAllString(q.Text, "blank")))
+		if !q.OpenEntry {
+			for i, o := range q.Options {
+				sentence(fmt.Sprintf("%c: %s", 'A'+i, o.Label))
+			}
+		}
+		switch {
+		case q.OpenEntry && len(q.Blanks) > 0:
+			var parts []string
+			for _, b := range q.Blanks {
+				if b.Answer != "" {
+					parts = append(parts, b.Answer)
+				}
+			}
+			if len(parts) > 0 {
+				sentence("Answer: " + strings.Join(parts, ", then "))
+			}
+		case len(q.Answers) > 1:
+			last := len(q.Answers) - 1
+			sentence("Correct answers: " + strings.Join(q.Answers[:last], ", ") + " and " + q.Answers[last])
+		case len(q.Answers) == 1:
+			sentence(q.givenPrefix() + "Answer: " + q.Answers[0])
+		}
+		sentence(q.Explanation)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,3793 @@
+package quizextract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestParseMergeRender(t *testing.T) {
+	quiz, err := os.ReadFile("../selftest/st01.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := os.ReadFile("../selftest/st01_result.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Parse(quiz, results, "ST01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Questions) != 4 || doc.Practice {
+		t.Fatalf("Parse: %d questions, practice %v", len(doc.Questions), doc.Practice)
+	}
+	if practice, err := Parse(quiz, nil, "ST01"); err != nil || !practice.Practice {
+		t.Errorf("Parse without results: practice %v, err %v", practice.Practice, err)
+	}
+	merged, err := Merge(quiz, [][]byte{results, results}, []string{"first", "second"}, "ST01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(merged.Questions[0].Attempts); got != 2 {
+		t.Errorf("Merge: question 1 has %d attempts, want 2", got)
+	}
+	if _, err := Merge(quiz, [][]byte{[]byte(`{}`)}, nil, "ST01"); err == nil {
+		t.Error("Merge accepted results without item results")
+	}
+	for _, format := range []string{"", "md", "html", "mediawiki", "rst", "adoc", "txt", "quizizz", "docx", "csv"} {
+		out, _, err := Render(doc, RenderOptions{Format: format})
+		if err != nil || out == "" {
+			t.Errorf("Render(%q) = %d bytes, %v", format, len(out), err)
+		}
+	}
+	if md, _, _ := Render(doc, RenderOptions{}); md != RenderMarkdown(doc) {
+		t.Error("Render without a format is not Markdown")
+	}
+	if _, _, err := Render(doc, RenderOptions{Format: "odt"}); err == nil {
+		t.Error("Render accepted an unknown format")
+	}
+}
+
+func TestRenderHTMLCSS(t *testing.T) {
+	dir := t.TempDir()
+	css := filepath.Join(dir, "styles", "custom.css")
+	if err := os.MkdirAll(filepath.Dir(css), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(css, []byte("body { color: navy; }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	doc := QuizDoc{Title: "wk01 <Quiz>"}
+	tests := []struct {
+		name    string
+		opts    HTMLOptions
+		want    []string
+		wantErr bool
+	}{
+		{"no stylesheet", HTMLOptions{}, []string{"<title>wk01 &lt;Quiz&gt;</title>", "--qe-spacing"}, false},
+		{"inline", HTMLOptions{CSSPath: css}, []string{"<style>\nbody { color: navy; }\n</style>\n"}, false},
+		{"link relative to output", HTMLOptions{CSSPath: css, CSSMode: "link", OutPath: filepath.Join(dir, "out.html")}, []string{`<link rel="stylesheet" href="styles/custom.css">`}, false},
+		{"link url", HTMLOptions{CSSPath: "https://example.com/a.css", CSSMode: "link", OutPath: filepath.Join(dir, "out.html")}, []string{`<link rel="stylesheet" href="https://example.com/a.css">`}, false},
+		{"missing file", HTMLOptions{CSSPath: filepath.Join(dir, "missing.css")}, nil, true},
+		{"unknown mode", HTMLOptions{CSSPath: css, CSSMode: "embed"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := RenderHTML(doc, tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: output missing %q", tt.name, w)
+			}
+		}
+	}
+}
+
+func TestRenderHTMLTheme(t *testing.T) {
+	dir := t.TempDir()
+	css := filepath.Join(dir, "custom.css")
+	if err := os.WriteFile(css, []byte(".question { border: 0; }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		theme   string
+		want    string // substring of the theme's <style> block
+		wantErr bool
+	}{
+		{"", "", false},
+		{"light", "", false},
+		{"dark", "--qe-bg: #0d1117;", false},
+		{"sepia", "--qe-bg: #f4ecd8;", false},
+		{"compact", "--qe-spacing: 0.5rem;", false},
+		{"solarized", "", true},
+	}
+	for _, tt := range tests {
+		got, err := RenderHTML(QuizDoc{Title: "wk01"}, HTMLOptions{Theme: tt.theme, CSSPath: css})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("theme %q: err = %v, wantErr %v", tt.theme, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if n := strings.Count(got, "<style>"); tt.want == "" && n != 2 || tt.want != "" && n != 3 {
+			t.Errorf("theme %q: %d <style> blocks", tt.theme, n)
+		}
+		// The user stylesheet comes after the theme so it can override it.
+		user := strings.Index(got, ".question { border: 0; }")
+		if tt.want != "" {
+			if i := strings.Index(got, tt.want); i < 0 || i > user {
+				t.Errorf("theme %q: %q missing or after the user stylesheet", tt.theme, tt.want)
+			}
+		}
+	}
+}
+
+func TestDecodeAnswerFeedback(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", ``, nil, false},
+		{"null", `null`, nil, false},
+		{"map", `{"c1": "Right.", "c2": "Too slow."}`, map[string]string{"c1": "Right.", "c2": "Too slow."}, false},
+		{"array by id", `[{"id": "c1", "feedback": "Right."}]`, map[string]string{"c1": "Right."}, false},
+		{"array by choice_id", `[{"id": "x", "choice_id": "c2", "item_body": "<p>Too slow.</p>"}]`, map[string]string{"c2": "<p>Too slow.</p>"}, false},
+		{"array skips blanks", `[{"id": "c1"}, {"feedback": "orphan"}]`, map[string]string{}, false},
+		{"unknown shape", `"feedback"`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := decodeAnswerFeedback(json.RawMessage(tt.raw))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeAnswerFeedback error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decodeAnswerFeedback = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenderFeedbackFootnotes(t *testing.T) {
+	doc := QuizDoc{Title: "wk01", Questions: []Question{{
+		Number:    2,
+		Text:      "Pick one",
+		HasResult: true,
+		Options: []Option{
+			{Label: "A", Correct: true, Feedback: "Right."},
+			{Label: "B"},
+			{Label: "C", Feedback: "Too <slow>."},
+		},
+		Answers: []string{"A"},
+	}}}
+	tests := []struct {
+		name   string
+		render func() string
+		want   []string
+	}{
+		{"markdown", func() string { return RenderMarkdown(doc) }, []string{
+			"  - A (correct) [^q2-1]\n",
+			"  - B\n",
+			"  - C [^q2-3]\n",
+			"[^q2-1]: Right.\n[^q2-3]: Too <slow>.\n",
+		}},
+		{"html", func() string { s, _ := RenderHTML(doc, HTMLOptions{}); return s }, []string{
+			`<a href="#q2-fn1">1</a>`,
+			`<a href="#q2-fn3">2</a>`,
+			"<ol class=\"footnotes\">\n<li id=\"q2-fn1\">Right.</li>\n<li id=\"q2-fn3\">Too &lt;slow&gt;.</li>\n</ol>",
+		}},
+	}
+	for _, tt := range tests {
+		got := tt.render()
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: output missing %q", tt.name, w)
+			}
+		}
+	}
+}
+
+func TestApplyExplanations(t *testing.T) {
+	notes := map[string]string{"item-1": "From the item note.", "2": "From the number note."}
+	answer := func(prompt string) (string, error) { return "From the LLM.", nil }
+	fail := func(prompt string) (string, error) { return "", errors.New("exit status 1") }
+	tests := []struct {
+		name     string
+		sources  []string
+		llm      LLM
+		q        Question
+		want     string
+		warnings int
+	}{
+		{"first source wins", []string{"general", "correct"}, nil, Question{GeneralFeedback: "General.", CorrectFeedback: "Correct."}, "General.", 0},
+		{"empty source falls through", []string{"general", "correct"}, nil, Question{CorrectFeedback: " Correct. "}, "Correct.", 0},
+		{"notes by item id", []string{"notes"}, nil, Question{Number: 2, ItemID: "item-1"}, "From the item note.", 0},
+		{"notes by number", []string{"notes"}, nil, Question{Number: 2, ItemID: "item-9"}, "From the number note.", 0},
+		{"llm without a runner is skipped", []string{"llm", "general"}, nil, Question{GeneralFeedback: "General."}, "General.", 0},
+		{"llm answers", []string{"llm", "general"}, answer, Question{GeneralFeedback: "General."}, "From the LLM.", 0},
+		{"llm failure warns and falls through", []string{"llm", "general"}, fail, Question{GeneralFeedback: "General."}, "General.", 1},
+		{"nothing found", []string{"general", "notes"}, nil, Question{Number: 7}, "", 0},
+	}
+	for _, tt := range tests {
+		doc := QuizDoc{Questions: []Question{tt.q}}
+		warnings := ApplyExplanations(&doc, ExplainConfig{Sources: tt.sources, Notes: notes, LLM: tt.llm})
+		if got := doc.Questions[0].Explanation; got != tt.want {
+			t.Errorf("%s: Explanation = %q, want %q", tt.name, got, tt.want)
+		}
+		if len(warnings) != tt.warnings {
+			t.Errorf("%s: warnings = %q, want %d", tt.name, warnings, tt.warnings)
+		}
+	}
+}
+
+func TestDescribeBlankRule(t *testing.T) {
+	yes, no, two := true, false, 2
+	tests := []struct {
+		name       string
+		answerType string
+		sc         blankScoring
+		want       string
+	}{
+		{"no scoring", "openEntry", blankScoring{}, ""},
+		{"exact, case-insensitive", "openEntry", blankScoring{Algorithm: "TextEquivalence", CaseSensitive: &no}, "exact match, case-insensitive"},
+		{"contains, case-sensitive, whitespace", "openEntry", blankScoring{Algorithm: "TextContainsAnswer", CaseSensitive: &yes, IgnoreWhitespace: &yes}, "response must contain the answer, case-sensitive, extra whitespace ignored"},
+		{"close enough with distance", "", blankScoring{Algorithm: "TextCloseEnough", MaxDistance: &two}, "close match (up to 2 character edits)"},
+		{"close enough without distance", "", blankScoring{Algorithm: "TextCloseEnough"}, "close match"},
+		{"dropdown", "Dropdown", blankScoring{Algorithm: "TextInChoices"}, "must match one of the accepted answers, chosen from a dropdown"},
+		{"word bank", "wordbank", blankScoring{Algorithm: "Equivalence", IgnoreWhitespace: &no}, "exact match, chosen from the word bank"},
+		{"unknown algorithm", "", blankScoring{Algorithm: "Custom"}, "Custom"},
+	}
+	for _, tt := range tests {
+		if got := describeBlankRule(tt.answerType, tt.sc); got != tt.want {
+			t.Errorf("%s: describeBlankRule = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWordBankAndDropdownBlanks(t *testing.T) {
+	var quiz []QuizItem
+	if err := json.Unmarshal([]byte(`[{"position": 1, "item": {
+		"id": "i1",
+		"item_body": "<p>`+"`b1`"+` and `+"`b2`"+` and `+"`b3`"+`</p>",
+		"interaction_data": {
+			"blanks": [
+				{"id": "b1", "answer_type": "wordbank"},
+				{"id": "b2", "answer_type": "dropdown", "choices": [{"id": "d1", "item_body": "red"}, {"id": "d2", "item_body": "blue"}]},
+				{"id": "b3", "answer_type": "openEntry"}
+			],
+			"word_bank_choices": [{"id": "w2", "item_body": "<b>beta</b>", "position": 2}, {"id": "w1", "item_body": "alpha", "position": 1}]
+		}
+	}}]`), &quiz); err != nil {
+		t.Fatal(err)
+	}
+	var results []ResultItem
+	if err := json.Unmarshal([]byte(`[{"item_id": "i1", "scored_data": {"value": {
+		"b1": {"correct_answer": "w2"},
+		"b2": {"correct_answer": "d1"},
+		"b3": {"correct_answer": "gamma"}
+	}}}]`), &results); err != nil {
+		t.Fatal(err)
+	}
+	q := BuildQuizDoc(quiz, results, "wk01").Questions[0]
+	if want := []string{"alpha", "beta"}; !reflect.DeepEqual(q.WordBank, want) {
+		t.Errorf("WordBank = %q, want %q", q.WordBank, want)
+	}
+	tests := []struct {
+		blank int
+		want  string
+	}{
+		{0, "beta"},
+		{1, "red"},
+		{2, "gamma"},
+	}
+	for _, tt := range tests {
+		if got := q.Blanks[tt.blank].Answer; got != tt.want {
+			t.Errorf("blank %d: Answer = %q, want %q", tt.blank+1, got, tt.want)
+		}
+	}
+}
+
+func TestDeriveSelectedChoiceIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{"map form", `{"c1": {"user_responded": true}, "c2": {"user_responded": false}, "c3": {}}`, map[string]bool{"c1": true}, false},
+		{"single id", `"c2"`, map[string]bool{"c2": true}, false},
+		{"id list", `["c1", "c3"]`, map[string]bool{"c1": true, "c3": true}, false},
+		{"no value", `null`, map[string]bool{}, false},
+		{"number", `7`, map[string]bool{}, true},
+		{"list of objects", `[{"id": "c1"}]`, map[string]bool{}, true},
+	}
+	for _, tt := range tests {
+		res := ResultItem{Scored: ScoredData{ValueRaw: json.RawMessage(tt.value)}}
+		got, err := deriveSelectedChoiceIDs(res)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: deriveSelectedChoiceIDs error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: deriveSelectedChoiceIDs = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeStringList(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", ``, nil, false},
+		{"null", `null`, nil, false},
+		{"empty string", `""`, nil, false},
+		{"string", `"a"`, []string{"a"}, false},
+		{"mixed list", `["a", 2]`, []string{"a", "2"}, false},
+		{"number", `2`, nil, true},
+		{"object", `{"a": 1}`, nil, true},
+		{"list with an object", `["a", {"b": 1}]`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := decodeStringList(json.RawMessage(tt.raw))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeStringList error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decodeStringList = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsScaleSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"likert-scale", true},
+		{"Scale", true},
+		{"survey", true},
+		{"choice", false},
+		{"multi-answer", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isScaleSlug(tt.slug); got != tt.want {
+			t.Errorf("isScaleSlug(%q) = %v, want %v", tt.slug, got, tt.want)
+		}
+	}
+}
+
+func TestIsUngradedQuiz(t *testing.T) {
+	graded := []QuizItem{{PointsPossible: 1}, {PointsPossible: 2}}
+	result := func(js string) ResultItem {
+		var r ResultItem
+		if err := json.Unmarshal([]byte(js), &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	tests := []struct {
+		name    string
+		quiz    []QuizItem
+		results []ResultItem
+		want    bool
+	}{
+		{"no items", nil, nil, false},
+		{"all zero points", []QuizItem{{}, {}}, nil, true},
+		{"graded without results", graded, nil, false},
+		{"responses only", graded, []ResultItem{result(`{"scored_data": {"value": {"c1": {"user_responded": true}}}}`)}, true},
+		{"per-entry correct flag", graded, []ResultItem{result(`{"scored_data": {"value": {"c1": {"correct": true}}}}`)}, false},
+		{"hidden key but scored", graded, []ResultItem{result(`{"score": 1, "scored_data": {"value": {"c1": {"user_responded": true}}}}`)}, false},
+		{"hidden key with points", graded, []ResultItem{result(`{"points_possible": 2, "scored_data": {"value": "c1"}}`)}, false},
+	}
+	for _, tt := range tests {
+		if got := isUngradedQuiz(tt.quiz, tt.results); got != tt.want {
+			t.Errorf("%s: isUngradedQuiz = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyMeta(t *testing.T) {
+	tests := []struct {
+		name string
+		meta QuizMeta
+		want []string
+	}{
+		{"no metadata", QuizMeta{}, nil},
+		{"new quizzes instructions", QuizMeta{Instructions: "<p>Read <b>carefully</b>.</p><p>Two attempts.</p>", Description: "<p>ignored</p>"}, []string{"Read carefully.", "Two attempts."}},
+		{"classic description", QuizMeta{Instructions: " ", Description: "<p>Line one<br/>Line two</p><ul><li>Item</li></ul>"}, []string{"Line one", "Line two", "Item"}},
+	}
+	for _, tt := range tests {
+		var doc QuizDoc
+		doc.ApplyMeta(tt.meta)
+		if !reflect.DeepEqual(doc.Description, tt.want) {
+			t.Errorf("%s: Description = %q, want %q", tt.name, doc.Description, tt.want)
+		}
+	}
+}
+
+func TestNewQuestionGroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       *QuizGroup
+		want     *QuestionGroup
+		wantRule string
+	}{
+		{"nil", nil, nil, ""},
+		{"no id or title", &QuizGroup{PickCount: 2}, nil, ""},
+		{"pick_count of item_count", &QuizGroup{ID: "g1", Title: "Warm-up", PickCount: 2, ItemCount: 5}, &QuestionGroup{ID: "g1", Title: "Warm-up", Pick: 2, Of: 5}, "pick 2 of 5"},
+		{"sample_num of entry_count", &QuizGroup{ID: "g2", SampleNum: 3, EntryCount: 8}, &QuestionGroup{ID: "g2", Title: "Question group", Pick: 3, Of: 8}, "pick 3 of 8"},
+		{"title as id, pick only", &QuizGroup{Title: "Bank A", PickCount: 1}, &QuestionGroup{ID: "Bank A", Title: "Bank A", Pick: 1}, "pick 1"},
+		{"no counts", &QuizGroup{ID: "g3"}, &QuestionGroup{ID: "g3", Title: "Question group"}, ""},
+	}
+	for _, tt := range tests {
+		got := newQuestionGroup(tt.in)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: newQuestionGroup = %+v, want %+v", tt.name, got, tt.want)
+			continue
+		}
+		if got != nil && got.Rule() != tt.wantRule {
+			t.Errorf("%s: Rule = %q, want %q", tt.name, got.Rule(), tt.wantRule)
+		}
+	}
+}
+
+func TestScheduleDetails(t *testing.T) {
+	tests := []struct {
+		name string
+		meta QuizMeta
+		want []DocDetail
+	}{
+		{"nothing scheduled", QuizMeta{}, nil},
+		{"due only", QuizMeta{DueAt: "2024-03-08T23:59:00Z"}, []DocDetail{{"Due", "Fri 8 Mar 2024 23:59 UTC"}}},
+		{"window", QuizMeta{UnlockAt: "2024-03-01T08:00:00.000+07:00", LockAt: "2024-03-09T08:00:00+07:00"}, []DocDetail{{"Available", "Fri 1 Mar 2024 08:00 +0700 until Sat 9 Mar 2024 08:00 +0700"}}},
+		{"from only", QuizMeta{UnlockAt: "2024-03-01T08:00:00Z"}, []DocDetail{{"Available", "from Fri 1 Mar 2024 08:00 UTC"}}},
+		{"until unparseable", QuizMeta{LockAt: "next Friday"}, []DocDetail{{"Available", "until next Friday"}}},
+	}
+	for _, tt := range tests {
+		if got := scheduleDetails(tt.meta); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: scheduleDetails = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseRubric(t *testing.T) {
+	two := 2.0
+	criteria := `[{"id": "c1", "description": "<b>Thesis</b>", "points": 4, "ratings": [{"id": "r1", "description": "Clear", "points": 4}, {"id": "r2", "description": "Vague", "points": 2}]},
+		{"id": "c2", "long_description": "Uses evidence", "points": 2.5}]`
+	want := []RubricCriterion{
+		{ID: "c1", Description: "Thesis", Points: 4, Ratings: []RubricRating{{"r1", "Clear", 4}, {"r2", "Vague", 2}}},
+		{ID: "c2", Description: "Uses evidence", Points: 2.5},
+	}
+	assessed := []RubricCriterion{want[0], want[1]}
+	assessed[0].Assessed = &RubricAssessment{Points: &two, Rating: "Vague", Comments: "Sharpen it."}
+	tests := []struct {
+		name       string
+		raw        string
+		assessment string
+		want       []RubricCriterion
+	}{
+		{"none", ``, ``, nil},
+		{"bare array", criteria, ``, want},
+		{"wrapped in criteria", `{"criteria": ` + criteria + `}`, ``, want},
+		{"wrapped in data", `{"data": ` + criteria + `}`, ``, want},
+		{"with assessment", criteria, `{"c1": {"points": 2, "rating_id": "r2", "comments": " Sharpen it. "}}`, assessed},
+		{"unreadable", `"rubric"`, ``, nil},
+	}
+	for _, tt := range tests {
+		if got := parseRubric(json.RawMessage(tt.raw), json.RawMessage(tt.assessment)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseRubric = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsEssay(t *testing.T) {
+	tests := []struct {
+		slug, responseType string
+		want               bool
+	}{
+		{"essay", "", true},
+		{"", "Text", true},
+		{"", "richtext", true},
+		{"choice", "Uuid", false},
+		{"rich-fill-blank", "HashOfTexts", false},
+	}
+	for _, tt := range tests {
+		var item QuizItemInner
+		item.InteractionType.Slug = tt.slug
+		item.UserResponseType = tt.responseType
+		if got := isEssay(item); got != tt.want {
+			t.Errorf("isEssay(%q, %q) = %v, want %v", tt.slug, tt.responseType, got, tt.want)
+		}
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []Comment
+		wantErr bool
+	}{
+		{"none", ``, nil, false},
+		{"rows", `[{"author_name": " Dr. Lee ", "comment": "<p>Good work.</p>", "created_at": "2024-03-08T10:00:00Z"}, {"text": "See me."}, {"comment": " "}]`,
+			[]Comment{{Author: "Dr. Lee", Text: "Good work.", Date: "Fri 8 Mar 2024 10:00 UTC"}, {Text: "See me."}}, false},
+		{"bare string", `"Nice."`, []Comment{{Text: "Nice."}}, false},
+		{"string list", `["One.", "", "Two."]`, []Comment{{Text: "One."}, {Text: "Two."}}, false},
+		{"unreadable", `{"comment": "Nice."}`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseComments(json.RawMessage(tt.raw))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ParseComments error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ParseComments = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	quiz, class := itemAnalysisFixture(t)
+	doc := BuildQuizDoc(quiz, class[0], "T")
+	tests := []struct {
+		name  string
+		label FileLabel
+		class [][]ResultItem
+		want  QuizStats
+	}{
+		{"no results", FileLabel{Label: "WK01"}, nil, QuizStats{Label: "WK01", Title: "T", Questions: 1, Possible: 1}},
+		{"one student", FileLabel{Label: "WK01"}, class[:1], QuizStats{Label: "WK01", Title: "T", Questions: 1, Students: 1, Score: 1, Possible: 1}},
+		{"class mean, title as label", FileLabel{Title: "Sorting"}, class, QuizStats{Label: "Sorting", Title: "T", Questions: 1, Students: 4, Score: 0.25, Possible: 1}},
+	}
+	for _, tt := range tests {
+		if got := ComputeStats(doc, tt.label, quiz, tt.class); got != tt.want {
+			t.Errorf("%s: ComputeStats = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	if got := (QuizStats{Score: 1, Possible: 3}).Percent(); RoundTo(got, 1) != 33.3 {
+		t.Errorf("Percent = %v, want 33.3", got)
+	}
+	if got := (QuizStats{}).Percent(); got != 0 {
+		t.Errorf("Percent with nothing possible = %v, want 0", got)
+	}
+}
+
+func TestDescribeRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`colou?r`, `"colo", then optionally "u", then "r"`},
+		{`^\d{3}-\d{4}$`, `the start, then a digit (3 times), then "-", then a digit (4 times), then the end`},
+		{`[A-Z]{2,}`, `one of [A-Z] (2 or more times)`},
+		{`x{1,3}`, `"x" (1 to 3 times)`},
+		{`x{0,3}`, `"x" (up to 3 times)`},
+		{`cat|dog`, `either "cat" or "dog"`},
+		{`(?i)^yes$`, `the start, then "yes", then the end (ignoring case)`},
+		{`a\sb`, `"a", then a whitespace character, then "b"`},
+		{`\w+`, `one or more of a letter, digit or underscore`},
+		{`(`, ``},
+	}
+	for _, tt := range tests {
+		if got := describeRegex(tt.pattern); got != tt.want {
+			t.Errorf("describeRegex(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestRegexExample(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`^\d{3}-\d{4}$`, "000-0000"},
+		{`colou?r`, "colour"},
+		{`(?i)^YES$`, "yes"},
+		{`a\sb`, "a b"},
+		{`cat|dog`, "cat"},
+		{`\w+@\w+\.com`, "a@a.com"},
+	}
+	for _, tt := range tests {
+		got := regexExample(tt.pattern)
+		if got != tt.want {
+			t.Errorf("regexExample(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+		if !regexp.MustCompile(tt.pattern).MatchString(got) {
+			t.Errorf("regexExample(%q) = %q does not match the pattern", tt.pattern, got)
+		}
+	}
+}
+
+func TestClassExample(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []rune
+		want   rune
+	}{
+		{"lowercase preferred", []rune{'0', '9', 'a', 'z'}, 'a'},
+		{"digits", []rune{'0', '9'}, '0'},
+		{"punctuation", []rune{'-', '-', '.', '.'}, '-'},
+		{"whitespace", []rune{'\t', '\n', '\f', '\r', ' ', ' '}, ' '},
+	}
+	for _, tt := range tests {
+		if got := classExample(tt.ranges); got != tt.want {
+			t.Errorf("%s: classExample = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseBlankScoring(t *testing.T) {
+	raw := []byte(`{"value": [
+		{"id": "b1", "scoring_algorithm": "TextContainsAnswer", "scoring_data": {"value": ["monitoring", "observability"], "case_sensitive": false}},
+		{"id": "b2", "scoring_algorithm": "TextRegex", "scoring_data": {"value": "^\\d+$"}},
+		{"id": "b3", "scoring_algorithm": "TextEquivalence", "scoring_data": {"blank_text": "latency"}},
+		{"scoring_algorithm": "TextEquivalence", "scoring_data": {"value": "no id"}}
+	]}`)
+	got, err := parseBlankScoring(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("parseBlankScoring returned %d blanks, want 3: %+v", len(got), got)
+	}
+	b1 := got["b1"]
+	if b1.Algorithm != "TextContainsAnswer" || len(b1.Accepted) != 2 || b1.Accepted[1] != "observability" {
+		t.Errorf("b1 = %+v", b1)
+	}
+	if b1.CaseSensitive == nil || *b1.CaseSensitive {
+		t.Errorf("b1.CaseSensitive = %v, want false", b1.CaseSensitive)
+	}
+	if b2 := got["b2"]; b2.Pattern != `^\d+$` || b2.Accepted != nil {
+		t.Errorf("b2 = %+v, want the regex as Pattern and no accepted answers", b2)
+	}
+	if b3 := got["b3"]; len(b3.Accepted) != 1 || b3.Accepted[0] != "latency" {
+		t.Errorf("b3 = %+v, want blank_text as the accepted answer", b3)
+	}
+	for _, in := range []string{``, `null`, `"oops"`} {
+		if got, _ := parseBlankScoring([]byte(in)); got != nil {
+			t.Errorf("parseBlankScoring(%q) = %+v, want nil", in, got)
+		}
+	}
+	if _, err := parseBlankScoring([]byte(`"oops"`)); err == nil {
+		t.Error("parseBlankScoring(\"oops\") returned no error")
+	}
+	got, err = parseBlankScoring([]byte(`[{"id": "b1", "scoring_data": {"value": {"x": 1}}}, {"id": "b2", "scoring_data": {"value": "ok"}}]`))
+	if err == nil || len(got["b1"].Accepted) != 0 || len(got["b2"].Accepted) != 1 {
+		t.Errorf("parseBlankScoring with an unreadable value = %+v, %v; want b2 kept and an error", got, err)
+	}
+}
+
+func TestSplitHotText(t *testing.T) {
+	passage := `<p>The <span data-hot-text-id="h1">cache</span> is <b>slow</b> but the <span data-id="h2">disk</span> is fine.</p>`
+	want := []PassageSpan{
+		{Text: "The "},
+		{Text: "cache", ID: "h1", Selectable: true},
+		{Text: " is slow but the "},
+		{Text: "disk", ID: "h2", Selectable: true},
+		{Text: " is fine."},
+	}
+	got := splitHotText(passage)
+	if len(got) != len(want) {
+		t.Fatalf("splitHotText returned %d spans, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("span %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestExamConditionsTimeLimit(t *testing.T) {
+	minutes := 20
+	tests := []struct {
+		name string
+		meta QuizMeta
+		want string
+	}{
+		{"under a minute", newQuizzesTimeLimit(30), "30 seconds"},
+		{"odd seconds", newQuizzesTimeLimit(90), "1 minute 30 seconds"},
+		{"whole minutes", newQuizzesTimeLimit(2700), "45 minutes"},
+		{"one minute", newQuizzesTimeLimit(60), "1 minute"},
+		{"classic", QuizMeta{TimeLimit: &minutes}, "20 minutes"},
+	}
+	for _, tt := range tests {
+		got := ""
+		for _, d := range examConditions(tt.meta) {
+			if d.Label == "Time limit" {
+				got = d.Value
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: time limit = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyQuizInfo(t *testing.T) {
+	doc := QuizDoc{Title: "WK12 Quiz — Questions and Solutions", Details: []DocDetail{{"Due", "Fri 1 May 2026 23:59 UTC"}}}
+	doc.ApplyQuizInfo(QuizInfo{Title: "Load testing", Points: 10, Attempt: 2, SubmittedAt: "2026-04-30T10:15:00Z"})
+	want := []DocDetail{
+		{"Quiz", "Load testing"},
+		{"Points possible", "10"},
+		{"Attempt", "2"},
+		{"Submitted", "Thu 30 Apr 2026 10:15 UTC"},
+		{"Due", "Fri 1 May 2026 23:59 UTC"},
+	}
+	if !reflect.DeepEqual(doc.Details, want) {
+		t.Errorf("Details = %v, want %v", doc.Details, want)
+	}
+	doc = QuizDoc{Title: "Load testing — Questions and Solutions"}
+	doc.ApplyQuizInfo(QuizInfo{Title: "Load testing"})
+	if len(doc.Details) != 0 {
+		t.Errorf("a title already in the heading added %v", doc.Details)
+	}
+	for _, tt := range []struct {
+		attempts []int
+		want     int
+	}{{[]int{2, 2, 0}, 2}, {[]int{1, 2}, 0}, {[]int{0}, 0}} {
+		var results []ResultItem
+		for _, a := range tt.attempts {
+			results = append(results, ResultItem{Attempt: a})
+		}
+		if got := ResultsAttempt(results); got != tt.want {
+			t.Errorf("ResultsAttempt(%v) = %d, want %d", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func newQuizzesTimeLimit(sec int) QuizMeta {
+	var m QuizMeta
+	m.Settings.HasTimeLimit = true
+	m.Settings.SessionTimeLimitInSeconds = sec
+	return m
+}
+
+// itemAnalysisFixture is one single-choice question answered by four students: one correct,
+// two picking "b" and one picking "c".
+func itemAnalysisFixture(t *testing.T) ([]QuizItem, [][]ResultItem) {
+	t.Helper()
+	var quiz []QuizItem
+	if err := json.Unmarshal([]byte(`[{
+		"points_possible": 1, "position": 1,
+		"item": {"id": "q1", "item_body": "<p>Pick one</p>", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": [
+				{"id": "a", "item_body": "Right", "position": 1},
+				{"id": "b", "item_body": "Tempting", "position": 2},
+				{"id": "c", "item_body": "Rare", "position": 3}]}}
+	}]`), &quiz); err != nil {
+		t.Fatal(err)
+	}
+	student := func(choice string, score float64) []ResultItem {
+		correct := choice == "a"
+		value := map[string]ResultValueEntry{}
+		responded, one := true, 1
+		value[choice] = ResultValueEntry{UserResponded: &responded}
+		if !correct {
+			// Results only reveal the key alongside a student's own response.
+			value["a"] = ResultValueEntry{ResultScore: &one}
+		} else {
+			value["a"] = ResultValueEntry{UserResponded: &responded, ResultScore: &one}
+		}
+		raw, _ := json.Marshal(value)
+		return []ResultItem{{ItemID: "q1", Score: score, Scored: ScoredData{Correct: correct, ValueRaw: raw}}}
+	}
+	return quiz, [][]ResultItem{student("a", 1), student("b", 0), student("b", 0), student("c", 0)}
+}
+
+func TestAnalyzeResults(t *testing.T) {
+	quiz, class := itemAnalysisFixture(t)
+	a := AnalyzeResults(quiz, class, "T", 30)
+	if a.Students != 4 || len(a.Items) != 1 {
+		t.Fatalf("analysis = %d students, %d items; want 4, 1", a.Students, len(a.Items))
+	}
+	st := a.Items[0]
+	if st.Students != 4 || st.Correct != 1 {
+		t.Errorf("students/correct = %d/%d, want 4/1", st.Students, st.Correct)
+	}
+	if got := st.PercentCorrect(); got != 25 {
+		t.Errorf("PercentCorrect = %v, want 25", got)
+	}
+	if got := st.AverageScore(); got != 0.25 {
+		t.Errorf("AverageScore = %v, want 0.25", got)
+	}
+	for id, want := range map[string]int{"a": 1, "b": 2, "c": 1} {
+		if got := st.ChoiceCounts[id]; got != want {
+			t.Errorf("ChoiceCounts[%s] = %d, want %d", id, got, want)
+		}
+	}
+}
+
+func TestAnalyzeResultsShuffledKey(t *testing.T) {
+	// Two students saw the choices of the same item in different shuffles, and each one's
+	// results reveal a different half of the key.
+	seen := func(order, key, chose string) (Question, ResultItem) {
+		var quiz []QuizItem
+		if err := json.Unmarshal([]byte(`[{"points_possible": 1, "position": 1,
+			"item": {"id": "q1", "item_body": "<p>Pick two</p>", "interaction_type": {"slug": "choice"}, "user_response_type": "MultipleUuid",
+				"interaction_data": {"shuffled_order": `+order+`, "choices": [
+					{"id": "a", "item_body": "Alpha", "position": 1},
+					{"id": "b", "item_body": "Beta", "position": 2},
+					{"id": "c", "item_body": "Gamma", "position": 3}]}}}]`), &quiz); err != nil {
+			t.Fatal(err)
+		}
+		responded, one := true, 1
+		raw, _ := json.Marshal(map[string]ResultValueEntry{key: {ResultScore: &one}, chose: {UserResponded: &responded}})
+		res := ResultItem{ItemID: "q1", Scored: ScoredData{ValueRaw: raw}}
+		return BuildQuizDoc(quiz, []ResultItem{res}, "T").Questions[0], res
+	}
+	st := ItemStats{ChoiceCounts: map[string]int{}, Responses: map[string]int{}}
+	st.add(seen(`["c", "a", "b"]`, "a", "b"))
+	st.add(seen(`["b", "c", "a"]`, "c", "b"))
+	correct := map[string]bool{}
+	for _, o := range st.Question.Options {
+		correct[o.ID] = o.Correct
+	}
+	if want := map[string]bool{"a": true, "b": false, "c": true}; !reflect.DeepEqual(correct, want) {
+		t.Errorf("merged key = %v, want %v", correct, want)
+	}
+	if st.Students != 2 || st.ChoiceCounts["b"] != 2 {
+		t.Errorf("students = %d, b chosen %d times; want 2, 2", st.Students, st.ChoiceCounts["b"])
+	}
+}
+
+func TestDistractors(t *testing.T) {
+	quiz, class := itemAnalysisFixture(t)
+	a := AnalyzeResults(quiz, class, "T", 30)
+	st := a.Items[0]
+	d := st.Distractors()
+	if len(d) != 2 || d[0].ID != "b" || d[1].ID != "c" {
+		t.Fatalf("Distractors = %+v, want b then c", d)
+	}
+	if !a.flagged(st, d[0]) || a.flagged(st, d[1]) {
+		t.Errorf("flagged(b, c) = %v, %v; want true, false at 30%%", a.flagged(st, d[0]), a.flagged(st, d[1]))
+	}
+	st.Question.Ungraded = true
+	if d := st.Distractors(); d != nil {
+		t.Errorf("ungraded Distractors = %+v, want none", d)
+	}
+}
+
+func TestAnalyzeGroups(t *testing.T) {
+	quiz, class := itemAnalysisFixture(t)
+	groups := AnalyzeGroups(quiz, class, []string{"Sec B", NotInGradebook, "Sec A", "Sec B"}, 30)
+	var got []string
+	for _, g := range groups {
+		got = append(got, fmt.Sprintf("%s %d/%d", g.Name, g.Analysis.Items[0].Correct, g.Analysis.Students))
+	}
+	want := []string{"Sec A 0/1", "Sec B 1/2", NotInGradebook + " 0/1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("analyzeGroups = %q, want %q", got, want)
+	}
+	bands := AnalyzeGroups(quiz, class, []string{"50–60", "90–100", "50–60", "80–90"}, 30)
+	if bands[0].Name != "90–100" || bands[2].Name != "50–60" {
+		t.Errorf("score bands ordered %s, %s, %s; want highest first", bands[0].Name, bands[1].Name, bands[2].Name)
+	}
+}
+
+func TestRenderConfluenceStorageIsWellFormed(t *testing.T) {
+	doc := QuizDoc{
+		Title:   "WK12 Quiz",
+		Details: []DocDetail{{Label: "Due", Value: "Mon <9am>"}},
+		Questions: []Question{
+			{Number: 1, Text: `Is 1 < 2 & "true"?`, HasResult: true, Options: []Option{{Label: "Yes", Correct: true}, {Label: "No"}}, Answers: []string{"Yes"}},
+			{Number: 2, Text: "Fill [Blank 1]", HasResult: true, OpenEntry: true, Blanks: []BlankAnswer{{Label: "Blank 1"}}},
+		},
+	}
+	out := RenderConfluenceStorage(doc)
+	dec := xml.NewDecoder(strings.NewReader("<root>" + out + "</root>"))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("storage format is not well-formed XML: %v\n%s", err, out)
+		}
+	}
+	if !strings.Contains(out, "<li><strong>Yes (correct)</strong></li>") {
+		t.Errorf("correct option not bold:\n%s", out)
+	}
+}
+
+func TestWikiText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Soak testing is used to:", "Soak testing is used to:"},
+		{"Fill [Blank 1] here", "<nowiki>Fill [Blank 1] here</nowiki>"},
+		{"it''s bold", "<nowiki>it''s bold</nowiki>"},
+		{"a < b", "<nowiki>a < b</nowiki>"},
+		{"* not a bullet", "<nowiki>* not a bullet</nowiki>"},
+		{"x = 1", "x = 1"},
+		{"ends with =", "<nowiki>ends with =</nowiki>"},
+		{"</nowiki>", "<nowiki>&lt;/nowiki></nowiki>"},
+	}
+	for _, tt := range tests {
+		if got := wikiText(tt.in); got != tt.want {
+			t.Errorf("wikiText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRSTText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Soak testing is used to:", "Soak testing is used to:"},
+		{"wk_12 results", "wk_12 results"},
+		{"see link_ here", `see link\_ here`},
+		{"*args and `code`", "\\*args and \\`code\\`"},
+		{`a|b\c`, `a\|b\\c`},
+	}
+	for _, tt := range tests {
+		if got := rstText(tt.in); got != tt.want {
+			t.Errorf("rstText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAdocText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Soak testing is used to:", "Soak testing is used to:"},
+		{"Fill [Blank 1] here", `pass:c[Fill [Blank 1\] here]`},
+		{"C++ & *args", "pass:c[C++ & *args]"},
+	}
+	for _, tt := range tests {
+		if got := adocText(tt.in); got != tt.want {
+			t.Errorf("adocText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		text, first, rest string
+		width             int
+		want              string
+	}{
+		{"short line", "1) ", "   ", 80, "1) short line"},
+		{"one two three four", "1) ", "   ", 12, "1) one two\n   three\n   four"},
+		{"averyverylongword fits", "", "", 5, "averyverylongword\nfits"},
+		{"no   wrap  at all", "", "", 0, "no wrap at all"},
+		{"", "   -> ", "", 80, "   ->"},
+	}
+	for _, tt := range tests {
+		if got := wrapText(tt.text, tt.width, tt.first, tt.rest); got != tt.want {
+			t.Errorf("wrapText(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestRenderQuizizz(t *testing.T) {
+	opts := func(labels ...string) []Option {
+		var out []Option
+		for _, l := range labels {
+			out = append(out, Option{Label: strings.TrimSuffix(l, "*"), Correct: strings.HasSuffix(l, "*")})
+		}
+		return out
+	}
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, ContentID: "q-1", Text: "Pick the planet.", Options: opts("Moon", "Mars*")},
+		{Number: 2, Text: "Pick the primes.", Multi: true, Options: opts("2*", "4", "7*")},
+		{Number: 3, Text: "Water boils at [Blank 1] degrees.", OpenEntry: true, Blanks: []BlankAnswer{{Answer: "100", Accepted: []string{"100", "one hundred"}}}},
+		{Number: 4, Text: "Explain, briefly.", Essay: true},
+		{Number: 5, Text: "Rate the course.", Ungraded: true, Options: opts("Good", "Bad")},
+		{Number: 6, Text: "Pick one.", Options: opts("a", "b", "c", "d", "e", "f*")},
+		{Number: 7, Text: "Match them.", Type: "matching", Answers: []string{"a → 1"}},
+		{Number: 8, Text: "[Blank 1] and [Blank 2]", OpenEntry: true, Blanks: []BlankAnswer{{Answer: "x"}, {Answer: "y"}}},
+		{Number: 9, Text: "Pick the answer.", Options: opts("a", "b")},
+	}}
+	got, warnings := RenderQuizizz(doc, 45)
+	want := `Question Text,Question Type,Option 1,Option 2,Option 3,Option 4,Option 5,Correct Answer,Time in seconds,Image Link,Content ID
+Pick the planet.,Multiple Choice,Moon,Mars,,,,2,45,,q-1
+Pick the primes.,Checkbox,2,4,7,,,"1,3",45,,
+Water boils at _____ degrees.,Fill-in-the-Blank,100,one hundred,,,,,45,,
+"Explain, briefly.",Open-Ended,,,,,,,45,,
+Rate the course.,Poll,Good,Bad,,,,,45,,
+`
+	if string(got) != want {
+		t.Errorf("renderQuizizz =\n%s\nwant\n%s", got, want)
+	}
+	wantWarnings := []string{
+		"question 6 skipped: 6 options; Quizizz takes at most 5",
+		"question 7 skipped: Quizizz has no matching questions",
+		"question 8 skipped: 2 blanks; Quizizz fill-in-the-blank questions have one",
+		"question 9 skipped: the answer key is unknown",
+	}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("warnings = %q, want %q", warnings, wantWarnings)
+	}
+}
+
+func TestRenderCSV(t *testing.T) {
+	half := 0.5
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, ContentID: "q-1", Type: "multiple choice", Text: "Pick the planet.", Options: []Option{{Label: "Moon"}, {Label: "Mars", Correct: true}}, Answers: []string{"Mars"}, Possible: 1, Earned: &half},
+		{Number: 2, Type: "fill in the blank", Text: "Water boils at [Blank 1], at sea level.", OpenEntry: true, Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "100"}}, Possible: 2},
+		{Number: 3, Type: "matching", Text: "Match them.", Answers: []string{"a → 1", "b → 2"}, Quizzes: []string{"WK03", "WK07"}},
+	}}
+	got, err := RenderCSV(doc, "WK12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `week,number,type,question,options,correct_answers,points_possible,points_earned,content_id
+WK12,1,multiple choice,Pick the planet.,Moon | Mars,Mars,1,0.5,q-1
+WK12,2,fill in the blank,"Water boils at [Blank 1], at sea level.",,Blank 1: 100,2,,
+WK03 | WK07,3,matching,Match them.,,a → 1 | b → 2,0,,
+`
+	if string(got) != want {
+		t.Errorf("RenderCSV =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderObsidian(t *testing.T) {
+	one, none := 1.0, 0.0
+	doc := QuizDoc{Title: `WK01 "Intro" Quiz`, Questions: []Question{
+		{Number: 1, ContentID: "q-1", Text: "Pick the planet.", HasResult: true, Possible: 1, Earned: &one, Tags: []string{"bloom:remember", "solar system"},
+			Answers: []string{"Mars"}, Options: []Option{{Label: "Moon", Feedback: "A moon."}, {Label: "Mars", Correct: true}}},
+		{Number: 2, ContentID: "q-2", Text: "Rate it.", HasResult: true, Ungraded: true, Options: []Option{{Label: "Good", Selected: true}}, Responses: []string{"Good"}},
+		{Number: 3, ContentID: "q-3", Text: "Boils at [Blank 1].", HasResult: true, OpenEntry: true, Possible: 1, Earned: &none, Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "100"}}},
+	}}
+	got := RenderObsidian(doc, ObsidianOptions{Course: "CS 101", Week: "WK01", Date: "2026-01-05"})
+	want := `---
+title: "WK01 \"Intro\" Quiz"
+course: "CS 101"
+week: "WK01"
+score: 1
+points_possible: 2
+date: 2026-01-05
+tags:
+  - quiz
+  - wk01
+  - bloom/remember
+  - solar-system
+---
+
+# WK01 "Intro" Quiz
+
+<a id="q-1"></a>
+
+## 1) Pick the planet.
+- Tags: #bloom/remember #solar-system
+
+- Options:
+  - Moon
+  - Mars
+
+> [!answer]- Answer
+> - Answer: Mars
+>
+> - Moon: A moon.
+
+<a id="q-2"></a>
+
+## 2) Rate it.
+- Options (ungraded):
+  - Good (your response)
+
+- Your response: Good
+
+<a id="q-3"></a>
+
+## 3) Boils at [Blank 1].
+
+- Options: N/A (open entry)
+
+> [!answer]- Answer
+> - Blanks and answers:
+>   - Blank 1: 100
+
+`
+	if got != want {
+		t.Errorf("RenderObsidian =\n%s\nwant\n%s", got, want)
+	}
+	if out, _, _ := Render(doc, RenderOptions{Flavor: "obsidian"}); !strings.HasPrefix(out, "---\n") {
+		t.Error("Render with Flavor obsidian wrote no frontmatter")
+	}
+	if _, _, err := Render(doc, RenderOptions{Flavor: "hugo"}); err == nil {
+		t.Error("Render accepted an unknown flavor")
+	}
+}
+
+func TestRenderStudyNotes(t *testing.T) {
+	doc := QuizDoc{Title: "WK01 Quiz", Questions: []Question{
+		{Number: 1, Title: "Dynamic programming 1", Text: "Which technique stores subproblem results?", Answers: []string{"Memoization"}},
+		{Number: 2, Title: "Question 2", Text: "Kruskal's algorithm is greedy.", Type: "true/false", Answers: []string{"False"}},
+		{Number: 3, Title: "Dynamic programming: knapsack (3)", Text: "Knapsack runs in [Blank 1] time.", OpenEntry: true, Blanks: []BlankAnswer{{Label: "Blank 1", Accepted: []string{"pseudo-polynomial"}}}},
+		{Number: 4, Title: "Q4", Text: "Match the sorts.", Tags: []string{"bloom:remember", "sorting"}, Matches: []MatchPair{{Prompt: "Merge", Answer: "O(n log n)"}}},
+		{Number: 5, Text: "Explain recursion.", Essay: true},
+	}}
+	want := `# WK01 Quiz
+
+_Study notes: each question is written out with its answer. Fill in the Why: lines in your own words._
+
+## Dynamic programming
+
+- Q1: Which technique stores subproblem results: **Memoization**
+  - Why:
+- Q3: Knapsack runs in **pseudo-polynomial** time.
+  - Why:
+
+## General
+
+- Q2: **Not true:** Kruskal's algorithm is greedy.
+  - Why:
+- Q5: Explain recursion. _(no answer key)_
+  - Why:
+
+## sorting
+
+- Q4: Match the sorts.
+  - Merge: **O(n log n)**
+  - Why:
+`
+	if got := RenderStudyNotes(doc); got != want {
+		t.Errorf("RenderStudyNotes =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	for _, lang := range Languages() {
+		l, ok := LabelsFor(lang)
+		if !ok {
+			t.Errorf("LabelsFor(%q) has no catalog", lang)
+		}
+		for _, k := range LabelKeys {
+			if lang != "en" && l[k] == "" {
+				t.Errorf("the %s catalog does not translate %q", lang, k)
+			}
+		}
+	}
+	if _, ok := LabelsFor("xx"); ok {
+		t.Error("LabelsFor(xx) found a catalog")
+	}
+	es, ok := LabelsFor("es-MX")
+	if !ok || es["Options"] != "Opciones" {
+		t.Fatalf("LabelsFor(es-MX) = %v, %v", es, ok)
+	}
+	es["Explanation"] = "Por qué"
+	if fresh, _ := LabelsFor("es"); fresh["Explanation"] != "Explicación" {
+		t.Error("changing a catalog changed the built-in one")
+	}
+
+	doc := QuizDoc{Title: "Semana 3", Labels: es, Details: []DocDetail{{"Navigation", "one question at a time"}}, Questions: []Question{
+		{Number: 1, ContentID: "q1", Text: "¿Cuál es un lenguaje compilado?", HasResult: true, Options: []Option{{Label: "Go", Correct: true}, {Label: "Python"}}, Answers: []string{"Go"}, Explanation: "Go se compila."},
+		{Number: 2, ContentID: "q2", Text: "¿Cuáles son primos?", HasResult: true, Multi: true, Answers: []string{"2", "3"}},
+		{Number: 3, ContentID: "q3", Text: "Se compila con [Blank 1].", HasResult: true, OpenEntry: true, Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "go build"}}},
+	}}
+	doc.Labels["Navigation"] = "Navegación"
+	md := RenderMarkdown(doc)
+	for _, want := range []string{"- Navegación: one question at a time\n", "- Opciones:\n  - Go (correcta)\n", "- Respuesta: Go\n", "- Por qué: Go se compila.\n", "- Respuestas correctas:\n", "Se compila con [Espacio 1].", "  - Espacio 1: go build\n"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("RenderMarkdown lacks %q:\n%s", want, md)
+		}
+	}
+	if doc.Questions[2].Text != "Se compila con [Blank 1]." || doc.Questions[2].Blanks[0].Label != "Blank 1" {
+		t.Errorf("rendering changed the document: %q, %q", doc.Questions[2].Text, doc.Questions[2].Blanks[0].Label)
+	}
+	txt := RenderText(doc, 0)
+	if !strings.Contains(txt, "   -> Respuesta: Go\n") || !strings.Contains(txt, "Respuestas correctas: 2; 3") || !strings.Contains(txt, "Espacio 1: go build") {
+		t.Errorf("RenderText is not translated:\n%s", txt)
+	}
+	page, err := RenderHTML(doc, HTMLOptions{Lang: "es"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<html lang="es">`, `<span class="answer-label">Respuesta:</span> Go`, `(correcta)`, `[Espacio 1]`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("RenderHTML lacks %q", want)
+		}
+	}
+	doc.Labels = nil
+	if md := RenderMarkdown(doc); !strings.Contains(md, "- Options:\n  - Go (correct)\n") || !strings.Contains(md, "- Navigation: one question at a time\n") {
+		t.Errorf("RenderMarkdown without Labels is not English:\n%s", md)
+	}
+}
+
+func TestFilterTypesAndNumbers(t *testing.T) {
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, Type: "multiple choice"},
+		{Number: 2, Type: "true/false"},
+		{Number: 3, Type: "fill in the blank"},
+		{Number: 4, Type: "multiple choice"},
+	}}
+	numbers := func(d QuizDoc) []int {
+		var out []int
+		for _, q := range d.Questions {
+			out = append(out, q.Number)
+		}
+		return out
+	}
+	d := doc
+	d.FilterTypes([]string{"Multiple-Choice", " true_false"})
+	if got := numbers(d); !reflect.DeepEqual(got, []int{1, 2, 4}) {
+		t.Errorf("FilterTypes kept %v", got)
+	}
+	d.FilterNumbers([]int{4, 2, 3})
+	if got := numbers(d); !reflect.DeepEqual(got, []int{2, 4}) {
+		t.Errorf("FilterNumbers kept %v", got)
+	}
+	d = doc
+	d.FilterTypes(nil)
+	d.FilterNumbers(nil)
+	if len(d.Questions) != 4 {
+		t.Errorf("empty filters kept %d questions", len(d.Questions))
+	}
+}
+
+func TestRenderAnki(t *testing.T) {
+	doc := QuizDoc{Title: "WK01 Quiz — Questions and Solutions", Questions: []Question{
+		{Number: 1, ContentID: "q-1", Text: "Pick the planet.", Answers: []string{"Mars"}, Options: []Option{{Label: "Moon"}, {Label: "Mars", Correct: true}}, Explanation: "Moons aren't planets.", Tags: []string{"solar system"}},
+		{Number: 2, ContentID: "q-2", Text: "Water boils at [Blank 1] <°C>.", OpenEntry: true, Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "100", Accepted: []string{"100", "one hundred"}}}},
+		{Number: 3, Text: "Explain.", Essay: true},
+		{Number: 4, Text: "Rate the course.", Ungraded: true},
+		{Number: 5, Text: "Pick one.", Options: []Option{{Label: "a"}, {Label: "b"}}},
+	}}
+	got, warnings := RenderAnki(doc)
+	want := "#separator:tab\n#html:true\n#guid column:1\n#tags column:4\n" +
+		"q-1\tPick the planet.\t<b>Answer:</b> Mars<br><ul><li>Moon</li><li><b>Mars</b></li></ul><br><i>Moons aren&#39;t planets.</i>\tWK01_Quiz solar_system\n" +
+		"q-2\tWater boils at [Blank 1] &lt;°C&gt;.\t<b>Blank 1:</b> 100 / one hundred\tWK01_Quiz\n"
+	if got != want {
+		t.Errorf("RenderAnki =\n%s\nwant\n%s", got, want)
+	}
+	wantWarnings := []string{
+		"question 3 skipped: essays have no answer key",
+		"question 4 skipped: ungraded questions have no answer key",
+		"question 5 skipped: the answer key is unknown",
+	}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("warnings = %q, want %q", warnings, wantWarnings)
+	}
+}
+
+func TestShowResponses(t *testing.T) {
+	zero, half, one := 0.0, 0.5, 1.0
+	doc := QuizDoc{ShowResponses: true, Questions: []Question{
+		{Number: 1, Text: "Pick the planet.", HasResult: true, Possible: 1, Earned: &one, Answers: []string{"Mars"}, Responses: []string{"Mars"}},
+		{Number: 2, Text: "Pick the primes.", HasResult: true, Possible: 1, Earned: &half, Answers: []string{"2", "7"}, Responses: []string{"2"}},
+		{Number: 3, Text: "Water boils at [Blank 1] degrees.", HasResult: true, OpenEntry: true, Possible: 1, Earned: &zero, Unanswered: true, Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "100"}}},
+		{Number: 4, Text: "Explain.", HasResult: true, Essay: true, Possible: 2, Earned: &one, Responses: []string{"Because."}},
+		{Number: 5, Text: "Rate the course.", HasResult: true, Ungraded: true, Earned: &zero, Responses: []string{"Good"}},
+	}}
+	got := RenderMarkdown(doc)
+	for _, want := range []string{
+		"## 1) Pick the planet.\n- Your answer: ✅ Mars\n- Correct answer: Mars\n- Score: 1 / 1\n",
+		"- Your answer: ❌ 2\n- Correct answer: 2; 7\n- Score: 0.5 / 1\n",
+		"- Response: left blank\n- Your answer: ❌ (left blank)\n- Correct answer: Blank 1: 100\n- Score: 0 / 1\n",
+		"## 4) Explain.\n- Your answer: ❌ Because.\n- Score: 1 / 2\n",
+		"## 5) Rate the course.\n- Your response: Good\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("RenderMarkdown is missing %q:\n%s", want, got)
+		}
+	}
+	doc.ShowResponses = false
+	if got := RenderMarkdown(doc); strings.Contains(got, "Your answer:") {
+		t.Errorf("RenderMarkdown without ShowResponses shows responses:\n%s", got)
+	}
+}
+
+func TestPDFEncode(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"Plain text", "Plain text", true},
+		{"café — “quoted” •", "caf\xe9 \x97 \x93quoted\x94 \x95", true},
+		{"a → b ≤ c", "a -> b <= c", true},
+		{"H₂O and x²", "H2O and x\xb2", true},
+		{"ก", "?", false},
+	}
+	for _, tt := range tests {
+		if got, ok := pdfEncode(tt.in); got != tt.want || ok != tt.ok {
+			t.Errorf("pdfEncode(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPDFParagraph(t *testing.T) {
+	w := &pdfWriter{}
+	w.newPage()
+	w.para(0, 10, "", pdfText{}.add(pdfBold, "Answer: ").add(pdfRegular, `x \(a^2\) (b)`))
+	want := `BT 56.69 775.20 Td /F1 10.00 Tf (Answer:) Tj /F0 10.00 Tf ( x) Tj /F2 10.00 Tf ( a^2) Tj /F0 10.00 Tf ( \(b\)) Tj ET` + "\n"
+	if got := w.pages[0].String(); got != want {
+		t.Errorf("para wrote\n%s\nwant\n%s", got, want)
+	}
+	w.para(0, 10, "", pdfText{}.add(pdfRegular, strings.Repeat("word ", 200)))
+	if lines := strings.Count(w.pages[0].String(), "BT"); lines != 1+11 {
+		t.Errorf("1000 characters of words took %d lines, want 11", lines-1)
+	}
+	w.para(0, 10, "", pdfText{}.add(pdfRegular, strings.Repeat("word ", 2000)))
+	if len(w.pages) != 3 {
+		t.Errorf("%d pages, want 3", len(w.pages))
+	}
+}
+
+func TestRenderPDF(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	img.Set(1, 0, color.NRGBA{}) // transparent, laid on white
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	doc := QuizDoc{Title: "WK01 Quiz", Questions: []Question{
+		{Number: 1, ContentID: "q-1", Text: "What is shown?", HasResult: true, BodyHTML: `<p><img src="` + dataURL + `"><img src="/courses/1/files/2/preview"></p>`, Answers: []string{"Red"}, Options: []Option{{Label: "Red", Correct: true}, {Label: "ก"}}},
+		{Number: 2, Text: "Pick one.", HasResult: true, Answers: []string{"A"}, Options: []Option{{Label: "A", Correct: true}}},
+	}}
+	out, warnings := RenderPDF(doc, PDFOptions{PageBreaks: true})
+	s := string(out)
+	for _, want := range []string{"%PDF-1.4\n", "/Count 2 >>", "/Subtype /Image /Width 2 /Height 1", "/BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding", "/Title <FEFF0057004B003000310020005100750069007A>", "/Dests << /q-1 [", "%%EOF\n"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("RenderPDF output is missing %q", want)
+		}
+	}
+	start := strings.Index(s, "/Subtype /Image")
+	start = strings.Index(s[start:], "stream\n") + start + len("stream\n")
+	zr, err := zlib.NewReader(strings.NewReader(s[start:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixels, _ := io.ReadAll(zr)
+	if want := []byte{255, 0, 0, 255, 255, 255}; !bytes.Equal(pixels, want) {
+		t.Errorf("image pixels = %v, want %v", pixels, want)
+	}
+	wantWarnings := []string{
+		"question 1: image /courses/1/files/2/preview left out: relative or unsupported URL",
+		"some characters have no glyph in the standard PDF fonts and were replaced with ?",
+	}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("warnings = %q, want %q", warnings, wantWarnings)
+	}
+
+	_, warnings = RenderPDF(doc, PDFOptions{LinkBase: "https://school.instructure.com", FetchImage: func(url string) ([]byte, error) {
+		if url != "https://school.instructure.com/courses/1/files/2/preview" {
+			t.Errorf("fetched %s", url)
+		}
+		return buf.Bytes(), nil
+	}})
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q, want only the missing glyph", warnings)
+	}
+}
+
+func TestRenderDocx(t *testing.T) {
+	doc := QuizDoc{Title: "WK01 Quiz & Review", Questions: []Question{
+		{Number: 1, ContentID: "q-1", Text: "What is shown?", HasResult: true, BodyHTML: `<p><img src="/courses/1/files/2/preview"></p>`, Answers: []string{"Red"}, Options: []Option{{Label: "Red", Correct: true}, {Label: "Blue <dark>"}}},
+		{Number: 2, Text: "Name it.", HasResult: true, OpenEntry: true, Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "ก"}}},
+	}}
+	out, warnings := RenderDocx(doc, DocxOptions{PageBreaks: true})
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		if err := xml.Unmarshal(b, new(struct{})); err != nil {
+			t.Errorf("%s is not well-formed: %v", f.Name, err)
+		}
+		parts[f.Name] = string(b)
+	}
+	if zr.File[0].Name != "[Content_Types].xml" {
+		t.Errorf("first part = %s, want [Content_Types].xml", zr.File[0].Name)
+	}
+	body := parts["word/document.xml"]
+	for _, want := range []string{
+		`<w:pStyle w:val="Title"/></w:pPr><w:r><w:t xml:space="preserve">WK01 Quiz &amp; Review</w:t>`,
+		`<w:pStyle w:val="Heading2"/></w:pPr><w:bookmarkStart w:id="1" w:name="q_1"/><w:r><w:t xml:space="preserve">1) What is shown?</w:t></w:r><w:bookmarkEnd w:id="1"/></w:p>`,
+		`<w:pStyle w:val="Heading2"/></w:pPr><w:r><w:t xml:space="preserve">2) Name it.</w:t>`,
+		`<w:r><w:rPr><w:b/></w:rPr><w:t xml:space="preserve">Red</w:t></w:r><w:r><w:rPr><w:i/></w:rPr><w:t xml:space="preserve"> (correct)</w:t>`,
+		`<w:t xml:space="preserve">Blue &lt;dark&gt;</w:t>`,
+		`<w:br w:type="page"/>`,
+		`<w:t xml:space="preserve">ก</w:t>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("document.xml is missing %q", want)
+		}
+	}
+	if !strings.Contains(parts["docProps/core.xml"], "<dc:title>WK01 Quiz &amp; Review</dc:title>") {
+		t.Errorf("core.xml = %s", parts["docProps/core.xml"])
+	}
+	if want := []string{"question 1: 1 image(s) left out; docx output does not embed images"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %q, want %q", warnings, want)
+	}
+	if again, _ := RenderDocx(doc, DocxOptions{PageBreaks: true}); !bytes.Equal(again, out) {
+		t.Error("rendering the same document twice gave different bytes")
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	earned := 0.5
+	doc := QuizDoc{Title: "WK01 Quiz", Questions: []Question{
+		{Number: 1, ItemID: "11", ContentID: "q-1", Type: "multiple choice", Text: "Pick the planet.", Possible: 1, Earned: &earned,
+			Options: []Option{{Label: "Moon", Selected: true}, {Label: "Mars", Correct: true}}, Answers: []string{"Mars"}, Responses: []string{"Moon"}},
+		{Number: 2, Type: "fill in the blank", Text: "Water boils at [Blank 1] degrees.", Possible: 2,
+			Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "100", Accepted: []string{"100", "one hundred"}}}, Unanswered: true},
+	}}
+	got, err := RenderJSON(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "title": "WK01 Quiz",
+  "questions": [
+    {
+      "number": 1,
+      "id": "11",
+      "content_id": "q-1",
+      "type": "multiple choice",
+      "text": "Pick the planet.",
+      "points_possible": 1,
+      "points_earned": 0.5,
+      "options": [
+        {
+          "label": "Moon",
+          "correct": false,
+          "selected": true
+        },
+        {
+          "label": "Mars",
+          "correct": true
+        }
+      ],
+      "answers": [
+        "Mars"
+      ],
+      "response": [
+        "Moon"
+      ]
+    },
+    {
+      "number": 2,
+      "type": "fill in the blank",
+      "text": "Water boils at [Blank 1] degrees.",
+      "points_possible": 2,
+      "blanks": [
+        {
+          "label": "Blank 1",
+          "answer": "100",
+          "accepted": [
+            "100",
+            "one hundred"
+          ]
+        }
+      ],
+      "answers": [],
+      "response": [],
+      "unanswered": true
+    }
+  ]
+}
+`
+	if got != want {
+		t.Errorf("RenderJSON =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSpeechScript(t *testing.T) {
+	doc := QuizDoc{Title: "WK01 Quiz — Questions and Solutions", Questions: []Question{
+		{Number: 1, Text: "Pick the planet", Answers: []string{"Mars"}, Options: []Option{{Label: "Moon"}, {Label: "Mars", Correct: true}},
+			Explanation: "Iron oxide makes it red."},
+		{Number: 2, Text: "Water boils at [Blank 1] degrees and freezes at [Blank 2].", OpenEntry: true,
+			Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "100"}, {Label: "Blank 2", Answer: "0"}}},
+		{Number: 3, Text: "Pick the primes?", Multi: true, Answers: []string{"2", "3", "7"}},
+	}}
+	want := "WK01 Quiz — Questions and Solutions.\n\n" +
+		"Question 1. Pick the planet.\nA: Moon.\nB: Mars.\nAnswer: Mars.\nIron oxide makes it red.\n\n" +
+		"Question 2. Water boils at blank degrees and freezes at blank.\nAnswer: 100, then 0.\n\n" +
+		"Question 3. Pick the primes?\nCorrect answers: 2, 3 and 7.\n\n"
+	if got := SpeechScript(doc); got != want {
+		t.Errorf("speechScript =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMatchingQuestion(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[{"position": 1, "points_possible": 2, "item": {"id": "m1", "item_body": "<p>Match the capitals.</p>",
+		"interaction_type": {"slug": "matching"},
+		"interaction_data": {"questions": [{"id": "b", "item_body": "Germany", "position": 2}, {"id": "a", "item_body": "France", "position": 1}],
+			"answers": ["Paris", "Berlin", "Madrid"]}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []ResultItem
+	if err := json.Unmarshal([]byte(`[{"item_id": "m1", "score": 1, "points_possible": 2, "scored_data": {"value": {
+		"a": {"user_response": "Paris", "correct": true},
+		"b": {"user_response": "Madrid", "correct": false, "correct_answer": "Berlin"}}}}]`), &results); err != nil {
+		t.Fatal(err)
+	}
+	q := BuildQuizDoc(quiz, results, "T").Questions[0]
+	want := []MatchPair{{"France", "Paris", "Paris"}, {"Germany", "Berlin", "Madrid"}}
+	if !reflect.DeepEqual(q.Matches, want) {
+		t.Errorf("Matches = %+v, want %+v", q.Matches, want)
+	}
+	if d := q.Distractors(); !reflect.DeepEqual(d, []string{"Madrid"}) {
+		t.Errorf("Distractors = %v", d)
+	}
+	md := RenderMarkdown(BuildQuizDoc(quiz, results, "T"))
+	for _, line := range []string{"| France | Paris | Paris |", "| Germany | Berlin | Madrid (incorrect) |", "- Distractors: Madrid"} {
+		if !strings.Contains(md, line) {
+			t.Errorf("Markdown lacks %q:\n%s", line, md)
+		}
+	}
+	if txt := RenderText(BuildQuizDoc(quiz, results, "T"), 0); !strings.Contains(txt, "Germany → Berlin") {
+		t.Errorf("text output lacks the pairs:\n%s", txt)
+	}
+	practice := RenderMarkdown(BuildPracticeDoc(quiz, "T"))
+	if strings.Contains(practice, "Berlin |") || !strings.Contains(practice, "- Answers to choose from: Paris, Berlin, Madrid") {
+		t.Errorf("practice sheet shows the key or lacks the answers:\n%s", practice)
+	}
+}
+
+func TestCategorizationQuestion(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[{"position": 1, "points_possible": 2, "item": {"id": "c1", "item_body": "<p>Sort the foods.</p>",
+		"interaction_type": {"slug": "categorization"},
+		"interaction_data": {"categories": {"v": {"id": "v", "item_body": "Vegetable"}, "f": {"id": "f", "item_body": "Fruit"}},
+			"category_order": ["f", "v"],
+			"distractors": {"a": {"item_body": "Apple", "position": 1}, "p": {"item_body": "Pear", "position": 2}, "c": {"item_body": "Carrot", "position": 3}, "r": {"item_body": "Rock", "position": 4}}},
+		"scoring_data": {"value": [{"id": "f", "value": ["a", "p"]}, {"id": "v", "value": ["c"]}]}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []ResultItem
+	if err := json.Unmarshal([]byte(`[{"item_id": "c1", "score": 1, "points_possible": 2, "scored_data": {"value": {
+		"f": {"user_response": ["a", "c"]}, "v": ["p"]}}}]`), &results); err != nil {
+		t.Fatal(err)
+	}
+	doc := BuildQuizDoc(quiz, results, "T")
+	want := []Category{{"Fruit", []string{"Apple", "Pear"}, []string{"Apple", "Carrot"}}, {"Vegetable", []string{"Carrot"}, []string{"Pear"}}}
+	if !reflect.DeepEqual(doc.Questions[0].Categories, want) {
+		t.Errorf("Categories = %+v, want %+v", doc.Questions[0].Categories, want)
+	}
+	md := RenderMarkdown(doc)
+	for _, line := range []string{"| Fruit | Apple, Pear | Apple, Carrot (incorrect) |", "| Vegetable | Carrot | Pear (incorrect) |", "- Distractors: Rock"} {
+		if !strings.Contains(md, line) {
+			t.Errorf("Markdown lacks %q:\n%s", line, md)
+		}
+	}
+	if txt := RenderText(doc, 0); !strings.Contains(txt, "Fruit: Apple, Pear") {
+		t.Errorf("text output lacks the categories:\n%s", txt)
+	}
+	practice := RenderMarkdown(BuildPracticeDoc(quiz, "T"))
+	if strings.Contains(practice, "Apple, Pear |") || !strings.Contains(practice, "- Items to sort: Apple, Pear, Carrot, Rock") {
+		t.Errorf("practice sheet shows the key or lacks the items:\n%s", practice)
+	}
+}
+
+func TestStimulus(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[
+		{"id": "10", "position": 1, "entry_type": "Stimulus", "item": {"title": "The Tortoise", "body": "<p>Slow and steady.</p><p>It won.</p>"}},
+		{"id": "11", "position": 2, "entry_type": "Item", "stimulus_quiz_entry_id": "10", "item": {"id": "a", "item_body": "Who won?", "interaction_type": {"slug": "essay"}}},
+		{"id": "12", "position": 3, "entry_type": "Item", "stimulus_quiz_entry_id": "10", "item": {"id": "b", "item_body": "Why?", "interaction_type": {"slug": "essay"}}},
+		{"id": "13", "position": 4, "entry_type": "Item", "item": {"id": "c", "item_body": "Unrelated.", "interaction_type": {"slug": "essay"}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quiz) != 3 || quiz[0].Stimulus == nil || quiz[1].Stimulus != quiz[0].Stimulus || quiz[2].Stimulus != nil {
+		t.Fatalf("stimulus not attached: %+v", quiz)
+	}
+	doc := BuildQuizDoc(quiz, nil, "T")
+	if doc.Questions[0].Number != 1 || doc.Questions[0].Stimulus == doc.Questions[1].Stimulus {
+		t.Errorf("questions should be numbered from 1 and own their stimulus: %+v", doc.Questions[:2])
+	}
+	md := RenderMarkdown(doc)
+	want := "## Stimulus: The Tortoise\n\n> Slow and steady.\n>\n> It won.\n\n<a id="
+	if strings.Count(md, "## Stimulus") != 1 || !strings.Contains(md, want) || !strings.Contains(md, "---\n\n<a id=") {
+		t.Errorf("Markdown should show the stimulus once before its questions:\n%s", md)
+	}
+	page, err := RenderHTML(doc, HTMLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(page, `<section class="group stimulus">`) != 1 || !strings.Contains(page, "<p>It won.</p>") {
+		t.Errorf("HTML lacks the stimulus section:\n%s", page)
+	}
+}
+
+func TestItemFeedback(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[
+		{"position": 1, "points_possible": 1, "item": {"id": "a", "item_body": "Pick the planet.", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": [{"id": "c1", "item_body": "Mars"}, {"id": "c2", "item_body": "Moon"}]},
+			"feedback": {"neutral": "<p>Planets orbit the Sun.</p>", "incorrect": "<p>The Moon orbits <b>Earth</b>.</p>"}}},
+		{"position": 2, "points_possible": 1, "item": {"id": "b", "item_body": "Pick the star.", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": [{"id": "c1", "item_body": "Sun"}, {"id": "c2", "item_body": "Mars"}]},
+			"feedback": {"correct": "Right: the Sun.", "incorrect": "Mars is a planet."}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := DecodeResults([]byte(`[
+		{"item_id": "a", "score": 0, "points_possible": 1, "scored_data": {"value": {"c1": {"correct": true}, "c2": {"user_responded": true}}}},
+		{"item_id": "b", "score": 1, "points_possible": 1, "scored_data": {"value": {"c1": {"correct": true, "user_responded": true}}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := BuildQuizDoc(quiz, results, "T")
+	if got := doc.Questions[0].IncorrectFeedback; got != "The Moon orbits Earth." {
+		t.Errorf("IncorrectFeedback = %q", got)
+	}
+	ApplyExplanations(&doc, ExplainConfig{Sources: []string{"incorrect", "general", "correct"}})
+	// The missed question explains the wrong answer; the right one skips the incorrect feedback.
+	for i, want := range []string{"The Moon orbits Earth.", "Right: the Sun."} {
+		if got := doc.Questions[i].Explanation; got != want {
+			t.Errorf("question %d explanation = %q, want %q", i+1, got, want)
+		}
+	}
+	if md := RenderMarkdown(doc); !strings.Contains(md, "- Explanation: The Moon orbits Earth.\n") {
+		t.Errorf("Markdown lacks the explanation:\n%s", md)
+	}
+
+	classic, _, err := ParseClassicQuestions([]byte(`[{"id": 1, "question_type": "true_false_question", "question_text": "Sky is blue.",
+		"answers": [{"id": 1, "text": "True", "weight": 100}, {"id": 2, "text": "False", "weight": 0}],
+		"incorrect_comments": "<p>Look up.</p>"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := BuildClassicDoc(classic, "T").Questions[0].IncorrectFeedback; got != "Look up." {
+		t.Errorf("Classic IncorrectFeedback = %q, want %q", got, "Look up.")
+	}
+}
+
+func TestHotSpotQuestion(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[{"position": 1, "points_possible": 1, "item": {"id": "h1", "item_body": "<p>Click the heart.</p>",
+		"interaction_type": {"slug": "hot-spot"},
+		"interaction_data": {"image_url": "https://example.com/body.png"}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, value string
+		wantAnswers []string
+		wantClick   []string
+	}{
+		{"bare click", `{"x": 0.4, "y": 0.5}`, nil, []string{"(0.4, 0.5)"}},
+		{"entry", `{"user_response": {"x": 0.3, "y": 0.35}, "correct_answer": {"type": "square", "coordinates": [{"x": 0.25, "y": 0.3}, {"x": 0.5, "y": 0.6}]}}`,
+			[]string{"square (0.25, 0.3), (0.5, 0.6)"}, []string{"(0.3, 0.35)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var results []ResultItem
+			if err := json.Unmarshal([]byte(`[{"item_id": "h1", "score": 1, "points_possible": 1, "scored_data": {"value": `+tt.value+`}}]`), &results); err != nil {
+				t.Fatal(err)
+			}
+			q := BuildQuizDoc(quiz, results, "T").Questions[0]
+			if !reflect.DeepEqual(q.Answers, tt.wantAnswers) || !reflect.DeepEqual(q.Responses, tt.wantClick) {
+				t.Errorf("Answers = %v, Responses = %v, want %v, %v", q.Answers, q.Responses, tt.wantAnswers, tt.wantClick)
+			}
+			if len(q.Media) != 1 || q.Media[0].URL != "https://example.com/body.png" {
+				t.Errorf("Media = %+v", q.Media)
+			}
+		})
+	}
+}
+
+func TestNumericQuestion(t *testing.T) {
+	tests := []struct {
+		name, scoring, value string
+		wantAnswers          []string
+		wantResponse         []string
+	}{
+		{"exact", `{"value": [{"id": "a", "type": "exactResponse", "value": "42"}]}`, `"42"`, []string{"42"}, []string{"42"}},
+		{"margin", `{"value": [{"type": "marginOfError", "value": 9.81, "margin": "0.05", "margin_type": "absolute"}]}`, `{"user_response": "9.8"}`, []string{"9.81 ± 0.05"}, []string{"9.8"}},
+		{"percent margin", `{"value": [{"type": "marginOfError", "value": "100", "margin": "5", "margin_type": "percent"}]}`, `103`, []string{"100 ± 5%"}, []string{"103"}},
+		{"range", `{"value": [{"type": "withinARange", "start": "1", "end": "2.5"}]}`, `{"value": 2}`, []string{"between 1 and 2.5"}, []string{"2"}},
+		{"precision", `{"value": [{"type": "preciseResponse", "value": "3.14", "precision": "2", "precision_type": "decimals"}, {"type": "preciseResponse", "value": "3.142", "precision": 4, "precision_type": "significantDigits"}]}`, `null`,
+			[]string{"3.14 (2 decimal places)", "3.142 (4 significant digits)"}, nil},
+		{"no key", `null`, `"7"`, nil, []string{"7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quiz, err := DecodeQuizItems([]byte(`[{"position": 1, "points_possible": 1, "item": {"id": "n1", "item_body": "<p>g?</p>",
+				"interaction_type": {"slug": "numeric"}, "user_response_type": "Text", "scoring_data": ` + tt.scoring + `}}]`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var results []ResultItem
+			if err := json.Unmarshal([]byte(`[{"item_id": "n1", "score": 1, "points_possible": 1, "scored_data": {"value": `+tt.value+`}}]`), &results); err != nil {
+				t.Fatal(err)
+			}
+			q := BuildQuizDoc(quiz, results, "T").Questions[0]
+			if q.Essay || !reflect.DeepEqual(q.Answers, tt.wantAnswers) || !reflect.DeepEqual(q.Responses, tt.wantResponse) {
+				t.Errorf("Answers = %v, Responses = %v, Essay = %v; want %v, %v", q.Answers, q.Responses, q.Essay, tt.wantAnswers, tt.wantResponse)
+			}
+			if len(tt.wantAnswers) == 1 && !strings.Contains(RenderMarkdown(QuizDoc{Questions: []Question{q}}), "- Answer: "+tt.wantAnswers[0]+"\n") {
+				t.Errorf("markdown lacks the answer:\n%s", RenderMarkdown(QuizDoc{Questions: []Question{q}}))
+			}
+		})
+	}
+}
+
+func TestFormulaQuestion(t *testing.T) {
+	scoring := `{"value": {"formula": "x * y", "numeric": {"type": "marginOfError", "margin": "0.5", "margin_type": "absolute"},
+		"generated_solutions": [{"inputs": [{"name": "x", "value": "2"}, {"name": "y", "value": "5"}], "output": "10"}]}}`
+	tests := []struct {
+		name, value        string
+		wantGiven          string
+		wantAnswers        []string
+		wantResponse       []string
+		wantMarkdownAnswer string
+	}{
+		{"student's values", `{"inputs": [{"name": "x", "value": 3}, {"name": "y", "value": "7"}], "output": 21, "user_response": "21"}`,
+			"x=3, y=7", []string{"21 ± 0.5"}, []string{"21"}, "- Given x=3, y=7 → Answer: 21 ± 0.5\n"},
+		{"generated example", `{"user_response": "9"}`, "x=2, y=5", []string{"10 ± 0.5"}, []string{"9"}, "- Given x=2, y=5 → Answer: 10 ± 0.5\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quiz, err := DecodeQuizItems([]byte(`[{"position": 1, "points_possible": 1, "item": {"id": "f1", "item_body": "<p>Multiply [x] by [y].</p>",
+				"interaction_type": {"slug": "formula"}, "scoring_data": ` + scoring + `}}]`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var results []ResultItem
+			if err := json.Unmarshal([]byte(`[{"item_id": "f1", "score": 1, "points_possible": 1, "scored_data": {"value": `+tt.value+`}}]`), &results); err != nil {
+				t.Fatal(err)
+			}
+			doc := BuildQuizDoc(quiz, results, "T")
+			q := doc.Questions[0]
+			if q.Formula != "x * y" || q.Given != tt.wantGiven || !reflect.DeepEqual(q.Answers, tt.wantAnswers) || !reflect.DeepEqual(q.Responses, tt.wantResponse) {
+				t.Errorf("Formula = %q, Given = %q, Answers = %v, Responses = %v", q.Formula, q.Given, q.Answers, q.Responses)
+			}
+			if q.KeyConfidence != KeyConfirmed {
+				t.Errorf("KeyConfidence = %q, want %q", q.KeyConfidence, KeyConfirmed)
+			}
+			md := RenderMarkdown(doc)
+			if !strings.Contains(md, "- Formula: x * y\n") || !strings.Contains(md, tt.wantMarkdownAnswer) {
+				t.Errorf("markdown:\n%s", md)
+			}
+		})
+	}
+
+	var classic []ClassicQuestion
+	err := json.Unmarshal([]byte(`[{"id": 5, "question_type": "calculated_question", "question_text": "<p>Multiply [x] by [y].</p>",
+		"formulas": [{"formula": "x*y"}], "answer_tolerance": "5%",
+		"answers": [{"weight": 100, "variables": [{"name": "x", "value": 3}, {"name": "y", "value": "7"}], "answer": 21}]}]`), &classic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := BuildClassicDoc(classic, "T").Questions[0]
+	if q.Formula != "x*y" || q.Given != "x=3, y=7" || !reflect.DeepEqual(q.Answers, []string{"21 ± 5%"}) || q.KeyConfidence != KeyConfirmed {
+		t.Errorf("classic: Formula = %q, Given = %q, Answers = %v, KeyConfidence = %q", q.Formula, q.Given, q.Answers, q.KeyConfidence)
+	}
+}
+
+func TestEssaySubmission(t *testing.T) {
+	tests := []struct {
+		name, slug, value string
+		wantText          []string
+		wantFiles         []string
+	}{
+		{"bare html", "essay", `"<p>First point.</p><p>Second &amp; last.</p>"`, []string{"First point.", "Second & last."}, nil},
+		{"user_response", "essay", `{"user_response": "Just one line"}`, []string{"Just one line"}, nil},
+		{"attachments", "file-upload", `[{"id": "1", "display_name": "report.pdf"}, {"filename": "data.csv"}]`, nil, []string{"report.pdf", "data.csv"}},
+		{"nested attachments", "file-upload", `{"attachments": [{"name": "scan.png"}]}`, nil, []string{"scan.png"}},
+		{"nothing", "essay", `null`, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quiz, err := DecodeQuizItems([]byte(`[{"position": 1, "points_possible": 10, "item": {"id": "e1", "item_body": "<p>Discuss.</p>",
+				"interaction_type": {"slug": "` + tt.slug + `"}}}]`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var results []ResultItem
+			if err := json.Unmarshal([]byte(`[{"item_id": "e1", "score": 8, "points_possible": 10, "scored_data": {"value": `+tt.value+`}}]`), &results); err != nil {
+				t.Fatal(err)
+			}
+			q := BuildQuizDoc(quiz, results, "T").Questions[0]
+			if !q.Essay || !reflect.DeepEqual(q.Submission, tt.wantText) || !reflect.DeepEqual(q.Files, tt.wantFiles) {
+				t.Errorf("Essay = %v, Submission = %q, Files = %q; want %q, %q", q.Essay, q.Submission, q.Files, tt.wantText, tt.wantFiles)
+			}
+		})
+	}
+
+	earned := 8.0
+	q := Question{Number: 1, Text: "Discuss.", HasResult: true, Essay: true, Possible: 10, Earned: &earned,
+		Submission: []string{"First point.", "Second point."}, Files: []string{"report.pdf"}, Comments: []Comment{{Text: "Good structure."}}}
+	md := RenderMarkdown(QuizDoc{Title: "T", Questions: []Question{q}})
+	want := "- Options: N/A (essay)\n\n- Submission:\n  > First point.\n  >\n  > Second point.\n- Files: report.pdf\n- Score: 8 / 10\n\n"
+	if !strings.Contains(md, want) || !strings.Contains(md, "Good structure.") {
+		t.Errorf("markdown:\n%s\nwant it to contain:\n%s", md, want)
+	}
+	md = RenderMarkdown(QuizDoc{Title: "T", Questions: []Question{q}, ShowResponses: true})
+	if !strings.Contains(md, "- Your answer: ❌ 4 words; report.pdf\n") || strings.Count(md, "- Score: 8 / 10") != 1 {
+		t.Errorf("markdown with responses:\n%s", md)
+	}
+}
+
+func TestOrderingQuestion(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[{"position": 1, "points_possible": 1, "item": {"id": "o1", "item_body": "<p>Put the steps in order.</p>",
+		"interaction_type": {"slug": "ordering"},
+		"interaction_data": {"choices": [{"id": "t", "item_body": "Test", "position": 1}, {"id": "p", "item_body": "Plan", "position": 2}, {"id": "b", "item_body": "Build", "position": 3}]}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		value     string
+		wantOrder []string
+		wantYours []string
+	}{
+		{"rows", `[{"id": "1", "user_responded": "b", "value": "p"}, {"id": "2", "user_responded": "p", "value": "b"}, {"id": "3", "user_responded": "t", "value": "t"}]`,
+			[]string{"Plan", "Build", "Test"}, []string{"Build", "Plan", "Test"}},
+		{"bare ids", `["p", "b", "t"]`, nil, []string{"Plan", "Build", "Test"}},
+		{"unknown id", `["p", "x", "t"]`, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var results []ResultItem
+			if err := json.Unmarshal([]byte(`[{"item_id": "o1", "score": 0, "points_possible": 1, "scored_data": {"value": `+tt.value+`}}]`), &results); err != nil {
+				t.Fatal(err)
+			}
+			q := BuildQuizDoc(quiz, results, "T").Questions[0]
+			if !reflect.DeepEqual(q.Order, tt.wantOrder) || !reflect.DeepEqual(q.ResponseOrder, tt.wantYours) {
+				t.Errorf("Order = %v, ResponseOrder = %v, want %v, %v", q.Order, q.ResponseOrder, tt.wantOrder, tt.wantYours)
+			}
+		})
+	}
+
+	var results []ResultItem
+	if err := json.Unmarshal([]byte(`[{"item_id": "o1", "score": 0, "points_possible": 1, "scored_data": {"value": `+tests[0].value+`}}]`), &results); err != nil {
+		t.Fatal(err)
+	}
+	md := RenderMarkdown(BuildQuizDoc(quiz, results, "T"))
+	for _, want := range []string{"- Correct order:\n  1. Plan\n  2. Build\n  3. Test\n", "- Your order:\n  1. Build\n  2. Plan\n"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown lacks %q:\n%s", want, md)
+		}
+	}
+	practice := RenderMarkdown(BuildPracticeDoc(quiz, "T"))
+	if strings.Contains(practice, "Correct order") || !strings.Contains(practice, "Plan") {
+		t.Errorf("practice sheet shows the order or lacks the choices:\n%s", practice)
+	}
+}
+
+func TestMultipleBlanks(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[{"position": 1, "points_possible": 2, "item": {"id": "b1",
+		"item_body": "<p>Water boils at <span id=\"blank_x\"></span> and freezes at <span id=\"blank_y\"></span> degrees.</p>",
+		"interaction_type": {"slug": "rich-fill-blank"},
+		"interaction_data": {"blanks": [{"id": "x", "answer_type": "openEntry"}, {"id": "y", "answer_type": "openEntry"}]}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []ResultItem
+	if err := json.Unmarshal([]byte(`[{"item_id": "b1", "score": 1, "points_possible": 2, "scored_data": {"value": {
+		"y": {"user_response": "32", "correct_answer": "0"},
+		"x": {"user_response": "100", "correct_answer": "100"}}}}]`), &results); err != nil {
+		t.Fatal(err)
+	}
+	q := BuildQuizDoc(quiz, results, "T").Questions[0]
+	if q.Text != "Water boils at [Blank 1] and freezes at [Blank 2] degrees." {
+		t.Errorf("Text = %q", q.Text)
+	}
+	var got []string
+	for _, b := range q.Blanks {
+		got = append(got, b.Label+"="+b.Answer)
+	}
+	if want := []string{"Blank 1=100", "Blank 2=0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("blanks = %v, want %v", got, want)
+	}
+	if want := []string{"Blank 1: 100", "Blank 2: 32"}; !reflect.DeepEqual(q.Responses, want) {
+		t.Errorf("Responses = %v, want %v", q.Responses, want)
+	}
+}
+
+func TestAttemptLabels(t *testing.T) {
+	names := []string{"a", "b"}
+	tests := []struct {
+		name     string
+		attempts [][]ResultItem
+		want     []string
+	}{
+		{"numbered", [][]ResultItem{{{Attempt: 1}}, {{Attempt: 3}}}, []string{"Attempt 1", "Attempt 3"}},
+		{"regrade of one attempt", [][]ResultItem{{{Attempt: 1}}, {{Attempt: 1}}}, names},
+		{"no numbers", [][]ResultItem{{{}}, {{}}}, names},
+		{"empty file", [][]ResultItem{{{Attempt: 1}}, nil}, names},
+	}
+	for _, tt := range tests {
+		if got := attemptLabels(tt.attempts, names); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: attemptLabels = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestObjectKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`{"b": 1, "a": {"x": [1, 2]}, "c": null}`, []string{"b", "a", "c"}},
+		{`{}`, nil},
+		{`["a", "b"]`, nil},
+		{`"a"`, nil},
+		{``, nil},
+		{`{"a": 1,`, nil},
+	}
+	for _, tt := range tests {
+		if got := objectKeys(json.RawMessage(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("objectKeys(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMarkdownV1OmitsNewerSections(t *testing.T) {
+	doc := QuizDoc{
+		Title:   "WK12 Quiz — Questions and Solutions",
+		Details: []DocDetail{{Label: "Time limit", Value: "60 minutes"}},
+		Questions: []Question{{
+			Number:      1,
+			Text:        "Soak testing is used to:",
+			HasResult:   true,
+			Bank:        "Performance",
+			Options:     []Option{{Label: "Find leaks", Correct: true, Feedback: "Yes."}, {Label: "Test latency"}},
+			Answers:     []string{"Find leaks"},
+			Explanation: "Soak tests run for hours.",
+		}},
+	}
+	want := "# WK12 Quiz — Questions and Solutions\n\n" +
+		"## 1) Soak testing is used to:\n" +
+		"- Options:\n  - Find leaks (correct)\n  - Test latency\n\n" +
+		"- Answer: Find leaks\n\n"
+	if got := RenderMarkdownV1(doc); got != want {
+		t.Errorf("renderMarkdownV1:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBuildClassicDoc(t *testing.T) {
+	var questions []ClassicQuestion
+	err := json.Unmarshal([]byte(`[
+		{"id": 11, "position": 2, "question_type": "multiple_choice_question", "question_text": "<p>Pick one</p>",
+		 "answers": [{"id": 1, "text": "A", "weight": 0}, {"id": 2, "html": "<b>B</b>", "weight": "100"}]},
+		{"id": 10, "position": 1, "question_type": "fill_in_multiple_blanks_question", "question_text": "<p>[x] and [y]</p>",
+		 "answers": [{"text": "1", "weight": 100, "blank_id": "x"}, {"text": "one", "weight": 100, "blank_id": "x"}, {"text": "2", "weight": 100, "blank_id": "y"}]},
+		{"id": 12, "position": 3, "question_type": "text_only_question", "question_text": "<p>Section B</p>"},
+		{"id": 13, "position": 4, "question_type": "numerical_question", "question_text": "<p>Range</p>",
+		 "answers": [{"numerical_answer_type": "range_answer", "start": 1, "end": 2.5, "weight": 100}]}
+	]`), &questions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := BuildClassicDoc(questions, "WK03")
+	if len(doc.Questions) != 3 {
+		t.Fatalf("got %d questions, want 3 (text-only skipped)", len(doc.Questions))
+	}
+	blanks := doc.Questions[0]
+	if blanks.ItemID != "10" || blanks.Text != "[Blank 1] and [Blank 2]" {
+		t.Errorf("blank question = %q %q", blanks.ItemID, blanks.Text)
+	}
+	if got := blanks.Blanks; len(got) != 2 || got[0].Answer != "1" || strings.Join(got[0].Accepted, ",") != "1,one" || got[1].Answer != "2" || got[1].Accepted != nil {
+		t.Errorf("blanks = %+v", got)
+	}
+	choice := doc.Questions[1]
+	if choice.Number != 2 || choice.Multi || strings.Join(choice.Answers, ",") != "B" || choice.Options[0].Correct {
+		t.Errorf("choice question = %+v", choice)
+	}
+	if got := doc.Questions[2].Blanks[0].Answer; got != "between 1 and 2.5" {
+		t.Errorf("range answer = %q", got)
+	}
+}
+
+func TestBuildClassicSubmissionDoc(t *testing.T) {
+	var questions []ClassicQuestion
+	err := json.Unmarshal([]byte(`[
+		{"id": 11, "position": 1, "question_type": "multiple_choice_question", "question_text": "<p>Pick one</p>", "points_possible": 1,
+		 "answers": [{"id": 1, "text": "A", "weight": 0}, {"id": 2, "text": "B", "weight": 100}]},
+		{"id": 12, "position": 2, "question_type": "multiple_dropdowns_question", "question_text": "<p>[x] is red</p>", "points_possible": 1,
+		 "answers": [{"id": 5, "text": "Mars", "weight": 100, "blank_id": "x"}, {"id": 6, "text": "Venus", "weight": 0, "blank_id": "x"}]},
+		{"id": 13, "position": 3, "question_type": "essay_question", "question_text": "<p>Why?</p>", "points_possible": 2}
+	]`), &questions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, ok, err := ParseClassicSubmissions([]byte(`{"id": 9, "attempt": 2, "submission_history": [
+		{"attempt": 2, "score": 2, "submitted_at": "2024-03-02T10:00:00Z", "submission_data": [
+			{"question_id": 11, "correct": true, "points": 1, "answer_id": 2},
+			{"question_id": 12, "correct": true, "points": 1, "answer_for_x": "5"},
+			{"question_id": 13, "correct": "undefined", "points": 0, "text": "<p>Because.</p>"}]},
+		{"attempt": 1, "score": 0, "submitted_at": "2024-03-01T10:00:00Z", "submission_data": [
+			{"question_id": 11, "correct": false, "points": 0, "answer_id": 1},
+			{"question_id": 12, "correct": false, "points": 0, "answer_for_x": null}]}]}`))
+	if err != nil || !ok || len(subs) != 2 || subs[0].Attempt != 1 {
+		t.Fatalf("ParseClassicSubmissions = %+v, %v, %v", subs, ok, err)
+	}
+	doc := BuildClassicSubmissionDoc(questions, subs, "WK03")
+	choice, dropdown, essay := doc.Questions[0], doc.Questions[1], doc.Questions[2]
+	if !choice.Options[1].Selected || choice.Options[0].Selected || *choice.Earned != 1 || strings.Join(choice.Responses, ",") != "B" {
+		t.Errorf("choice question = %+v", choice)
+	}
+	wantAttempts := []AttemptAnswer{{Attempt: "Attempt 1", Answer: "A", Score: "0 / 1"}, {Attempt: "Attempt 2", Answer: "B", Score: "1 / 1"}}
+	if !reflect.DeepEqual(choice.Attempts, wantAttempts) {
+		t.Errorf("attempts = %+v, want %+v", choice.Attempts, wantAttempts)
+	}
+	if got := dropdown.Attempts; len(got) != 2 || got[0].Answer != "(no response)" || strings.Join(dropdown.Responses, ",") != "Blank 1: Mars" {
+		t.Errorf("dropdown question = %+v", dropdown)
+	}
+	if essay.Earned != nil || strings.Join(essay.Submission, "") != "Because." || essay.Unanswered {
+		t.Errorf("ungraded essay = %+v", essay)
+	}
+	if got := doc.Details; len(got) != 1 || got[0].Value != "Attempt 1: 0 / 4, Attempt 2: 2 / 4" {
+		t.Errorf("details = %+v", got)
+	}
+
+	// submission_questions records the answer with the question.
+	questions[0].Answer = 1.0
+	if got := BuildClassicDoc(questions, "WK03").Questions[0]; !got.Options[0].Selected || got.Earned != nil {
+		t.Errorf("submission_questions answer = %+v", got)
+	}
+	if _, ok, _ := ParseClassicSubmissions([]byte(`[{"item_id": "1", "scored_data": {}}]`)); ok {
+		t.Error("New Quizzes results read as a Classic submission")
+	}
+}
+
+func TestParseQTI(t *testing.T) {
+	qti12 := `<?xml version="1.0" encoding="UTF-8"?>
+<questestinterop xmlns="http://www.imsglobal.org/xsd/ims_qtiasiv1p2">
+  <assessment ident="a1" title="Week 3 Quiz">
+    <section ident="root_section">
+      <item ident="i1" title="Question">
+        <itemmetadata><qtimetadata>
+          <qtimetadatafield><fieldlabel>question_type</fieldlabel><fieldentry>multiple_answers_question</fieldentry></qtimetadatafield>
+          <qtimetadatafield><fieldlabel>points_possible</fieldlabel><fieldentry>2.0</fieldentry></qtimetadatafield>
+        </qtimetadata></itemmetadata>
+        <presentation>
+          <material><mattext texttype="text/html">&lt;p&gt;Pick the primes.&lt;/p&gt;</mattext></material>
+          <response_lid ident="response1" rcardinality="Multiple"><render_choice>
+            <response_label ident="11"><material><mattext texttype="text/plain">2</mattext></material></response_label>
+            <response_label ident="12"><material><mattext texttype="text/plain">4</mattext></material></response_label>
+            <response_label ident="13"><material><mattext texttype="text/plain">5</mattext></material></response_label>
+          </render_choice></response_lid>
+        </presentation>
+        <resprocessing>
+          <respcondition continue="No">
+            <conditionvar><and>
+              <varequal respident="response1">11</varequal>
+              <not><varequal respident="response1">12</varequal></not>
+              <varequal respident="response1">13</varequal>
+            </and></conditionvar>
+            <setvar action="Set" varname="SCORE">100</setvar>
+          </respcondition>
+        </resprocessing>
+        <itemfeedback ident="general_fb"><flow_mat><material><mattext texttype="text/html">Primes have two divisors.</mattext></material></flow_mat></itemfeedback>
+      </item>
+      <section ident="g1" title="Group">
+        <selection_ordering><selection><sourcebank_ref>b1</sourcebank_ref></selection></selection_ordering>
+      </section>
+    </section>
+  </assessment>
+</questestinterop>`
+	bank := `<questestinterop><objectbank ident="b1"><item ident="i2">
+  <itemmetadata><qtimetadata><qtimetadatafield><fieldlabel>question_type</fieldlabel><fieldentry>fill_in_multiple_blanks_question</fieldentry></qtimetadatafield></qtimetadata></itemmetadata>
+  <presentation>
+    <material><mattext texttype="text/html">&lt;p&gt;Water boils at [t] degrees.&lt;/p&gt;</mattext></material>
+    <response_lid ident="response_t"><material><mattext>t</mattext></material><render_choice>
+      <response_label ident="21"><material><mattext>100</mattext></material></response_label>
+    </render_choice></response_lid>
+  </presentation>
+  <resprocessing><respcondition><conditionvar><varequal respident="response_t">21</varequal></conditionvar><setvar varname="SCORE" action="Add">100.00</setvar></respcondition></resprocessing>
+</item></objectbank></questestinterop>`
+	qs, err := ParseQTI(map[string][]byte{"a1/a1.xml": []byte(qti12), "non_cc_assessments/b1.xml.qti": []byte(bank), "imsmanifest.xml": []byte("<manifest/>")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := BuildClassicDoc(qs, "WK03")
+	if len(doc.Questions) != 2 {
+		t.Fatalf("got %d questions, want 2", len(doc.Questions))
+	}
+	q := doc.Questions[0]
+	if q.Text != "Pick the primes." || !q.Multi || q.Possible != 2 || strings.Join(q.Answers, ",") != "2,5" || q.GeneralFeedback != "Primes have two divisors." {
+		t.Errorf("question 1 = %+v", q)
+	}
+	if q := doc.Questions[1]; q.Text != "Water boils at [Blank 1] degrees." || len(q.Blanks) != 1 || q.Blanks[0].Answer != "100" {
+		t.Errorf("question 2 = %q %+v", q.Text, q.Blanks)
+	}
+
+	item := `<assessmentItem xmlns="http://www.imsglobal.org/xsd/imsqti_v2p1" identifier="q%d" title="Q">
+  <responseDeclaration identifier="RESPONSE" cardinality="single" baseType="identifier"><correctResponse><value>B</value></correctResponse></responseDeclaration>
+  <itemBody><p>Capital of %s?</p><choiceInteraction responseIdentifier="RESPONSE" maxChoices="1">
+    <simpleChoice identifier="A">Lyon</simpleChoice><simpleChoice identifier="B">Paris</simpleChoice>
+  </choiceInteraction></itemBody>
+</assessmentItem>`
+	blank := `<assessmentItem identifier="q3"><responseDeclaration identifier="R1"><correctResponse><value>4</value></correctResponse></responseDeclaration>
+  <itemBody><p>2 + 2 = <textEntryInteraction responseIdentifier="R1"/></p></itemBody></assessmentItem>`
+	test := `<assessmentTest><testPart><assessmentSection>
+  <assessmentItemRef href="items/b.xml"/><assessmentItemRef href="items/a.xml"/>
+</assessmentSection></testPart></assessmentTest>`
+	qs, err = ParseQTI(map[string][]byte{
+		"items/a.xml": []byte(fmt.Sprintf(item, 1, "France")),
+		"items/b.xml": []byte(fmt.Sprintf(item, 2, "Gaul")),
+		"items/c.xml": []byte(blank),
+		"test.xml":    []byte(test),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = BuildClassicDoc(qs, "QTI")
+	var got []string
+	for _, q := range doc.Questions {
+		got = append(got, q.Text+" = "+strings.Join(q.Answers, ",")+blanksOf(q))
+	}
+	want := []string{"Capital of Gaul? = Paris", "Capital of France? = Paris", "2 + 2 = [Blank 1] = 4"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("QTI 2.1 questions:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := ParseQTI(map[string][]byte{"a.xml": []byte(qti12), "b.xml": []byte(strings.Replace(qti12, `ident="a1"`, `ident="a2"`, 1))}); err == nil {
+		t.Error("ParseQTI accepted two assessments")
+	}
+	if _, err := ParseQTI(map[string][]byte{"imsmanifest.xml": []byte("<manifest/>")}); err == nil {
+		t.Error("ParseQTI accepted a package without questions")
+	}
+}
+
+// blanksOf lists a question's blank answers, for compact comparisons.
+func blanksOf(q Question) string {
+	var s []string
+	for _, b := range q.Blanks {
+		s = append(s, b.Answer)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestBuildBank(t *testing.T) {
+	choice := func(key ...string) Question {
+		q := Question{Number: 1, ContentID: "q-choice", Text: "Pick one", Options: []Option{{ID: "a", Label: "A"}, {ID: "b", Label: "B"}}}
+		for i, o := range q.Options {
+			if slices.Contains(key, o.Label) {
+				q.Options[i].Correct = true
+				q.Answers = append(q.Answers, o.Label)
+			}
+		}
+		return q
+	}
+	blank := func(answer string, accepted ...string) Question {
+		return Question{Number: 2, ContentID: "q-blank", Text: "[Blank 1]", Blanks: []BlankAnswer{{Label: "Blank 1", Answer: answer, Accepted: accepted}}}
+	}
+	docs := []QuizDoc{
+		{Questions: []Question{choice(), blank("color", "color", "colour")}},
+		{Questions: []Question{{ContentID: "q-new", Text: "New"}, choice("B"), blank("hue")}},
+		{Questions: []Question{blank("color"), blank("color")}},
+	}
+	bank := BuildBank(docs, []string{"WK01", "WK02", "WK03"}, "Bank")
+	if len(bank.Questions) != 3 {
+		t.Fatalf("got %d questions, want 3", len(bank.Questions))
+	}
+	c, b, n := bank.Questions[0], bank.Questions[1], bank.Questions[2]
+	if strings.Join(c.Answers, ",") != "B" || !c.Options[1].Correct || c.Number != 1 || strings.Join(c.Quizzes, ",") != "WK01,WK02" {
+		t.Errorf("choice = %+v", c)
+	}
+	if got := b.Blanks[0]; got.Answer != "color" || strings.Join(got.Accepted, ",") != "color,colour,hue" || strings.Join(b.Quizzes, ",") != "WK01,WK02,WK03" {
+		t.Errorf("blank = %+v, quizzes %v", got, b.Quizzes)
+	}
+	if n.Number != 3 || strings.Join(n.Quizzes, ",") != "WK02" {
+		t.Errorf("new question = %+v", n)
+	}
+	if got := bank.Details[1].Value; got != "3 unique of 7" {
+		t.Errorf("questions detail = %q", got)
+	}
+	if md := RenderMarkdown(bank); !strings.Contains(md, "- Appeared in: WK01, WK02\n") {
+		t.Errorf("markdown lacks the quizzes of a question:\n%s", md)
+	}
+}
+
+func TestMockExam(t *testing.T) {
+	earned := 0.0
+	var bank QuizDoc
+	for i := 1; i <= 6; i++ {
+		id := fmt.Sprintf("q%d", i)
+		bank.Questions = append(bank.Questions, Question{
+			Number: i, ContentID: id, Text: id, HasResult: true, Earned: &earned, Quizzes: []string{"WK01"},
+			Options: []Option{{ID: "a", Label: "A", Correct: true, Selected: true}, {ID: "b", Label: "B"}, {ID: "c", Label: "C"}, {ID: "d", Label: "D"}},
+			Answers: []string{"A"}, Responses: []string{"A"}, GeneralFeedback: "Because A.",
+		})
+	}
+	// Neither a survey item nor a question whose key never showed can go in the key.
+	bank.Questions = append(bank.Questions,
+		Question{Number: 7, ContentID: "survey", Text: "How was it?", Scale: true, Ungraded: true, HasResult: true},
+		Question{Number: 8, ContentID: "unknown", Text: "Lost", HasResult: true, Options: []Option{{ID: "a", Label: "A"}}})
+
+	exam, key := MockExam(bank, 10, rand.New(rand.NewSource(1)), "Mock")
+	if len(exam.Questions) != 6 || len(key.Questions) != 6 {
+		t.Fatalf("got %d exam and %d key questions, want the 6 with a key", len(exam.Questions), len(key.Questions))
+	}
+	if !exam.Exam || !exam.Practice || key.Practice || key.Title != "Mock — Answer Key" {
+		t.Errorf("exam = %q practice %v exam %v, key = %q practice %v", exam.Title, exam.Practice, exam.Exam, key.Title, key.Practice)
+	}
+	shuffled := false
+	for i, q := range exam.Questions {
+		k := key.Questions[i]
+		if q.Number != i+1 || k.Number != i+1 || q.ContentID != k.ContentID {
+			t.Errorf("question %d: exam %d %s, key %d %s", i, q.Number, q.ContentID, k.Number, k.ContentID)
+		}
+		for j, o := range q.Options {
+			if o.Label != k.Options[j].Label {
+				t.Errorf("%s: exam and key list the options in different orders", q.ContentID)
+			}
+			if o.Correct || o.Selected || k.Options[j].Selected {
+				t.Errorf("%s: option %+v of the exam, %+v of the key", q.ContentID, o, k.Options[j])
+			}
+		}
+		shuffled = shuffled || q.Options[0].Label != "A"
+		if q.Answers != nil || q.GeneralFeedback != "" || q.Quizzes != nil || q.Responses != nil {
+			t.Errorf("exam question gives away its key: %+v", q)
+		}
+		if strings.Join(k.Answers, ",") != "A" || k.GeneralFeedback == "" || k.Responses != nil || k.Earned != nil {
+			t.Errorf("key question = %+v", k)
+		}
+	}
+	if !shuffled {
+		t.Error("no options were shuffled")
+	}
+	if bank.Questions[0].Options[0].Label != "A" || !bank.Questions[0].Options[0].Correct {
+		t.Errorf("MockExam changed the bank: %+v", bank.Questions[0].Options)
+	}
+	if md := RenderMarkdown(exam); strings.Contains(md, "(correct)") || !strings.Contains(md, "answer key is a separate document") {
+		t.Errorf("exam markdown:\n%s", md)
+	}
+
+	again, _ := MockExam(bank, 3, rand.New(rand.NewSource(1)), "Mock")
+	first, _ := MockExam(bank, 3, rand.New(rand.NewSource(1)), "Mock")
+	for i := range again.Questions {
+		if again.Questions[i].ContentID != first.Questions[i].ContentID || again.Questions[i].Options[0].Label != first.Questions[i].Options[0].Label {
+			t.Errorf("the same seed drew different exams")
+		}
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	earned := 0.0
+	doc := QuizDoc{Title: "WK12", ShowResponses: true, Comments: []Comment{{Text: "Nice"}}, Questions: []Question{{
+		Number: 3, Text: "Pick A", HasResult: true, Earned: &earned,
+		Options: []Option{{ID: "a", Label: "A", Correct: true}, {ID: "b", Label: "B", Selected: true}},
+		Answers: []string{"A"}, Responses: []string{"B"}, GeneralFeedback: "Because A.",
+	}}}
+	questions, key := SplitKey(doc)
+	if !questions.QuestionsOnly || !questions.Practice || key.Practice || key.ShowResponses || key.Comments != nil {
+		t.Errorf("questions = %+v, key = %+v", questions, key)
+	}
+	q, k := questions.Questions[0], key.Questions[0]
+	if q.Number != 3 || q.Answers != nil || q.GeneralFeedback != "" || q.Options[0].Correct || q.Options[1].Selected {
+		t.Errorf("questions gives away its key or responses: %+v", q)
+	}
+	if !k.Options[0].Correct || k.Options[1].Selected || k.Responses != nil || k.Earned != nil || k.GeneralFeedback != "Because A." {
+		t.Errorf("key question = %+v", k)
+	}
+	if !doc.Questions[0].Options[1].Selected {
+		t.Error("SplitKey changed the document it split")
+	}
+	md := RenderMarkdown(questions)
+	if !strings.Contains(md, "_Questions only — the answer key is a separate document._") || strings.Contains(md, "(correct)") {
+		t.Errorf("questions document:\n%s", md)
+	}
+}
+
+func TestBankDifficulty(t *testing.T) {
+	tests := []struct {
+		record []bool
+		want   string
+	}{
+		{nil, ""},
+		{[]bool{true, false}, "easy"},
+		{[]bool{false, false, true}, "medium"},
+		{[]bool{false, false}, "hard"},
+	}
+	for _, tt := range tests {
+		if got := difficulty(tt.record); got != tt.want {
+			t.Errorf("difficulty(%v) = %q, want %q", tt.record, got, tt.want)
+		}
+	}
+
+	scored := func(id string, earned float64) Question {
+		return Question{ContentID: id, Text: id, Possible: 1, Earned: &earned, HasResult: true}
+	}
+	docs := []QuizDoc{
+		{Questions: []Question{scored("a", 1), scored("b", 0), scored("c", 0), {ContentID: "d", Text: "d"}}},
+		{Questions: []Question{scored("b", 1), scored("c", 0.5)}},
+	}
+	bank := BuildBank(docs, []string{"WK01", "WK02"}, "Bank")
+	var got []string
+	for _, q := range bank.Questions {
+		got = append(got, q.Text+"="+questionDifficulty(q))
+	}
+	if strings.Join(got, " ") != "a=easy b=medium c=hard d=" {
+		t.Errorf("difficulties = %v", got)
+	}
+	if d := bank.Details[len(bank.Details)-1]; d.Label != "Difficulty" || d.Value != "easy 1, medium 1, hard 1, unscored 1" {
+		t.Errorf("difficulty detail = %+v", d)
+	}
+	bank.SortByDifficulty()
+	bank.FilterDifficulty([]string{"hard", "easy"})
+	got = got[:0]
+	for _, q := range bank.Questions {
+		got = append(got, fmt.Sprintf("%d) %s", q.Number, q.Text))
+	}
+	if strings.Join(got, ", ") != "3) c, 1) a" {
+		t.Errorf("sorted hard and easy questions = %v", got)
+	}
+}
+
+func TestApplyClassStats(t *testing.T) {
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, ItemID: "66274"},
+		{Number: 2, ItemID: "66197", Options: []Option{{ID: "c1", Label: "Latency"}, {ID: "c2", Label: "Response Time"}}},
+		{Number: 3, ItemID: "66300"},
+	}}
+	var stats []QuestionStatistics
+	err := json.Unmarshal([]byte(`[
+		{"id": 66274, "position": 3, "answered_student_count": 20, "correct_student_count": 19},
+		{"id": "501", "position": 2, "answered_student_count": 25, "correct_student_count": 8, "correct_student_ratio": 0.32,
+		 "answers": [{"id": "c1", "text": "<p>latency</p>", "responses": 15}, {"id": "c9", "text": "<p>Other</p>", "responses": 2}]}
+	]`), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.ApplyClassStats(stats)
+	want := []string{
+		"Easy: 95% answered correctly (19 of 20 students).",
+		"Hard: 32% answered correctly (8 of 25 students). Responses: Latency 60%, Other 8%.",
+		"",
+	}
+	for i, q := range doc.Questions {
+		got := ""
+		if q.Class != nil {
+			got = q.Class.Summary()
+		}
+		if got != want[i] {
+			t.Errorf("question %d: Class = %q, want %q", q.Number, got, want[i])
+		}
+	}
+}
+
+func TestBuildReplay(t *testing.T) {
+	var payload struct {
+		Events []SubmissionEvent `json:"quiz_submission_events"`
+	}
+	err := json.Unmarshal([]byte(`{"quiz_submission_events": [
+		{"event_type": "session_started", "event_data": null, "created_at": "2025-11-09T06:00:00Z"},
+		{"event_type": "question_viewed", "event_data": ["10"], "created_at": "2025-11-09T06:00:05Z"},
+		{"event_type": "question_answered", "event_data": [{"quiz_question_id": "10", "answer": "7"}], "created_at": "2025-11-09T06:01:05Z"},
+		{"event_type": "page_blurred", "event_data": null, "created_at": "2025-11-09T06:01:10Z"},
+		{"event_type": "page_focused", "event_data": null, "created_at": "2025-11-09T06:03:10Z"},
+		{"event_type": "question_answered", "event_data": [{"quiz_question_id": "10", "answer": "8"}], "created_at": "2025-11-09T06:03:20Z"},
+		{"event_type": "question_viewed", "event_data": [11], "created_at": "2025-11-09T06:03:30Z"},
+		{"event_type": "question_answered", "event_data": [{"quiz_question_id": 11, "answer": "x"}, {"quiz_question_id": 11, "answer": "x"}], "created_at": "2025-11-09T06:04:00Z"}
+	]}`), &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := QuizDoc{Questions: []Question{{Number: 1, ItemID: "10"}}}
+	r := BuildReplay(payload.Events, doc)
+	if got := formatClock(r.Duration); got != "4:00" {
+		t.Errorf("Duration = %s, want 4:00", got)
+	}
+	want := []string{"Q1 0:05 1:25 2 1", "question 11 3:30 0:30 2 0"}
+	for i, q := range r.Questions {
+		got := fmt.Sprintf("%s %s %s %d %d", q.Label, formatClock(q.FirstViewed), formatClock(q.TimeSpent), q.Answers, q.Switches)
+		if i >= len(want) || got != want[i] {
+			t.Errorf("question %d = %q, want %q", i, got, want)
+		}
+	}
+	if len(r.Questions) != len(want) {
+		t.Errorf("got %d questions, want %d", len(r.Questions), len(want))
+	}
+	if got := r.Events[5].Text; got != "changed answer to Q1" {
+		t.Errorf("event 5 = %q", got)
+	}
+	if BuildReplay(nil, doc) != nil {
+		t.Error("buildReplay(nil) should be nil")
+	}
+}
+
+func TestFormatClock(t *testing.T) {
+	tests := map[time.Duration]string{0: "0:00", 65 * time.Second: "1:05", 3725 * time.Second: "1:02:05"}
+	for d, want := range tests {
+		if got := formatClock(d); got != want {
+			t.Errorf("formatClock(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestPayloadShape(t *testing.T) {
+	tests := map[string]string{
+		`[{"item": {"id": "1"}}]`:                                            "quiz",
+		`[{"id": 1, "question_type": "essay_question"}]`:                     "quiz",
+		`[{"item_id": "1", "scored_data": {}}]`:                              "results",
+		`{"submission": {"results": [{"item_id": "1", "scored_data": {}}]}}`: "results",
+		`{"title": "WK12"}`:                                                  "",
+		`not json`:                                                           "",
+	}
+	for in, want := range tests {
+		if got := PayloadShape([]byte(in)); got != want {
+			t.Errorf("payloadShape(%s) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestExtractMedia(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Media
+	}{
+		{"video element with sources",
+			`<p>Watch:</p><video controls data-duration="135"><source src="https://cdn.example.com/lecture.mp4?x=1" type="video/mp4"></video>`,
+			[]Media{{Kind: "video", URL: "https://cdn.example.com/lecture.mp4?x=1", Title: "lecture.mp4", Duration: 135 * time.Second, Player: true}}},
+		{"titled audio, duplicate dropped",
+			`<audio src="/files/9/clip.mp3" title="Interview &amp; notes"></audio><audio src="/files/9/clip.mp3"></audio>`,
+			[]Media{{Kind: "audio", URL: "/files/9/clip.mp3", Title: "Interview & notes", Player: true}}},
+		{"media comment link",
+			`<a id="media_comment_m-abc" class="instructure_inline_media_comment audio_comment" href="/media_objects/m-abc">this is a media comment</a>`,
+			[]Media{{Kind: "audio", URL: "/media_objects/m-abc"}}},
+		{"rich content editor iframe",
+			`<iframe title="Video player for demo.mov" src="/media_attachments_iframe/42?type=video" data-media-type="video"></iframe>`,
+			[]Media{{Kind: "video", URL: "/media_attachments_iframe/42?type=video", Title: "Video player for demo.mov"}}},
+		{"no media", `<p>Which is fastest?</p><iframe src="https://example.com/embed"></iframe>`, nil},
+	}
+	for _, tt := range tests {
+		got := extractMedia(tt.body)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: extractMedia = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	m := Media{Kind: "video", Title: "lecture.mp4", Duration: 135 * time.Second}
+	if got := m.Label(); got != "Video: lecture.mp4 (2:15)" {
+		t.Errorf("Label = %q", got)
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	got := extractLinks(
+		`<p>See <a href="https://sre.google/sre-book/monitoring/" target="_blank">the <em>SRE book</em></a> and <a href="/courses/1/pages/notes">notes</a>.</p>`,
+		`<a class="instructure_inline_media_comment" href="https://canvas.example.com/media_objects/m-1">clip</a>`,
+		`<a href='HTTPS://example.com/a?x=1&amp;y=2'></a><a href="https://sre.google/sre-book/monitoring/">again</a>`,
+	)
+	want := []Link{
+		{URL: "https://sre.google/sre-book/monitoring/", Text: "the SRE book"},
+		{URL: "HTTPS://example.com/a?x=1&y=2", Text: "HTTPS://example.com/a?x=1&y=2"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("extractLinks = %+v, want %+v", got, want)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	book := Link{URL: "https://sre.google/sre-book/", Text: "SRE book"}
+	docs := Link{URL: "https://prometheus.io/docs/", Text: "Prometheus docs"}
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, Links: []Link{docs}},
+		{Number: 2},
+		{Number: 3, Links: []Link{book, docs, {URL: book.URL, Text: "again"}}},
+	}}
+	got := doc.References()
+	want := []Reference{{Link: docs, Questions: []int{1, 3}}, {Link: book, Questions: []int{3}}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("References = %+v, want %+v", got, want)
+	}
+	if md := RenderMarkdown(doc); !strings.Contains(md, "## References\n\n- [Prometheus docs](https://prometheus.io/docs/) — Q1, Q3\n") {
+		t.Errorf("markdown references missing:\n%s", md)
+	}
+}
+
+func TestExtractTerms(t *testing.T) {
+	got := extractTerms(`<p>An <dfn>SLO</dfn> bounds <strong>error budget</strong> spend; <em>never</em> exceed <b>99.9</b> or <i>a very long emphasized phrase of seven words</i>.</p>`)
+	want := []string{"SLO", "error budget", "never"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("extractTerms = %q, want %q", got, want)
+	}
+}
+
+func TestBuildGlossary(t *testing.T) {
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, Text: "Which signal measures delay?", Terms: []string{"signal"}, Answers: []string{"Latency"}},
+		{Number: 2, Text: "True or false: latency is a golden signal.", Answers: []string{"True"}},
+		{Number: 3, Text: "Tools like Prometheus are used for [Blank 1].", Blanks: []BlankAnswer{{Label: "Blank 1", Answer: "monitoring"}}},
+	}}
+	got := BuildGlossary(doc, map[string]string{"latency": "Time taken to serve a request.", "saturation": "How full a service is."})
+	want := []GlossaryEntry{
+		{Term: "latency", Definition: "Time taken to serve a request.", Questions: []int{1, 2}},
+		{Term: "monitoring", Questions: []int{3}},
+		{Term: "signal", Questions: []int{1}},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("buildGlossary = %+v, want %+v", got, want)
+	}
+}
+
+func TestClassifyBloom(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Define latency.", "remember"},
+		{"Vertical scaling refers to:", "remember"},
+		{"Which statement best describes a soak test?", "understand"},
+		{"Calculate the p99 latency given these samples.", "apply"},
+		{"Compare horizontal and vertical scaling: what is the most likely cause of the slowdown?", "analyze"},
+		{"Prometheus scrapes [Blank 1].", ""},
+	}
+	for _, tt := range tests {
+		if got := classifyBloom(tt.text); got != tt.want {
+			t.Errorf("classifyBloom(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestApplyBloom(t *testing.T) {
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, Text: "Define latency."},
+		{Number: 2, Text: "Prometheus scrapes [Blank 1]."},
+		{Number: 3, Text: "What is throughput?"},
+		{Number: 4, Text: "Kafka partitions."},
+	}}
+	llm := func(prompt string) (string, error) {
+		if strings.Contains(prompt, "Define") {
+			return "", errors.New("unreachable: keywords classify it")
+		}
+		if strings.Contains(prompt, "Kafka") {
+			return "no idea", nil
+		}
+		return "Level: Apply (applying a tool)", nil
+	}
+	if warnings := doc.ApplyBloom("llm", llm); len(warnings) != 1 || !strings.Contains(warnings[0], "question 4") {
+		t.Errorf("warnings = %q, want one for question 4", warnings)
+	}
+	if got := fmt.Sprint(doc.Questions[0].Tags, doc.Questions[1].Tags); got != "[bloom:remember] [bloom:apply]" {
+		t.Errorf("tags = %s", got)
+	}
+	want := DocDetail{Label: "Bloom's levels", Value: "remember 2, apply 1, unclassified 1"}
+	if len(doc.Details) != 1 || doc.Details[0] != want {
+		t.Errorf("details = %+v, want %+v", doc.Details, want)
+	}
+}
+
+func TestQuestionType(t *testing.T) {
+	tests := []struct {
+		slug, name, userRespType string
+		want                     string
+	}{
+		{"choice", "Multiple Choice", "Uuid", "multiple choice"},
+		{"multi-answer", "Multiple Answer", "MultipleUuid", "multiple answer"},
+		{"rich-fill-blank", "Rich Fill in the Blank", "MultipleResponse", "fill in the blank"},
+		{"", "", "Boolean", "true/false"},
+		{"hot-text", "", "", "hot text"},
+		{"likert-scale", "", "", "survey"},
+		{"short_answer_question", "", "", "fill in the blank"},
+		{"stimulus-image", "Image Stimulus", "", "image stimulus"},
+		{"", "", "", "other"},
+	}
+	for _, tt := range tests {
+		if got := questionType(tt.slug, tt.name, tt.userRespType); got != tt.want {
+			t.Errorf("questionType(%q, %q, %q) = %q, want %q", tt.slug, tt.name, tt.userRespType, got, tt.want)
+		}
+	}
+}
+
+func TestAssets(t *testing.T) {
+	doc := QuizDoc{Title: "WK01 Quiz", Questions: []Question{
+		{Number: 1, Text: "What is shown?", HasResult: true, Answers: []string{"A"}, Options: []Option{{Label: "A", Correct: true}},
+			BodyHTML: `<p><img src="/files/2/preview" alt="Load [graph]"><img src="/files/3/preview"><img src="/files/2/preview"><img class="equation_image" src="/equation_images/x" data-equation-content="x"></p>`},
+	}}
+	if got, want := ImageSources(doc), []string{"/files/2/preview", "/files/3/preview"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ImageSources = %q, want %q", got, want)
+	}
+	doc.Assets = map[string]string{"/files/2/preview": "assets/0a1b.png"}
+	md, _, err := Render(doc, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "- Image: ![Load \\[graph\\]](assets/0a1b.png)\n- Image: ![0a1b.png](assets/0a1b.png)\n"; !strings.Contains(md, want) {
+		t.Errorf("Markdown should show the local image once per tag, titled by its alt text or file name:\n%s", md)
+	}
+	if strings.Contains(md, "/files/3/preview") {
+		t.Errorf("Markdown shows an image without a local copy:\n%s", md)
+	}
+	page, _, err := Render(doc, RenderOptions{Format: "html", HTML: HTMLOptions{LinkBase: "https://school.instructure.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<img src="assets/0a1b.png" alt="Load [graph]">`, `<img src="https://school.instructure.com/files/3/preview">`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML is missing %s", want)
+		}
+	}
+}
+
+func TestCanvasFileID(t *testing.T) {
+	tests := []struct{ href, want string }{
+		{"/courses/1/files/12345/download?verifier=abc&wrap=1", "12345"},
+		{"https://school.instructure.com/courses/1/files/12345", "12345"},
+		{"/files/77/preview", "77"},
+		{"/courses/1/files/12345/download/extra", ""},
+		{"/courses/1/files", ""},
+		{"https://example.com/files/abc", ""},
+	}
+	for _, tt := range tests {
+		if got := CanvasFileID(tt.href); got != tt.want {
+			t.Errorf("CanvasFileID(%q) = %q, want %q", tt.href, got, tt.want)
+		}
+	}
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, BodyHTML: `<p><a href="/courses/1/files/9/download?verifier=x">notes</a> <a href="https://go.dev">Go</a></p>`,
+			Links: []Link{{URL: "https://school.test/courses/1/files/8/download", Text: "slides"}, {URL: "https://go.dev", Text: "Go"}}},
+	}}
+	if got, want := FileLinks(doc), []string{"/courses/1/files/9/download?verifier=x", "https://school.test/courses/1/files/8/download"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FileLinks = %q, want %q", got, want)
+	}
+	doc.Assets = map[string]string{"https://school.test/courses/1/files/8/download": "assets/8f.pdf", "/courses/1/files/9/download?verifier=x": "assets/9a.pdf"}
+	if refs := doc.References(); len(refs) != 2 || refs[0].URL != "assets/8f.pdf" || refs[1].URL != "https://go.dev" {
+		t.Errorf("References = %+v, want the slides pointing to their copy", refs)
+	}
+	if got := sanitizeHTML(doc.Questions[0].BodyHTML, "", doc.Assets); !strings.Contains(got, `<a href="assets/9a.pdf">notes</a>`) {
+		t.Errorf("sanitizeHTML kept the Canvas file link: %s", got)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	earned := 0.5
+	doc := QuizDoc{Title: "WK01 Quiz", Questions: []Question{
+		{Number: 1, Text: "Pick two.", HasResult: true, Possible: 1, Earned: &earned, Answers: []string{"A", "B"}, Responses: []string{"A"},
+			Options: []Option{{Label: "A", Correct: true, Selected: true}, {Label: "B", Correct: true}, {Label: "C"}}},
+		{Number: 2, Text: "Say something.", Possible: 2},
+	}}
+	tmpl, err := ParseTemplate("test.md.tmpl", `{{.Title}}
+{{range .Questions}}{{.Number}}. {{upper .Text}} [{{points .Earned}}/{{points .Possible}}] {{join .Answers ", "}}
+{{range $i, $o := .Options}}{{letter $i}}{{if $o.Correct}}*{{end}} {{end}}{{with check .}}{{.Mark}} {{.Response}}{{end}}
+{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := Render(doc, RenderOptions{Format: "html", Template: tmpl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WK01 Quiz\n1. PICK TWO. [0.5/1] A, B\nA* B* C ❌ A\n2. SAY SOMETHING. [/2] \n\n"
+	if out != want {
+		t.Errorf("Render with a template = %q, want %q", out, want)
+	}
+	if _, err := ParseTemplate("bad.tmpl", "{{range .Questions}}"); err == nil {
+		t.Error("ParseTemplate accepted an unclosed range")
+	}
+	bad, _ := ParseTemplate("bad.tmpl", "{{points .Title}}")
+	if _, err := RenderTemplate(doc, bad); err == nil || !strings.Contains(err.Error(), "want a number") {
+		t.Errorf("RenderTemplate error = %v, want the points type error", err)
+	}
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	const base = "https://school.instructure.com/courses/1/quizzes/2"
+	tests := []struct{ in, base, want string }{
+		{`<p style="color:red">Run <code class="language-go x">fmt.Println(a < b)</code></p>`, "",
+			`<p>Run <code class="language-go">fmt.Println(a &lt; b)</code></p>`},
+		{`<pre><code>for i := 0; i < n; i++ {}</code></pre><script>alert(1)</script>`, "",
+			`<pre><code>for i := 0; i &lt; n; i++ {}</code></pre>`},
+		{`<img src="/courses/1/files/9/preview" alt="diagram" onerror="x()">`, base,
+			`<img src="https://school.instructure.com/courses/1/files/9/preview" alt="diagram">`},
+		{`<img src="/courses/1/files/9/preview">`, "", `<img src="/courses/1/files/9/preview">`},
+		{`<a href="javascript:alert(1)">x</a> <a href="https://go.dev">Go</a>`, "",
+			`<a>x</a> <a href="https://go.dev">Go</a>`},
+		{`<table><tr><td colspan="2" class="c">1<td>2</table>`, "",
+			`<table><tr><td colspan="2">1</td><td>2</td></tr></table>`},
+		{`<font color="red"><ul><li>one<li>two</ul></font>`, "", `<ul><li>one</li><li>two</li></ul>`},
+		{`<p><img class="equation_image" data-equation-content="x^2"></p><iframe src="https://e.test">no</iframe>`, "",
+			`<p>\(x^2\)</p>`},
+	}
+	for _, tt := range tests {
+		if got := sanitizeHTML(tt.in, tt.base, nil); got != tt.want {
+			t.Errorf("sanitizeHTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRichBody(t *testing.T) {
+	tests := map[string]bool{
+		`<p>Which planet is red?</p>`:                   false,
+		`<p>What does <code>len(s)</code> return?</p>`:  true,
+		`<ul><li>a</li></ul>`:                           true,
+		`<img class="equation_image" alt="LaTeX: x">`:   false,
+		`<p><img src="/courses/1/files/2/preview"></p>`: true,
+		`<table><tr><td>1</td></tr></table>`:            true,
+		`<p>if a <b>and</b> b &lt;code&gt; nothing</p>`: false,
+	}
+	for in, want := range tests {
+		if got := richBody(in); got != want {
+			t.Errorf("richBody(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestMarkdownBlocks(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []mdBlock
+	}{
+		{`<p>What does <code>len(s)</code> return?</p>`, []mdBlock{{Text: "What does `len(s)` return?", Para: true}}},
+		{`<p>Output?</p><pre class="language-go">if a < b &amp;&amp; c > d {
+	fmt.Println("x")
+}</pre>`, []mdBlock{
+			{Text: "Output?", Para: true},
+			{Text: "```go\nif a < b && c > d {\n\tfmt.Println(\"x\")\n}\n```"},
+		}},
+		{`<ol start="3"><li>First</li><li>Second<ul><li>nested</li></ul></li></ol>`, []mdBlock{
+			{Text: "3. First\n4. Second\n   - nested"},
+		}},
+		{`<table><caption>Results</caption><tr><th>Run</th><th>a|b</th></tr><tr><td>1</td></tr></table>`, []mdBlock{
+			{Text: "_Results_\n\n| Run | a\\|b |\n| --- | --- |\n| 1 |  |"},
+		}},
+		{`<p>See <img src="/files/1/preview"> and <span title="a>b">this</span>.</p>`, []mdBlock{{Text: "See and this.", Para: true}}},
+	}
+	for _, tt := range tests {
+		if got := markdownBlocks(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("markdownBlocks(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRepairHTML(t *testing.T) {
+	tests := []struct {
+		in, want string
+		repaired bool
+	}{
+		{"<p>Well <strong>formed</strong><br>text</p>", "<p>Well <strong>formed</strong><br>text</p>", false},
+		{"<p>I <3 loops</p>", "<p>I &lt;3 loops</p>", true},
+		{"<pre>if (a < b && c > d)</pre>", "<pre>if (a &lt; b && c &gt; d)</pre>", true},
+		{"<p>Bold <b>start</p>", "<p>Bold <b>start</b></p>", true},
+		{"<p>Stray</em> close</p>", "<p>Stray close</p>", true},
+		{"<ul><li>one<li>two</ul>", "<ul><li>one</li><li>two</li></ul>", false},
+		{`<a title="a>b" href="x">link</a> <!-- note -->`, `<a title="a>b" href="x">link</a> <!-- note -->`, false},
+		{"<p>trailing <b", "<p>trailing &lt;b</p>", true},
+	}
+	for _, tt := range tests {
+		got, repaired := RepairHTML(tt.in)
+		if got != tt.want || repaired != tt.repaired {
+			t.Errorf("repairHTML(%q) = %q, %v, want %q, %v", tt.in, got, repaired, tt.want, tt.repaired)
+		}
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"<p>First</p>\n<p>Second</p>", "First Second"},
+		{"<p>  lots \r\n of\t space  </p>", "lots of space"},
+		{"<div><ul><li><strong>Bold</strong> item</li></ul></div>", "Bold item"},
+		{"Tom &amp; Jerry&nbsp;&lt;3 &#39;cats&#39;", "Tom & Jerry <3 'cats'"},
+		{"H<sub>2</sub>O and x<sup>2</sup>", "H₂O and x²"},
+		{"<!-- note -->Visible", "Visible"},
+		{"Cafe\u0301 cre\u0300me", "Caf\u00e9 cr\u00e8me"},
+		{"\u200fשלום\u200f world", "\u200fשלום\u200f world"},
+		{`<p dir="rtl">مرحبا <b>بك</b></p> hello`, "\u2067مرحبا بك\u2069 hello"},
+	}
+	for _, tt := range tests {
+		if got := stripHTML(tt.in); got != tt.want {
+			t.Errorf("stripHTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripHTMLMalformed(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"<p>I <3 loops</p>", "I <3 loops"},
+		{"<code>for (i = 0; i < n; i++)</code> runs n times", "for (i = 0; i < n; i++) runs n times"},
+		{"<p>x &gt; 3 and y > 4</p>", "x > 3 and y > 4"},
+		{`<span title="a>b">text</span>`, "text"},
+		{"<code>if a<b then</code>", "if a<b then"},
+	}
+	for _, tt := range tests {
+		if got := stripHTML(tt.in); got != tt.want {
+			t.Errorf("stripHTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripHTMLEquations(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`Solve <img class="equation_image" title="x^2" src="/equation_images/x%255E2" alt="LaTeX: x^2" data-equation-content="x^2"> for x`, `Solve \(x^2\) for x`},
+		{`<img class="equation_image" alt="LaTeX: a &lt; b">`, `\(a < b\)`},
+		{`<img src="/courses/1/files/2/preview" alt="diagram">`, ``},
+	}
+	for _, tt := range tests {
+		if got := stripHTML(tt.in); got != tt.want {
+			t.Errorf("stripHTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderHTMLMath(t *testing.T) {
+	plain := QuizDoc{Title: "Quiz", Questions: []Question{{Number: 1, Text: "What is 2 + 2?"}}}
+	math := QuizDoc{Title: "Quiz", Questions: []Question{{Number: 1, Text: `Simplify \(x^2 \cdot x\)`}}}
+	tests := []struct {
+		doc  QuizDoc
+		mode string
+		want bool
+	}{
+		{plain, "cdn", false},
+		{math, "cdn", true},
+		{math, "none", false},
+		{QuizDoc{Questions: []Question{{Options: []Option{{Label: "$$\\frac{1}{2}$$"}}}}}, "cdn", true},
+	}
+	for _, tt := range tests {
+		out, err := RenderHTML(tt.doc, HTMLOptions{Math: tt.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(out, "mathjax@3"); got != tt.want {
+			t.Errorf("renderHTML(%q, math %s) loads MathJax = %v, want %v", tt.doc.Questions[0].Text, tt.mode, got, tt.want)
+		}
+	}
+	if _, err := RenderHTML(math, HTMLOptions{Math: "katex"}); err == nil {
+		t.Error("renderHTML accepted an unknown math mode")
+	}
+}
+
+func TestKaTeXOffline(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"katex.min.css":                  `@font-face{font-family:KaTeX_Main;src:url(fonts/KaTeX_Main-Regular.woff2) format("woff2"),url(fonts/KaTeX_Main-Regular.woff) format("woff"),url(fonts/KaTeX_Main-Regular.ttf) format("truetype")}`,
+		"katex.min.js":                   `var katex={};document.write("</script>");`,
+		"contrib/auto-render.min.js":     `function renderMathInElement(){}`,
+		"contrib/mhchem.min.js":          `katex.__defineMacro("\\ce",function(){});`,
+		"fonts/KaTeX_Main-Regular.woff2": "wOF2",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := katexOffline(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`src:url(data:font/woff2;base64,d09GMg==) format("woff2")}`,
+		`document.write("<\/script>")`,
+		"function renderMathInElement(){}",
+		`{left: "\\(", right: "\\)", display: false}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("katexOffline output lacks %q", want)
+		}
+	}
+	if strings.Contains(got, ".ttf") || strings.Contains(got, "fonts/") {
+		t.Errorf("katexOffline left font references: %s", got)
+	}
+
+	os.Remove(filepath.Join(dir, "fonts", "KaTeX_Main-Regular.woff2"))
+	if _, err := katexOffline(dir); err == nil {
+		t.Error("katexOffline succeeded without its fonts")
+	}
+}
+
+func TestChemFallback(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`Balance \(\ce{2H2 + O2 -> 2H2O}\)`, "Balance 2H₂ + O₂ → 2H₂O"},
+		{`The sulfate ion \ce{SO4^2-} is common.`, "The sulfate ion SO₄²⁻ is common."},
+		{`$$\ce{Fe^{3+} + e- <=> Fe^{2+}}$$`, "Fe³⁺ + e⁻ ⇌ Fe²⁺"},
+		{`\ce{Fe2(SO4)3(aq)}`, "Fe₂(SO₄)₃(aq)"},
+		{`\ce{NH4+ + OH- -> NH3 + H2O}`, "NH₄⁺ + OH⁻ → NH₃ + H₂O"},
+		{`\ce{A^{*}}`, "A^(*)"},
+		{`\(x^2 + \ce{H2O}\)`, `\(x^2 + \ce{H2O}\)`},
+		{`No chemistry \(x^2\)`, `No chemistry \(x^2\)`},
+	}
+	for _, tt := range tests {
+		if got := ChemFallback(tt.in); got != tt.want {
+			t.Errorf("chemFallback(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripHTMLScripts(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"H<sub>2</sub>O", "H₂O"},
+		{"Ca<sup>2+</sup> and x<sup>n</sup>", "Ca²⁺ and xⁿ"},
+		{"C<sub>6</sub>H<sub>12</sub>O<sub>6</sub>", "C₆H₁₂O₆"},
+		{"footnote<sup>[a]</sup>", "footnote[a]"},
+		{"open <sub>2", "open 2"},
+	}
+	for _, tt := range tests {
+		if got := stripHTML(tt.in); got != tt.want {
+			t.Errorf("stripHTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripHTMLMathML(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`Solve <math><msup><mi>x</mi><mn>2</mn></msup><mo>−</mo><mn>4</mn><mo>=</mo><mn>0</mn></math>.`, `Solve \(x^2-4=0\).`},
+		{`<math display="block"><mfrac><mrow><mi>a</mi><mo>+</mo><mi>b</mi></mrow><mn>2</mn></mfrac></math>`, `\[\frac{a+b}{2}\]`},
+		{`<math><msqrt><mi>x</mi></msqrt><mo>×</mo><mroot><mi>y</mi><mn>3</mn></mroot></math>`, `\(\sqrt{x}\times\sqrt[3]{y}\)`},
+		{`<math><mi>sin</mi><mi>θ</mi><mo>≤</mo><msub><mi>x</mi><mrow><mi>i</mi><mo>+</mo><mn>1</mn></mrow></msub></math>`, `\(\sin θ\le x_{i+1}\)`},
+		{`<math><munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover><mover><mi>v</mi><mo>→</mo></mover></math>`, `\(\sum_{i=1}^n\vec{v}\)`},
+		{`<math><mfenced><mi>a</mi><mi>b</mi></mfenced><mtext>if true</mtext></math>`, `\(\left( a, b \right)\text{if true}\)`},
+		{`<math><semantics><mrow><mi>E</mi></mrow><annotation encoding="application/x-tex">E = mc^2</annotation></semantics></math>`, `\(E = mc^2\)`},
+		{`<math alttext="x choose k"><mi>x</mi><mglyph src="c.png"/></math>`, `x choose k`},
+		{`<m:math><m:mi>x</m:mi><m:mo>&lt;</m:mo><m:mn>1</m:mn></m:math>`, `\(x<1\)`},
+		{`<p>no math here</p>`, `no math here`},
+	}
+	for _, tt := range tests {
+		if got := stripHTML(tt.in); got != tt.want {
+			t.Errorf("stripHTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got, want := sanitizeHTML(`<p><math><msup><mi>x</mi><mn>2</mn></msup></math></p>`, "", nil), `<p>\(x^2\)</p>`; got != want {
+		t.Errorf("sanitizeHTML kept the MathML: %q, want %q", got, want)
+	}
+}
+
+func TestContentID(t *testing.T) {
+	base := Question{Number: 1, ItemID: "101", Text: "Which gas do plants absorb?", Options: []Option{{Label: "Oxygen"}, {Label: "Carbon dioxide"}}}
+	id := contentID(base)
+	if !regexp.MustCompile(`^q-[0-9a-f]{12}$`).MatchString(id) {
+		t.Fatalf("contentID = %q, want q- and 12 hex digits", id)
+	}
+	tests := []struct {
+		name string
+		q    Question
+		same bool
+	}{
+		{"other number and item", Question{Number: 7, ItemID: "999", Text: base.Text, Options: base.Options}, true},
+		{"reshuffled choices", Question{Text: base.Text, Options: []Option{{Label: "Carbon dioxide"}, {Label: "Oxygen"}}}, true},
+		{"case and whitespace", Question{Text: "  which gas do\nplants  ABSORB? ", Options: []Option{{Label: "oxygen"}, {Label: "carbon  dioxide"}}}, true},
+		{"correctness ignored", Question{Text: base.Text, Options: []Option{{Label: "Oxygen"}, {Label: "Carbon dioxide", Correct: true}}}, true},
+		{"other stem", Question{Text: "Which gas do plants release?", Options: base.Options}, false},
+		{"other choice", Question{Text: base.Text, Options: []Option{{Label: "Oxygen"}, {Label: "Nitrogen"}}}, false},
+		{"choice dropped", Question{Text: base.Text, Options: base.Options[:1]}, false},
+	}
+	for _, tt := range tests {
+		if got := contentID(tt.q); (got == id) != tt.same {
+			t.Errorf("%s: contentID = %q, base %q, want same = %v", tt.name, got, id, tt.same)
+		}
+	}
+}
+
+func TestVerifyScore(t *testing.T) {
+	pts := func(v float64) *float64 { return &v }
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, Possible: 1, Earned: pts(1)},
+		{Number: 2, Possible: 2, Earned: pts(2.0 / 3)},
+		{Number: 3, Possible: 1, Ungraded: true, Earned: pts(0)},
+		{Number: 4, Possible: 1}, // essay not graded yet
+	}}
+	results := []ResultItem{{ItemID: "a", Score: 1}, {ItemID: "b", Score: 0.67}}
+	c := VerifyScore(doc, results, ReportedScore{Score: pts(1.67), Possible: pts(3)})
+	if c.Scored != 2 || RoundTo(c.Earned, 2) != 1.67 || c.Possible != 3 || len(c.Mismatches) != 0 {
+		t.Errorf("VerifyScore = %+v, want 1.67 / 3 from 2 questions, no mismatch", c)
+	}
+
+	doc.Questions[0].Earned = pts(1.5)
+	c = VerifyScore(doc, results, ReportedScore{Score: pts(2), Possible: pts(4)})
+	want := []string{
+		"question 1 scores 1.5 of 1 points possible",
+		"the results items add up to 1.67, the questions to 2.17; an item may not have been read",
+		"Canvas reports a score of 2, the questions add up to 2.17 (-0.17); a regrade, fudge points or a late penalty?",
+		"the quiz is worth 4 points, the scored questions 3; a question may be missing or unscored",
+	}
+	if !reflect.DeepEqual(c.Mismatches, want) {
+		t.Errorf("Mismatches =\n%q\nwant\n%q", c.Mismatches, want)
+	}
+}
+
+func TestScoresCSV(t *testing.T) {
+	third, one := 1.0/3, 1.0
+	tests := []struct {
+		name string
+		doc  QuizDoc
+		want string
+	}{
+		{"scored", QuizDoc{Questions: []Question{
+			{Number: 1, ItemID: "101", ContentID: "q-0a1b2c3d4e5f", Possible: 1, Earned: &one},
+			{Number: 2, ItemID: "102", Possible: 1, Earned: &third},
+			{Number: 3, ItemID: "103", Possible: 1, Earned: &third},
+			{Number: 4, ItemID: "104", Possible: 2},
+		}}, "item_id,question,points_possible,points_earned,question_id\n101,1,1,1,q-0a1b2c3d4e5f\n102,2,1,0.33,\n103,3,1,0.33,\n104,4,2,,\ntotal,,5,1.67,\n"},
+		{"no results", QuizDoc{Questions: []Question{{Number: 1, ItemID: "7", Possible: 1.5}}},
+			"item_id,question,points_possible,points_earned,question_id\n7,1,1.5,,\ntotal,,1.5,,\n"},
+	}
+	for _, tt := range tests {
+		got, err := ScoresCSV(tt.doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: scoresCSV =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLeftBlank(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{`{"a": {"result_score": 0, "user_responded": false}, "b": {"result_score": 1, "user_responded": false}}`, true},
+		{`{"a": {"result_score": 0, "user_responded": true}, "b": {"result_score": 1, "user_responded": false}}`, false},
+		{`{"blank1": {"correct_answer": "monitoring", "result_score": 0}}`, true},
+		{`{"blank1": {"correct_answer": "monitoring", "user_response": "tracing"}}`, false},
+		{`{"a": {"result_score": 1}}`, false},
+		{`""`, true},
+		{`[]`, true},
+		{`"choice-1"`, false},
+		{`null`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		res := ResultItem{Scored: ScoredData{ValueRaw: json.RawMessage(tt.value)}}
+		if got := leftBlank(res); got != tt.want {
+			t.Errorf("leftBlank(%s) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestKeyConfidence(t *testing.T) {
+	choice := Question{Answers: []string{"Mars"}}
+	blank := Question{OpenEntry: true, Blanks: []BlankAnswer{{ID: "b1", Label: "Blank 1", Answer: "monitoring"}}}
+	order := Question{Answers: []string{"A", "B"}, Order: []string{"A", "B"}}
+	tests := []struct {
+		name    string
+		q       Question
+		scoring string
+		value   string
+		want    string
+	}{
+		{"choice marked correct", choice, ``, `{"c1": {"correct": true, "result_score": 1}}`, KeyConfirmed},
+		{"choice scored only", choice, ``, `{"c1": {"result_score": 1, "user_responded": true}}`, KeyInferred},
+		{"one choice scored only", choice, ``, `{"c1": {"correct": true}, "c2": {"result_score": 1}}`, KeyInferred},
+		{"choice array rows", choice, ``, `[{"id": 1, "result_score": 1, "value": "c1"}]`, KeyInferred},
+		{"no choice key", Question{Options: []Option{{Label: "Mars"}}}, ``, `{"c1": {"result_score": 0}}`, KeyUnknown},
+		{"blank correct_answer", blank, ``, `{"b1": {"correct_answer": "monitoring", "user_response": "tracing"}}`, KeyConfirmed},
+		{"blank authored", blank, `{"value": [{"id": "b1", "scoring_algorithm": "TextEquivalence", "scoring_data": {"value": "monitoring"}}]}`, `{"b1": {"user_response": "monitoring"}}`, KeyConfirmed},
+		{"blank scored response", blank, ``, `{"b1": {"user_response": "monitoring", "result_score": 1}}`, KeyInferred},
+		{"blank own response", blank, ``, `{"b1": {"user_response": "monitoring", "result_score": 0}}`, KeyUnknown},
+		{"blank without answer", Question{Blanks: []BlankAnswer{{ID: "b1"}}}, ``, `{}`, KeyUnknown},
+		{"ordering authored", order, `{"value": ["a", "b"]}`, `["b", "a"]`, KeyConfirmed},
+		{"ordering rows", order, ``, `[{"user_responded": "b", "value": "a"}]`, KeyConfirmed},
+		{"essay", Question{Essay: true}, ``, `{}`, ""},
+		{"survey", Question{Ungraded: true, Answers: []string{"Yes"}}, ``, `{}`, ""},
+	}
+	for _, tt := range tests {
+		res := ResultItem{Scored: ScoredData{ValueRaw: json.RawMessage(tt.value)}}
+		if got := keyConfidence(tt.q, json.RawMessage(tt.scoring), res); got != tt.want {
+			t.Errorf("%s: keyConfidence = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	doc := QuizDoc{Questions: []Question{
+		{Number: 1, KeyConfidence: KeyConfirmed},
+		{Number: 2, KeyConfidence: KeyInferred},
+		{Number: 3, Essay: true},
+		{Number: 4, KeyConfidence: KeyUnknown},
+	}}
+	doc.AnnotateKeyConfidence()
+	if want := (DocDetail{Label: "Keys to double-check", Value: "questions 2, 4"}); len(doc.Details) != 1 || doc.Details[0] != want {
+		t.Errorf("details = %v, want %v", doc.Details, want)
+	}
+	md := RenderMarkdown(doc)
+	if !strings.Contains(md, "## 2) \n- Key: inferred from score\n") || strings.Contains(md, "## 3) \n- Key") {
+		t.Errorf("markdown lacks the key lines:\n%s", md)
+	}
+}
+
+func TestPartialCredit(t *testing.T) {
+	opts := func(states ...string) []Option {
+		var out []Option
+		for i, s := range states {
+			out = append(out, Option{Label: string(rune('A' + i)), Correct: strings.Contains(s, "c"), Selected: strings.Contains(s, "s")})
+		}
+		return out
+	}
+	tests := []struct {
+		name      string
+		options   []Option
+		earned    float64
+		algorithm string
+		response  string
+		score     string
+	}{
+		{"two right, one wrong", opts("c", "cs", "cs", "s"), 1.0 / 3, "", "B (+0.33); C (+0.33); D (-0.33)", "0.33 / 1 (partial credit)"},
+		{"negative total floors at zero", opts("c", "cs", "s", "s"), 0, "PartialScore", "B (+0.5); C (-0.5); D (-0.5)", "0 / 1 (partial credit)"},
+		{"full marks under partial scoring", opts("cs", "cs", ""), 1, "PartialScore", "A (+0.5); B (+0.5)", "1 / 1 (partial credit)"},
+		{"full marks, rule unknown", opts("cs", "cs", ""), 1, "", "A; B", "1 / 1"},
+		{"all or nothing", opts("c", "cs", "s"), 0, "AllOrNothing", "B; C", "0 / 1"},
+		{"score the rule can't explain", opts("c", "cs", "cs", "s"), 0.5, "", "B; C; D", "0.5 / 1"},
+	}
+	for _, tt := range tests {
+		earned := tt.earned
+		q := Question{Multi: true, HasResult: true, Possible: 1, Earned: &earned, Options: tt.options}
+		for _, o := range q.Options {
+			if o.Selected {
+				q.Responses = append(q.Responses, o.Label)
+			}
+		}
+		partialCredit(&q, tt.algorithm)
+		c, _ := q.CheckResponse()
+		if c.Response != tt.response || c.Score != tt.score {
+			t.Errorf("%s: response %q, score %q; want %q, %q", tt.name, c.Response, c.Score, tt.response, tt.score)
+		}
+	}
+}
+
+func TestNormalizeChoicesEncodings(t *testing.T) {
+	tests := []struct {
+		name               string
+		idat               InteractionData
+		userRespType, slug string
+		want               string
+		wantErr            bool
+	}{
+		{"map keyed by id", InteractionData{RawChoices: json.RawMessage(`{"k1": {"item_body": "One", "position": 1}, "k2": {"id": "two", "item_body": "Two", "position": 2}}`)}, "Uuid", "choice", "k1=One two=Two", false},
+		{"array", InteractionData{RawChoices: json.RawMessage(`[{"id": "a", "item_body": "A", "position": 1}]`)}, "Uuid", "choice", "a=A", false},
+		{"true/false by slug", InteractionData{}, "", "true-false", "true=True false=False", false},
+		{"true/false by response type", InteractionData{TrueChoice: "Yes", FalseChoice: "No"}, "boolean", "", "true=Yes false=No", false},
+		{"already set", InteractionData{Choices: []QuizChoice{{ID: "x", ItemBody: "X", Position: 1}}, RawChoices: json.RawMessage(`[{"id": "y"}]`)}, "Uuid", "choice", "x=X", false},
+		{"numeric ids", InteractionData{RawChoices: json.RawMessage(`[{"id": 7, "item_body": "A", "position": 1}, {"id": "8", "item_body": "B", "position": 2}]`)}, "Uuid", "choice", "7=A 8=B", false},
+		{"long numeric id in a map", InteractionData{RawChoices: json.RawMessage(`{"k": {"id": 12345678901234567890, "item_body": "A"}}`)}, "Uuid", "choice", "12345678901234567890=A", false},
+		{"empty map", InteractionData{RawChoices: json.RawMessage(`{}`)}, "Uuid", "choice", "", false},
+		{"unreadable", InteractionData{RawChoices: json.RawMessage(`"oops"`)}, "Uuid", "choice", "", true},
+		{"id of the wrong kind", InteractionData{RawChoices: json.RawMessage(`[{"id": {"x": 1}, "item_body": "A"}]`)}, "Uuid", "choice", "", true},
+		{"missing", InteractionData{}, "Uuid", "choice", "", false},
+	}
+	for _, tt := range tests {
+		if err := tt.idat.NormalizeChoices(tt.userRespType, tt.slug); (err != nil) != tt.wantErr {
+			t.Errorf("%s: NormalizeChoices error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		var got []string
+		for _, c := range tt.idat.Choices {
+			got = append(got, c.ID+"="+c.ItemBody)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s: choices %q, want %q", tt.name, strings.Join(got, " "), tt.want)
+		}
+	}
+}
+
+func TestDeriveCorrectChoiceIDs(t *testing.T) {
+	tests := []struct {
+		name, value string
+		want        []string
+	}{
+		{"map result_score", `{"a": {"result_score": 1}, "b": {"result_score": 0}}`, []string{"a"}},
+		{"map correct flag", `{"a": {"correct": false}, "b": {"correct": true}}`, []string{"b"}},
+		{"map user_responded only", `{"a": {"user_responded": true}}`, nil},
+		{"array rows", `[{"id": "1", "result_score": 1, "value": "p"}, {"id": "2", "result_score": 0, "value": "q"}]`, []string{"p"}},
+		{"array rows with numeric ids", `[{"id": 1, "result_score": 1, "value": 7}, {"id": 2, "result_score": 1.0}, {"id": 3, "result_score": 0.5}]`, []string{"2", "7"}},
+		{"empty id", `{"": {"result_score": 1}}`, nil},
+		{"bare ids", `["a", "b"]`, nil},
+		{"string", `"a"`, nil},
+		{"null", `null`, nil},
+		{"missing", ``, nil},
+	}
+	for _, tt := range tests {
+		ids := deriveCorrectChoiceIDs(ResultItem{Scored: ScoredData{ValueRaw: json.RawMessage(tt.value)}})
+		var got []string
+		for id := range ids {
+			got = append(got, id)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: deriveCorrectChoiceIDs = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeChoicesOrder(t *testing.T) {
+	array := `[{"id": "a", "item_body": "A", "position": 1}, {"id": "b", "item_body": "B", "position": 2}, {"id": "c", "item_body": "C", "position": 3}]`
+	mapped := `{"b": {"item_body": "B", "position": 2}, "a": {"item_body": "A", "position": 1}, "c": {"item_body": "C", "position": 3}}`
+	tests := []struct {
+		name     string
+		choices  string
+		shuffled []string
+		want     string
+	}{
+		{"array authored", array, nil, "abc"},
+		{"array shuffled", array, []string{"c", "a", "b"}, "cab"},
+		{"map authored", mapped, nil, "abc"},
+		{"map shuffled", mapped, []string{"b", "c", "a"}, "bca"},
+		{"map without positions", `{"y": {"item_body": "Y"}, "x": {"item_body": "X"}}`, nil, "xy"},
+		{"partial shuffle", array, []string{"c"}, "cab"},
+	}
+	for _, tt := range tests {
+		idat := InteractionData{ShuffledOrder: tt.shuffled}
+		if strings.HasPrefix(tt.choices, "[") {
+			if err := json.Unmarshal([]byte(tt.choices), &idat.Choices); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			idat.RawChoices = json.RawMessage(tt.choices)
+		}
+		idat.NormalizeChoices("", "choice")
+		sort.SliceStable(idat.Choices, func(i, j int) bool { return idat.Choices[i].Position < idat.Choices[j].Position })
+		var got string
+		for _, c := range idat.Choices {
+			got += c.ID
+		}
+		if got != tt.want {
+			t.Errorf("%s: choice order %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func FuzzNormalizeChoices(f *testing.F) {
+	f.Add(`{"k1": {"item_body": "One", "position": 2}, "k2": {"id": "two", "item_body": "Two", "position": 1}}`, "Uuid", "choice", "")
+	f.Add(`[{"id": "a", "item_body": "A", "position": 1}, {"id": "b", "item_body": "B", "position": 2}]`, "Uuid", "choice", "b,a")
+	f.Add(`[{"id": 17, "item_body": "A", "position": 1}]`, "Uuid", "choice", "17")
+	f.Add(`{"1": {"id": 1}, "2": {"id": "2"}}`, "", "choice", "2,1,9")
+	f.Add(``, "Boolean", "true-false", "false")
+	f.Add(`"oops"`, "Uuid", "choice", "")
+	f.Fuzz(func(t *testing.T, raw, userRespType, slug, shuffled string) {
+		idat := InteractionData{RawChoices: json.RawMessage(raw)}
+		if shuffled != "" {
+			idat.ShuffledOrder = strings.Split(shuffled, ",")
+		}
+		err := idat.NormalizeChoices(userRespType, slug)
+		if err != nil && len(idat.Choices) > 0 {
+			t.Errorf("NormalizeChoices(%q) = %v with %d choices; an error leaves none", raw, err, len(idat.Choices))
+		}
+		if err == nil && json.Valid([]byte(raw)) && strings.HasPrefix(strings.TrimSpace(raw), "{") {
+			// Map entries are numbered 1..n, whatever positions they were authored with.
+			for i, c := range idat.Choices {
+				if c.Position != i+1 {
+					t.Errorf("NormalizeChoices(%q): choice %d has position %d", raw, i, c.Position)
+				}
+			}
+		}
+	})
+}
+
+func FuzzDeriveCorrectChoiceIDs(f *testing.F) {
+	f.Add(`{"a": {"result_score": 1}, "b": {"result_score": 0}}`)
+	f.Add(`{"a": {"correct": true, "user_responded": true}}`)
+	f.Add(`[{"id": "1", "result_score": 1, "value": "p"}, {"id": 2, "result_score": 1, "value": 7}]`)
+	f.Add(`[{"id": 3, "result_score": 1}]`)
+	f.Add(`["a", 2]`)
+	f.Add(`"a"`)
+	f.Add(`null`)
+	f.Fuzz(func(t *testing.T, value string) {
+		res := ResultItem{Scored: ScoredData{ValueRaw: json.RawMessage(value)}}
+		ids := deriveCorrectChoiceIDs(res)
+		if ids == nil {
+			t.Fatalf("deriveCorrectChoiceIDs(%q) = nil, want an empty set", value)
+		}
+		for id := range ids {
+			if id == "" {
+				t.Errorf("deriveCorrectChoiceIDs(%q) has an empty id", value)
+			}
+		}
+		selected, _ := deriveSelectedChoiceIDs(res)
+		for id := range selected {
+			if id == "" {
+				t.Errorf("deriveSelectedChoiceIDs(%q) has an empty id", value)
+			}
+		}
+		leftBlank(res)
+		valueShape(res.Scored.ValueRaw)
+	})
+}
+
+func FuzzStripHTML(f *testing.F) {
+	f.Add(`<p>H<sub>2</sub>O &amp; <b>CO<sup>2</sup></b></p>`)
+	f.Add(`<p dir="rtl">שלום</p><p dir="ltr">x`)
+	f.Add(`<math><mfrac><mi>a</mi><mn>2</mn></mfrac></math>`)
+	f.Add(`<img class="equation_image" data-equation-content="x^2">`)
+	f.Add(`a < b <c <d e="<">`)
+	f.Add(`<!-- note --><sup>unclosed`)
+	f.Fuzz(func(t *testing.T, s string) {
+		out := stripHTML(s)
+		if utf8.ValidString(s) && !utf8.ValidString(out) {
+			t.Errorf("stripHTML(%q) = %q, not valid UTF-8", s, out)
+		}
+		if out != strings.Join(strings.Fields(out), " ") {
+			t.Errorf("stripHTML(%q) = %q, whitespace not collapsed", s, out)
+		}
+		stripHTMLKeepEdges(s)
+		htmlParagraphs(s)
+	})
+}
+
+func TestAppendDebugIDs(t *testing.T) {
+	doc := QuizDoc{Questions: []Question{
+		{ItemID: "66208", Slug: "choice", Text: "Soak testing is used to:", Options: []Option{{ID: "c1", Label: "Find leaks"}, {Label: "True"}}},
+		{ItemID: "101", Text: "Fill [Blank 1]", Blanks: []BlankAnswer{{ID: "b1", Label: "Blank 1"}}},
+	}}
+	doc.AppendDebugIDs()
+	got := []string{doc.Questions[0].Text, doc.Questions[0].Options[0].Label, doc.Questions[0].Options[1].Label, doc.Questions[1].Text, doc.Questions[1].Blanks[0].Label}
+	want := []string{"Soak testing is used to: [item 66208, choice]", "Find leaks [choice c1]", "True", "Fill [Blank 1] [item 101]", "Blank 1 [blank b1]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("appendDebugIDs:\n got %q\nwant %q", got, want)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden from the current output")
+
+// TestGolden runs each quiz in testdata/golden and its _result.json through Parse and
+// Render and compares the Markdown with the committed .md file, and the JSON, which carries
+// the content IDs, with the committed .out.json file. After a deliberate change to the
+// output, rerun with -update and review the diff of the golden files.
+func TestGolden(t *testing.T) {
+	quizzes, err := filepath.Glob(filepath.Join("testdata", "golden", "*_result.json"))
+	if err != nil || len(quizzes) == 0 {
+		t.Fatalf("no fixtures in testdata/golden: %v", err)
+	}
+	for _, resultPath := range quizzes {
+		name := strings.TrimSuffix(filepath.Base(resultPath), "_result.json")
+		t.Run(name, func(t *testing.T) {
+			quizJSON, err := os.ReadFile(filepath.Join("testdata", "golden", name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resultsJSON, err := os.ReadFile(resultPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := Parse(quizJSON, resultsJSON, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			md, _, err := Render(doc, RenderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			js, err := RenderJSON(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, out := range []struct{ format, ext, got string }{{"Markdown", ".md", md}, {"JSON", ".out.json", js}} {
+				golden := filepath.Join("testdata", "golden", name+out.ext)
+				if *update {
+					if err := os.WriteFile(golden, []byte(out.got), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v (run go test -update to create it)", err)
+				}
+				if out.got != string(want) {
+					t.Errorf("%s differs from %s (rerun with -update if the change is intended):\ngot:\n%s\nwant:\n%s", out.format, golden, out.got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTrace(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[
+		{"position": 1, "item": {"id": "a", "item_body": "<p>Map</p>", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": {"c1": {"item_body": "x", "position": 1}}}}},
+		{"position": 2, "item": {"id": "b", "item_body": "<p>Array</p>", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": [{"id": "c1", "item_body": "x"}]}}},
+		{"position": 3, "item": {"id": "c", "item_body": "<p>Say {{b1}}</p>", "interaction_type": {"slug": "rich-fill-blank"},
+			"interaction_data": {"blanks": [{"id": "b1", "answer_type": "openEntry"}]},
+			"scoring_data": {"value": [{"id": "b1", "scoring_data": {"value": "hi", "blank_text": "hi"}}]}}},
+		{"position": 4, "item": {"id": "d", "item_body": "<p>Order</p>", "interaction_type": {"slug": "ordering"},
+			"interaction_data": {"choices": {"c1": {"item_body": "x"}}}, "scoring_data": {"value": ["c1"]}}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []ResultItem
+	err = json.Unmarshal([]byte(`[
+		{"item_id": "a", "score": 1, "scored_data": {"value": {"c1": {"result_score": 1, "user_responded": true}}}},
+		{"item_id": "b", "score": 1, "scored_data": {"value": [{"id": "c1", "result_score": 1, "value": "c1"}]}},
+		{"item_id": "c", "score": 1, "scored_data": {"value": {"b1": {"user_response": "hi"}}}},
+		{"item_id": "d", "score": 1, "scored_data": {"value": ["c1"]}}
+	]`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	defer func() { Logger = nil }()
+	BuildQuizDoc(quiz, results, "T")
+
+	var got []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec struct {
+			Msg      string
+			Question int
+			Choices  string
+			From     string
+		}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Msg == "decoding item" {
+			got = append(got, fmt.Sprintf("%d choices %s", rec.Question, rec.Choices))
+		} else {
+			got = append(got, fmt.Sprintf("%d %s", rec.Question, rec.From))
+		}
+	}
+	want := []string{
+		"1 choices map",
+		"1 scored_data.value map of result_score/correct",
+		"2 choices array",
+		"2 scored_data.value array of result_score/value",
+		"3 choices none",
+		"3 scoring_data",
+		"4 choices map",
+		"4 scoring_data",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("trace =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	quiz, err := DecodeQuizItems([]byte(`[
+		{"position": 1, "points_possible": 1, "item": {"id": "a", "item_body": "<p>Draw it</p>", "interaction_type": {"slug": "drawing"}}},
+		{"position": 2, "points_possible": 1, "item": {"id": "b", "item_body": "<p>Pick</p>", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": [{"id": "c1", "item_body": "x"}]}}},
+		{"position": 3, "points_possible": 1, "item": {"id": "c", "item_body": "<p>Missing</p>", "interaction_type": {"slug": "choice"}}},
+		{"position": 4, "points_possible": 1, "item": {"id": "d", "item_body": "<p>Fine</p>", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": [{"id": "c1", "item_body": "x"}]}}},
+		{"position": 5, "points_possible": 1, "item": {"id": "e", "item_body": "<p>Odd</p>", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": "c1,c2"}, "answer_feedback": [{"id": true, "feedback": "no"}]}},
+		{"position": 6, "points_possible": 1, "item": {"id": "f", "item_body": "<p>Picked</p>", "interaction_type": {"slug": "choice"},
+			"interaction_data": {"choices": [{"id": "c1", "item_body": "x"}]}}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []ResultItem
+	err = json.Unmarshal([]byte(`[
+		{"item_id": "a", "score": 0, "points_possible": 1, "scored_data": {"value": ["zz"]}},
+		{"item_id": "b", "score": 1, "points_possible": 1, "scored_data": {"value": {"weird": {"x": 1}, "other": {}}}},
+		{"item_id": "d", "score": 1, "points_possible": 1, "scored_data": {"value": {"c1": {"result_score": 1, "user_responded": true}}}},
+		{"item_id": "e", "score": 1, "points_possible": 1, "scored_data": {"value": {"c1": {"result_score": 1, "user_responded": true}}}},
+		{"item_id": "f", "score": 0, "points_possible": 1, "scored_data": {"value": 7}, "comments": {"text": "hm"}}
+	]`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range Diagnose(BuildQuizDoc(quiz, results, "T")) {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`question 1 (item a): unrecognized interaction type "drawing", read as a choice question`,
+		`question 1 (item a): no answer key: scored_data.value is a list of 1 string, which matches none of its choices or blanks`,
+		`question 2 (item b): no answer key: scored_data.value is an object of 2 entries, which matches none of its choices or blanks`,
+		`question 3 (item c): no result for this item; is its item_id in the results?`,
+		`question 5 (item e): unreadable interaction_data.choices: choices are a string, not an object keyed by id or a list`,
+		`question 5 (item e): unreadable answer_feedback: answer feedback id is true/false, not a string or a number`,
+		`question 5 (item e): no answer key: scored_data.value is an object of 1 entry, which matches none of its choices or blanks`,
+		`question 6 (item f): unreadable the result's comments: comments is an object of 1 entry, not a string or a list of strings`,
+		`question 6 (item f): unreadable scored_data.value: selection is a number, not a string or a list of strings`,
+		`question 6 (item f): no answer key: scored_data.value is a number, which matches none of its choices or blanks`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diagnose =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A practice sheet has no results to miss; only the unknown type and the unreadable item are reported.
+	if got := Diagnose(BuildPracticeDoc(quiz, "T")); len(got) != 3 || got[0].Number != 1 || got[1].Number != 5 || got[2].Number != 5 {
+		t.Errorf("practice Diagnose = %v, want question 1's type and question 5's choices and feedback", got)
+	}
+}
+
+func TestDecodeQuizPages(t *testing.T) {
+	pages := [][]byte{
+		[]byte(`{"items": [
+			{"id": "10", "position": 1, "entry_type": "Stimulus", "item": {"title": "Passage", "body": "<p>Read me.</p>"}},
+			{"id": "11", "position": 2, "entry_type": "Item", "item": {"id": "a", "item_body": "First"}}]}`),
+		[]byte(`[{"id": "11", "position": 2, "entry_type": "Item", "item": {"id": "a", "item_body": "First"}},
+			{"id": "12", "position": 3, "entry_type": "Item", "stimulus_quiz_entry_id": "10", "item": {"id": "b", "item_body": "Second"}}]`),
+		[]byte(`[]`),
+	}
+	quiz, err := DecodeQuizPages(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, q := range quiz {
+		got = append(got, q.Item.ID)
+	}
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("items = %v, want [a b]: the overlapping item once, no stimulus entry", got)
+	}
+	if len(quiz) == 2 && (quiz[1].Stimulus == nil || quiz[1].Stimulus.Title != "Passage") {
+		t.Errorf("item b lacks the stimulus from page 1: %+v", quiz[1].Stimulus)
+	}
+
+	_, err = DecodeQuizPages([][]byte{pages[0], []byte(`[{"item_id": "a", "scored_data": {}}]`)})
+	if err == nil || !strings.HasPrefix(err.Error(), "page 2: ") || !strings.HasSuffix(err.Error(), "it holds item results") {
+		t.Errorf("results as page 2: error %v", err)
+	}
+}
+
+func TestDescribePayload(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`[{"item": {"id": "1"}}]`, "quiz items"},
+		{`[{"id": 1, "question_type": "essay_question", "question_text": "Why?"}]`, "a Classic Quizzes export"},
+		{`{"session": {"results": [{"item_id": "1", "scored_data": {}}]}}`, "item results"},
+		{`{"errors": [{"message": "user not authorised to perform that action"}]}`, "a Canvas API error: user not authorised to perform that action"},
+		{`{"errors": {"quiz_id": "invalid"}}`, "a Canvas API error: no message given"},
+		{`{"quiz_submissions": [{"id": 7, "score": 3}]}`, "a quiz_submissions list, which has each attempt's score but not its item results"},
+		{`{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7}`, "an object with keys a, b, c, d, e and 2 more"},
+		{`[{"id": 1, "title": "Week 1"}, {"id": 2}]`, "a list of 2 objects with keys id, title"},
+		{`[]`, "an empty list"},
+		{`"results"`, "a string"},
+		{`{oops`, "no valid JSON"},
+	}
+	for _, tt := range tests {
+		if got := DescribePayload([]byte(tt.in)); got != tt.want {
+			t.Errorf("DescribePayload(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// Each decoder refuses the other's payload, saying what it is, instead of
+	// decoding it into empty items.
+	if _, err := DecodeQuizItems([]byte(`[{"item_id": "1", "scored_data": {"value": "a"}}]`)); err == nil || !strings.HasSuffix(err.Error(), "it holds item results") {
+		t.Errorf("DecodeQuizItems(results) error = %v", err)
+	}
+	if _, err := DecodeResults([]byte(`[{"position": 1, "item": {"id": "1"}}]`)); err == nil || !strings.HasSuffix(err.Error(), "it holds quiz items") {
+		t.Errorf("DecodeResults(quiz) error = %v", err)
+	}
+	if quiz, err := DecodeQuizItems([]byte(`[]`)); err != nil || len(quiz) != 0 {
+		t.Errorf("DecodeQuizItems([]) = %v, %v; want no items and no error", quiz, err)
+	}
+}
+
+func TestDecodeQuizItems(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{`[{"item": {"id": "1"}}, {"item": {"id": "2"}}]`, 2},
+		{`{"quiz": {"title": "Wk 1"}, "items": [{"item": {"id": "1"}}]}`, 1},
+		{`{"entries": []}`, -1},
+	}
+	for _, tt := range tests {
+		quiz, err := DecodeQuizItems([]byte(tt.in))
+		if tt.want < 0 {
+			if err == nil {
+				t.Errorf("decodeQuizItems(%s) succeeded", tt.in)
+			}
+			continue
+		}
+		if err != nil || len(quiz) != tt.want {
+			t.Errorf("decodeQuizItems(%s) = %d items, %v; want %d", tt.in, len(quiz), err, tt.want)
+		}
+	}
+}
+
+func TestParseNumericIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		quiz    string
+		results string
+		want    string // "item id: answers", or the start of the error
+	}{
+		{"item and result ids",
+			`[{"position": 1, "item": {"id": 101, "item_body": "Pick", "interaction_type": {"slug": "choice"},
+				"interaction_data": {"choices": [{"id": 1, "item_body": "A"}, {"id": 2, "item_body": "B"}]}}}]`,
+			`[{"item_id": 101, "score": 1, "points_possible": 1, "scored_data": {"value": {"2": {"result_score": 1, "user_responded": true}}}}]`,
+			"101: B"},
+		{"blank id",
+			`[{"position": 1, "item": {"id": "102", "item_body": "<p>It is <span id=\"blank_5\"></span>.</p>", "interaction_type": {"slug": "rich-fill-blank"},
+				"interaction_data": {"blanks": [{"id": 5, "answer_type": "openEntry"}]}}}]`,
+			`[{"item_id": "102", "score": 1, "points_possible": 1, "scored_data": {"value": {"5": {"correct_answer": "gamma"}}}}]`,
+			"102: Blank 1=gamma"},
+		{"entry and stimulus ids",
+			`[{"id": 10, "position": 1, "entry_type": "Stimulus", "item": {"title": "Passage", "body": "<p>Read.</p>"}},
+			  {"id": 11, "position": 2, "entry_type": "Item", "stimulus_quiz_entry_id": 10, "item": {"id": 103, "item_body": "Why?", "interaction_type": {"slug": "essay"}}}]`,
+			`[{"item_id": 103, "score": 1, "points_possible": 1}]`,
+			"103: (stimulus)"},
+		{"item id of another kind",
+			`[{"position": 1, "item": {"id": {"n": 1}, "item_body": "Pick"}}]`, `[]`,
+			"item id is an object"},
+		{"result item_id of another kind",
+			`[{"position": 1, "item": {"id": "1", "item_body": "Pick"}}]`, `[{"item_id": true}]`,
+			"result item_id: id is true/false"},
+		{"blank id of another kind",
+			`[{"position": 1, "item": {"id": "1", "item_body": "Fill", "interaction_data": {"blanks": [{"id": [5]}]}}}]`, `[]`,
+			"blank id is a list"},
+	}
+	for _, tt := range tests {
+		doc, err := Parse([]byte(tt.quiz), []byte(tt.results), "T")
+		if err != nil {
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: Parse error = %v, want %q", tt.name, err, tt.want)
+			}
+			continue
+		}
+		if len(doc.Questions) != 1 {
+			t.Errorf("%s: %d questions, want 1", tt.name, len(doc.Questions))
+			continue
+		}
+		q := doc.Questions[0]
+		parts := q.Answers
+		for _, b := range q.Blanks {
+			parts = append(parts, b.Label+"="+b.Answer)
+		}
+		if q.Stimulus != nil {
+			parts = append(parts, "(stimulus)")
+		}
+		if got := q.ItemID + ": " + strings.Join(parts, ", "); got != tt.want {
+			t.Errorf("%s: Parse = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadQuizItems(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int // -1: not streamable, so the caller falls back to DecodeQuizItems
+	}{
+		{`[{"item": {"id": "1"}}, {"item": {"id": "2"}}]`, 2},
+		{`[]`, 0},
+		{`{"quiz": {"title": "Wk 1", "tags": ["a"]}, "empty": [], "n": 3, "items": [{"item": {"id": "1"}}], "after": {"x": [1]}}`, 1},
+		{`{"entries": []}`, -1},
+		{`{"data": {"items": [{"item": {"id": "1"}}]}}`, -1},                       // nested deeper
+		{`[{"question_type": "multiple_choice_question"}]`, -1},                    // Classic export
+		{`[{"item": {"id": "1"}}][{"item": {"id": "2"}}]`, -1},                     // two documents
+		{`[{"item": {"id": "1"}},]`, -1},                                           // trailing comma
+		{"\xef\xbb\xbf" + `[{"item": {"id": "1"}}]`, -1},                           // byte order mark
+		{`{"items": [{"item": {"id": "1"}}], "more": [{"item": {"id": "2"}}]}`, 1}, // the first array wins
+	}
+	for _, tt := range tests {
+		quiz, err := ReadQuizItems(strings.NewReader(tt.in))
+		if tt.want < 0 {
+			if err == nil {
+				t.Errorf("ReadQuizItems(%s) succeeded with %d items", tt.in, len(quiz))
+			}
+			continue
+		}
+		if err != nil || len(quiz) != tt.want {
+			t.Errorf("ReadQuizItems(%s) = %d items, %v; want %d", tt.in, len(quiz), err, tt.want)
+			continue
+		}
+		if decoded, err := DecodeQuizItems([]byte(tt.in)); len(quiz) > 0 && (err != nil || !reflect.DeepEqual(quiz, decoded)) {
+			t.Errorf("ReadQuizItems(%s) = %+v, DecodeQuizItems gives %+v", tt.in, quiz, decoded)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join("..", "sample", "wk12_result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := ReadResults(bytes.NewReader(b))
+	decoded, _ := DecodeResults(b)
+	if err != nil || !reflect.DeepEqual(streamed, decoded) {
+		t.Errorf("ReadResults(sample) = %d results, %v; want the %d DecodeResults gives", len(streamed), err, len(decoded))
+	}
+}
+
+func TestStreamQuizItems(t *testing.T) {
+	in := `{"items": [{"entry_type": "Stimulus", "id": "s1", "item": {"id": "", "title": "Passage"}},
+		{"stimulus_quiz_entry_id": "s1", "item": {"id": "1"}}, {"item": {"id": "2"}}, {"item": {"id": "3"}}]}`
+	var ids []string
+	stop := errors.New("stop")
+	err := StreamQuizItems(strings.NewReader(in), func(q QuizItem) error {
+		ids = append(ids, q.EntryType+q.Item.ID)
+		if q.Item.ID == "2" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("StreamQuizItems error = %v, want the callback's", err)
+	}
+	// The stimulus entry is passed on as it is; only ReadQuizItems attaches it.
+	if want := []string{"Stimulus", "1", "2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("StreamQuizItems passed %q, want %q", ids, want)
+	}
+
+	var results []string
+	err = StreamResults(strings.NewReader(`[{"item_id": "1", "scored_data": {}}, {"item_id": "2", "scored_data": {}}]`), func(res ResultItem) error {
+		results = append(results, res.ItemID)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(results, []string{"1", "2"}) {
+		t.Errorf("StreamResults passed %q, %v", results, err)
+	}
+}
+
+// largeExport writes a quiz export of n multiple-choice items to a temporary file.
+func largeExport(b *testing.B, n int) string {
+	var sb strings.Builder
+	sb.WriteString(`{"quiz": {"title": "Institution-wide bank"}, "items": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"position": %d, "points_possible": 1, "item": {"id": "i%d", "item_body": "<p>Question %d %s</p>",
+			"interaction_type": {"slug": "choice"}, "user_response_type": "Uuid",
+			"interaction_data": {"choices": [{"id": "a", "item_body": "<p>Alpha</p>"}, {"id": "b", "item_body": "<p>Beta</p>"}]}}}`,
+			i+1, i, i, strings.Repeat("lorem ipsum ", 40))
+	}
+	sb.WriteString("]}")
+	path := filepath.Join(b.TempDir(), "export.json")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// The three benchmarks read the same 5,000-item export: all at once with DecodeQuizItems, as
+// a stream collected by ReadQuizItems, and as a stream handled item by item with
+// StreamQuizItems. Compare their B/op with -benchmem.
+func BenchmarkDecodeQuizItems(b *testing.B) {
+	path := largeExport(b, 5000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := DecodeQuizItems(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadQuizItems(b *testing.B) {
+	path := largeExport(b, 5000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = ReadQuizItems(f)
+		f.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamQuizItems(b *testing.B) {
+	path := largeExport(b, 5000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		n := 0
+		err = StreamQuizItems(f, func(QuizItem) error {
+			n++
+			return nil
+		})
+		f.Close()
+		if err != nil || n != 5000 {
+			b.Fatalf("streamed %d items: %v", n, err)
+		}
+	}
+}